@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/core"
 	"github.com/evilsocket/opensnitch/daemon/dns"
@@ -30,6 +31,33 @@ type Connection struct {
 	DstHost  string
 	Entry    *netstat.Entry
 	Process  *procmon.Process
+	// SNI is the server name of the TLS connections, if the packet
+	// intercepted has the ClientHello.
+	SNI string
+	// HTTPHost is the host of the plain HTTP connections, if the packet
+	// intercepted has the request.
+	HTTPHost string
+	// DstHostChain are the names the destination has been resolved through,
+	// from the name of the IP up to DstHost, if it's a CNAME.
+	DstHostChain []string
+	// DstHostResolver is the IP of the DNS server that resolved DstHost, if
+	// known, and UntrustedResolver is true if it's not a trusted one.
+	DstHostResolver   string
+	UntrustedResolver bool
+	// Tags of the non-terminating rules that matched the connection.
+	Tags []string
+	// Rules are the names of the non-terminating rules that matched the
+	// connection, before the rule that sets the verdict.
+	Rules []string
+	// NetNS is the ID of the network namespace of the process, 0 if unknown.
+	NetNS uint64
+	// Tampered is true if the binary of the process has been deleted or
+	// replaced since it was executed.
+	Tampered bool
+	// LookupTime is the time spent finding the process of the connection,
+	// and RulesTime evaluating the rules.
+	LookupTime time.Duration
+	RulesTime  time.Duration
 
 	Pkt *netfilter.Packet
 }
@@ -71,6 +99,14 @@ func newConnectionImpl(nfp *netfilter.Packet, c *Connection, protoType string) (
 	if c.parseDirection(protoType) == false {
 		return nil, nil
 	}
+	start := time.Now()
+	defer func() {
+		if cr != nil && cr.Process != nil {
+			cr.NetNS = procmon.GetNetNS(cr.Process.ID)
+			cr.Tampered = procmon.IsTampered(cr.Process.ID, cr.Process.Path)
+			cr.LookupTime = time.Since(start)
+		}
+	}()
 	log.Debug("new connection %s => %d:%v -> %v (%s):%d uid: %d, mark: %x", c.Protocol, c.SrcPort, c.SrcIP, c.DstIP, c.DstHost, c.DstPort, nfp.UID, nfp.Mark)
 
 	c.Entry = &netstat.Entry{
@@ -91,6 +127,7 @@ func newConnectionImpl(nfp *netfilter.Packet, c *Connection, protoType string) (
 		if swap {
 			c.swapFields()
 		}
+		procmon.RecordLookup(c.Process != nil, err)
 
 		if c.Process != nil {
 			c.Entry.UserId = c.Process.UID
@@ -105,6 +142,7 @@ func newConnectionImpl(nfp *netfilter.Packet, c *Connection, protoType string) (
 			audit.Lock.RLock()
 			c.Process = procmon.NewProcess(pid, aevent.ProcName)
 			c.Process.Path = aevent.ProcPath
+			c.Process.PPID = aevent.PPid
 			c.Process.ReadCmdline()
 			c.Process.CWD = aevent.ProcDir
 			audit.Lock.RUnlock()
@@ -114,8 +152,16 @@ func newConnectionImpl(nfp *netfilter.Packet, c *Connection, protoType string) (
 			}
 			c.Process.ReadEnv()
 			c.Process.CleanPath()
+			c.Process.ReadParents()
+			c.Process.ReadContainer()
+			c.Process.ReadNamespace()
+			c.Process.ReadSession()
+			c.Process.ReadStartTime()
+			c.Process.ReadAppID()
+			c.Process.ReadCgroup()
 
 			procmon.AddToActivePidsCache(uint64(pid), c.Process)
+			procmon.RecordLookup(true, nil)
 			return c, nil
 		}
 	}
@@ -146,6 +192,10 @@ func newConnectionImpl(nfp *netfilter.Packet, c *Connection, protoType string) (
 				break
 			}
 		}
+		// with ebpf, the lookup has already been counted.
+		if !procmon.MethodIsEbpf() {
+			procmon.RecordLookup(pid != -1, nil)
+		}
 	}
 
 	if pid == os.Getpid() {
@@ -180,11 +230,11 @@ func NewConnection(nfp *netfilter.Packet) (c *Connection, err error) {
 		return nil, errors.New("Error getting IPv4 layer data")
 	}
 	c = &Connection{
-		SrcIP:   ip.SrcIP,
-		DstIP:   ip.DstIP,
-		DstHost: dns.HostOr(ip.DstIP, ""),
-		Pkt:     nfp,
+		SrcIP: ip.SrcIP,
+		DstIP: ip.DstIP,
+		Pkt:   nfp,
 	}
+	c.ResolveDstHost()
 	return newConnectionImpl(nfp, c, "")
 }
 
@@ -199,11 +249,11 @@ func NewConnection6(nfp *netfilter.Packet) (c *Connection, err error) {
 		return nil, errors.New("Error getting IPv6 layer data")
 	}
 	c = &Connection{
-		SrcIP:   ip.SrcIP,
-		DstIP:   ip.DstIP,
-		DstHost: dns.HostOr(ip.DstIP, ""),
-		Pkt:     nfp,
+		SrcIP: ip.SrcIP,
+		DstIP: ip.DstIP,
+		Pkt:   nfp,
 	}
+	c.ResolveDstHost()
 	return newConnectionImpl(nfp, c, "6")
 }
 
@@ -218,6 +268,10 @@ func (c *Connection) parseDirection(protoType string) bool {
 
 			if tcp.DstPort == 53 {
 				c.getDomains(c.Pkt, c)
+			} else if len(tcp.Payload) > 0 {
+				if c.SNI = parseSNI(tcp.Payload); c.SNI == "" {
+					c.HTTPHost = parseHTTPHost(tcp.Payload)
+				}
 			}
 		}
 	} else if udpLayer := c.Pkt.Packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
@@ -264,6 +318,43 @@ func (c *Connection) parseDirection(protoType string) bool {
 	return ret
 }
 
+// ResolveDstHost sets the domain of the destination IP, and the CNAMEs it has
+// been resolved through, if it has been resolved. Otherwise the IP is looked up
+// with reverse DNS, for the events of the connections.
+func (c *Connection) ResolveDstHost() {
+	var trusted bool
+	c.DstHostChain, c.DstHostResolver, trusted = dns.HostChainResolver(c.DstIP)
+	c.UntrustedResolver = !trusted
+	if len(c.DstHostChain) > 0 {
+		c.DstHost = c.DstHostChain[len(c.DstHostChain)-1]
+	} else {
+		dns.LookupReverse(c.DstIP)
+	}
+}
+
+// reverseHost returns the name of the destination IP looked up with reverse
+// DNS, if its domain is unknown.
+func (c *Connection) reverseHost() string {
+	if c.DstHost != "" {
+		return ""
+	}
+	return dns.ReverseHost(c.DstIP)
+}
+
+// DstHosts returns the names of the destination: the domain requested, and
+// the CNAMEs it has been resolved through.
+func (c *Connection) DstHosts() []string {
+	for _, host := range c.DstHostChain {
+		if host == c.DstHost {
+			return c.DstHostChain
+		}
+	}
+	if c.DstHost == "" {
+		return c.DstHostChain
+	}
+	return append(c.DstHostChain[:len(c.DstHostChain):len(c.DstHostChain)], c.DstHost)
+}
+
 // swapFields swaps connection's fields.
 // Used to workaround an issue where outbound connections
 // have the fields swapped (procmon/ebpf/find.go).
@@ -292,6 +383,8 @@ func (c *Connection) getDomains(nfp *netfilter.Packet, con *Connection) {
 	for _, dns := range domains {
 		con.DstHost = dns
 	}
+	// the domain queried, not the one of the DNS server.
+	con.DstHostChain = nil
 }
 
 // To returns the destination host of a connection.
@@ -329,5 +422,19 @@ func (c *Connection) Serialize() *protocol.Connection {
 		ProcessArgs: c.Process.Args,
 		ProcessEnv:  c.Process.Env,
 		ProcessCwd:  c.Process.CWD,
+		Tags:        c.Tags,
+		Netns:       c.NetNS,
+
+		ProcessParents:     c.Process.SerializeParents(),
+		ProcessCgroup:      c.Process.Cgroup,
+		ProcessSystemdUnit: c.Process.SystemdUnit,
+		ProcessContainer:   c.Process.Container.Serialize(),
+		ProcessTampered:    c.Tampered,
+		ProcessNsPid:       uint32(c.Process.NSPID),
+		ProcessHostPath:    c.Process.HostPath,
+		ProcessExecArgs:    c.Process.ExecArgs,
+		DstHostChain:       c.DstHostChain,
+		DstReverseHost:     c.reverseHost(),
+		DstHostResolver:    c.DstHostResolver,
 	}
 }
@@ -1,6 +1,7 @@
 package conman
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"testing"
@@ -8,6 +9,7 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 
+	"github.com/evilsocket/opensnitch/daemon/dns"
 	"github.com/evilsocket/opensnitch/daemon/netfilter"
 )
 
@@ -125,3 +127,89 @@ func TestParseUDPDirection(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// clientHello returns the first TLS record sent by a client.
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+	buf := make([]byte, 16384)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal("Error reading the ClientHello:", err)
+	}
+	return buf[:n]
+}
+
+func TestParseSNI(t *testing.T) {
+	hello := clientHello(t, "www.Example.org")
+	if sni := parseSNI(hello); sni != "www.example.org" {
+		t.Error("Invalid SNI:", sni)
+	}
+	// the ClientHello doesn't fit in the packet.
+	if sni := parseSNI(hello[:len(hello)/2]); sni != "" && sni != "www.example.org" {
+		t.Error("Invalid SNI of a partial ClientHello:", sni)
+	}
+	if sni := parseSNI(hello[:20]); sni != "" {
+		t.Error("SNI of a truncated ClientHello:", sni)
+	}
+	if sni := parseSNI([]byte("GET / HTTP/1.1\r\nHost: example.org\r\n\r\n")); sni != "" {
+		t.Error("SNI of a non-TLS payload:", sni)
+	}
+	if sni := parseSNI(clientHello(t, "")); sni != "" {
+		t.Error("SNI of a ClientHello without server name:", sni)
+	}
+}
+
+func TestParseHTTPHost(t *testing.T) {
+	tests := map[string]string{
+		"GET / HTTP/1.1\r\nUser-Agent: curl\r\nHost: www.Example.org\r\n\r\n": "www.example.org",
+		"POST /api HTTP/1.0\r\nhost: example.org:8080\r\n":                    "example.org",
+		"GET / HTTP/1.1\r\nHost: [2001:db8::1]:80\r\n\r\n":                    "2001:db8::1",
+		// the Host header is not in the packet.
+		"GET / HTTP/1.1\r\nUser-Agent: curl\r\n":                   "",
+		"GET / HTTP/1.1\r\n\r\nHost: example.org\r\n":              "",
+		"SSH-2.0-OpenSSH_9.6\r\nHost: example.org\r\n":             "",
+		"GET / HTTP/2.0\r\nHost: example.org\r\n\r\n":              "",
+		"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03 Host: x\r\n": "",
+	}
+	for payload, host := range tests {
+		if h := parseHTTPHost([]byte(payload)); h != host {
+			t.Errorf("Invalid HTTP host of %q: %s, expected %s", payload, h, host)
+		}
+	}
+}
+
+func TestResolveDstHostResolver(t *testing.T) {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP("10.6.6.6"), DstIP: net.ParseIP("192.168.1.109")}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 29517}
+	udp.SetNetworkLayerForChecksum(ip)
+	msg := &layers.DNS{ID: 1, QR: true,
+		Questions: []layers.DNSQuestion{{Name: []byte("bank.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers:   []layers.DNSResourceRecord{{Name: []byte("bank.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.ParseIP("100.64.6.6").To4()}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, udp, msg); err != nil {
+		t.Fatal(err)
+	}
+	dns.TrackAnswers(gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default))
+
+	con := &Connection{DstIP: net.ParseIP("100.64.6.6")}
+	con.ResolveDstHost()
+	if con.DstHost != "bank.example.com" || con.DstHostResolver != "10.6.6.6" {
+		t.Errorf("Invalid domain or resolver: %s, %s", con.DstHost, con.DstHostResolver)
+	}
+	if con.UntrustedResolver {
+		t.Error("The resolver should be trusted if no trusted resolvers are configured")
+	}
+
+	dns.SetTrustedResolvers([]string{"1.1.1.1", "192.168.1.0/24"})
+	defer dns.SetTrustedResolvers(nil)
+	con.ResolveDstHost()
+	if !con.UntrustedResolver {
+		t.Error("The resolver should not be trusted")
+	}
+}
@@ -0,0 +1,54 @@
+package conman
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+// The host of the plain HTTP connections is read from the Host header of the
+// first request, when the packet intercepted has data, like the SNI of the
+// TLS connections.
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// parseHTTPHost returns the host of the Host header of an HTTP/1.x request,
+// or an empty string if the payload is not a request, or the header is not in
+// the payload.
+func parseHTTPHost(payload []byte) string {
+	end := bytes.Index(payload, []byte("\r\n"))
+	if end == -1 || !isHTTPRequestLine(string(payload[:end])) {
+		return ""
+	}
+	for _, line := range strings.Split(string(payload[end+2:]), "\r\n") {
+		if line == "" {
+			// end of the headers.
+			break
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "host") {
+			continue
+		}
+		host := strings.TrimSpace(kv[1])
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	}
+	return ""
+}
+
+// isHTTPRequestLine checks if a line is the request line of HTTP/1.x:
+// GET /index.html HTTP/1.1
+func isHTTPRequestLine(line string) bool {
+	fields := strings.Split(line, " ")
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return false
+	}
+	for _, m := range httpMethods {
+		if fields[0] == m {
+			return true
+		}
+	}
+	return false
+}
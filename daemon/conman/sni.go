@@ -0,0 +1,129 @@
+package conman
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// The server name (SNI) of the TLS connections is read from the ClientHello
+// message, which is sent in the first packet with data of the connection.
+// The SYN packets don't carry data, so it's only available when the packet
+// intercepted has data (TCP Fast Open, or packets queued after the handshake).
+// The ClientHello of QUIC is encrypted, so it's not parsed.
+
+const (
+	tlsRecordHandshake    = 22
+	tlsClientHello        = 1
+	tlsExtServerName      = 0
+	tlsServerNameHost     = 0
+	tlsRecordHeaderLen    = 5
+	tlsHandshakeHeaderLen = 4
+)
+
+// parseSNI returns the server name of a TLS ClientHello, or an empty string if
+// the payload is not a ClientHello. Only the beginning of the ClientHello may
+// be in the payload, so the name is returned if it's found before the end of
+// the data.
+func parseSNI(payload []byte) string {
+	if len(payload) < tlsRecordHeaderLen+tlsHandshakeHeaderLen || payload[0] != tlsRecordHandshake || payload[1] != 3 {
+		return ""
+	}
+	hello := payload[tlsRecordHeaderLen:]
+	if hello[0] != tlsClientHello {
+		return ""
+	}
+	// version (2) and random (32).
+	p := &tlsReader{data: hello[tlsHandshakeHeaderLen:]}
+	if !p.skip(34) || !p.skipVector(1) || !p.skipVector(2) || !p.skipVector(1) {
+		return ""
+	}
+	if _, ok := p.uint16(); !ok {
+		return ""
+	}
+	for {
+		extType, ok := p.uint16()
+		if !ok {
+			return ""
+		}
+		extLen, ok := p.uint16()
+		if !ok {
+			return ""
+		}
+		if extType != tlsExtServerName {
+			if !p.skip(int(extLen)) {
+				return ""
+			}
+			continue
+		}
+		// server_name_list length, followed by the names.
+		if _, ok := p.uint16(); !ok {
+			return ""
+		}
+		for {
+			nameType, ok := p.uint8()
+			if !ok {
+				return ""
+			}
+			nameLen, ok := p.uint16()
+			if !ok {
+				return ""
+			}
+			name, ok := p.bytes(int(nameLen))
+			if !ok {
+				return ""
+			}
+			if nameType == tlsServerNameHost {
+				return strings.ToLower(strings.TrimSuffix(string(name), "."))
+			}
+		}
+	}
+}
+
+// tlsReader reads the fields of a TLS message, without reading past the end
+// of the data.
+type tlsReader struct {
+	data []byte
+}
+
+func (r *tlsReader) skip(n int) bool {
+	_, ok := r.bytes(n)
+	return ok
+}
+
+func (r *tlsReader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(r.data) < n {
+		return nil, false
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, true
+}
+
+func (r *tlsReader) uint8() (uint8, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *tlsReader) uint16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+// skipVector skips a vector of data, preceded by its length of lenSize bytes.
+func (r *tlsReader) skipVector(lenSize int) bool {
+	b, ok := r.bytes(lenSize)
+	if !ok {
+		return false
+	}
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return r.skip(n)
+}
@@ -1,10 +1,12 @@
 package core
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"regexp"
 	"strings"
 
@@ -28,6 +30,26 @@ func GetKernelVersion() string {
 	return strings.Replace(string(version), "\n", "", -1)
 }
 
+// GetCgroupv2MountPoint returns where the cgroupv2 hierarchy is mounted:
+// /sys/fs/cgroup on unified systems, /sys/fs/cgroup/unified on hybrid systems.
+func GetCgroupv2MountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("cgroupv2 not mounted")
+}
+
 // CheckSysRequirements checks system features we need to work properly
 func CheckSysRequirements() {
 	type checksT struct {
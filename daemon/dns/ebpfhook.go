@@ -118,9 +118,14 @@ func ListenerEbpf() error {
 		return err
 	}
 	probesAttached := 0
+	tlsProbesAttached := 0
 	for uprobe := range m.IterUprobes() {
 		probeFunction := strings.Replace(uprobe.Name, "uretprobe/", "", 1)
 		probeFunction = strings.Replace(probeFunction, "uprobe/", "", 1)
+		if libPattern, isTLS := tlsLibraries[probeFunction]; isTLS {
+			tlsProbesAttached += attachTLSProbe(uprobe, probeFunction, libPattern)
+			continue
+		}
 		offset, err := lookupSymbol(libcElf, probeFunction)
 		if err != nil {
 			log.Warning("EBPF-DNS: Failed to find symbol for uprobe %s : %s\n", uprobe.Name, err)
@@ -147,6 +152,23 @@ func ListenerEbpf() error {
 		log.Error("EBPF-DNS: Failed to init perf map: %s\n", err)
 		return err
 	}
+	var tlsPerfMap *bpf.PerfMap
+	tlsChannel := make(chan []byte)
+	tlsExitChannel := make(chan bool)
+	if tlsProbesAttached > 0 {
+		if tlsPerfMap, err = bpf.InitPerfMap(m, "tls_events", tlsChannel, nil); err != nil {
+			log.Warning("EBPF-DNS: Failed to init TLS perf map, DoH/DoT responses won't be tracked: %s", err)
+		} else {
+			log.Info("EBPF-DNS: tracking DoH/DoT responses, %d TLS probes attached", tlsProbesAttached)
+			if err := m.EnableKprobe("kprobe/tcp_recvmsg", 0); err != nil {
+				log.Warning("EBPF-DNS: Failed to attach kprobe tcp_recvmsg, the servers of the DoH/DoT responses won't be known: %s", err)
+			}
+			// the reads of a connection are parsed in order, by a single worker.
+			go spawnTLSWorker(0, tlsChannel, tlsExitChannel)
+			tlsPerfMap.PollStart()
+		}
+	}
+
 	sig := make(chan os.Signal, 1)
 	exitChannel := make(chan bool)
 	signal.Notify(sig, os.Interrupt, os.Kill)
@@ -162,6 +184,10 @@ func ListenerEbpf() error {
 	for i := 0; i < 5; i++ {
 		exitChannel <- true
 	}
+	if tlsPerfMap != nil {
+		tlsPerfMap.PollStop()
+		close(tlsExitChannel)
+	}
 	return nil
 }
 
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// The DNS responses can be rewritten to block the domains denied when they're
+// resolved, in addition to when the applications connect to them: the A/AAAA
+// records of the domains denied are stripped from the responses, answering
+// NXDOMAIN, or replaced by 0.0.0.0 (::).
+
+// Methods of blocking the domains of the DNS responses.
+const (
+	FirewallNXDomain = "nxdomain"
+	FirewallNull     = "null"
+)
+
+// DeniedFunc tells if an IP resolved must be blocked. chain are the names it
+// has been resolved through, from the name of the IP up to the domain
+// requested.
+type DeniedFunc func(chain []string, ip net.IP) bool
+
+// FilterAnswers checks the A/AAAA records of the DNS response of a packet, and
+// returns the packet rewritten if any of them is denied, or nil if the
+// response must not be modified.
+func FilterAnswers(packet gopacket.Packet, method string, denied DeniedFunc) []byte {
+	if method != FirewallNXDomain && method != FirewallNull {
+		return nil
+	}
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp == nil || udp.SrcPort != 53 {
+		return nil
+	}
+	msg, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || msg == nil || !msg.QR || len(msg.Answers) == 0 {
+		return nil
+	}
+
+	var answers []layers.DNSResourceRecord
+	blocked, addresses := 0, 0
+	for _, ans := range msg.Answers {
+		if ans.IP == nil || (ans.Type != layers.DNSTypeA && ans.Type != layers.DNSTypeAAAA) {
+			answers = append(answers, ans)
+			continue
+		}
+		if !denied(answerChain(msg, string(ans.Name)), ans.IP) {
+			answers = append(answers, ans)
+			addresses++
+			continue
+		}
+		log.Debug("DNS firewall: blocking %s -> %s", ans.Name, ans.IP)
+		blocked++
+		if method == FirewallNull {
+			if ans.Type == layers.DNSTypeA {
+				ans.IP = net.IPv4zero.To4()
+			} else {
+				ans.IP = net.IPv6zero
+			}
+			answers = append(answers, ans)
+		}
+	}
+	if blocked == 0 {
+		return nil
+	}
+	msg.Answers = answers
+	if method == FirewallNXDomain && addresses == 0 {
+		msg.ResponseCode = layers.DNSResponseCodeNXDomain
+		msg.Answers = nil
+	}
+
+	return serializeResponse(packet, udp, msg)
+}
+
+// answerChain returns the names of a record of a response, from its name up
+// to the domain requested, following the CNAMEs of the response.
+func answerChain(msg *layers.DNS, name string) []string {
+	chain := []string{name}
+	seen := map[string]bool{name: true}
+	for found := true; found; {
+		found = false
+		for _, ans := range msg.Answers {
+			if ans.Type == layers.DNSTypeCNAME && string(ans.CNAME) == name && !seen[string(ans.Name)] {
+				name = string(ans.Name)
+				seen[name] = true
+				chain = append(chain, name)
+				found = true
+				break
+			}
+		}
+	}
+	return chain
+}
+
+// serializeResponse builds the packet of a DNS response modified, recomputing
+// the lengths and checksums.
+func serializeResponse(packet gopacket.Packet, udp *layers.UDP, msg *layers.DNS) []byte {
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	buf := gopacket.NewSerializeBuffer()
+
+	var err error
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		udp.SetNetworkLayerForChecksum(ip)
+		err = gopacket.SerializeLayers(buf, opts, ip, udp, msg)
+	case *layers.IPv6:
+		// the extension headers are not serialized.
+		if ip.NextHeader != layers.IPProtocolUDP {
+			return nil
+		}
+		udp.SetNetworkLayerForChecksum(ip)
+		err = gopacket.SerializeLayers(buf, opts, ip, udp, msg)
+	default:
+		return nil
+	}
+	if err != nil {
+		log.Warning("DNS firewall: unable to rewrite the response: %s", err)
+		return nil
+	}
+	return buf.Bytes()
+}
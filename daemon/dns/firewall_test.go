@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func cnameRecord(name, cname string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 120, CNAME: []byte(cname)}
+}
+
+func aaaaRecord(name, ip string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: 120, IP: net.ParseIP(ip)}
+}
+
+// deniedDomains denies the IPs resolved through any of the domains.
+func deniedDomains(domains ...string) DeniedFunc {
+	return func(chain []string, ip net.IP) bool {
+		for _, host := range chain {
+			for _, d := range domains {
+				if host == d {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func filteredResponse(t *testing.T, data []byte) *layers.DNS {
+	if data == nil {
+		t.Fatal("the response has not been rewritten")
+	}
+	packet := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default)
+	msg, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok {
+		t.Fatal("invalid response rewritten")
+	}
+	return msg
+}
+
+func TestAnswerChain(t *testing.T) {
+	msg := &layers.DNS{Answers: []layers.DNSResourceRecord{
+		cnameRecord("www.example.com", "www.example.com.cdn.net"),
+		cnameRecord("www.example.com.cdn.net", "edge.cdn.net"),
+		aRecord("edge.cdn.net", "1.1.1.1"),
+		// loop
+		cnameRecord("a.example.com", "b.example.com"),
+		cnameRecord("b.example.com", "a.example.com"),
+	}}
+
+	tests := []struct {
+		name  string
+		chain []string
+	}{
+		{"edge.cdn.net", []string{"edge.cdn.net", "www.example.com.cdn.net", "www.example.com"}},
+		{"www.example.com", []string{"www.example.com"}},
+		{"a.example.com", []string{"a.example.com", "b.example.com"}},
+	}
+	for _, test := range tests {
+		chain := answerChain(msg, test.name)
+		if len(chain) != len(test.chain) {
+			t.Errorf("%s: unexpected chain %v, want %v", test.name, chain, test.chain)
+			continue
+		}
+		for i := range chain {
+			if chain[i] != test.chain[i] {
+				t.Errorf("%s: unexpected chain %v, want %v", test.name, chain, test.chain)
+				break
+			}
+		}
+	}
+}
+
+func TestFilterAnswers(t *testing.T) {
+	answers := []layers.DNSResourceRecord{
+		cnameRecord("www.example.com", "edge.cdn.net"),
+		aRecord("edge.cdn.net", "1.1.1.1"),
+		aaaaRecord("edge.cdn.net", "2001:db8::1"),
+		aRecord("api.example.com", "1.1.1.2"),
+	}
+
+	t.Run("not denied", func(t *testing.T) {
+		packet := answerPacket(t, portDNS, answers, nil)
+		if data := FilterAnswers(packet, FirewallNXDomain, deniedDomains("ads.example.com")); data != nil {
+			t.Error("the response has been rewritten")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		packet := answerPacket(t, portDNS, answers, nil)
+		if data := FilterAnswers(packet, "", deniedDomains("www.example.com")); data != nil {
+			t.Error("the response has been rewritten")
+		}
+	})
+
+	t.Run("not DNS", func(t *testing.T) {
+		packet := answerPacket(t, portMDNS, answers, nil)
+		if data := FilterAnswers(packet, FirewallNXDomain, deniedDomains("www.example.com")); data != nil {
+			t.Error("the mDNS response has been rewritten")
+		}
+	})
+
+	t.Run("CNAME denied, A and AAAA stripped", func(t *testing.T) {
+		packet := answerPacket(t, portDNS, answers, nil)
+		msg := filteredResponse(t, FilterAnswers(packet, FirewallNXDomain, deniedDomains("www.example.com")))
+		if msg.ResponseCode != layers.DNSResponseCodeNoErr {
+			t.Errorf("unexpected response code %s", msg.ResponseCode)
+		}
+		// the CNAME is kept, and the address of the other domain.
+		if len(msg.Answers) != 2 || msg.Answers[0].Type != layers.DNSTypeCNAME || !msg.Answers[1].IP.Equal(net.ParseIP("1.1.1.2")) {
+			t.Errorf("unexpected answers: %v", msg.Answers)
+		}
+	})
+
+	t.Run("CNAME denied, replaced by null addresses", func(t *testing.T) {
+		packet := answerPacket(t, portDNS, answers, nil)
+		msg := filteredResponse(t, FilterAnswers(packet, FirewallNull, deniedDomains("www.example.com")))
+		if len(msg.Answers) != 4 {
+			t.Fatalf("unexpected answers: %v", msg.Answers)
+		}
+		if !msg.Answers[1].IP.Equal(net.IPv4zero) || !msg.Answers[2].IP.Equal(net.IPv6zero) {
+			t.Errorf("the addresses denied have not been replaced: %s %s", msg.Answers[1].IP, msg.Answers[2].IP)
+		}
+		if msg.Answers[2].Type != layers.DNSTypeAAAA || msg.Answers[2].IP.To4() != nil {
+			t.Errorf("the AAAA record has been replaced by an IPv4: %v", msg.Answers[2])
+		}
+		if !msg.Answers[3].IP.Equal(net.ParseIP("1.1.1.2")) {
+			t.Errorf("an address allowed has been replaced: %v", msg.Answers[3])
+		}
+	})
+
+	t.Run("all the answers stripped", func(t *testing.T) {
+		packet := answerPacket(t, portDNS, answers, nil)
+		msg := filteredResponse(t, FilterAnswers(packet, FirewallNXDomain, deniedDomains("www.example.com", "api.example.com")))
+		if msg.ResponseCode != layers.DNSResponseCodeNXDomain || len(msg.Answers) != 0 {
+			t.Errorf("unexpected response: %s, %v", msg.ResponseCode, msg.Answers)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// The names of the hosts files (/etc/hosts, and the files of aliases
+// configured by the user) are not resolved with DNS, so they're loaded to
+// show and match the connections to the local or internal hosts by their
+// names. They're used when the IP hasn't been resolved with DNS, and they
+// don't expire, but the files are reloaded when they change.
+
+const (
+	systemHostsFile = "/etc/hosts"
+	// time to wait for the changes of a file to be written, before reloading it.
+	hostsReloadDelay = time.Second
+)
+
+var (
+	hostsLock    = sync.RWMutex{}
+	hostsNames   = make(map[string]string)
+	hostsFiles   []string
+	hostsWatcher *fsnotify.Watcher
+)
+
+// LoadHostsFiles loads the names of /etc/hosts and of the files of aliases,
+// in the format of /etc/hosts. The aliases have preference over /etc/hosts.
+func LoadHostsFiles(aliases []string) {
+	files := append([]string{systemHostsFile}, aliases...)
+	for i, file := range files {
+		files[i] = filepath.Clean(file)
+	}
+
+	hostsLock.Lock()
+	defer hostsLock.Unlock()
+
+	hostsFiles = files
+	loadHostsFiles()
+	watchHostsFiles()
+}
+
+// lookupHosts returns the name of an IP of the hosts files, if any.
+func lookupHosts(ip string) (string, bool) {
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
+
+	name, found := hostsNames[ip]
+	return name, found
+}
+
+// loadHostsFiles reads the hosts files. Must be called with the lock held.
+func loadHostsFiles() {
+	names := make(map[string]string)
+	for _, file := range hostsFiles {
+		if err := parseHostsFile(file, names); err != nil && !os.IsNotExist(err) {
+			log.Warning("Error loading hosts file %s: %s", file, err)
+		}
+	}
+	hostsNames = names
+	log.Debug("%d names loaded from the hosts files", len(names))
+}
+
+// parseHostsFile adds the names of a hosts file to the list, the first name of
+// each IP. The ones of the last files loaded replace the previous ones.
+//
+//	192.168.1.10	nas.lan nas	# comment
+func parseHostsFile(file string, names map[string]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fileNames := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		if _, found := fileNames[ip.String()]; !found {
+			fileNames[ip.String()] = strings.ToLower(fields[1])
+		}
+	}
+	for ip, name := range fileNames {
+		names[ip] = name
+	}
+	return scanner.Err()
+}
+
+// watchHostsFiles reloads the hosts files when they change. The directories
+// are watched, because the files are usually replaced instead of modified.
+// Must be called with the lock held.
+func watchHostsFiles() {
+	if hostsWatcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Warning("Unable to watch the hosts files: %s", err)
+			return
+		}
+		hostsWatcher = watcher
+		go hostsWatcherWorker(watcher)
+	}
+	for _, file := range hostsFiles {
+		if err := hostsWatcher.Add(filepath.Dir(file)); err != nil {
+			log.Debug("Unable to watch the hosts file %s: %s", file, err)
+		}
+	}
+}
+
+func hostsWatcherWorker(watcher *fsnotify.Watcher) {
+	var timer *time.Timer
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Op&fsnotify.Chmod == event.Op || !isHostsFile(event.Name) {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(hostsReloadDelay, func() {
+				hostsLock.Lock()
+				defer hostsLock.Unlock()
+				log.Debug("Hosts file changed, reloading: %s", event.Name)
+				loadHostsFiles()
+			})
+		case err := <-watcher.Errors:
+			log.Error("Hosts files watcher error: %s", err)
+		}
+	}
+}
+
+func isHostsFile(path string) bool {
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
+
+	for _, file := range hostsFiles {
+		if file == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
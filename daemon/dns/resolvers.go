@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// The domains are resolved by the DNS servers configured on the system, which
+// may be changed by a rogue DHCP server, or by an attacker on the local
+// network answering the queries before the legitimate server. If a list of
+// trusted resolvers is configured, the connections to the IPs resolved by
+// other servers are flagged or denied.
+// The responses of the local resolvers (systemd-resolved, dnsmasq, ...), and
+// the ones whose server is unknown (getaddrinfo(), ...) are trusted.
+// The DoH/DoT responses read from the TLS libraries may come from any
+// connection of any process, so they're only trusted if their server is one
+// of the trusted resolvers.
+
+// Actions applied to the connections whose domain has been resolved by an
+// untrusted resolver.
+const (
+	UntrustedAlert = "alert"
+	UntrustedDeny  = "deny"
+)
+
+var (
+	trustLock        = sync.RWMutex{}
+	trustedResolvers []*net.IPNet
+)
+
+// SetTrustedResolvers configures the IPs or networks of the trusted DNS
+// servers. If empty, all of them are trusted.
+func SetTrustedResolvers(resolvers []string) {
+	var nets []*net.IPNet
+	for _, r := range resolvers {
+		if !strings.Contains(r, "/") {
+			if ip := net.ParseIP(r); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			log.Warning("Invalid trusted DNS resolver %s: %s", r, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+
+	trustLock.Lock()
+	defer trustLock.Unlock()
+	trustedResolvers = nets
+}
+
+// TrustedResolver tells if the answers of a DNS server are trusted.
+func TrustedResolver(resolver string) bool {
+	if resolver == "" {
+		return true
+	}
+	ip := net.ParseIP(resolver)
+	if ip == nil || ip.IsLoopback() {
+		return true
+	}
+
+	trustLock.RLock()
+	defer trustLock.RUnlock()
+
+	if len(trustedResolvers) == 0 {
+		return true
+	}
+	for _, ipNet := range trustedResolvers {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedEncryptedResolver tells if the DoH/DoT answers of a server are
+// trusted: only if it's local or one of the trusted resolvers.
+func TrustedEncryptedResolver(resolver string) bool {
+	ip := net.ParseIP(resolver)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	trustLock.RLock()
+	defer trustLock.RUnlock()
+
+	for _, ipNet := range trustedResolvers {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// The destinations whose domain has not been resolved (connections to IPs,
+// domains resolved before the daemon was started, ...) are looked up with
+// reverse DNS (PTR) in the background, to show a name instead of the IP.
+// The names are informative only: they're not used to match the rules,
+// because the owner of the IP decides them.
+
+var (
+	reverseEnabled = true
+	// queries in progress at the same time. The lookups beyond it are
+	// discarded, and retried with the next connection.
+	maxReverseQueries = 4
+	reverseTimeout    = 3 * time.Second
+	// time the names (or the lack of them) are kept.
+	reverseTTL        = time.Hour
+	reverseFailedTTL  = 10 * time.Minute
+	maxReverseEntries = 5000
+
+	reverseLock    = sync.Mutex{}
+	reverseNames   = make(map[string]*reverseEntry)
+	reverseQueries = make(chan struct{}, maxReverseQueries)
+)
+
+type reverseEntry struct {
+	name    string
+	expires time.Time
+	pending bool
+}
+
+// SetReverseLookups enables or disables the reverse DNS lookups.
+func SetReverseLookups(enabled bool) {
+	reverseLock.Lock()
+	defer reverseLock.Unlock()
+
+	reverseEnabled = enabled
+	if !enabled {
+		reverseNames = make(map[string]*reverseEntry)
+	}
+}
+
+// LookupReverse starts the reverse DNS lookup of an IP in the background, if
+// it's not known yet.
+func LookupReverse(ip net.IP) {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+		return
+	}
+	key := ip.String()
+
+	reverseLock.Lock()
+	defer reverseLock.Unlock()
+
+	if !reverseEnabled {
+		return
+	}
+	if entry, found := reverseNames[key]; found && (entry.pending || time.Now().Before(entry.expires)) {
+		return
+	}
+	select {
+	case reverseQueries <- struct{}{}:
+	default:
+		return
+	}
+	if len(reverseNames) >= maxReverseEntries {
+		purgeReverseNames()
+	}
+	reverseNames[key] = &reverseEntry{pending: true}
+	go resolveReverse(key)
+}
+
+// ReverseHost returns the name of an IP looked up with reverse DNS, if any.
+func ReverseHost(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	reverseLock.Lock()
+	defer reverseLock.Unlock()
+
+	if entry, found := reverseNames[ip.String()]; found && !entry.pending && time.Now().Before(entry.expires) {
+		return entry.name
+	}
+	return ""
+}
+
+func resolveReverse(ip string) {
+	defer func() { <-reverseQueries }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reverseTimeout)
+	defer cancel()
+
+	entry := &reverseEntry{expires: time.Now().Add(reverseFailedTTL)}
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err == nil && len(names) > 0 {
+		entry.name = strings.TrimSuffix(names[0], ".")
+		entry.expires = time.Now().Add(reverseTTL)
+		log.Debug("Reverse DNS: %s -> %s", ip, entry.name)
+	}
+
+	reverseLock.Lock()
+	defer reverseLock.Unlock()
+	if reverseEnabled {
+		reverseNames[ip] = entry
+	}
+}
+
+// purgeReverseNames deletes the names expired, or all of them if none has
+// expired. Must be called with the lock held.
+func purgeReverseNames() {
+	now := time.Now()
+	for ip, entry := range reverseNames {
+		if !entry.pending && now.After(entry.expires) {
+			delete(reverseNames, ip)
+		}
+	}
+	if len(reverseNames) >= maxReverseEntries {
+		for ip, entry := range reverseNames {
+			if !entry.pending {
+				delete(reverseNames, ip)
+			}
+		}
+	}
+}
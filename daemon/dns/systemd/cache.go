@@ -0,0 +1,72 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// The queries resolved before the daemon was started, or while it was not
+// connected to systemd-resolved, are not notified by the monitor. Their
+// records are read from the cache of systemd-resolved (systemd >= 254):
+//   - resolvectl show-cache
+
+const (
+	resolvedDumpCacheMethod = "io.systemd.Resolve.Monitor.DumpCache"
+	dumpCacheTimeout        = 5 * time.Second
+)
+
+// CacheRecord represents a resource record of the cache of systemd-resolved.
+type CacheRecord struct {
+	RR  RRType `json:"rr"`
+	Raw string `json:"raw"`
+}
+
+// CacheEntry represents an entry of the cache of systemd-resolved: the
+// records of a question.
+type CacheEntry struct {
+	Key KeyType       `json:"key"`
+	RRs []CacheRecord `json:"rrs"`
+}
+
+// CacheScope represents the cache of an interface and protocol of
+// systemd-resolved.
+/*{
+	"protocol": "dns",
+	"family": 2,
+	"ifindex": 3,
+	"ifname": "wlan0",
+	"cache": [{"key": {...}, "rrs": [{"rr": {...}, "raw": "..."}], "until": 1234}]
+}*/
+type CacheScope struct {
+	Protocol string       `json:"protocol"`
+	Ifindex  int          `json:"ifindex"`
+	Cache    []CacheEntry `json:"cache"`
+}
+
+type dumpCacheResponse struct {
+	Dump []CacheScope `json:"dump"`
+}
+
+// DumpCache returns the records of the cache of systemd-resolved.
+// It opens its own connection with the unix socket, because the one of the
+// monitor is busy receiving the DNS responses.
+func DumpCache() ([]CacheScope, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dumpCacheTimeout)
+	defer cancel()
+
+	conn, err := varlink.NewConnection(ctx, fmt.Sprintf("unix://%s", socketPath))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	type emptyT struct{}
+	resp := &dumpCacheResponse{}
+	if err := conn.Call(ctx, resolvedDumpCacheMethod, &emptyT{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Dump, nil
+}
@@ -0,0 +1,447 @@
+package dns
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	bpf "github.com/iovisor/gobpf/elf"
+	"golang.org/x/net/http2/hpack"
+)
+
+// The applications that resolve the domains with DNS-over-HTTPS or
+// DNS-over-TLS don't use getaddrinfo(), and their responses can't be read from
+// the packets. The data they read from the TLS libraries is hooked instead, and
+// the DNS responses are searched in it.
+
+// The DoT responses are only searched on the connections to the port 853, and
+// the DoH ones on the HTTP responses whose Content-Type is a DNS message. The
+// server of the connection is obtained from the socket read, and the answers
+// are considered untrusted unless it's one of the trusted resolvers.
+
+const (
+	maxTLSData = 1024
+	portDoT    = 853
+
+	contentTypeDNSMessage = "application/dns-message"
+	contentTypeDNSJSON    = "application/dns-json"
+
+	// connections and HTTP/2 streams whose state is kept.
+	maxTLSConns     = 1024
+	maxHTTP2Streams = 100
+	// size of the dynamic table of the HTTP/2 headers allowed.
+	maxHeaderTableSize = 64 * 1024
+)
+
+type tlsPeer struct {
+	Family uint16
+	// network byte order.
+	Dport uint16
+	Daddr [16]byte
+}
+
+type tlsDataEvent struct {
+	Len  uint32
+	Pid  uint32
+	Conn uint64
+	Peer tlsPeer
+	Data [maxTLSData]byte
+}
+
+var (
+	// libraries of the TLS functions hooked, by function.
+	tlsLibraries = map[string]string{
+		"SSL_read":           "libssl.so*",
+		"gnutls_record_recv": "libgnutls.so*",
+		"PR_Read":            "libnspr4.so",
+	}
+	// directories where the libraries are searched, if they're not found by
+	// ldconfig.
+	libDirs = []string{
+		"/lib64", "/usr/lib64",
+		"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu",
+		"/lib/aarch64-linux-gnu", "/usr/lib/aarch64-linux-gnu",
+		"/lib", "/usr/lib",
+	}
+)
+
+// findLibraries returns the paths of the libraries whose name matches the
+// pattern, without duplicates.
+func findLibraries(pattern string) (libs []string) {
+	seen := make(map[string]bool)
+	add := func(path string) {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil || seen[realPath] {
+			return
+		}
+		seen[realPath] = true
+		libs = append(libs, realPath)
+	}
+
+	// libssl.so.3 (libc6,x86-64) => /lib/x86_64-linux-gnu/libssl.so.3
+	if out, err := core.Exec("ldconfig", []string{"-p"}); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[len(fields)-2] != "=>" {
+				continue
+			}
+			if matched, _ := filepath.Match(pattern, fields[0]); matched {
+				add(fields[len(fields)-1])
+			}
+		}
+	}
+	if len(libs) > 0 {
+		return libs
+	}
+	for _, dir := range libDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, lib := range matches {
+			add(lib)
+		}
+	}
+	return libs
+}
+
+// attachTLSProbe attaches the uprobe of a TLS function to the libraries that
+// export it, and returns the number of libraries where it has been attached.
+func attachTLSProbe(uprobe *bpf.Uprobe, function, libPattern string) (attached int) {
+	for _, lib := range findLibraries(libPattern) {
+		libElf, err := elf.Open(lib)
+		if err != nil {
+			log.Debug("EBPF-DNS: Failed to open %s: %v", lib, err)
+			continue
+		}
+		offset, err := lookupSymbol(libElf, function)
+		libElf.Close()
+		if err != nil {
+			log.Debug("EBPF-DNS: %s not found in %s", function, lib)
+			continue
+		}
+		if err := bpf.AttachUprobe(uprobe, lib, offset); err != nil {
+			log.Warning("EBPF-DNS: Failed to attach uprobe %s to %s: %s", uprobe.Name, lib, err)
+			continue
+		}
+		log.Debug("EBPF-DNS: %s attached to %s", uprobe.Name, lib)
+		attached++
+	}
+	return attached
+}
+
+func spawnTLSWorker(id int, channel chan []byte, exitChannel chan bool) {
+	log.Debug("dns TLS worker initialized #%d", id)
+	var event tlsDataEvent
+	conns := newTLSConns()
+	for {
+		select {
+		case <-exitChannel:
+			log.Debug("DNS TLS worker #%d closed", id)
+			return
+		case data := <-channel:
+			if err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &event); err != nil {
+				log.Debug("(%d) EBPF-DNS: Failed to decode TLS event: %s", id, err)
+				continue
+			}
+			if event.Len > maxTLSData {
+				continue
+			}
+			resolver, port := event.Peer.addr()
+			conn := conns.get(tlsConnKey{pid: event.Pid, conn: event.Conn})
+			for _, msg := range conn.findDNSResponses(event.Data[:event.Len], port == portDoT) {
+				log.Debug("(%d) EBPF-DNS: DoH/DoT response from %s, %d answers", id, resolver, len(msg.Answers))
+				trackEncryptedAnswers(msg, resolver)
+			}
+		}
+	}
+}
+
+// addr returns the IP and port of the server of a TLS connection, if known.
+func (p *tlsPeer) addr() (string, uint16) {
+	port := p.Dport>>8 | p.Dport<<8
+	switch p.Family {
+	case syscall.AF_INET:
+		return net.IP(p.Daddr[:4]).String(), port
+	case syscall.AF_INET6:
+		return net.IP(p.Daddr[:]).String(), port
+	}
+	return "", 0
+}
+
+type tlsConnKey struct {
+	pid  uint32
+	conn uint64
+}
+
+// tlsConns holds the state of the TLS connections read, up to maxTLSConns.
+// The connections closed are not notified, so the least recently read ones
+// are deleted to make room for new ones.
+type tlsConns struct {
+	conns map[tlsConnKey]*tlsConn
+}
+
+func newTLSConns() *tlsConns {
+	return &tlsConns{conns: make(map[tlsConnKey]*tlsConn)}
+}
+
+func (c *tlsConns) get(key tlsConnKey) *tlsConn {
+	conn, found := c.conns[key]
+	if !found {
+		if len(c.conns) >= maxTLSConns {
+			var oldest tlsConnKey
+			for k, v := range c.conns {
+				if c.conns[oldest] == nil || v.lastRead.Before(c.conns[oldest].lastRead) {
+					oldest = k
+				}
+			}
+			delete(c.conns, oldest)
+		}
+		conn = newTLSConn()
+		c.conns[key] = conn
+	}
+	conn.lastRead = time.Now()
+	return conn
+}
+
+// tlsConn holds the state of the HTTP responses of a TLS connection, whose
+// headers and body may be read separately.
+type tlsConn struct {
+	lastRead time.Time
+	// the HTTP/2 headers are compressed with the ones received before on
+	// the connection (RFC 7541).
+	decoder *hpack.Decoder
+	// stream whose headers are being decoded.
+	stream uint32
+	// format of the DNS messages of the HTTP/2 streams, by stream.
+	streams map[uint32]string
+	// format of the DNS message of the last HTTP/1 response, whose body has
+	// not been read yet.
+	pendingBody string
+}
+
+func newTLSConn() *tlsConn {
+	c := &tlsConn{}
+	c.resetHeaders()
+	return c
+}
+
+// resetHeaders discards the state of the HTTP/2 headers, which is lost
+// if a read is truncated or missed.
+func (c *tlsConn) resetHeaders() {
+	c.streams = make(map[uint32]string)
+	c.decoder = hpack.NewDecoder(maxHeaderTableSize, func(f hpack.HeaderField) {
+		if f.Name != "content-type" {
+			return
+		}
+		if format := dnsContentType(f.Value); format != "" && len(c.streams) < maxHTTP2Streams {
+			c.streams[c.stream] = format
+		}
+	})
+}
+
+// dnsContentType returns the format of the DNS messages of a Content-Type,
+// or "" if it's not a DNS message.
+func dnsContentType(contentType string) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == contentTypeDNSMessage || mediaType == contentTypeDNSJSON {
+		return mediaType
+	}
+	return ""
+}
+
+// findDNSResponses returns the DNS responses found in the data read from a
+// TLS connection:
+//   - DNS-over-TLS: the messages are prefixed by their length (RFC 7858), on
+//     the connections to the port 853 (dot).
+//   - DNS-over-HTTPS: the messages are the body of the HTTP/1.1 responses, or
+//     the DATA frames of HTTP/2 (RFC 8484), whose Content-Type is
+//     application/dns-message, or application/dns-json (the JSON API of
+//     Google and Cloudflare).
+func (c *tlsConn) findDNSResponses(data []byte, dot bool) (responses []*layers.DNS) {
+	add := func(format string, body []byte) {
+		var msg *layers.DNS
+		if format == contentTypeDNSJSON {
+			msg = parseDNSJSON(body)
+		} else {
+			msg = parseDNSResponse(body)
+		}
+		if msg != nil {
+			responses = append(responses, msg)
+		}
+	}
+
+	switch {
+	case dot:
+		for len(data) > 2 && int(binary.BigEndian.Uint16(data[0:2])) <= len(data)-2 {
+			msgLen := int(binary.BigEndian.Uint16(data[0:2]))
+			add(contentTypeDNSMessage, data[2:2+msgLen])
+			data = data[2+msgLen:]
+		}
+	case bytes.HasPrefix(data, []byte("HTTP/1.")):
+		c.pendingBody = ""
+		idx := bytes.Index(data, []byte("\r\n\r\n"))
+		if idx == -1 {
+			break
+		}
+		format := ""
+		for _, line := range strings.Split(string(data[:idx]), "\r\n")[1:] {
+			if kv := strings.SplitN(line, ":", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "content-type") {
+				format = dnsContentType(kv[1])
+			}
+		}
+		if format == "" {
+			break
+		}
+		if body := data[idx+4:]; len(body) > 0 {
+			add(format, body)
+		} else {
+			c.pendingBody = format
+		}
+	case c.pendingBody != "":
+		add(c.pendingBody, data)
+		c.pendingBody = ""
+	default:
+		for _, frame := range c.http2DataFrames(data) {
+			add(frame.format, frame.payload)
+		}
+	}
+	return responses
+}
+
+type http2Data struct {
+	format  string
+	payload []byte
+}
+
+// http2DataFrames returns the payload of the HTTP/2 DATA frames of the data
+// whose stream is a DNS message, decoding the headers of the responses.
+func (c *tlsConn) http2DataFrames(data []byte) (frames []http2Data) {
+	const (
+		frameHeaderLen    = 9
+		frameData         = 0x0
+		frameHeaders      = 0x1
+		frameContinuation = 0x9
+		flagEndStream     = 0x1
+		flagEndHeaders    = 0x4
+		flagPadded        = 0x8
+		flagPriority      = 0x20
+	)
+	for len(data) >= frameHeaderLen {
+		length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		frameType, flags := data[3], data[4]
+		stream := binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff
+		if length > len(data)-frameHeaderLen {
+			break
+		}
+		payload := data[frameHeaderLen : frameHeaderLen+length]
+		data = data[frameHeaderLen+length:]
+
+		if (frameType == frameData || frameType == frameHeaders) && flags&flagPadded != 0 {
+			if len(payload) == 0 || int(payload[0]) >= len(payload) {
+				continue
+			}
+			payload = payload[1 : len(payload)-int(payload[0])]
+		}
+		switch frameType {
+		case frameHeaders, frameContinuation:
+			if frameType == frameHeaders && flags&flagPriority != 0 {
+				if len(payload) < 5 {
+					continue
+				}
+				payload = payload[5:]
+			}
+			c.stream = stream
+			if _, err := c.decoder.Write(payload); err != nil {
+				c.resetHeaders()
+				continue
+			}
+			if flags&flagEndHeaders != 0 {
+				if err := c.decoder.Close(); err != nil {
+					c.resetHeaders()
+				}
+			}
+		case frameData:
+			format, found := c.streams[stream]
+			if !found {
+				continue
+			}
+			if flags&flagEndStream != 0 {
+				delete(c.streams, stream)
+			}
+			frames = append(frames, http2Data{format: format, payload: payload})
+		}
+	}
+	return frames
+}
+
+// parseDNSResponse returns the DNS message of the data, if it's a valid
+// response with answers.
+func parseDNSResponse(data []byte) *layers.DNS {
+	if len(data) < 12 {
+		return nil
+	}
+	msg := &layers.DNS{}
+	if err := msg.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	return validDNSResponse(msg)
+}
+
+func validDNSResponse(msg *layers.DNS) *layers.DNS {
+	if !msg.QR || msg.ResponseCode != layers.DNSResponseCodeNoErr || len(msg.Questions) == 0 || len(msg.Answers) == 0 {
+		return nil
+	}
+	return msg
+}
+
+// dnsJSON is the format of the responses of the JSON API of DoH:
+// {"Status": 0, "Question": [{"name": "example.com.", "type": 1}],
+// "Answer": [{"name": "example.com.", "type": 1, "TTL": 300, "data": "1.2.3.4"}]}
+type dnsJSON struct {
+	Status   int             `json:"Status"`
+	Question []dnsJSONRecord `json:"Question"`
+	Answer   []dnsJSONRecord `json:"Answer"`
+}
+
+type dnsJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// parseDNSJSON returns the DNS message of a response of the JSON API, with
+// the A, AAAA and CNAME answers.
+func parseDNSJSON(data []byte) *layers.DNS {
+	var resp dnsJSON
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+	msg := &layers.DNS{QR: true, ResponseCode: layers.DNSResponseCode(resp.Status)}
+	for _, q := range resp.Question {
+		msg.Questions = append(msg.Questions, layers.DNSQuestion{Name: []byte(strings.TrimSuffix(q.Name, ".")), Type: layers.DNSType(q.Type)})
+	}
+	for _, a := range resp.Answer {
+		rr := layers.DNSResourceRecord{Name: []byte(strings.TrimSuffix(a.Name, ".")), Type: layers.DNSType(a.Type), TTL: a.TTL}
+		switch rr.Type {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			if rr.IP = net.ParseIP(a.Data); rr.IP == nil {
+				continue
+			}
+		case layers.DNSTypeCNAME:
+			rr.CNAME = []byte(strings.TrimSuffix(a.Data, "."))
+		default:
+			continue
+		}
+		msg.Answers = append(msg.Answers, rr)
+	}
+	return validDNSResponse(msg)
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/http2/hpack"
+)
+
+func dnsResponse(t *testing.T) []byte {
+	msg := &layers.DNS{
+		ID: 1, QR: true, ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{{
+			Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300,
+			IP: net.ParseIP("1.2.3.4").To4(),
+		}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := msg.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatalf("failed to serialize the DNS response: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func dotMessage(msg []byte) []byte {
+	return append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+func http1Response(contentType string, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 200 OK\r\nContent-Type: " + contentType + "\r\nContent-Length: 10\r\n\r\n")
+	return append(b.Bytes(), body...)
+}
+
+func http2Frame(frameType, flags byte, stream uint32, payload []byte) []byte {
+	frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[5:9], stream)
+	return append(frame, payload...)
+}
+
+func http2Headers(t *testing.T, enc *hpack.Encoder, buf *bytes.Buffer, stream uint32, contentType string) []byte {
+	buf.Reset()
+	for _, f := range []hpack.HeaderField{{Name: ":status", Value: "200"}, {Name: "content-type", Value: contentType}} {
+		if err := enc.WriteField(f); err != nil {
+			t.Fatalf("failed to encode the headers: %s", err)
+		}
+	}
+	return http2Frame(0x1, 0x4, stream, buf.Bytes())
+}
+
+func TestFindDNSResponses(t *testing.T) {
+	msg := dnsResponse(t)
+	json := []byte(`{"Status": 0, "Question": [{"name": "example.com.", "type": 1}],
+"Answer": [{"name": "example.com.", "type": 5, "TTL": 60, "data": "cdn.example.net."},
+{"name": "cdn.example.net.", "type": 1, "TTL": 60, "data": "1.2.3.4"}]}`)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		dot   bool
+		found int
+	}{
+		{"DoT", dotMessage(msg), true, 1},
+		{"DoT, several messages", append(dotMessage(msg), dotMessage(msg)...), true, 2},
+		{"DoT message, not to the port 853", dotMessage(msg), false, 0},
+		{"DoH HTTP/1.1", http1Response("application/dns-message", msg), false, 1},
+		{"DoH HTTP/1.1 JSON", http1Response("application/dns-json; charset=utf-8", json), false, 1},
+		{"HTTP/1.1 with another Content-Type", http1Response("application/octet-stream", msg), false, 0},
+		{"HTTP/1.1 on the port 853", http1Response("application/dns-message", msg), true, 0},
+		{"plain DNS message", msg, false, 0},
+		{"DNS query", http1Response("application/dns-message", append([]byte{0, 1, 0}, msg[3:]...)), false, 0},
+		{"empty", nil, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newTLSConn().findDNSResponses(test.data, test.dot); len(got) != test.found {
+				t.Errorf("%d responses found, want %d", len(got), test.found)
+			}
+		})
+	}
+}
+
+func TestFindDNSResponsesJSON(t *testing.T) {
+	json := []byte(`{"Status": 0, "Question": [{"name": "example.com.", "type": 1}],
+"Answer": [{"name": "example.com.", "type": 5, "TTL": 60, "data": "cdn.example.net."},
+{"name": "cdn.example.net.", "type": 1, "TTL": 60, "data": "1.2.3.4"},
+{"name": "example.com.", "type": 16, "TTL": 60, "data": "\"v=spf1 -all\""}]}`)
+
+	got := newTLSConn().findDNSResponses(http1Response("application/dns-json", json), false)
+	if len(got) != 1 {
+		t.Fatalf("%d responses found, want 1", len(got))
+	}
+	answers := got[0].Answers
+	if len(answers) != 2 {
+		t.Fatalf("unexpected answers: %v", answers)
+	}
+	if string(answers[0].Name) != "example.com" || string(answers[0].CNAME) != "cdn.example.net" {
+		t.Errorf("unexpected CNAME answer: %s -> %s", answers[0].Name, answers[0].CNAME)
+	}
+	if string(answers[1].Name) != "cdn.example.net" || !answers[1].IP.Equal(net.ParseIP("1.2.3.4")) {
+		t.Errorf("unexpected A answer: %s -> %s", answers[1].Name, answers[1].IP)
+	}
+}
+
+func TestFindDNSResponsesSplitReads(t *testing.T) {
+	msg := dnsResponse(t)
+
+	t.Run("HTTP/1.1 body read separately", func(t *testing.T) {
+		conn := newTLSConn()
+		if got := conn.findDNSResponses(http1Response("application/dns-message", nil), false); len(got) != 0 {
+			t.Errorf("%d responses found in the headers", len(got))
+		}
+		if got := conn.findDNSResponses(msg, false); len(got) != 1 {
+			t.Errorf("%d responses found in the body, want 1", len(got))
+		}
+		if got := conn.findDNSResponses(msg, false); len(got) != 0 {
+			t.Errorf("%d responses found after the body", len(got))
+		}
+	})
+
+	t.Run("HTTP/2 streams", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := hpack.NewEncoder(&buf)
+		conn := newTLSConn()
+
+		// the Content-Type of the second response is encoded with the
+		// dynamic table.
+		for _, stream := range []uint32{1, 3} {
+			if got := conn.findDNSResponses(http2Headers(t, enc, &buf, stream, "application/dns-message"), false); len(got) != 0 {
+				t.Errorf("stream %d: %d responses found in the headers", stream, len(got))
+			}
+			if got := conn.findDNSResponses(http2Frame(0x0, 0x1, stream, msg), false); len(got) != 1 {
+				t.Errorf("stream %d: %d responses found, want 1", stream, len(got))
+			}
+		}
+		if len(conn.streams) != 0 {
+			t.Errorf("the ended streams are kept: %v", conn.streams)
+		}
+
+		data := append(http2Headers(t, enc, &buf, 5, "text/html"), http2Frame(0x0, 0x1, 5, msg)...)
+		if got := conn.findDNSResponses(data, false); len(got) != 0 {
+			t.Errorf("%d responses found in a stream of another Content-Type", len(got))
+		}
+		if got := conn.findDNSResponses(http2Frame(0x0, 0x1, 7, msg), false); len(got) != 0 {
+			t.Errorf("%d responses found in a stream whose headers are unknown", len(got))
+		}
+	})
+}
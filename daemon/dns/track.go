@@ -1,8 +1,11 @@
 package dns
 
 import (
+	"container/list"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/log"
 
@@ -10,11 +13,58 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
+// The domains resolved are kept in a LRU cache, by IP (or CNAME), up to
+// maxEntries. The entries expire with the TTL of the records, but not before
+// minTTL, because the applications cache the responses and may connect some
+// time after resolving the domain.
 var (
-	responses = make(map[string]string, 0)
-	lock      = sync.RWMutex{}
+	maxEntries = 10000
+	minTTL     = time.Minute
+	// TTL of the responses whose TTL is unknown (getaddrinfo, systemd-resolved).
+	defaultTTL = 5 * time.Minute
+
+	lock      = sync.Mutex{}
+	responses = make(map[string]*list.Element)
+	lru       = list.New()
+	counters  CacheStats
+)
+
+// Source ports of the responses of the DNS protocols whose answers are
+// tracked. The names of the devices of the local network (printer.local, ...)
+// are resolved with mDNS and LLMNR, instead of with the DNS servers, and only
+// the names of their scope are tracked from them.
+const (
+	portDNS   = 53
+	portMDNS  = 5353
+	portLLMNR = 5355
 )
 
+func init() {
+	// mDNS and LLMNR use the format of the DNS messages.
+	layers.RegisterUDPPortLayerType(portMDNS, layers.LayerTypeDNS)
+	layers.RegisterUDPPortLayerType(portLLMNR, layers.LayerTypeDNS)
+}
+
+type cacheEntry struct {
+	resolved string
+	hostname string
+	expires  time.Time
+	// IP of the DNS server that answered, if known.
+	resolver string
+	// the answer was read from a DoH/DoT connection.
+	encrypted bool
+}
+
+// CacheStats holds the counters of the cache of domains resolved.
+type CacheStats struct {
+	Entries uint64
+	Hits    uint64
+	Misses  uint64
+	// entries deleted because their TTL expired, or to make room for new ones.
+	Expired uint64
+	Evicted uint64
+}
+
 // TrackAnswers obtains the resolved domains of a DNS query.
 // If the packet is UDP DNS, the domain names are added to the list of resolved domains.
 func TrackAnswers(packet gopacket.Packet) bool {
@@ -27,7 +77,7 @@ func TrackAnswers(packet gopacket.Packet) bool {
 	if ok == false || udp == nil {
 		return false
 	}
-	if udp.SrcPort != 53 {
+	if udp.SrcPort != portDNS && udp.SrcPort != portMDNS && udp.SrcPort != portLLMNR {
 		return false
 	}
 
@@ -41,21 +91,91 @@ func TrackAnswers(packet gopacket.Packet) bool {
 		return false
 	}
 
-	for _, ans := range dnsAns.Answers {
+	resolver := ""
+	if nl := packet.NetworkLayer(); nl != nil {
+		resolver = nl.NetworkFlow().Src().String()
+	}
+	switch udp.SrcPort {
+	case portDNS:
+		trackDNSAnswers(dnsAns, resolver)
+	case portMDNS:
+		// the addresses of the services discovered are sent as additional records.
+		trackDNSRecords(localRecords(dnsAns.Answers, mdnsName), resolver, false)
+		trackDNSRecords(localRecords(dnsAns.Additionals, mdnsName), resolver, false)
+	case portLLMNR:
+		trackDNSRecords(localRecords(dnsAns.Answers, llmnrName), resolver, false)
+	}
+
+	return true
+}
+
+// localRecords returns the records whose name can be resolved by a protocol
+// of the local network. Any device of the network can answer them, so they
+// can't resolve other domains.
+func localRecords(records []layers.DNSResourceRecord, allowed func(name string) bool) (local []layers.DNSResourceRecord) {
+	for _, rr := range records {
+		if rr.Name != nil && allowed(string(rr.Name)) {
+			local = append(local, rr)
+		}
+	}
+	return local
+}
+
+// mdnsName tells if a name can be resolved with mDNS: the ones under .local,
+// and the reverse lookups (RFC 6762).
+func mdnsName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, zone := range []string{"local", "in-addr.arpa", "ip6.arpa"} {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// llmnrName tells if a name can be resolved with LLMNR: the single-label
+// names (RFC 4795).
+func llmnrName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return name != "" && !strings.Contains(name, ".")
+}
+
+// trackDNSAnswers adds the domains resolved of a DNS response to the list.
+// resolver is the IP of the DNS server that answered, if known.
+func trackDNSAnswers(dnsAns *layers.DNS, resolver string) {
+	trackDNSRecords(dnsAns.Answers, resolver, false)
+}
+
+// trackEncryptedAnswers adds the domains resolved of a DoH/DoT response,
+// which are trusted only if resolver is one of the trusted resolvers.
+func trackEncryptedAnswers(dnsAns *layers.DNS, resolver string) {
+	trackDNSRecords(dnsAns.Answers, resolver, true)
+}
+
+func trackDNSRecords(records []layers.DNSResourceRecord, resolver string, encrypted bool) {
+	for _, ans := range records {
 		if ans.Name != nil {
+			ttl := time.Duration(ans.TTL) * time.Second
 			if ans.IP != nil {
-				Track(ans.IP.String(), string(ans.Name))
+				track(ans.IP.String(), string(ans.Name), ttl, resolver, encrypted)
 			} else if ans.CNAME != nil {
-				Track(string(ans.CNAME), string(ans.Name))
+				track(string(ans.CNAME), string(ans.Name), ttl, resolver, encrypted)
 			}
 		}
 	}
-
-	return true
 }
 
-// Track adds a resolved domain to the list.
+// Track adds a resolved domain to the list, whose TTL is unknown.
 func Track(resolved string, hostname string) {
+	TrackWithTTL(resolved, hostname, defaultTTL)
+}
+
+// TrackWithTTL adds a resolved domain to the list, until its TTL expires.
+func TrackWithTTL(resolved string, hostname string, ttl time.Duration) {
+	track(resolved, hostname, ttl, "", false)
+}
+
+func track(resolved, hostname string, ttl time.Duration, resolver string, encrypted bool) {
 	lock.Lock()
 	defer lock.Unlock()
 
@@ -65,38 +185,135 @@ func Track(resolved string, hostname string) {
 	if resolved == "::1" || resolved == hostname {
 		return
 	}
-	responses[resolved] = hostname
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	expires := time.Now().Add(ttl)
+
+	if elem, found := responses[resolved]; found {
+		entry := elem.Value.(*cacheEntry)
+		entry.hostname = hostname
+		entry.expires = expires
+		entry.resolver = resolver
+		entry.encrypted = encrypted
+		lru.MoveToFront(elem)
+	} else {
+		for lru.Len() >= maxEntries {
+			removeEntry(lru.Back())
+			counters.Evicted++
+		}
+		responses[resolved] = lru.PushFront(&cacheEntry{resolved: resolved, hostname: hostname, expires: expires, resolver: resolver, encrypted: encrypted})
+	}
 
 	log.Debug("New DNS record: %s -> %s", resolved, hostname)
 }
 
+// removeEntry deletes an entry of the cache. Must be called with the lock held.
+func removeEntry(elem *list.Element) {
+	delete(responses, elem.Value.(*cacheEntry).resolved)
+	lru.Remove(elem)
+}
+
+// lookup returns the domain of a resolved IP or CNAME, if it has not expired.
+// Must be called with the lock held.
+func lookup(resolved string) (host string, found bool) {
+	if entry := lookupEntry(resolved); entry != nil {
+		return entry.hostname, true
+	}
+	return "", false
+}
+
+func lookupEntry(resolved string) *cacheEntry {
+	elem, found := responses[resolved]
+	if !found {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		removeEntry(elem)
+		counters.Expired++
+		return nil
+	}
+	lru.MoveToFront(elem)
+	return entry
+}
+
 // Host returns if a resolved domain is in the list.
 func Host(resolved string) (host string, found bool) {
-	lock.RLock()
-	defer lock.RUnlock()
+	lock.Lock()
+	defer lock.Unlock()
 
-	host, found = responses[resolved]
+	host, found = lookup(resolved)
+	countLookup(found)
 	return
 }
 
+func countLookup(found bool) {
+	if found {
+		counters.Hits++
+	} else {
+		counters.Misses++
+	}
+}
+
 // HostOr checks if an IP has a domain name already resolved.
 // If the domain is in the list it's returned, otherwise the IP will be returned.
 func HostOr(ip net.IP, or string) string {
-	if host, found := Host(ip.String()); found == true {
-		// host might have been CNAME; go back until we reach the "root"
-		seen := make(map[string]bool) // prevent possibility of loops
-		for {
-			orig, had := Host(host)
-			if seen[orig] {
-				break
-			}
-			if !had {
-				break
-			}
-			seen[orig] = true
-			host = orig
-		}
-		return host
+	if chain := HostChain(ip); len(chain) > 0 {
+		return chain[len(chain)-1]
 	}
 	return or
 }
+
+// HostChain returns the names an IP has been resolved through, from the name
+// of the IP up to the domain requested, following the CNAMEs:
+// 1.2.3.4 -> [cdn.example.net, tracking.example.com]
+func HostChain(ip net.IP) (chain []string) {
+	chain, _, _ = HostChainResolver(ip)
+	return chain
+}
+
+// HostChainResolver returns the names an IP has been resolved through, the
+// IP of the DNS server that resolved it, if known, and if it's trusted.
+func HostChainResolver(ip net.IP) (chain []string, resolver string, trusted bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry := lookupEntry(ip.String())
+	countLookup(entry != nil)
+	if entry == nil {
+		if host, found := lookupHosts(ip.String()); found {
+			return []string{host}, "", true
+		}
+		return nil, "", true
+	}
+	host, resolver := entry.hostname, entry.resolver
+	if entry.encrypted {
+		trusted = TrustedEncryptedResolver(resolver)
+	} else {
+		trusted = TrustedResolver(resolver)
+	}
+	chain = append(chain, host)
+	// host might have been CNAME; go back until we reach the "root"
+	seen := map[string]bool{host: true} // prevent possibility of loops
+	for {
+		orig, had := lookup(host)
+		if !had || seen[orig] {
+			break
+		}
+		seen[orig] = true
+		chain = append(chain, orig)
+		host = orig
+	}
+	return chain, resolver, trusted
+}
+
+// GetCacheStats returns the counters of the cache of domains resolved.
+func GetCacheStats() CacheStats {
+	lock.Lock()
+	defer lock.Unlock()
+
+	stats := counters
+	stats.Entries = uint64(lru.Len())
+	return stats
+}
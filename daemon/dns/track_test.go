@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func resetCache() {
+	lock.Lock()
+	defer lock.Unlock()
+	responses = make(map[string]*list.Element)
+	lru = list.New()
+	counters = CacheStats{}
+}
+
+func answerPacket(t *testing.T, srcPort layers.UDPPort, answers, additionals []layers.DNSResourceRecord) gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("192.168.1.2")}
+	udp := &layers.UDP{SrcPort: srcPort, DstPort: 40000}
+	udp.SetNetworkLayerForChecksum(ip)
+	msg := &layers.DNS{ID: 1, QR: true, Answers: answers, Additionals: additionals}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, udp, msg); err != nil {
+		t.Fatalf("failed to serialize the packet: %s", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+}
+
+func aRecord(name, ip string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 120, IP: net.ParseIP(ip).To4()}
+}
+
+func TestTrackAnswersLocalProtocols(t *testing.T) {
+	tests := []struct {
+		name        string
+		port        layers.UDPPort
+		answers     []layers.DNSResourceRecord
+		additionals []layers.DNSResourceRecord
+		tracked     map[string]string
+	}{
+		{
+			"DNS", portDNS,
+			[]layers.DNSResourceRecord{aRecord("www.example.com", "1.1.1.1"), aRecord("printer.local", "1.1.1.2")},
+			nil,
+			map[string]string{"1.1.1.1": "www.example.com", "1.1.1.2": "printer.local"},
+		},
+		{
+			"mDNS", portMDNS,
+			[]layers.DNSResourceRecord{aRecord("printer.local", "1.1.1.1"), aRecord("www.example.com", "1.1.1.2"), aRecord("local.example.com", "1.1.1.3")},
+			[]layers.DNSResourceRecord{aRecord("nas.LOCAL", "1.1.1.4"), aRecord("www.bank.com", "1.1.1.5"), aRecord("4.1.1.1.in-addr.arpa", "1.1.1.6")},
+			map[string]string{"1.1.1.1": "printer.local", "1.1.1.4": "nas.LOCAL", "1.1.1.6": "4.1.1.1.in-addr.arpa"},
+		},
+		{
+			"LLMNR", portLLMNR,
+			[]layers.DNSResourceRecord{aRecord("printer", "1.1.1.1"), aRecord("www.example.com", "1.1.1.2"), aRecord("nas", "1.1.1.3")},
+			// the additional records are only tracked from mDNS.
+			[]layers.DNSResourceRecord{aRecord("server", "1.1.1.4")},
+			map[string]string{"1.1.1.1": "printer", "1.1.1.3": "nas"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetCache()
+			if !TrackAnswers(answerPacket(t, test.port, test.answers, test.additionals)) {
+				t.Fatal("the response has not been tracked")
+			}
+			for i := 1; i <= 6; i++ {
+				ip := net.IPv4(1, 1, 1, byte(i)).String()
+				host, found := Host(ip)
+				if want, tracked := test.tracked[ip]; found != tracked || host != want {
+					t.Errorf("%s: resolved to %q (%v), want %q (%v)", ip, host, found, want, tracked)
+				}
+			}
+		})
+	}
+	resetCache()
+}
+
+func withMaxEntries(max int) func() {
+	saved := maxEntries
+	maxEntries = max
+	return func() { maxEntries = saved }
+}
+
+func TestTrackEvictionOrder(t *testing.T) {
+	defer withMaxEntries(3)()
+	resetCache()
+	defer resetCache()
+
+	TrackWithTTL("1.1.1.1", "a.example.com", time.Hour)
+	TrackWithTTL("1.1.1.2", "b.example.com", time.Hour)
+	TrackWithTTL("1.1.1.3", "c.example.com", time.Hour)
+	// looking up or updating an entry makes it the most recently used one.
+	if _, found := Host("1.1.1.1"); !found {
+		t.Fatal("1.1.1.1 not found")
+	}
+	TrackWithTTL("1.1.1.2", "b2.example.com", time.Hour)
+	TrackWithTTL("1.1.1.4", "d.example.com", time.Hour)
+	TrackWithTTL("1.1.1.5", "e.example.com", time.Hour)
+
+	tests := []struct {
+		ip    string
+		host  string
+		found bool
+	}{
+		{"1.1.1.1", "", false},
+		{"1.1.1.2", "b2.example.com", true},
+		{"1.1.1.3", "", false},
+		{"1.1.1.4", "d.example.com", true},
+		{"1.1.1.5", "e.example.com", true},
+	}
+	// the oldest entries are evicted first: 1.1.1.3, and then 1.1.1.1.
+	stats := GetCacheStats()
+	if stats.Evicted != 2 {
+		t.Errorf("evicted %d entries, want 2", stats.Evicted)
+	}
+	for _, test := range tests {
+		if host, found := Host(test.ip); found != test.found || host != test.host {
+			t.Errorf("%s: resolved to %q (%v), want %q (%v)", test.ip, host, found, test.host, test.found)
+		}
+	}
+}
+
+func TestTrackTTL(t *testing.T) {
+	resetCache()
+	defer resetCache()
+
+	TrackWithTTL("1.1.1.1", "www.example.com", time.Hour)
+	TrackWithTTL("1.1.1.2", "short.example.com", time.Second)
+
+	lock.Lock()
+	if expires := responses["1.1.1.2"].Value.(*cacheEntry).expires; time.Until(expires) < minTTL-time.Second {
+		t.Errorf("the TTL has not been raised to the minimum: %s", time.Until(expires))
+	}
+	responses["1.1.1.1"].Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+	lock.Unlock()
+
+	if host, found := Host("1.1.1.1"); found {
+		t.Errorf("expired entry found: %s", host)
+	}
+	if _, found := Host("1.1.1.2"); !found {
+		t.Error("1.1.1.2 not found")
+	}
+	stats := GetCacheStats()
+	if stats.Entries != 1 || stats.Expired != 1 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// tracking the domain again renews the entry.
+	TrackWithTTL("1.1.1.1", "www.example.com", time.Hour)
+	if host, found := Host("1.1.1.1"); !found || host != "www.example.com" {
+		t.Errorf("1.1.1.1 resolved to %q (%v)", host, found)
+	}
+}
+
+func TestTrackCapacity(t *testing.T) {
+	defer withMaxEntries(10)()
+	resetCache()
+	defer resetCache()
+
+	for i := 1; i <= 100; i++ {
+		TrackWithTTL(net.IPv4(1, 1, 1, byte(i)).String(), fmt.Sprint("host", i), time.Hour)
+		if stats := GetCacheStats(); stats.Entries > uint64(maxEntries) {
+			t.Fatalf("%d entries in the cache, max %d", stats.Entries, maxEntries)
+		}
+	}
+
+	lock.Lock()
+	entries, elements := len(responses), lru.Len()
+	lock.Unlock()
+	if entries != maxEntries || elements != maxEntries {
+		t.Errorf("%d responses and %d LRU elements, want %d", entries, elements, maxEntries)
+	}
+	if stats := GetCacheStats(); stats.Evicted != 90 {
+		t.Errorf("evicted %d entries, want 90", stats.Evicted)
+	}
+	for i := 91; i <= 100; i++ {
+		if host, found := Host(net.IPv4(1, 1, 1, byte(i)).String()); !found || host != fmt.Sprint("host", i) {
+			t.Errorf("1.1.1.%d resolved to %q (%v)", i, host, found)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package firewall
+
+import (
+	"github.com/evilsocket/opensnitch/daemon/firewall/iptables"
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables"
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// Coexistence modes of the firewall backends.
+// The rules of iptables-legacy and nftables (including iptables-nft) are
+// evaluated independently, so if the system already has rules of one of them,
+// using the other one may block connections allowed by us, or allow
+// connections blocked by the system.
+const (
+	// use the configured firewall, even if the system uses the other one.
+	CoexistAllow = "allow"
+	// use the firewall that the system is using, in order not to mix backends.
+	CoexistStrict = "strict"
+)
+
+var coexistence = CoexistAllow
+
+// BackendInfo describes the firewall in use.
+type BackendInfo struct {
+	Name string
+	// variant of iptables (legacy, nf_tables).
+	Variant     string
+	Coexistence string
+	Running     bool
+}
+
+// SetCoexistence configures how to behave when the system uses a different
+// firewall backend than the configured one.
+// It's applied the next time the firewall is initialized.
+func SetCoexistence(mode string) {
+	switch mode {
+	case CoexistStrict:
+		coexistence = CoexistStrict
+	case "", CoexistAllow:
+		coexistence = CoexistAllow
+	default:
+		log.Warning("Invalid firewall coexistence mode: %s, using %s", mode, CoexistAllow)
+		coexistence = CoexistAllow
+	}
+}
+
+// checkCoexistence returns the firewall to use, given the configured one.
+func checkCoexistence(fwType string) string {
+	if coexistence != CoexistStrict {
+		return fwType
+	}
+	legacyInUse := iptables.CountRules(iptables.VariantLegacy) > 0
+
+	switch fwType {
+	case nftables.Name:
+		if legacyInUse {
+			return iptables.Name
+		}
+	case iptables.Name:
+		// iptables chooses the variant in use (see iptables.DetectVariant()),
+		// but if only the legacy one is available it cannot be mixed with nftables.
+		if !legacyInUse && iptables.GetVariant("iptables") == iptables.VariantLegacy && nftables.IsInUse() {
+			return nftables.Name
+		}
+	}
+
+	return fwType
+}
+
+// Backend returns the firewall in use.
+func Backend() *BackendInfo {
+	info := &BackendInfo{Coexistence: coexistence}
+	if fw == nil {
+		return info
+	}
+	info.Name = fw.Name()
+	info.Running = fw.IsRunning()
+	if ipt, ok := fw.(*iptables.Iptables); ok {
+		info.Variant = ipt.Variant()
+	}
+
+	return info
+}
@@ -18,6 +18,10 @@ var (
 	ReloadConf     = true
 )
 
+// DNSPorts are the source ports of the DNS responses intercepted: DNS, mDNS
+// and LLMNR.
+const DNSPorts = "53,5353,5355"
+
 type (
 	callback     func()
 	callbackBool func() bool
@@ -31,6 +35,10 @@ type (
 		Running      bool
 		Intercepting bool
 		FwEnabled    bool
+		// network interfaces where connections are intercepted (all if empty),
+		// and network interfaces excluded from interception.
+		Interfaces     []string
+		SkipInterfaces []string
 		sync.RWMutex
 	}
 )
@@ -47,6 +55,26 @@ func (c *Common) SetQueueNum(qNum *int) {
 
 }
 
+// SetInterfaces sets the network interfaces where connections are intercepted.
+// If the list of interfaces is empty, connections are intercepted on all the
+// interfaces, except on the ones of the skip list.
+func (c *Common) SetInterfaces(ifaces, skipIfaces []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Interfaces = ifaces
+	c.SkipInterfaces = skipIfaces
+}
+
+// GetInterfaces returns the network interfaces where connections are
+// intercepted, and the ones excluded from interception.
+func (c *Common) GetInterfaces() (ifaces, skipIfaces []string) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.Interfaces, c.SkipInterfaces
+}
+
 // IsRunning returns if the firewall is running or not.
 func (c *Common) IsRunning() bool {
 	c.RLock()
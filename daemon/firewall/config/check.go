@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConfigError describes an error found while validating the configuration.
+// Path is the field of the configuration that is not valid, for example:
+// SystemRules[0].Chains[1].Rules[2].Expressions[0]
+type ConfigError struct {
+	Line int
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	msg := e.Err.Error()
+	if e.Path != "" {
+		msg = fmt.Sprintf("%s: %s", e.Path, msg)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d, %s", e.Line, msg)
+	}
+	return msg
+}
+
+// ParseConfiguration parses the given configuration, without loading it.
+// Syntax errors are returned as a *ConfigError, with the line of the error.
+func ParseConfiguration(rawConfig []byte) (*SystemConfig, error) {
+	cfg := &SystemConfig{}
+	err := json.Unmarshal(rawConfig, cfg)
+	switch e := err.(type) {
+	case nil:
+		return cfg, nil
+	case *json.SyntaxError:
+		return nil, &ConfigError{Line: getLine(rawConfig, e.Offset), Err: err}
+	case *json.UnmarshalTypeError:
+		return nil, &ConfigError{Line: getLine(rawConfig, e.Offset), Path: e.Field, Err: err}
+	}
+	return nil, &ConfigError{Err: err}
+}
+
+// SetErrorsLines sets the line of the configuration where each error is located.
+// If a field is not present in the configuration, the line of the closest
+// parent field is used.
+func SetErrorsLines(rawConfig []byte, errs []*ConfigError) {
+	offsets := getPathsOffsets(rawConfig)
+	for _, e := range errs {
+		path := strings.ToLower(e.Path)
+		for {
+			if off, found := offsets[path]; found {
+				e.Line = getLine(rawConfig, off)
+				break
+			}
+			idx := strings.LastIndexAny(path, ".[")
+			if idx == -1 {
+				break
+			}
+			path = path[:idx]
+		}
+	}
+}
+
+// getLine returns the line number of the given offset.
+func getLine(raw []byte, offset int64) int {
+	if offset > int64(len(raw)) {
+		offset = int64(len(raw))
+	}
+	return bytes.Count(raw[:offset], []byte("\n")) + 1
+}
+
+// getPathsOffsets returns the offset of every field of the configuration.
+// The keys are lowercased, because field names are case-insensitive.
+func getPathsOffsets(raw []byte) map[string]int64 {
+	offsets := make(map[string]int64)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	walkJSON(dec, "", offsets)
+	return offsets
+}
+
+func walkJSON(dec *json.Decoder, path string, offsets map[string]int64) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	offsets[path] = dec.InputOffset()
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			field := strings.ToLower(fmt.Sprint(key))
+			if path != "" {
+				field = path + "." + field
+			}
+			if err := walkJSON(dec, field, offsets); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := walkJSON(dec, fmt.Sprintf("%s[%d]", path, i), offsets); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+
+	return err
+}
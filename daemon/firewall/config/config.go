@@ -89,7 +89,9 @@ type FwChain struct {
 	Type        string
 	Hook        string
 	Policy      string
-	Rules       []*FwRule
+	// network interface of the ingress and egress hooks.
+	Device string
+	Rules  []*FwRule
 }
 
 // IsInvalid checks if the chain has been correctly configured.
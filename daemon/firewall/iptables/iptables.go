@@ -3,6 +3,7 @@ package iptables
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -57,8 +58,9 @@ type Iptables struct {
 	config.Config
 	common.Common
 
-	bin  string
-	bin6 string
+	bin     string
+	bin6    string
+	variant string
 
 	regexRulesQuery       *regexp.Regexp
 	regexSystemRulesQuery *regexp.Regexp
@@ -77,9 +79,11 @@ func Fw() (*Iptables, error) {
 	reRulesQuery, _ := regexp.Compile(`NFQUEUE.*ctstate NEW,RELATED.*NFQUEUE num.*bypass`)
 	reSystemRulesQuery, _ := regexp.Compile(SystemRulePrefix + ".*")
 
+	bin, bin6, variant := DetectVariant()
 	ipt := &Iptables{
-		bin:                   "iptables",
-		bin6:                  "ip6tables",
+		bin:                   bin,
+		bin6:                  bin6,
+		variant:               variant,
 		regexRulesQuery:       reRulesQuery,
 		regexSystemRulesQuery: reSystemRulesQuery,
 		chains: SystemChains{
@@ -94,11 +98,16 @@ func (ipt *Iptables) Name() string {
 	return Name
 }
 
+// Variant returns the variant of iptables in use (legacy, nf_tables).
+func (ipt *Iptables) Variant() string {
+	return ipt.variant
+}
+
 // Init inserts the firewall rules and starts monitoring for firewall
 // changes.
-func (ipt *Iptables) Init(qNum *int) {
+func (ipt *Iptables) Init(qNum *int) error {
 	if ipt.IsRunning() {
-		return
+		return nil
 	}
 	ipt.SetQueueNum(qNum)
 
@@ -114,6 +123,7 @@ func (ipt *Iptables) Init(qNum *int) {
 	ipt.AddSystemRules(!common.ReloadRules, common.BackupChains)
 
 	ipt.Running = true
+	return nil
 }
 
 // Stop deletes the firewall rules, allowing network traffic.
@@ -199,3 +209,29 @@ func (ipt *Iptables) Deserialize(sysfw *protocol.SysFirewall) ([]byte, error) {
 
 	//return nil, fmt.Errorf("iptables.Deserialize() not implemented")
 }
+
+// SetInterfaces sets the network interfaces where connections are intercepted.
+// Not supported on iptables, connections are intercepted on all the interfaces.
+func (ipt *Iptables) SetInterfaces(ifaces, skipIfaces []string) {
+	if len(ifaces) > 0 || len(skipIfaces) > 0 {
+		log.Warning("Intercepting connections on specific interfaces is not supported on iptables.")
+	}
+}
+
+// GetCounters returns the counters of our chains.
+// Named counters are not supported on iptables.
+func (ipt *Iptables) GetCounters() []*protocol.FirewallCounter {
+	return nil
+}
+
+// GetRulesCounters returns the counters of our rules.
+// Not supported on iptables, our rules cannot be identified.
+func (ipt *Iptables) GetRulesCounters() []*protocol.FirewallCounter {
+	return nil
+}
+
+// GetFirewallRules returns the rules we manage, as they're loaded in the system.
+// Not supported on iptables.
+func (ipt *Iptables) GetFirewallRules() (*protocol.SysFirewall, error) {
+	return nil, fmt.Errorf("listing the loaded rules is not supported on iptables")
+}
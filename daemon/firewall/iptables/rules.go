@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/firewall/common"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/vishvananda/netlink"
 )
@@ -41,12 +42,13 @@ func (ipt *Iptables) RunRule(action Action, enable bool, logError bool, rule []s
 
 // QueueDNSResponses redirects DNS responses to us, in order to keep a cache
 // of resolved domains.
-// INPUT --protocol udp --sport 53 -j NFQUEUE --queue-num 0 --queue-bypass
+// INPUT --protocol udp -m multiport --sports 53,5353,5355 -j NFQUEUE --queue-num 0 --queue-bypass
 func (ipt *Iptables) QueueDNSResponses(enable bool, logError bool) (err4, err6 error) {
 	return ipt.RunRule(INSERT, enable, logError, []string{
 		"INPUT",
 		"--protocol", "udp",
-		"--sport", "53",
+		"-m", "multiport",
+		"--sports", common.DNSPorts,
 		"-j", "NFQUEUE",
 		"--queue-num", fmt.Sprintf("%d", ipt.QueueNum),
 		"--queue-bypass",
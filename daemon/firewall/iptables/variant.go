@@ -0,0 +1,89 @@
+package iptables
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// iptables has two variants: legacy, which uses the x_tables kernel API,
+// and nf_tables, which translates the rules to nftables.
+// Both variants can be installed at the same time, and the rules added with
+// one of them are not visible to the other, so we need to use the variant that
+// the system is actually using. Otherwise the rules of both variants would be
+// evaluated, and a connection allowed by us could be blocked by the other.
+const (
+	VariantLegacy = "legacy"
+	VariantNft    = "nf_tables"
+)
+
+// GetVariant returns the variant of the given iptables binary.
+// iptables v1.8.7 (nf_tables)
+// iptables v1.8.7 (legacy)
+// Versions older than 1.8 only support the legacy variant.
+func GetVariant(bin string) string {
+	out, err := core.Exec(bin, []string{"-V"})
+	if err != nil {
+		return ""
+	}
+	if strings.Contains(out, "("+VariantNft+")") {
+		return VariantNft
+	}
+	return VariantLegacy
+}
+
+// getVariantBins returns the binaries of the given variant.
+func getVariantBins(variant string) (bin, bin6 string) {
+	if variant == VariantNft {
+		return "iptables-nft", "ip6tables-nft"
+	}
+	return "iptables-" + VariantLegacy, "ip6tables-" + VariantLegacy
+}
+
+// CountRules returns the number of rules loaded with the given variant.
+// It returns -1 if the variant is not installed.
+func CountRules(variant string) int {
+	bin, bin6 := getVariantBins(variant)
+	total := -1
+	for _, b := range []string{bin, bin6} {
+		if _, err := exec.LookPath(b + "-save"); err != nil {
+			continue
+		}
+		out, err := core.Exec(b+"-save", []string{})
+		if err != nil {
+			continue
+		}
+		if total == -1 {
+			total = 0
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "-A ") {
+				total++
+			}
+		}
+	}
+
+	return total
+}
+
+// DetectVariant returns the iptables binaries and the variant to use.
+// By default the variant of the iptables binary is used, unless it has no
+// rules loaded, and the other variant has.
+func DetectVariant() (bin, bin6, variant string) {
+	bin, bin6 = "iptables", "ip6tables"
+	variant = GetVariant(bin)
+
+	other := VariantNft
+	if variant == VariantNft {
+		other = VariantLegacy
+	}
+	if CountRules(variant) <= 0 && CountRules(other) > 0 {
+		bin, bin6 = getVariantBins(other)
+		log.Warning("iptables: the system is using %s instead of iptables (%s)", bin, variant)
+		variant = other
+	}
+
+	return bin, bin6, variant
+}
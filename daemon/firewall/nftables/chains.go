@@ -7,6 +7,9 @@ import (
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 // getChainKey returns the identifier that will be used to link chains and rules.
@@ -69,6 +72,51 @@ func (n *Nft) AddChain(name, table, family string, priority *nftables.ChainPrior
 	}
 
 	sysChains.Store(key, chain)
+	sysRuleset.Added(tbl, unix.NFT_MSG_NEWCHAIN, chain.Name, 0)
+	n.addChainCounter(chain, false)
+	return chain
+}
+
+// addNetdevChain adds a new chain attached to a network interface, on the
+// ingress or egress hooks. The packets are filtered before reaching the IP stack.
+// The nftables lib doesn't support the device of the hooks, so we build the
+// netlink message ourselves (see netlink.go).
+// nft add chain netdev filter ingress { type filter hook ingress device eth0 priority 0; policy accept; }
+func (n *Nft) addNetdevChain(name, table, family, device string, priority *nftables.ChainPriority, ctype nftables.ChainType, hook *nftables.ChainHook, policy nftables.ChainPolicy) *nftables.Chain {
+	tbl := n.getTable(table, family)
+	if tbl == nil {
+		log.Error("%s addNetdevChain, Error getting table: %s, %s", logTag, table, family)
+		return nil
+	}
+	chain := &nftables.Chain{
+		Name:     strings.ToLower(name),
+		Table:    tbl,
+		Type:     ctype,
+		Hooknum:  hook,
+		Priority: priority,
+		Policy:   &policy,
+	}
+
+	req := newNftMsg(unix.NFT_MSG_NEWCHAIN, unix.NLM_F_CREATE, tbl)
+	req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_TABLE, nl.ZeroTerminated(tbl.Name)))
+	req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_NAME, nl.ZeroTerminated(chain.Name)))
+	hookAttr := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_CHAIN_HOOK, nil)
+	hookAttr.AddRtAttr(unix.NFTA_HOOK_HOOKNUM, binaryutil.BigEndian.PutUint32(uint32(getKernelHook(tbl.Family, *hook))))
+	hookAttr.AddRtAttr(unix.NFTA_HOOK_PRIORITY, binaryutil.BigEndian.PutUint32(uint32(*priority)))
+	hookAttr.AddRtAttr(unix.NFTA_HOOK_DEV, nl.ZeroTerminated(device))
+	req.AddData(hookAttr)
+	req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_POLICY, binaryutil.BigEndian.PutUint32(uint32(policy))))
+	req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_TYPE, nl.ZeroTerminated(string(ctype))))
+
+	// the table may be queued in the current batch.
+	if err := n.queueRaw(req); err != nil {
+		log.Error("%s error adding netdev chain %s, device %s: %s", logTag, name, device, err)
+		return nil
+	}
+
+	sysChains.Store(getChainKey(chain.Name, tbl), chain)
+	sysRuleset.Added(tbl, unix.NFT_MSG_NEWCHAIN, chain.Name, 0)
+	n.addChainCounter(chain, false)
 	return chain
 }
 
@@ -101,50 +149,33 @@ func (n *Nft) addRegularChain(name, table, family string) error {
 	}
 	key := getChainKey(name, tbl)
 	sysChains.Store(key, chain)
+	sysRuleset.Added(tbl, unix.NFT_MSG_NEWCHAIN, chain.Name, 0)
+	n.addChainCounter(chain, false)
 
 	return nil
 }
 
 func (n *Nft) addInterceptionChains() error {
-	var filterPolicy nftables.ChainPolicy
-	var manglePolicy nftables.ChainPolicy
-	filterPolicy = nftables.ChainPolicyAccept
-	manglePolicy = nftables.ChainPolicyAccept
-
-	tbl := n.getTable(exprs.NFT_CHAIN_FILTER, exprs.NFT_FAMILY_INET)
-	if tbl != nil {
-		key := getChainKey(exprs.NFT_HOOK_INPUT, tbl)
-		ch, found := sysChains.Load(key)
-		if key != "" && found {
-			filterPolicy = *ch.(*nftables.Chain).Policy
-		}
-	}
-	tbl = n.getTable(exprs.NFT_CHAIN_MANGLE, exprs.NFT_FAMILY_INET)
-	if tbl != nil {
-		key := getChainKey(exprs.NFT_HOOK_OUTPUT, tbl)
-		ch, found := sysChains.Load(key)
-		if key != "" && found {
-			manglePolicy = *ch.(*nftables.Chain).Policy
-		}
-	}
+	filterPolicy, filterPrio := n.getInterceptionChainOpts(exprs.NFT_HOOK_INPUT, exprs.NFT_CHAIN_FILTER, nftables.ChainPriorityFilter)
+	manglePolicy, manglePrio := n.getInterceptionChainOpts(exprs.NFT_HOOK_OUTPUT, exprs.NFT_CHAIN_MANGLE, nftables.ChainPriorityMangle)
 
 	// nft list tables
 	n.AddChain(exprs.NFT_HOOK_INPUT, exprs.NFT_CHAIN_FILTER, exprs.NFT_FAMILY_INET,
-		nftables.ChainPriorityFilter, nftables.ChainTypeFilter, nftables.ChainHookInput, filterPolicy)
-	if !n.Commit() {
+		filterPrio, nftables.ChainTypeFilter, nftables.ChainHookInput, filterPolicy)
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("Error adding DNS interception chain input-filter-inet")
 	}
 	n.AddChain(exprs.NFT_HOOK_OUTPUT, exprs.NFT_CHAIN_MANGLE, exprs.NFT_FAMILY_INET,
-		nftables.ChainPriorityMangle, nftables.ChainTypeRoute, nftables.ChainHookOutput, manglePolicy)
-	if !n.Commit() {
+		manglePrio, nftables.ChainTypeRoute, nftables.ChainHookOutput, manglePolicy)
+	if n.Commit() == CommitFailed {
 		log.Error("(1) Error adding interception chain mangle-output-inet, trying with type Filter instead of Route")
 
 		// Workaround for kernels 4.x and maybe others.
 		// @see firewall/nftables/utils.go:getChainPriority()
-		chainPrio, chainType := getChainPriority(exprs.NFT_FAMILY_INET, exprs.NFT_CHAIN_MANGLE, exprs.NFT_HOOK_OUTPUT)
+		_, chainType := getChainPriority(exprs.NFT_FAMILY_INET, exprs.NFT_CHAIN_MANGLE, exprs.NFT_HOOK_OUTPUT)
 		n.AddChain(exprs.NFT_HOOK_OUTPUT, exprs.NFT_CHAIN_MANGLE, exprs.NFT_FAMILY_INET,
-			chainPrio, chainType, nftables.ChainHookOutput, manglePolicy)
-		if !n.Commit() {
+			manglePrio, chainType, nftables.ChainHookOutput, manglePolicy)
+		if n.Commit() == CommitFailed {
 			return fmt.Errorf("(2) Error adding interception chain mangle-output-inet with type Filter. Report it on github please, specifying the distro and the kernel")
 		}
 	}
@@ -152,10 +183,37 @@ func (n *Nft) addInterceptionChains() error {
 	return nil
 }
 
+// getInterceptionChainOpts returns the policy and priority of an interception chain.
+// If the user has configured the same chain in the system firewall, its policy
+// and priority are used, otherwise the chain is created with policy accept and
+// the default priority. The kernel doesn't allow to change the priority of
+// an existing base chain, so both chains must have the same priority.
+func (n *Nft) getInterceptionChainOpts(name, table string, defPrio *nftables.ChainPriority) (nftables.ChainPolicy, *nftables.ChainPriority) {
+	policy := nftables.ChainPolicyAccept
+	prio := defPrio
+
+	tbl := n.getTable(table, exprs.NFT_FAMILY_INET)
+	if tbl == nil {
+		return policy, prio
+	}
+	if ch, found := sysChains.Load(getChainKey(name, tbl)); found {
+		chain := ch.(*nftables.Chain)
+		if chain.Policy != nil {
+			policy = *chain.Policy
+		}
+		if chain.Priority != nil {
+			prio = chain.Priority
+		}
+	}
+
+	return policy, prio
+}
+
 func (n *Nft) delChain(chain *nftables.Chain) error {
 	n.conn.DelChain(chain)
+	n.delChainCounters(chain)
 	sysChains.Delete(getChainKey(chain.Name, chain.Table))
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("[nftables] error deleting chain %s, %s", chain.Name, chain.Table.Name)
 	}
 
@@ -0,0 +1,214 @@
+package nftables
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	counterPrefix = "opensnitch-counter"
+	// suffix of the counters that count the traffic sent to the daemon.
+	counterInterceptedSuffix = "intercepted"
+)
+
+// chainCounter holds a named counter added to one of our chains.
+type chainCounter struct {
+	obj         *nftables.CounterObj
+	chain       string
+	intercepted bool
+}
+
+// store of named counters added to the system.
+// Every chain we create has a counter, that counts the traffic matched by the
+// system rules of that chain (i.e.: traffic that is not sent to the daemon).
+// The interception chains have also a counter for the traffic intercepted.
+type sysCountersT struct {
+	counters map[string]*chainCounter
+	sync.RWMutex
+}
+
+func (c *sysCountersT) Add(key string, counter *chainCounter) {
+	c.Lock()
+	defer c.Unlock()
+	c.counters[key] = counter
+}
+
+func (c *sysCountersT) Get(key string) *chainCounter {
+	c.RLock()
+	defer c.RUnlock()
+	return c.counters[key]
+}
+
+// List returns a copy of the counters, so they can be deleted while iterating it.
+func (c *sysCountersT) List() map[string]*chainCounter {
+	c.RLock()
+	defer c.RUnlock()
+	list := make(map[string]*chainCounter, len(c.counters))
+	for k, v := range c.counters {
+		list[k] = v
+	}
+	return list
+}
+
+func (c *sysCountersT) Del(key string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.counters, key)
+}
+
+// getCounterName returns the name of the counter of the given chain.
+// Counters belong to a table, so the name must be unique per table.
+func getCounterName(chain string, intercepted bool) string {
+	if intercepted {
+		return fmt.Sprint(counterPrefix, "-", chain, "-", counterInterceptedSuffix)
+	}
+	return fmt.Sprint(counterPrefix, "-", chain)
+}
+
+// getObjKey returns the key of a stateful object (counter, quota).
+// Objects belong to a table, so the name is unique per table.
+func getObjKey(name string, table *nftables.Table) string {
+	return fmt.Sprintf("%s-%s-%d", name, table.Name, table.Family)
+}
+
+// addChainCounter queues a new named counter for the given chain.
+// If the counter already exists it's reused, keeping its values.
+// nft add counter inet filter opensnitch-counter-input
+func (n *Nft) addChainCounter(chain *nftables.Chain, intercepted bool) *nftables.CounterObj {
+	name := getCounterName(chain.Name, intercepted)
+	key := getObjKey(name, chain.Table)
+	if counter := sysCounters.Get(key); counter != nil {
+		return counter.obj
+	}
+
+	obj := &nftables.CounterObj{
+		Table: chain.Table,
+		Name:  name,
+	}
+	n.conn.AddObj(obj)
+	sysCounters.Add(key, &chainCounter{
+		obj:         obj,
+		chain:       chain.Name,
+		intercepted: intercepted,
+	})
+	sysRuleset.Added(chain.Table, unix.NFT_MSG_NEWOBJ, name, exprs.NFT_OBJECT_COUNTER)
+
+	return obj
+}
+
+// getCounterExpr returns the expression to update the counter of the given chain.
+// nft add rule inet filter input udp sport 53 counter name opensnitch-counter-input-intercepted queue num 0 bypass
+func (n *Nft) getCounterExpr(chain *nftables.Chain, intercepted bool) *[]expr.Any {
+	obj := n.addChainCounter(chain, intercepted)
+	return exprs.NewExprCounter(obj.Name)
+}
+
+// delChainCounters queues the deletion of the counters of the given chain.
+// It must be called after deleting the rules that reference them.
+func (n *Nft) delChainCounters(chain *nftables.Chain) {
+	for _, intercepted := range []bool{false, true} {
+		key := getObjKey(getCounterName(chain.Name, intercepted), chain.Table)
+		if counter := sysCounters.Get(key); counter != nil {
+			n.conn.DeleteObject(counter.obj)
+			sysCounters.Del(key)
+		}
+	}
+}
+
+// delTableCounters removes from the store the counters of the given table.
+// The counters are deleted from the system along with the table.
+func delTableCounters(tbl *nftables.Table) {
+	for k, counter := range sysCounters.List() {
+		if counter.obj.Table.Name == tbl.Name && counter.obj.Table.Family == tbl.Family {
+			sysCounters.Del(k)
+		}
+	}
+}
+
+// GetCounters returns the current values of the counters of our chains.
+func (n *Nft) GetCounters() []*protocol.FirewallCounter {
+	n.Lock()
+	defer n.Unlock()
+
+	fwCounters := []*protocol.FirewallCounter{}
+	if n.conn == nil {
+		return fwCounters
+	}
+	for k, counter := range sysCounters.List() {
+		obj, err := n.conn.GetObject(counter.obj)
+		if err != nil {
+			log.Debug("%s error getting counter %s: %s", logTag, k, err)
+			continue
+		}
+		cnt, ok := obj.(*nftables.CounterObj)
+		if !ok {
+			continue
+		}
+		fwCounters = append(fwCounters, &protocol.FirewallCounter{
+			Table:       counter.obj.Table.Name,
+			Chain:       counter.chain,
+			Family:      getFamilyName(counter.obj.Table.Family),
+			Intercepted: counter.intercepted,
+			Packets:     cnt.Packets,
+			Bytes:       cnt.Bytes,
+		})
+	}
+
+	return fwCounters
+}
+
+// GetRulesCounters returns the packets and bytes matched by every rule we added,
+// identified by the UUID stored in the metadata of the rule (see userdata.go).
+// The values are read from the anonymous counter of the rules.
+func (n *Nft) GetRulesCounters() []*protocol.FirewallCounter {
+	n.Lock()
+	defer n.Unlock()
+
+	fwCounters := []*protocol.FirewallCounter{}
+	if n.conn == nil {
+		return fwCounters
+	}
+	chains, err := n.conn.ListChains()
+	if err != nil {
+		log.Debug("%s error listing chains: %s", logTag, err)
+		return fwCounters
+	}
+	for _, c := range chains {
+		rules, err := n.conn.GetRule(c.Table, c)
+		if err != nil {
+			log.Debug("%s error listing rules of %s, %s: %s", logTag, c.Name, c.Table.Name, err)
+			continue
+		}
+		for _, r := range rules {
+			meta := parseRuleMeta(r.UserData)
+			if meta == nil || meta.UUID == "" {
+				continue
+			}
+			for _, e := range r.Exprs {
+				cnt, ok := e.(*expr.Counter)
+				if !ok {
+					continue
+				}
+				fwCounters = append(fwCounters, &protocol.FirewallCounter{
+					Table:       c.Table.Name,
+					Chain:       c.Name,
+					Family:      getFamilyName(c.Table.Family),
+					Intercepted: meta.Origin == interceptionRuleKey,
+					Packets:     cnt.Packets,
+					Bytes:       cnt.Bytes,
+					RuleUuid:    meta.UUID,
+				})
+				break
+			}
+		}
+	}
+
+	return fwCounters
+}
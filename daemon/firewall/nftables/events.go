@@ -0,0 +1,133 @@
+package nftables
+
+import (
+	"bytes"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Time to wait before checking the rules after receiving an event.
+// Deleting a ruleset generates a burst of events (nft flush ruleset, firewalld
+// reloading its rules, etc), so we wait until it ends before checking the rules.
+const eventsDebounceTime = 500 * time.Millisecond
+
+// startEventsMonitor listens for nftables changes made by other tools
+// (firewalld, docker, nft flush ruleset...), and restores the interception
+// rules if the tables, chains or rules we added have been deleted.
+// It complements the checker of common.NewRulesChecker(), which checks the
+// rules periodically.
+func (n *Nft) startEventsMonitor() {
+	sock, err := nl.Subscribe(unix.NETLINK_NETFILTER, unix.NFNLGRP_NFTABLES)
+	if err != nil {
+		log.Warning("%s unable to monitor nftables events: %s", logTag, err)
+		return
+	}
+	// allow to unblock Receive() periodically, to check if we need to exit.
+	if err := sock.SetReceiveTimeout(&unix.Timeval{Sec: 1}); err != nil {
+		log.Warning("%s unable to set events monitor timeout: %s", logTag, err)
+	}
+
+	n.eventsExitChan = make(chan bool, 1)
+	go n.monitorEvents(sock, n.eventsExitChan)
+}
+
+// stopEventsMonitor stops listening for nftables events.
+func (n *Nft) stopEventsMonitor() {
+	if n.eventsExitChan == nil {
+		return
+	}
+	n.eventsExitChan <- true
+	close(n.eventsExitChan)
+	n.eventsExitChan = nil
+}
+
+func (n *Nft) monitorEvents(sock *nl.NetlinkSocket, exitChan <-chan bool) {
+	defer sock.Close()
+
+	rulesDeleted := make(chan bool, 1)
+	go func() {
+		for {
+			msgs, _, err := sock.Receive()
+			select {
+			case <-exitChan:
+				close(rulesDeleted)
+				return
+			default:
+			}
+			if err != nil {
+				if err == unix.EAGAIN || err == unix.EWOULDBLOCK || err == unix.EINTR {
+					continue
+				}
+				log.Warning("%s events monitor error: %s", logTag, err)
+				close(rulesDeleted)
+				return
+			}
+			for _, msg := range msgs {
+				if isInterceptionDeleted(msg) {
+					select {
+					case rulesDeleted <- true:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	debounce := time.NewTimer(eventsDebounceTime)
+	debounce.Stop()
+	for {
+		select {
+		case _, ok := <-rulesDeleted:
+			if !ok {
+				goto Exit
+			}
+			debounce.Reset(eventsDebounceTime)
+		case <-debounce.C:
+			if !n.AreRulesLoaded() {
+				log.Important("%s interception rules deleted by other program, restoring them", logTag)
+				n.reloadRulesCallback()
+			}
+		}
+	}
+
+Exit:
+	debounce.Stop()
+	log.Debug("%s exit monitoring nftables events", logTag)
+}
+
+// isInterceptionDeleted checks if a netlink message notifies the deletion of
+// a table, chain or rule of the tables where the interception rules are added.
+func isInterceptionDeleted(msg syscall.NetlinkMessage) bool {
+	if int(msg.Header.Type>>8) != unix.NFNL_SUBSYS_NFTABLES {
+		return false
+	}
+	switch int(msg.Header.Type & 0xff) {
+	case unix.NFT_MSG_DELTABLE, unix.NFT_MSG_DELCHAIN, unix.NFT_MSG_DELRULE:
+	default:
+		return false
+	}
+
+	// skip struct nfgenmsg
+	if len(msg.Data) < 4 {
+		return false
+	}
+	attrs, err := nl.ParseRouteAttr(msg.Data[4:])
+	if err != nil {
+		return false
+	}
+	for _, attr := range attrs {
+		// NFTA_TABLE_NAME, NFTA_CHAIN_TABLE and NFTA_RULE_TABLE
+		if attr.Attr.Type != unix.NFTA_TABLE_NAME {
+			continue
+		}
+		table := string(bytes.TrimRight(attr.Value, "\x00"))
+		return table == exprs.NFT_CHAIN_FILTER || table == exprs.NFT_CHAIN_MANGLE
+	}
+
+	return false
+}
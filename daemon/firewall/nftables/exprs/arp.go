@@ -0,0 +1,140 @@
+package exprs
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// offsets of the fields of the ARP header, for ethernet and IPv4 addresses.
+// https://www.rfc-editor.org/rfc/rfc826
+const (
+	arpOffHtype     = 0
+	arpOffPtype     = 2
+	arpOffOperation = 6
+	arpOffSha       = 8
+	arpOffSpa       = 14
+	arpOffTha       = 18
+	arpOffTpa       = 24
+)
+
+// NewExprARP creates a new expression to match the fields of ARP packets.
+// {"Key": "operation", "Value": "reply"}, {"Key": "saddr-ip", "Value": "192.168.1.1"},
+// {"Key": "saddr-ether", "Value": "00:11:22:33:44:55"}
+//
+// nft add rule arp filter input arp operation reply arp saddr ip 192.168.1.1 arp saddr ether != 00:11:22:33:44:55 drop
+//
+//	[ payload load 2b @ network header + 6 => reg 1 ]
+//	[ cmp eq reg 1 0x00000200 ]
+//	[ payload load 4b @ network header + 14 => reg 1 ]
+//	[ cmp eq reg 1 0x0101a8c0 ]
+//	[ payload load 6b @ network header + 8 => reg 1 ]
+//	[ cmp neq reg 1 0x33221100 0x00005544 ]
+//
+// On families other than arp, the packet is first matched against the ARP protocol.
+func NewExprARP(family string, values []*config.ExprValues, cmpOp *expr.CmpOp) (*[]expr.Any, error) {
+	exprList := []expr.Any{}
+	if family != NFT_FAMILY_ARP {
+		exprList = append(exprList, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyPROTOCOL, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     binaryutil.BigEndian.PutUint16(unix.ETH_P_ARP),
+			},
+		}...)
+	}
+
+	for _, arp := range values {
+		var offset uint32
+		var data []byte
+		var err error
+
+		switch arp.Key {
+		case NFT_ARP_OPERATION:
+			offset = arpOffOperation
+			data, err = parseARPOperation(arp.Value)
+		case NFT_ARP_HTYPE:
+			offset = arpOffHtype
+			data, err = parseARPUint16(arp.Value)
+		case NFT_ARP_PTYPE:
+			offset = arpOffPtype
+			data, err = parseARPUint16(arp.Value)
+		case NFT_ARP_SADDR_IP, NFT_ARP_DADDR_IP:
+			offset = arpOffSpa
+			if arp.Key == NFT_ARP_DADDR_IP {
+				offset = arpOffTpa
+			}
+			ip := net.ParseIP(arp.Value).To4()
+			if ip == nil {
+				err = fmt.Errorf("invalid IPv4 address: %s", arp.Value)
+			}
+			data = ip
+		case NFT_ARP_SADDR_ETHER, NFT_ARP_DADDR_ETHER:
+			offset = arpOffSha
+			if arp.Key == NFT_ARP_DADDR_ETHER {
+				offset = arpOffTha
+			}
+			data, err = parseMACAddr(arp.Value)
+		default:
+			err = fmt.Errorf("invalid arp option: %s", arp.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		exprList = append(exprList, []expr.Any{
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       offset,
+				Len:          uint32(len(data)),
+			},
+			&expr.Cmp{
+				Op:       *cmpOp,
+				Register: 1,
+				Data:     data,
+			},
+		}...)
+	}
+
+	return &exprList, nil
+}
+
+func parseARPOperation(op string) ([]byte, error) {
+	code := uint16(0)
+	switch strings.ToLower(op) {
+	case ARP_OP_REQUEST:
+		code = 1
+	case ARP_OP_REPLY:
+		code = 2
+	case ARP_OP_RREQUEST:
+		code = 3
+	case ARP_OP_RREPLY:
+		code = 4
+	case ARP_OP_INREQUEST:
+		code = 8
+	case ARP_OP_INREPLY:
+		code = 9
+	case ARP_OP_NAK:
+		code = 10
+	default:
+		return parseARPUint16(op)
+	}
+
+	return binaryutil.BigEndian.PutUint16(code), nil
+}
+
+func parseARPUint16(value string) ([]byte, error) {
+	num, err := strconv.ParseUint(value, 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid arp value: %s", value)
+	}
+	return binaryutil.BigEndian.PutUint16(uint16(num)), nil
+}
@@ -4,11 +4,23 @@ import (
 	"github.com/google/nftables/expr"
 )
 
+// NFT_OBJECT_COUNTER is the type of the counter stateful objects.
+const NFT_OBJECT_COUNTER = 1
+
+// NewExprAnonCounter returns an anonymous counter, which counts the packets
+// and bytes matched by the rule where it's added.
+// nft add rule inet filter input tcp dport 22 counter accept
+func NewExprAnonCounter() *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Counter{},
+	}
+}
+
 // NewExprCounter returns a counter for packets or bytes.
 func NewExprCounter(counterName string) *[]expr.Any {
 	return &[]expr.Any{
 		&expr.Objref{
-			Type: 1,
+			Type: NFT_OBJECT_COUNTER,
 			Name: counterName,
 		},
 	}
@@ -51,11 +51,23 @@ func NewExprCtMark(setMark bool, value string, cmpOp *expr.CmpOp) (*[]expr.Any,
 	return &exprCtMark, nil
 }
 
-// NewExprCtState returns a new ct expression.
-func NewExprCtState(ctFlags []*config.ExprValues) (*[]expr.Any, error) {
+// NewExprCtState returns a new ct state expression.
+// Multiple states can be specified, as several "state" keys or inline:
+// {"Key": "state", "Value": "established,related"}
+// The states are ORed, so the rule matches if the connection is in any of them.
+// With the operator "!=" the rule matches if it's in none of them.
+//
+// nft --debug netlink add rule filter input ct state established,related
+//  [ ct load state => reg 1 ]
+//  [ bitwise reg 1 = ( reg 1 & 0x00000006 ) ^ 0x00000000 ]
+//  [ cmp neq reg 1 0x00000000 ]
+func NewExprCtState(ctFlags []*config.ExprValues, cmpOp *expr.CmpOp) (*[]expr.Any, error) {
 	mask := uint32(0)
 
 	for _, flag := range ctFlags {
+		if flag.Key != NFT_CT_STATE {
+			continue
+		}
 		found, msk, err := parseInlineCtStates(flag.Value)
 		if err != nil {
 			return nil, err
@@ -71,6 +83,15 @@ func NewExprCtState(ctFlags []*config.ExprValues) (*[]expr.Any, error) {
 		}
 		mask |= msk
 	}
+	if mask == 0 {
+		return nil, fmt.Errorf("conntrack state not specified")
+	}
+
+	// the bitwise result is 0 when none of the states match.
+	ctStateCmpOp := expr.CmpOpNeq
+	if cmpOp != nil && *cmpOp == expr.CmpOpNeq {
+		ctStateCmpOp = expr.CmpOpEq
+	}
 
 	return &[]expr.Any{
 		&expr.Ct{
@@ -83,6 +104,7 @@ func NewExprCtState(ctFlags []*config.ExprValues) (*[]expr.Any, error) {
 			Mask:           binaryutil.NativeEndian.PutUint32(mask),
 			Xor:            binaryutil.NativeEndian.PutUint32(0),
 		},
+		&expr.Cmp{Op: ctStateCmpOp, Register: 1, Data: []byte{0, 0, 0, 0}},
 	}, nil
 }
 
@@ -113,6 +135,8 @@ func getCtState(flag string) (mask uint32, err error) {
 		mask |= expr.CtStateBitRELATED
 	case CT_STATE_INVALID:
 		mask |= expr.CtStateBitINVALID
+	case CT_STATE_UNTRACKED:
+		mask |= expr.CtStateBitUNTRACKED
 	default:
 		return 0, fmt.Errorf("Invalid conntrack flag: %s", flag)
 	}
@@ -9,9 +9,15 @@ const (
 	NFT_CHAIN_SECURITY  = "security"
 	NFT_CHAIN_NATDEST   = "natdest"
 	NFT_CHAIN_NATSOURCE = "natsource"
+	NFT_CHAIN_NAT       = "nat"
 	NFT_CHAIN_CONNTRACK = "conntrack"
 	NFT_CHAIN_SELINUX   = "selinux"
 
+	// standard priority names used by nft
+	NFT_PRIO_DSTNAT = "dstnat"
+	NFT_PRIO_SRCNAT = "srcnat"
+	NFT_PRIO_OUT    = "out"
+
 	NFT_HOOK_INPUT       = "input"
 	NFT_HOOK_OUTPUT      = "output"
 	NFT_HOOK_PREROUTING  = "prerouting"
@@ -71,6 +77,24 @@ const (
 
 	NFT_ETHER = "ether"
 
+	NFT_ARP             = "arp"
+	NFT_ARP_OPERATION   = "operation"
+	NFT_ARP_HTYPE       = "htype"
+	NFT_ARP_PTYPE       = "ptype"
+	NFT_ARP_SADDR_IP    = "saddr-ip"
+	NFT_ARP_DADDR_IP    = "daddr-ip"
+	NFT_ARP_SADDR_ETHER = "saddr-ether"
+	NFT_ARP_DADDR_ETHER = "daddr-ether"
+	ARP_OP_REQUEST      = "request"
+	ARP_OP_REPLY        = "reply"
+	ARP_OP_RREQUEST     = "rrequest"
+	ARP_OP_RREPLY       = "rreply"
+	ARP_OP_INREQUEST    = "inrequest"
+	ARP_OP_INREPLY      = "inreply"
+	ARP_OP_NAK          = "nak"
+
+	NFT_VMAP = "vmap"
+
 	NFT_IIFNAME = "iifname"
 	NFT_OIFNAME = "oifname"
 
@@ -88,6 +112,16 @@ const (
 	NFT_LOG_LEVEL_DEBUG  = "debug"
 	NFT_LOG_LEVEL_AUDIT  = "audit"
 	NFT_LOG_FLAGS        = "flags"
+	NFT_LOG_GROUP        = "group"
+	NFT_LOG_SNAPLEN      = "snaplen"
+	NFT_LOG_QTHRESHOLD   = "queue-threshold"
+
+	NFT_LOG_FLAG_TCP_SEQ = "tcp-sequence"
+	NFT_LOG_FLAG_TCP_OPT = "tcp-options"
+	NFT_LOG_FLAG_IP_OPT  = "ip-options"
+	NFT_LOG_FLAG_SKUID   = "skuid"
+	NFT_LOG_FLAG_ETHER   = "ether"
+	NFT_LOG_FLAG_ALL     = "all"
 
 	NFT_CT               = "ct"
 	NFT_CT_STATE         = "state"
@@ -97,10 +131,12 @@ const (
 	CT_STATE_ESTABLISHED = "established"
 	CT_STATE_RELATED     = "related"
 	CT_STATE_INVALID     = "invalid"
+	CT_STATE_UNTRACKED   = "untracked"
 
 	NFT_NOTRACK = "notrack"
 
 	NFT_QUOTA            = "quota"
+	NFT_QUOTA_NAME       = "name"
 	NFT_QUOTA_UNTIL      = "until"
 	NFT_QUOTA_OVER       = "over"
 	NFT_QUOTA_USED       = "used"
@@ -109,6 +145,22 @@ const (
 	NFT_QUOTA_UNIT_MB    = "mbytes"
 	NFT_QUOTA_UNIT_GB    = "gbytes"
 
+	NFT_FLOW          = "flow"
+	NFT_FLOW_NAME     = "name"
+	NFT_FLOW_DEVICES  = "devices"
+	NFT_FLOW_PRIORITY = "priority"
+	NFT_FLOW_OFFLOAD  = "offload"
+	NFT_FLOW_COUNTER  = "counter"
+
+	NFT_SOCKET          = "socket"
+	NFT_SOCKET_CGROUPV2 = "cgroupv2"
+
+	NFT_SYNPROXY           = "synproxy"
+	NFT_SYNPROXY_MSS       = "mss"
+	NFT_SYNPROXY_WSCALE    = "wscale"
+	NFT_SYNPROXY_TIMESTAMP = "timestamp"
+	NFT_SYNPROXY_SACK_PERM = "sack-perm"
+
 	NFT_COUNTER         = "counter"
 	NFT_COUNTER_NAME    = "name"
 	NFT_COUNTER_PACKETS = "packets"
@@ -117,6 +169,7 @@ const (
 	NFT_LIMIT             = "limit"
 	NFT_LIMIT_OVER        = "over"
 	NFT_LIMIT_BURST       = "burst"
+	NFT_LIMIT_RATE        = "rate"
 	NFT_LIMIT_UNITS_RATE  = "rate-units"
 	NFT_LIMIT_UNITS_TIME  = "time-units"
 	NFT_LIMIT_UNITS       = "units"
@@ -124,6 +177,7 @@ const (
 	NFT_LIMIT_UNIT_MINUTE = "minute"
 	NFT_LIMIT_UNIT_HOUR   = "hour"
 	NFT_LIMIT_UNIT_DAY    = "day"
+	NFT_LIMIT_UNIT_BYTES  = "bytes"
 	NFT_LIMIT_UNIT_KBYTES = "kbytes"
 	NFT_LIMIT_UNIT_MBYTES = "mbytes"
 
@@ -189,4 +243,15 @@ const (
 	ICMP_PACKET_TOO_BIG          = "packet-too-big"
 	ICMP_NEIGHBOUR_SOLICITATION  = "neighbour-solicitation"
 	ICMP_NEIGHBOUR_ADVERTISEMENT = "neighbour-advertisement"
+	ICMP_MLD_LISTENER_QUERY      = "mld-listener-query"
+	ICMP_MLD_LISTENER_REPORT     = "mld-listener-report"
+	ICMP_MLD_LISTENER_DONE       = "mld-listener-done"
+	ICMP_MLD2_LISTENER_REPORT    = "mld2-listener-report"
+
+	// ICMPv6 types names used by nft
+	ICMP_ND_ROUTER_SOLICIT   = "nd-router-solicit"
+	ICMP_ND_ROUTER_ADVERT    = "nd-router-advert"
+	ICMP_ND_NEIGHBOR_SOLICIT = "nd-neighbor-solicit"
+	ICMP_ND_NEIGHBOR_ADVERT  = "nd-neighbor-advert"
+	ICMP_ND_REDIRECT         = "nd-redirect"
 )
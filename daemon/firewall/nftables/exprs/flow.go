@@ -0,0 +1,18 @@
+package exprs
+
+import (
+	"github.com/google/nftables/expr"
+)
+
+// NewExprFlowOffload returns a new expression to offload the connections to
+// the given flowtable.
+// Once a connection is offloaded, its packets bypass the classic forwarding
+// path (and our rules), so it should only be used with established connections.
+// nft add rule inet filter forward ct state established flow add @ft
+func NewExprFlowOffload(name string) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.FlowOffload{
+			Name: name,
+		},
+	}
+}
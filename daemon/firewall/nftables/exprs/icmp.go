@@ -0,0 +1,108 @@
+package exprs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// ParseICMPTypes parses a list of ICMP or ICMPv6 types separated by commas.
+// Types can be specified by name (echo-request) or by number (8).
+func ParseICMPTypes(icmpProto, value string) ([]byte, error) {
+	types := []byte{}
+	for _, icmpType := range strings.Split(value, ",") {
+		icmpType = strings.TrimSpace(icmpType)
+		if t, err := strconv.ParseUint(icmpType, 10, 8); err == nil {
+			types = append(types, byte(t))
+			continue
+		}
+		t := uint8(0)
+		if icmpProto == NFT_PROTO_ICMPv6 {
+			t = GetICMPv6Type(icmpType)
+		} else {
+			t = GetICMPType(icmpType)
+		}
+		// 0 is echo-reply for ICMP, and it's not a valid type for ICMPv6.
+		if t == 0 && (icmpProto == NFT_PROTO_ICMPv6 || icmpType != ICMP_ECHO_REPLY) {
+			return nil, fmt.Errorf("invalid %s type: %s", icmpProto, icmpType)
+		}
+		types = append(types, t)
+	}
+
+	return types, nil
+}
+
+// ParseICMPCodes parses a list of ICMP or ICMPv6 codes separated by commas.
+// The meaning of the code depends on the type, so only numbers are allowed.
+func ParseICMPCodes(icmpProto, value string) ([]byte, error) {
+	codes := []byte{}
+	for _, icmpCode := range strings.Split(value, ",") {
+		c, err := strconv.ParseUint(strings.TrimSpace(icmpCode), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s code: %s", icmpProto, icmpCode)
+		}
+		codes = append(codes, byte(c))
+	}
+
+	return codes, nil
+}
+
+// GetICMPSetType returns the data type of the sets of ICMP types or codes.
+func GetICMPSetType(icmpProto, field string) nftables.SetDatatype {
+	switch {
+	case icmpProto == NFT_PROTO_ICMPv6 && field == NFT_ICMP_CODE:
+		return nftables.TypeICMPV6Code
+	case icmpProto == NFT_PROTO_ICMPv6:
+		return nftables.TypeICMP6Type
+	case field == NFT_ICMP_CODE:
+		return nftables.TypeICMPCode
+	}
+	return nftables.TypeICMPType
+}
+
+// NewExprICMP returns a new expression to match the type or code of ICMP packets.
+// nft --debug=netlink add rule inet filter input icmp type echo-request accept
+//	[ payload load 1b @ transport header + 0 => reg 1 ]
+//	[ cmp eq reg 1 0x00000008 ]
+func NewExprICMP(field string, value byte, cmpOp expr.CmpOp) *[]expr.Any {
+	return &[]expr.Any{
+		getExprICMPPayload(field),
+		&expr.Cmp{
+			Op:       cmpOp,
+			Register: 1,
+			Data:     []byte{value},
+		},
+	}
+}
+
+// NewExprICMPSet returns a new expression to match the type or code of ICMP
+// packets against the elements of a set.
+// nft add rule inet filter input icmpv6 type != { nd-router-advert, nd-neighbor-solicit } drop
+func NewExprICMPSet(field string, set *nftables.Set, cmpOp expr.CmpOp) *[]expr.Any {
+	return &[]expr.Any{
+		getExprICMPPayload(field),
+		&expr.Lookup{
+			SourceRegister: 1,
+			SetName:        set.Name,
+			SetID:          set.ID,
+			Invert:         cmpOp == expr.CmpOpNeq,
+		},
+	}
+}
+
+// The type and code are the first 2 bytes of the ICMP and ICMPv6 headers.
+func getExprICMPPayload(field string) *expr.Payload {
+	offset := uint32(0)
+	if field == NFT_ICMP_CODE {
+		offset = 1
+	}
+	return &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseTransportHeader,
+		Offset:       offset,
+		Len:          1,
+	}
+}
@@ -0,0 +1,84 @@
+package exprs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseICMPTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		icmpProto string
+		value     string
+		want      []byte
+		wantErr   bool
+	}{
+		{"icmp name", NFT_PROTO_ICMP, "echo-request", []byte{8}, false},
+		{"icmp echo-reply", NFT_PROTO_ICMP, "echo-reply", []byte{0}, false},
+		{"icmpv6 echo-reply", NFT_PROTO_ICMPv6, "echo-reply", []byte{129}, false},
+		{"icmpv6 name", NFT_PROTO_ICMPv6, "packet-too-big", []byte{2}, false},
+		{"icmpv6 nd alias", NFT_PROTO_ICMPv6, "nd-neighbor-solicit", []byte{135}, false},
+		{"icmpv6 mld", NFT_PROTO_ICMPv6, "mld-listener-query", []byte{130}, false},
+		{"list of names, with spaces", NFT_PROTO_ICMP, "echo-request, destination-unreachable ,time-exceeded", []byte{8, 3, 11}, false},
+		{"numbers", NFT_PROTO_ICMP, "8,0", []byte{8, 0}, false},
+		{"names and numbers", NFT_PROTO_ICMPv6, "133, nd-router-advert", []byte{133, 134}, false},
+		{"lowest number", NFT_PROTO_ICMPv6, "0", []byte{0}, false},
+		{"highest number", NFT_PROTO_ICMP, "255", []byte{255}, false},
+		{"number out of range", NFT_PROTO_ICMP, "256", nil, true},
+		{"negative number", NFT_PROTO_ICMP, "-1", nil, true},
+		{"ranges not supported", NFT_PROTO_ICMP, "1-3", nil, true},
+		{"icmpv6 name with icmp", NFT_PROTO_ICMP, "packet-too-big", nil, true},
+		{"icmpv6 nd alias with icmp", NFT_PROTO_ICMP, "nd-neighbor-solicit", nil, true},
+		{"icmp name with icmpv6", NFT_PROTO_ICMPv6, "source-quench", nil, true},
+		{"unknown name", NFT_PROTO_ICMP, "echo", nil, true},
+		{"empty", NFT_PROTO_ICMP, "", nil, true},
+		{"empty item", NFT_PROTO_ICMPv6, "echo-request,", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseICMPTypes(test.icmpProto, test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseICMPTypes(%s, %q) error = %v, wantErr %v", test.icmpProto, test.value, err, test.wantErr)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("ParseICMPTypes(%s, %q) = %v, want %v", test.icmpProto, test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseICMPCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		icmpProto string
+		value     string
+		want      []byte
+		wantErr   bool
+	}{
+		{"icmp code", NFT_PROTO_ICMP, "3", []byte{3}, false},
+		{"icmpv6 code", NFT_PROTO_ICMPv6, "4", []byte{4}, false},
+		{"list, with spaces", NFT_PROTO_ICMP, "0, 1 ,3", []byte{0, 1, 3}, false},
+		{"lowest number", NFT_PROTO_ICMPv6, "0", []byte{0}, false},
+		{"highest number", NFT_PROTO_ICMP, "255", []byte{255}, false},
+		{"number out of range", NFT_PROTO_ICMPv6, "256", nil, true},
+		{"negative number", NFT_PROTO_ICMP, "-1", nil, true},
+		{"ranges not supported", NFT_PROTO_ICMP, "0-3", nil, true},
+		// the names of the codes depend on the type.
+		{"names not supported", NFT_PROTO_ICMP, "port-unreachable", nil, true},
+		{"empty", NFT_PROTO_ICMPv6, "", nil, true},
+		{"empty item", NFT_PROTO_ICMP, "1,,2", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseICMPCodes(test.icmpProto, test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseICMPCodes(%s, %q) error = %v, wantErr %v", test.icmpProto, test.value, err, test.wantErr)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("ParseICMPCodes(%s, %q) = %v, want %v", test.icmpProto, test.value, got, test.want)
+			}
+		})
+	}
+}
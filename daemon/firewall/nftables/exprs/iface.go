@@ -1,6 +1,9 @@
 package exprs
 
-import "github.com/google/nftables/expr"
+import (
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
 
 // NewExprIface returns a new network interface expression
 func NewExprIface(iface string, isOut bool, cmpOp expr.CmpOp) *[]expr.Any {
@@ -18,6 +21,31 @@ func NewExprIface(iface string, isOut bool, cmpOp expr.CmpOp) *[]expr.Any {
 	}
 }
 
+// NewExprIfaceSet returns a new expression to match the network interface
+// against the elements of a set.
+// nft add rule inet mangle output oifname != { br0, docker0 } ...
+func NewExprIfaceSet(set *nftables.Set, isOut bool, cmpOp expr.CmpOp) *[]expr.Any {
+	keyDev := expr.MetaKeyIIFNAME
+	if isOut {
+		keyDev = expr.MetaKeyOIFNAME
+	}
+	return &[]expr.Any{
+		&expr.Meta{Key: keyDev, Register: 1},
+		&expr.Lookup{
+			SourceRegister: 1,
+			SetName:        set.Name,
+			SetID:          set.ID,
+			Invert:         cmpOp == expr.CmpOpNeq,
+		},
+	}
+}
+
+// GetIfaceName returns the name of the interface in the format expected by
+// nftables, to be used as key of the elements of a set.
+func GetIfaceName(iface string) []byte {
+	return ifname(iface)
+}
+
 // https://github.com/google/nftables/blob/master/nftables_test.go#L81
 func ifname(n string) []byte {
 	b := make([]byte, 16)
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
@@ -27,33 +28,47 @@ import (
 // "Values": [
 //   {"Key": "daddr": "Value": "1.2.3.4-1.2.9.254"}
 // ]
-// TODO (filter by multiple dest addrs separated by commas):
+// Example 4 (filtering by multiple dest addrs separated by commas, or CIDRs):
 // "Values": [
-//   {"Key": "daddr": "Value": "1.2.3.4,1.2.9.254"}
+//   {"Key": "daddr": "Value": "1.2.3.4,1.2.9.254,10.0.0.0/8"}
 // ]
+// Lists of addresses are compiled into a named set, see NewExprIPSet().
 func NewExprIP(family string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+	return newExprIP(family, ipOptions, cmpOp, false)
+}
+
+// NewExprIP6 returns a new IPv6 expression ("Name": "ip6"), with the same
+// options as NewExprIP().
+func NewExprIP6(family string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+	return newExprIP(family, ipOptions, cmpOp, true)
+}
+
+func newExprIP(family string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp, ipv6 bool) (*[]expr.Any, error) {
 	var exprIP []expr.Any
 
 	if family == NFT_FAMILY_INET {
+		nfproto := byte(unix.NFPROTO_IPV4)
+		if ipv6 {
+			nfproto = unix.NFPROTO_IPV6
+		}
 		exprIP = append(exprIP, &expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1})
-		exprIP = append(exprIP, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV4}})
+		exprIP = append(exprIP, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{nfproto}})
 	}
 	for _, ipOpt := range ipOptions {
-		// TODO: ipv6
 		switch ipOpt.Key {
 		case NFT_SADDR, NFT_DADDR:
 			// if the table family is inet, we need to specify the protocol of the IP being added.
 
-			payload := getExprIPPayload(ipOpt.Key)
+			payload := getExprAddrPayload(ipOpt.Key, ipv6)
 			exprIP = append(exprIP, payload)
 			if strings.Index(ipOpt.Value, "-") == -1 {
-				exprIPtemp, err := getExprIP(ipOpt.Value, cmpOp)
+				exprIPtemp, err := getExprIP(ipOpt.Value, cmpOp, ipv6)
 				if err != nil {
 					return nil, err
 				}
 				exprIP = append(exprIP, *exprIPtemp...)
 			} else {
-				exprIPtemp, err := getExprRangeIP(ipOpt.Value, cmpOp)
+				exprIPtemp, err := getExprRangeIP(ipOpt.Value, cmpOp, ipv6)
 				if err != nil {
 					return nil, err
 				}
@@ -61,7 +76,7 @@ func NewExprIP(family string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp)
 			}
 
 		case NFT_PROTOCOL:
-			payload := getExprIPPayload(ipOpt.Key)
+			payload := getExprAddrPayload(ipOpt.Key, ipv6)
 			exprIP = append(exprIP, payload)
 			protoCode, err := getProtocolCode(ipOpt.Value)
 			if err != nil {
@@ -80,6 +95,23 @@ func NewExprIP(family string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp)
 }
 
 func getExprIPPayload(what string) *expr.Payload {
+	return getExprAddrPayload(what, false)
+}
+
+// getExprAddrPayload returns the payload of the protocol, source or
+// destination address of the IPv4 header, or of the IPv6 header if ipv6 is
+// true (next header, offsets 8 and 24, 16 bytes).
+func getExprAddrPayload(what string, ipv6 bool) *expr.Payload {
+	if ipv6 {
+		switch what {
+		case NFT_PROTOCOL:
+			return &expr.Payload{DestRegister: 1, Offset: 6, Base: expr.PayloadBaseNetworkHeader, Len: 1}
+		case NFT_DADDR:
+			return &expr.Payload{DestRegister: 1, Offset: 24, Base: expr.PayloadBaseNetworkHeader, Len: 16}
+		default:
+			return &expr.Payload{SourceRegister: 1, DestRegister: 1, Offset: 8, Base: expr.PayloadBaseNetworkHeader, Len: 16}
+		}
+	}
 
 	switch what {
 	case NFT_PROTOCOL:
@@ -110,39 +142,60 @@ func getExprIPPayload(what string) *expr.Payload {
 	}
 }
 
-// Supported IP types: a.b.c.d, a.b.c.d-w.x.y.z
-// TODO: support IPs separated by commas: a.b.c.d, e.f.g.h,...
-func getExprIP(value string, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+// NewExprIPSet returns a new expression to match the source or destination
+// address against the elements of a set, of IPv4 or IPv6 addresses.
+// nft add rule inet filter output ip daddr @opensnitch-set-1 drop
+func NewExprIPSet(what string, set *nftables.Set, cmpOp expr.CmpOp) *[]expr.Any {
+	return &[]expr.Any{
+		getExprAddrPayload(what, set.KeyType == nftables.TypeIP6Addr),
+		&expr.Lookup{
+			SourceRegister: 1,
+			SetName:        set.Name,
+			SetID:          set.ID,
+			Invert:         cmpOp == expr.CmpOpNeq,
+		},
+	}
+}
+
+// Supported IP types: a.b.c.d, a.b.c.d-w.x.y.z, and the IPv6 ones if ipv6 is
+// true.
+func getExprIP(value string, cmpOp expr.CmpOp, ipv6 bool) (*[]expr.Any, error) {
 	ip := net.ParseIP(value)
 	if ip == nil {
 		return nil, fmt.Errorf("Invalid IP: %s", value)
 	}
+	if ip = toIPVersion(ip, ipv6); ip == nil {
+		return nil, fmt.Errorf("Invalid IP version: %s", value)
+	}
 
 	return &[]expr.Any{
 		&expr.Cmp{
 			Op:       cmpOp,
 			Register: 1,
-			Data:     ip.To4(),
+			Data:     ip,
 		},
 	}, nil
 }
 
-// Supported IP types: a.b.c.d, a.b.c.d-w.x.y.z
-// TODO: support IPs separated by commas: a.b.c.d, e.f.g.h,...
-func getExprRangeIP(value string, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+// Supported IP types: a.b.c.d, a.b.c.d-w.x.y.z, and the IPv6 ones if ipv6 is
+// true.
+func getExprRangeIP(value string, cmpOp expr.CmpOp, ipv6 bool) (*[]expr.Any, error) {
 	ips := strings.Split(value, "-")
 	ipSrc := net.ParseIP(ips[0])
 	ipDst := net.ParseIP(ips[1])
 	if ipSrc == nil || ipDst == nil {
 		return nil, fmt.Errorf("Invalid IPs range: %v", ips)
 	}
+	if ipSrc, ipDst = toIPVersion(ipSrc, ipv6), toIPVersion(ipDst, ipv6); ipSrc == nil || ipDst == nil {
+		return nil, fmt.Errorf("Invalid IP version: %s", value)
+	}
 
 	return &[]expr.Any{
 		&expr.Range{
 			Op:       cmpOp,
 			Register: 1,
-			FromData: ipSrc.To4(),
-			ToData:   ipDst.To4(),
+			FromData: ipSrc,
+			ToData:   ipDst,
 		},
 	}, nil
 }
@@ -0,0 +1,78 @@
+package exprs
+
+import (
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestNewExprIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		ipv6    bool
+		wantErr bool
+	}{
+		{"daddr", NFT_DADDR, "1.1.1.1", false, false},
+		{"saddr range", NFT_SADDR, "1.1.1.1-1.1.1.10", false, false},
+		{"invalid IP", NFT_DADDR, "1.1.1", false, true},
+		{"invalid range", NFT_DADDR, "1.1.1.1-1.1.1", false, true},
+		{"IPv6 address in an ip statement", NFT_DADDR, "2001:db8::1", false, true},
+		{"IPv6 range in an ip statement", NFT_SADDR, "2001:db8::1-2001:db8::10", false, true},
+		{"ip6 daddr", NFT_DADDR, "2001:db8::1", true, false},
+		{"ip6 saddr range", NFT_SADDR, "2001:db8::1-2001:db8::10", true, false},
+		{"IPv4 address in an ip6 statement", NFT_DADDR, "1.1.1.1", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []*config.ExprValues{{Key: test.key, Value: test.value}}
+			newExprIP := NewExprIP
+			if test.ipv6 {
+				newExprIP = NewExprIP6
+			}
+			_, err := newExprIP(NFT_FAMILY_INET, opts, expr.CmpOpEq)
+			if test.wantErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewExprIPSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType nftables.SetDatatype
+		what    string
+		offset  uint32
+		len     uint32
+	}{
+		{"IPv4 daddr", nftables.TypeIPAddr, NFT_DADDR, 16, 4},
+		{"IPv4 saddr", nftables.TypeIPAddr, NFT_SADDR, 12, 4},
+		{"IPv6 daddr", nftables.TypeIP6Addr, NFT_DADDR, 24, 16},
+		{"IPv6 saddr", nftables.TypeIP6Addr, NFT_SADDR, 8, 16},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := &nftables.Set{Name: "opensnitch-set-1", KeyType: test.keyType}
+			exprList := *NewExprIPSet(test.what, set, expr.CmpOpEq)
+			payload, ok := exprList[0].(*expr.Payload)
+			if !ok {
+				t.Fatalf("unexpected expression %T", exprList[0])
+			}
+			if payload.Offset != test.offset || payload.Len != test.len {
+				t.Errorf("payload offset %d, len %d, want %d, %d", payload.Offset, payload.Len, test.offset, test.len)
+			}
+			if lookup, ok := exprList[1].(*expr.Lookup); !ok || lookup.SetName != set.Name {
+				t.Errorf("unexpected lookup %v", exprList[1])
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package exprs
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
 	"github.com/google/nftables/expr"
@@ -11,6 +12,10 @@ import (
 // NewExprLimit returns a new limit expression.
 // limit rate [over] 1/second
 // to express bytes units, we use: 10-mbytes instead of nft's 10 mbytes
+//
+// The rate can also be defined in a single value, with the nft syntax:
+// {"Key": "rate", "Value": "10/second burst 20"}
+// {"Key": "rate", "Value": "over 2 mbytes/second"}
 func NewExprLimit(statement *config.ExprStatement) (*[]expr.Any, error) {
 	var err error
 	exprLimit := &expr.Limit{
@@ -44,15 +49,28 @@ func NewExprLimit(statement *config.ExprStatement) (*[]expr.Any, error) {
 			exprLimit.Type, exprLimit.Rate = getLimitRate(values.Value, exprLimit.Rate)
 
 		case NFT_LIMIT_UNITS_TIME:
-			exprLimit.Unit = getLimitUnits(values.Value)
+			exprLimit.Unit, err = getLimitUnits(values.Value)
+			if err != nil {
+				return nil, err
+			}
+
+		case NFT_LIMIT_RATE:
+			if err := parseLimitRate(values.Value, exprLimit); err != nil {
+				return nil, err
+			}
 		}
 	}
+	if exprLimit.Rate == 0 {
+		return nil, fmt.Errorf("Invalid limit rate, it cannot be 0")
+	}
 
 	return &[]expr.Any{exprLimit}, nil
 }
 
-func getLimitUnits(units string) (limitUnits expr.LimitTime) {
+func getLimitUnits(units string) (limitUnits expr.LimitTime, err error) {
 	switch units {
+	case NFT_LIMIT_UNIT_SECOND:
+		limitUnits = expr.LimitTimeSecond
 	case NFT_LIMIT_UNIT_MINUTE:
 		limitUnits = expr.LimitTimeMinute
 	case NFT_LIMIT_UNIT_HOUR:
@@ -60,10 +78,10 @@ func getLimitUnits(units string) (limitUnits expr.LimitTime) {
 	case NFT_LIMIT_UNIT_DAY:
 		limitUnits = expr.LimitTimeDay
 	default:
-		limitUnits = expr.LimitTimeSecond
+		err = fmt.Errorf("Invalid limit time unit: %s", units)
 	}
 
-	return limitUnits
+	return limitUnits, err
 }
 
 func getLimitRate(units string, rate uint64) (limitType expr.LimitType, limitRate uint64) {
@@ -74,6 +92,9 @@ func getLimitRate(units string, rate uint64) (limitType expr.LimitType, limitRat
 	case NFT_LIMIT_UNIT_MBYTES:
 		limitRate = (rate * 1024) * 1024
 		limitType = expr.LimitTypePktBytes
+	case NFT_LIMIT_UNIT_BYTES:
+		limitRate = rate
+		limitType = expr.LimitTypePktBytes
 	default:
 		limitType = expr.LimitTypePkts
 		limitRate, _ = strconv.ParseUint(units, 10, 64)
@@ -81,3 +102,65 @@ func getLimitRate(units string, rate uint64) (limitType expr.LimitType, limitRat
 
 	return
 }
+
+func isLimitByteUnit(units string) bool {
+	return units == NFT_LIMIT_UNIT_BYTES || units == NFT_LIMIT_UNIT_KBYTES || units == NFT_LIMIT_UNIT_MBYTES
+}
+
+// parseLimitRate parses a rate limit defined with the nft syntax:
+// [over] <rate> [bytes|kbytes|mbytes]/<second|minute|hour|day> [burst <n> [bytes|kbytes|mbytes]]
+func parseLimitRate(value string, exprLimit *expr.Limit) error {
+	parms := strings.Fields(strings.Replace(strings.ToLower(value), "/", " / ", 1))
+	if len(parms) > 0 && parms[0] == NFT_LIMIT_OVER {
+		exprLimit.Over = true
+		parms = parms[1:]
+	}
+	if len(parms) < 3 {
+		return fmt.Errorf("Invalid limit rate: %s", value)
+	}
+
+	rate, err := strconv.ParseUint(parms[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid limit rate: %s", value)
+	}
+	parms = parms[1:]
+	rateUnits := ""
+	if parms[0] != "/" {
+		rateUnits = parms[0]
+		parms = parms[1:]
+	}
+	if len(parms) < 2 || parms[0] != "/" {
+		return fmt.Errorf("Invalid limit rate, time unit missing: %s", value)
+	}
+	exprLimit.Type, exprLimit.Rate = expr.LimitTypePkts, rate
+	if rateUnits != "" {
+		if !isLimitByteUnit(rateUnits) {
+			return fmt.Errorf("Invalid limit rate unit: %s", value)
+		}
+		exprLimit.Type, exprLimit.Rate = getLimitRate(rateUnits, rate)
+	}
+	if exprLimit.Unit, err = getLimitUnits(parms[1]); err != nil {
+		return fmt.Errorf("Invalid limit rate, %s", err)
+	}
+	parms = parms[2:]
+
+	if len(parms) == 0 {
+		return nil
+	}
+	if parms[0] != NFT_LIMIT_BURST || len(parms) < 2 {
+		return fmt.Errorf("Invalid limit burst: %s", value)
+	}
+	burst, err := strconv.ParseUint(parms[1], 10, 32)
+	if err != nil || burst == 0 {
+		return fmt.Errorf("Invalid limit burst: %s", value)
+	}
+	if len(parms) > 2 {
+		if !isLimitByteUnit(parms[2]) {
+			return fmt.Errorf("Invalid limit burst unit: %s", value)
+		}
+		_, burst = getLimitRate(parms[2], burst)
+	}
+	exprLimit.Burst = uint32(burst)
+
+	return nil
+}
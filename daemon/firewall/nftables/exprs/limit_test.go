@@ -0,0 +1,122 @@
+package exprs
+
+import (
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables/expr"
+)
+
+func TestParseLimitRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    expr.Limit
+		wantErr bool
+	}{
+		{
+			"packets",
+			"10/second",
+			expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeSecond},
+			false,
+		},
+		{
+			"over, with spaces",
+			"over 5 / minute",
+			expr.Limit{Type: expr.LimitTypePkts, Rate: 5, Unit: expr.LimitTimeMinute, Over: true},
+			false,
+		},
+		{
+			"bytes",
+			"2 mbytes/hour",
+			expr.Limit{Type: expr.LimitTypePktBytes, Rate: 2 * 1024 * 1024, Unit: expr.LimitTimeHour},
+			false,
+		},
+		{
+			"burst",
+			"10/day burst 5",
+			expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeDay, Burst: 5},
+			false,
+		},
+		{
+			"burst in bytes, upper case",
+			"1 KBYTES/second BURST 2 kbytes",
+			expr.Limit{Type: expr.LimitTypePktBytes, Rate: 1024, Unit: expr.LimitTimeSecond, Burst: 2048},
+			false,
+		},
+		{"empty", "", expr.Limit{}, true},
+		{"invalid rate", "ten/second", expr.Limit{}, true},
+		{"time unit missing", "10 mbytes", expr.Limit{}, true},
+		{"invalid burst", "10/second burst", expr.Limit{}, true},
+		{"zero burst", "10/second burst 0", expr.Limit{}, true},
+		{"unknown option", "10/second foo 2", expr.Limit{}, true},
+		{"unknown time unit", "10/foo", expr.Limit{}, true},
+		{"unknown rate unit", "10 gbytes/second", expr.Limit{}, true},
+		{"unknown burst unit", "10/second burst 2 packets", expr.Limit{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := expr.Limit{}
+			err := parseLimitRate(test.value, &got)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewExprLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []*config.ExprValues
+		want    expr.Limit
+		wantErr bool
+	}{
+		{
+			"units",
+			[]*config.ExprValues{{Key: "units", Value: "10"}, {Key: "rate-units", Value: "kbytes"}, {Key: "time-units", Value: "minute"}},
+			expr.Limit{Type: expr.LimitTypePktBytes, Rate: 10 * 1024, Unit: expr.LimitTimeMinute},
+			false,
+		},
+		{
+			"rate in the rate units",
+			[]*config.ExprValues{{Key: "rate-units", Value: "5"}, {Key: "time-units", Value: "second"}},
+			expr.Limit{Type: expr.LimitTypePkts, Rate: 5, Unit: expr.LimitTimeSecond},
+			false,
+		},
+		{
+			"unknown time unit",
+			[]*config.ExprValues{{Key: "units", Value: "10"}, {Key: "time-units", Value: "week"}},
+			expr.Limit{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewExprLimit(&config.ExprStatement{Name: NFT_LIMIT, Values: test.values})
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limit := (*got)[0].(*expr.Limit); *limit != test.want {
+				t.Errorf("got %+v, want %+v", *limit, test.want)
+			}
+		})
+	}
+}
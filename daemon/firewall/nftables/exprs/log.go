@@ -2,6 +2,8 @@ package exprs
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
 	"github.com/evilsocket/opensnitch/daemon/log"
@@ -10,6 +12,11 @@ import (
 )
 
 // NewExprLog returns a new log expression.
+// By default the packets are logged to the kernel log (dmesg), but they can be
+// sent to a netlink group instead, to be collected by ulogd:
+// {"Key": "prefix", "Value": "drop-ssh"}, {"Key": "group", "Value": "2"}, {"Key": "snaplen", "Value": "128"}
+//
+// nft add rule inet filter input tcp dport 22 log prefix "drop-ssh" group 2 snaplen 128 drop
 func NewExprLog(statement *config.ExprStatement) (*[]expr.Any, error) {
 	prefix := "opensnitch"
 	logExpr := expr.Log{
@@ -27,25 +34,84 @@ func NewExprLog(statement *config.ExprStatement) (*[]expr.Any, error) {
 		case NFT_LOG_LEVEL:
 			lvl, err := getLogLevel(values.Value)
 			if err != nil {
-				log.Warning("%s", err)
-			} else {
-				logExpr.Key |= 1 << unix.NFTA_LOG_LEVEL
-				logExpr.Level = lvl
+				return nil, err
 			}
-			// TODO
-			// https://github.com/google/nftables/blob/main/nftables_test.go#L623
-			//case exprs.NFT_LOG_FLAGS:
-			//case exprs.NFT_LOG_GROUP:
-			//case exprs.NFT_LOG_QTHRESHOLD:
+			logExpr.Key |= 1 << unix.NFTA_LOG_LEVEL
+			logExpr.Level = lvl
+		case NFT_LOG_FLAGS:
+			flags, err := getLogFlags(values.Value)
+			if err != nil {
+				return nil, err
+			}
+			logExpr.Key |= 1 << unix.NFTA_LOG_FLAGS
+			logExpr.Flags = flags
+		case NFT_LOG_GROUP:
+			group, err := strconv.ParseUint(values.Value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid log group: %s", values.Value)
+			}
+			logExpr.Key |= 1 << unix.NFTA_LOG_GROUP
+			logExpr.Group = uint16(group)
+		case NFT_LOG_SNAPLEN:
+			snaplen, err := strconv.ParseUint(values.Value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid log snaplen: %s", values.Value)
+			}
+			logExpr.Key |= 1 << unix.NFTA_LOG_SNAPLEN
+			logExpr.Snaplen = uint32(snaplen)
+		case NFT_LOG_QTHRESHOLD:
+			qthreshold, err := strconv.ParseUint(values.Value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid log queue-threshold: %s", values.Value)
+			}
+			logExpr.Key |= 1 << unix.NFTA_LOG_QTHRESHOLD
+			logExpr.QThreshold = uint16(qthreshold)
+		default:
+			log.Warning("%s log option not supported: %s", "nftables", values.Key)
 		}
 	}
 
+	// the packets sent to a netlink group are not logged to the kernel log.
+	isGroup := logExpr.Key&(1<<unix.NFTA_LOG_GROUP) != 0
+	if isGroup && logExpr.Key&((1<<unix.NFTA_LOG_LEVEL)|(1<<unix.NFTA_LOG_FLAGS)) != 0 {
+		return nil, fmt.Errorf("log level and flags cannot be used with log group")
+	}
+	if !isGroup && logExpr.Key&((1<<unix.NFTA_LOG_SNAPLEN)|(1<<unix.NFTA_LOG_QTHRESHOLD)) != 0 {
+		return nil, fmt.Errorf("log snaplen and queue-threshold require a log group")
+	}
+
 	return &[]expr.Any{
 		&logExpr,
 	}, nil
 
 }
 
+// getLogFlags parses the list of log flags, separated by commas:
+// "tcp-sequence,tcp-options,ip-options,skuid,ether" or "all"
+func getLogFlags(what string) (expr.LogFlags, error) {
+	flags := expr.LogFlags(0)
+	for _, flag := range strings.Split(what, ",") {
+		switch strings.TrimSpace(flag) {
+		case NFT_LOG_FLAG_TCP_SEQ:
+			flags |= expr.LogFlagsTCPSeq
+		case NFT_LOG_FLAG_TCP_OPT:
+			flags |= expr.LogFlagsTCPOpt
+		case NFT_LOG_FLAG_IP_OPT:
+			flags |= expr.LogFlagsIPOpt
+		case NFT_LOG_FLAG_SKUID:
+			flags |= expr.LogFlagsUID
+		case NFT_LOG_FLAG_ETHER:
+			flags |= expr.LogFlagsMACDecode
+		case NFT_LOG_FLAG_ALL:
+			flags |= expr.LogFlagsMask
+		default:
+			return 0, fmt.Errorf("Invalid log flag: %s", flag)
+		}
+	}
+
+	return flags, nil
+}
+
 func getLogLevel(what string) (expr.LogLevel, error) {
 	switch what {
 	// https://github.com/google/nftables/blob/main/expr/log.go#L28
@@ -2,7 +2,9 @@ package exprs
 
 import (
 	"fmt"
+	"os/user"
 	"strconv"
+	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
 	"github.com/google/nftables/binaryutil"
@@ -30,8 +32,20 @@ func NewExprMeta(values []*config.ExprValues, cmpOp *expr.CmpOp) (*[]expr.Any, e
 
 			return &metaExpr, nil
 
-		case NFT_META_MARK, NFT_META_PRIORITY,
-			NFT_META_SKUID, NFT_META_SKGID,
+		case NFT_META_MARK:
+			mark, err := ParseMark(meta.Value)
+			if err != nil {
+				return nil, err
+			}
+			if setMark {
+				return NewExprMetaSetMark(mark), nil
+			}
+			return NewExprMetaMark(mark, *cmpOp), nil
+
+		case NFT_META_SKUID, NFT_META_SKGID:
+			return NewExprMetaSkID(meta.Key, meta.Value, *cmpOp)
+
+		case NFT_META_PRIORITY,
 			NFT_META_PROTOCOL:
 
 			metaKey, err := getMetaKey(meta.Key)
@@ -87,6 +101,143 @@ func NewExprMeta(values []*config.ExprValues, cmpOp *expr.CmpOp) (*[]expr.Any, e
 	return nil, fmt.Errorf("%s meta keyword not supported yet, open a new issue on github", "nftables")
 }
 
+// NewExprMetaMark returns a new expression to match the mark of the packets.
+// The packets marked by VPNs or policy routing (fwmark) can be excluded from
+// interception by accepting them before the queue rule.
+// nft add rule inet mangle output meta mark 0xca6c accept
+//
+//	[ meta load mark => reg 1 ]
+//	[ cmp eq reg 1 0x0000ca6c ]
+func NewExprMetaMark(mark uint32, cmpOp expr.CmpOp) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+		&expr.Cmp{
+			Op:       cmpOp,
+			Register: 1,
+			Data:     binaryutil.NativeEndian.PutUint32(mark),
+		},
+	}
+}
+
+// NewExprMetaSetMark returns a new expression to set the mark of the packets.
+// nft add rule inet mangle output meta mark set 0x1
+//
+//	[ immediate reg 1 0x00000001 ]
+//	[ meta set mark with reg 1 ]
+func NewExprMetaSetMark(mark uint32) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Immediate{
+			Register: 1,
+			Data:     binaryutil.NativeEndian.PutUint32(mark),
+		},
+		&expr.Meta{Key: expr.MetaKeyMARK, Register: 1, SourceRegister: true},
+	}
+}
+
+// NewExprMetaSkID returns a new expression to match the user (skuid) or group
+// (skgid) that owns the socket of the packets. Only valid for locally
+// generated or received packets (input, output and postrouting hooks).
+// The value can be an id (1002), a name (nobody) or a range of ids (1000-2000).
+//
+// The comparison of ranges and <, > operators is done in network byte order:
+// nft --debug=netlink add rule inet filter output meta skuid 1000-2000 drop
+//
+//	[ meta load skuid => reg 1 ]
+//	[ byteorder reg 1 = hton(reg 1, 4, 4) ]
+//	[ range eq reg 1 0x000003e8 0x000007d0 ]
+func NewExprMetaSkID(key, value string, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+	metaKey, err := getMetaKey(key)
+	if err != nil {
+		return nil, err
+	}
+	metaExpr := []expr.Any{
+		&expr.Meta{Key: metaKey, Register: 1},
+	}
+	hton := &expr.Byteorder{
+		SourceRegister: 1,
+		DestRegister:   1,
+		Op:             expr.ByteorderHton,
+		Len:            4,
+		Size:           4,
+	}
+
+	if ids := strings.Split(value, "-"); len(ids) == 2 {
+		if cmpOp != expr.CmpOpEq && cmpOp != expr.CmpOpNeq {
+			return nil, fmt.Errorf("invalid operator for %s range: %s", key, value)
+		}
+		from, err := getSkID(key, ids[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := getSkID(key, ids[1])
+		if err != nil {
+			return nil, err
+		}
+		metaExpr = append(metaExpr, hton, &expr.Range{
+			Op:       cmpOp,
+			Register: 1,
+			FromData: binaryutil.BigEndian.PutUint32(from),
+			ToData:   binaryutil.BigEndian.PutUint32(to),
+		})
+		return &metaExpr, nil
+	}
+
+	id, err := getSkID(key, value)
+	if err != nil {
+		return nil, err
+	}
+	data := binaryutil.NativeEndian.PutUint32(id)
+	if cmpOp != expr.CmpOpEq && cmpOp != expr.CmpOpNeq {
+		metaExpr = append(metaExpr, hton)
+		data = binaryutil.BigEndian.PutUint32(id)
+	}
+	metaExpr = append(metaExpr, &expr.Cmp{
+		Op:       cmpOp,
+		Register: 1,
+		Data:     data,
+	})
+
+	return &metaExpr, nil
+}
+
+// getSkID returns the id of the given user or group, by number or by name.
+func getSkID(key, value string) (uint32, error) {
+	value = strings.TrimSpace(value)
+	if id, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	idStr := ""
+	if key == NFT_META_SKUID {
+		u, err := user.Lookup(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", key, err)
+		}
+		idStr = u.Uid
+	} else {
+		g, err := user.LookupGroup(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", key, err)
+		}
+		idStr = g.Gid
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %s: %s", key, value, idStr)
+	}
+
+	return uint32(id), nil
+}
+
+// ParseMark parses a packet mark, in decimal (51820) or hexadecimal (0xca6c).
+func ParseMark(value string) (uint32, error) {
+	mark, err := strconv.ParseUint(strings.TrimSpace(value), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mark: %s", value)
+	}
+	return uint32(mark), nil
+}
+
 func getMetaValue(value string) (int, error) {
 	metaVal, err := strconv.Atoi(value)
 	if err != nil {
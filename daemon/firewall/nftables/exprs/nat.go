@@ -6,16 +6,29 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/google/nftables"
 	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
 
+// NATParms holds the registers where the NAT addresses and ports are loaded.
+// A register is 0 if it has not been configured.
+type NATParms struct {
+	// NFPROTO_IPV4 or NFPROTO_IPV6, depending on the addresses.
+	Family      uint32
+	RegAddrMin  uint32
+	RegAddrMax  uint32
+	RegProtoMin uint32
+	RegProtoMax uint32
+}
+
 // NewExprNATFlags returns the nat flags configured.
-// common to masquerade, snat and dnat
+// common to masquerade, snat and dnat.
+// The flags can be separated by commas or spaces: "to 1.2.3.4 random,persistent"
 func NewExprNATFlags(parms string) (random, fullrandom, persistent bool) {
-	masqParms := strings.Split(parms, ",")
+	masqParms := strings.FieldsFunc(parms, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
 	for _, mParm := range masqParms {
 		switch mParm {
 		case NFT_MASQ_RANDOM:
@@ -32,57 +45,122 @@ func NewExprNATFlags(parms string) (random, fullrandom, persistent bool) {
 
 // NewExprNAT parses the redirection of redirect, snat, dnat, tproxy and masquerade verdict:
 // to x.y.z.a:abcd
-// If only the IP is specified (to 1.2.3.4), only NAT.RegAddrMin must be present (regAddr == true)
-// If only the port is specified (to :1234), only NAT.RegPortMin must be present (regPort == true)
-// If both addr and port are specified (to 1.2.3.4:1234), NAT.RegPortMin and NAT.RegAddrMin must be present.
-func NewExprNAT(parms, verdict string) (bool, bool, *[]expr.Any, error) {
-	regAddr := false
-	regProto := false
+// to x.y.z.a-x.y.z.b:abcd-efgh
+// to [2001:db8::1]:abcd
+// If only the IP is specified (to 1.2.3.4), only NAT.RegAddrMin must be present
+// If only the port is specified (to :1234), only NAT.RegProtoMin must be present
+// If both addr and port are specified (to 1.2.3.4:1234), NAT.RegProtoMin and NAT.RegAddrMin must be present.
+// The Max registers are only present when a range is specified.
+func NewExprNAT(parms, verdict string) (*NATParms, *[]expr.Any, error) {
+	natParms := &NATParms{Family: unix.NFPROTO_IPV4}
 	exprNAT := []expr.Any{}
-	NATParms := strings.Split(parms, " ")
-
-	idx := 0
-	// exclude first parameter if it's "to"
-	if NATParms[idx] == NFT_PARM_TO {
-		idx++
-	}
-	dParms := strings.Split(NATParms[idx], ":")
-	// masquerade doesn't allow "to IP"
-	if dParms[0] != "" && verdict != VERDICT_MASQUERADE {
-		dIP := dParms[0]
-		destIP := net.ParseIP(dIP)
-		if destIP == nil {
-			return regAddr, regProto, &exprNAT, fmt.Errorf("Invalid IP: %s", dIP)
+
+	dest := ""
+	for _, p := range strings.Fields(parms) {
+		if p == NFT_PARM_TO {
+			continue
 		}
+		if random, fullRandom, persistent := NewExprNATFlags(p); random || fullRandom || persistent {
+			continue
+		}
+		dest = p
+		break
+	}
+	if dest == "" {
+		return natParms, &exprNAT, nil
+	}
 
-		exprNAT = append(exprNAT, []expr.Any{
-			&expr.Immediate{
-				Register: 1,
-				Data:     destIP.To4(),
-			}}...)
-		regAddr = true
+	addr, port := splitNATDest(dest)
+	// masquerade and redirect don't allow "to IP"
+	if addr != "" && verdict != VERDICT_MASQUERADE && verdict != VERDICT_REDIRECT {
+		addrMin, addrMax, err := parseNATRange(addr, func(a string) ([]byte, error) {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP: %s", a)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				return ip4, nil
+			}
+			natParms.Family = unix.NFPROTO_IPV6
+			return ip.To16(), nil
+		})
+		if err != nil {
+			return natParms, &exprNAT, err
+		}
+		if addrMax != nil && len(addrMax) != len(addrMin) {
+			return natParms, &exprNAT, fmt.Errorf("Invalid IP range: %s", addr)
+		}
+		natParms.RegAddrMin = 1
+		exprNAT = append(exprNAT, &expr.Immediate{Register: natParms.RegAddrMin, Data: addrMin})
+		if addrMax != nil {
+			natParms.RegAddrMax = 2
+			exprNAT = append(exprNAT, &expr.Immediate{Register: natParms.RegAddrMax, Data: addrMax})
+		}
 	}
 
-	if len(dParms) == 2 {
-		dPort := dParms[1]
-		// TODO: support ranges. 9000-9100
-		destPort, err := strconv.Atoi(dPort)
+	if port != "" {
+		portMin, portMax, err := parseNATRange(port, func(p string) ([]byte, error) {
+			destPort, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid Port: %s", p)
+			}
+			return binaryutil.BigEndian.PutUint16(uint16(destPort)), nil
+		})
 		if err != nil {
-			return regAddr, regProto, &exprNAT, fmt.Errorf("Invalid Port: %s", dPort)
+			return natParms, &exprNAT, err
 		}
-		reg := uint32(2)
-		if verdict == VERDICT_TPROXY || verdict == VERDICT_MASQUERADE || verdict == VERDICT_REDIRECT {
-			reg = 1
+		// the ports are loaded after the addresses, if any.
+		natParms.RegProtoMin = 1
+		if natParms.RegAddrMax != 0 {
+			natParms.RegProtoMin = 3
+		} else if natParms.RegAddrMin != 0 {
+			natParms.RegProtoMin = 2
+		}
+		exprNAT = append(exprNAT, &expr.Immediate{Register: natParms.RegProtoMin, Data: portMin})
+		if portMax != nil {
+			natParms.RegProtoMax = natParms.RegProtoMin + 1
+			exprNAT = append(exprNAT, &expr.Immediate{Register: natParms.RegProtoMax, Data: portMax})
 		}
-		exprNAT = append(exprNAT, []expr.Any{
-			&expr.Immediate{
-				Register: reg,
-				Data:     binaryutil.BigEndian.PutUint16(uint16(destPort)),
-			}}...)
-		regProto = true
 	}
 
-	return regAddr, regProto, &exprNAT, nil
+	return natParms, &exprNAT, nil
+}
+
+// splitNATDest splits the address and the port of a NAT destination:
+// 1.2.3.4:80, 1.2.3.4, :80, [2001:db8::1]:80, 2001:db8::1
+func splitNATDest(dest string) (addr, port string) {
+	if strings.HasPrefix(dest, "[") {
+		if end := strings.Index(dest, "]"); end > 0 {
+			return dest[1:end], strings.TrimPrefix(dest[end+1:], ":")
+		}
+	}
+	// IPv6 address without port
+	if strings.Count(dest, ":") > 1 {
+		return dest, ""
+	}
+	parts := strings.Split(dest, ":")
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// parseNATRange parses a single value or a range of values (min-max).
+// max is nil if it's not a range.
+func parseNATRange(value string, parse func(string) ([]byte, error)) (min, max []byte, err error) {
+	values := strings.Split(value, "-")
+	if len(values) > 2 {
+		return nil, nil, fmt.Errorf("Invalid range: %s", value)
+	}
+	if min, err = parse(values[0]); err != nil {
+		return nil, nil, err
+	}
+	if len(values) == 2 {
+		if max, err = parse(values[1]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return min, max, nil
 }
 
 // NewExprMasquerade returns a new masquerade expression.
@@ -102,39 +180,89 @@ func NewExprMasquerade(toPorts, random, fullRandom, persistent bool) *[]expr.Any
 }
 
 // NewExprRedirect returns a new redirect expression.
-func NewExprRedirect() *[]expr.Any {
+// If no ports are specified, the packets are redirected to the same port.
+func NewExprRedirect(natParms *NATParms) *[]expr.Any {
 	return &[]expr.Any{
 		// Redirect is a special case of DNAT where the destination is the current machine
 		&expr.Redir{
-			RegisterProtoMin: 1,
+			RegisterProtoMin: natParms.RegProtoMin,
+			RegisterProtoMax: natParms.RegProtoMax,
 		},
 	}
 }
 
 // NewExprSNAT returns a new snat expression.
-func NewExprSNAT() *expr.NAT {
-	return &expr.NAT{
-		Type:   expr.NATTypeSourceNAT,
-		Family: unix.NFPROTO_IPV4,
-	}
+func NewExprSNAT(natParms *NATParms) *expr.NAT {
+	return newExprNAT(expr.NATTypeSourceNAT, natParms)
 }
 
 // NewExprDNAT returns a new dnat expression.
-func NewExprDNAT() *expr.NAT {
+func NewExprDNAT(natParms *NATParms) *expr.NAT {
+	return newExprNAT(expr.NATTypeDestNAT, natParms)
+}
+
+func newExprNAT(natType expr.NATType, natParms *NATParms) *expr.NAT {
 	return &expr.NAT{
-		Type:   expr.NATTypeDestNAT,
-		Family: unix.NFPROTO_IPV4,
+		Type:        natType,
+		Family:      natParms.Family,
+		RegAddrMin:  natParms.RegAddrMin,
+		RegAddrMax:  natParms.RegAddrMax,
+		RegProtoMin: natParms.RegProtoMin,
+		RegProtoMax: natParms.RegProtoMax,
 	}
 }
 
-// NewExprTproxy returns a new tproxy expression.
-// XXX: is "to x.x.x.x:1234" supported by google/nftables lib? or only "to :1234"?
-// it creates an erronous rule.
-func NewExprTproxy() *[]expr.Any {
-	return &[]expr.Any{
-		&expr.TProxy{
-			Family:      byte(nftables.TableFamilyIPv4),
-			TableFamily: byte(nftables.TableFamilyIPv4),
-			RegPort:     1,
-		}}
+// NewExprTproxy returns a new tproxy expression, to redirect the packets to
+// a local proxy, without modifying them. It's only valid in prerouting chains.
+// Optionally the packets can be marked, to route them to the local machine:
+// "Target": "tproxy", "TargetParameters": "to :8080 mark 1"
+//
+// The google/nftables lib only supports redirecting to a port: "to :1234",
+// so the proxy must listen on the destination address of the packets.
+//
+// nft add rule inet mangle prerouting tcp dport 80 tproxy to :8080 meta mark set 1 accept
+//
+//	[ immediate reg 1 0x0000901f ]
+//	[ tproxy port reg 1 ]
+//	[ immediate reg 1 0x00000001 ]
+//	[ meta set mark with reg 1 ]
+//	[ immediate reg 0 accept ]
+func NewExprTproxy(family, parms string) (*[]expr.Any, error) {
+	natParms, tproxyExpr, err := NewExprNAT(parms, VERDICT_TPROXY)
+	if err != nil {
+		return nil, err
+	}
+	if natParms.RegAddrMin != 0 {
+		return nil, fmt.Errorf("tproxy to an address is not supported, only to a port: to :1234")
+	}
+	if natParms.RegProtoMin == 0 || natParms.RegProtoMax != 0 {
+		return nil, fmt.Errorf("tproxy requires a port: to :1234 (%s)", parms)
+	}
+
+	// no address specified, on inet tables it's valid for IPv4 and IPv6.
+	tproxyFamily := unix.NFPROTO_UNSPEC
+	switch family {
+	case NFT_FAMILY_IP:
+		tproxyFamily = unix.NFPROTO_IPV4
+	case NFT_FAMILY_IP6:
+		tproxyFamily = unix.NFPROTO_IPV6
+	}
+	*tproxyExpr = append(*tproxyExpr, &expr.TProxy{
+		Family:  byte(tproxyFamily),
+		RegPort: natParms.RegProtoMin,
+	})
+
+	tParms := strings.Fields(parms)
+	for i, p := range tParms {
+		if p != NFT_META_MARK || i+1 >= len(tParms) {
+			continue
+		}
+		mark, err := ParseMark(tParms[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tproxy mark: %s", tParms[i+1])
+		}
+		*tproxyExpr = append(*tproxyExpr, *NewExprMetaSetMark(mark)...)
+	}
+
+	return tproxyExpr, nil
 }
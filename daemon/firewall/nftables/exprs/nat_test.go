@@ -0,0 +1,95 @@
+package exprs
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSplitNATDest(t *testing.T) {
+	tests := []struct {
+		dest string
+		addr string
+		port string
+	}{
+		{"1.2.3.4:80", "1.2.3.4", "80"},
+		{"1.2.3.4", "1.2.3.4", ""},
+		{":80", "", "80"},
+		{"1.2.3.4-1.2.3.10:80-90", "1.2.3.4-1.2.3.10", "80-90"},
+		{"[2001:db8::1]:80", "2001:db8::1", "80"},
+		{"[2001:db8::1]", "2001:db8::1", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dest, func(t *testing.T) {
+			addr, port := splitNATDest(test.dest)
+			if addr != test.addr || port != test.port {
+				t.Errorf("got %q, %q, want %q, %q", addr, port, test.addr, test.port)
+			}
+		})
+	}
+}
+
+func TestNewExprNAT(t *testing.T) {
+	tests := []struct {
+		name    string
+		parms   string
+		verdict string
+		want    NATParms
+		exprs   int
+		wantErr bool
+	}{
+		{"no destination", "random", VERDICT_SNAT, NATParms{Family: unix.NFPROTO_IPV4}, 0, false},
+		{
+			"address", "to 1.2.3.4", VERDICT_SNAT,
+			NATParms{Family: unix.NFPROTO_IPV4, RegAddrMin: 1}, 1, false,
+		},
+		{
+			"port", "to :8080", VERDICT_REDIRECT,
+			NATParms{Family: unix.NFPROTO_IPV4, RegProtoMin: 1}, 1, false,
+		},
+		{
+			"address and port", "to 1.2.3.4:80 persistent", VERDICT_DNAT,
+			NATParms{Family: unix.NFPROTO_IPV4, RegAddrMin: 1, RegProtoMin: 2}, 2, false,
+		},
+		{
+			"ranges", "to 1.2.3.4-1.2.3.10:80-90", VERDICT_DNAT,
+			NATParms{Family: unix.NFPROTO_IPV4, RegAddrMin: 1, RegAddrMax: 2, RegProtoMin: 3, RegProtoMax: 4}, 4, false,
+		},
+		{
+			"IPv6 address and port", "to [2001:db8::1]:80", VERDICT_DNAT,
+			NATParms{Family: unix.NFPROTO_IPV6, RegAddrMin: 1, RegProtoMin: 2}, 2, false,
+		},
+		{
+			// masquerade and redirect only allow ports.
+			"redirect to address", "to 1.2.3.4:80", VERDICT_REDIRECT,
+			NATParms{Family: unix.NFPROTO_IPV4, RegProtoMin: 1}, 1, false,
+		},
+		{"invalid IP", "to 1.2.3:80", VERDICT_DNAT, NATParms{}, 0, true},
+		{"invalid port", "to 1.2.3.4:http", VERDICT_DNAT, NATParms{}, 0, true},
+		{"mixed families range", "to 1.2.3.4-2001:db8::1", VERDICT_SNAT, NATParms{}, 0, true},
+		{"invalid range", "to :80-90-100", VERDICT_DNAT, NATParms{}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			natParms, exprNAT, err := NewExprNAT(test.parms, test.verdict)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", *natParms)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *natParms != test.want {
+				t.Errorf("got %+v, want %+v", *natParms, test.want)
+			}
+			if len(*exprNAT) != test.exprs {
+				t.Errorf("got %d expressions, want %d", len(*exprNAT), test.exprs)
+			}
+		})
+	}
+}
@@ -112,13 +112,18 @@ func NewExprProtocol(proto string) (*[]expr.Any, error) {
 
 // NewExprProtoSet creates a new list of SetElements{}, to match
 // multiple protocol values.
-func NewExprProtoSet(l4prots string) *[]nftables.SetElement {
+// "Value": "tcp,udp"
+func NewExprProtoSet(l4prots string) (*[]nftables.SetElement, error) {
 	protoList := strings.Split(l4prots, ",")
 	protoSet := []nftables.SetElement{}
 	for _, name := range protoList {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
 		pcode, err := getProtocolCode(name)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
 		protoSet = append(protoSet,
@@ -126,18 +131,41 @@ func NewExprProtoSet(l4prots string) *[]nftables.SetElement {
 				{Key: []byte{byte(pcode)}},
 			}...)
 	}
+	if len(protoSet) == 0 {
+		return nil, fmt.Errorf("empty list of protocols: %s", l4prots)
+	}
+
+	return &protoSet, nil
+}
 
-	return &protoSet
+// NewExprL4ProtoSet returns a new expression to match the protocol against
+// the elements of a set.
+// nft add rule inet filter input meta l4proto { tcp, udp } th dport 53 accept
+//
+//	[ meta load l4proto => reg 1 ]
+//	[ lookup reg 1 set __set%d ]
+func NewExprL4ProtoSet(set *nftables.Set, cmpOp *expr.CmpOp) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Lookup{
+			SourceRegister: 1,
+			SetName:        set.Name,
+			SetID:          set.ID,
+			Invert:         *cmpOp == expr.CmpOpNeq,
+		},
+	}
 }
 
 // NewExprL4Proto returns a new expression to match a protocol.
-func NewExprL4Proto(name string, cmpOp *expr.CmpOp) *[]expr.Any {
-	proto, _ := getProtocolCode(name)
+func NewExprL4Proto(name string, cmpOp *expr.CmpOp) (*[]expr.Any, error) {
+	proto, err := getProtocolCode(strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return nil, err
+	}
 	return &[]expr.Any{
 		&expr.Cmp{
 			Op:       *cmpOp,
 			Register: 1,
 			Data:     []byte{byte(proto)},
 		},
-	}
+	}, nil
 }
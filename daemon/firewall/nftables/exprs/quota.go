@@ -8,9 +8,34 @@ import (
 	"github.com/google/nftables/expr"
 )
 
+// NFT_OBJECT_QUOTA is the type of the quota stateful objects.
+const NFT_OBJECT_QUOTA = 2
+
 // NewQuota returns a new quota expression.
-// TODO: named quotas
+// Named quotas are created with NewExprQuotaRef().
 func NewQuota(opts []*config.ExprValues) (*[]expr.Any, error) {
+	quota, err := ParseQuota(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &[]expr.Any{quota}, nil
+}
+
+// NewExprQuotaRef returns a new expression that references a named quota.
+// The quota must exist in the table before adding the rule.
+// nft add rule inet filter output ip daddr 1.1.1.1 quota name my-quota drop
+func NewExprQuotaRef(name string) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Objref{
+			Type: NFT_OBJECT_QUOTA,
+			Name: name,
+		},
+	}
+}
+
+// ParseQuota parses the options of a quota statement.
+// {"Key": "over", "Value": ""}, {"Key": "mbytes", "Value": "500"}
+func ParseQuota(opts []*config.ExprValues) (*expr.Quota, error) {
 	over := false
 	bytes := int64(0)
 	used := int64(0)
@@ -54,11 +79,9 @@ func NewQuota(opts []*config.ExprValues) (*[]expr.Any, error) {
 	if bytes == 0 {
 		return nil, fmt.Errorf("quota bytes cannot be 0")
 	}
-	return &[]expr.Any{
-		&expr.Quota{
-			Bytes:    uint64(bytes),
-			Consumed: uint64(used),
-			Over:     over,
-		},
+	return &expr.Quota{
+		Bytes:    uint64(bytes),
+		Consumed: uint64(used),
+		Over:     over,
 	}, nil
 }
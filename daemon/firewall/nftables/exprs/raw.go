@@ -0,0 +1,61 @@
+package exprs
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// libExpr is the interface of the expressions of the nftables lib.
+// Its methods are not exported, so the expressions not supported by the lib
+// embed it (with a nil value) only to be stored along with the other
+// expressions of a rule. The lib can't marshal them: the rules with these
+// expressions are always built by us, with their own Marshal() method
+// (see netlink.go).
+type libExpr = expr.Any
+
+// flags of the type of the netlink attributes.
+const attrFlags = unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER
+
+// parseRawExpr returns the attributes of the data of an expression encoded
+// by Marshal(), checking that it's the expected one. The flags are removed
+// from the type of the attributes.
+func parseRawExpr(name string, raw []byte) ([]syscall.NetlinkRouteAttr, error) {
+	attrs, err := nl.ParseRouteAttr(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s expression: %s", name, err)
+	}
+	exprName := ""
+	var data []syscall.NetlinkRouteAttr
+	for _, attr := range attrs {
+		switch attr.Attr.Type &^ attrFlags {
+		case unix.NFTA_EXPR_NAME:
+			exprName = strings.TrimRight(string(attr.Value), "\x00")
+		case unix.NFTA_EXPR_DATA:
+			if data, err = nl.ParseRouteAttr(attr.Value); err != nil {
+				return nil, fmt.Errorf("invalid %s expression data: %s", name, err)
+			}
+		}
+	}
+	if exprName != name {
+		return nil, fmt.Errorf("invalid expression %s, expected %s", exprName, name)
+	}
+	for i := range data {
+		data[i].Attr.Type &^= attrFlags
+	}
+
+	return data, nil
+}
+
+// attrUint32 returns the value of a 32 bits attribute, in network byte order.
+func attrUint32(attr syscall.NetlinkRouteAttr) (uint32, error) {
+	if len(attr.Value) < 4 {
+		return 0, fmt.Errorf("invalid attribute %d length: %d", attr.Attr.Type, len(attr.Value))
+	}
+	return binaryutil.BigEndian.Uint32(attr.Value[:4]), nil
+}
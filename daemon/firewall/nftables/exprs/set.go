@@ -0,0 +1,148 @@
+package exprs
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/google/nftables"
+)
+
+// IsIPList checks if the value of an IP statement must be compiled into a set:
+// a list of IPs separated by commas, or a network range in CIDR notation.
+// "Value": "1.1.1.1,8.8.8.8,10.0.0.0/8,192.168.1.10-192.168.1.20"
+func IsIPList(value string) bool {
+	return strings.Index(value, ",") != -1 || strings.Index(value, "/") != -1
+}
+
+// NewExprIPSetElements parses a list of IPs, CIDRs and IP ranges separated by
+// commas, and returns the elements of an interval set of IPv4 addresses, or of
+// IPv6 addresses if ipv6 is true (nftables.TypeIP6Addr). The addresses of
+// the other version are rejected, since a set only holds one type of keys.
+// Every range is defined by 2 elements, the first address of the range and
+// the address following the last one, flagged as IntervalEnd:
+// 10.0.0.0/8 -> [10.0.0.0, 11.0.0.0)
+//
+// The kernel doesn't allow overlapping intervals, so the ranges that overlap
+// or are contiguous are merged, like nft does with the auto-merge flag:
+// 10.0.0.0/8,10.1.0.0/16,11.0.0.0/8 -> [10.0.0.0, 12.0.0.0)
+func NewExprIPSetElements(value string, ipv6 bool) (*[]nftables.SetElement, error) {
+	type ipRange struct {
+		start net.IP
+		end   net.IP
+	}
+	ranges := []ipRange{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var start, end net.IP
+		if strings.Index(item, "/") != -1 {
+			_, ipNet, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid network range: %s", item)
+			}
+			start, end = getCIDRBounds(ipNet)
+		} else if strings.Index(item, "-") != -1 {
+			ips := strings.Split(item, "-")
+			start = net.ParseIP(ips[0])
+			end = net.ParseIP(ips[len(ips)-1])
+		} else {
+			start = net.ParseIP(item)
+			end = start
+		}
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("Invalid IP: %s", item)
+		}
+		if start, end = toIPVersion(start, ipv6), toIPVersion(end, ipv6); start == nil || end == nil {
+			return nil, fmt.Errorf("IPv4 and IPv6 addresses can't be mixed: %s", item)
+		}
+		if bytes.Compare(start, end) > 0 {
+			return nil, fmt.Errorf("Invalid IPs range: %s", item)
+		}
+		ranges = append(ranges, ipRange{start, end})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("Empty IPs list")
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+	merged := []ipRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		// the last range reaches the last address, so it includes the rest.
+		next := nextIP(last.end)
+		if next == nil {
+			break
+		}
+		if bytes.Compare(r.start, next) > 0 {
+			merged = append(merged, r)
+			continue
+		}
+		if bytes.Compare(r.end, last.end) > 0 {
+			last.end = r.end
+		}
+	}
+
+	setElements := []nftables.SetElement{}
+	for _, r := range merged {
+		setElements = append(setElements, nftables.SetElement{Key: r.start})
+		// the last address (255.255.255.255, ffff:...:ffff) has no
+		// following address, so the interval remains open.
+		if next := nextIP(r.end); next != nil {
+			setElements = append(setElements, nftables.SetElement{Key: next, IntervalEnd: true})
+		}
+	}
+
+	return &setElements, nil
+}
+
+// toIPVersion returns the IP in the format of its version (4 or 16 bytes),
+// or nil if it's not of the version given.
+func toIPVersion(ip net.IP, ipv6 bool) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		if ipv6 {
+			return nil
+		}
+		return ip4
+	}
+	if !ipv6 {
+		return nil
+	}
+	return ip.To16()
+}
+
+// getCIDRBounds returns the first and the last address of a network.
+func getCIDRBounds(ipNet *net.IPNet) (net.IP, net.IP) {
+	start := ipNet.IP.Mask(ipNet.Mask)
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^ipNet.Mask[i]
+	}
+	return start, end
+}
+
+// nextIP returns the address following the given one, or nil on overflow.
+func nextIP(ip net.IP) net.IP {
+	return net.IP(nextKey(ip))
+}
+
+// nextKey returns the big endian value following the given one, or nil on
+// overflow. It's used to calculate the end of the intervals of a set.
+func nextKey(key []byte) []byte {
+	next := make([]byte, len(key))
+	copy(next, key)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package exprs
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewExprIPSetElements(t *testing.T) {
+	type element struct {
+		key string
+		end bool
+	}
+	tests := []struct {
+		name    string
+		value   string
+		ipv6    bool
+		want    []element
+		wantErr bool
+	}{
+		{
+			"single IPs",
+			"1.1.1.1,8.8.8.8",
+			false,
+			[]element{{"1.1.1.1", false}, {"1.1.1.2", true}, {"8.8.8.8", false}, {"8.8.8.9", true}},
+			false,
+		},
+		{
+			"CIDR",
+			"10.0.0.0/8",
+			false,
+			[]element{{"10.0.0.0", false}, {"11.0.0.0", true}},
+			false,
+		},
+		{
+			"range",
+			"192.168.1.10-192.168.1.20",
+			false,
+			[]element{{"192.168.1.10", false}, {"192.168.1.21", true}},
+			false,
+		},
+		{
+			"overlapping and contiguous ranges are merged",
+			"10.1.0.0/16, 10.0.0.0/8,11.0.0.0/8",
+			false,
+			[]element{{"10.0.0.0", false}, {"12.0.0.0", true}},
+			false,
+		},
+		{
+			"open interval at the end of the address space",
+			"255.255.255.0/24,255.255.255.255",
+			false,
+			[]element{{"255.255.255.0", false}},
+			false,
+		},
+		{
+			"IPv6 addresses and CIDRs",
+			"2001:db8::1,2001:db8:1::/48, 2001:db8:1:1::/64",
+			true,
+			[]element{{"2001:db8::1", false}, {"2001:db8::2", true}, {"2001:db8:1::", false}, {"2001:db8:2::", true}},
+			false,
+		},
+		{
+			"IPv6 range",
+			"2001:db8::1-2001:db8::10",
+			true,
+			[]element{{"2001:db8::1", false}, {"2001:db8::11", true}},
+			false,
+		},
+		{
+			"IPv6 open interval at the end of the address space",
+			"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00/120",
+			true,
+			[]element{{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00", false}},
+			false,
+		},
+		{"invalid IP", "1.1.1.1,not-an-ip", false, nil, true},
+		{"invalid CIDR", "10.0.0.0/33", false, nil, true},
+		{"reversed range", "1.1.1.10-1.1.1.1", false, nil, true},
+		{"empty list", " , ", false, nil, true},
+		{"IPv6 address in an IPv4 set", "1.1.1.1,2001:db8::1", false, nil, true},
+		{"IPv6 CIDR in an IPv4 set", "2001:db8::/32", false, nil, true},
+		{"IPv4 address in an IPv6 set", "2001:db8::1,1.1.1.1", true, nil, true},
+		{"mixed range", "1.1.1.1-2001:db8::10", true, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			elements, err := NewExprIPSetElements(test.value, test.ipv6)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", *elements)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range *elements {
+				if len(e.Key) != net.IPv4len && !test.ipv6 || len(e.Key) != net.IPv6len && test.ipv6 {
+					t.Fatalf("invalid key length %d", len(e.Key))
+				}
+			}
+			if len(*elements) != len(test.want) {
+				t.Fatalf("got %d elements, want %d: %v", len(*elements), len(test.want), *elements)
+			}
+			for i, e := range *elements {
+				if !net.IP(e.Key).Equal(net.ParseIP(test.want[i].key)) || e.IntervalEnd != test.want[i].end {
+					t.Errorf("element %d: got %s (end %v), want %s (end %v)",
+						i, net.IP(e.Key), e.IntervalEnd, test.want[i].key, test.want[i].end)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package exprs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// socket expression keys and attributes.
+// https://git.netfilter.org/libnftnl/tree/include/linux/netfilter/nf_tables.h
+const (
+	NFT_SOCKET_KEY_CGROUPV2 = 3
+
+	nftaSocketKey   = 1
+	nftaSocketDreg  = 2
+	nftaSocketLevel = 3
+)
+
+// Socket is the socket expression, to match packets by the socket they
+// belong to.
+// The nftables lib doesn't support it yet, so the rules with this expression
+// are built by us (see libExpr).
+type Socket struct {
+	libExpr
+	Key      uint32
+	Level    uint32
+	Register uint32
+}
+
+// Marshal returns the netlink attributes of the expression.
+func (s *Socket) Marshal() []byte {
+	data := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_EXPR_DATA, nil)
+	data.AddRtAttr(nftaSocketKey, binaryutil.BigEndian.PutUint32(s.Key))
+	data.AddRtAttr(nftaSocketDreg, binaryutil.BigEndian.PutUint32(s.Register))
+	if s.Key == NFT_SOCKET_KEY_CGROUPV2 {
+		data.AddRtAttr(nftaSocketLevel, binaryutil.BigEndian.PutUint32(s.Level))
+	}
+
+	return append(
+		nl.NewRtAttr(unix.NFTA_EXPR_NAME, nl.ZeroTerminated("socket")).Serialize(),
+		data.Serialize()...,
+	)
+}
+
+// Unmarshal decodes the netlink attributes of the expression, as encoded
+// by Marshal().
+func (s *Socket) Unmarshal(data []byte) error {
+	attrs, err := parseRawExpr("socket", data)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		value, err := attrUint32(attr)
+		if err != nil {
+			return fmt.Errorf("invalid socket expression: %s", err)
+		}
+		switch attr.Attr.Type {
+		case nftaSocketKey:
+			s.Key = value
+		case nftaSocketDreg:
+			s.Register = value
+		case nftaSocketLevel:
+			s.Level = value
+		}
+	}
+
+	return nil
+}
+
+// NewExprSocketCgroupv2 returns a new expression to match the packets of the
+// sockets that belong to the given cgroupv2 (or to its descendants).
+// The path is relative to the cgroupv2 mount point: system.slice/ssh.service
+//
+// nft --debug=netlink add rule inet filter output socket cgroupv2 level 2 "system.slice/ssh.service" accept
+//
+//	[ socket load cgroupv2 => reg 1 , level 2 ]
+//	[ cmp eq reg 1 0x00000a5c 0x00000000 ]
+func NewExprSocketCgroupv2(path string, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+	if cmpOp != expr.CmpOpEq && cmpOp != expr.CmpOpNeq {
+		return nil, fmt.Errorf("invalid operator for socket cgroupv2: %s", path)
+	}
+	mountPoint, err := core.GetCgroupv2MountPoint()
+	if err != nil {
+		return nil, err
+	}
+	path = strings.Trim(strings.TrimPrefix(filepath.Clean(path), mountPoint), "/")
+	if path == "" || path == "." {
+		return nil, fmt.Errorf("invalid cgroupv2 path: %s", path)
+	}
+
+	// the cgroup id is the kernfs node id of the cgroup directory.
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, filepath.Join(mountPoint, path), 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cgroupv2 %s: %s", path, err)
+	}
+	if len(handle.Bytes()) != 8 {
+		return nil, fmt.Errorf("invalid cgroupv2 %s id: %v", path, handle.Bytes())
+	}
+
+	return &[]expr.Any{
+		&Socket{
+			Key:      NFT_SOCKET_KEY_CGROUPV2,
+			Level:    uint32(len(strings.Split(path, "/"))),
+			Register: 1,
+		},
+		&expr.Cmp{
+			Op:       cmpOp,
+			Register: 1,
+			Data:     handle.Bytes(),
+		},
+	}, nil
+}
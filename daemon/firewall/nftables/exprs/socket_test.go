@@ -0,0 +1,58 @@
+package exprs
+
+import (
+	"testing"
+)
+
+func TestSocketMarshal(t *testing.T) {
+	tests := []struct {
+		name   string
+		socket Socket
+		want   Socket
+	}{
+		{
+			"cgroupv2",
+			Socket{Key: NFT_SOCKET_KEY_CGROUPV2, Level: 2, Register: 1},
+			Socket{Key: NFT_SOCKET_KEY_CGROUPV2, Level: 2, Register: 1},
+		},
+		{
+			// the level is only encoded for the cgroupv2 key.
+			"other key",
+			Socket{Key: 1, Level: 2, Register: 3},
+			Socket{Key: 1, Register: 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Socket{}
+			if err := got.Unmarshal(test.socket.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSocketUnmarshalInvalid(t *testing.T) {
+	synproxy := &Synproxy{Flags: NF_SYNPROXY_OPT_TIMESTAMP}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated", (&Socket{Key: NFT_SOCKET_KEY_CGROUPV2}).Marshal()[:10]},
+		{"other expression", synproxy.Marshal()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := Socket{}
+			if err := s.Unmarshal(test.data); err == nil {
+				t.Errorf("expected an error, got %+v", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package exprs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// synproxy expression attributes and options.
+// https://git.netfilter.org/libnftnl/tree/include/linux/netfilter/nf_tables.h
+// https://git.netfilter.org/libnftnl/tree/include/linux/netfilter/nf_synproxy.h
+const (
+	nftaSynproxyMss    = 1
+	nftaSynproxyWscale = 2
+	nftaSynproxyFlags  = 3
+
+	NF_SYNPROXY_OPT_MSS       = 0x01
+	NF_SYNPROXY_OPT_WSCALE    = 0x02
+	NF_SYNPROXY_OPT_SACK_PERM = 0x04
+	NF_SYNPROXY_OPT_TIMESTAMP = 0x08
+)
+
+// Synproxy is the synproxy statement, to answer the TCP handshakes on behalf
+// of the destination, and protect it from SYN floods.
+// The nftables lib doesn't support it yet, so the rules with this expression
+// are built by us (see libExpr).
+type Synproxy struct {
+	libExpr
+	Mss    uint16
+	Wscale uint8
+	Flags  uint32
+}
+
+// Marshal returns the netlink attributes of the expression.
+func (s *Synproxy) Marshal() []byte {
+	data := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_EXPR_DATA, nil)
+	if s.Flags&NF_SYNPROXY_OPT_MSS != 0 {
+		data.AddRtAttr(nftaSynproxyMss, binaryutil.BigEndian.PutUint16(s.Mss))
+	}
+	if s.Flags&NF_SYNPROXY_OPT_WSCALE != 0 {
+		data.AddRtAttr(nftaSynproxyWscale, []byte{s.Wscale})
+	}
+	data.AddRtAttr(nftaSynproxyFlags, binaryutil.BigEndian.PutUint32(s.Flags))
+
+	return append(
+		nl.NewRtAttr(unix.NFTA_EXPR_NAME, nl.ZeroTerminated("synproxy")).Serialize(),
+		data.Serialize()...,
+	)
+}
+
+// Unmarshal decodes the netlink attributes of the expression, as encoded
+// by Marshal().
+func (s *Synproxy) Unmarshal(data []byte) error {
+	attrs, err := parseRawExpr("synproxy", data)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case nftaSynproxyMss:
+			if len(attr.Value) < 2 {
+				return fmt.Errorf("invalid synproxy mss length: %d", len(attr.Value))
+			}
+			s.Mss = binaryutil.BigEndian.Uint16(attr.Value[:2])
+		case nftaSynproxyWscale:
+			if len(attr.Value) < 1 {
+				return fmt.Errorf("invalid synproxy wscale length: %d", len(attr.Value))
+			}
+			s.Wscale = attr.Value[0]
+		case nftaSynproxyFlags:
+			if s.Flags, err = attrUint32(attr); err != nil {
+				return fmt.Errorf("invalid synproxy flags: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewExprSynproxy returns a new synproxy statement.
+// The connections must not be tracked until the handshake has been completed,
+// so usually the SYN packets are excluded from conntrack with notrack, and
+// synproxy is applied to the untracked and invalid packets:
+//
+// nft add rule ip raw prerouting tcp dport 80 tcp flags syn notrack
+// nft add rule ip filter input tcp dport 80 ct state invalid,untracked synproxy mss 1460 wscale 7 timestamp sack-perm
+//
+//	[ synproxy mss 1460 wscale 7 ]
+//
+// {"Key": "mss", "Value": "1460"}, {"Key": "wscale", "Value": "7"}, {"Key": "timestamp"}, {"Key": "sack-perm"}
+func NewExprSynproxy(values []*config.ExprValues) (*[]expr.Any, error) {
+	synproxy := &Synproxy{}
+	for _, opt := range values {
+		switch strings.ToLower(opt.Key) {
+		case NFT_SYNPROXY_MSS:
+			mss, err := strconv.ParseUint(opt.Value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid synproxy mss: %s", opt.Value)
+			}
+			synproxy.Mss = uint16(mss)
+			synproxy.Flags |= NF_SYNPROXY_OPT_MSS
+		case NFT_SYNPROXY_WSCALE:
+			// the maximum window scale is 14 (RFC 7323).
+			wscale, err := strconv.ParseUint(opt.Value, 10, 8)
+			if err != nil || wscale > 14 {
+				return nil, fmt.Errorf("invalid synproxy wscale: %s", opt.Value)
+			}
+			synproxy.Wscale = uint8(wscale)
+			synproxy.Flags |= NF_SYNPROXY_OPT_WSCALE
+		case NFT_SYNPROXY_TIMESTAMP:
+			synproxy.Flags |= NF_SYNPROXY_OPT_TIMESTAMP
+		case NFT_SYNPROXY_SACK_PERM:
+			synproxy.Flags |= NF_SYNPROXY_OPT_SACK_PERM
+		default:
+			return nil, fmt.Errorf("invalid synproxy option: %s", opt.Key)
+		}
+	}
+
+	return &[]expr.Any{synproxy}, nil
+}
@@ -0,0 +1,37 @@
+package exprs
+
+import (
+	"testing"
+)
+
+func TestSynproxyMarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		synproxy Synproxy
+		want     Synproxy
+	}{
+		{
+			"all the options",
+			Synproxy{Mss: 1460, Wscale: 7, Flags: NF_SYNPROXY_OPT_MSS | NF_SYNPROXY_OPT_WSCALE | NF_SYNPROXY_OPT_TIMESTAMP | NF_SYNPROXY_OPT_SACK_PERM},
+			Synproxy{Mss: 1460, Wscale: 7, Flags: NF_SYNPROXY_OPT_MSS | NF_SYNPROXY_OPT_WSCALE | NF_SYNPROXY_OPT_TIMESTAMP | NF_SYNPROXY_OPT_SACK_PERM},
+		},
+		{
+			// the mss and wscale are only encoded if their flags are set.
+			"without mss and wscale",
+			Synproxy{Mss: 1460, Wscale: 7, Flags: NF_SYNPROXY_OPT_TIMESTAMP},
+			Synproxy{Flags: NF_SYNPROXY_OPT_TIMESTAMP},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Synproxy{}
+			if err := got.Unmarshal(test.synproxy.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
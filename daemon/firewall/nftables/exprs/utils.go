@@ -95,16 +95,24 @@ func GetICMPv6Type(icmpType string) uint8 {
 		return layers.ICMPv6TypeEchoRequest
 	case ICMP_ECHO_REPLY:
 		return layers.ICMPv6TypeEchoReply
-	case ICMP_ROUTER_SOLICITATION:
+	case ICMP_ROUTER_SOLICITATION, ICMP_ND_ROUTER_SOLICIT:
 		return layers.ICMPv6TypeRouterSolicitation
-	case ICMP_ROUTER_ADVERTISEMENT:
+	case ICMP_ROUTER_ADVERTISEMENT, ICMP_ND_ROUTER_ADVERT:
 		return layers.ICMPv6TypeRouterAdvertisement
-	case ICMP_NEIGHBOUR_SOLICITATION:
+	case ICMP_NEIGHBOUR_SOLICITATION, ICMP_ND_NEIGHBOR_SOLICIT:
 		return layers.ICMPv6TypeNeighborSolicitation
-	case ICMP_NEIGHBOUR_ADVERTISEMENT:
+	case ICMP_NEIGHBOUR_ADVERTISEMENT, ICMP_ND_NEIGHBOR_ADVERT:
 		return layers.ICMPv6TypeNeighborAdvertisement
-	case ICMP_REDIRECT:
+	case ICMP_REDIRECT, ICMP_ND_REDIRECT:
 		return layers.ICMPv6TypeRedirect
+	case ICMP_MLD_LISTENER_QUERY:
+		return 130
+	case ICMP_MLD_LISTENER_REPORT:
+		return 131
+	case ICMP_MLD_LISTENER_DONE:
+		return 132
+	case ICMP_MLD2_LISTENER_REPORT:
+		return 143
 	}
 	return 0
 }
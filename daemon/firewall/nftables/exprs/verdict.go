@@ -1,6 +1,7 @@
 package exprs
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -9,38 +10,44 @@ import (
 )
 
 // NewExprVerdict constructs a new verdict to apply on connections.
-func NewExprVerdict(verdict, parms string) *[]expr.Any {
-	switch strings.ToLower(verdict) {
+// The family of the table is needed by the NAT and tproxy verdicts.
+// The target can be empty, for rules like "ct set mark" or "log".
+func NewExprVerdict(family, verdict, parms string) (*[]expr.Any, error) {
+	verdict = strings.ToLower(verdict)
+	switch verdict {
 	case VERDICT_ACCEPT:
-		return NewExprAccept()
+		return NewExprAccept(), nil
 
 	case VERDICT_DROP:
 		return &[]expr.Any{&expr.Verdict{
 			Kind: expr.VerdictDrop,
-		}}
+		}}, nil
 
 	// FIXME: this verdict is not added to nftables
 	case VERDICT_STOP:
 		return &[]expr.Any{&expr.Verdict{
 			Kind: expr.VerdictStop,
-		}}
+		}}, nil
 
 	case VERDICT_REJECT:
 		reject := NewExprReject(parms)
-		return &[]expr.Any{reject}
+		return &[]expr.Any{reject}, nil
 
 	case VERDICT_RETURN:
 		return &[]expr.Any{&expr.Verdict{
 			Kind: expr.VerdictReturn,
-		}}
+		}}, nil
 
 	case VERDICT_JUMP:
+		if parms == "" {
+			return nil, fmt.Errorf("jump: the chain cannot be empty")
+		}
 		return &[]expr.Any{
 			&expr.Verdict{
 				Kind:  expr.VerdictKind(unix.NFT_JUMP),
 				Chain: parms,
 			},
-		}
+		}, nil
 
 	case VERDICT_QUEUE:
 		queueNum := 0
@@ -55,39 +62,24 @@ func NewExprVerdict(verdict, parms string) *[]expr.Any {
 				Num: uint16(queueNum),
 				// TODO: allow to configure this flag
 				Flag: expr.QueueFlagBypass,
-			}}
-
-	case VERDICT_SNAT:
-		snat := NewExprSNAT()
-		snat.Random, snat.FullyRandom, snat.Persistent = NewExprNATFlags(parms)
-		snatExpr := &[]expr.Any{snat}
+			}}, nil
 
-		if regAddr, regProto, natParms, err := NewExprNAT(parms, VERDICT_SNAT); err == nil {
-			if regAddr {
-				snat.RegAddrMin = 1
-			}
-			if regProto {
-				snat.RegProtoMin = 2
-			}
-			*snatExpr = append(*natParms, *snatExpr...)
+	case VERDICT_SNAT, VERDICT_DNAT:
+		natParms, natExpr, err := NewExprNAT(parms, verdict)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameters: %s", verdict, err)
 		}
-		return snatExpr
-
-	case VERDICT_DNAT:
-		dnat := NewExprDNAT()
-		dnat.Random, dnat.FullyRandom, dnat.Persistent = NewExprNATFlags(parms)
-		dnatExpr := &[]expr.Any{dnat}
-
-		if regAddr, regProto, natParms, err := NewExprNAT(parms, VERDICT_DNAT); err == nil {
-			if regAddr {
-				dnat.RegAddrMin = 1
-			}
-			if regProto {
-				dnat.RegProtoMin = 2
-			}
-			*dnatExpr = append(*natParms, *dnatExpr...)
+		// no address specified ("to :8080"), use the family of the table.
+		if natParms.RegAddrMin == 0 && family == NFT_FAMILY_IP6 {
+			natParms.Family = unix.NFPROTO_IPV6
 		}
-		return dnatExpr
+		nat := NewExprDNAT(natParms)
+		if verdict == VERDICT_SNAT {
+			nat = NewExprSNAT(natParms)
+		}
+		nat.Random, nat.FullyRandom, nat.Persistent = NewExprNATFlags(parms)
+		*natExpr = append(*natExpr, nat)
+		return natExpr, nil
 
 	case VERDICT_MASQUERADE:
 		m := &expr.Masq{}
@@ -95,37 +87,43 @@ func NewExprVerdict(verdict, parms string) *[]expr.Any {
 		masqExpr := &[]expr.Any{m}
 
 		if parms == "" {
-			return masqExpr
+			return masqExpr, nil
+		}
+		natParms, natExpr, err := NewExprNAT(parms, VERDICT_MASQUERADE)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameters: %s", verdict, err)
 		}
 		// if any of the flag is set to true, toPorts must be false
-		toPorts := !(m.Random == true || m.FullyRandom == true || m.Persistent == true)
+		toPorts := natParms.RegProtoMin != 0 && !(m.Random == true || m.FullyRandom == true || m.Persistent == true)
 		masqExpr = NewExprMasquerade(toPorts, m.Random, m.FullyRandom, m.Persistent)
-		if _, _, natParms, err := NewExprNAT(parms, VERDICT_MASQUERADE); err == nil {
-			*masqExpr = append(*natParms, *masqExpr...)
+		if toPorts {
+			(*masqExpr)[0].(*expr.Masq).RegProtoMax = natParms.RegProtoMax
+			*masqExpr = append(*natExpr, *masqExpr...)
 		}
 
-		return masqExpr
+		return masqExpr, nil
 
 	case VERDICT_REDIRECT:
-		if _, _, rewriteParms, err := NewExprNAT(parms, VERDICT_REDIRECT); err == nil {
-			redirExpr := NewExprRedirect()
-			*redirExpr = append(*rewriteParms, *redirExpr...)
-			return redirExpr
+		natParms, natExpr, err := NewExprNAT(parms, VERDICT_REDIRECT)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameters: %s", verdict, err)
 		}
+		*natExpr = append(*natExpr, *NewExprRedirect(natParms)...)
+		return natExpr, nil
 
 	case VERDICT_TPROXY:
-		if _, _, rewriteParms, err := NewExprNAT(parms, VERDICT_TPROXY); err == nil {
-			tproxyExpr := &[]expr.Any{}
-			*tproxyExpr = append(*tproxyExpr, *rewriteParms...)
-			tVerdict := NewExprTproxy()
-			*tproxyExpr = append(*tproxyExpr, *tVerdict...)
-			*tproxyExpr = append(*tproxyExpr, *NewExprAccept()...)
-			return tproxyExpr
+		tproxyExpr, err := NewExprTproxy(family, parms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameters: %s", verdict, err)
 		}
+		*tproxyExpr = append(*tproxyExpr, *NewExprAccept()...)
+		return tproxyExpr, nil
+
+	case "":
+		return &[]expr.Any{}, nil
 	}
 
-	// target can be empty, "ct set mark" or "log" for example
-	return &[]expr.Any{}
+	return nil, fmt.Errorf("invalid verdict: %s", verdict)
 }
 
 // NewExprAccept creates the accept verdict.
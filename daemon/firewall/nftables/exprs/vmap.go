@@ -0,0 +1,209 @@
+package exprs
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// NewExprVmap returns the expressions to select the field of the packet to
+// look up in a verdict map, the definition of the map, and its elements.
+// The first value defines the selector (protocol + field), and the rest of
+// values the elements of the map (key: verdict):
+//
+// "Name": "vmap",
+// "Values": [
+//   {"Key": "tcp", "Value": "dport"},
+//   {"Key": "22", "Value": "accept"},
+//   {"Key": "8000-8080", "Value": "jump my-chain"},
+//   {"Key": "23", "Value": "drop"}
+// ]
+//
+// nft add rule inet filter input tcp dport vmap { 22 : accept, 8000-8080 : jump my-chain, 23 : drop }
+//
+// The set returned doesn't have a table, it must be assigned by the caller before adding it.
+func NewExprVmap(family string, values []*config.ExprValues) (*[]expr.Any, *nftables.Set, *[]nftables.SetElement, error) {
+	if len(values) < 2 {
+		return nil, nil, nil, fmt.Errorf("vmap needs a selector and at least one element")
+	}
+	selector := values[0]
+	exprList := []expr.Any{}
+	set := &nftables.Set{
+		Anonymous: true,
+		Constant:  true,
+		IsMap:     true,
+		DataType:  nftables.TypeVerdict,
+	}
+
+	var parseKey func(string) ([]byte, []byte, error)
+	switch strings.ToLower(selector.Key) {
+	case NFT_FAMILY_IP:
+		if selector.Value != NFT_SADDR && selector.Value != NFT_DADDR {
+			return nil, nil, nil, fmt.Errorf("Invalid vmap ip selector: %s", selector.Value)
+		}
+		exprIP, _ := NewExprIP(family, nil, expr.CmpOpEq)
+		exprList = append(exprList, *exprIP...)
+		exprList = append(exprList, getExprIPPayload(selector.Value))
+		set.KeyType = nftables.TypeIPAddr
+		parseKey = parseVmapIPKey
+
+	default:
+		exprProto, err := NewExprProtocol(selector.Key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		exprPDir, err := NewExprPortDirection(selector.Value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		exprList = append(exprList, *exprProto...)
+		exprList = append(exprList, exprPDir)
+		set.KeyType = nftables.TypeInetService
+		parseKey = parseVmapPortKey
+	}
+
+	type vmapElem struct {
+		key        string
+		start, end []byte
+		verdict    *expr.Verdict
+	}
+	elems := []vmapElem{}
+	for _, elem := range values[1:] {
+		verdict, err := getVmapVerdict(elem.Value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		start, end, err := parseKey(elem.Key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !bytes.Equal(start, end) {
+			set.Interval = true
+		}
+		elems = append(elems, vmapElem{elem.Key, start, end, verdict})
+	}
+
+	// The kernel expects the elements of interval maps ordered, and rejects
+	// overlapping ranges, so validate them here to report the offending keys.
+	sort.SliceStable(elems, func(i, j int) bool {
+		return bytes.Compare(elems[i].start, elems[j].start) < 0
+	})
+	for i := 1; i < len(elems); i++ {
+		if bytes.Compare(elems[i].start, elems[i-1].end) <= 0 {
+			return nil, nil, nil, fmt.Errorf("Overlapping vmap elements: %s, %s", elems[i-1].key, elems[i].key)
+		}
+	}
+
+	// In interval maps every element is a range, so single keys are
+	// converted to ranges of 1 element: [key, key+1).
+	// The end of a range is omitted when the next one starts right after it.
+	setElements := []nftables.SetElement{}
+	for i, e := range elems {
+		setElements = append(setElements, nftables.SetElement{Key: e.start, VerdictData: e.verdict})
+		if !set.Interval {
+			continue
+		}
+		next := nextKey(e.end)
+		if next == nil || (i+1 < len(elems) && bytes.Equal(next, elems[i+1].start)) {
+			continue
+		}
+		setElements = append(setElements, nftables.SetElement{Key: next, IntervalEnd: true})
+	}
+
+	return &exprList, set, &setElements, nil
+}
+
+// NewExprVmapLookup returns the lookup expression of a verdict map.
+// The verdict of the element found is written to the verdict register.
+//	[ lookup reg 1 set __map%d dreg 0 ]
+func NewExprVmapLookup(set *nftables.Set) *[]expr.Any {
+	return &[]expr.Any{
+		&expr.Lookup{
+			SourceRegister: 1,
+			DestRegister:   0,
+			IsDestRegSet:   true,
+			SetName:        set.Name,
+			SetID:          set.ID,
+		},
+	}
+}
+
+// parseVmapPortKey parses a port or a range of ports, returning the first
+// and the last port.
+func parseVmapPortKey(key string) ([]byte, []byte, error) {
+	ports := strings.Split(key, "-")
+	start, err := strconv.ParseUint(ports[0], 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid vmap port: %s", key)
+	}
+	end, err := strconv.ParseUint(ports[len(ports)-1], 10, 16)
+	if err != nil || end < start {
+		return nil, nil, fmt.Errorf("Invalid vmap ports range: %s", key)
+	}
+	return binaryutil.BigEndian.PutUint16(uint16(start)), binaryutil.BigEndian.PutUint16(uint16(end)), nil
+}
+
+// parseVmapIPKey parses an IP, a range of IPs or a network range (CIDR),
+// returning the first and the last address.
+func parseVmapIPKey(key string) ([]byte, []byte, error) {
+	var start, end net.IP
+	if strings.Index(key, "/") != -1 {
+		_, ipNet, err := net.ParseCIDR(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid vmap network range: %s", key)
+		}
+		start, end = getCIDRBounds(ipNet)
+	} else {
+		ips := strings.Split(key, "-")
+		start = net.ParseIP(ips[0])
+		end = net.ParseIP(ips[len(ips)-1])
+	}
+	if start == nil || end == nil || start.To4() == nil || end.To4() == nil {
+		return nil, nil, fmt.Errorf("Invalid vmap IP: %s", key)
+	}
+	if bytes.Compare(start.To4(), end.To4()) > 0 {
+		return nil, nil, fmt.Errorf("Invalid vmap IPs range: %s", key)
+	}
+	return start.To4(), end.To4(), nil
+}
+
+// getVmapVerdict translates the verdict of a vmap element.
+// Only terminal verdicts and jumps to other chains are allowed.
+func getVmapVerdict(verdict string) (*expr.Verdict, error) {
+	// chain names are case sensitive, only the verdict is normalized.
+	parms := strings.Fields(verdict)
+	if len(parms) == 0 {
+		return nil, fmt.Errorf("Empty vmap verdict")
+	}
+	parms[0] = strings.ToLower(parms[0])
+	switch parms[0] {
+	case VERDICT_ACCEPT:
+		return &expr.Verdict{Kind: expr.VerdictAccept}, nil
+	case VERDICT_DROP:
+		return &expr.Verdict{Kind: expr.VerdictDrop}, nil
+	case VERDICT_RETURN:
+		return &expr.Verdict{Kind: expr.VerdictReturn}, nil
+	case VERDICT_CONTINUE:
+		return &expr.Verdict{Kind: expr.VerdictContinue}, nil
+	case VERDICT_JUMP, VERDICT_GOTO:
+		if len(parms) != 2 {
+			return nil, fmt.Errorf("Invalid vmap verdict, chain missing: %s", verdict)
+		}
+		kind := expr.VerdictKind(unix.NFT_JUMP)
+		if parms[0] == VERDICT_GOTO {
+			kind = expr.VerdictKind(unix.NFT_GOTO)
+		}
+		return &expr.Verdict{Kind: kind, Chain: parms[1]}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid vmap verdict: %s", verdict)
+}
@@ -0,0 +1,131 @@
+package exprs
+
+import (
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetVmapVerdict(t *testing.T) {
+	tests := []struct {
+		name    string
+		verdict string
+		want    expr.Verdict
+		wantErr bool
+	}{
+		{"accept", "accept", expr.Verdict{Kind: expr.VerdictAccept}, false},
+		{"drop, upper case", "DROP", expr.Verdict{Kind: expr.VerdictDrop}, false},
+		{"jump", "jump my-chain", expr.Verdict{Kind: expr.VerdictKind(unix.NFT_JUMP), Chain: "my-chain"}, false},
+		{"goto keeps the case of the chain", "GOTO My-Chain", expr.Verdict{Kind: expr.VerdictKind(unix.NFT_GOTO), Chain: "My-Chain"}, false},
+		{"empty", "", expr.Verdict{}, true},
+		{"jump without chain", "jump", expr.Verdict{}, true},
+		{"invalid", "queue", expr.Verdict{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := getVmapVerdict(test.verdict)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if *got != test.want {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewExprVmap(t *testing.T) {
+	type element struct {
+		port uint16
+		end  bool
+	}
+	tests := []struct {
+		name     string
+		values   []*config.ExprValues
+		interval bool
+		want     []element
+		wantErr  bool
+	}{
+		{
+			"single ports",
+			[]*config.ExprValues{{Key: "tcp", Value: "dport"}, {Key: "23", Value: "drop"}, {Key: "22", Value: "accept"}},
+			false,
+			[]element{{22, false}, {23, false}},
+			false,
+		},
+		{
+			"unordered ranges",
+			[]*config.ExprValues{{Key: "tcp", Value: "dport"}, {Key: "8000-8080", Value: "jump my-chain"}, {Key: "22", Value: "accept"}},
+			true,
+			[]element{{22, false}, {23, true}, {8000, false}, {8081, true}},
+			false,
+		},
+		{
+			"adjacent ranges",
+			[]*config.ExprValues{{Key: "udp", Value: "dport"}, {Key: "100-199", Value: "accept"}, {Key: "200-299", Value: "drop"}},
+			true,
+			[]element{{100, false}, {200, false}, {300, true}},
+			false,
+		},
+		{
+			"overlapping ranges",
+			[]*config.ExprValues{{Key: "tcp", Value: "dport"}, {Key: "8000-8080", Value: "accept"}, {Key: "8080", Value: "drop"}},
+			true,
+			nil,
+			true,
+		},
+		{
+			"duplicated port",
+			[]*config.ExprValues{{Key: "tcp", Value: "dport"}, {Key: "22", Value: "accept"}, {Key: "22", Value: "drop"}},
+			false,
+			nil,
+			true,
+		},
+		{
+			"selector only",
+			[]*config.ExprValues{{Key: "tcp", Value: "dport"}},
+			false,
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, set, elements, err := NewExprVmap(NFT_FAMILY_INET, test.values)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", elements)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if set.Interval != test.interval {
+				t.Errorf("interval = %v, want %v", set.Interval, test.interval)
+			}
+			if len(*elements) != len(test.want) {
+				t.Fatalf("got %d elements, want %d: %+v", len(*elements), len(test.want), *elements)
+			}
+			for i, e := range *elements {
+				if port := binaryutil.BigEndian.Uint16(e.Key); port != test.want[i].port || e.IntervalEnd != test.want[i].end {
+					t.Errorf("element %d = %d (end: %v), want %+v", i, port, e.IntervalEnd, test.want[i])
+				}
+				if !e.IntervalEnd && e.VerdictData == nil {
+					t.Errorf("element %d without verdict", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package nftables
+
+import (
+	"sync"
+
+	"github.com/google/nftables"
+)
+
+// store of flowtables added to the system.
+// Flowtables are referenced by the rules that offload connections to them
+// ("flow add @ft"), so as named sets and quotas, they're not deleted when the
+// rules are deleted.
+type sysFlowtablesT struct {
+	flowtables map[string]*nftables.Flowtable
+	sync.RWMutex
+}
+
+func (f *sysFlowtablesT) Add(key string, ft *nftables.Flowtable) {
+	f.Lock()
+	defer f.Unlock()
+	f.flowtables[key] = ft
+}
+
+func (f *sysFlowtablesT) Get(key string) *nftables.Flowtable {
+	f.RLock()
+	defer f.RUnlock()
+	return f.flowtables[key]
+}
+
+// List returns a copy of the flowtables, so they can be deleted while iterating it.
+func (f *sysFlowtablesT) List() map[string]*nftables.Flowtable {
+	f.RLock()
+	defer f.RUnlock()
+	list := make(map[string]*nftables.Flowtable, len(f.flowtables))
+	for k, v := range f.flowtables {
+		list[k] = v
+	}
+	return list
+}
+
+func (f *sysFlowtablesT) Del(key string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.flowtables, key)
+}
+
+// AddFlowtable queues a new flowtable attached to the given devices, if it
+// doesn't exist.
+// The flowtable is added to the system on the next Commit(), so it must be
+// called before adding the rule that references it, in the same batch.
+// nft add flowtable inet filter ft { hook ingress priority 0; devices = { eth0, eth1 }; flags offload; }
+func (n *Nft) AddFlowtable(tbl *nftables.Table, name string, devices []string, prio int32, flags nftables.FlowtableFlags) *nftables.Flowtable {
+	key := getObjKey(name, tbl)
+	if !n.dryRun {
+		if ft := sysFlowtables.Get(key); ft != nil {
+			return ft
+		}
+	}
+
+	ft := n.conn.AddFlowtable(&nftables.Flowtable{
+		Table:    tbl,
+		Name:     name,
+		Hooknum:  nftables.FlowtableHookIngress,
+		Priority: nftables.FlowtablePriorityRef(nftables.FlowtablePriority(prio)),
+		Devices:  devices,
+		Flags:    flags,
+	})
+	if !n.dryRun {
+		sysFlowtables.Add(key, ft)
+		sysRuleset.Added(tbl, nftMsgNewFlowtable, name, 0)
+	}
+
+	return ft
+}
+
+// delFlowtables queues the deletion of the flowtables we added.
+// It must be called after deleting the rules that reference them, otherwise
+// the kernel refuses to delete them.
+func (n *Nft) delFlowtables() {
+	for k, ft := range sysFlowtables.List() {
+		n.conn.DelFlowtable(ft)
+		sysFlowtables.Del(k)
+	}
+}
+
+// delUnusedFlowtables queues the deletion of the flowtables that are no longer
+// referenced by any rule.
+func (n *Nft) delUnusedFlowtables(refs *objRefs) {
+	for k, ft := range sysFlowtables.List() {
+		if refs.flowtables[k] {
+			continue
+		}
+		n.conn.DelFlowtable(ft)
+		sysFlowtables.Del(k)
+	}
+}
+
+// delTableFlowtables removes from the store the flowtables of the given table.
+// The flowtables are deleted from the system along with the table.
+func delTableFlowtables(tbl *nftables.Table) {
+	for k, ft := range sysFlowtables.List() {
+		if ft.Table.Name == tbl.Name && ft.Table.Family == tbl.Family {
+			sysFlowtables.Del(k)
+		}
+	}
+}
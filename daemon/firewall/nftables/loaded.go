@@ -0,0 +1,180 @@
+package nftables
+
+import (
+	"fmt"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// The configuration file only tells what the system firewall should look like.
+// In order to know what's actually loaded, we read back from the kernel the
+// chains and rules we manage (including the interception chains), and convert
+// them to the format of the configuration.
+//
+// The nftables lib can't convert the rules back to the expressions of the
+// configuration, so the system rules are identified by their UUID and the hash
+// of their definition (see userdata.go), and the configured definition is used.
+// The rules that can't be matched against the configuration are reported only
+// with their verdict.
+
+// loadedConfig holds the chains loaded in the kernel, in the format of the
+// system firewall configuration.
+type loadedConfig struct {
+	SystemRules []*loadedConfigChains
+	Version     uint32
+	Enabled     bool
+}
+
+type loadedConfigChains struct {
+	Chains []*config.FwChain
+}
+
+// GetFirewallRules returns the tables, chains and rules we manage, as they're
+// loaded in the kernel.
+func (n *Nft) GetFirewallRules() (*protocol.SysFirewall, error) {
+	n.SysConfig.RLock()
+	defer n.SysConfig.RUnlock()
+	n.Lock()
+	defer n.Unlock()
+
+	if n.conn == nil {
+		return nil, fmt.Errorf("%s netlink connection not active", logTag)
+	}
+	chains, err := n.conn.ListChains()
+	if err != nil {
+		return nil, fmt.Errorf("error listing nftables chains: %s", err)
+	}
+
+	configured := make(map[string]*config.FwRule)
+	for _, fwCfg := range n.SysConfig.SystemRules {
+		for _, chain := range fwCfg.Chains {
+			for _, r := range chain.Rules {
+				configured[r.UUID] = r
+			}
+		}
+	}
+
+	loaded := &loadedConfigChains{Chains: []*config.FwChain{}}
+	for _, c := range chains {
+		rules, err := n.conn.GetRule(c.Table, c)
+		if err != nil {
+			return nil, fmt.Errorf("error listing rules of %s, %s: %s", c.Name, c.Table.Name, err)
+		}
+		fwChain := getConfigChain(c)
+		for _, r := range rules {
+			meta := parseRuleMeta(r.UserData)
+			if meta == nil {
+				continue
+			}
+			fwChain.Rules = append(fwChain.Rules, getConfigRule(r, meta, configured[meta.UUID]))
+		}
+		// the chains that existed before, but where we haven't added any rule.
+		_, ours := sysChains.Load(getChainKey(c.Name, c.Table))
+		if !ours && len(fwChain.Rules) == 0 {
+			continue
+		}
+		loaded.Chains = append(loaded.Chains, fwChain)
+	}
+
+	return toSysFirewall(&loadedConfig{
+		Enabled:     n.SysConfig.Enabled,
+		Version:     n.SysConfig.Version,
+		SystemRules: []*loadedConfigChains{loaded},
+	})
+}
+
+// getConfigChain converts a chain loaded in the kernel to the format of the
+// configuration, without its rules.
+// The lib doesn't report the device of the ingress and egress hooks.
+func getConfigChain(c *nftables.Chain) *config.FwChain {
+	fwChain := &config.FwChain{
+		Name:   c.Name,
+		Table:  c.Table.Name,
+		Family: getFamilyName(c.Table.Family),
+		Type:   string(c.Type),
+		Rules:  []*config.FwRule{},
+	}
+	if c.Hooknum != nil {
+		fwChain.Hook = getHookName(c.Table.Family, *c.Hooknum)
+	}
+	if c.Priority != nil {
+		fwChain.Priority = fmt.Sprint(*c.Priority)
+	}
+	if c.Policy != nil {
+		fwChain.Policy = exprs.VERDICT_ACCEPT
+		if *c.Policy == nftables.ChainPolicyDrop {
+			fwChain.Policy = exprs.VERDICT_DROP
+		}
+	}
+
+	return fwChain
+}
+
+// getConfigRule converts a rule loaded in the kernel to the format of the
+// configuration.
+// If the rule has been loaded from the given configured rule, and it hasn't
+// changed since then, the configured definition is used.
+// The Position of the rule is its handle.
+func getConfigRule(r *nftables.Rule, meta *ruleMeta, cfgRule *config.FwRule) *config.FwRule {
+	if cfgRule != nil && meta.Origin == systemRuleKey && getSystemRuleHash(cfgRule) == meta.Hash {
+		return &config.FwRule{
+			UUID:             cfgRule.UUID,
+			Description:      cfgRule.Description,
+			Expressions:      cfgRule.Expressions,
+			Target:           cfgRule.Target,
+			TargetParameters: cfgRule.TargetParameters,
+			Position:         r.Handle,
+			Enabled:          true,
+		}
+	}
+
+	fwRule := &config.FwRule{
+		UUID:        meta.UUID,
+		Description: "rule not found in the configuration",
+		Expressions: []*config.Expressions{},
+		Position:    r.Handle,
+		Enabled:     true,
+	}
+	if meta.Origin == interceptionRuleKey {
+		fwRule.Description = "connections interception"
+	}
+	fwRule.Target, fwRule.TargetParameters = getRuleVerdict(r)
+
+	return fwRule
+}
+
+// getRuleVerdict returns the verdict of a rule loaded in the kernel, in the
+// format of the configuration.
+func getRuleVerdict(r *nftables.Rule) (target, parms string) {
+	for i := len(r.Exprs) - 1; i >= 0; i-- {
+		switch e := r.Exprs[i].(type) {
+		case *expr.Queue:
+			return exprs.VERDICT_QUEUE, fmt.Sprint(exprs.NFT_QUEUE_NUM, " ", e.Num)
+		case *expr.Reject:
+			return exprs.VERDICT_REJECT, ""
+		case *expr.Masq:
+			return exprs.VERDICT_MASQUERADE, ""
+		case *expr.Redir:
+			return exprs.VERDICT_REDIRECT, ""
+		case *expr.Verdict:
+			switch e.Kind {
+			case expr.VerdictAccept:
+				return exprs.VERDICT_ACCEPT, ""
+			case expr.VerdictDrop:
+				return exprs.VERDICT_DROP, ""
+			case expr.VerdictReturn:
+				return exprs.VERDICT_RETURN, ""
+			case expr.VerdictJump:
+				return exprs.VERDICT_JUMP, e.Chain
+			case expr.VerdictGoto:
+				return exprs.VERDICT_GOTO, e.Chain
+			}
+		}
+	}
+
+	return "", ""
+}
@@ -27,7 +27,7 @@ func (n *Nft) AreRulesLoaded() bool {
 			continue
 		}
 		for rdx, r := range rules {
-			if string(r.UserData) == interceptionRuleKey {
+			if isRuleOf(r.UserData, interceptionRuleKey) {
 				if c.Table.Name == exprs.NFT_CHAIN_FILTER && c.Name == exprs.NFT_HOOK_INPUT && rdx != 0 {
 					log.Warning("nftables DNS rule not in 1st position (%d)", rdx)
 					return false
@@ -51,22 +51,39 @@ func (n *Nft) AreRulesLoaded() bool {
 }
 
 // reloadConfCallback gets called after the configuration changes.
+// Only the differences between the rules loaded and the new ones are applied.
+// If it fails, the system rules are deleted and added again one by one.
 func (n *Nft) reloadConfCallback() {
 	log.Important("reloadConfCallback changed, reloading")
-	n.DeleteSystemRules(!common.ForcedDelRules, !common.RestoreChains, log.GetLogLevel() == log.DEBUG)
-	n.AddSystemRules(common.ReloadRules, !common.BackupChains)
+	n.SysConfig.RLock()
+	defer n.SysConfig.RUnlock()
+
+	n.exclusive(func() {
+		if err := n.reloadSystemRules(); err != nil {
+			log.Warning("%s error reloading system rules, adding them again: %s", logTag, err)
+			n.deleteSystemRules(!common.ForcedDelRules, !common.RestoreChains, log.GetLogLevel() == log.DEBUG)
+			n.addSystemRules(common.ReloadRules, !common.BackupChains)
+		}
+	})
 }
 
 // reloadRulesCallback gets called when the interception rules are not present.
 func (n *Nft) reloadRulesCallback() {
 	log.Important("nftables firewall rules changed, reloading")
-	n.DisableInterception(log.GetLogLevel() == log.DEBUG)
-	time.Sleep(time.Millisecond * 500)
-	n.EnableInterception()
+	n.exclusive(func() {
+		n.disableInterception(log.GetLogLevel() == log.DEBUG)
+		time.Sleep(time.Millisecond * 500)
+		n.enableInterception()
+	})
 }
 
-// preloadConfCallback gets called before the fw configuration is loaded
+// preloadConfCallback gets called before the fw configuration is loaded.
+// Once the firewall is running, the rules are not deleted, the changes are
+// applied by reloadConfCallback().
 func (n *Nft) preloadConfCallback() {
+	if n.IsRunning() {
+		return
+	}
 	log.Info("nftables config changed, reloading")
 	n.DeleteSystemRules(!common.ForcedDelRules, common.RestoreChains, log.GetLogLevel() == log.DEBUG)
 }
@@ -0,0 +1,170 @@
+package nftables
+
+import (
+	"syscall"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The nftables lib doesn't support yet some of the objects we need (quotas,
+// netdev chains, socket and synproxy expressions), so in these cases we build the netlink
+// messages ourselves.
+//
+// When there's a transaction in progress, these messages are queued in the
+// transaction, and sent along with the changes queued in the nftables
+// connection, in the same batch and in the same order they were queued.
+// Otherwise they're sent immediately, after the changes queued so far.
+
+// size of the batches from which the buffers of the socket are enlarged.
+const batchBufSize = 128 * 1024
+
+// newNftMsg returns a new nftables netlink message of the given type.
+func newNftMsg(msgType, flags int, tbl *nftables.Table) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest((unix.NFNL_SUBSYS_NFTABLES<<8)|msgType, unix.NLM_F_ACK|flags)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: uint8(tbl.Family), Version: unix.NFNETLINK_V0})
+	return req
+}
+
+// rawExpr is an expression not supported by the nftables lib, which encodes
+// its own netlink attributes.
+type rawExpr interface {
+	Marshal() []byte
+}
+
+// hasRawExprs checks if the rule has expressions not supported by the nftables lib.
+func hasRawExprs(rule *nftables.Rule) bool {
+	for _, e := range rule.Exprs {
+		if _, ok := e.(rawExpr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newRuleMsg returns a new message to insert the given rule.
+// The expressions supported by the nftables lib are encoded by the lib.
+func newRuleMsg(rule *nftables.Rule) (*nl.NetlinkRequest, error) {
+	req := newNftMsg(unix.NFT_MSG_NEWRULE, unix.NLM_F_CREATE, rule.Table)
+	req.AddData(nl.NewRtAttr(unix.NFTA_RULE_TABLE, nl.ZeroTerminated(rule.Table.Name)))
+	req.AddData(nl.NewRtAttr(unix.NFTA_RULE_CHAIN, nl.ZeroTerminated(rule.Chain.Name)))
+
+	exprList := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_RULE_EXPRESSIONS, nil)
+	for _, e := range rule.Exprs {
+		var data []byte
+		if rexpr, ok := e.(rawExpr); ok {
+			data = rexpr.Marshal()
+		} else {
+			raw, err := expr.Marshal(byte(rule.Table.Family), e)
+			if err != nil {
+				return nil, err
+			}
+			data = raw
+		}
+		exprList.AddRtAttr(unix.NLA_F_NESTED|unix.NFTA_LIST_ELEM, data)
+	}
+	req.AddData(exprList)
+
+	if rule.Position != 0 {
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_POSITION, binaryutil.BigEndian.PutUint64(rule.Position)))
+	}
+	if rule.UserData != nil {
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_USERDATA, rule.UserData))
+	}
+
+	return req, nil
+}
+
+// queuedMsgs returns the changes queued in the nftables connection as netlink
+// messages, and discards them from the connection.
+// The lib doesn't export the queued messages, so we flush them to a fake
+// socket that captures them.
+func (n *Nft) queuedMsgs() ([]*nl.NetlinkRequest, error) {
+	conn := n.conn
+	n.conn = NewNft()
+
+	msgs := []*nl.NetlinkRequest{}
+	conn.TestDial = func(req []netlink.Message) ([]netlink.Message, error) {
+		// the acks are requested with empty messages, and the begin and
+		// end of the batch are added by sendBatch().
+		if len(req) < 2 {
+			return nil, nil
+		}
+		for _, m := range req[1 : len(req)-1] {
+			msg := nl.NewNetlinkRequest(int(m.Header.Type), int(m.Header.Flags)|unix.NLM_F_ACK)
+			msg.AddRawData(m.Data)
+			msgs = append(msgs, msg)
+		}
+		return nil, nil
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// sendBatch sends the given messages to the kernel in a single batch,
+// and waits for the acknowledgement of all of them.
+func sendBatch(msgs ...*nl.NetlinkRequest) error {
+	sock, err := nl.Subscribe(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	if err := sock.SetReceiveTimeout(&unix.Timeval{Sec: 1}); err != nil {
+		return err
+	}
+
+	// the resource id of the batch messages is the subsystem.
+	batchHdr := &nl.Nfgenmsg{
+		NfgenFamily: unix.AF_UNSPEC,
+		Version:     unix.NFNETLINK_V0,
+		ResId:       nl.Swap16(unix.NFNL_SUBSYS_NFTABLES),
+	}
+	begin := nl.NewNetlinkRequest(unix.NFNL_MSG_BATCH_BEGIN, 0)
+	begin.AddData(batchHdr)
+	end := nl.NewNetlinkRequest(unix.NFNL_MSG_BATCH_END, 0)
+	end.AddData(batchHdr)
+
+	// the messages of a batch must be sent in the same buffer.
+	pending := make(map[uint32]bool)
+	batch := begin.Serialize()
+	for _, msg := range msgs {
+		batch = append(batch, msg.Serialize()...)
+		pending[msg.Seq] = true
+	}
+	batch = append(batch, end.Serialize()...)
+
+	// the default buffers of the socket are too small for big batches
+	// (i.e.: when restoring the ruleset).
+	if len(batch) > batchBufSize {
+		unix.SetsockoptInt(sock.GetFd(), unix.SOL_SOCKET, unix.SO_SNDBUFFORCE, len(batch))
+		unix.SetsockoptInt(sock.GetFd(), unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, len(batch))
+	}
+	if err := unix.Sendto(sock.GetFd(), batch, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		replies, _, err := sock.Receive()
+		if err != nil {
+			return err
+		}
+		for _, msg := range replies {
+			if !pending[msg.Header.Seq] || msg.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			delete(pending, msg.Header.Seq)
+			// if one of the messages fails, the whole batch is discarded.
+			if errno := -int32(nl.NativeEndian().Uint32(msg.Data[0:4])); errno != 0 {
+				return syscall.Errno(errno)
+			}
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package nftables
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"sync"
 
@@ -47,6 +48,13 @@ type Nft struct {
 
 	conn   *nftables.Conn
 	chains iptables.SystemChains
+	txn    transaction
+
+	// dryRun compiles the rules without adding them to the system, nor to
+	// the stores. Used to validate the configuration.
+	dryRun bool
+
+	eventsExitChan chan bool
 }
 
 // NewNft creates a new nftables object
@@ -54,6 +62,12 @@ func NewNft() *nftables.Conn {
 	return &nftables.Conn{}
 }
 
+// IsInUse checks if the system has nftables tables loaded.
+func IsInUse() bool {
+	tables, err := NewNft().ListTables()
+	return err == nil && len(tables) > 0
+}
+
 // Fw initializes a new nftables object
 func Fw() (*Nft, error) {
 	n := &Nft{
@@ -71,9 +85,9 @@ func (n *Nft) Name() string {
 
 // Init inserts the firewall rules and starts monitoring for firewall
 // changes.
-func (n *Nft) Init(qNum *int) {
+func (n *Nft) Init(qNum *int) error {
 	if n.IsRunning() {
-		return
+		return nil
 	}
 	initMapsStore()
 	n.SetQueueNum(qNum)
@@ -85,14 +99,41 @@ func (n *Nft) Init(qNum *int) {
 	n.NewSystemFwConfig(n.preloadConfCallback, n.reloadConfCallback)
 	n.LoadDiskConfiguration(!common.ReloadConf)
 
-	// start from a clean state
-	// The daemon may have exited unexpectedly, leaving residual fw rules, so we
-	// need to clean them up to avoid duplicated rules.
-	n.delInterceptionRules()
-	n.AddSystemRules(!common.ReloadRules, common.BackupChains)
-	n.EnableInterception()
+	n.SysConfig.RLock()
+	defer n.SysConfig.RUnlock()
+
+	var err error
+	n.exclusive(func() {
+		// start from a clean state
+		// The daemon may have exited unexpectedly, leaving residual fw rules, so we
+		// need to clean them up to avoid duplicated rules.
+		n.restoreRulesetFromDisk()
+		n.delInterceptionRules()
+		// save the current ruleset, to restore it on exit.
+		n.snapshotRuleset()
+
+		// add interception and system rules in a single batch, so if any of them
+		// fails, the firewall is not left half-configured.
+		// The batch is retried once, in case the error was transient (for
+		// example, another program modifying the ruleset at the same time).
+		for try := 1; try <= 2; try++ {
+			n.Begin()
+			n.addSystemRules(!common.ReloadRules, common.BackupChains)
+			n.enableInterception()
+			if err = n.CommitAll(); err == nil {
+				break
+			}
+			log.Warning("%s error applying firewall rules (attempt %d): %s", logTag, try, err)
+		}
+	})
+	if err != nil {
+		n.StopConfigWatcher()
+		return fmt.Errorf("%s error applying firewall rules: %s", logTag, err)
+	}
+	n.startEventsMonitor()
 
 	n.Running = true
+	return nil
 }
 
 // Stop deletes the firewall rules, allowing network traffic.
@@ -100,6 +141,7 @@ func (n *Nft) Stop() {
 	if n.IsRunning() == false {
 		return
 	}
+	n.stopEventsMonitor()
 	n.StopConfigWatcher()
 	n.StopCheckingRules()
 	n.CleanRules(log.GetLogLevel() == log.DEBUG)
@@ -109,11 +151,15 @@ func (n *Nft) Stop() {
 
 // EnableInterception adds firewall rules to intercept connections
 func (n *Nft) EnableInterception() {
-	if err := n.addInterceptionTables(); err != nil {
+	n.exclusive(n.enableInterception)
+}
+
+func (n *Nft) enableInterception() {
+	if err := n.Queue(n.addInterceptionTables); err != nil {
 		log.Error("Error while adding interception tables: %s", err)
 		return
 	}
-	if err := n.addInterceptionChains(); err != nil {
+	if err := n.Queue(n.addInterceptionChains); err != nil {
 		log.Error("Error while adding interception chains: %s", err)
 		return
 	}
@@ -124,40 +170,64 @@ func (n *Nft) EnableInterception() {
 	if err, _ := n.QueueConnections(common.EnableRule, common.EnableRule); err != nil {
 		log.Error("Error while running conntrack nftables rule: %s", err)
 	}
-	// start monitoring firewall rules to intercept network traffic.
-	n.NewRulesChecker(n.AreRulesLoaded, n.reloadRulesCallback)
+	// start monitoring firewall rules to intercept network traffic, once
+	// they're loaded.
+	n.afterCommit(func() {
+		n.NewRulesChecker(n.AreRulesLoaded, n.reloadRulesCallback)
+	})
 }
 
 // DisableInterception removes firewall rules to intercept outbound connections.
 func (n *Nft) DisableInterception(logErrors bool) {
+	n.exclusive(func() {
+		n.disableInterception(logErrors)
+	})
+}
+
+func (n *Nft) disableInterception(logErrors bool) {
 	n.StopCheckingRules()
 	n.delInterceptionRules()
 }
 
 // CleanRules deletes the rules we added.
 func (n *Nft) CleanRules(logErrors bool) {
-	n.DisableInterception(logErrors)
-	n.DeleteSystemRules(common.ForcedDelRules, common.RestoreChains, logErrors)
+	n.exclusive(func() {
+		n.disableInterception(logErrors)
+		n.deleteSystemRules(common.ForcedDelRules, common.RestoreChains, logErrors)
+	})
 }
 
 // Commit applies the queued changes, creating new objects (tables, chains, etc).
 // You add rules, chains or tables, and after calling to Flush() they're added to the system.
 // NOTE: it's very important not to call Flush() without queued tasks.
-func (n *Nft) Commit() bool {
+//
+// If there's a transaction in progress, the changes are not applied until
+// CommitAll() is called, and CommitDeferred is returned. The transaction can
+// only be in progress in the same goroutine, since the callers are serialized
+// by exclusive().
+func (n *Nft) Commit() CommitState {
+	if n.inTransaction() {
+		return CommitDeferred
+	}
 	if err := n.conn.Flush(); err != nil {
 		log.Warning("%s error applying changes: %s", logTag, err)
-		return false
+		return CommitFailed
 	}
-	return true
+	return CommitApplied
 }
 
 // Serialize converts the configuration from json to protobuf
 func (n *Nft) Serialize() (*protocol.SysFirewall, error) {
+	return toSysFirewall(&n.SysConfig)
+}
+
+// toSysFirewall converts a system firewall configuration to protobuf.
+func toSysFirewall(cfg interface{}) (*protocol.SysFirewall, error) {
 	sysfw := &protocol.SysFirewall{}
 	jun := jsonpb.Unmarshaler{
 		AllowUnknownFields: true,
 	}
-	rawConfig, err := json.Marshal(&n.SysConfig)
+	rawConfig, err := json.Marshal(cfg)
 	if err != nil {
 		log.Error("nftables.Serialize() struct to string error: %s", err)
 		return nil, err
@@ -1,11 +1,13 @@
 package nftables
 
 import (
+	"fmt"
+
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
-	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
 // nftables rules are composed of expressions, for example:
@@ -30,8 +32,11 @@ import (
 //
 // https://wiki.archlinux.org/title/Nftables#Expressions
 // https://wiki.nftables.org/wiki-nftables/index.php/Building_rules_through_expressions
-func (n *Nft) parseExpression(table, chain, family string, expression *config.Expressions) *[]expr.Any {
+func (n *Nft) parseExpression(table, chain, family string, expression *config.Expressions) (*[]expr.Any, error) {
 	var exprList []expr.Any
+	if expression.Statement == nil {
+		return nil, fmt.Errorf("empty statement")
+	}
 	cmpOp := exprs.NewOperator(expression.Statement.Op)
 
 	switch expression.Statement.Name {
@@ -39,16 +44,14 @@ func (n *Nft) parseExpression(table, chain, family string, expression *config.Ex
 	case exprs.NFT_CT:
 		exprCt := n.buildConntrackRule(expression.Statement.Values, &cmpOp)
 		if exprCt == nil {
-			log.Warning("%s Ct statement error", logTag)
-			return nil
+			return nil, fmt.Errorf("ct statement error")
 		}
 		exprList = append(exprList, *exprCt...)
 
 	case exprs.NFT_META:
 		metaExpr, err := exprs.NewExprMeta(expression.Statement.Values, &cmpOp)
 		if err != nil {
-			log.Warning("%s meta statement error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("meta statement error: %s", err)
 		}
 
 		for _, exprValue := range expression.Statement.Values {
@@ -56,82 +59,85 @@ func (n *Nft) parseExpression(table, chain, family string, expression *config.Ex
 			case exprs.NFT_META_L4PROTO:
 				l4rule, err := n.buildL4ProtoRule(table, family, exprValue.Value, &cmpOp)
 				if err != nil {
-					log.Warning("%s meta.l4proto statement error: %s", logTag, err)
-					return nil
+					return nil, fmt.Errorf("meta.l4proto statement error: %s", err)
 				}
 				*metaExpr = append(*metaExpr, *l4rule...)
 			case exprs.NFT_DPORT, exprs.NFT_SPORT:
 				exprPDir, err := exprs.NewExprPortDirection(exprValue.Key)
 				if err != nil {
-					log.Warning("%s ports statement error: %s", logTag, err)
-					return nil
+					return nil, fmt.Errorf("ports statement error: %s", err)
 				}
 				*metaExpr = append(*metaExpr, []expr.Any{exprPDir}...)
 				portsRule, err := n.buildPortsRule(table, family, exprValue.Value, &cmpOp)
 				if err != nil {
-					log.Warning("%s meta.l4proto.ports statement error: %s", logTag, err)
-					return nil
+					return nil, fmt.Errorf("meta.l4proto.ports statement error: %s", err)
 				}
 				*metaExpr = append(*metaExpr, *portsRule...)
 			}
 		}
-		return metaExpr
+		return metaExpr, nil
 
 	case exprs.NFT_ETHER:
 		etherExpr, err := exprs.NewExprEther(expression.Statement.Values)
 		if err != nil {
-			log.Warning("%s ether statement error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("ether statement error: %s", err)
+		}
+		return etherExpr, nil
+
+	case exprs.NFT_ARP:
+		arpExpr, err := exprs.NewExprARP(family, expression.Statement.Values, &cmpOp)
+		if err != nil {
+			return nil, fmt.Errorf("arp statement error: %s", err)
 		}
-		return etherExpr
+		return arpExpr, nil
 
 	// TODO: support iif, oif
 	case exprs.NFT_IIFNAME, exprs.NFT_OIFNAME:
 		isOut := expression.Statement.Name == exprs.NFT_OIFNAME
-		iface := expression.Statement.Values[0].Key
-		if iface == "" {
-			log.Warning("%s network interface statement error: %s", logTag, expression.Statement.Name)
-			return nil
+		if len(expression.Statement.Values) == 0 || expression.Statement.Values[0].Key == "" {
+			return nil, fmt.Errorf("network interface statement error: %s", expression.Statement.Name)
 		}
-		exprList = append(exprList, *exprs.NewExprIface(iface, isOut, cmpOp)...)
+		exprList = append(exprList, *exprs.NewExprIface(expression.Statement.Values[0].Key, isOut, cmpOp)...)
 
 	case exprs.NFT_FAMILY_IP, exprs.NFT_FAMILY_IP6:
-		exprIP, err := exprs.NewExprIP(family, expression.Statement.Values, cmpOp)
+		exprIP, err := n.buildIPRule(table, family, expression.Statement.Name, expression.Statement.Values, cmpOp)
 		if err != nil {
-			log.Warning("%s addr statement error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("addr statement error: %s", err)
 		}
 		exprList = append(exprList, *exprIP...)
 
 	case exprs.NFT_PROTO_ICMP, exprs.NFT_PROTO_ICMPv6:
-		exprICMP := n.buildICMPRule(table, family, expression.Statement.Name, expression.Statement.Values)
-		if exprICMP == nil {
-			log.Warning("%s icmp statement error", logTag)
-			return nil
+		exprICMP, err := n.buildICMPRule(table, family, expression.Statement.Name, expression.Statement.Values, &cmpOp)
+		if err != nil {
+			return nil, fmt.Errorf("icmp statement error: %s", err)
 		}
 		exprList = append(exprList, *exprICMP...)
 
+	case exprs.NFT_VMAP:
+		exprVmap, err := n.buildVmapRule(table, family, expression.Statement.Values)
+		if err != nil {
+			return nil, fmt.Errorf("vmap statement error: %s", err)
+		}
+		exprList = append(exprList, *exprVmap...)
+
 	case exprs.NFT_LOG:
 		exprLog, err := exprs.NewExprLog(expression.Statement)
 		if err != nil {
-			log.Warning("%s log statement error", logTag)
-			return nil
+			return nil, fmt.Errorf("log statement error: %s", err)
 		}
 		exprList = append(exprList, *exprLog...)
 
 	case exprs.NFT_LIMIT:
 		exprLimit, err := exprs.NewExprLimit(expression.Statement)
 		if err != nil {
-			log.Warning("%s %s", logTag, err)
-			return nil
+			return nil, err
 		}
 		exprList = append(exprList, *exprLimit...)
 
 	case exprs.NFT_PROTO_UDP, exprs.NFT_PROTO_TCP, exprs.NFT_PROTO_UDPLITE, exprs.NFT_PROTO_SCTP, exprs.NFT_PROTO_DCCP:
 		exprProto, err := exprs.NewExprProtocol(expression.Statement.Name)
 		if err != nil {
-			log.Warning("%s proto statement error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("proto statement error: %s", err)
 		}
 		exprList = append(exprList, *exprProto...)
 
@@ -141,14 +147,12 @@ func (n *Nft) parseExpression(table, chain, family string, expression *config.Ex
 			case exprs.NFT_DPORT, exprs.NFT_SPORT:
 				exprPDir, err := exprs.NewExprPortDirection(exprValue.Key)
 				if err != nil {
-					log.Warning("%s ports statement error: %s", logTag, err)
-					return nil
+					return nil, fmt.Errorf("ports statement error: %s", err)
 				}
 				exprList = append(exprList, []expr.Any{exprPDir}...)
 				portsRule, err := n.buildPortsRule(table, family, exprValue.Value, &cmpOp)
 				if err != nil {
-					log.Warning("%s proto.ports statement error: %s", logTag, err)
-					return nil
+					return nil, fmt.Errorf("proto.ports statement error: %s", err)
 				}
 				exprList = append(exprList, *portsRule...)
 			}
@@ -156,14 +160,42 @@ func (n *Nft) parseExpression(table, chain, family string, expression *config.Ex
 		}
 
 	case exprs.NFT_QUOTA:
-		exprQuota, err := exprs.NewQuota(expression.Statement.Values)
+		exprQuota, err := n.buildQuotaRule(table, family, expression.Statement.Values)
 		if err != nil {
-			log.Warning("%s quota statement error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("quota statement error: %s", err)
 		}
 
 		exprList = append(exprList, *exprQuota...)
 
+	case exprs.NFT_FLOW:
+		exprFlow, err := n.buildFlowRule(table, family, expression.Statement.Values)
+		if err != nil {
+			return nil, fmt.Errorf("flow statement error: %s", err)
+		}
+
+		exprList = append(exprList, *exprFlow...)
+
+	case exprs.NFT_SOCKET:
+		for _, sockOption := range expression.Statement.Values {
+			switch sockOption.Key {
+			case exprs.NFT_SOCKET_CGROUPV2:
+				exprSock, err := exprs.NewExprSocketCgroupv2(sockOption.Value, cmpOp)
+				if err != nil {
+					return nil, fmt.Errorf("socket statement error: %s", err)
+				}
+				exprList = append(exprList, *exprSock...)
+			default:
+				return nil, fmt.Errorf("socket option not supported: %s", sockOption.Key)
+			}
+		}
+
+	case exprs.NFT_SYNPROXY:
+		exprSynproxy, err := exprs.NewExprSynproxy(expression.Statement.Values)
+		if err != nil {
+			return nil, fmt.Errorf("synproxy statement error: %s", err)
+		}
+		exprList = append(exprList, *exprSynproxy...)
+
 	case exprs.NFT_NOTRACK:
 		exprList = append(exprList, *exprs.NewNoTrack()...)
 
@@ -188,8 +220,14 @@ func (n *Nft) parseExpression(table, chain, family string, expression *config.Ex
 			}
 		}
 		n.conn.AddObj(counterObj)
+		if !n.dryRun {
+			sysRuleset.Added(counterObj.Table, unix.NFT_MSG_NEWOBJ, counterObj.Name, exprs.NFT_OBJECT_COUNTER)
+		}
 		exprList = append(exprList, *exprs.NewExprCounter(defaultCounterName)...)
+
+	default:
+		return nil, fmt.Errorf("unknown statement: %s", expression.Statement.Name)
 	}
 
-	return &exprList
+	return &exprList, nil
 }
@@ -0,0 +1,123 @@
+package nftables
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// store of named quotas added to the system.
+// Named quotas are shared by all the rules that reference them, so the
+// consumed bytes are accumulated until the quota is deleted.
+type sysQuotasT struct {
+	quotas map[string]*quotaObj
+	sync.RWMutex
+}
+
+type quotaObj struct {
+	table *nftables.Table
+	name  string
+}
+
+func (q *sysQuotasT) Add(key string, quota *quotaObj) {
+	q.Lock()
+	defer q.Unlock()
+	q.quotas[key] = quota
+}
+
+func (q *sysQuotasT) Get(key string) *quotaObj {
+	q.RLock()
+	defer q.RUnlock()
+	return q.quotas[key]
+}
+
+// List returns a copy of the quotas, so they can be deleted while iterating it.
+func (q *sysQuotasT) List() map[string]*quotaObj {
+	q.RLock()
+	defer q.RUnlock()
+	list := make(map[string]*quotaObj, len(q.quotas))
+	for k, v := range q.quotas {
+		list[k] = v
+	}
+	return list
+}
+
+func (q *sysQuotasT) Del(key string) {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.quotas, key)
+}
+
+// AddNamedQuota adds a new named quota to the given table, if it doesn't exist.
+// The nftables lib doesn't support quota objects yet, so we build the netlink
+// messages ourselves (see netlink.go).
+// nft add quota inet filter my-quota { over 500 mbytes }
+func (n *Nft) AddNamedQuota(tbl *nftables.Table, name string, quota *expr.Quota) error {
+	key := getObjKey(name, tbl)
+	if n.dryRun || sysQuotas.Get(key) != nil {
+		return nil
+	}
+
+	flags := uint32(0)
+	if quota.Over {
+		flags = unix.NFT_QUOTA_F_INV
+	}
+	obj := newQuotaObjMsg(unix.NFT_MSG_NEWOBJ, unix.NLM_F_CREATE, tbl, name)
+	data := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_OBJ_DATA, nil)
+	data.AddRtAttr(unix.NFTA_QUOTA_BYTES, binaryutil.BigEndian.PutUint64(quota.Bytes))
+	data.AddRtAttr(unix.NFTA_QUOTA_FLAGS, binaryutil.BigEndian.PutUint32(flags))
+	data.AddRtAttr(unix.NFTA_QUOTA_CONSUMED, binaryutil.BigEndian.PutUint64(quota.Consumed))
+	obj.AddData(data)
+
+	// the table may be queued in the current batch.
+	if err := n.queueRaw(obj); err != nil {
+		return fmt.Errorf("error adding quota %s: %s", name, err)
+	}
+	sysQuotas.Add(key, &quotaObj{table: tbl, name: name})
+	sysRuleset.Added(tbl, unix.NFT_MSG_NEWOBJ, name, exprs.NFT_OBJECT_QUOTA)
+
+	return nil
+}
+
+// delNamedQuotas queues the deletion of the named quotas we added.
+// It must be called after deleting the rules that reference them, otherwise
+// the kernel refuses to delete them.
+func (n *Nft) delNamedQuotas() {
+	for k, quota := range sysQuotas.List() {
+		if err := n.queueRaw(newQuotaObjMsg(unix.NFT_MSG_DELOBJ, 0, quota.table, quota.name)); err != nil {
+			log.Debug("%s error deleting named quota: %s, %s", logTag, k, err)
+		}
+		sysQuotas.Del(k)
+	}
+}
+
+// delUnusedNamedQuotas queues the deletion of the named quotas that are no
+// longer referenced by any rule.
+func (n *Nft) delUnusedNamedQuotas(refs *objRefs) {
+	for k, quota := range sysQuotas.List() {
+		if refs.objs[k] {
+			continue
+		}
+		if err := n.queueRaw(newQuotaObjMsg(unix.NFT_MSG_DELOBJ, 0, quota.table, quota.name)); err != nil {
+			log.Debug("%s error deleting named quota: %s, %s", logTag, k, err)
+		}
+		sysQuotas.Del(k)
+	}
+}
+
+// newQuotaObjMsg builds a new netlink message to add or delete a quota object.
+func newQuotaObjMsg(msgType, flags int, tbl *nftables.Table, name string) *nl.NetlinkRequest {
+	req := newNftMsg(msgType, flags, tbl)
+	req.AddData(nl.NewRtAttr(unix.NFTA_OBJ_TABLE, nl.ZeroTerminated(tbl.Name)))
+	req.AddData(nl.NewRtAttr(unix.NFTA_OBJ_NAME, nl.ZeroTerminated(name)))
+	req.AddData(nl.NewRtAttr(unix.NFTA_OBJ_TYPE, binaryutil.BigEndian.PutUint32(exprs.NFT_OBJECT_QUOTA)))
+
+	return req
+}
@@ -0,0 +1,401 @@
+package nftables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/nftables"
+	"github.com/google/uuid"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// When the system firewall configuration changes, instead of deleting all the
+// system rules and adding them again, we compare the chains and rules loaded
+// in the kernel with the new configuration, and apply only the differences.
+// That way there's no window without rules, and the counters of the chains
+// and rules that didn't change are preserved.
+//
+// In order to know which rules of the configuration are already loaded, every
+// system rule is tagged with a hash of its definition (see userdata.go).
+
+// systemRule holds a system rule loaded in the kernel.
+type systemRule struct {
+	// hash of the definition of the rule.
+	key    string
+	handle uint64
+}
+
+// loadedChain holds a chain loaded in the kernel, and our rules.
+type loadedChain struct {
+	chain *nftables.Chain
+	rules []*systemRule
+	// number of rules of the chain, including the ones not added by us.
+	total int
+	// network interface of the chains attached to the ingress or egress hooks.
+	device string
+}
+
+// getLoadedChains returns the chains loaded in the kernel, with our rules.
+// Chain names are lowercased, like the base chains we add (see AddChain()).
+func (n *Nft) getLoadedChains() (map[string]*loadedChain, error) {
+	chains, err := n.conn.ListChains()
+	if err != nil {
+		return nil, fmt.Errorf("error listing nftables chains: %s", err)
+	}
+	// the lib doesn't report the device of the chains attached to a network
+	// interface, so we get them from the kernel ourselves.
+	devices, err := getChainDevices()
+	if err != nil {
+		return nil, fmt.Errorf("error listing nftables chains devices: %s", err)
+	}
+	loaded := make(map[string]*loadedChain)
+	for _, c := range chains {
+		rules, err := n.conn.GetRule(c.Table, c)
+		if err != nil {
+			return nil, fmt.Errorf("error listing rules of %s, %s: %s", c.Name, c.Table.Name, err)
+		}
+		lc := &loadedChain{chain: c, total: len(rules), device: devices[getChainKey(c.Name, c.Table)]}
+		for _, r := range rules {
+			if meta := parseRuleMeta(r.UserData); meta != nil && meta.Origin == systemRuleKey {
+				lc.rules = append(lc.rules, &systemRule{key: meta.Hash, handle: r.Handle})
+			}
+		}
+		loaded[getChainKey(strings.ToLower(c.Name), c.Table)] = lc
+	}
+
+	return loaded, nil
+}
+
+// getChainDevices returns the network interface of the chains attached to one,
+// by chain key.
+func getChainDevices() (map[string]string, error) {
+	devices := make(map[string]string)
+	replies, err := dumpObjects(unix.NFT_MSG_GETCHAIN, unix.NFT_MSG_NEWCHAIN, nftables.TableFamilyUnspecified)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range replies {
+		family, table, attrs, err := parseDumpMsg(data, unix.NFTA_CHAIN_TABLE)
+		if err != nil {
+			return nil, err
+		}
+		_, chain, _, err := parseDumpMsg(data, unix.NFTA_CHAIN_NAME)
+		if err != nil {
+			return nil, err
+		}
+		if device := parseChainDevice(attrs); device != "" {
+			devices[getChainKey(chain, &nftables.Table{Name: table, Family: family})] = device
+		}
+	}
+
+	return devices, nil
+}
+
+// parseChainDevice returns the device of the hook of a chain, if any.
+func parseChainDevice(data []byte) string {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return ""
+	}
+	for _, a := range attrs {
+		if a.Attr.Type&nl.NLA_TYPE_MASK != unix.NFTA_CHAIN_HOOK {
+			continue
+		}
+		hookAttrs, err := nl.ParseRouteAttr(a.Value)
+		if err != nil {
+			return ""
+		}
+		for _, h := range hookAttrs {
+			if h.Attr.Type&nl.NLA_TYPE_MASK == unix.NFTA_HOOK_DEV {
+				return strings.TrimRight(string(h.Value), "\x00")
+			}
+		}
+	}
+	return ""
+}
+
+// isSameChain checks if the options of a loaded chain are the ones configured.
+// The kernel doesn't allow to change the hook or the priority of a base chain,
+// so if they're different the chain must be deleted and added again.
+func isSameChain(lc *loadedChain, chain *config.FwChain) bool {
+	if chain.Hook == "" && chain.Type == "" {
+		return lc.chain.Hooknum == nil
+	}
+	hook, prio, ctype, _, err := parseSystemChain(chain)
+	if err != nil {
+		// the error will be reported when adding the chain.
+		return true
+	}
+	// the chains attached to a network interface must be added again if the
+	// device changes.
+	if h := strings.ToLower(chain.Hook); h == exprs.NFT_HOOK_INGRESS || h == exprs.NFT_HOOK_EGRESS {
+		if lc.device != chain.Device {
+			return false
+		}
+		if lc.chain.Table != nil {
+			hook = nftables.ChainHookRef(getKernelHook(lc.chain.Table.Family, *hook))
+		}
+	}
+
+	return lc.chain.Hooknum != nil && *lc.chain.Hooknum == *hook &&
+		lc.chain.Priority != nil && *lc.chain.Priority == *prio &&
+		lc.chain.Type == ctype
+}
+
+// isInterceptionChain checks if the given chain is one of the chains where
+// the interception rules are added.
+func isInterceptionChain(key string) bool {
+	return key == getChainKey(exprs.NFT_HOOK_INPUT, &nftables.Table{Name: exprs.NFT_CHAIN_FILTER, Family: nftables.TableFamilyINet}) ||
+		key == getChainKey(exprs.NFT_HOOK_OUTPUT, &nftables.Table{Name: exprs.NFT_CHAIN_MANGLE, Family: nftables.TableFamilyINet})
+}
+
+// ReloadSystemRules applies the changes of the system firewall configuration,
+// adding the new chains and rules, and deleting the ones that no longer exist.
+// The chains and rules that didn't change are not modified.
+func (n *Nft) ReloadSystemRules() (err error) {
+	n.SysConfig.RLock()
+	defer n.SysConfig.RUnlock()
+
+	n.exclusive(func() {
+		err = n.reloadSystemRules()
+	})
+	return err
+}
+
+// reloadSystemRules must be called with the configuration locked.
+func (n *Nft) reloadSystemRules() error {
+	n.Lock()
+	defer n.Unlock()
+
+	loaded, err := n.getLoadedChains()
+	if err != nil {
+		return err
+	}
+
+	// the changed chains are deleted and added again in the same batch.
+	n.Begin()
+	configured := []*config.FwChain{}
+	if n.SysConfig.Enabled {
+		for _, fwCfg := range n.SysConfig.SystemRules {
+			configured = append(configured, fwCfg.Chains...)
+		}
+	}
+	wanted := make(map[string]bool)
+	for _, chain := range configured {
+		tbl := &nftables.Table{Name: chain.Table, Family: getFamilyCode(chain.Family)}
+		key := getChainKey(strings.ToLower(chain.Name), tbl)
+		wanted[key] = true
+
+		// chains with other rules than ours are reused, as we used to do.
+		lc, found := loaded[key]
+		if !found || lc.total > len(lc.rules) || isSameChain(lc, chain) {
+			continue
+		}
+		if _, ours := sysChains.Load(getChainKey(lc.chain.Name, lc.chain.Table)); !ours {
+			continue
+		}
+		log.Debug("%s chain %s, table %s changed, adding it again", logTag, chain.Name, chain.Table)
+		chn := lc.chain
+		n.Queue(func() error {
+			return n.delChain(chn)
+		})
+		delete(loaded, key)
+	}
+
+	for key, lc := range loaded {
+		if wanted[key] {
+			continue
+		}
+		n.delLoadedChain(key, lc)
+	}
+	for _, chain := range configured {
+		if err := n.Queue(func() error {
+			if !n.CreateSystemRule(chain, true) {
+				return fmt.Errorf("error creating chain %s, table %s", chain.Name, chain.Table)
+			}
+			return nil
+		}); err != nil {
+			continue
+		}
+		tbl := &nftables.Table{Name: chain.Table, Family: getFamilyCode(chain.Family)}
+		n.syncChainRules(chain, loaded[getChainKey(strings.ToLower(chain.Name), tbl)])
+	}
+	if err := n.CommitAll(); err != nil {
+		return err
+	}
+
+	// the kernel refuses to delete the objects in use, so only the ones not
+	// referenced by any rule are deleted, in a single batch.
+	refs, err := n.getObjRefs()
+	if err != nil {
+		log.Warning("%s error listing the sets, quotas and flowtables in use: %s", logTag, err)
+		return nil
+	}
+	n.delNamedObjects(refs)
+
+	return nil
+}
+
+// delLoadedChain deletes our rules of a chain that is no longer configured.
+// If the chain was added by us and it's empty, the chain is also deleted.
+func (n *Nft) delLoadedChain(key string, lc *loadedChain) {
+	for _, r := range lc.rules {
+		n.conn.DelRule(&nftables.Rule{
+			Table:  lc.chain.Table,
+			Chain:  lc.chain,
+			Handle: r.handle,
+		})
+	}
+	_, ours := sysChains.Load(getChainKey(lc.chain.Name, lc.chain.Table))
+	if ours && lc.total == len(lc.rules) && !isInterceptionChain(key) {
+		n.delChain(lc.chain)
+		return
+	}
+	// restore the policy of the chains that existed before we modified them.
+	c, found := origSysChains[getChainKey(lc.chain.Name, lc.chain.Table)]
+	if found && lc.chain.Policy != nil && *lc.chain.Policy != nftables.ChainPolicyAccept {
+		*c.Policy = nftables.ChainPolicyAccept
+		n.conn.AddChain(c)
+	}
+}
+
+// syncChainRules adds the rules of a chain that are not loaded yet, and
+// deletes the loaded ones that are no longer configured.
+// The rules that didn't change are kept, so the new rules are placed
+// relative to them, in the order of the configuration.
+func (n *Nft) syncChainRules(chain *config.FwChain, lc *loadedChain) {
+	var loaded []*systemRule
+	if lc != nil {
+		loaded = lc.rules
+	}
+	rules := []*config.FwRule{}
+	keys := []string{}
+	for _, r := range chain.Rules {
+		if r.UUID == "" {
+			r.UUID = uuid.New().String()
+		}
+		if r.Enabled {
+			rules = append(rules, r)
+			keys = append(keys, getSystemRuleHash(r))
+		}
+	}
+	kept := matchRules(keys, loaded)
+
+	keptLoaded := make(map[int]bool)
+	for _, li := range kept {
+		keptLoaded[li] = true
+	}
+	for li, r := range loaded {
+		if keptLoaded[li] {
+			continue
+		}
+		n.conn.DelRule(&nftables.Rule{
+			Table:  lc.chain.Table,
+			Chain:  lc.chain,
+			Handle: r.handle,
+		})
+	}
+
+	// handle of the next rule that is kept, for every rule of the chain.
+	next := make([]uint64, len(rules))
+	handle := uint64(0)
+	for i := len(rules) - 1; i >= 0; i-- {
+		next[i] = handle
+		if li, found := kept[i]; found {
+			handle = loaded[li].handle
+		}
+	}
+	// the new rules at the end are added after the last rule kept, in reverse
+	// order. If no rule is kept, they're inserted at the top of the chain,
+	// as AddSystemRules() does.
+	last := uint64(0)
+	for li, r := range loaded {
+		if keptLoaded[li] {
+			last = r.handle
+		}
+	}
+
+	for i, r := range rules {
+		if _, found := kept[i]; found || next[i] == 0 {
+			continue
+		}
+		position := next[i]
+		if r.Position != 0 {
+			position = r.Position
+		}
+		n.Queue(func() error {
+			return n.addSystemRule(r, chain, position, false)
+		})
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		if _, found := kept[i]; found || next[i] != 0 {
+			continue
+		}
+		rule := rules[i]
+		n.Queue(func() error {
+			if rule.Position != 0 {
+				return n.addSystemRule(rule, chain, rule.Position, false)
+			}
+			return n.addSystemRule(rule, chain, last, last != 0)
+		})
+	}
+}
+
+// addSystemRule compiles a system rule, and inserts it before the given
+// position. If appendRule is true, it's added after it.
+func (n *Nft) addSystemRule(rule *config.FwRule, chain *config.FwChain, position uint64, appendRule bool) error {
+	exprList, field, err := n.compileRule(rule, chain)
+	if err != nil {
+		log.Warning("%s error adding rule %s, %s: %s", logTag, rule.UUID, field, err)
+		return err
+	}
+	if len(*exprList) == 0 {
+		return nil
+	}
+	if appendRule {
+		return n.addRule(chain.Name, chain.Table, chain.Family, position, exprList, newSystemRuleMeta(rule))
+	}
+	return n.insertRule(chain.Name, chain.Table, chain.Family, position, exprList, newSystemRuleMeta(rule))
+}
+
+// matchRules returns the longest sequence of rules of the configuration that
+// are loaded in the same order, as a map of the index of the configured rule
+// to the index of the loaded rule.
+func matchRules(keys []string, loaded []*systemRule) map[int]int {
+	// lcs[i][j] is the length of the longest common sequence of keys[i:] and loaded[j:]
+	lcs := make([][]int, len(keys)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(loaded)+1)
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		for j := len(loaded) - 1; j >= 0; j-- {
+			if keys[i] == loaded[j].key {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	kept := make(map[int]int)
+	for i, j := 0, 0; i < len(keys) && j < len(loaded); {
+		switch {
+		case keys[i] == loaded[j].key:
+			kept[i] = j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,107 @@
+package nftables
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/nftables"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func loadedRules(keys ...string) []*systemRule {
+	rules := []*systemRule{}
+	for i, k := range keys {
+		rules = append(rules, &systemRule{key: k, handle: uint64(i + 1)})
+	}
+	return rules
+}
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []string
+		loaded []*systemRule
+		want   map[int]int
+	}{
+		{"no rules", []string{}, loadedRules(), map[int]int{}},
+		{"nothing loaded", []string{"a", "b"}, loadedRules(), map[int]int{}},
+		{"all deleted", []string{}, loadedRules("a", "b"), map[int]int{}},
+		{"unchanged", []string{"a", "b", "c"}, loadedRules("a", "b", "c"), map[int]int{0: 0, 1: 1, 2: 2}},
+		{"rule added at the top", []string{"x", "a", "b"}, loadedRules("a", "b"), map[int]int{1: 0, 2: 1}},
+		{"rule added in the middle", []string{"a", "x", "b"}, loadedRules("a", "b"), map[int]int{0: 0, 2: 1}},
+		{"rule deleted", []string{"a", "c"}, loadedRules("a", "b", "c"), map[int]int{0: 0, 1: 2}},
+		{"rule modified", []string{"a", "x", "c"}, loadedRules("a", "b", "c"), map[int]int{0: 0, 2: 2}},
+		{"rules swapped", []string{"b", "a"}, loadedRules("a", "b"), map[int]int{1: 0}},
+		{"rule moved to the end", []string{"b", "c", "a"}, loadedRules("a", "b", "c"), map[int]int{0: 1, 1: 2}},
+		{"duplicated rules", []string{"a", "a"}, loadedRules("a"), map[int]int{0: 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matchRules(test.keys, test.loaded); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsSameChain(t *testing.T) {
+	inet := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+	netdev := &nftables.Table{Name: "filter", Family: nftables.TableFamilyNetdev}
+	loaded := func(tbl *nftables.Table, hook nftables.ChainHook, prio nftables.ChainPriority, device string) *loadedChain {
+		return &loadedChain{
+			chain: &nftables.Chain{
+				Table:    tbl,
+				Type:     nftables.ChainTypeFilter,
+				Hooknum:  nftables.ChainHookRef(hook),
+				Priority: nftables.ChainPriorityRef(prio),
+			},
+			device: device,
+		}
+	}
+	chain := func(family, hook, prio, device string) *config.FwChain {
+		return &config.FwChain{Family: family, Type: "filter", Hook: hook, Policy: "accept", Priority: prio, Device: device}
+	}
+
+	tests := []struct {
+		name   string
+		loaded *loadedChain
+		chain  *config.FwChain
+		want   bool
+	}{
+		{"same input chain", loaded(inet, *nftables.ChainHookInput, 0, ""), chain("inet", "input", "0", ""), true},
+		{"input priority changed", loaded(inet, *nftables.ChainHookInput, 0, ""), chain("inet", "input", "10", ""), false},
+		{"same netdev ingress chain", loaded(netdev, *nftables.ChainHookIngress, 0, "eth0"), chain("netdev", "ingress", "0", "eth0"), true},
+		{"same netdev egress chain", loaded(netdev, chainHookEgress, 0, "eth0"), chain("netdev", "egress", "0", "eth0"), true},
+		{"netdev device changed", loaded(netdev, *nftables.ChainHookIngress, 0, "eth0"), chain("netdev", "ingress", "0", "eth1"), false},
+		{"netdev priority changed", loaded(netdev, *nftables.ChainHookIngress, 0, "eth0"), chain("netdev", "ingress", "-10", "eth0"), false},
+		{"netdev hook changed", loaded(netdev, *nftables.ChainHookIngress, 0, "eth0"), chain("netdev", "egress", "0", "eth0"), false},
+		{"same inet ingress chain", loaded(inet, nfInetIngress, 0, "eth0"), chain("inet", "ingress", "0", "eth0"), true},
+		{"inet ingress device changed", loaded(inet, nfInetIngress, 0, "eth0"), chain("inet", "ingress", "0", "wlan0"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isSameChain(test.loaded, test.chain); got != test.want {
+				t.Errorf("isSameChain() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseChainDevice(t *testing.T) {
+	hook := nl.NewRtAttr(unix.NLA_F_NESTED|unix.NFTA_CHAIN_HOOK, nil)
+	hook.AddRtAttr(unix.NFTA_HOOK_HOOKNUM, []byte{0, 0, 0, 0})
+	hook.AddRtAttr(unix.NFTA_HOOK_DEV, nl.ZeroTerminated("eth0"))
+	data := nl.NewRtAttr(unix.NFTA_CHAIN_NAME, nl.ZeroTerminated("ingress")).Serialize()
+	data = append(data, hook.Serialize()...)
+
+	if device := parseChainDevice(data); device != "eth0" {
+		t.Errorf("got device %q, want eth0", device)
+	}
+	if device := parseChainDevice(nl.NewRtAttr(unix.NFTA_CHAIN_NAME, nl.ZeroTerminated("input")).Serialize()); device != "" {
+		t.Errorf("got device %q for a chain without device", device)
+	}
+}
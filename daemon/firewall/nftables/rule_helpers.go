@@ -2,6 +2,7 @@ package nftables
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
@@ -13,94 +14,63 @@ import (
 
 // rules examples: https://github.com/google/nftables/blob/master/nftables_test.go
 
-func (n *Nft) buildICMPRule(table, family string, icmpProtoVersion string, icmpOptions []*config.ExprValues) *[]expr.Any {
+// buildICMPRule helper builds a new rule to match ICMP and ICMPv6 packets by
+// type and code. Multiple values separated by commas are added to a set.
+// {"Key": "type", "Value": "echo-request,destination-unreachable"}, {"Key": "code", "Value": "0"}
+//
+// nft add rule inet filter input icmpv6 type { nd-router-advert, nd-neighbor-solicit } accept
+func (n *Nft) buildICMPRule(table, family string, icmpProtoVersion string, icmpOptions []*config.ExprValues, cmpOp *expr.CmpOp) (*[]expr.Any, error) {
 	tbl := n.getTable(table, family)
 	if tbl == nil {
-		return nil
+		return nil, fmt.Errorf("Invalid table (%s, %s)", table, family)
 	}
-	offset := uint32(0)
-	icmpType := uint8(0)
-	setType := nftables.SetDatatype{}
-
-	switch icmpProtoVersion {
-	case exprs.NFT_PROTO_ICMP:
-		setType = nftables.TypeICMPType
-	case exprs.NFT_PROTO_ICMPv6:
-		setType = nftables.TypeICMP6Type
-	default:
-		return nil
+	exprICMP, err := exprs.NewExprProtocol(icmpProtoVersion)
+	if err != nil {
+		return nil, err
 	}
-
-	exprICMP, _ := exprs.NewExprProtocol(icmpProtoVersion)
 	ICMPrule := []expr.Any{}
 	ICMPrule = append(ICMPrule, *exprICMP...)
 
-	ICMPtemp := []expr.Any{}
-	setElements := []nftables.SetElement{}
 	for _, icmp := range icmpOptions {
+		values := []byte{}
 		switch icmp.Key {
 		case exprs.NFT_ICMP_TYPE:
-			icmpTypeList := strings.Split(icmp.Value, ",")
-			for _, icmpTypeStr := range icmpTypeList {
-				if exprs.NFT_PROTO_ICMPv6 == icmpProtoVersion {
-					icmpType = exprs.GetICMPv6Type(icmpTypeStr)
-				} else {
-					icmpType = exprs.GetICMPType(icmpTypeStr)
-				}
-				exprCmp := &expr.Cmp{
-					Op:       expr.CmpOpEq,
-					Register: 1,
-					Data:     []byte{icmpType},
-				}
-				ICMPtemp = append(ICMPtemp, []expr.Any{exprCmp}...)
-
-				// fill setElements. If there're more than 1 icmp type we'll use it later
-				setElements = append(setElements,
-					[]nftables.SetElement{
-						{
-							Key: []byte{icmpType},
-						},
-					}...)
-			}
+			values, err = exprs.ParseICMPTypes(icmpProtoVersion, icmp.Value)
 		case exprs.NFT_ICMP_CODE:
-			// TODO
-			offset = 1
+			values, err = exprs.ParseICMPCodes(icmpProtoVersion, icmp.Value)
+		default:
+			err = fmt.Errorf("invalid %s option: %s", icmpProtoVersion, icmp.Key)
+		}
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	ICMPrule = append(ICMPrule, []expr.Any{
-		&expr.Payload{
-			DestRegister: 1,
-			Base:         expr.PayloadBaseTransportHeader,
-			Offset:       offset, // 0 type, 1 code
-			Len:          1,
-		},
-	}...)
+		if len(values) == 1 {
+			ICMPrule = append(ICMPrule, *exprs.NewExprICMP(icmp.Key, values[0], *cmpOp)...)
+			continue
+		}
 
-	if len(setElements) == 1 {
-		ICMPrule = append(ICMPrule, ICMPtemp...)
-	} else {
+		setElements := []nftables.SetElement{}
+		for _, v := range values {
+			setElements = append(setElements, nftables.SetElement{Key: []byte{v}})
+		}
 		set := &nftables.Set{
 			Anonymous: true,
 			Constant:  true,
 			Table:     tbl,
-			KeyType:   setType,
+			KeyType:   exprs.GetICMPSetType(icmpProtoVersion, icmp.Key),
 		}
 		if err := n.conn.AddSet(set, setElements); err != nil {
-			log.Warning("%s AddSet() error: %s", logTag, err)
-			return nil
+			return nil, fmt.Errorf("AddSet() error: %s", err)
+		}
+		if !n.dryRun {
+			sysSets = append(sysSets, []*nftables.Set{set}...)
 		}
-		sysSets = append(sysSets, []*nftables.Set{set}...)
 
-		ICMPrule = append(ICMPrule, []expr.Any{
-			&expr.Lookup{
-				SourceRegister: 1,
-				SetName:        set.Name,
-				SetID:          set.ID,
-			}}...)
+		ICMPrule = append(ICMPrule, *exprs.NewExprICMPSet(icmp.Key, set, *cmpOp)...)
 	}
 
-	return &ICMPrule
+	return &ICMPrule, nil
 }
 
 func (n *Nft) buildConntrackRule(ctOptions []*config.ExprValues, cmpOp *expr.CmpOp) *[]expr.Any {
@@ -112,15 +82,12 @@ func (n *Nft) buildConntrackRule(ctOptions []*config.ExprValues, cmpOp *expr.Cmp
 		// we expect to have multiple "state" keys:
 		// { "state": "established", "state": "related" }
 		case exprs.NFT_CT_STATE:
-			ctExprState, err := exprs.NewExprCtState(ctOptions)
+			ctExprState, err := exprs.NewExprCtState(ctOptions, cmpOp)
 			if err != nil {
 				log.Warning("%s ct set state error: %s", logTag, err)
 				return nil
 			}
 			exprList = append(exprList, *ctExprState...)
-			exprList = append(exprList,
-				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0, 0, 0, 0}},
-			)
 			// we only need to iterate once here
 			goto Exit
 		case exprs.NFT_CT_SET_MARK:
@@ -167,18 +134,23 @@ func (n *Nft) buildL4ProtoRule(table, family, l4prots string, cmpOp *expr.CmpOp)
 			Table:     tbl,
 			KeyType:   nftables.TypeInetProto,
 		}
-		protoSet := exprs.NewExprProtoSet(l4prots)
+		protoSet, err := exprs.NewExprProtoSet(l4prots)
+		if err != nil {
+			return nil, err
+		}
 		if err := n.conn.AddSet(set, *protoSet); err != nil {
 			log.Warning("%s protoSet, AddSet() error: %s", logTag, err)
 			return nil, err
 		}
-		exprList = append(exprList, &expr.Lookup{
-			SourceRegister: 1,
-			SetName:        set.Name,
-			SetID:          set.ID,
-		})
+		if !n.dryRun {
+			sysSets = append(sysSets, []*nftables.Set{set}...)
+		}
+		exprList = append(exprList, *exprs.NewExprL4ProtoSet(set, cmpOp)...)
 	} else {
-		exprProto := exprs.NewExprL4Proto(l4prots, cmpOp)
+		exprProto, err := exprs.NewExprL4Proto(l4prots, cmpOp)
+		if err != nil {
+			return nil, err
+		}
 		exprList = append(exprList, *exprProto...)
 	}
 
@@ -208,7 +180,9 @@ func (n *Nft) buildPortsRule(table, family, ports string, cmpOp *expr.CmpOp) (*[
 			SetName:        set.Name,
 			SetID:          set.ID,
 		})
-		sysSets = append(sysSets, []*nftables.Set{set}...)
+		if !n.dryRun {
+			sysSets = append(sysSets, []*nftables.Set{set}...)
+		}
 	} else if strings.Index(ports, "-") != -1 {
 		exprList = append(exprList, *exprs.NewExprPortRange(ports, cmpOp)...)
 	} else {
@@ -217,3 +191,177 @@ func (n *Nft) buildPortsRule(table, family, ports string, cmpOp *expr.CmpOp) (*[
 
 	return &exprList, nil
 }
+
+// buildIPRule helper builds a new rule to match IPs.
+// Lists of IPs or network ranges (CIDRs) are compiled into a single named
+// interval set, instead of adding one rule per address:
+//
+// nft add set inet filter opensnitch-set-1 { type ipv4_addr; flags interval; }
+// nft add element inet filter opensnitch-set-1 { 1.1.1.1, 10.0.0.0/8 }
+// nft add rule inet filter output ip daddr @opensnitch-set-1 drop
+//
+// The addresses of the "ip6" statements are IPv6, and their lists are
+// compiled into sets of type ipv6_addr.
+func (n *Nft) buildIPRule(table, family, statement string, ipOptions []*config.ExprValues, cmpOp expr.CmpOp) (*[]expr.Any, error) {
+	tbl := n.getTable(table, family)
+	if tbl == nil {
+		return nil, fmt.Errorf("Invalid table (%s, %s)", table, family)
+	}
+
+	ipOpts := []*config.ExprValues{}
+	setOpts := []*config.ExprValues{}
+	for _, ipOpt := range ipOptions {
+		if (ipOpt.Key == exprs.NFT_SADDR || ipOpt.Key == exprs.NFT_DADDR) && exprs.IsIPList(ipOpt.Value) {
+			setOpts = append(setOpts, ipOpt)
+			continue
+		}
+		ipOpts = append(ipOpts, ipOpt)
+	}
+
+	ipv6 := statement == exprs.NFT_FAMILY_IP6
+	newExprIP, setType := exprs.NewExprIP, nftables.TypeIPAddr
+	if ipv6 {
+		newExprIP, setType = exprs.NewExprIP6, nftables.TypeIP6Addr
+	}
+	exprList, err := newExprIP(family, ipOpts, cmpOp)
+	if err != nil {
+		return nil, err
+	}
+	for _, ipOpt := range setOpts {
+		setElements, err := exprs.NewExprIPSetElements(ipOpt.Value, ipv6)
+		if err != nil {
+			return nil, err
+		}
+		set, err := n.AddNamedSet(tbl, setType, true, *setElements)
+		if err != nil {
+			log.Warning("%s ipSet, AddSet() error: %s", logTag, err)
+			return nil, err
+		}
+		*exprList = append(*exprList, *exprs.NewExprIPSet(ipOpt.Key, set, cmpOp)...)
+	}
+
+	return exprList, nil
+}
+
+// buildVmapRule helper builds a new rule to apply a verdict based on the value
+// of a field of the packet, in a single lookup:
+//
+// nft --debug=netlink add rule inet filter input tcp dport vmap { 22 : accept, 23 : drop }
+//	__map%d filter b size 2
+//	__map%d filter 0
+//		element 00001600  : accept 0 [end]	element 00001700  : drop 0 [end]
+//	inet filter input
+//	  [ meta load l4proto => reg 1 ]
+//	  [ cmp eq reg 1 0x00000006 ]
+//	  [ payload load 2b @ transport header + 2 => reg 1 ]
+//	  [ lookup reg 1 set __map%d dreg 0 ]
+//
+// The verdict of the rule (Target) must be empty.
+func (n *Nft) buildVmapRule(table, family string, values []*config.ExprValues) (*[]expr.Any, error) {
+	tbl := n.getTable(table, family)
+	if tbl == nil {
+		return nil, fmt.Errorf("Invalid table (%s, %s)", table, family)
+	}
+	exprList, set, setElements, err := exprs.NewExprVmap(family, values)
+	if err != nil {
+		return nil, err
+	}
+	set.Table = tbl
+	if err := n.conn.AddSet(set, *setElements); err != nil {
+		log.Warning("%s vmap, AddSet() error: %s", logTag, err)
+		return nil, err
+	}
+	if !n.dryRun {
+		sysSets = append(sysSets, []*nftables.Set{set}...)
+	}
+	*exprList = append(*exprList, *exprs.NewExprVmapLookup(set)...)
+
+	return exprList, nil
+}
+
+// buildQuotaRule helper builds a new quota rule.
+// If the quota has a name, a named quota is created in the table, and all the
+// rules that reference it share the same quota:
+//
+// nft add quota inet filter my-quota { over 500 mbytes }
+// nft add rule inet filter output oifname "wlan0" quota name "my-quota" drop
+//	[ meta load oifname => reg 1 ]
+//	[ cmp eq reg 1 0x6e616c77 0x00000030 0x00000000 0x00000000 ]
+//	[ objref type 2 name my-quota ]
+//	[ immediate reg 0 drop ]
+func (n *Nft) buildQuotaRule(table, family string, values []*config.ExprValues) (*[]expr.Any, error) {
+	quotaName := ""
+	for _, v := range values {
+		if v.Key == exprs.NFT_QUOTA_NAME {
+			quotaName = v.Value
+		}
+	}
+	if quotaName == "" {
+		return exprs.NewQuota(values)
+	}
+
+	tbl := n.getTable(table, family)
+	if tbl == nil {
+		return nil, fmt.Errorf("Invalid table (%s, %s)", table, family)
+	}
+	quota, err := exprs.ParseQuota(values)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.AddNamedQuota(tbl, quotaName, quota); err != nil {
+		return nil, err
+	}
+
+	return exprs.NewExprQuotaRef(quotaName), nil
+}
+
+// buildFlowRule helper creates the flowtable if it doesn't exist, and builds
+// the expression to offload the connections to it.
+// {"Key": "name", "Value": "ft"}, {"Key": "devices", "Value": "eth0,eth1"},
+// {"Key": "priority", "Value": "0"}, {"Key": "offload", "Value": ""}
+//
+// nft add flowtable inet filter ft { hook ingress priority 0; devices = { eth0, eth1 }; }
+// nft add rule inet filter forward ct state established flow add @ft
+func (n *Nft) buildFlowRule(table, family string, values []*config.ExprValues) (*[]expr.Any, error) {
+	name := ""
+	devices := []string{}
+	prio := int32(0)
+	flags := nftables.FlowtableFlags(0)
+	for _, v := range values {
+		switch v.Key {
+		case exprs.NFT_FLOW_NAME:
+			name = v.Value
+		case exprs.NFT_FLOW_DEVICES:
+			for _, dev := range strings.Split(v.Value, ",") {
+				if dev = strings.TrimSpace(dev); dev != "" {
+					devices = append(devices, dev)
+				}
+			}
+		case exprs.NFT_FLOW_PRIORITY:
+			p, err := strconv.ParseInt(v.Value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid flowtable priority: %s", v.Value)
+			}
+			prio = int32(p)
+		case exprs.NFT_FLOW_OFFLOAD:
+			// offload the flows to the hardware, if the NIC supports it.
+			flags |= nftables.FlowtableFlagsHWOffload
+		case exprs.NFT_FLOW_COUNTER:
+			flags |= nftables.FlowtableFlagsCounter
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("flowtable name cannot be empty")
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("flowtable %s: devices cannot be empty", name)
+	}
+
+	tbl := n.getTable(table, family)
+	if tbl == nil {
+		return nil, fmt.Errorf("Invalid table (%s, %s)", table, family)
+	}
+	n.AddFlowtable(tbl, name, devices, prio, flags)
+
+	return exprs.NewExprFlowOffload(name), nil
+}
@@ -3,6 +3,7 @@ package nftables
 import (
 	"fmt"
 
+	"github.com/evilsocket/opensnitch/daemon/firewall/common"
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/google/nftables"
@@ -15,7 +16,7 @@ import (
 // QueueDNSResponses redirects DNS responses to us, in order to keep a cache
 // of resolved domains.
 // This rule must be added in top of the system rules, otherwise it may get bypassed.
-// nft insert rule ip filter input udp sport 53 queue num 0 bypass
+// nft insert rule ip filter input udp sport { 53, 5353, 5355 } queue num 0 bypass
 func (n *Nft) QueueDNSResponses(enable bool, logError bool) (error, error) {
 	if n.conn == nil {
 		return nil, nil
@@ -33,40 +34,49 @@ func (n *Nft) QueueDNSResponses(enable bool, logError bool) (error, error) {
 			continue
 		}
 
+		portsExprs, err := n.buildPortsRule(exprs.NFT_CHAIN_FILTER, fam, common.DNSPorts, nil)
+		if err != nil {
+			log.Error("QueueDNSResponses() Error adding the DNS ports: %s", err)
+			continue
+		}
+		dnsExprs := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte{unix.IPPROTO_UDP},
+			},
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseTransportHeader,
+				Offset:       0,
+				Len:          2,
+			},
+		}
+		dnsExprs = append(dnsExprs, *portsExprs...)
+		// DNS responses are received on the input interface.
+		dnsExprs = append(dnsExprs, *n.getIfacesExprs(table, false)...)
+		dnsExprs = append(dnsExprs,
+			(*n.getCounterExpr(chain, true))[0],
+			(*exprs.NewExprAnonCounter())[0],
+			&expr.Queue{
+				Num:  n.QueueNum,
+				Flag: expr.QueueFlagBypass,
+			},
+		)
+
 		// nft list ruleset -a
 		n.conn.InsertRule(&nftables.Rule{
 			Position: 0,
 			Table:    table,
 			Chain:    chain,
-			Exprs: []expr.Any{
-				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
-				&expr.Cmp{
-					Op:       expr.CmpOpEq,
-					Register: 1,
-					Data:     []byte{unix.IPPROTO_UDP},
-				},
-				&expr.Payload{
-					DestRegister: 1,
-					Base:         expr.PayloadBaseTransportHeader,
-					Offset:       0,
-					Len:          2,
-				},
-				&expr.Cmp{
-					Op:       expr.CmpOpEq,
-					Register: 1,
-					Data:     binaryutil.BigEndian.PutUint16(uint16(53)),
-				},
-				&expr.Queue{
-					Num:  n.QueueNum,
-					Flag: expr.QueueFlagBypass,
-				},
-			},
-			// rule key, to allow get it later by key
-			UserData: []byte(interceptionRuleKey),
+			Exprs:    dnsExprs,
+			// metadata of the rule, to identify it later.
+			UserData: newInterceptionRuleMeta().Marshal(),
 		})
 	}
 	// apply changes
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("Error adding DNS interception rules"), nil
 	}
 
@@ -91,45 +101,121 @@ func (n *Nft) QueueConnections(enable bool, logError bool) (error, error) {
 		return nil, fmt.Errorf("QueueConnections() Error getting outputChain: output-%s", table.Name)
 	}
 
+	queueExprs := []expr.Any{
+		&expr.Ct{Register: 1, SourceRegister: false, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitNEW | expr.CtStateBitRELATED),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0, 0, 0, 0}},
+	}
+	// outbound connections are sent through the output interface.
+	queueExprs = append(queueExprs, *n.getIfacesExprs(table, true)...)
+	queueExprs = append(queueExprs,
+		(*n.getCounterExpr(chain, true))[0],
+		(*exprs.NewExprAnonCounter())[0],
+		&expr.Queue{
+			Num:  n.QueueNum,
+			Flag: expr.QueueFlagBypass,
+		},
+	)
+
 	n.conn.AddRule(&nftables.Rule{
 		Position: 0,
 		Table:    table,
 		Chain:    chain,
-		Exprs: []expr.Any{
-			&expr.Ct{Register: 1, SourceRegister: false, Key: expr.CtKeySTATE},
-			&expr.Bitwise{
-				SourceRegister: 1,
-				DestRegister:   1,
-				Len:            4,
-				Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitNEW | expr.CtStateBitRELATED),
-				Xor:            binaryutil.NativeEndian.PutUint32(0),
-			},
-			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0, 0, 0, 0}},
-			&expr.Queue{
-				Num:  n.QueueNum,
-				Flag: expr.QueueFlagBypass,
-			},
-		},
-		// rule key, to allow get it later by key
-		UserData: []byte(interceptionRuleKey),
+		Exprs:    queueExprs,
+		// metadata of the rule, to identify it later.
+		UserData: newInterceptionRuleMeta().Marshal(),
 	})
 	// apply changes
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("Error adding interception rule "), nil
 	}
 
 	if enable {
 		// flush conntrack as soon as netfilter rule is set. This ensures that already-established
 		// connections will go to netfilter queue.
-		if err := netlink.ConntrackTableFlush(netlink.ConntrackTable); err != nil {
-			log.Error("nftables, error in ConntrackTableFlush %s", err)
-		}
+		n.afterCommit(func() {
+			if err := netlink.ConntrackTableFlush(netlink.ConntrackTable); err != nil {
+				log.Error("nftables, error in ConntrackTableFlush %s", err)
+			}
+		})
 	}
 
 	return nil, nil
 }
 
-func (n *Nft) insertRule(chain, table, family string, position uint64, exprs *[]expr.Any) error {
+// getIfacesExprs returns the expressions to restrict the interception rules
+// to the configured network interfaces, if any.
+// nft add rule inet mangle output oifname { wlan0 } oifname != { br0 } ct state new,related queue num 0 bypass
+func (n *Nft) getIfacesExprs(table *nftables.Table, isOut bool) *[]expr.Any {
+	exprList := []expr.Any{}
+	ifaces, skipIfaces := n.GetInterfaces()
+	for _, list := range []struct {
+		ifaces []string
+		cmpOp  expr.CmpOp
+	}{
+		{ifaces, expr.CmpOpEq},
+		{skipIfaces, expr.CmpOpNeq},
+	} {
+		if len(list.ifaces) == 0 {
+			continue
+		}
+		if len(list.ifaces) == 1 {
+			exprList = append(exprList, *exprs.NewExprIface(list.ifaces[0], isOut, list.cmpOp)...)
+			continue
+		}
+		// several interfaces are matched against a set, so we keep only
+		// one interception rule per hook.
+		setElements := []nftables.SetElement{}
+		for _, iface := range list.ifaces {
+			setElements = append(setElements, nftables.SetElement{Key: exprs.GetIfaceName(iface)})
+		}
+		set := &nftables.Set{
+			Anonymous: true,
+			Constant:  true,
+			Table:     table,
+			KeyType:   nftables.TypeIFName,
+		}
+		if err := n.conn.AddSet(set, setElements); err != nil {
+			log.Warning("%s error adding interfaces set %v: %s", logTag, list.ifaces, err)
+			continue
+		}
+		sysSets = append(sysSets, []*nftables.Set{set}...)
+		exprList = append(exprList, *exprs.NewExprIfaceSet(set, isOut, list.cmpOp)...)
+	}
+
+	return &exprList
+}
+
+// insertRawRule inserts a rule with expressions not supported by the nftables lib.
+// The rule is queued after the changes queued so far, because its table and
+// chain may not exist yet.
+// If appendRule is true, the rule is added after the given position instead
+// of before it.
+func (n *Nft) insertRawRule(rule *nftables.Rule, appendRule bool) error {
+	msg, err := newRuleMsg(rule)
+	if err != nil {
+		return fmt.Errorf("%s Error adding rule: %s", logTag, err)
+	}
+	if appendRule {
+		msg.Flags |= unix.NLM_F_APPEND
+	}
+	if err := n.queueRaw(msg); err != nil {
+		return fmt.Errorf("%s error adding rule to %s, %s: %s", logTag, rule.Table.Name, rule.Chain.Name, err)
+	}
+
+	return nil
+}
+
+// insertRule inserts a system rule before the rule with the given handle
+// (position), or at the top of the chain if the position is 0.
+// The metadata identifies the rule (see userdata.go).
+func (n *Nft) insertRule(chain, table, family string, position uint64, exprs *[]expr.Any, meta *ruleMeta) error {
 	tbl := n.getTable(table, family)
 	if tbl == nil {
 		return fmt.Errorf("%s addRule, Error getting table: %s, %s", logTag, table, family)
@@ -146,17 +232,22 @@ func (n *Nft) insertRule(chain, table, family string, position uint64, exprs *[]
 		Table:    tbl,
 		Chain:    chn.(*nftables.Chain),
 		Exprs:    *exprs,
-		UserData: []byte(systemRuleKey),
+		UserData: meta.Marshal(),
+	}
+	if hasRawExprs(rule) {
+		return n.insertRawRule(rule, false)
 	}
 	n.conn.InsertRule(rule)
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("%s Error adding rule", logTag)
 	}
 
 	return nil
 }
 
-func (n *Nft) addRule(chain, table, family string, position uint64, exprs *[]expr.Any) error {
+// addRule adds a system rule after the rule with the given handle (position),
+// or at the end of the chain if the position is 0.
+func (n *Nft) addRule(chain, table, family string, position uint64, exprs *[]expr.Any, meta *ruleMeta) error {
 	tbl := n.getTable(table, family)
 	if tbl == nil {
 		return fmt.Errorf("%s addRule, Error getting table: %s, %s", logTag, table, family)
@@ -173,30 +264,35 @@ func (n *Nft) addRule(chain, table, family string, position uint64, exprs *[]exp
 		Table:    tbl,
 		Chain:    chn.(*nftables.Chain),
 		Exprs:    *exprs,
-		UserData: []byte(systemRuleKey),
+		UserData: meta.Marshal(),
+	}
+	if hasRawExprs(rule) {
+		return n.insertRawRule(rule, true)
 	}
 	n.conn.AddRule(rule)
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return fmt.Errorf("%s Error adding rule", logTag)
 	}
 
 	return nil
 }
 
-func (n *Nft) delRulesByKey(key string) error {
+// delRulesByOrigin deletes the rules we added with the given origin
+// (interceptionRuleKey, systemRuleKey).
+func (n *Nft) delRulesByOrigin(origin string) error {
 	chains, err := n.conn.ListChains()
 	if err != nil {
-		return fmt.Errorf("error listing nftables chains (%s): %s", key, err)
+		return fmt.Errorf("error listing nftables chains (%s): %s", origin, err)
 	}
 	for _, c := range chains {
 		rules, err := n.conn.GetRule(c.Table, c)
 		if err != nil {
-			log.Warning("Error listing rules (%s): %s", key, err)
+			log.Warning("Error listing rules (%s): %s", origin, err)
 			continue
 		}
 		delRules := 0
 		for _, r := range rules {
-			if string(r.UserData) != key {
+			if !isRuleOf(r.UserData, origin) {
 				continue
 			}
 			// just passing the r object doesn't work.
@@ -205,13 +301,13 @@ func (n *Nft) delRulesByKey(key string) error {
 				Chain:  c,
 				Handle: r.Handle,
 			}); err != nil {
-				log.Warning("[nftables] error deleting rule (%s): %s", key, err)
+				log.Warning("[nftables] error deleting rule (%s): %s", origin, err)
 				continue
 			}
 			delRules++
 		}
 		if delRules > 0 {
-			if !n.Commit() {
+			if n.Commit() == CommitFailed {
 				log.Warning("%s error deleting rules: %s", logTag, err)
 			}
 		}
@@ -227,5 +323,5 @@ func (n *Nft) delRulesByKey(key string) error {
 }
 
 func (n *Nft) delInterceptionRules() {
-	n.delRulesByKey(interceptionRuleKey)
+	n.delRulesByOrigin(interceptionRuleKey)
 }
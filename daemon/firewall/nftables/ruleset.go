@@ -0,0 +1,497 @@
+package nftables
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Before modifying the system firewall we take a snapshot of the chains of
+// the existing tables, and we record the objects we add to them (chains, sets,
+// stateful objects, flowtables) and the existing chains we modify.
+// When the daemon exits, only these objects are restored: the ones we added
+// are deleted, and the chains we modified are restored to the state they had
+// before starting. This way the changes made by other applications while we
+// were running are preserved. The tables we created are deleted.
+//
+// The chains are saved as raw netlink messages, the same ones that the kernel
+// sends us when listing the ruleset, because the nftables lib discards some
+// of their attributes.
+//
+// The snapshot of the tables we modify is also saved to disk, so if the
+// daemon exits unexpectedly, the tables are restored on the next start.
+// It's saved under /run because the ruleset doesn't survive a reboot.
+var rulesetFile = "/run/opensnitchd/nftables-ruleset.json"
+
+// attributes and messages not defined yet by the x/sys/unix package.
+const (
+	nftaChainFlags    = 0xa
+	nftaChainUserdata = 0xc
+	nftaFlowtableName = 0x2
+
+	nftMsgNewFlowtable = 0x16
+	nftMsgGetFlowtable = 0x17
+	nftMsgDelFlowtable = 0x18
+)
+
+// The kernel adds to the listings some attributes that are not accepted when
+// creating the objects (handles, number of references, etc), so we only keep
+// the ones needed to create them.
+var (
+	chainAttrs = []uint16{unix.NFTA_CHAIN_TABLE, unix.NFTA_CHAIN_NAME, unix.NFTA_CHAIN_HOOK, unix.NFTA_CHAIN_POLICY,
+		unix.NFTA_CHAIN_TYPE, unix.NFTA_CHAIN_COUNTERS, nftaChainFlags, nftaChainUserdata}
+)
+
+// rawMsg is a netlink message of the ruleset.
+type rawMsg struct {
+	Type int
+	// attributes of the message, without the nfgenmsg header.
+	Data []byte
+}
+
+// tableObj is an object we added to a table.
+type tableObj struct {
+	// message used to add it (NFT_MSG_NEWCHAIN, NFT_MSG_NEWSET, etc).
+	Type int
+	Name string
+	// type of the stateful objects (counter, quota).
+	ObjType uint32 `json:",omitempty"`
+}
+
+// tableSnapshot holds the state of a table before we modified it, and the
+// changes we made.
+type tableSnapshot struct {
+	Name   string
+	Family nftables.TableFamily
+	// the table didn't exist before starting, so it must be deleted.
+	Created bool
+	// messages to create the chains of the table, by name.
+	Chains map[string]rawMsg
+	// objects we added to the table.
+	Added []tableObj
+	// existing chains we modified.
+	Changed []string
+}
+
+// store of the ruleset of the system, before we modified it.
+type sysRulesetT struct {
+	sync.RWMutex
+	// state of all the tables of the system when we started.
+	tables map[string]*tableSnapshot
+	// tables that we have modified.
+	modified map[string]*tableSnapshot
+	// false if we couldn't take the snapshot.
+	valid bool
+}
+
+func getSnapshotKey(name string, family nftables.TableFamily) string {
+	return fmt.Sprintf("%s-%d", name, family)
+}
+
+// Modified registers a table that is going to be modified.
+func (r *sysRulesetT) Modified(tbl *nftables.Table) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.valid {
+		return
+	}
+	if r.modifiedTable(tbl) != nil {
+		r.save()
+	}
+}
+
+// Added registers an object added to a table: a new object, or an existing
+// chain modified.
+func (r *sysRulesetT) Added(tbl *nftables.Table, msgType int, name string, objType uint32) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.valid {
+		return
+	}
+	changed := r.modifiedTable(tbl) != nil
+	snap := r.modified[getSnapshotKey(tbl.Name, tbl.Family)]
+	if !snap.Created && snap.add(msgType, name, objType) {
+		changed = true
+	}
+	if changed {
+		r.save()
+	}
+}
+
+// add records an object added to the table, and returns false if it was
+// already recorded.
+func (s *tableSnapshot) add(msgType int, name string, objType uint32) bool {
+	if _, existed := s.Chains[name]; existed && msgType == unix.NFT_MSG_NEWCHAIN {
+		for _, c := range s.Changed {
+			if c == name {
+				return false
+			}
+		}
+		s.Changed = append(s.Changed, name)
+		return true
+	}
+	obj := tableObj{Type: msgType, Name: name, ObjType: objType}
+	for _, o := range s.Added {
+		if o == obj {
+			return false
+		}
+	}
+	s.Added = append(s.Added, obj)
+	return true
+}
+
+// modifiedTable registers a table as modified, and returns its snapshot.
+// It returns nil if it was already registered.
+func (r *sysRulesetT) modifiedTable(tbl *nftables.Table) *tableSnapshot {
+	key := getSnapshotKey(tbl.Name, tbl.Family)
+	if _, found := r.modified[key]; found {
+		return nil
+	}
+	snap, found := r.tables[key]
+	if !found {
+		snap = &tableSnapshot{Name: tbl.Name, Family: tbl.Family, Created: true}
+	}
+	r.modified[key] = snap
+	return snap
+}
+
+// IsValid returns if the snapshot of the ruleset was taken.
+func (r *sysRulesetT) IsValid() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.valid
+}
+
+// save writes to disk the snapshot of the tables we've modified.
+func (r *sysRulesetT) save() {
+	if len(r.modified) == 0 {
+		os.Remove(rulesetFile)
+		return
+	}
+	tables := []*tableSnapshot{}
+	for _, snap := range r.modified {
+		tables = append(tables, snap)
+	}
+	raw, err := json.Marshal(tables)
+	if err != nil {
+		log.Warning("%s error encoding ruleset snapshot: %s", logTag, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(rulesetFile), 0700); err != nil {
+		log.Warning("%s error saving ruleset snapshot: %s", logTag, err)
+		return
+	}
+	if err := ioutil.WriteFile(rulesetFile, raw, 0600); err != nil {
+		log.Warning("%s error saving ruleset snapshot: %s", logTag, err)
+	}
+}
+
+// snapshotRuleset saves the current tables and chains of the system.
+// nft list chains
+func (n *Nft) snapshotRuleset() {
+	tables, err := dumpRuleset()
+
+	sysRuleset.Lock()
+	defer sysRuleset.Unlock()
+	sysRuleset.modified = make(map[string]*tableSnapshot)
+	sysRuleset.save()
+	if err != nil {
+		log.Warning("%s error saving the existing ruleset, it won't be restored on exit: %s", logTag, err)
+		sysRuleset.valid = false
+		return
+	}
+	sysRuleset.tables = tables
+	sysRuleset.valid = true
+}
+
+// restoreRuleset restores the tables we modified to their original state.
+// The tables that couldn't be restored are kept on disk, to try it again
+// on the next start.
+func (n *Nft) restoreRuleset() {
+	sysRuleset.Lock()
+	defer sysRuleset.Unlock()
+
+	for key, snap := range sysRuleset.modified {
+		if err := n.restoreTable(snap); err != nil {
+			log.Warning("%s error restoring table %s, %s: %s", logTag, snap.Name, getFamilyName(snap.Family), err)
+			continue
+		}
+		delete(sysRuleset.modified, key)
+	}
+	sysRuleset.save()
+}
+
+// restoreRulesetFromDisk restores the tables modified by a previous instance
+// of the daemon that didn't exit cleanly.
+func (n *Nft) restoreRulesetFromDisk() {
+	raw, err := ioutil.ReadFile(rulesetFile)
+	if err != nil {
+		return
+	}
+	tables := []*tableSnapshot{}
+	if err := json.Unmarshal(raw, &tables); err != nil {
+		log.Warning("%s invalid ruleset snapshot %s: %s", logTag, rulesetFile, err)
+		os.Remove(rulesetFile)
+		return
+	}
+	log.Important("%s the daemon didn't exit cleanly, restoring the tables it modified", logTag)
+
+	sysRuleset.Lock()
+	for _, snap := range tables {
+		sysRuleset.modified[getSnapshotKey(snap.Name, snap.Family)] = snap
+	}
+	sysRuleset.Unlock()
+	n.restoreRuleset()
+}
+
+// restoreTable deletes the table if we created it. Otherwise it deletes our
+// rules and the objects we added, and adds again the chains we modified as
+// they were before starting, in a single batch.
+func (n *Nft) restoreTable(snap *tableSnapshot) error {
+	tbl := &nftables.Table{Name: snap.Name, Family: snap.Family}
+	if !n.tableExists(tbl) {
+		return nil
+	}
+	if snap.Created {
+		req := newNftMsg(unix.NFT_MSG_DELTABLE, 0, tbl)
+		req.AddData(nl.NewRtAttr(unix.NFTA_TABLE_NAME, nl.ZeroTerminated(tbl.Name)))
+		return sendBatch(req)
+	}
+
+	msgs, err := delOurRulesMsgs(tbl)
+	if err != nil {
+		return err
+	}
+	// the objects that no longer exist can't be deleted, and the rules must
+	// be deleted before the objects they reference, and these before the
+	// chains.
+	for _, objType := range []struct {
+		newType, getType, delType int
+		nameAttr                  uint16
+	}{
+		{unix.NFT_MSG_NEWSET, unix.NFT_MSG_GETSET, unix.NFT_MSG_DELSET, unix.NFTA_SET_NAME},
+		{nftMsgNewFlowtable, nftMsgGetFlowtable, nftMsgDelFlowtable, nftaFlowtableName},
+		{unix.NFT_MSG_NEWOBJ, unix.NFT_MSG_GETOBJ, unix.NFT_MSG_DELOBJ, unix.NFTA_OBJ_NAME},
+		{unix.NFT_MSG_NEWCHAIN, unix.NFT_MSG_GETCHAIN, unix.NFT_MSG_DELCHAIN, unix.NFTA_CHAIN_NAME},
+	} {
+		added := []tableObj{}
+		for _, obj := range snap.Added {
+			if obj.Type == objType.newType {
+				added = append(added, obj)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		objects, err := listTableObjects(tbl, objType.getType, objType.newType)
+		if err != nil {
+			return err
+		}
+		loaded := make(map[string]bool)
+		for _, attrs := range objects {
+			loaded[strings.TrimRight(string(attrs[objType.nameAttr]), "\x00")] = true
+		}
+		for _, obj := range added {
+			if !loaded[obj.Name] {
+				continue
+			}
+			req := newNftMsg(objType.delType, 0, tbl)
+			// the table is the first attribute of all the objects.
+			req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_TABLE, nl.ZeroTerminated(tbl.Name)))
+			req.AddData(nl.NewRtAttr(int(objType.nameAttr), nl.ZeroTerminated(obj.Name)))
+			if objType.delType == unix.NFT_MSG_DELOBJ {
+				req.AddData(nl.NewRtAttr(unix.NFTA_OBJ_TYPE, binaryutil.BigEndian.PutUint32(obj.ObjType)))
+			}
+			msgs = append(msgs, req)
+		}
+	}
+	for _, name := range snap.Changed {
+		if m, found := snap.Chains[name]; found {
+			req := newNftMsg(m.Type, unix.NLM_F_CREATE, tbl)
+			req.AddRawData(m.Data)
+			msgs = append(msgs, req)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	return sendBatch(msgs...)
+}
+
+// delOurRulesMsgs returns the messages to delete the rules added by us to
+// the given table.
+func delOurRulesMsgs(tbl *nftables.Table) ([]*nl.NetlinkRequest, error) {
+	rules, err := listTableObjects(tbl, unix.NFT_MSG_GETRULE, unix.NFT_MSG_NEWRULE)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*nl.NetlinkRequest{}
+	for _, attrs := range rules {
+		if parseRuleMeta(attrs[unix.NFTA_RULE_USERDATA]) == nil {
+			continue
+		}
+		req := newNftMsg(unix.NFT_MSG_DELRULE, 0, tbl)
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_TABLE, nl.ZeroTerminated(tbl.Name)))
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_CHAIN, attrs[unix.NFTA_RULE_CHAIN]))
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_HANDLE, attrs[unix.NFTA_RULE_HANDLE]))
+		msgs = append(msgs, req)
+	}
+
+	return msgs, nil
+}
+
+// listTableObjects returns the attributes of the objects of the given type
+// of a table, by attribute type.
+func listTableObjects(tbl *nftables.Table, getType, newType int) ([]map[uint16][]byte, error) {
+	replies, err := dumpObjects(getType, newType, tbl.Family)
+	if err != nil {
+		return nil, err
+	}
+	objects := []map[uint16][]byte{}
+	for _, data := range replies {
+		// all the objects have the name of the table as first attribute.
+		family, name, raw, err := parseDumpMsg(data, unix.NFTA_CHAIN_TABLE)
+		if err != nil {
+			return nil, err
+		}
+		if family != tbl.Family || name != tbl.Name {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(raw)
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[uint16][]byte)
+		for _, a := range attrs {
+			obj[a.Attr.Type&nl.NLA_TYPE_MASK] = a.Value
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+func (n *Nft) tableExists(tbl *nftables.Table) bool {
+	tables, err := n.conn.ListTables()
+	if err != nil {
+		return false
+	}
+	for _, t := range tables {
+		if t.Name == tbl.Name && t.Family == tbl.Family {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpRuleset returns the tables of the system, and the messages needed to
+// create their chains.
+func dumpRuleset() (map[string]*tableSnapshot, error) {
+	tables := make(map[string]*tableSnapshot)
+
+	replies, err := dumpObjects(unix.NFT_MSG_GETTABLE, unix.NFT_MSG_NEWTABLE, nftables.TableFamilyUnspecified)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range replies {
+		family, name, _, err := parseDumpMsg(data, unix.NFTA_TABLE_NAME)
+		if err != nil {
+			return nil, err
+		}
+		tables[getSnapshotKey(name, family)] = &tableSnapshot{
+			Name:   name,
+			Family: family,
+			Chains: make(map[string]rawMsg),
+		}
+	}
+
+	replies, err = dumpObjects(unix.NFT_MSG_GETCHAIN, unix.NFT_MSG_NEWCHAIN, nftables.TableFamilyUnspecified)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range replies {
+		family, name, attrs, err := parseDumpMsg(data, unix.NFTA_CHAIN_TABLE)
+		if err != nil {
+			return nil, err
+		}
+		snap, found := tables[getSnapshotKey(name, family)]
+		if !found {
+			continue
+		}
+		_, chain, _, err := parseDumpMsg(data, unix.NFTA_CHAIN_NAME)
+		if err != nil {
+			return nil, err
+		}
+		if attrs, err = filterAttrs(attrs, chainAttrs...); err != nil {
+			return nil, err
+		}
+		snap.Chains[chain] = rawMsg{Type: unix.NFT_MSG_NEWCHAIN, Data: attrs}
+	}
+
+	return tables, nil
+}
+
+// dumpObjects lists the objects of the given type of the given family.
+// The replies are returned without the netlink header.
+func dumpObjects(getType, newType int, family nftables.TableFamily, attrs ...*nl.RtAttr) ([][]byte, error) {
+	req := newNftMsg(getType, unix.NLM_F_DUMP, &nftables.Table{Family: family})
+	for _, a := range attrs {
+		req.AddData(a)
+	}
+	return req.Execute(unix.NETLINK_NETFILTER, uint16((unix.NFNL_SUBSYS_NFTABLES<<8)|newType))
+}
+
+// parseDumpMsg returns the family, the table and the attributes of a message.
+func parseDumpMsg(data []byte, tableAttr uint16) (nftables.TableFamily, string, []byte, error) {
+	if len(data) < nl.SizeofNfgenmsg {
+		return 0, "", nil, fmt.Errorf("invalid netlink message, len: %d", len(data))
+	}
+	family := nftables.TableFamily(data[0])
+	attrs, err := nl.ParseRouteAttr(data[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return 0, "", nil, err
+	}
+	for _, a := range attrs {
+		if a.Attr.Type&nl.NLA_TYPE_MASK == tableAttr {
+			return family, strings.TrimRight(string(a.Value), "\x00"), data[nl.SizeofNfgenmsg:], nil
+		}
+	}
+
+	return 0, "", nil, fmt.Errorf("netlink message without table")
+}
+
+// filterAttrs returns the attributes of the given types.
+func filterAttrs(data []byte, keep ...uint16) ([]byte, error) {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{}
+	for _, a := range attrs {
+		if hasAttrType(a.Attr.Type, keep) {
+			b = append(b, nl.NewRtAttr(int(a.Attr.Type), a.Value).Serialize()...)
+		}
+	}
+
+	return b, nil
+}
+
+func hasAttrType(attrType uint16, types []uint16) bool {
+	for _, t := range types {
+		if attrType&nl.NLA_TYPE_MASK == t {
+			return true
+		}
+	}
+	return false
+}
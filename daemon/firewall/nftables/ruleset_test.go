@@ -0,0 +1,44 @@
+package nftables
+
+import (
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"golang.org/x/sys/unix"
+)
+
+func TestTableSnapshotAdd(t *testing.T) {
+	snap := &tableSnapshot{
+		Name:   "filter",
+		Chains: map[string]rawMsg{"input": {Type: unix.NFT_MSG_NEWCHAIN}},
+	}
+	tests := []struct {
+		name    string
+		msgType int
+		objName string
+		objType uint32
+		want    bool
+	}{
+		{"existing chain", unix.NFT_MSG_NEWCHAIN, "input", 0, true},
+		{"existing chain again", unix.NFT_MSG_NEWCHAIN, "input", 0, false},
+		{"new chain", unix.NFT_MSG_NEWCHAIN, "forward", 0, true},
+		{"new set", unix.NFT_MSG_NEWSET, "input", 0, true},
+		{"new counter", unix.NFT_MSG_NEWOBJ, "opensnitch", exprs.NFT_OBJECT_COUNTER, true},
+		{"new quota with the same name", unix.NFT_MSG_NEWOBJ, "opensnitch", exprs.NFT_OBJECT_QUOTA, true},
+		{"new counter again", unix.NFT_MSG_NEWOBJ, "opensnitch", exprs.NFT_OBJECT_COUNTER, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := snap.add(test.msgType, test.objName, test.objType); got != test.want {
+				t.Errorf("add() = %v, want %v", got, test.want)
+			}
+		})
+	}
+	if len(snap.Changed) != 1 || snap.Changed[0] != "input" {
+		t.Errorf("unexpected modified chains: %v", snap.Changed)
+	}
+	if len(snap.Added) != 4 {
+		t.Errorf("unexpected added objects: %v", snap.Added)
+	}
+}
@@ -0,0 +1,111 @@
+package nftables
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+const setPrefix = "opensnitch-set"
+
+// store of named sets added to the system.
+// Contrary to anonymous sets, named sets are not deleted when the rule that
+// references them is deleted, so we need to keep track of them.
+type sysSetsT struct {
+	sets  map[string]*nftables.Set
+	count uint32
+	sync.RWMutex
+}
+
+func (s *sysSetsT) Add(key string, set *nftables.Set) {
+	s.Lock()
+	defer s.Unlock()
+	s.sets[key] = set
+}
+
+func (s *sysSetsT) Get(key string) *nftables.Set {
+	s.RLock()
+	defer s.RUnlock()
+	return s.sets[key]
+}
+
+// List returns a copy of the sets, so they can be deleted while iterating it.
+func (s *sysSetsT) List() map[string]*nftables.Set {
+	s.RLock()
+	defer s.RUnlock()
+	list := make(map[string]*nftables.Set, len(s.sets))
+	for k, v := range s.sets {
+		list[k] = v
+	}
+	return list
+}
+
+func (s *sysSetsT) Del(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sets, key)
+}
+
+// newName returns a new unique set name.
+func (s *sysSetsT) newName() string {
+	s.Lock()
+	defer s.Unlock()
+	s.count++
+	return fmt.Sprint(setPrefix, "-", s.count)
+}
+
+func getSetKey(name string, table *nftables.Table) string {
+	return fmt.Sprintf("%s-%s-%d", name, table.Name, table.Family)
+}
+
+// AddNamedSet queues a new named set with the given elements.
+// The set is added to the system on the next Commit(), so it must be called
+// before adding the rule that references it, in the same batch.
+// nft add set inet filter opensnitch-set-1 { type ipv4_addr; flags interval; }
+func (n *Nft) AddNamedSet(tbl *nftables.Table, keyType nftables.SetDatatype, interval bool, elements []nftables.SetElement) (*nftables.Set, error) {
+	// the stores are not initialized when checking the configuration, and
+	// the name doesn't matter since the set is not added to the system.
+	name := setPrefix + "-check"
+	if !n.dryRun {
+		name = sysNamedSets.newName()
+	}
+	set := &nftables.Set{
+		Name:     name,
+		Table:    tbl,
+		KeyType:  keyType,
+		Interval: interval,
+	}
+	if err := n.conn.AddSet(set, elements); err != nil {
+		return nil, err
+	}
+	if !n.dryRun {
+		sysNamedSets.Add(getSetKey(set.Name, tbl), set)
+		sysRuleset.Added(tbl, unix.NFT_MSG_NEWSET, set.Name, 0)
+	}
+
+	return set, nil
+}
+
+// delNamedSets queues the deletion of the named sets we added.
+// It must be called after deleting the rules that reference them, otherwise
+// the kernel refuses to delete them.
+func (n *Nft) delNamedSets() {
+	for k, set := range sysNamedSets.List() {
+		n.conn.DelSet(set)
+		sysNamedSets.Del(k)
+	}
+}
+
+// delUnusedNamedSets queues the deletion of the named sets that are no longer
+// referenced by any rule.
+func (n *Nft) delUnusedNamedSets(refs *objRefs) {
+	for k, set := range sysNamedSets.List() {
+		if refs.sets[k] {
+			continue
+		}
+		n.conn.DelSet(set)
+		sysNamedSets.Del(k)
+	}
+}
@@ -1,11 +1,11 @@
 package nftables
 
 import (
+	"fmt"
 	"strings"
 	"sync"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/config"
-	"github.com/evilsocket/opensnitch/daemon/firewall/iptables"
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/google/nftables"
@@ -31,10 +31,15 @@ func (t *sysTablesT) Get(name string) *nftables.Table {
 	return t.tables[name]
 }
 
+// List returns a copy of the tables, so they can be deleted while iterating it.
 func (t *sysTablesT) List() map[string]*nftables.Table {
 	t.RLock()
 	defer t.RUnlock()
-	return t.tables
+	list := make(map[string]*nftables.Table, len(t.tables))
+	for k, v := range t.tables {
+		list[k] = v
+	}
+	return list
 }
 
 func (t *sysTablesT) Del(name string) {
@@ -49,6 +54,11 @@ var (
 	sysChains     *sync.Map
 	origSysChains map[string]*nftables.Chain
 	sysSets       []*nftables.Set
+	sysNamedSets  *sysSetsT
+	sysCounters   *sysCountersT
+	sysQuotas     *sysQuotasT
+	sysFlowtables *sysFlowtablesT
+	sysRuleset    *sysRulesetT
 )
 
 func initMapsStore() {
@@ -57,6 +67,22 @@ func initMapsStore() {
 	}
 	sysChains = &sync.Map{}
 	origSysChains = make(map[string]*nftables.Chain)
+	sysNamedSets = &sysSetsT{
+		sets: make(map[string]*nftables.Set),
+	}
+	sysCounters = &sysCountersT{
+		counters: make(map[string]*chainCounter),
+	}
+	sysQuotas = &sysQuotasT{
+		quotas: make(map[string]*quotaObj),
+	}
+	sysFlowtables = &sysFlowtablesT{
+		flowtables: make(map[string]*nftables.Flowtable),
+	}
+	sysRuleset = &sysRulesetT{
+		tables:   make(map[string]*tableSnapshot),
+		modified: make(map[string]*tableSnapshot),
+	}
 }
 
 // CreateSystemRule create the custom firewall chains and adds them to system.
@@ -73,19 +99,23 @@ func (n *Nft) CreateSystemRule(chain *config.FwChain, logErrors bool) bool {
 	// regular chains doesn't have a hook, nor a type
 	if chain.Hook == "" && chain.Type == "" {
 		n.addRegularChain(chain.Name, tableName, chain.Family)
-		return n.Commit()
+		return n.Commit() != CommitFailed
 	}
 
-	chainPolicy := nftables.ChainPolicyAccept
-	if iptables.Action(strings.ToLower(chain.Policy)) == exprs.VERDICT_DROP {
-		chainPolicy = nftables.ChainPolicyDrop
+	chainHook, chainPrio, chainType, chainPolicy, err := parseSystemChain(chain)
+	if err != nil {
+		log.Warning("%s chain %s, table %s: %s", logTag, chain.Name, chain.Table, err)
+		return false
 	}
 
-	chainHook := getHook(chain.Hook)
-	chainPrio, chainType := getChainPriority(chain.Family, chain.Type, chain.Hook)
-	if chainPrio == nil {
-		log.Warning("%s Invalid system firewall combination: %s, %s", logTag, chain.Type, chain.Hook)
-		return false
+	// chains on the ingress and egress hooks are attached to a network interface.
+	if hook := strings.ToLower(chain.Hook); hook == exprs.NFT_HOOK_INGRESS || hook == exprs.NFT_HOOK_EGRESS {
+		if ret := n.addNetdevChain(chain.Name, chain.Table, chain.Family, chain.Device, chainPrio,
+			chainType, chainHook, chainPolicy); ret == nil {
+			log.Warning("%s error adding chain: %s, table: %s", logTag, chain.Name, chain.Table)
+			return false
+		}
+		return n.Commit() != CommitFailed
 	}
 
 	if ret := n.AddChain(chain.Name, chain.Table, chain.Family, chainPrio,
@@ -94,7 +124,36 @@ func (n *Nft) CreateSystemRule(chain *config.FwChain, logErrors bool) bool {
 		return false
 	}
 
-	return n.Commit()
+	return n.Commit() != CommitFailed
+}
+
+// parseSystemChain validates the options of a base chain (hook, type,
+// priority and policy), and converts them to the nftables types.
+func parseSystemChain(chain *config.FwChain) (*nftables.ChainHook, *nftables.ChainPriority, nftables.ChainType, nftables.ChainPolicy, error) {
+	chainPolicy, err := getChainPolicy(chain.Policy)
+	if err != nil {
+		return nil, nil, "", chainPolicy, err
+	}
+
+	chainHook := getHook(chain.Family, chain.Hook)
+	chainPrio, chainType := getChainPriority(chain.Family, chain.Type, chain.Hook)
+	if chainPrio == nil {
+		return nil, nil, "", chainPolicy, fmt.Errorf("invalid system firewall combination: %s, %s", chain.Type, chain.Hook)
+	}
+	// the default priority of the chain type can be overridden by the user.
+	if chain.Priority != "" {
+		if chainPrio, err = parseChainPriority(chain.Family, chain.Priority); err != nil {
+			return nil, nil, "", chainPolicy, err
+		}
+	}
+
+	if hook := strings.ToLower(chain.Hook); hook == exprs.NFT_HOOK_INGRESS || hook == exprs.NFT_HOOK_EGRESS {
+		if chain.Device == "" {
+			return nil, nil, "", chainPolicy, fmt.Errorf("hook %s: Device cannot be empty", chain.Hook)
+		}
+	}
+
+	return chainHook, chainPrio, chainType, chainPolicy, nil
 }
 
 // AddSystemRules creates the system firewall from configuration.
@@ -102,6 +161,13 @@ func (n *Nft) AddSystemRules(reload, backupExistingChains bool) {
 	n.SysConfig.RLock()
 	defer n.SysConfig.RUnlock()
 
+	n.exclusive(func() {
+		n.addSystemRules(reload, backupExistingChains)
+	})
+}
+
+// addSystemRules must be called with the configuration locked.
+func (n *Nft) addSystemRules(reload, backupExistingChains bool) {
 	if n.SysConfig.Enabled == false {
 		log.Important("[nftables] AddSystemRules() fw disabled")
 		return
@@ -112,7 +178,12 @@ func (n *Nft) AddSystemRules(reload, backupExistingChains bool) {
 
 	for _, fwCfg := range n.SysConfig.SystemRules {
 		for _, chain := range fwCfg.Chains {
-			if !n.CreateSystemRule(chain, true) {
+			if err := n.Queue(func() error {
+				if !n.CreateSystemRule(chain, true) {
+					return fmt.Errorf("error creating chain %s, table %s", chain.Name, chain.Table)
+				}
+				return nil
+			}); err != nil {
 				log.Info("createSystem failed: %s %s", chain.Name, chain.Table)
 				continue
 			}
@@ -122,7 +193,11 @@ func (n *Nft) AddSystemRules(reload, backupExistingChains bool) {
 					chain.Rules[i].UUID = uuid.String()
 				}
 				if chain.Rules[i].Enabled {
-					n.AddSystemRule(chain.Rules[i], chain)
+					rule := chain.Rules[i]
+					n.Queue(func() error {
+						err, _ := n.AddSystemRule(rule, chain)
+						return err
+					})
 				}
 			}
 		}
@@ -133,12 +208,19 @@ func (n *Nft) AddSystemRules(reload, backupExistingChains bool) {
 // If force is false and the rule has not been previously added,
 // it won't try to delete the tables and chains. Otherwise it'll try to delete them.
 func (n *Nft) DeleteSystemRules(force, restoreExistingChains, logErrors bool) {
+	n.exclusive(func() {
+		n.deleteSystemRules(force, restoreExistingChains, logErrors)
+	})
+}
+
+func (n *Nft) deleteSystemRules(force, restoreExistingChains, logErrors bool) {
 	n.Lock()
 	defer n.Unlock()
 
-	if err := n.delRulesByKey(systemRuleKey); err != nil {
+	if err := n.delRulesByOrigin(systemRuleKey); err != nil {
 		log.Warning("error deleting interception rules: %s", err)
 	}
+	n.delNamedObjects(nil)
 
 	if restoreExistingChains {
 		n.restoreBackupChains()
@@ -148,24 +230,167 @@ func (n *Nft) DeleteSystemRules(force, restoreExistingChains, logErrors bool) {
 	}
 }
 
+// objRefs holds the named objects referenced by the rules, by key (see
+// getSetKey() and getObjKey()).
+type objRefs struct {
+	sets       map[string]bool
+	objs       map[string]bool
+	flowtables map[string]bool
+}
+
+// getObjRefs returns the named sets, quotas and flowtables referenced by the
+// rules of the tables where we added them.
+func (n *Nft) getObjRefs() (*objRefs, error) {
+	refs := &objRefs{
+		sets:       make(map[string]bool),
+		objs:       make(map[string]bool),
+		flowtables: make(map[string]bool),
+	}
+	tables := make(map[string]bool)
+	for _, set := range sysNamedSets.List() {
+		tables[getTableKey(set.Table.Name, set.Table.Family)] = true
+	}
+	for _, quota := range sysQuotas.List() {
+		tables[getTableKey(quota.table.Name, quota.table.Family)] = true
+	}
+	for _, ft := range sysFlowtables.List() {
+		tables[getTableKey(ft.Table.Name, ft.Table.Family)] = true
+	}
+	if len(tables) == 0 {
+		return refs, nil
+	}
+
+	chains, err := n.conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chains {
+		if !tables[getTableKey(c.Table.Name, c.Table.Family)] {
+			continue
+		}
+		rules, err := n.conn.GetRule(c.Table, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			for _, e := range r.Exprs {
+				switch e := e.(type) {
+				case *expr.Lookup:
+					refs.sets[getSetKey(e.SetName, c.Table)] = true
+				case *expr.Dynset:
+					refs.sets[getSetKey(e.SetName, c.Table)] = true
+				case *expr.Objref:
+					refs.objs[getObjKey(e.Name, c.Table)] = true
+				case *expr.FlowOffload:
+					refs.flowtables[getObjKey(e.Name, c.Table)] = true
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+// delNamedObjects deletes the named sets, quotas and flowtables we added in a
+// single batch, or only the ones not referenced by refs, if not nil.
+// If the batch fails none of them is deleted, and they're kept in the stores.
+func (n *Nft) delNamedObjects(refs *objRefs) {
+	n.Begin()
+	if refs == nil {
+		n.delNamedSets()
+		n.delNamedQuotas()
+		n.delFlowtables()
+	} else {
+		n.delUnusedNamedSets(refs)
+		n.delUnusedNamedQuotas(refs)
+		n.delUnusedFlowtables(refs)
+	}
+	if err := n.CommitAll(); err != nil {
+		log.Warning("%s error deleting named sets, quotas and flowtables: %s", logTag, err)
+	}
+}
+
 // AddSystemRule inserts a new rule.
+// If any of the expressions of the rule is invalid, the rule is not added.
 func (n *Nft) AddSystemRule(rule *config.FwRule, chain *config.FwChain) (err4, err6 error) {
 	n.Lock()
 	defer n.Unlock()
+
+	exprList, field, err := n.compileRule(rule, chain)
+	if err != nil {
+		log.Warning("%s error adding rule %s, %s: %s", logTag, rule.UUID, field, err)
+		return err, nil
+	}
+	if len(*exprList) > 0 {
+		if err4 = n.insertRule(chain.Name, chain.Table, chain.Family, rule.Position, exprList, newSystemRuleMeta(rule)); err4 != nil {
+			log.Warning("error adding rule: %v", rule)
+		}
+	}
+
+	return err4, nil
+}
+
+// compileRule converts the expressions and the verdict of a rule to nftables
+// expressions. On error, it returns the field of the rule that is not valid.
+func (n *Nft) compileRule(rule *config.FwRule, chain *config.FwChain) (*[]expr.Any, string, error) {
+	exprVerdict, err := exprs.NewExprVerdict(chain.Family, rule.Target, rule.TargetParameters)
+	if err != nil {
+		return nil, "Target", err
+	}
+
 	exprList := []expr.Any{}
+	for i, expression := range rule.Expressions {
+		exprsOfRule, err := n.parseExpression(chain.Table, chain.Name, chain.Family, expression)
+		if err != nil {
+			return nil, fmt.Sprintf("Expressions[%d]", i), err
+		}
+		exprList = append(exprList, *exprsOfRule...)
+	}
+	if len(exprList) == 0 {
+		return &exprList, "", nil
+	}
 
-	for _, expression := range rule.Expressions {
-		if exprsOfRule := n.parseExpression(chain.Table, chain.Name, chain.Family, expression); exprsOfRule != nil {
-			exprList = append(exprList, *exprsOfRule...)
+	if !n.dryRun {
+		if chn := getChain(chain.Name, n.getTable(chain.Table, chain.Family)); chn != nil {
+			exprList = append(exprList, *n.getCounterExpr(chn, false)...)
 		}
+		exprList = append(exprList, *exprs.NewExprAnonCounter()...)
 	}
-	if len(exprList) > 0 {
-		exprVerdict := exprs.NewExprVerdict(rule.Target, rule.TargetParameters)
-		exprList = append(exprList, *exprVerdict...)
-		if err := n.insertRule(chain.Name, chain.Table, chain.Family, rule.Position, &exprList); err != nil {
-			log.Warning("error adding rule: %v", rule)
+	exprList = append(exprList, *exprVerdict...)
+
+	return &exprList, "", nil
+}
+
+// CheckSystemRules compiles the given system firewall configuration, without
+// adding it to the system, and returns the errors found.
+// Disabled rules are also checked.
+func CheckSystemRules(cfg *config.SystemConfig) []*config.ConfigError {
+	n := &Nft{conn: NewNft(), dryRun: true}
+	errs := []*config.ConfigError{}
+
+	for i, fwCfg := range cfg.SystemRules {
+		for j, chain := range fwCfg.Chains {
+			chainPath := fmt.Sprintf("SystemRules[%d].Chains[%d]", i, j)
+			if chain.IsInvalid() {
+				errs = append(errs, &config.ConfigError{
+					Path: chainPath,
+					Err:  fmt.Errorf("chain's fields Name, Family and Table cannot be empty"),
+				})
+				continue
+			}
+			if chain.Hook != "" || chain.Type != "" {
+				if _, _, _, _, err := parseSystemChain(chain); err != nil {
+					errs = append(errs, &config.ConfigError{Path: chainPath, Err: err})
+				}
+			}
+
+			for k, rule := range chain.Rules {
+				if _, field, err := n.compileRule(rule, chain); err != nil {
+					rulePath := fmt.Sprintf("%s.Rules[%d].%s", chainPath, k, field)
+					errs = append(errs, &config.ConfigError{Path: rulePath, Err: err})
+				}
+			}
 		}
 	}
 
-	return nil, nil
+	return errs
 }
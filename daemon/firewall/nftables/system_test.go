@@ -0,0 +1,82 @@
+package nftables
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/google/nftables"
+)
+
+// systemConfig returns a configuration with a rule matching the IP of the
+// given key and value.
+func systemConfig(t *testing.T, key, value string) *config.SystemConfig {
+	raw := fmt.Sprintf(`{
+		"Enabled": true,
+		"SystemRules": [{
+			"Chains": [{
+				"Name": "input", "Table": "filter", "Family": "inet",
+				"Priority": "0", "Type": "filter", "Hook": "input", "Policy": "accept",
+				"Rules": [{
+					"Enabled": true,
+					"Target": "accept",
+					"Expressions": [{
+						"Statement": {"Op": "==", "Name": "ip", "Values": [{"Key": %q, "Value": %q}]}
+					}]
+				}]
+			}]
+		}]
+	}`, key, value)
+	cfg := &config.SystemConfig{}
+	if err := json.Unmarshal([]byte(raw), cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestCheckSystemRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{"single IP", exprs.NFT_DADDR, "1.1.1.1", false},
+		{"IP list", exprs.NFT_DADDR, "1.1.1.1,2.2.2.2", false},
+		{"source IP list", exprs.NFT_SADDR, "10.0.0.0/8,192.168.1.1", false},
+		{"IP range list", exprs.NFT_DADDR, "1.1.1.1-1.1.1.10,2.2.2.2", false},
+		{"invalid IP list", exprs.NFT_DADDR, "1.1.1.1,not-an-ip", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := CheckSystemRules(systemConfig(t, test.key, test.value))
+			if test.wantErr && len(errs) == 0 {
+				t.Error("expected an error, got none")
+			}
+			if !test.wantErr && len(errs) > 0 {
+				t.Errorf("unexpected error: %s: %v", errs[0].Path, errs[0].Err)
+			}
+		})
+	}
+}
+
+func TestStoresListCopy(t *testing.T) {
+	sets := &sysSetsT{sets: make(map[string]*nftables.Set)}
+	for i := 0; i < 3; i++ {
+		sets.Add(fmt.Sprint("set-", i), &nftables.Set{})
+	}
+	// deleting while iterating must not modify the list being iterated.
+	list := sets.List()
+	for k := range list {
+		sets.Del(k)
+	}
+	if len(list) != 3 {
+		t.Errorf("the list has been modified: %v", list)
+	}
+	if len(sets.List()) != 0 {
+		t.Errorf("the sets have not been deleted: %v", sets.List())
+	}
+}
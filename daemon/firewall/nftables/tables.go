@@ -17,15 +17,21 @@ func (n *Nft) AddTable(name, family string) (*nftables.Table, error) {
 	}
 	n.conn.AddTable(tbl)
 
-	if !n.Commit() {
+	if n.Commit() == CommitFailed {
 		return nil, fmt.Errorf("%s error adding system firewall table: %s, family: %s (%d)", logTag, name, family, famCode)
 	}
 	key := getTableKey(name, family)
+	if sysTables.Get(key) == nil {
+		sysRuleset.Modified(tbl)
+	}
 	sysTables.Add(key, tbl)
 	return tbl, nil
 }
 
 func (n *Nft) getTable(name, family string) *nftables.Table {
+	if n.dryRun {
+		return &nftables.Table{Name: name, Family: getFamilyCode(family)}
+	}
 	return sysTables.Get(getTableKey(name, family))
 }
 
@@ -72,16 +78,32 @@ func (n *Nft) nonSystemRules(tbl *nftables.Table) int {
 	return t
 }
 
+// delSystemTables deletes the objects we added to the existing tables, restores
+// the chains we modified, and deletes the tables we created.
+// If the snapshot of the ruleset couldn't be taken, only the tables without
+// rules are deleted.
 func (n *Nft) delSystemTables() {
+	if sysRuleset.IsValid() {
+		n.restoreRuleset()
+		for k, tbl := range sysTables.List() {
+			sysTables.Del(k)
+			delTableCounters(tbl)
+			delTableFlowtables(tbl)
+		}
+		return
+	}
+
+	n.Begin()
 	for k, tbl := range sysTables.List() {
 		if n.nonSystemRules(tbl) != 0 {
 			continue
 		}
 		n.conn.DelTable(tbl)
-		if !n.Commit() {
-			log.Warning("error deleting system table: %s", k)
-			continue
-		}
 		sysTables.Del(k)
+		delTableCounters(tbl)
+		delTableFlowtables(tbl)
+	}
+	if err := n.CommitAll(); err != nil {
+		log.Warning("error deleting system tables: %s", err)
 	}
 }
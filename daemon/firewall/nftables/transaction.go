@@ -0,0 +1,264 @@
+package nftables
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/google/nftables"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// transaction holds the state of the changes queued in a single batch.
+//
+// nftables applies batches atomically: either all the changes of the batch
+// are applied, or none of them. So instead of committing every table, chain
+// or rule individually, we can queue all of them and commit them at once,
+// without leaving the firewall half-configured if one of them fails.
+//
+// The changes of a transaction are queued in the same connection as the
+// changes of the other goroutines (the rules checker, the config watcher,
+// the GUI), so the functions that modify the firewall are serialized with
+// exclusive(), and a transaction can only be started by one of them.
+type transaction struct {
+	sync.Mutex
+	// held while the firewall is being modified.
+	batch sync.Mutex
+	// goroutine holding the batch lock, 0 if none.
+	owner  uint64
+	active bool
+	errors []error
+	// functions to be called after the changes have been applied.
+	postCommit []func()
+	// messages of the batch built by us (see netlink.go). If there're any,
+	// the messages queued in the connection are sent along with them.
+	raw []*nl.NetlinkRequest
+
+	// state of the stores when the transaction began
+	tables     map[string]*nftables.Table
+	chains     map[interface{}]interface{}
+	sets       map[string]*nftables.Set
+	counters   map[string]*chainCounter
+	flowtables map[string]*nftables.Flowtable
+	quotas     map[string]*quotaObj
+	anonSets   int
+}
+
+// CommitState is the result of Commit().
+type CommitState int
+
+// Possible CommitState values.
+const (
+	// CommitFailed means that the changes couldn't be applied.
+	CommitFailed CommitState = iota
+	// CommitApplied means that the changes have been applied to the system.
+	CommitApplied
+	// CommitDeferred means that the changes have been queued in the current
+	// transaction, and they'll be applied or discarded by CommitAll().
+	CommitDeferred
+)
+
+// exclusive runs fn while no other goroutine modifies the firewall, so the
+// changes queued by fn are not mixed with the changes of other goroutines.
+// It can be called again from fn (for example, from an exported function
+// called by another one), in which case fn is run directly.
+func (n *Nft) exclusive(fn func()) {
+	id := goroutineID()
+	n.txn.Lock()
+	owner := n.txn.owner
+	n.txn.Unlock()
+	if owner != 0 && owner == id {
+		fn()
+		return
+	}
+
+	n.txn.batch.Lock()
+	n.txn.Lock()
+	n.txn.owner = id
+	n.txn.Unlock()
+	defer func() {
+		n.txn.Lock()
+		n.txn.owner = 0
+		n.txn.Unlock()
+		n.txn.batch.Unlock()
+	}()
+	fn()
+}
+
+// goroutineID returns the id of the current goroutine, from the header of its
+// stack trace: "goroutine 18 [running]:".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := strings.Fields(strings.TrimPrefix(string(buf), "goroutine "))
+	if len(fields) == 0 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(fields[0], 10, 64)
+	return id
+}
+
+// Begin starts a new transaction.
+// From now on, Commit() does not apply the changes to the system, they're
+// queued until CommitAll() is called.
+// It must be called from a function run by exclusive().
+func (n *Nft) Begin() {
+	n.txn.Lock()
+	defer n.txn.Unlock()
+
+	if n.txn.active {
+		log.Debug("%s Begin(), transaction already started", logTag)
+		return
+	}
+	n.txn.active = true
+	n.txn.errors = []error{}
+	n.txn.postCommit = []func(){}
+	n.txn.raw = []*nl.NetlinkRequest{}
+	n.txn.tables = sysTables.List()
+	n.txn.chains = make(map[interface{}]interface{})
+	sysChains.Range(func(k, v interface{}) bool {
+		n.txn.chains[k] = v
+		return true
+	})
+	n.txn.sets = sysNamedSets.List()
+	n.txn.counters = sysCounters.List()
+	n.txn.flowtables = sysFlowtables.List()
+	n.txn.quotas = sysQuotas.List()
+	n.txn.anonSets = len(sysSets)
+}
+
+// Queue adds a new change to the current transaction.
+// If there's no transaction in progress, the change is applied immediately.
+// If the change can't be queued (invalid options, unknown table, etc), the
+// transaction is marked as failed, and it'll be rolled back on CommitAll().
+func (n *Nft) Queue(change func() error) error {
+	err := change()
+	if err != nil && n.inTransaction() {
+		n.txn.Lock()
+		n.txn.errors = append(n.txn.errors, err)
+		n.txn.Unlock()
+	}
+	return err
+}
+
+// CommitAll applies all the changes queued since Begin() in a single batch.
+// On error, the changes are discarded and the state of the stores is
+// restored to the state they had before starting the transaction.
+func (n *Nft) CommitAll() error {
+	n.txn.Lock()
+	defer n.txn.Unlock()
+
+	if !n.txn.active {
+		return fmt.Errorf("%s CommitAll(), no transaction started", logTag)
+	}
+	n.txn.active = false
+
+	if len(n.txn.errors) > 0 {
+		n.rollback()
+		return fmt.Errorf("%d changes failed, first error: %s", len(n.txn.errors), n.txn.errors[0])
+	}
+	if err := n.flushAll(); err != nil {
+		n.rollback()
+		return err
+	}
+	for _, cb := range n.txn.postCommit {
+		cb()
+	}
+
+	return nil
+}
+
+// flushAll sends the changes queued in the connection, along with the
+// messages built by us, if any.
+func (n *Nft) flushAll() error {
+	if len(n.txn.raw) == 0 {
+		return n.conn.Flush()
+	}
+	msgs, err := n.queuedMsgs()
+	if err != nil {
+		return err
+	}
+	return sendBatch(append(n.txn.raw, msgs...)...)
+}
+
+// queueRaw adds messages built by us to the current transaction, after the
+// changes queued so far in the connection, so the objects referenced by
+// them (tables, chains, sets) are added first.
+// If there's no transaction in progress, they're sent immediately.
+func (n *Nft) queueRaw(msgs ...*nl.NetlinkRequest) error {
+	queued, err := n.queuedMsgs()
+	if err != nil {
+		return err
+	}
+	msgs = append(queued, msgs...)
+
+	n.txn.Lock()
+	defer n.txn.Unlock()
+	if n.txn.active {
+		n.txn.raw = append(n.txn.raw, msgs...)
+		return nil
+	}
+	return sendBatch(msgs...)
+}
+
+// afterCommit schedules a function to be executed once the changes have been
+// applied. If there's no transaction in progress, it's executed immediately.
+func (n *Nft) afterCommit(cb func()) {
+	n.txn.Lock()
+	defer n.txn.Unlock()
+	if n.txn.active {
+		n.txn.postCommit = append(n.txn.postCommit, cb)
+		return
+	}
+	cb()
+}
+
+// rollback discards the queued changes, and restores the stores.
+// The kernel doesn't apply any change of a failed batch, so there's nothing
+// to delete from the system.
+func (n *Nft) rollback() {
+	log.Debug("%s rolling back transaction", logTag)
+	// discard the pending messages of the batch, if any.
+	n.conn = NewNft()
+
+	sysTables.Lock()
+	sysTables.tables = n.txn.tables
+	sysTables.Unlock()
+
+	sysChains.Range(func(k, v interface{}) bool {
+		if _, found := n.txn.chains[k]; !found {
+			sysChains.Delete(k)
+		}
+		return true
+	})
+	for k, v := range n.txn.chains {
+		sysChains.Store(k, v)
+	}
+
+	sysNamedSets.Lock()
+	sysNamedSets.sets = n.txn.sets
+	sysNamedSets.Unlock()
+
+	sysCounters.Lock()
+	sysCounters.counters = n.txn.counters
+	sysCounters.Unlock()
+
+	sysFlowtables.Lock()
+	sysFlowtables.flowtables = n.txn.flowtables
+	sysFlowtables.Unlock()
+
+	sysQuotas.Lock()
+	sysQuotas.quotas = n.txn.quotas
+	sysQuotas.Unlock()
+
+	sysSets = sysSets[:n.txn.anonSets]
+}
+
+func (n *Nft) inTransaction() bool {
+	n.txn.Lock()
+	defer n.txn.Unlock()
+	return n.txn.active
+}
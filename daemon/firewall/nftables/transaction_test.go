@@ -0,0 +1,159 @@
+package nftables
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// testNft returns a firewall whose batches are sent to the given function
+// instead of to the kernel.
+func testNft(batches *[][]netlink.Message, err error) *Nft {
+	initMapsStore()
+	n := &Nft{}
+	n.conn = &nftables.Conn{
+		TestDial: func(req []netlink.Message) ([]netlink.Message, error) {
+			// the acks are requested without messages.
+			if len(req) == 0 {
+				return nil, nil
+			}
+			*batches = append(*batches, req)
+			return nil, err
+		},
+	}
+	return n
+}
+
+// hasMsg checks if the batch has a message of the given type.
+func hasMsg(batch []netlink.Message, msgType int) bool {
+	for _, m := range batch {
+		if m.Header.Type == netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES<<8)|msgType) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCommitAll(t *testing.T) {
+	batches := [][]netlink.Message{}
+	n := testNft(&batches, nil)
+
+	if state := n.Commit(); state != CommitApplied {
+		t.Errorf("Commit() without transaction = %d, want %d", state, CommitApplied)
+	}
+
+	committed := false
+	n.Begin()
+	if _, err := n.AddTable("opensnitch", "inet"); err != nil {
+		t.Fatalf("AddTable() error: %s", err)
+	}
+	if _, err := n.AddTable("opensnitch", "ip6"); err != nil {
+		t.Fatalf("AddTable() error: %s", err)
+	}
+	if state := n.Commit(); state != CommitDeferred {
+		t.Errorf("Commit() in a transaction = %d, want %d", state, CommitDeferred)
+	}
+	n.afterCommit(func() { committed = true })
+	if len(batches) != 0 || committed {
+		t.Fatalf("changes applied before CommitAll(): %d batches", len(batches))
+	}
+
+	if err := n.CommitAll(); err != nil {
+		t.Fatalf("CommitAll() error: %s", err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(batches))
+	}
+	// begin, 2 tables, end.
+	if len(batches[0]) != 4 || !hasMsg(batches[0], unix.NFT_MSG_NEWTABLE) {
+		t.Errorf("unexpected batch: %+v", batches[0])
+	}
+	if !committed {
+		t.Error("afterCommit() callback not called")
+	}
+	if len(sysTables.List()) != 2 {
+		t.Errorf("unexpected tables: %v", sysTables.List())
+	}
+	if err := n.CommitAll(); err == nil {
+		t.Error("CommitAll() without transaction must fail")
+	}
+}
+
+func TestCommitAllRollback(t *testing.T) {
+	batches := [][]netlink.Message{}
+	n := testNft(&batches, unix.EINVAL)
+	sysTables.Add(getTableKey("filter", "inet"), &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet})
+
+	committed := false
+	n.Begin()
+	n.AddTable("opensnitch", "inet")
+	n.afterCommit(func() { committed = true })
+	if err := n.CommitAll(); err == nil {
+		t.Fatal("CommitAll() of a failed batch must fail")
+	}
+	if len(batches) != 1 {
+		t.Errorf("got %d batches, want 1", len(batches))
+	}
+	if committed {
+		t.Error("afterCommit() callback called on a failed batch")
+	}
+	if tables := sysTables.List(); len(tables) != 1 || tables[getTableKey("filter", "inet")] == nil {
+		t.Errorf("tables not restored: %v", tables)
+	}
+	if n.inTransaction() {
+		t.Error("transaction still active")
+	}
+}
+
+func TestQueueRollback(t *testing.T) {
+	batches := [][]netlink.Message{}
+	n := testNft(&batches, nil)
+
+	n.Begin()
+	n.AddTable("opensnitch", "inet")
+	if err := n.Queue(func() error { return errors.New("invalid rule") }); err == nil {
+		t.Error("Queue() didn't return the error of the change")
+	}
+	if err := n.CommitAll(); err == nil {
+		t.Fatal("CommitAll() with a failed change must fail")
+	}
+	if len(batches) != 0 {
+		t.Errorf("the batch has been sent: %+v", batches)
+	}
+	if len(sysTables.List()) != 0 {
+		t.Errorf("tables not restored: %v", sysTables.List())
+	}
+}
+
+func TestExclusiveReentrant(t *testing.T) {
+	n := &Nft{}
+	done := make(chan bool)
+	go func() {
+		n.exclusive(func() {
+			n.exclusive(func() {})
+		})
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nested exclusive() calls deadlocked")
+	}
+
+	// other goroutines must still wait.
+	order := make(chan int, 2)
+	n.exclusive(func() {
+		go n.exclusive(func() { order <- 2 })
+		time.Sleep(100 * time.Millisecond)
+		order <- 1
+	})
+	if first := <-order; first != 1 {
+		t.Error("exclusive() didn't serialize the goroutines")
+	}
+	<-order
+}
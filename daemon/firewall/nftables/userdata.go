@@ -0,0 +1,130 @@
+package nftables
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
+	"github.com/google/uuid"
+)
+
+// Every rule we add is tagged with its origin (interception or system rule),
+// and an UUID, stored in the UserData of the rule.
+// This way our rules can be identified even if other rules are added, deleted
+// or moved by other applications, without depending on the position of the rules.
+//
+// The data is encoded in TLV format (type, length, value), like nft does to
+// store the comments of the rules. nft only displays the comment of the
+// rules, and ignores the rest of attributes:
+// nft list ruleset
+//
+//	udp sport 53 counter name "opensnitch-counter-input-intercepted" queue num 0 bypass comment "opensnitch-key-interception"
+const (
+	// NFTNL_UDATA_RULE_COMMENT
+	udataRuleComment = 0
+	udataRuleOrigin  = 0x10
+	udataRuleUUID    = 0x11
+	// hash of the definition of a system rule (see getSystemRuleHash()).
+	udataRuleHash = 0x12
+
+	// the length of the attributes is stored in 1 byte.
+	udataMaxLen = 255
+)
+
+// ruleMeta holds the metadata of the rules added by us.
+type ruleMeta struct {
+	Origin string
+	UUID   string
+	Hash   string
+}
+
+// Marshal encodes the metadata of the rule.
+// The origin is also added as the comment of the rule.
+func (m *ruleMeta) Marshal() []byte {
+	data := []byte{}
+	for _, attr := range []struct {
+		typ   byte
+		value string
+	}{
+		{udataRuleComment, m.Origin + "\x00"},
+		{udataRuleOrigin, m.Origin},
+		{udataRuleUUID, m.UUID},
+		{udataRuleHash, m.Hash},
+	} {
+		if attr.value == "" || len(attr.value) > udataMaxLen {
+			continue
+		}
+		data = append(data, attr.typ, byte(len(attr.value)))
+		data = append(data, attr.value...)
+	}
+
+	return data
+}
+
+// parseRuleMeta decodes the metadata of a rule.
+// It returns nil if the rule has not been added by us.
+func parseRuleMeta(data []byte) *ruleMeta {
+	// rules added by previous versions, which only stored the key of the rule.
+	if bytes.HasPrefix(data, []byte(fwKey)) {
+		meta := &ruleMeta{Origin: interceptionRuleKey}
+		if bytes.HasPrefix(data, []byte(systemRuleKey)) {
+			meta.Origin = systemRuleKey
+		}
+		return meta
+	}
+
+	meta := &ruleMeta{}
+	for len(data) >= 2 {
+		typ, length := data[0], int(data[1])
+		if len(data) < 2+length {
+			return nil
+		}
+		value := string(data[2 : 2+length])
+		switch typ {
+		case udataRuleOrigin:
+			meta.Origin = value
+		case udataRuleUUID:
+			meta.UUID = value
+		case udataRuleHash:
+			meta.Hash = value
+		}
+		data = data[2+length:]
+	}
+	if meta.Origin != interceptionRuleKey && meta.Origin != systemRuleKey {
+		return nil
+	}
+
+	return meta
+}
+
+// isRuleOf checks if the rule has been added by us, with the given origin.
+func isRuleOf(data []byte, origin string) bool {
+	meta := parseRuleMeta(data)
+	return meta != nil && meta.Origin == origin
+}
+
+// newInterceptionRuleMeta returns the metadata of a new interception rule.
+func newInterceptionRuleMeta() *ruleMeta {
+	return &ruleMeta{Origin: interceptionRuleKey, UUID: uuid.New().String()}
+}
+
+// newSystemRuleMeta returns the metadata of a system rule.
+func newSystemRuleMeta(rule *config.FwRule) *ruleMeta {
+	return &ruleMeta{Origin: systemRuleKey, UUID: rule.UUID, Hash: getSystemRuleHash(rule)}
+}
+
+// getSystemRuleHash returns a hash of the definition of a system rule, to
+// know if a rule loaded in the kernel has changed.
+func getSystemRuleHash(rule *config.FwRule) string {
+	def, _ := json.Marshal(struct {
+		Expressions      []*config.Expressions
+		Target           string
+		TargetParameters string
+	}{rule.Expressions, rule.Target, rule.TargetParameters})
+	h := fnv.New64a()
+	h.Write(def)
+
+	return fmt.Sprintf("%x", h.Sum64())
+}
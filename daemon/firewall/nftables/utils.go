@@ -1,11 +1,24 @@
 package nftables
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// hooks not defined yet by the nftables lib, nor by the unix package.
+// Both were added after the last hook of their family, so their numbers are
+// the number of hooks defined by the headers the unix package was built from.
+const (
+	// NF_NETDEV_EGRESS, available since kernel 5.16.
+	chainHookEgress nftables.ChainHook = unix.NF_NETDEV_NUMHOOKS
+	// NF_INET_INGRESS, available since kernel 5.10.
+	nfInetIngress nftables.ChainHook = unix.NF_INET_NUMHOOKS
 )
 
 func getFamilyCode(family string) nftables.TableFamily {
@@ -32,9 +45,36 @@ func getFamilyCode(family string) nftables.TableFamily {
 	return famCode
 }
 
-func getHook(chain string) *nftables.ChainHook {
+// getFamilyName returns the name of the given family code.
+func getFamilyName(famCode nftables.TableFamily) string {
+	switch famCode {
+	case nftables.TableFamilyIPv6:
+		return exprs.NFT_FAMILY_IP6
+	case nftables.TableFamilyIPv4:
+		return exprs.NFT_FAMILY_IP
+	case nftables.TableFamilyBridge:
+		return exprs.NFT_FAMILY_BRIDGE
+	case nftables.TableFamilyARP:
+		return exprs.NFT_FAMILY_ARP
+	case nftables.TableFamilyNetdev:
+		return exprs.NFT_FAMILY_NETDEV
+	}
+
+	return exprs.NFT_FAMILY_INET
+}
+
+// getHook returns the hook number of the given hook name.
+// The arp family has its own hooks: NF_ARP_IN = 0, NF_ARP_OUT = 1.
+func getHook(family, chain string) *nftables.ChainHook {
 	hook := nftables.ChainHookOutput
 
+	if strings.ToLower(family) == exprs.NFT_FAMILY_ARP {
+		if strings.ToLower(chain) == exprs.NFT_HOOK_INPUT {
+			return nftables.ChainHookRef(0)
+		}
+		return nftables.ChainHookRef(1)
+	}
+
 	// https://github.com/google/nftables/blob/master/chain.go#L33
 	switch strings.ToLower(chain) {
 	case exprs.NFT_HOOK_INPUT:
@@ -47,8 +87,54 @@ func getHook(chain string) *nftables.ChainHook {
 		hook = nftables.ChainHookForward
 	case exprs.NFT_HOOK_INGRESS:
 		hook = nftables.ChainHookIngress
+	case exprs.NFT_HOOK_EGRESS:
+		hook = nftables.ChainHookRef(chainHookEgress)
+	}
+
+	return hook
+}
+
+// getHookName returns the name of the given hook.
+// The hook numbers of the netdev and arp families are different than the rest
+// of families (NF_NETDEV_INGRESS = 0, NF_NETDEV_EGRESS = 1, NF_ARP_IN = 0, NF_ARP_OUT = 1).
+func getHookName(family nftables.TableFamily, hook nftables.ChainHook) string {
+	switch family {
+	case nftables.TableFamilyNetdev:
+		if hook == chainHookEgress {
+			return exprs.NFT_HOOK_EGRESS
+		}
+		return exprs.NFT_HOOK_INGRESS
+	case nftables.TableFamilyARP:
+		if hook == 1 {
+			return exprs.NFT_HOOK_OUTPUT
+		}
+		return exprs.NFT_HOOK_INPUT
+	}
+
+	switch hook {
+	case *nftables.ChainHookPrerouting:
+		return exprs.NFT_HOOK_PREROUTING
+	case *nftables.ChainHookInput:
+		return exprs.NFT_HOOK_INPUT
+	case *nftables.ChainHookForward:
+		return exprs.NFT_HOOK_FORWARD
+	case *nftables.ChainHookOutput:
+		return exprs.NFT_HOOK_OUTPUT
+	case *nftables.ChainHookPostrouting:
+		return exprs.NFT_HOOK_POSTROUTING
+	case nfInetIngress:
+		return exprs.NFT_HOOK_INGRESS
 	}
 
+	return ""
+}
+
+// getKernelHook returns the hook number used by the kernel for the given hook.
+// The inet family has its own ingress hook (kernel >= 5.10).
+func getKernelHook(family nftables.TableFamily, hook nftables.ChainHook) nftables.ChainHook {
+	if family == nftables.TableFamilyINet && hook == *nftables.ChainHookIngress {
+		return nfInetIngress
+	}
 	return hook
 }
 
@@ -69,14 +155,18 @@ func getChainPriority(family, cType, hook string) (*nftables.ChainPriority, nfta
 
 	// constraints
 	// https://www.netfilter.org/projects/nftables/manpage.html#lbAQ
-	if (cType == exprs.NFT_CHAIN_NATDEST || cType == exprs.NFT_CHAIN_NATSOURCE) && hook == exprs.NFT_HOOK_FORWARD {
+	if (cType == exprs.NFT_CHAIN_NATDEST || cType == exprs.NFT_CHAIN_NATSOURCE || cType == exprs.NFT_CHAIN_NAT) && hook == exprs.NFT_HOOK_FORWARD {
 		log.Warning("[nftables] invalid nat combination of tables and hooks. chain: %s, hook: %s", cType, hook)
 		return nil, chainType
 	}
-	if family == exprs.NFT_FAMILY_NETDEV && (cType != exprs.NFT_CHAIN_FILTER || hook != exprs.NFT_HOOK_INGRESS) {
+	if family == exprs.NFT_FAMILY_NETDEV && (cType != exprs.NFT_CHAIN_FILTER || (hook != exprs.NFT_HOOK_INGRESS && hook != exprs.NFT_HOOK_EGRESS)) {
 		log.Warning("[nftables] invalid netdev combination of tables and hooks. chain: %s, hook: %s", cType, hook)
 		return nil, chainType
 	}
+	if family != exprs.NFT_FAMILY_NETDEV && hook == exprs.NFT_HOOK_EGRESS {
+		log.Warning("[nftables] invalid egress combination of families and hooks. family: %s, hook: %s", family, hook)
+		return nil, chainType
+	}
 	if family == exprs.NFT_FAMILY_ARP && (cType != exprs.NFT_CHAIN_FILTER || (hook != exprs.NFT_HOOK_OUTPUT && hook != exprs.NFT_HOOK_INPUT)) {
 		log.Warning("[nftables] invalid arp combination of tables and hooks. chain: %s, hook: %s", cType, hook)
 		return nil, chainType
@@ -135,6 +225,14 @@ func getChainPriority(family, cType, hook string) (*nftables.ChainPriority, nfta
 		chainPrio = nftables.ChainPriorityNATSource
 		chainType = nftables.ChainTypeNAT
 
+	case exprs.NFT_CHAIN_NAT:
+		// hooks: prerouting, output (dnat), postrouting, input (snat)
+		chainPrio = nftables.ChainPriorityNATDest
+		if hook == exprs.NFT_HOOK_POSTROUTING || hook == exprs.NFT_HOOK_INPUT {
+			chainPrio = nftables.ChainPriorityNATSource
+		}
+		chainType = nftables.ChainTypeNAT
+
 	case exprs.NFT_CHAIN_SECURITY:
 		// hook: all
 		chainPrio = nftables.ChainPrioritySecurity
@@ -171,3 +269,77 @@ func getConntrackPriority(hook string) (*nftables.ChainPriority, nftables.ChainT
 
 	return chainPrio, chainType
 }
+
+// parseChainPriority parses the priority of a chain configured by the user.
+// It can be a number, or one of the standard priority names, optionally
+// followed by an offset: "filter", "mangle - 5", "dstnat+10", "-150".
+// https://www.netfilter.org/projects/nftables/manpage.html#lbAQ (table 6.)
+func parseChainPriority(family, prio string) (*nftables.ChainPriority, error) {
+	prio = strings.ToLower(strings.ReplaceAll(prio, " ", ""))
+	if num, err := strconv.ParseInt(prio, 10, 32); err == nil {
+		return nftables.ChainPriorityRef(nftables.ChainPriority(num)), nil
+	}
+
+	name := prio
+	offset := int64(0)
+	if idx := strings.IndexAny(prio, "+-"); idx > 0 {
+		name = prio[:idx]
+		off, err := strconv.ParseInt(prio[idx:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chain priority offset: %s", prio)
+		}
+		offset = off
+	}
+
+	var base *nftables.ChainPriority
+	switch strings.ToLower(family) {
+	case exprs.NFT_FAMILY_ARP:
+		// arp	input, output	filter	0	NF_IP_PRI_FILTER
+		if name == exprs.NFT_CHAIN_FILTER {
+			base = nftables.ChainPriorityFilter
+		}
+	case exprs.NFT_FAMILY_BRIDGE:
+		switch name {
+		case exprs.NFT_PRIO_DSTNAT, exprs.NFT_CHAIN_NATDEST:
+			base = nftables.ChainPriorityRaw
+		case exprs.NFT_CHAIN_FILTER:
+			base = nftables.ChainPriorityConntrack
+		case exprs.NFT_PRIO_OUT:
+			base = nftables.ChainPriorityNATSource
+		case exprs.NFT_PRIO_SRCNAT, exprs.NFT_CHAIN_NATSOURCE:
+			base = nftables.ChainPriorityConntrackHelper
+		}
+	default:
+		switch name {
+		case exprs.NFT_CHAIN_RAW:
+			base = nftables.ChainPriorityRaw
+		case exprs.NFT_CHAIN_MANGLE:
+			base = nftables.ChainPriorityMangle
+		case exprs.NFT_PRIO_DSTNAT, exprs.NFT_CHAIN_NATDEST:
+			base = nftables.ChainPriorityNATDest
+		case exprs.NFT_CHAIN_FILTER:
+			base = nftables.ChainPriorityFilter
+		case exprs.NFT_CHAIN_SECURITY:
+			base = nftables.ChainPrioritySecurity
+		case exprs.NFT_PRIO_SRCNAT, exprs.NFT_CHAIN_NATSOURCE:
+			base = nftables.ChainPriorityNATSource
+		}
+	}
+	if base == nil {
+		return nil, fmt.Errorf("invalid chain priority for family %s: %s", family, prio)
+	}
+
+	return nftables.ChainPriorityRef(*base + nftables.ChainPriority(offset)), nil
+}
+
+// getChainPolicy returns the policy of a chain configured by the user.
+// An empty policy defaults to accept.
+func getChainPolicy(policy string) (nftables.ChainPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", exprs.VERDICT_ACCEPT:
+		return nftables.ChainPolicyAccept, nil
+	case exprs.VERDICT_DROP:
+		return nftables.ChainPolicyDrop, nil
+	}
+	return nftables.ChainPolicyAccept, fmt.Errorf("invalid chain policy: %s", policy)
+}
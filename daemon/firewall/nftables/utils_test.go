@@ -0,0 +1,49 @@
+package nftables
+
+import (
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables/exprs"
+	"github.com/google/nftables"
+)
+
+func TestParseChainPriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  string
+		prio    string
+		want    nftables.ChainPriority
+		wantErr bool
+	}{
+		{"number", exprs.NFT_FAMILY_INET, "-150", -150, false},
+		{"name", exprs.NFT_FAMILY_INET, "filter", *nftables.ChainPriorityFilter, false},
+		{"name with offset", exprs.NFT_FAMILY_IP, "mangle - 5", *nftables.ChainPriorityMangle - 5, false},
+		{"name with positive offset", exprs.NFT_FAMILY_IP6, "dstnat+10", *nftables.ChainPriorityNATDest + 10, false},
+		{"upper case", exprs.NFT_FAMILY_INET, "SRCNAT", *nftables.ChainPriorityNATSource, false},
+		{"bridge family", exprs.NFT_FAMILY_BRIDGE, "out", *nftables.ChainPriorityNATSource, false},
+		{"bridge filter", exprs.NFT_FAMILY_BRIDGE, "filter", *nftables.ChainPriorityConntrack, false},
+		{"arp filter", exprs.NFT_FAMILY_ARP, "filter", *nftables.ChainPriorityFilter, false},
+		{"invalid name", exprs.NFT_FAMILY_INET, "foo", 0, true},
+		{"invalid offset", exprs.NFT_FAMILY_INET, "filter+a", 0, true},
+		{"name not valid for the family", exprs.NFT_FAMILY_ARP, "mangle", 0, true},
+		{"bridge only name", exprs.NFT_FAMILY_INET, "out", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseChainPriority(test.family, test.prio)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %d", *got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != test.want {
+				t.Errorf("got %d, want %d", *got, test.want)
+			}
+		})
+	}
+}
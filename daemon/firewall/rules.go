@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/evilsocket/opensnitch/daemon/firewall/common"
+	"github.com/evilsocket/opensnitch/daemon/firewall/config"
 	"github.com/evilsocket/opensnitch/daemon/firewall/iptables"
 	"github.com/evilsocket/opensnitch/daemon/firewall/nftables"
 	"github.com/evilsocket/opensnitch/daemon/log"
@@ -12,11 +13,12 @@ import (
 
 // Firewall is the interface that all firewalls (iptables, nftables) must implement.
 type Firewall interface {
-	Init(*int)
+	Init(*int) error
 	Stop()
 	Name() string
 	IsRunning() bool
 	SetQueueNum(num *int)
+	SetInterfaces(ifaces, skipIfaces []string)
 
 	SaveConfiguration(rawConfig string) error
 
@@ -31,18 +33,30 @@ type Firewall interface {
 
 	Serialize() (*protocol.SysFirewall, error)
 	Deserialize(sysfw *protocol.SysFirewall) ([]byte, error)
+
+	GetCounters() []*protocol.FirewallCounter
+	GetRulesCounters() []*protocol.FirewallCounter
+	GetFirewallRules() (*protocol.SysFirewall, error)
 }
 
 var (
 	fw       Firewall
 	queueNum = 0
+	// network interfaces where connections are intercepted
+	interfaces     []string
+	skipInterfaces []string
 )
 
 // Init initializes the firewall and loads firewall rules.
-// We'll try to use the firewall configured in the configuration (iptables/nftables).
+// We'll try to use the firewall configured in the configuration (iptables/nftables),
+// unless the system is using the other one and mixing them is not allowed (see backend.go).
 // If iptables is not installed, we can add nftables rules directly to the kernel,
 // without relying on any binaries.
 func Init(fwType string, qNum *int) (err error) {
+	if sysFw := checkCoexistence(fwType); sysFw != fwType {
+		log.Warning("The system is using %s, using it instead of %s to not mix backends", sysFw, fwType)
+		fwType = sysFw
+	}
 	if fwType == iptables.Name {
 		fw, err = iptables.Fw()
 		if err != nil {
@@ -65,7 +79,10 @@ func Init(fwType string, qNum *int) (err error) {
 		return fmt.Errorf("Firewall not initialized")
 	}
 	fw.Stop()
-	fw.Init(qNum)
+	fw.SetInterfaces(interfaces, skipInterfaces)
+	if err = fw.Init(qNum); err != nil {
+		return err
+	}
 	queueNum = *qNum
 
 	log.Info("Using %s firewall", fw.Name())
@@ -87,16 +104,67 @@ func CleanRules(logErrors bool) {
 }
 
 // ChangeFw stops current firewall and initializes a new one.
+// If the new one can't be initialized, the previous one is restored.
+// In strict coexistence mode, changing to a firewall that would mix backends
+// with the one used by the system is refused.
 func ChangeFw(fwtype string) (err error) {
+	if sysFw := checkCoexistence(fwtype); sysFw != fwtype {
+		return fmt.Errorf("the system is using %s, %s can't be used in %s coexistence mode", sysFw, fwtype, CoexistStrict)
+	}
+	prevFw := fw
 	Stop()
-	err = Init(fwtype, &queueNum)
+	if err = Init(fwtype, &queueNum); err == nil || prevFw == nil {
+		return
+	}
+
+	log.Warning("Error changing firewall to %s, restoring %s: %s", fwtype, prevFw.Name(), err)
+	if fw != nil && fw != prevFw {
+		fw.Stop()
+	}
+	fw = prevFw
+	if errInit := fw.Init(&queueNum); errInit != nil {
+		return fmt.Errorf("%s, error restoring %s: %s", err, fw.Name(), errInit)
+	}
 	return
 }
 
+// SetInterfaces configures the network interfaces where connections are intercepted.
+// If the firewall is running and the interfaces have changed, the interception
+// rules are reloaded.
+func SetInterfaces(ifaces, skipIfaces []string) {
+	if equalIfaces(ifaces, interfaces) && equalIfaces(skipIfaces, skipInterfaces) {
+		return
+	}
+	interfaces = ifaces
+	skipInterfaces = skipIfaces
+	if !IsRunning() {
+		return
+	}
+
+	log.Info("Intercepting connections on interfaces: %v, skipping: %v", ifaces, skipIfaces)
+	fw.SetInterfaces(interfaces, skipInterfaces)
+	fw.DisableInterception(true)
+	fw.EnableInterception()
+}
+
+func equalIfaces(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Reload deletes existing firewall rules and readds them.
 func Reload() {
 	fw.Stop()
-	fw.Init(&queueNum)
+	if err := fw.Init(&queueNum); err != nil {
+		log.Error("%s", err)
+	}
 }
 
 // ReloadSystemRules deletes existing rules, and add them again
@@ -145,3 +213,45 @@ func Serialize() (*protocol.SysFirewall, error) {
 func Deserialize(sysfw *protocol.SysFirewall) ([]byte, error) {
 	return fw.Deserialize(sysfw)
 }
+
+// GetCounters returns the packets and bytes counted on the chains we created.
+func GetCounters() []*protocol.FirewallCounter {
+	if fw == nil {
+		return nil
+	}
+	return fw.GetCounters()
+}
+
+// GetRulesCounters returns the packets and bytes matched by every rule we added.
+func GetRulesCounters() []*protocol.FirewallCounter {
+	if fw == nil {
+		return nil
+	}
+	return fw.GetRulesCounters()
+}
+
+// GetFirewallRules returns the tables, chains and rules we manage, as they're
+// loaded in the system.
+func GetFirewallRules() (*protocol.SysFirewall, error) {
+	if fw == nil {
+		return nil, fmt.Errorf("firewall not initialized")
+	}
+	return fw.GetFirewallRules()
+}
+
+// CheckConfiguration parses and compiles the given system firewall
+// configuration, without applying it, and returns the errors found.
+func CheckConfiguration(rawConfig []byte) []error {
+	cfg, err := config.ParseConfiguration(rawConfig)
+	if err != nil {
+		return []error{err}
+	}
+	cfgErrs := nftables.CheckSystemRules(cfg)
+	config.SetErrorsLines(rawConfig, cfgErrs)
+
+	errs := []error{}
+	for _, e := range cfgErrs {
+		errs = append(errs, e)
+	}
+	return errs
+}
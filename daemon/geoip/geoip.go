@@ -0,0 +1,175 @@
+package geoip
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// DefaultPaths are the usual locations of the GeoLite2 databases, used when no
+// database has been configured.
+var DefaultPaths = []string{
+	"/usr/share/GeoIP/GeoLite2-Country.mmdb",
+	"/var/lib/GeoIP/GeoLite2-Country.mmdb",
+	"/usr/share/GeoIP/GeoLite2-City.mmdb",
+	"/var/lib/GeoIP/GeoLite2-City.mmdb",
+}
+
+// DefaultASNPaths are the usual locations of the GeoLite2 ASN databases.
+var DefaultASNPaths = []string{
+	"/usr/share/GeoIP/GeoLite2-ASN.mmdb",
+	"/var/lib/GeoIP/GeoLite2-ASN.mmdb",
+}
+
+// database is a MaxMind database that is reloaded when the file changes.
+type database struct {
+	sync.RWMutex
+	name         string
+	defaultPaths []string
+	reader       *Reader
+	path         string
+	mod          time.Time
+	// the database is loaded from defaultPaths on the first lookup, if it hasn't been configured.
+	initialized bool
+
+	// results of the IPs looked up, to not search the database on every connection.
+	cache          map[string]interface{}
+	monitorRunning bool
+}
+
+var (
+	countryDB = &database{name: "GeoIP", defaultPaths: DefaultPaths}
+	asnDB     = &database{name: "ASN", defaultPaths: DefaultASNPaths}
+
+	maxCacheSize = 8192
+
+	// interval to check if the database files have been updated.
+	monitorInterval = 30 * time.Second
+)
+
+// SetDatabase loads the given country database, and reloads it when it changes.
+// If path is empty, the first database found in DefaultPaths is used.
+func SetDatabase(path string) {
+	countryDB.set(path)
+}
+
+// SetASNDatabase loads the given ASN database, and reloads it when it changes.
+// If path is empty, the first database found in DefaultASNPaths is used.
+func SetASNDatabase(path string) {
+	asnDB.set(path)
+}
+
+// Country returns the ISO code of the country of the given IP (ES, US, ...),
+// or "" if the IP is not in the database, or there's no database.
+func Country(ip net.IP) string {
+	country, _ := countryDB.lookup(ip, func(r *Reader, ip net.IP) interface{} {
+		return r.Country(ip)
+	}).(string)
+	return country
+}
+
+// ASN returns the number of the autonomous system of the given IP, or 0 if the
+// IP is not in the database, or there's no database.
+func ASN(ip net.IP) uint {
+	asn, _ := asnDB.lookup(ip, func(r *Reader, ip net.IP) interface{} {
+		return r.ASN(ip)
+	}).(uint)
+	return asn
+}
+
+func (db *database) set(path string) {
+	if path == "" {
+		for _, p := range db.defaultPaths {
+			if core.Exists(p) {
+				path = p
+				break
+			}
+		}
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	db.initialized = true
+	db.path = path
+	db.reader = nil
+	db.mod = time.Time{}
+	if path == "" {
+		log.Debug("%s database not found", db.name)
+		return
+	}
+	db.load()
+	if !db.monitorRunning {
+		db.monitorRunning = true
+		go db.monitor()
+	}
+}
+
+// load loads the database if it has changed. It must be called with the lock held.
+func (db *database) load() {
+	modTime, err := core.GetFileModTime(db.path)
+	if err != nil {
+		if db.reader != nil {
+			log.Warning("%s database not available: %s", db.name, err)
+		}
+		db.reader = nil
+		return
+	}
+	if modTime.Equal(db.mod) {
+		return
+	}
+	r, err := Open(db.path)
+	if err != nil {
+		log.Warning("Error loading %s database %s: %s", db.name, db.path, err)
+		return
+	}
+	db.reader = r
+	db.mod = modTime
+	db.cache = make(map[string]interface{})
+	log.Info("%s database loaded: %s (%s)", db.name, db.path, r.Type)
+}
+
+func (db *database) monitor() {
+	for {
+		time.Sleep(monitorInterval)
+		db.Lock()
+		if db.path != "" {
+			db.load()
+		}
+		db.Unlock()
+	}
+}
+
+// lookup returns the cached result of the IP, or searches it in the database.
+func (db *database) lookup(ip net.IP, search func(*Reader, net.IP) interface{}) interface{} {
+	db.RLock()
+	if !db.initialized {
+		db.RUnlock()
+		db.set("")
+		db.RLock()
+	}
+	key := ip.String()
+	if db.reader == nil {
+		db.RUnlock()
+		return nil
+	}
+	result, found := db.cache[key]
+	r := db.reader
+	db.RUnlock()
+	if found {
+		return result
+	}
+
+	result = search(r, ip)
+
+	db.Lock()
+	if len(db.cache) >= maxCacheSize {
+		db.cache = make(map[string]interface{})
+	}
+	db.cache[key] = result
+	db.Unlock()
+
+	return result
+}
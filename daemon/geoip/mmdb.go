@@ -0,0 +1,355 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// Reader of MaxMind DB files (GeoLite2-Country.mmdb, GeoLite2-City.mmdb, ...)
+// https://maxmind.github.io/MaxMind-DB/
+//
+// The file is compounded of a binary search tree of the bits of the IP
+// addresses, a data section with the records of the networks, and the metadata
+// of the database at the end of the file.
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// types of the fields of the data section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// Reader holds a MaxMind database loaded in memory.
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// node where the IPv4 addresses start, in IPv6 databases (::/96).
+	ipv4Start uint
+	// Type of the database: GeoLite2-Country, GeoIP2-City, etc.
+	Type string
+}
+
+// Open loads a MaxMind database.
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(buf)
+}
+
+// NewReader parses a MaxMind database.
+func NewReader(buf []byte) (*Reader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx == -1 {
+		return nil, fmt.Errorf("invalid MaxMind database, metadata not found")
+	}
+	metaStart := idx + len(metadataMarker)
+	meta, _, err := decode(buf[metaStart:], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind database metadata: %s", err)
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid MaxMind database metadata")
+	}
+
+	r := &Reader{buf: buf}
+	r.nodeCount = uint(toUint(metadata["node_count"]))
+	r.recordSize = uint(toUint(metadata["record_size"]))
+	r.ipVersion = uint(toUint(metadata["ip_version"]))
+	r.Type, _ = metadata["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("invalid MaxMind database record size: %d", r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	// the data section starts after the tree, and 16 bytes of zeros.
+	if treeSize+16 > uint(idx) {
+		return nil, fmt.Errorf("invalid MaxMind database tree size: %d", treeSize)
+	}
+	r.data = buf[treeSize+16 : idx]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// readNode returns the left (0) or right (1) record of a node of the tree.
+func (r *Reader) readNode(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node * 6
+		if bit == 1 {
+			off += 3
+		}
+		b := r.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node * 8
+		if bit == 1 {
+			off += 4
+		}
+		return uint(binary.BigEndian.Uint32(r.buf[off : off+4]))
+	}
+}
+
+// Lookup returns the record of the network of the given IP, or nil if the IP
+// is not in the database.
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	addr := ip.To4()
+	if addr == nil {
+		if r.ipVersion == 4 {
+			return nil, fmt.Errorf("IPv6 address in an IPv4 database: %s", ip)
+		}
+		addr = ip.To16()
+	} else if r.ipVersion == 6 {
+		node = r.ipv4Start
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("invalid IP: %s", ip)
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, fmt.Errorf("invalid MaxMind database tree")
+	}
+	offset := node - r.nodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("invalid MaxMind database record offset: %d", offset)
+	}
+	record, _, err := decode(r.data, offset)
+	return record, err
+}
+
+// Country returns the ISO code of the country of an IP, or "" if it's unknown.
+func (r *Reader) Country(ip net.IP) string {
+	record, err := r.Lookup(ip)
+	if err != nil || record == nil {
+		return ""
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if iso, found := lookupPath(record, key, "iso_code").(string); found {
+			return iso
+		}
+	}
+	return ""
+}
+
+// ASN returns the number of the autonomous system of an IP, or 0 if it's unknown.
+func (r *Reader) ASN(ip net.IP) uint {
+	record, err := r.Lookup(ip)
+	if err != nil || record == nil {
+		return 0
+	}
+	return uint(toUint(lookupPath(record, "autonomous_system_number")))
+}
+
+// lookupPath returns the value of the given keys of nested maps.
+func lookupPath(record interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
+
+func toUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
+
+// decode decodes the field of the data section at the given offset, and
+// returns it with the offset of the next field.
+func decode(data []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, fmt.Errorf("unexpected end of data")
+	}
+	ctrl := data[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+
+	if typeNum == typePointer {
+		pointer, next, err := decodePointer(data, ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decode(data, pointer)
+		return value, next, err
+	}
+	if typeNum == typeExtended {
+		if offset >= uint(len(data)) {
+			return nil, 0, fmt.Errorf("unexpected end of data")
+		}
+		typeNum = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(data)) {
+			return nil, 0, fmt.Errorf("unexpected end of data")
+		}
+		n := uint(0)
+		for _, b := range data[offset : offset+extra] {
+			n = n<<8 | uint(b)
+		}
+		offset += extra
+		switch size {
+		case 29:
+			size = 29 + n
+		case 30:
+			size = 285 + n
+		default:
+			size = 65821 + n
+		}
+	}
+
+	switch typeNum {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("invalid map key: %v", key)
+			}
+			value, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeEndMarker, typeContainer:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, fmt.Errorf("unexpected end of data")
+	}
+	field := data[offset : offset+size]
+	offset += size
+
+	switch typeNum {
+	case typeString:
+		return string(field), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte{}, field...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size: %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(field)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size: %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(field)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		n := uint64(0)
+		for _, b := range field {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case typeInt32:
+		n := uint32(0)
+		for _, b := range field {
+			n = n<<8 | uint32(b)
+		}
+		return int32(n), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type: %d", typeNum)
+}
+
+// decodePointer returns the offset a pointer points to, and the offset of the
+// next field.
+func decodePointer(data []byte, ctrl byte, offset uint) (uint, uint, error) {
+	size := uint((ctrl>>3)&0x3) + 1
+	if offset+size > uint(len(data)) {
+		return 0, 0, fmt.Errorf("unexpected end of data")
+	}
+	b := data[offset : offset+size]
+	pointer := uint(0)
+	if size != 4 {
+		pointer = uint(ctrl & 0x7)
+	}
+	for _, v := range b {
+		pointer = pointer<<8 | uint(v)
+	}
+	switch size {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, offset + size, nil
+}
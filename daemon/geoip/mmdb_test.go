@@ -0,0 +1,188 @@
+package geoip
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDB builds a MaxMind database with the given networks and countries.
+// The records of the nodes are -1 if empty, the next node if >= 0,
+// or the offset of the data (-2 - offset).
+type testDB struct {
+	nodes [][2]int
+	data  []byte
+}
+
+func encString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{2<<5 | 29, byte(len(s) - 29)}, []byte(s)...)
+	}
+	return append([]byte{byte(2<<5 | len(s))}, []byte(s)...)
+}
+
+func encUint32(n uint32) []byte {
+	return []byte{6<<5 | 4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+func encPointer(offset int) []byte {
+	return []byte{byte(1<<5 | (offset>>8)&0x7), byte(offset)}
+}
+
+func encMap(pairs ...[]byte) []byte {
+	b := []byte{byte(7<<5 | len(pairs)/2)}
+	for _, p := range pairs {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func (db *testDB) add(network string, record []byte) {
+	_, n, _ := net.ParseCIDR(network)
+	ones, bits := n.Mask.Size()
+	ip := n.IP.To16()
+	if bits == 32 {
+		ip = n.IP.To4()
+	}
+	if len(db.nodes) == 0 {
+		db.nodes = append(db.nodes, [2]int{-1, -1})
+	}
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+		if i == ones-1 {
+			db.nodes[node][bit] = -2 - len(db.data)
+			break
+		}
+		if db.nodes[node][bit] < 0 {
+			db.nodes = append(db.nodes, [2]int{-1, -1})
+			db.nodes[node][bit] = len(db.nodes) - 1
+		}
+		node = db.nodes[node][bit]
+	}
+	db.data = append(db.data, record...)
+}
+
+func (db *testDB) build(ipVersion uint32) []byte {
+	count := len(db.nodes)
+	buf := []byte{}
+	for _, node := range db.nodes {
+		for _, rec := range node {
+			v := rec
+			if rec == -1 {
+				v = count
+			} else if rec <= -2 {
+				v = count + 16 + (-2 - rec)
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, db.data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encMap(
+		encString("node_count"), encUint32(uint32(count)),
+		encString("record_size"), encUint32(24),
+		encString("ip_version"), encUint32(ipVersion),
+		encString("database_type"), encString("Test-Country"),
+	)...)
+	return buf
+}
+
+func newTestDB(ipVersion uint32) []byte {
+	db := &testDB{}
+	prefix, bits := "", 0
+	if ipVersion == 6 {
+		// IPv4 addresses are stored in ::/96
+		prefix, bits = "::", 96
+	}
+	db.add(fmt.Sprintf("%s1.0.0.0/%d", prefix, bits+8), encMap(encString("country"), encMap(encString("iso_code"), encString("AU"))))
+	// the key and the value of the country are pointers to the ones of the first record.
+	db.add(fmt.Sprintf("%s8.8.8.0/%d", prefix, bits+24), encMap(encString("registered_country"), encMap(encPointer(10), encPointer(19))))
+	if ipVersion == 6 {
+		db.add("2001:db8::/32", encMap(encString("country"), encMap(encString("iso_code"), encString("ES"))))
+	}
+	return db.build(ipVersion)
+}
+
+func TestReader(t *testing.T) {
+	for _, ipVersion := range []uint32{4, 6} {
+		r, err := NewReader(newTestDB(ipVersion))
+		if err != nil {
+			t.Fatal("NewReader() error:", ipVersion, err)
+		}
+		if r.Type != "Test-Country" {
+			t.Error("Invalid database type:", r.Type)
+		}
+		countries := map[string]string{
+			"1.1.1.1":   "AU",
+			"1.255.0.1": "AU",
+			"8.8.8.8":   "AU",
+			"8.8.9.8":   "",
+			"2.2.2.2":   "",
+		}
+		if ipVersion == 6 {
+			countries["2001:db8::1"] = "ES"
+			countries["2001:db9::1"] = ""
+		}
+		for ip, country := range countries {
+			if c := r.Country(net.ParseIP(ip)); c != country {
+				t.Error("Invalid country:", ipVersion, ip, c, country)
+			}
+		}
+	}
+	if _, err := NewReader([]byte("not a database")); err == nil {
+		t.Error("NewReader() loaded an invalid database")
+	}
+}
+
+func TestCountry(t *testing.T) {
+	monitorInterval = 100 * time.Millisecond
+	dbFile, err := ioutil.TempFile("", "geoip_test_*.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dbFile.Name())
+	dbFile.Write(newTestDB(4))
+	dbFile.Close()
+
+	SetDatabase(dbFile.Name())
+	if c := Country(net.ParseIP("1.1.1.1")); c != "AU" {
+		t.Error("Invalid country:", c)
+	}
+	if c := Country(net.ParseIP("2001:db8::1")); c != "" {
+		t.Error("Invalid country of an IPv6 address:", c)
+	}
+
+	// the database is reloaded when it changes.
+	next := time.Now().Add(time.Second)
+	ioutil.WriteFile(dbFile.Name(), newTestDB(6), 0600)
+	os.Chtimes(dbFile.Name(), next, next)
+	time.Sleep(500 * time.Millisecond)
+	if c := Country(net.ParseIP("2001:db8::1")); c != "ES" {
+		t.Error("Database not reloaded:", c)
+	}
+}
+
+func TestASN(t *testing.T) {
+	db := &testDB{}
+	db.add("1.1.1.0/24", encMap(encString("autonomous_system_number"), encUint32(13335), encString("autonomous_system_organization"), encString("CLOUDFLARENET")))
+	dbFile, err := ioutil.TempFile("", "geoip_test_asn_*.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dbFile.Name())
+	dbFile.Write(db.build(4))
+	dbFile.Close()
+
+	SetASNDatabase(dbFile.Name())
+	if asn := ASN(net.ParseIP("1.1.1.1")); asn != 13335 {
+		t.Error("Invalid ASN:", asn)
+	}
+	if asn := ASN(net.ParseIP("1.1.2.1")); asn != 0 {
+		t.Error("Invalid ASN of an unknown IP:", asn)
+	}
+}
@@ -9,10 +9,11 @@ require (
 	github.com/google/nftables v0.1.0
 	github.com/google/uuid v1.3.0
 	github.com/iovisor/gobpf v0.2.0
+	github.com/mdlayher/netlink v1.4.2
 	github.com/varlink/go v0.4.0
 	github.com/vishvananda/netlink v0.0.0-20210811191823-e1a867c6b452
 	golang.org/x/net v0.0.0-20211209124913-491a49abca63
 	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d
 	google.golang.org/grpc v1.32.0
-	google.golang.org/protobuf v1.26.0 // indirect
+	google.golang.org/protobuf v1.26.0
 )
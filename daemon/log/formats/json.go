@@ -3,6 +3,7 @@ package formats
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
 )
@@ -19,6 +20,7 @@ const (
 // JSONEventFormat object to be sent to the remote service.
 // TODO: Expand as needed: ebpf events, etc.
 type JSONEventFormat struct {
+	Time   string      `json:"Time"`
 	Rule   string      `json:"Rule"`
 	Action string      `json:"Action"`
 	Event  interface{} `json:"Event"`
@@ -34,7 +36,9 @@ func NewJSON() *JSONEventFormat {
 // Transform takes input arguments and formats them to JSON format.
 func (j *JSONEventFormat) Transform(args ...interface{}) (out string) {
 	p := args[0]
-	jObj := &JSONEventFormat{}
+	jObj := &JSONEventFormat{
+		Time: time.Now().Format(time.RFC3339Nano),
+	}
 
 	values := p.([]interface{})
 	for n, val := range values {
@@ -64,6 +68,7 @@ func (j *JSONEventFormat) Transform(args ...interface{}) (out string) {
 	if err != nil {
 		return
 	}
-	out = fmt.Sprint(string(rawCfg), "\n\n")
+	// one event per line
+	out = fmt.Sprint(string(rawCfg), "\n")
 	return
 }
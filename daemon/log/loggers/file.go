@@ -0,0 +1,172 @@
+package loggers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/log/formats"
+)
+
+const (
+	LOGGER_FILE = "file"
+	// rotate the file when it reaches this size, in MB
+	defaultMaxSize = 100
+	// rotated files to keep: events.json.1, events.json.2, ...
+	defaultMaxFiles = 5
+)
+
+// File defines the logger that writes events to a file, one per line, rotating
+// it when it reaches the configured size.
+// It supports writing events in JSON (by default), CSV and RFC5424 formats.
+type File struct {
+	Name     string
+	Tag      string
+	Hostname string
+
+	logFormat formats.LoggerFormat
+	cfg       *LoggerConfig
+	file      *os.File
+	size      int64
+	maxSize   int64
+	maxFiles  int
+
+	mu *sync.Mutex
+}
+
+// NewFile returns a new object that writes outbound connections to a file,
+// with the given format (JSON by default)
+func NewFile(cfg *LoggerConfig) (*File, error) {
+	var err error
+	log.Info("NewFile logger: %v", cfg)
+
+	f := &File{
+		Name: LOGGER_FILE,
+		cfg:  cfg,
+		mu:   &sync.Mutex{},
+	}
+
+	f.logFormat = formats.NewJSON()
+	if cfg.Format == formats.CSV {
+		f.logFormat = formats.NewCSV()
+	} else if cfg.Format == formats.RFC5424 {
+		f.logFormat = formats.NewRfc5424()
+	}
+
+	f.Tag = logTag
+	if cfg.Tag != "" {
+		f.Tag = cfg.Tag
+	}
+	f.Hostname, err = os.Hostname()
+	if err != nil {
+		f.Hostname = "localhost"
+	}
+	f.maxSize = defaultMaxSize
+	if cfg.MaxSize > 0 {
+		f.maxSize = int64(cfg.MaxSize)
+	}
+	f.maxSize *= 1024 * 1024
+	f.maxFiles = defaultMaxFiles
+	if cfg.MaxFiles > 0 {
+		f.maxFiles = cfg.MaxFiles
+	}
+
+	if err = f.Open(); err != nil {
+		log.Error("Error loading logger: %s", err)
+		return nil, err
+	}
+	log.Info("[%s logger] initialized: %v", f.Name, cfg)
+
+	return f, err
+}
+
+// Open opens or creates the file, appending the events to the existing ones.
+func (f *File) Open() error {
+	if f.cfg.Path == "" {
+		return fmt.Errorf("[%s] Path must not be empty", f.Name)
+	}
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = st.Size()
+
+	return nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate renames the file to <path>.1, and the previous rotated files to
+// <path>.N+1, deleting the oldest one.
+func (f *File) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	os.Remove(fmt.Sprint(f.cfg.Path, ".", f.maxFiles))
+	for i := f.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprint(f.cfg.Path, ".", i), fmt.Sprint(f.cfg.Path, ".", i+1))
+	}
+	if err := os.Rename(f.cfg.Path, f.cfg.Path+".1"); err != nil {
+		log.Debug("[%s] error rotating %s: %s", f.Name, f.cfg.Path, err)
+	}
+
+	return f.Open()
+}
+
+// Transform transforms data for proper ingestion.
+func (f *File) Transform(args ...interface{}) (out string) {
+	if f.logFormat != nil {
+		args = append(args, f.Hostname)
+		args = append(args, f.Tag)
+		out = f.logFormat.Transform(args...)
+	}
+	return
+}
+
+func (f *File) Write(msg string) {
+	if msg == "" {
+		return
+	}
+	if msg[len(msg)-1] != '\n' {
+		msg += "\n"
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.Open(); err != nil {
+			log.Debug("[%s] error opening %s: %s", f.Name, f.cfg.Path, err)
+			return
+		}
+	} else if f.size > 0 && f.size+int64(len(msg)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			log.Error("[%s] error rotating %s: %s", f.Name, f.cfg.Path, err)
+			return
+		}
+	}
+
+	n, err := f.file.WriteString(msg)
+	f.size += int64(n)
+	if err != nil {
+		log.Error("[%s] write error: %s", f.Name, err)
+	}
+}
@@ -17,10 +17,16 @@ type LoggerConfig struct {
 	Name string
 	// Format: rfc5424, csv, json, ...
 	Format string
-	// Protocol: udp, tcp
+	// Protocol: udp, tcp, unix, unixgram
 	Protocol string
-	// Server: 127.0.0.1:514
+	// Server: 127.0.0.1:514, /run/siem.sock
 	Server string
+	// Path: /var/log/opensnitchd.events.json
+	Path string
+	// MaxSize: size in MB of the file before rotating it
+	MaxSize int
+	// MaxFiles: number of rotated files to keep
+	MaxFiles int
 	// WriteTimeout:
 	WriteTimeout string
 	// Tag: opensnitchd, mytag, ...
@@ -55,6 +61,18 @@ func (l *LoggerManager) Load(configs []LoggerConfig, workers int) {
 				l.loggers[fmt.Sprint(lgr.Name, lgr.cfg.Server, lgr.cfg.Protocol)] = lgr
 				workers += cfg.Workers
 			}
+		case LOGGER_REMOTE:
+			if lgr, err := NewRemote(&cfg); err == nil {
+				l.count++
+				l.loggers[fmt.Sprint(lgr.Name, lgr.cfg.Server, lgr.cfg.Protocol)] = lgr
+				workers += cfg.Workers
+			}
+		case LOGGER_FILE:
+			if lgr, err := NewFile(&cfg); err == nil {
+				l.count++
+				l.loggers[fmt.Sprint(lgr.Name, lgr.cfg.Path)] = lgr
+				workers += cfg.Workers
+			}
 		case LOGGER_SYSLOG:
 			if lgr, err := NewSyslog(&cfg); err == nil {
 				l.count++
@@ -19,7 +19,7 @@ const (
 )
 
 // Remote defines the logger that writes events to a generic remote server.
-// It can write to the local or a remote daemon, UDP or TCP.
+// It can write to the local or a remote daemon, UDP, TCP or UNIX sockets.
 // It supports writing events in RFC5424, RFC3164, CSV and JSON formats.
 type Remote struct {
 	Name     string
@@ -101,7 +101,7 @@ func (s *Remote) Open() (err error) {
 // Dial opens a new connection with a remote server.
 func (s *Remote) Dial(proto, addr string, connTimeout time.Duration) (netConn net.Conn, err error) {
 	switch proto {
-	case "udp", "tcp":
+	case "udp", "tcp", "unix", "unixgram":
 		netConn, err = net.DialTimeout(proto, addr, connTimeout)
 		if err != nil {
 			return nil, err
@@ -161,7 +161,7 @@ func (s *Remote) Write(msg string) {
 	// I haven't figured out yet why these write errors ocurr.
 	s.mu.Lock()
 	s.netConn.SetWriteDeadline(deadline)
-	_, err := s.netConn.Write([]byte(msg))
+	_, err := s.netConn.Write([]byte(s.formatLine(msg)))
 	s.mu.Unlock()
 	if err == nil {
 		return
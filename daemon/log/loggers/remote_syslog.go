@@ -31,11 +31,10 @@ const (
 type RemoteSyslog struct {
 	Syslog
 
-	Hostname string
-	netConn  net.Conn
-	Timeout  time.Duration
-	errors   uint32
-	status   uint32
+	netConn net.Conn
+	Timeout time.Duration
+	errors  uint32
+	status  uint32
 
 	mu *sync.RWMutex
 }
@@ -56,6 +55,8 @@ func NewRemoteSyslog(cfg *LoggerConfig) (*RemoteSyslog, error) {
 	sys.logFormat = formats.NewRfc5424()
 	if cfg.Format == formats.CSV {
 		sys.logFormat = formats.NewCSV()
+	} else if cfg.Format == formats.JSON {
+		sys.logFormat = formats.NewJSON()
 	}
 
 	sys.Tag = logTag
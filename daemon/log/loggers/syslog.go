@@ -2,6 +2,7 @@ package loggers
 
 import (
 	"log/syslog"
+	"os"
 
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/evilsocket/opensnitch/daemon/log/formats"
@@ -17,6 +18,7 @@ type Syslog struct {
 	Name      string
 	Writer    *syslog.Writer
 	Tag       string
+	Hostname  string
 	logFormat formats.LoggerFormat
 	cfg       *LoggerConfig
 }
@@ -35,12 +37,18 @@ func NewSyslog(cfg *LoggerConfig) (*Syslog, error) {
 	sys.logFormat = formats.NewRfc5424()
 	if cfg.Format == formats.CSV {
 		sys.logFormat = formats.NewCSV()
+	} else if cfg.Format == formats.JSON {
+		sys.logFormat = formats.NewJSON()
 	}
 
 	sys.Tag = logTag
 	if cfg.Tag != "" {
 		sys.Tag = cfg.Tag
 	}
+	sys.Hostname, err = os.Hostname()
+	if err != nil {
+		sys.Hostname = "localhost"
+	}
 
 	if err = sys.Open(); err != nil {
 		log.Error("Error loading logger: %s", err)
@@ -67,6 +75,8 @@ func (s *Syslog) Close() error {
 // Transform transforms data for proper ingestion.
 func (s *Syslog) Transform(args ...interface{}) (out string) {
 	if s.logFormat != nil {
+		args = append(args, s.Hostname)
+		args = append(args, s.Tag)
 		out = s.logFormat.Transform(args...)
 	}
 	return
@@ -24,6 +24,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -31,8 +32,11 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -45,6 +49,7 @@ import (
 	"github.com/evilsocket/opensnitch/daemon/log/loggers"
 	"github.com/evilsocket/opensnitch/daemon/netfilter"
 	"github.com/evilsocket/opensnitch/daemon/netlink"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
 	"github.com/evilsocket/opensnitch/daemon/procmon/ebpf"
 	"github.com/evilsocket/opensnitch/daemon/procmon/monitor"
 	"github.com/evilsocket/opensnitch/daemon/rule"
@@ -56,11 +61,19 @@ import (
 var (
 	showVersion       = false
 	checkRequirements = false
+	checkFwConfig     = ""
 	procmonMethod     = ""
 	logFile           = ""
 	logUTC            = true
 	logMicro          = false
 	rulesPath         = "rules"
+	rulesDB           = ""
+	rulesExport       = ""
+	testConnections   = ""
+	bundleExport      = ""
+	bundleImport      = ""
+	bundleKey         = ""
+	bundleKeygen      = ""
 	noLiveReload      = false
 	queueNum          = 0
 	repeatQueueNum    int //will be set later to queueNum + 1
@@ -89,15 +102,28 @@ var (
 	exitChan      = (chan bool)(nil)
 	loggerMgr     *loggers.LoggerManager
 	resolvMonitor *systemd.ResolvedMonitor
+
+	// processes with a deleted or replaced binary already notified.
+	tamperedAlerts sync.Map
+	// untrusted DNS servers already notified.
+	untrustedResolverAlerts sync.Map
 )
 
 func init() {
 	flag.BoolVar(&showVersion, "version", debug, "Show daemon version of this executable and exit.")
 	flag.BoolVar(&checkRequirements, "check-requirements", debug, "Check system requirements for incompatibilities.")
+	flag.StringVar(&checkFwConfig, "check-fw-config", checkFwConfig, "Check the system firewall configuration file (system-fw.json) for errors, and exit.")
 
 	flag.StringVar(&procmonMethod, "process-monitor-method", procmonMethod, "How to search for processes path. Options: ftrace, audit (experimental), ebpf (experimental), proc (default)")
 	flag.StringVar(&uiSocket, "ui-socket", uiSocket, "Path the UI gRPC service listener (https://github.com/grpc/grpc/blob/master/doc/naming.md).")
 	flag.StringVar(&rulesPath, "rules-path", rulesPath, "Path to load JSON rules from.")
+	flag.StringVar(&rulesDB, "rules-db", rulesDB, "Path to a SQLite database to store the rules, instead of the rules path. If the database is empty, the rules of the rules path are imported. Requires building the daemon with -tags sqlite.")
+	flag.StringVar(&rulesExport, "rules-export", rulesExport, "Export the rules of the rules database to this directory, in JSON format, and exit.")
+	flag.StringVar(&testConnections, "test-connections", testConnections, "Evaluate the connections of this JSON file against the rules, print the rules that match them, and exit. It fails if the action of a connection is not the expected one.")
+	flag.StringVar(&bundleExport, "rules-bundle-export", bundleExport, "Export the rules and their lists to this bundle, signed with the private key of -rules-bundle-key, and exit.")
+	flag.StringVar(&bundleImport, "rules-bundle-import", bundleImport, "Verify this bundle with the public key of -rules-bundle-key, install its rules and lists, and exit.")
+	flag.StringVar(&bundleKey, "rules-bundle-key", bundleKey, "Key to sign (private key) or verify (public key) the bundles of rules.")
+	flag.StringVar(&bundleKeygen, "rules-bundle-keygen", bundleKeygen, "Generate a key pair to sign bundles of rules: the private key is written to this file, and the public key to the same file with the .pub extension, and exit.")
 	flag.IntVar(&queueNum, "queue-num", queueNum, "Netfilter queue number.")
 	flag.IntVar(&workers, "workers", workers, "Number of concurrent workers.")
 	flag.BoolVar(&noLiveReload, "no-live-reload", debug, "Disable rules live reloading.")
@@ -147,6 +173,83 @@ func setupLogging() {
 	}
 }
 
+// checkFirewallConfig validates the given system firewall configuration file,
+// without applying it, and returns the exit code.
+func checkFirewallConfig(path string) int {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading %s: %s\n", path, err)
+		return 1
+	}
+	errs := firewall.CheckConfiguration(raw)
+	for _, e := range errs {
+		fmt.Printf("%s: %s\n", path, e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: configuration OK\n", path)
+	return 0
+}
+
+// checkConnections prints the rules that match the connections of a file,
+// and returns 1 if the action of any connection is not the expected one.
+func checkConnections(path string) int {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading %s: %s\n", path, err)
+		return 1
+	}
+	var sims []rule.Simulation
+	if err := json.Unmarshal(raw, &sims); err != nil {
+		fmt.Printf("Error parsing %s: %s\n", path, err)
+		return 1
+	}
+	ret := 0
+	for i, sim := range sims {
+		res, err := rules.Simulate(&sim)
+		if err != nil {
+			fmt.Printf("%s: connection %d: %s\n", path, i, err)
+			ret = 1
+			continue
+		}
+		verdict := "no rule matches"
+		if res.Matched {
+			verdict = fmt.Sprintf("%s (%s)", res.Action, res.Rule.Name)
+		}
+		status := ""
+		if sim.Expect != "" && sim.Expect != res.Action {
+			status = fmt.Sprintf(", FAIL: expected %s", sim.Expect)
+			ret = 1
+		}
+		fmt.Printf("%s: connection %d: %s -> %s:%d: %s%s\n", path, i, sim.ProcessPath, sim.DstIP, sim.DstPort, verdict, status)
+	}
+	return ret
+}
+
+// handleBundle exports the rules to a bundle, or imports the rules of a
+// bundle, installing its lists in listsDir, and returns the exit code.
+func handleBundle(listsDir string) int {
+	if bundleKey == "" {
+		fmt.Println("-rules-bundle-key is required")
+		return 1
+	}
+	if bundleExport != "" {
+		if err := rules.ExportBundle(bundleExport, bundleKey, nil); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	}
+	names, err := rules.ImportBundle(bundleImport, bundleKey, listsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("%s: %d rules imported: %s\n", bundleImport, len(names), strings.Join(names, ", "))
+	return 0
+}
+
 func setupProfiling() {
 	if cpuProfile != "" {
 		if f, err := os.Create(cpuProfile); err != nil {
@@ -221,34 +324,66 @@ func listenToEvents() {
 			}
 		}(uiClient)
 	}
+	go func(uiClient *ui.Client) {
+		for msg := range procmon.ChecksumEvents() {
+			uiClient.SendWarningAlert(msg)
+		}
+	}(uiClient)
+	go func(uiClient *ui.Client) {
+		for f := range monitor.FailoverEvents() {
+			uiClient.PostAlert(
+				protocol.Alert_WARNING,
+				protocol.Alert_PROC_MONITOR,
+				protocol.Alert_SHOW_ALERT,
+				protocol.Alert_HIGH,
+				fmt.Sprintf("Process monitor method %s failing (%s), changed to %s", f.From, f.Reason, f.To))
+		}
+	}(uiClient)
+	go func(uiClient *ui.Client) {
+		for r := range rules.ExpiredRules() {
+			uiClient.PostAlert(
+				protocol.Alert_INFO,
+				protocol.Alert_RULE,
+				protocol.Alert_SHOW_ALERT,
+				protocol.Alert_LOW,
+				r)
+		}
+	}(uiClient)
+	go func() {
+		for change := range rules.RuleChanges() {
+			stats.PublishRuleChange(change)
+		}
+	}()
 }
 
 func initSystemdResolvedMonitor() {
-	resolvMonitor, err := systemd.NewResolvedMonitor()
+	resolved, err := systemd.NewResolvedMonitor()
 	if err != nil {
 		log.Debug("[DNS] Unable to use systemd-resolved monitor: %s", err)
 		return
 	}
-	_, err = resolvMonitor.Connect()
+	_, err = resolved.Connect()
 	if err != nil {
 		log.Debug("[DNS] Connecting to systemd-resolved: %s", err)
 		return
 	}
-	err = resolvMonitor.Subscribe()
+	go loadResolvedCache()
+	err = resolved.Subscribe()
 	if err != nil {
 		log.Debug("[DNS] Subscribing to systemd-resolved DNS events: %s", err)
 		return
 	}
+	resolvMonitor = resolved
 	go func() {
 		for {
 			select {
-			case exit := <-resolvMonitor.Exit():
+			case exit := <-resolved.Exit():
 				if exit == nil {
 					log.Info("[DNS] systemd-resolved monitor stopped")
 					return
 				}
 				log.Debug("[DNS] systemd-resolved monitor disconnected. Reconnecting...")
-			case response := <-resolvMonitor.GetDNSResponses():
+			case response := <-resolved.GetDNSResponses():
 				if response.State != systemd.SuccessState {
 					log.Debug("[DNS] systemd-resolved monitor response error: %v", response)
 					continue
@@ -256,33 +391,66 @@ func initSystemdResolvedMonitor() {
 				/*for i, q := range response.Question {
 					log.Debug("%d SYSTEMD RESPONSE Q: %s", i, q.Name)
 				}*/
-				for i, a := range response.Answer {
-					if a.RR.Key.Type != systemd.DNSTypeA &&
-						a.RR.Key.Type != systemd.DNSTypeAAAA &&
-						a.RR.Key.Type != systemd.DNSTypeCNAME {
-						log.Debug("systemd-resolved, excluding answer: %#v", a)
-						continue
-					}
-					domain := a.RR.Key.Name
-					ip := net.IP(a.RR.Address)
-					log.Debug("%d systemd-resolved monitor response: %s -> %s", i, domain, ip)
-					if a.RR.Key.Type == systemd.DNSTypeCNAME {
-						log.Debug("systemd-resolved CNAME >> %s -> %s", a.RR.Name, domain)
-						dns.Track(a.RR.Name, domain)
-					} else {
-						dns.Track(ip.String(), domain)
-					}
+				for _, a := range response.Answer {
+					trackResolvedRecord(a.RR)
 				}
 			}
 		}
 	}()
 }
 
+// loadResolvedCache tracks the domains of the cache of systemd-resolved,
+// resolved before the monitor was subscribed.
+func loadResolvedCache() {
+	scopes, err := systemd.DumpCache()
+	if err != nil {
+		log.Debug("[DNS] Unable to read the cache of systemd-resolved: %s", err)
+		return
+	}
+	records := 0
+	for _, scope := range scopes {
+		for _, entry := range scope.Cache {
+			for _, r := range entry.RRs {
+				if trackResolvedRecord(r.RR) {
+					records++
+				}
+			}
+		}
+	}
+	log.Debug("[DNS] %d records loaded from the cache of systemd-resolved", records)
+}
+
+// trackResolvedRecord adds a record resolved by systemd-resolved to the list
+// of resolved domains, if it's an A, AAAA or CNAME.
+func trackResolvedRecord(rr systemd.RRType) bool {
+	if rr.Key.Type != systemd.DNSTypeA &&
+		rr.Key.Type != systemd.DNSTypeAAAA &&
+		rr.Key.Type != systemd.DNSTypeCNAME {
+		log.Debug("systemd-resolved, excluding answer: %#v", rr)
+		return false
+	}
+	domain := rr.Key.Name
+	if rr.Key.Type == systemd.DNSTypeCNAME {
+		log.Debug("systemd-resolved CNAME >> %s -> %s", rr.Name, domain)
+		dns.Track(rr.Name, domain)
+		return true
+	}
+	ip := net.IP(rr.Address)
+	log.Debug("systemd-resolved monitor response: %s -> %s", domain, ip)
+	dns.Track(ip.String(), domain)
+	return true
+}
+
 func doCleanup(queue, repeatQueue *netfilter.Queue) {
 	log.Info("Cleaning up ...")
 	firewall.Stop()
 	monitor.End()
 	uiClient.Close()
+	rules.StopAccounting()
+	stats.Stop()
+	if err := rules.SaveHits(); err != nil {
+		log.Warning("Error saving the hits of the rules: %s", err)
+	}
 	queue.Close()
 	repeatQueue.Close()
 	if resolvMonitor != nil {
@@ -309,9 +477,16 @@ func doCleanup(queue, repeatQueue *netfilter.Queue) {
 
 func onPacket(packet netfilter.Packet) {
 	// DNS response, just parse, track and accept.
+	// The answers denied by the rules are removed before, if configured.
+	filtered := dns.FilterAnswers(packet.Packet, uiClient.DNSFirewall(), deniedAnswer)
 	if dns.TrackAnswers(packet.Packet) == true {
-		packet.SetVerdictAndMark(netfilter.NF_ACCEPT, packet.Mark)
+		if filtered != nil {
+			packet.SetVerdictAndMarkWithPacket(netfilter.NF_ACCEPT, packet.Mark, filtered)
+		} else {
+			packet.SetVerdictAndMark(netfilter.NF_ACCEPT, packet.Mark)
+		}
 		stats.OnDNSResponse()
+		stats.LogDNSResponse(packet.Packet)
 		return
 	}
 
@@ -326,6 +501,15 @@ func onPacket(packet netfilter.Packet) {
 		packet.SetVerdict(netfilter.NF_ACCEPT)
 		return
 	}
+	if con.Tampered {
+		alertTampered(con)
+	}
+	if con.DstPort == 53 {
+		stats.OnDNSQuery(con)
+	}
+	if con.UntrustedResolver && denyUntrustedResolver(&packet, con) {
+		return
+	}
 
 	// search a match in preloaded rules
 	r := acceptOrDeny(&packet, con)
@@ -338,6 +522,60 @@ func onPacket(packet netfilter.Packet) {
 	stats.OnConnectionEvent(con, r, r == nil)
 }
 
+// denyUntrustedResolver notifies the connections whose domain has been
+// resolved by an untrusted DNS server, once per server, and drops them if
+// configured. Returns true if the connection has been dropped.
+func denyUntrustedResolver(packet *netfilter.Packet, con *conman.Connection) bool {
+	deny := uiClient.UntrustedResolvers() == dns.UntrustedDeny
+	log.Warning("%s resolved by an untrusted DNS server %s: %s -> %s (denied: %v)", con.DstHost, con.DstHostResolver, con.Process.Path, con.To(), deny)
+	if _, notified := untrustedResolverAlerts.LoadOrStore(con.DstHostResolver, true); !notified {
+		uiClient.PostAlert(
+			protocol.Alert_WARNING,
+			protocol.Alert_CONNECTION,
+			protocol.Alert_SHOW_ALERT,
+			protocol.Alert_HIGH,
+			con)
+	}
+	if !deny {
+		return false
+	}
+	packet.SetVerdict(netfilter.NF_DROP)
+	return true
+}
+
+// deniedAnswer tells if an IP resolved by a DNS response is denied by the
+// rules. The process that resolved it is not known, so only the rules that
+// don't depend on it are applied: a domain or IP denied explicitly, not by the
+// catch-all or fallback rules.
+func deniedAnswer(chain []string, ip net.IP) bool {
+	res, err := rules.SimulateDestination(&rule.Simulation{
+		DstHost:      chain[len(chain)-1],
+		DstHostChain: chain,
+		DstIP:        ip.String(),
+		UserID:       -1,
+	})
+	if err != nil || !res.Matched {
+		return false
+	}
+	return res.Action == rule.Deny || res.Action == rule.Reject
+}
+
+// alertTampered notifies the connections of the processes whose binary has
+// been deleted or replaced, once per process.
+func alertTampered(con *conman.Connection) {
+	key := fmt.Sprint(con.Process.ID, ":", con.Process.Path)
+	if _, notified := tamperedAlerts.LoadOrStore(key, true); notified {
+		return
+	}
+	log.Warning("The binary of the process %d has been deleted or replaced: %s", con.Process.ID, con.Process.Path)
+	uiClient.PostAlert(
+		protocol.Alert_WARNING,
+		protocol.Alert_CONNECTION,
+		protocol.Alert_SHOW_ALERT,
+		protocol.Alert_HIGH,
+		con)
+}
+
 func applyDefaultAction(packet *netfilter.Packet) {
 	if uiClient.DefaultAction() == rule.Allow {
 		packet.SetVerdictAndMark(netfilter.NF_ACCEPT, packet.Mark)
@@ -346,93 +584,146 @@ func applyDefaultAction(packet *netfilter.Packet) {
 	}
 }
 
-func acceptOrDeny(packet *netfilter.Packet, con *conman.Connection) *rule.Rule {
-	r := rules.FindFirstMatch(con)
-	if r == nil {
-		// no rule matched
-		// Note that as soon as we set a verdict on a packet, the next packet in the netfilter queue
-		// will begin to be processed even if this function hasn't yet returned
-
-		// send a request to the UI client if
-		// 1) connected and running and 2) we are not already asking
-		if uiClient.Connected() == false || uiClient.GetIsAsking() == true {
-			applyDefaultAction(packet)
-			log.Debug("UI is not running or busy, connected: %v, running: %v", uiClient.Connected(), uiClient.GetIsAsking())
-			return nil
+// rejectConnection answers the packet of a rejected connection, so the
+// application doesn't wait for a timeout. If the reply can't be sent, the
+// socket of the connection is closed.
+func rejectConnection(packet *netfilter.Packet, con *conman.Connection) {
+	switch method := uiClient.RejectWith(); method {
+	case netfilter.RejectICMP, netfilter.RejectTCPReset:
+		err := netfilter.SendReject(packet.Packet, method)
+		if err == nil {
+			return
 		}
+		log.Debug("Error rejecting connection (%s), closing the socket: %s", method, err)
+	}
+	netlink.KillSocket(con.Protocol, con.SrcIP, con.SrcPort, con.DstIP, con.DstPort)
+}
 
-		uiClient.SetIsAsking(true)
-		defer uiClient.SetIsAsking(false)
+// askRule asks the user to allow or deny a connection, waiting for the answer
+// up to the given timeout. It returns the requeued packet, or nil if it was
+// lost.
+func askRule(packet *netfilter.Packet, con *conman.Connection, timeout time.Duration) (*rule.Rule, *netfilter.Packet) {
+	uiClient.SetIsAsking(true)
+	defer uiClient.SetIsAsking(false)
+
+	// In order not to block packet processing, we send our packet to a different netfilter queue
+	// and then immediately pull it back out of that queue
+	packet.SetRequeueVerdict(uint16(repeatQueueNum))
+
+	var o bool
+	var pkt netfilter.Packet
+	// don't wait for the packet longer than 1 sec
+	select {
+	case pkt, o = <-repeatPktChan:
+		if !o {
+			log.Debug("error while receiving packet from repeatPktChan")
+			return nil, nil
+		}
+	case <-time.After(1 * time.Second):
+		log.Debug("timed out while receiving packet from repeatPktChan")
+		return nil, nil
+	}
 
-		// In order not to block packet processing, we send our packet to a different netfilter queue
-		// and then immediately pull it back out of that queue
-		packet.SetRequeueVerdict(uint16(repeatQueueNum))
+	//check if the pulled out packet is the same we put in
+	if res := bytes.Compare(packet.Packet.Data(), pkt.Packet.Data()); res != 0 {
+		log.Error("The packet which was requeued has changed abruptly. This should never happen. Please report this incident to the Opensnitch developers. %v %v ", packet, pkt)
+		return nil, nil
+	}
+	packet = &pkt
 
-		var o bool
-		var pkt netfilter.Packet
-		// don't wait for the packet longer than 1 sec
-		select {
-		case pkt, o = <-repeatPktChan:
-			if !o {
-				log.Debug("error while receiving packet from repeatPktChan")
-				return nil
-			}
-		case <-time.After(1 * time.Second):
-			log.Debug("timed out while receiving packet from repeatPktChan")
-			return nil
-		}
+	// Update the hostname again.
+	// This is required due to a race between the ebpf dns hook and the actual first packet beeing sent
+	if con.DstHost == "" {
+		con.ResolveDstHost()
+	}
 
-		//check if the pulled out packet is the same we put in
-		if res := bytes.Compare(packet.Packet.Data(), pkt.Packet.Data()); res != 0 {
-			log.Error("The packet which was requeued has changed abruptly. This should never happen. Please report this incident to the Opensnitch developers. %v %v ", packet, pkt)
-			return nil
-		}
-		packet = &pkt
+	return uiClient.Ask(con, timeout), packet
+}
 
-		// Update the hostname again.
-		// This is required due to a race between the ebpf dns hook and the actual first packet beeing sent
-		if con.DstHost == "" {
-			con.DstHost = dns.HostOr(con.DstIP, con.DstHost)
-		}
+// addAnswer adds the rule answered by the user to the loaded rules.
+func addAnswer(r *rule.Rule) {
+	ok := false
+	pers := ""
+	action := string(r.Action)
+	if r.Action == rule.Allow {
+		action = log.Green(action)
+	} else {
+		action = log.Red(action)
+	}
 
-		r = uiClient.Ask(con)
-		if r == nil {
-			log.Error("Invalid rule received, applying default action")
-			applyDefaultAction(packet)
-			return nil
+	// check if and how the rule needs to be saved
+	if r.Duration == rule.Always {
+		pers = "Saved"
+		// add to the loaded rules and persist on disk
+		if err := rules.Add(r, true); err != nil {
+			log.Error("Error while saving rule: %s", err)
+		} else {
+			ok = true
 		}
-		ok := false
-		pers := ""
-		action := string(r.Action)
-		if r.Action == rule.Allow {
-			action = log.Green(action)
+	} else {
+		pers = "Added"
+		// add to the rules but do not save to disk
+		if err := rules.Add(r, false); err != nil {
+			log.Error("Error while adding rule: %s", err)
 		} else {
-			action = log.Red(action)
+			ok = true
 		}
+	}
+
+	if ok {
+		log.Important("%s new rule: %s if %s", pers, action, r.Operator.String())
+	}
+}
 
-		// check if and how the rule needs to be saved
-		if r.Duration == rule.Always {
-			pers = "Saved"
-			// add to the loaded rules and persist on disk
-			if err := rules.Add(r, true); err != nil {
-				log.Error("Error while saving rule: %s", err)
+func acceptOrDeny(packet *netfilter.Packet, con *conman.Connection) *rule.Rule {
+	start := time.Now()
+	r := rules.FindFirstMatch(con)
+	con.RulesTime = time.Since(start)
+	// the rules with the action prompt ask the user even if other rules
+	// would allow the connection.
+	prompt := r != nil && r.Enabled && r.Action == rule.Prompt
+	if r == nil || prompt {
+		// no rule matched
+		// Note that as soon as we set a verdict on a packet, the next packet in the netfilter queue
+		// will begin to be processed even if this function hasn't yet returned
+
+		// send a request to the UI client if
+		// 1) connected and running and 2) we are not already asking
+		if uiClient.Connected() == false || uiClient.GetIsAsking() == true {
+			log.Debug("UI is not running or busy, connected: %v, running: %v", uiClient.Connected(), uiClient.GetIsAsking())
+			if prompt {
+				r = r.PromptFallback()
 			} else {
-				ok = true
+				applyDefaultAction(packet)
+				return nil
 			}
 		} else {
-			pers = "Added"
-			// add to the rules but do not save to disk
-			if err := rules.Add(r, false); err != nil {
-				log.Error("Error while adding rule: %s", err)
+			timeout := rule.DefaultPromptTimeout
+			if prompt {
+				timeout = r.Prompt.GetTimeout()
+			}
+			var answer *rule.Rule
+			if answer, packet = askRule(packet, con, timeout); packet == nil {
+				return nil
+			}
+			if answer == nil && prompt {
+				log.Info("No answer to the rule %s, applying its prompt action", r.Name)
+				r = r.PromptFallback()
+			} else if answer == nil {
+				log.Error("Invalid rule received, applying default action")
+				applyDefaultAction(packet)
+				return nil
+			} else if prompt {
+				// the answer only applies to this connection, the next
+				// ones will be asked again.
+				r = answer
 			} else {
-				ok = true
+				r = answer
+				// until-logout rules expire when the session of the user ends.
+				r.BindSession(con.Process.ID, con.Process.UID)
+				addAnswer(r)
 			}
 		}
-
-		if ok {
-			log.Important("%s new rule: %s if %s", pers, action, r.Operator.String())
-		}
-
 	}
 	if packet == nil {
 		log.Debug("Packet nil after processing rules")
@@ -444,8 +735,9 @@ func acceptOrDeny(packet *netfilter.Packet, con *conman.Connection) *rule.Rule {
 		ruleName := log.Green(r.Name)
 		log.Info("DISABLED (%s) %s %s -> %s:%d (%s)", uiClient.DefaultAction(), log.Bold(log.Green("✔")), log.Bold(con.Process.Path), log.Bold(con.To()), con.DstPort, ruleName)
 
-	} else if r.Action == rule.Allow {
+	} else if r.GetAction() == rule.Allow {
 		packet.SetVerdictAndMark(netfilter.NF_ACCEPT, packet.Mark)
+		rules.TrackConnection(con, r)
 		ruleName := log.Green(r.Name)
 		if r.Operator.Operand == rule.OpTrue {
 			ruleName = log.Dim(r.Name)
@@ -453,7 +745,7 @@ func acceptOrDeny(packet *netfilter.Packet, con *conman.Connection) *rule.Rule {
 		log.Debug("%s %s -> %d:%s => %s:%d, mark: %x (%s)", log.Bold(log.Green("✔")), log.Bold(con.Process.Path), con.SrcPort, log.Bold(con.SrcIP.String()), log.Bold(con.To()), con.DstPort, packet.Mark, ruleName)
 	} else {
 		if r.Action == rule.Reject {
-			netlink.KillSocket(con.Protocol, con.SrcIP, con.SrcPort, con.DstIP, con.DstPort)
+			rejectConnection(packet, con)
 		}
 		packet.SetVerdict(netfilter.NF_DROP)
 
@@ -476,6 +768,16 @@ func main() {
 		core.CheckSysRequirements()
 		os.Exit(0)
 	}
+	if checkFwConfig != "" {
+		os.Exit(checkFirewallConfig(checkFwConfig))
+	}
+	if bundleKeygen != "" {
+		if err := rule.GenerateBundleKeys(bundleKeygen, bundleKeygen+".pub"); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	setupLogging()
 	setupProfiling()
@@ -489,11 +791,31 @@ func main() {
 
 	setupSignals()
 
-	log.Info("Loading rules from %s ...", rulesPath)
 	if rules, err = rule.NewLoader(!noLiveReload); err != nil {
 		log.Fatal("%s", err)
-	} else if err = rules.Load(rulesPath); err != nil {
-		log.Fatal("%s", err)
+	}
+	if rulesDB != "" {
+		log.Info("Loading rules from %s ...", rulesDB)
+		if err = rules.LoadDB(rulesDB, rulesPath); err != nil {
+			log.Fatal("%s", err)
+		}
+		if rulesExport != "" {
+			if err = rules.Export(rulesExport); err != nil {
+				log.Fatal("%s", err)
+			}
+			os.Exit(0)
+		}
+	} else {
+		log.Info("Loading rules from %s ...", rulesPath)
+		if err = rules.Load(rulesPath); err != nil {
+			log.Fatal("%s", err)
+		}
+	}
+	if testConnections != "" {
+		os.Exit(checkConnections(testConnections))
+	}
+	if bundleExport != "" || bundleImport != "" {
+		os.Exit(handleBundle(filepath.Join(filepath.Dir(rulesPath), "lists")))
 	}
 	stats = statistics.New(rules)
 	loggerMgr = loggers.NewLoggerManager()
@@ -56,6 +56,11 @@ func (p *Packet) SetVerdictWithPacket(v Verdict, packet []byte) {
 	p.verdictChannel <- VerdictContainer{Verdict: v, Packet: packet, Mark: 0}
 }
 
+// SetVerdictAndMarkWithPacket apply a verdict with a new packet, and marks it.
+func (p *Packet) SetVerdictAndMarkWithPacket(v Verdict, mark uint32, packet []byte) {
+	p.verdictChannel <- VerdictContainer{Verdict: v, Packet: packet, Mark: mark}
+}
+
 // IsIPv4 returns if the packet is IPv4
 func (p *Packet) IsIPv4() bool {
 	return p.NetworkProtocol == IPv4
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -243,6 +244,7 @@ func go_callback(queueID C.int, data *C.uchar, length C.int, mark C.uint, idx ui
 		}
 
 	case <-time.After(1 * time.Millisecond):
+		atomic.AddUint64(&queueTimeouts, 1)
 		fmt.Fprintf(os.Stderr, "Timed out while sending packet to queue channel %d\n", idx)
 	}
 }
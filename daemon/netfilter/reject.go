@@ -0,0 +1,137 @@
+package netfilter
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Methods to reject connections.
+// By default the socket of the connection is closed, which only works for TCP
+// connections. Otherwise, the packet is dropped, and we answer on behalf of
+// the destination, so the application fails immediately instead of waiting
+// for a timeout.
+const (
+	RejectKillSocket = "kill-socket"
+	// ICMP port unreachable for all the protocols.
+	RejectICMP = "icmp-port-unreachable"
+	// TCP reset for TCP connections, ICMP port unreachable for the rest.
+	RejectTCPReset = "tcp-reset"
+
+	// bytes of the rejected packet to include in the ICMP errors.
+	rejectICMPPayloadLen = 512
+)
+
+// SendReject answers a packet with a TCP reset or an ICMP port unreachable,
+// as if the connection had been rejected by the destination.
+func SendReject(pkt gopacket.Packet, method string) error {
+	var ip gopacket.NetworkLayer
+	var reply gopacket.SerializableLayer
+	var src, dst net.IP
+	var proto layers.IPProtocol
+	var family int
+
+	switch orig := pkt.NetworkLayer().(type) {
+	case *layers.IPv4:
+		family = syscall.AF_INET
+		src, dst = orig.DstIP, orig.SrcIP
+		ip = &layers.IPv4{Version: 4, TTL: 64, SrcIP: src, DstIP: dst}
+	case *layers.IPv6:
+		family = syscall.AF_INET6
+		src, dst = orig.DstIP, orig.SrcIP
+		ip = &layers.IPv6{Version: 6, HopLimit: 64, SrcIP: src, DstIP: dst}
+	default:
+		return fmt.Errorf("reject: unsupported network protocol")
+	}
+
+	if tcp, ok := pkt.TransportLayer().(*layers.TCP); ok && method == RejectTCPReset {
+		proto = layers.IPProtocolTCP
+		reply = newTCPReset(tcp, ip)
+	} else if family == syscall.AF_INET {
+		proto = layers.IPProtocolICMPv4
+		reply = &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort),
+		}
+	} else {
+		proto = layers.IPProtocolICMPv6
+		icmp6 := &layers.ICMPv6{
+			TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6CodePortUnreachable),
+		}
+		icmp6.SetNetworkLayerForChecksum(ip)
+		reply = icmp6
+	}
+
+	return sendRejectPacket(family, ip, reply, proto, pkt.NetworkLayer().LayerContents(), pkt.NetworkLayer().LayerPayload(), dst)
+}
+
+func newTCPReset(orig *layers.TCP, ip gopacket.NetworkLayer) *layers.TCP {
+	rst := &layers.TCP{
+		SrcPort: orig.DstPort,
+		DstPort: orig.SrcPort,
+		RST:     true,
+	}
+	if orig.ACK {
+		rst.Seq = orig.Ack
+	} else {
+		rst.ACK = true
+		rst.Ack = orig.Seq + uint32(len(orig.Payload))
+		if orig.SYN || orig.FIN {
+			rst.Ack++
+		}
+	}
+	rst.SetNetworkLayerForChecksum(ip)
+	return rst
+}
+
+// sendRejectPacket builds the reply and sends it through a raw socket.
+// The ICMP errors include the beginning of the rejected packet, which is
+// used by the kernel to find the socket of the connection.
+func sendRejectPacket(family int, ip gopacket.NetworkLayer, reply gopacket.SerializableLayer, proto layers.IPProtocol, origHeader, origPayload []byte, dst net.IP) error {
+	pktLayers := []gopacket.SerializableLayer{ip.(gopacket.SerializableLayer), reply}
+	if proto != layers.IPProtocolTCP {
+		data := append([]byte{}, origHeader...)
+		data = append(data, origPayload...)
+		if len(data) > rejectICMPPayloadLen {
+			data = data[:rejectICMPPayloadLen]
+		}
+		pktLayers = append(pktLayers, gopacket.Payload(data))
+	}
+	// the protocol of the network layer must be set before serializing it
+	switch l := ip.(type) {
+	case *layers.IPv4:
+		l.Protocol = proto
+	case *layers.IPv6:
+		l.NextHeader = proto
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, pktLayers...); err != nil {
+		return fmt.Errorf("reject: error building packet: %s", err)
+	}
+
+	// IPPROTO_RAW sockets include the IP header.
+	fd, err := syscall.Socket(family, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return fmt.Errorf("reject: error opening raw socket: %s", err)
+	}
+	defer syscall.Close(fd)
+
+	var sa syscall.Sockaddr
+	if family == syscall.AF_INET {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], dst.To4())
+		sa = sa4
+	} else {
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], dst.To16())
+		sa = sa6
+	}
+	if err := syscall.Sendto(fd, buf.Bytes(), 0, sa); err != nil {
+		return fmt.Errorf("reject: error sending packet: %s", err)
+	}
+	return nil
+}
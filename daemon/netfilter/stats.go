@@ -0,0 +1,63 @@
+package netfilter
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// QueueStats holds the counters of a netfilter queue, as reported by the
+// kernel in /proc/net/netfilter/nfnetlink_queue.
+type QueueStats struct {
+	ID uint16
+	// packets waiting for a verdict.
+	Length uint64
+	// packets dropped by the kernel because the queue was full, and because
+	// they couldn't be sent to the daemon.
+	Dropped     uint64
+	UserDropped uint64
+}
+
+const queueStatsPath = "/proc/net/netfilter/nfnetlink_queue"
+
+// packets not delivered to the daemon in time, whose verdict is the default
+// one of the queue.
+var queueTimeouts uint64
+
+// GetQueueStats returns the counters of the netfilter queues in use.
+func GetQueueStats() ([]QueueStats, error) {
+	f, err := os.Open(queueStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var stats []QueueStats
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// queue_number peer_portid queue_total copy_mode copy_range queue_dropped user_dropped id_sequence 1
+		//     0        12345          0        2        4096        0            0           1200     1
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			continue
+		}
+		st := QueueStats{ID: uint16(id)}
+		st.Length, _ = strconv.ParseUint(fields[2], 10, 64)
+		st.Dropped, _ = strconv.ParseUint(fields[5], 10, 64)
+		st.UserDropped, _ = strconv.ParseUint(fields[6], 10, 64)
+		stats = append(stats, st)
+	}
+	return stats, scanner.Err()
+}
+
+// GetQueueTimeouts returns the number of packets that couldn't be sent to the
+// daemon in time.
+func GetQueueTimeouts() uint64 {
+	return atomic.LoadUint64(&queueTimeouts)
+}
@@ -0,0 +1,246 @@
+package netlink
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// The kernel notifies the connections tracked by conntrack that are closed,
+// with the bytes and packets transferred in each direction, over a netlink
+// socket (ctnetlink):
+// https://elixir.bootlin.com/linux/latest/source/include/uapi/linux/netfilter/nfnetlink_conntrack.h
+// The counters and the timestamps are only added if the accounting and the
+// timestamps of conntrack are enabled (nf_conntrack_acct, nf_conntrack_timestamp).
+
+const (
+	netlinkNetfilter        = 12
+	nfnlSubsysCtnetlink     = 1
+	ipctnlMsgCtDelete       = 2
+	nfnlgrpConntrackDestroy = 3
+	sizeofNfgenmsg          = 4
+
+	nlaTypeMask = 0x3fff
+
+	ctaTupleOrig      = 1
+	ctaTupleReply     = 2
+	ctaCountersOrig   = 9
+	ctaCountersReply  = 10
+	ctaTimestamp      = 20
+	ctaTupleIP        = 1
+	ctaTupleProto     = 2
+	ctaIPv4Src        = 1
+	ctaIPv4Dst        = 2
+	ctaIPv6Src        = 3
+	ctaIPv6Dst        = 4
+	ctaProtoNum       = 1
+	ctaProtoSrcPort   = 2
+	ctaProtoDstPort   = 3
+	ctaCountersPkts   = 1
+	ctaCountersBytes  = 2
+	ctaTimestampStart = 1
+	ctaTimestampStop  = 2
+)
+
+var conntrackSysctls = []string{
+	"/proc/sys/net/netfilter/nf_conntrack_acct",
+	"/proc/sys/net/netfilter/nf_conntrack_timestamp",
+}
+
+// ConntrackTuple is the direction of a connection tracked by conntrack.
+type ConntrackTuple struct {
+	Protocol uint8
+	SrcIP    net.IP
+	DstIP    net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	Bytes    uint64
+	Packets  uint64
+}
+
+// ConntrackEvent is a connection closed. Orig is the direction of the
+// connection, and Reply the direction of the responses.
+type ConntrackEvent struct {
+	Orig  ConntrackTuple
+	Reply ConntrackTuple
+	// zero if the timestamps are disabled.
+	Start time.Time
+	Stop  time.Time
+}
+
+// EnableConntrackAccounting enables the counters and the timestamps of the
+// connections tracked by conntrack, and returns the values of the ones that
+// were disabled, to restore them with RestoreConntrackAccounting.
+func EnableConntrackAccounting() (saved map[string][]byte) {
+	saved = make(map[string][]byte)
+	for _, path := range conntrackSysctls {
+		old, err := ioutil.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(old)) == "1" {
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte("1"), 0644); err != nil {
+			log.Warning("Unable to enable conntrack accounting, %s: %s", path, err)
+			continue
+		}
+		if old != nil {
+			saved[path] = old
+		}
+	}
+	return saved
+}
+
+// RestoreConntrackAccounting restores the counters and the timestamps of
+// conntrack to the values they had before enabling them.
+func RestoreConntrackAccounting(saved map[string][]byte) {
+	for path, old := range saved {
+		if err := ioutil.WriteFile(path, old, 0644); err != nil {
+			log.Warning("Unable to restore conntrack accounting, %s: %s", path, err)
+		}
+	}
+}
+
+// ListenConntrackEvents subscribes to the connections closed, until the
+// context is cancelled. It requires CAP_NET_ADMIN.
+func ListenConntrackEvents(ctx context.Context) (<-chan ConntrackEvent, error) {
+	fd, err := subscribe(syscall.SOCK_RAW, netlinkNetfilter, 1<<(nfnlgrpConntrackDestroy-1))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan ConntrackEvent, 1024)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+
+		// the events lost because we didn't read them fast enough are
+		// not notified again.
+		receive(ctx, fd, 64*1024, "conntrack events", func(data []byte) bool {
+			for _, ev := range parseConntrackEvents(data) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}, nil)
+	}()
+
+	return events, nil
+}
+
+// parseConntrackEvents parses the netlink messages of the connections closed.
+func parseConntrackEvents(data []byte) (events []ConntrackEvent) {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if m.Header.Type != nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete || len(m.Data) < sizeofNfgenmsg {
+			continue
+		}
+		var ev ConntrackEvent
+		for _, a := range parseAttrs(m.Data[sizeofNfgenmsg:]) {
+			switch a.typ {
+			case ctaTupleOrig:
+				parseConntrackTuple(a.data, &ev.Orig)
+			case ctaTupleReply:
+				parseConntrackTuple(a.data, &ev.Reply)
+			case ctaCountersOrig:
+				parseConntrackCounters(a.data, &ev.Orig)
+			case ctaCountersReply:
+				parseConntrackCounters(a.data, &ev.Reply)
+			case ctaTimestamp:
+				for _, ts := range parseAttrs(a.data) {
+					if len(ts.data) < 8 {
+						continue
+					}
+					t := time.Unix(0, int64(binary.BigEndian.Uint64(ts.data)))
+					if ts.typ == ctaTimestampStart {
+						ev.Start = t
+					} else if ts.typ == ctaTimestampStop {
+						ev.Stop = t
+					}
+				}
+			}
+		}
+		if ev.Orig.SrcIP == nil {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func parseConntrackTuple(data []byte, t *ConntrackTuple) {
+	for _, a := range parseAttrs(data) {
+		switch a.typ {
+		case ctaTupleIP:
+			for _, ip := range parseAttrs(a.data) {
+				switch ip.typ {
+				case ctaIPv4Src, ctaIPv6Src:
+					t.SrcIP = net.IP(append([]byte{}, ip.data...))
+				case ctaIPv4Dst, ctaIPv6Dst:
+					t.DstIP = net.IP(append([]byte{}, ip.data...))
+				}
+			}
+		case ctaTupleProto:
+			for _, p := range parseAttrs(a.data) {
+				switch {
+				case p.typ == ctaProtoNum && len(p.data) >= 1:
+					t.Protocol = p.data[0]
+				case p.typ == ctaProtoSrcPort && len(p.data) >= 2:
+					t.SrcPort = binary.BigEndian.Uint16(p.data)
+				case p.typ == ctaProtoDstPort && len(p.data) >= 2:
+					t.DstPort = binary.BigEndian.Uint16(p.data)
+				}
+			}
+		}
+	}
+}
+
+func parseConntrackCounters(data []byte, t *ConntrackTuple) {
+	for _, a := range parseAttrs(data) {
+		if len(a.data) < 8 {
+			continue
+		}
+		switch a.typ {
+		case ctaCountersPkts:
+			t.Packets = binary.BigEndian.Uint64(a.data)
+		case ctaCountersBytes:
+			t.Bytes = binary.BigEndian.Uint64(a.data)
+		}
+	}
+}
+
+type netlinkAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseAttrs parses a list of netlink attributes, nested or not.
+func parseAttrs(data []byte) (attrs []netlinkAttr) {
+	for len(data) >= syscall.NLA_HDRLEN {
+		l := int(native.Uint16(data[0:2]))
+		if l < syscall.NLA_HDRLEN || l > len(data) {
+			break
+		}
+		attrs = append(attrs, netlinkAttr{
+			typ:  native.Uint16(data[2:4]) & nlaTypeMask,
+			data: data[syscall.NLA_HDRLEN:l],
+		})
+		// the attributes are aligned to 4 bytes.
+		l = (l + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+		if l > len(data) {
+			break
+		}
+		data = data[l:]
+	}
+	return attrs
+}
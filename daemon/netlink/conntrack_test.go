@@ -0,0 +1,131 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// nlAttr builds a netlink attribute, padded to 4 bytes.
+func nlAttr(typ uint16, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	attr := new(bytes.Buffer)
+	binary.Write(attr, native, []uint16{uint16(syscall.NLA_HDRLEN + len(payload)), typ})
+	attr.Write(payload)
+	for attr.Len()%syscall.NLA_ALIGNTO != 0 {
+		attr.WriteByte(0)
+	}
+	return attr.Bytes()
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func conntrackTuple(typ uint16, src, dst net.IP, sport, dport uint16) []byte {
+	return nlAttr(typ|0x8000,
+		nlAttr(ctaTupleIP|0x8000, nlAttr(ctaIPv4Src, src.To4()), nlAttr(ctaIPv4Dst, dst.To4())),
+		nlAttr(ctaTupleProto|0x8000, nlAttr(ctaProtoNum, []byte{syscall.IPPROTO_TCP}), nlAttr(ctaProtoSrcPort, be16(sport)), nlAttr(ctaProtoDstPort, be16(dport))),
+	)
+}
+
+// conntrackMsg builds a netlink message of a connection closed.
+func conntrackMsg(msgType uint16, attrs ...[]byte) []byte {
+	payload := append([]byte{syscall.AF_INET, 0, 0, 0}, bytes.Join(attrs, nil)...)
+	msg := new(bytes.Buffer)
+	binary.Write(msg, native, syscall.NlMsghdr{
+		Len:  uint32(syscall.NLMSG_HDRLEN + len(payload)),
+		Type: msgType,
+	})
+	msg.Write(payload)
+	return msg.Bytes()
+}
+
+func TestParseConntrackEvents(t *testing.T) {
+	local, remote := net.ParseIP("192.168.1.10"), net.ParseIP("1.1.1.1")
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	stop := time.Now().Truncate(time.Second)
+
+	var data []byte
+	data = append(data, conntrackMsg(nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete,
+		conntrackTuple(ctaTupleOrig, local, remote, 40000, 443),
+		conntrackTuple(ctaTupleReply, remote, local, 443, 40000),
+		nlAttr(ctaCountersOrig|0x8000, nlAttr(ctaCountersPkts, be64(10)), nlAttr(ctaCountersBytes, be64(1500))),
+		nlAttr(ctaCountersReply|0x8000, nlAttr(ctaCountersPkts, be64(20)), nlAttr(ctaCountersBytes, be64(30000))),
+		nlAttr(ctaTimestamp|0x8000, nlAttr(ctaTimestampStart, be64(uint64(start.UnixNano()))), nlAttr(ctaTimestampStop, be64(uint64(stop.UnixNano())))),
+	)...)
+	// new connections are ignored
+	data = append(data, conntrackMsg(nfnlSubsysCtnetlink<<8|0,
+		conntrackTuple(ctaTupleOrig, local, remote, 40001, 443),
+	)...)
+	// without accounting nor timestamps
+	data = append(data, conntrackMsg(nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete,
+		conntrackTuple(ctaTupleOrig, local, remote, 40002, 80),
+	)...)
+
+	events := parseConntrackEvents(data)
+	if len(events) != 2 {
+		t.Fatal("Invalid number of events parsed:", events)
+	}
+	ev := events[0]
+	if !ev.Orig.SrcIP.Equal(local) || !ev.Orig.DstIP.Equal(remote) || ev.Orig.SrcPort != 40000 || ev.Orig.DstPort != 443 || ev.Orig.Protocol != syscall.IPPROTO_TCP {
+		t.Error("Invalid tuple of the connection:", ev.Orig)
+	}
+	if !ev.Reply.SrcIP.Equal(remote) || ev.Reply.SrcPort != 443 {
+		t.Error("Invalid tuple of the responses:", ev.Reply)
+	}
+	if ev.Orig.Bytes != 1500 || ev.Orig.Packets != 10 || ev.Reply.Bytes != 30000 || ev.Reply.Packets != 20 {
+		t.Error("Invalid counters:", ev.Orig, ev.Reply)
+	}
+	if !ev.Start.Equal(start) || !ev.Stop.Equal(stop) {
+		t.Error("Invalid timestamps:", ev.Start, ev.Stop)
+	}
+	if ev := events[1]; ev.Orig.DstPort != 80 || ev.Orig.Bytes != 0 || !ev.Start.IsZero() {
+		t.Error("Invalid event without accounting:", ev)
+	}
+}
+
+func TestConntrackAccounting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netlink_test_conntrack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	acct, timestamp := filepath.Join(dir, "nf_conntrack_acct"), filepath.Join(dir, "nf_conntrack_timestamp")
+	ioutil.WriteFile(acct, []byte("0\n"), 0644)
+	ioutil.WriteFile(timestamp, []byte("1\n"), 0644)
+	defer func(sysctls []string) { conntrackSysctls = sysctls }(conntrackSysctls)
+	conntrackSysctls = []string{acct, timestamp}
+
+	saved := EnableConntrackAccounting()
+	for _, path := range conntrackSysctls {
+		if value, _ := ioutil.ReadFile(path); string(value) != "1" && string(value) != "1\n" {
+			t.Errorf("%s not enabled: %q", path, value)
+		}
+	}
+	if len(saved) != 1 {
+		t.Errorf("only the sysctls disabled must be saved: %v", saved)
+	}
+
+	RestoreConntrackAccounting(saved)
+	if value, _ := ioutil.ReadFile(acct); string(value) != "0\n" {
+		t.Errorf("nf_conntrack_acct not restored: %q", value)
+	}
+	if value, _ := ioutil.ReadFile(timestamp); string(value) != "1\n" {
+		t.Errorf("nf_conntrack_timestamp modified: %q", value)
+	}
+}
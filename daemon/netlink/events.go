@@ -0,0 +1,64 @@
+package netlink
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// The proc connector and conntrack notify their events over multicast
+// netlink sockets, which are read the same way until the listener is stopped.
+
+// subscribe opens a netlink socket of the given protocol, and binds it to the
+// given multicast groups.
+func subscribe(sockType, proto int, groups uint32) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, sockType|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return -1, err
+	}
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	// don't block on the reads forever, to stop listening when the context is
+	// cancelled.
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	return fd, nil
+}
+
+// receive reads the messages of the socket and passes them to onData, until
+// the context is cancelled, the socket fails or onData returns false.
+// onLost is called when the kernel has discarded messages because we didn't
+// read them fast enough. If it's nil, they're ignored.
+func receive(ctx context.Context, fd, bufSize int, tag string, onData func([]byte) bool, onLost func() bool) {
+	buf := make([]byte, bufSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.ENOBUFS {
+			if onLost != nil && !onLost() {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Warning("%s error: %s", tag, err)
+			return
+		}
+		if !onData(buf[:n]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package netlink
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	vnetlink "github.com/vishvananda/netlink"
+)
+
+// The MAC addresses of the hosts of the local networks are read from the
+// neighbor table (ARP, NDP) of the kernel, and cached for a few seconds, to not
+// query the kernel on every connection.
+
+var (
+	macCacheTTL = 10 * time.Second
+
+	macCacheLock sync.Mutex
+	macCache     = make(map[string]macEntry)
+)
+
+type macEntry struct {
+	mac     string
+	expires time.Time
+}
+
+// GetMAC returns the MAC address of an IP: the address of the local interface
+// if the IP is local, or the address of the neighbor table. It's empty if the
+// IP is not in the local networks, or it's not resolved yet.
+func GetMAC(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return cachedMAC("ip:"+ip.String(), func() string {
+		if mac := localMAC(ip); mac != "" {
+			return mac
+		}
+		route, err := getRoute(ip)
+		if err != nil {
+			return ""
+		}
+		return neighMAC(ip, route.LinkIndex)
+	})
+}
+
+// GetNextHopMAC returns the MAC address of the next hop to reach an IP: the
+// gateway of the route to the IP, or the IP itself if it's on the local
+// network.
+func GetNextHopMAC(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return cachedMAC("hop:"+ip.String(), func() string {
+		if mac := localMAC(ip); mac != "" {
+			return mac
+		}
+		route, err := getRoute(ip)
+		if err != nil {
+			return ""
+		}
+		if route.Gw != nil {
+			return neighMAC(route.Gw, route.LinkIndex)
+		}
+		return neighMAC(ip, route.LinkIndex)
+	})
+}
+
+func cachedMAC(key string, resolve func() string) string {
+	now := time.Now()
+	macCacheLock.Lock()
+	e, found := macCache[key]
+	macCacheLock.Unlock()
+	if found && now.Before(e.expires) {
+		return e.mac
+	}
+
+	mac := resolve()
+	macCacheLock.Lock()
+	for k, e := range macCache {
+		if now.After(e.expires) {
+			delete(macCache, k)
+		}
+	}
+	macCache[key] = macEntry{mac: mac, expires: now.Add(macCacheTTL)}
+	macCacheLock.Unlock()
+	return mac
+}
+
+func getRoute(ip net.IP) (*vnetlink.Route, error) {
+	routes, err := vnetlink.RouteGet(ip)
+	if err != nil || len(routes) == 0 {
+		return nil, err
+	}
+	return &routes[0], nil
+}
+
+// neighMAC returns the MAC address of an IP of the neighbor table of an
+// interface.
+func neighMAC(ip net.IP, linkIndex int) string {
+	family := vnetlink.FAMILY_V4
+	if ip.To4() == nil {
+		family = vnetlink.FAMILY_V6
+	}
+	neighs, err := vnetlink.NeighList(linkIndex, family)
+	if err != nil {
+		return ""
+	}
+	for _, n := range neighs {
+		if n.IP.Equal(ip) && len(n.HardwareAddr) > 0 && n.State&(vnetlink.NUD_FAILED|vnetlink.NUD_INCOMPLETE) == 0 {
+			return n.HardwareAddr.String()
+		}
+	}
+	return ""
+}
+
+// localMAC returns the MAC address of the local interface with the given IP.
+func localMAC(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface.HardwareAddr.String()
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package netlink
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetMAC(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip("Unable to list the interfaces:", err)
+	}
+	tested := 0
+	for _, iface := range ifaces {
+		if len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			tested++
+			if mac := GetMAC(ipnet.IP); mac != iface.HardwareAddr.String() {
+				t.Error("Invalid MAC of a local IP:", ipnet.IP, mac, iface.HardwareAddr)
+			}
+			if mac := GetNextHopMAC(ipnet.IP); mac != iface.HardwareAddr.String() {
+				t.Error("Invalid next hop MAC of a local IP:", ipnet.IP, mac, iface.HardwareAddr)
+			}
+		}
+	}
+	if tested == 0 {
+		t.Log("No interfaces with MAC addresses to test")
+	}
+
+	// the hosts out of the local networks don't have MAC addresses, the
+	// next hop is the gateway.
+	remote := net.ParseIP("203.0.113.1")
+	if mac := GetMAC(remote); mac != "" {
+		t.Error("MAC of a remote IP:", mac)
+	}
+	if route, err := getRoute(remote); err == nil && route != nil && route.Gw != nil {
+		if mac := GetNextHopMAC(remote); mac != GetMAC(route.Gw) {
+			t.Error("Invalid next hop MAC of a remote IP:", mac, route.Gw)
+		}
+	}
+	if mac := GetMAC(nil); mac != "" {
+		t.Error("MAC of a nil IP:", mac)
+	}
+}
@@ -0,0 +1,157 @@
+package netlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// The kernel proc connector notifies the processes created (fork), executed
+// (exec) and exited, over a netlink socket:
+// https://elixir.bootlin.com/linux/latest/source/include/uapi/linux/cn_proc.h
+
+const (
+	netlinkConnector  = 11
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+
+	sizeofCnMsg     = 20
+	sizeofProcEvent = 16
+)
+
+// Types of the events of the proc connector.
+const (
+	ProcEventFork = 0x00000001
+	ProcEventExec = 0x00000002
+	ProcEventExit = 0x80000000
+	// ProcEventLost is sent when the kernel has dropped events because we
+	// didn't read them fast enough, so the state of the processes must be read
+	// again.
+	ProcEventLost = 0xffffffff
+)
+
+// ProcEvent is an event of the proc connector.
+type ProcEvent struct {
+	What uint32
+	// PID (tgid) and thread ID of the process. For the fork events, of the child.
+	PID int
+	TID int
+	// PID of the parent, for the fork events.
+	ParentPID int
+	ExitCode  uint32
+}
+
+// IsThread returns true if the event is of a thread, not of a process.
+func (e *ProcEvent) IsThread() bool {
+	return e.PID != e.TID
+}
+
+// ListenProcEvents subscribes to the events of the proc connector, until the
+// context is cancelled. It requires CAP_NET_ADMIN.
+func ListenProcEvents(ctx context.Context) (<-chan ProcEvent, error) {
+	fd, err := subscribe(syscall.SOCK_DGRAM, netlinkConnector, cnIdxProc)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendProcMcastOp(fd, procCnMcastListen); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("unable to subscribe to the proc connector: %s", err)
+	}
+
+	events := make(chan ProcEvent, 1024)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		defer sendProcMcastOp(fd, procCnMcastIgnore)
+
+		send := func(ev ProcEvent) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		receive(ctx, fd, syscall.Getpagesize(), "proc connector",
+			func(data []byte) bool {
+				for _, ev := range parseProcEvents(data) {
+					if !send(ev) {
+						return false
+					}
+				}
+				return true
+			},
+			func() bool {
+				return send(ProcEvent{What: ProcEventLost})
+			})
+	}()
+
+	return events, nil
+}
+
+func sendProcMcastOp(fd int, op uint32) error {
+	buf := bytes.NewBuffer(make([]byte, 0, syscall.NLMSG_HDRLEN+sizeofCnMsg+4))
+	binary.Write(buf, native, syscall.NlMsghdr{
+		Len:  uint32(syscall.NLMSG_HDRLEN + sizeofCnMsg + 4),
+		Type: uint16(syscall.NLMSG_DONE),
+	})
+	// cn_msg: id (idx, val), seq, ack, len, flags
+	binary.Write(buf, native, []uint32{cnIdxProc, cnValProc, 0, 0})
+	binary.Write(buf, native, []uint16{4, 0})
+	binary.Write(buf, native, op)
+
+	return syscall.Sendto(fd, buf.Bytes(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+// parseProcEvents parses the netlink messages of the proc connector.
+// Only the fork, exec and exit events are returned.
+func parseProcEvents(data []byte) (events []ProcEvent) {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if len(m.Data) < sizeofCnMsg+sizeofProcEvent {
+			continue
+		}
+		if native.Uint32(m.Data[0:4]) != cnIdxProc || native.Uint32(m.Data[4:8]) != cnValProc {
+			continue
+		}
+		// proc_event: what, cpu, timestamp_ns, event_data
+		ev := m.Data[sizeofCnMsg:]
+		what := native.Uint32(ev[0:4])
+		d := ev[sizeofProcEvent:]
+		switch what {
+		case ProcEventFork:
+			// parent_pid, parent_tgid, child_pid, child_tgid
+			if len(d) < 16 {
+				continue
+			}
+			events = append(events, ProcEvent{
+				What:      what,
+				ParentPID: int(native.Uint32(d[4:8])),
+				TID:       int(native.Uint32(d[8:12])),
+				PID:       int(native.Uint32(d[12:16])),
+			})
+		case ProcEventExec, ProcEventExit:
+			// process_pid, process_tgid[, exit_code, exit_signal]
+			if len(d) < 8 {
+				continue
+			}
+			e := ProcEvent{
+				What: what,
+				TID:  int(native.Uint32(d[0:4])),
+				PID:  int(native.Uint32(d[4:8])),
+			}
+			if what == ProcEventExit && len(d) >= 12 {
+				e.ExitCode = native.Uint32(d[8:12])
+			}
+			events = append(events, e)
+		}
+	}
+	return events
+}
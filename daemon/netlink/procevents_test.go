@@ -0,0 +1,84 @@
+package netlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// procEventMsg builds a netlink message of the proc connector.
+func procEventMsg(what uint32, data ...uint32) []byte {
+	payload := new(bytes.Buffer)
+	binary.Write(payload, native, []uint32{cnIdxProc, cnValProc, 0, 0})
+	binary.Write(payload, native, []uint16{uint16(sizeofProcEvent + 4*len(data)), 0})
+	binary.Write(payload, native, []uint32{what, 0})
+	binary.Write(payload, native, uint64(0))
+	binary.Write(payload, native, data)
+
+	msg := new(bytes.Buffer)
+	binary.Write(msg, native, syscall.NlMsghdr{
+		Len:  uint32(syscall.NLMSG_HDRLEN + payload.Len()),
+		Type: uint16(syscall.NLMSG_DONE),
+	})
+	msg.Write(payload.Bytes())
+	return msg.Bytes()
+}
+
+func TestParseProcEvents(t *testing.T) {
+	var data []byte
+	// parent_pid, parent_tgid, child_pid, child_tgid
+	data = append(data, procEventMsg(ProcEventFork, 100, 100, 200, 200)...)
+	// process_pid, process_tgid
+	data = append(data, procEventMsg(ProcEventExec, 200, 200)...)
+	// thread of the process 200
+	data = append(data, procEventMsg(ProcEventFork, 200, 200, 201, 200)...)
+	// process_pid, process_tgid, exit_code, exit_signal
+	data = append(data, procEventMsg(ProcEventExit, 200, 200, 256, 17)...)
+	// other events are ignored (uid changes)
+	data = append(data, procEventMsg(0x00000004, 200, 200, 1000, 1000)...)
+
+	events := parseProcEvents(data)
+	if len(events) != 4 {
+		t.Fatal("Invalid number of events parsed:", events)
+	}
+	if ev := events[0]; ev.What != ProcEventFork || ev.PID != 200 || ev.ParentPID != 100 || ev.IsThread() {
+		t.Error("Invalid fork event:", ev)
+	}
+	if ev := events[1]; ev.What != ProcEventExec || ev.PID != 200 || ev.TID != 200 {
+		t.Error("Invalid exec event:", ev)
+	}
+	if ev := events[2]; !ev.IsThread() || ev.PID != 200 || ev.TID != 201 {
+		t.Error("Invalid fork event of a thread:", ev)
+	}
+	if ev := events[3]; ev.What != ProcEventExit || ev.PID != 200 || ev.ExitCode != 256 {
+		t.Error("Invalid exit event:", ev)
+	}
+}
+
+func TestListenProcEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := ListenProcEvents(ctx)
+	if err != nil {
+		t.Skip("Unable to listen to the proc connector:", err)
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("Unable to execute true:", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.What == ProcEventExec && ev.PID == cmd.Process.Pid {
+				return
+			}
+		case <-timeout:
+			t.Skip("exec event not received, the proc connector may not be available")
+		}
+	}
+}
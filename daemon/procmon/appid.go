@@ -0,0 +1,102 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Types of the sandboxed and packaged applications.
+const (
+	AppFlatpak  = "flatpak"
+	AppSnap     = "snap"
+	AppAppImage = "appimage"
+)
+
+var (
+	// the processes of the applications run in the cgroups:
+	// app-flatpak-<appid>-<n>.scope, snap.<name>.<app>[-<uuid>].(scope|service)
+	reFlatpakCgroup = regexp.MustCompile(`/app-flatpak-(.+)-[0-9]+\.scope$`)
+	reSnapCgroup    = regexp.MustCompile(`/snap\.([a-z0-9-]+)\.[^/]*\.(?:scope|service)$`)
+	// /snap/<name>/<revision>/...
+	reSnapPath = regexp.MustCompile(`^/snap/([^/]+)/[^/]+/`)
+)
+
+// ReadAppID reads the identity of the application of the process, if it's a
+// flatpak, snap or AppImage application, which doesn't change with the
+// versions of the application, nor with the paths where it's mounted:
+// the ID of the flatpak (org.mozilla.firefox), the name of the snap (firefox),
+// or the path to the AppImage file.
+func (p *Process) ReadAppID() {
+	if info, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/root/.flatpak-info")); err == nil {
+		if id := parseFlatpakInfo(string(info)); id != "" {
+			p.AppID, p.AppType = id, AppFlatpak
+			return
+		}
+	}
+	if cgroup, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/cgroup")); err == nil {
+		if appType, id := parseAppCgroup(string(cgroup)); id != "" {
+			p.AppID, p.AppType = id, appType
+			return
+		}
+	}
+	if m := reSnapPath.FindStringSubmatch(p.Path); m != nil {
+		p.AppID, p.AppType = m[1], AppSnap
+		return
+	}
+	// the AppImages are mounted in /tmp/.mount_<name><random>, and the
+	// runtime exports the path of the file to the processes.
+	if environ, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/environ")); err == nil {
+		if path := getEnviron(string(environ), "APPIMAGE"); path != "" {
+			p.AppID, p.AppType = path, AppAppImage
+		}
+	}
+}
+
+// parseFlatpakInfo returns the ID of the application of a .flatpak-info file:
+// [Application]
+// name=org.mozilla.firefox
+func parseFlatpakInfo(info string) string {
+	section := ""
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		if section == "[Application]" && strings.HasPrefix(line, "name=") {
+			return strings.TrimPrefix(line, "name=")
+		}
+	}
+	return ""
+}
+
+// parseAppCgroup returns the type and the ID of the application of the
+// cgroups of a process, if any.
+func parseAppCgroup(cgroup string) (appType, id string) {
+	for _, line := range strings.Split(cgroup, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if m := reFlatpakCgroup.FindStringSubmatch(fields[2]); m != nil {
+			// systemd escapes the dashes of the unit names.
+			return AppFlatpak, strings.Replace(m[1], `\x2d`, "-", -1)
+		}
+		if m := reSnapCgroup.FindStringSubmatch(fields[2]); m != nil {
+			return AppSnap, m[1]
+		}
+	}
+	return "", ""
+}
+
+// getEnviron returns the value of a variable of the environment of a process.
+func getEnviron(environ, name string) string {
+	for _, v := range strings.Split(environ, "\x00") {
+		if strings.HasPrefix(v, name+"=") {
+			return v[len(name)+1:]
+		}
+	}
+	return ""
+}
@@ -13,6 +13,7 @@
 // - auditctl -a always,exit -F arch=b64 -S socket,connect,execve -k opensnitchd
 // - increase /etc/audisp/audispd.conf q_depth if there're dropped events
 // - set write_logs to no if you don't need/want audit logs to be stored in the disk.
+//   They're used to replay the events received while auditd was being restarted.
 //
 // read messages from the pipe to verify that it's working:
 // socat unix-connect:/var/run/audispd_events stdio
@@ -34,6 +35,7 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -96,6 +98,16 @@ var (
 	rule64      = []string{"exit,always", "-F", "arch=b64", "-F", fmt.Sprint("ppid!=", ourPid), "-F", fmt.Sprint("pid!=", ourPid), "-S", "socket,connect", "-k", "opensnitch"}
 	rule32      = []string{"exit,always", "-F", "arch=b32", "-F", fmt.Sprint("ppid!=", ourPid), "-F", fmt.Sprint("pid!=", ourPid), "-S", "socketcall", "-F", "a0=1", "-k", "opensnitch"}
 	audispdPath = "/var/run/audispd_events"
+
+	// delays between the reconnections to auditd, doubled after every failed
+	// attempt.
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+	// minimum time between the installations of the rules, in order not to
+	// flood auditd if it's restarted in a loop.
+	minRulesInterval = 30 * time.Second
+	rulesAddedAt     time.Time
+	connLock         sync.Mutex
 )
 
 // OPENSNITCH_RULES_KEY is the mark we place on every event we are interested in.
@@ -214,6 +226,15 @@ Exit:
 }
 
 func addRules() bool {
+	if checkRules() {
+		log.Debug("audit rules already installed")
+		return true
+	}
+	if !rulesAddedAt.IsZero() && time.Since(rulesAddedAt) < minRulesInterval {
+		log.Debug("audit rules installed %s ago, not adding them yet", time.Since(rulesAddedAt))
+		return false
+	}
+	rulesAddedAt = time.Now()
 	r64 := append([]string{"-A"}, rule64...)
 	r32 := append([]string{"-A"}, rule32...)
 	_, err64 := core.Exec("auditctl", r64)
@@ -240,15 +261,27 @@ func deleteRules() bool {
 	_, err64 := core.Exec("auditctl", r64)
 	_, err32 := core.Exec("auditctl", r32)
 	if err64 == nil && err32 == nil {
+		rulesAddedAt = time.Time{}
 		return true
 	}
 	log.Error("Error deleting audit rules, err32=%v, err64=%v", err32, err64)
 	return false
 }
 
+// checkRules returns true if our rules are loaded in the kernel. They're
+// deleted if auditd is restarted and loads its rules with -D.
 func checkRules() bool {
-	// TODO
-	return true
+	out, err := core.Exec("auditctl", []string{"-l"})
+	if err != nil {
+		return false
+	}
+	rules := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "key=opensnitch") || strings.Contains(line, "-k opensnitch") {
+			rules++
+		}
+	}
+	return rules >= 2
 }
 
 func checkStatus() bool {
@@ -274,16 +307,16 @@ func Reader(r io.Reader, eventChan chan<- Event) {
 		default:
 			buf, _, err := reader.ReadLine()
 			if err != nil {
-				if err == io.EOF {
-					log.Error("AuditReader: auditd stopped, reconnecting in 30s %s", err)
-					if newReader, err := reconnect(); err == nil {
-						reader = bufio.NewReader(newReader)
-						log.Important("Auditd reconnected, continue reading")
-					}
-					continue
+				log.Error("AuditReader: auditd stopped, reconnecting: %s", err)
+				disconnected := time.Now()
+				newReader, err := reconnect()
+				if err != nil {
+					goto Exit
 				}
-				log.Warning("AuditReader: auditd error %s", err)
-				break
+				reader = bufio.NewReader(newReader)
+				log.Important("Auditd reconnected after %s, continue reading", time.Since(disconnected).Round(time.Second))
+				replayEvents(eventChan)
+				continue
 			}
 
 			parseEvent(string(buf[0:len(buf)]), eventChan)
@@ -300,10 +333,46 @@ func StartChannel() {
 	EventChan = make(chan Event, 0)
 }
 
+// reconnect connects again to the audisp socket, until it succeeds or
+// the reader is stopped.
 func reconnect() (net.Conn, error) {
-	deleteRules()
-	time.Sleep(30 * time.Second)
-	return connect()
+	delay := reconnectMinDelay
+	for {
+		select {
+		case <-eventsExitChan:
+			return nil, fmt.Errorf("audit reader stopped")
+		case <-time.After(delay):
+		}
+		conn, err := connect()
+		if err == nil {
+			connLock.Lock()
+			auditConn = conn
+			connLock.Unlock()
+			return conn, nil
+		}
+		log.Debug("audit reconnection error, retrying in %s: %s", delay, err)
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// replayEvents reads the events of the last minutes from the audit logs, to
+// know the processes that opened connections while we were disconnected.
+// It requires auditd to write the events to disk (write_logs = yes).
+func replayEvents(eventChan chan<- Event) {
+	out, err := core.Exec("ausearch", []string{"--raw", "-k", "opensnitch", "-ts", "recent"})
+	if err != nil {
+		log.Debug("audit: unable to replay the recent events: %s", err)
+		return
+	}
+	lines := strings.Split(out, "\n")
+	newEvent = false
+	for _, line := range lines {
+		parseEvent(line, eventChan)
+	}
+	newEvent = false
+	log.Debug("audit: %d recent messages replayed", len(lines))
 }
 
 func connect() (net.Conn, error) {
@@ -312,13 +381,21 @@ func connect() (net.Conn, error) {
 	return net.Dial("unix", audispdPath)
 }
 
+// Available returns true if the af_unix plugin of auditd is listening.
+func Available() bool {
+	return core.Exists(audispdPath)
+}
+
 // Stop stops listening for events from auditd and delete the auditd rules.
 func Stop() {
+	connLock.Lock()
 	if auditConn != nil {
 		if err := auditConn.Close(); err != nil {
 			log.Warning("audit.Stop() error closing socket: %v", err)
 		}
+		auditConn = nil
 	}
+	connLock.Unlock()
 
 	if eventsCleaner != nil {
 		eventsCleaner.Stop()
@@ -340,16 +417,19 @@ func Stop() {
 
 // Start makes a new connection to the audisp af_unix socket.
 func Start() (net.Conn, error) {
-	auditConn, err := connect()
+	conn, err := connect()
 	if err != nil {
 		log.Error("auditd Start() connection error %v", err)
 		deleteRules()
 		return nil, err
 	}
+	connLock.Lock()
+	auditConn = conn
+	connLock.Unlock()
 
 	configureSyscalls()
 	eventsCleaner = time.NewTicker(time.Minute * 5)
 	eventsCleanerChan = make(chan bool)
 	eventsExitChan = make(chan bool)
-	return auditConn, err
+	return conn, err
 }
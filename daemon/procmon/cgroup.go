@@ -0,0 +1,61 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// types of the systemd units the processes can belong to, besides the slices.
+var systemdUnitTypes = []string{".service", ".scope", ".socket", ".mount", ".swap"}
+
+// ReadCgroup reads the cgroup of the process, and the systemd unit it belongs
+// to: /system.slice/ssh.service -> ssh.service
+func (p *Process) ReadCgroup() {
+	cgroup, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/cgroup"))
+	if err != nil {
+		return
+	}
+	p.Cgroup = parseCgroup(string(cgroup))
+	p.SystemdUnit = systemdUnit(p.Cgroup)
+}
+
+// parseCgroup returns the path of the cgroup of a process: the one of the
+// unified hierarchy (cgroup v2), or the one of the systemd hierarchy
+// (cgroup v1).
+func parseCgroup(cgroup string) string {
+	path := ""
+	for _, line := range strings.Split(cgroup, "\n") {
+		// hierarchy-ID:controllers:path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if (fields[0] == "0" && fields[1] == "") || fields[1] == "name=systemd" {
+			return fields[2]
+		}
+		if path == "" {
+			path = fields[2]
+		}
+	}
+	return path
+}
+
+// systemdUnit returns the systemd unit of a cgroup: the deepest unit
+// (service, scope, ...) of the path, or the deepest slice if there's none.
+// /user.slice/user-1000.slice/user@1000.service/app.slice/foo.service -> foo.service
+func systemdUnit(cgroup string) string {
+	slice := ""
+	parts := strings.Split(cgroup, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		for _, unitType := range systemdUnitTypes {
+			if strings.HasSuffix(parts[i], unitType) {
+				return parts[i]
+			}
+		}
+		if slice == "" && strings.HasSuffix(parts[i], ".slice") {
+			slice = parts[i]
+		}
+	}
+	return slice
+}
@@ -0,0 +1,109 @@
+package procmon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// checksumItem is the checksum of a binary, which is recomputed only if the
+// file changes.
+type checksumItem struct {
+	checksum string
+	modTime  time.Time
+	size     int64
+}
+
+var (
+	checksumsLock sync.RWMutex
+	// checksums of the binaries, by path.
+	checksums = make(map[string]*checksumItem)
+
+	// notifications of the binaries whose checksum has changed.
+	checksumEvents = make(chan string, 32)
+)
+
+// ChecksumEvents returns the channel where the changes of the checksums of the
+// binaries are notified.
+func ChecksumEvents() <-chan string {
+	return checksumEvents
+}
+
+// Checksum returns the SHA-256 of the binary of the process, or "" if it
+// can't be read.
+// The binary is read from /proc/<pid>/exe if the process is still running,
+// so the checksum is of the binary that was executed, even if it has been
+// replaced on disk, or it's inside a container.
+func (p *Process) Checksum() string {
+	binPath := fmt.Sprint("/proc/", p.ID, "/exe")
+	if _, err := os.Stat(binPath); err != nil {
+		binPath = p.Path
+		if p.HostPath != "" {
+			binPath = p.HostPath
+		}
+	}
+	return cachedChecksum(p.Path, binPath)
+}
+
+// PathChecksum returns the SHA-256 of a binary on disk, or "" if it can't be
+// read.
+func PathChecksum(path string) string {
+	return cachedChecksum(path, path)
+}
+
+// cachedChecksum returns the checksum of the binary of the given path, reading
+// it from binPath if it has changed since the last time.
+func cachedChecksum(path, binPath string) string {
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return ""
+	}
+
+	checksumsLock.RLock()
+	item, found := checksums[path]
+	checksumsLock.RUnlock()
+	if found && item.modTime.Equal(info.ModTime()) && item.size == info.Size() {
+		return item.checksum
+	}
+
+	checksum, err := fileChecksum(binPath)
+	if err != nil {
+		log.Debug("Error computing the checksum of %s: %s", path, err)
+		return ""
+	}
+
+	checksumsLock.Lock()
+	checksums[path] = &checksumItem{checksum: checksum, modTime: info.ModTime(), size: info.Size()}
+	checksumsLock.Unlock()
+
+	if found && item.checksum != checksum {
+		msg := fmt.Sprintf("The checksum of %s has changed: %s -> %s", path, item.checksum, checksum)
+		log.Warning(msg)
+		select {
+		case checksumEvents <- msg:
+		default:
+		}
+	}
+
+	return checksum
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,294 @@
+package procmon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// Container runtimes.
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+	RuntimeCrio       = "cri-o"
+)
+
+// Container is the container a process runs in.
+type Container struct {
+	ID      string
+	Runtime string
+	Name    string
+	Image   string
+	Labels  map[string]string
+	// Pod is the name of the Kubernetes pod of the container, if any.
+	Pod string
+}
+
+var (
+	// directories where the container runtimes store the configuration of
+	// the containers.
+	dockerContainersPath     = "/var/lib/docker/containers"
+	podmanContainersPath     = "/var/lib/containers/storage/overlay-containers"
+	containerdContainersPath = "/run/containerd/io.containerd.runtime.v2.task"
+
+	// the cgroups of the containers contain the ID of the container:
+	// /system.slice/docker-<id>.scope, /docker/<id>, /machine.slice/libpod-<id>.scope,
+	// /kubepods/besteffort/pod<uid>/<id>, cri-containerd-<id>.scope, crio-<id>.scope
+	reContainerCgroup = regexp.MustCompile(`(docker|libpod|cri-containerd|crio|kubepods)[-/](?:.*/)?([0-9a-f]{64})(?:\.scope)?$`)
+
+	// sockets of the APIs of the runtimes compatible with the API of docker,
+	// to read the details of the containers whose configuration is not found
+	// on disk (rootless containers, other data directories, ...).
+	containerAPISockets = map[string]string{
+		RuntimeDocker: "/var/run/docker.sock",
+		RuntimePodman: "/run/podman/podman.sock",
+	}
+	// the containers are read while the connections are queued, so don't
+	// wait for the runtimes for long.
+	containerAPITimeout = 500 * time.Millisecond
+
+	containersLock sync.RWMutex
+	// containers by ID. The containers without details are not cached, to be
+	// read again once the runtime has written them.
+	containers = make(map[string]*Container)
+)
+
+// Serialize transforms a Container object to gRPC protocol object.
+func (c *Container) Serialize() *protocol.Container {
+	if c == nil {
+		return nil
+	}
+	return &protocol.Container{
+		Id:      c.ID,
+		Runtime: c.Runtime,
+		Name:    c.Name,
+		Image:   c.Image,
+		Pod:     c.Pod,
+		Labels:  c.Labels,
+	}
+}
+
+// ReadContainer reads the container the process runs in, from its cgroups.
+// Container is nil if the process doesn't run in a container.
+func (p *Process) ReadContainer() {
+	cgroup, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/cgroup"))
+	if err != nil {
+		return
+	}
+	p.Container = getContainer(string(cgroup))
+}
+
+// getContainer returns the container of the cgroups of a process.
+func getContainer(cgroup string) *Container {
+	runtime, id := parseContainerCgroup(cgroup)
+	if id == "" {
+		return nil
+	}
+
+	containersLock.RLock()
+	c, found := containers[id]
+	containersLock.RUnlock()
+	if found {
+		return c
+	}
+
+	c = &Container{ID: id, Runtime: runtime, Labels: make(map[string]string)}
+	var err error
+	switch runtime {
+	case RuntimeDocker:
+		err = c.readDocker()
+	case RuntimePodman:
+		err = c.readPodman()
+	case RuntimeContainerd:
+		// the cgroups of the kubernetes pods don't tell the runtime when
+		// the cgroupfs driver is used.
+		if err = c.readContainerd(); err != nil && c.readDocker() == nil {
+			c.Runtime = RuntimeDocker
+			err = nil
+		}
+	}
+	if err != nil && c.readAPI() == nil {
+		err = nil
+	}
+	if err != nil {
+		log.Debug("Unable to read the details of the container %s (%s): %s", id, runtime, err)
+		return c
+	}
+
+	containersLock.Lock()
+	containers[id] = c
+	containersLock.Unlock()
+	return c
+}
+
+// parseContainerCgroup returns the runtime and the ID of the container of the
+// cgroups of a process, if any.
+func parseContainerCgroup(cgroup string) (runtime, id string) {
+	for _, line := range strings.Split(cgroup, "\n") {
+		// hierarchy-ID:controllers:path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		m := reContainerCgroup.FindStringSubmatch(fields[2])
+		if m == nil {
+			continue
+		}
+		switch m[1] {
+		case "docker":
+			runtime = RuntimeDocker
+		case "libpod":
+			runtime = RuntimePodman
+		case "crio":
+			runtime = RuntimeCrio
+		default:
+			runtime = RuntimeContainerd
+		}
+		return runtime, m[2]
+	}
+	return "", ""
+}
+
+// dockerConfig is the configuration of a docker container, as saved on disk
+// and as returned by the API.
+type dockerConfig struct {
+	Name   string
+	Config struct {
+		Image  string
+		Labels map[string]string
+	}
+}
+
+// readDocker reads the configuration of a docker container:
+// /var/lib/docker/containers/<id>/config.v2.json
+func (c *Container) readDocker() error {
+	var config dockerConfig
+	if err := readJSON(filepath.Join(dockerContainersPath, c.ID, "config.v2.json"), &config); err != nil {
+		return err
+	}
+	c.setDockerConfig(&config)
+	return nil
+}
+
+// readAPI reads the configuration of a docker or podman container from the API
+// of the runtime: GET /containers/<id>/json
+func (c *Container) readAPI() error {
+	socket, found := containerAPISockets[c.Runtime]
+	if !found {
+		return fmt.Errorf("no API for the runtime %s", c.Runtime)
+	}
+	client := &http.Client{
+		Timeout: containerAPITimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("http://localhost/containers/" + c.ID + "/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	var config dockerConfig
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return err
+	}
+	c.setDockerConfig(&config)
+	return nil
+}
+
+func (c *Container) setDockerConfig(config *dockerConfig) {
+	c.Name = strings.TrimPrefix(config.Name, "/")
+	c.Image = config.Config.Image
+	for k, v := range config.Config.Labels {
+		c.Labels[k] = v
+	}
+	c.Pod = c.Labels["io.kubernetes.pod.name"]
+}
+
+// readPodman reads the configuration of a podman container, from the list of
+// containers of the storage, and the OCI configuration of the container.
+func (c *Container) readPodman() error {
+	var list []struct {
+		ID       string   `json:"id"`
+		Names    []string `json:"names"`
+		Metadata string   `json:"metadata"`
+	}
+	if err := readJSON(filepath.Join(podmanContainersPath, "containers.json"), &list); err != nil {
+		return err
+	}
+	for _, item := range list {
+		if item.ID != c.ID {
+			continue
+		}
+		if len(item.Names) > 0 {
+			c.Name = item.Names[0]
+		}
+		var meta struct {
+			ImageName string `json:"image-name"`
+		}
+		if json.Unmarshal([]byte(item.Metadata), &meta) == nil {
+			c.Image = meta.ImageName
+		}
+		c.readAnnotations(filepath.Join(podmanContainersPath, c.ID, "userdata", "config.json"))
+		return nil
+	}
+	return fmt.Errorf("container not found")
+}
+
+// readContainerd reads the OCI configuration of a containerd container:
+// /run/containerd/io.containerd.runtime.v2.task/<namespace>/<id>/config.json
+func (c *Container) readContainerd() error {
+	matches, _ := filepath.Glob(filepath.Join(containerdContainersPath, "*", c.ID, "config.json"))
+	if len(matches) == 0 {
+		return fmt.Errorf("container not found")
+	}
+	if err := c.readAnnotations(matches[0]); err != nil {
+		return err
+	}
+	c.Name = c.Labels["io.kubernetes.cri.container-name"]
+	c.Image = c.Labels["io.kubernetes.cri.image-name"]
+	c.Pod = c.Labels["io.kubernetes.cri.sandbox-name"]
+	return nil
+}
+
+// readAnnotations adds the annotations of an OCI configuration to the labels
+// of the container.
+func (c *Container) readAnnotations(path string) error {
+	var config struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := readJSON(path, &config); err != nil {
+		return err
+	}
+	for k, v := range config.Annotations {
+		c.Labels[k] = v
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -43,6 +43,13 @@ func (p *Process) GetInfo() error {
 		return err
 	}
 	p.ReadEnv()
+	p.ReadParents()
+	p.ReadContainer()
+	p.ReadNamespace()
+	p.ReadSession()
+	p.ReadStartTime()
+	p.ReadAppID()
+	p.ReadCgroup()
 
 	return nil
 }
@@ -83,7 +90,31 @@ func (p *Process) ReadCwd() error {
 	return nil
 }
 
+// ReadPPID reads the PID of the parent of the process from ProcFS /proc/<pid>/stat
+func (p *Process) ReadPPID() error {
+	if p.PPID != 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/stat"))
+	if err != nil {
+		return err
+	}
+	// the comm field may contain spaces and parenthesis: 1234 (my (app)) S 1000 ...
+	stat := string(data)
+	idx := strings.LastIndexByte(stat, ')')
+	if idx == -1 {
+		return fmt.Errorf("Invalid stat of PID %d", p.ID)
+	}
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 2 {
+		return fmt.Errorf("Invalid stat of PID %d", p.ID)
+	}
+	p.PPID, err = strconv.Atoi(fields[1])
+	return err
+}
+
 // ReadEnv reads and parses the environment variables of a process.
+// Only the variables configured with SetEnvVars are kept, if any.
 func (p *Process) ReadEnv() {
 	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", p.ID))
 	if err != nil {
@@ -93,6 +124,9 @@ func (p *Process) ReadEnv() {
 		parts := strings.SplitN(core.Trim(s), "=", 2)
 		if parts != nil && len(parts) == 2 {
 			key := core.Trim(parts[0])
+			if !keepEnvVar(key) {
+				continue
+			}
 			val := core.Trim(parts[1])
 			p.Env[key] = val
 		}
@@ -174,6 +208,18 @@ func (p *Process) ReadCmdline() {
 	p.CleanArgs()
 }
 
+// RereadCmdline reads again the cmdline of the process, as it may have been
+// overwritten since it was executed. The arguments are kept if it can't be
+// read.
+func (p *Process) RereadCmdline() {
+	current := NewProcess(p.ID, p.Comm)
+	current.Path = p.Path
+	current.ReadCmdline()
+	if len(current.Args) > 0 {
+		p.Args = current.Args
+	}
+}
+
 // CleanArgs applies fixes on the cmdline arguments.
 // - AppImages cmdline reports the execuable launched as /proc/self/exe,
 //   instead of the actual path to the binary.
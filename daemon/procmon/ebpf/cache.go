@@ -28,13 +28,47 @@ type execEventItem struct {
 	Proc     procmon.Process
 	Event    execEvent
 	LastSeen int64
+	// PID and start time of the process, to discard the event if the PID is
+	// reused by other process.
+	ProcKey procmon.ProcKey
+	// last time the PID was checked (see procKeyReused()).
+	ProcKeyChecked int64
+	// when the process exited, 0 if it's still running.
+	Exited int64
 }
 
 type eventsStore struct {
 	execEvents map[uint64]*execEventItem
+	// PIDs of the processes exited, the oldest first.
+	exited []uint64
 	sync.RWMutex
 }
 
+// The processes exited are kept for a while, to identify the connections of
+// the short-lived processes that exit before their connections are parsed.
+var (
+	exitedTTL       = 10 * time.Second
+	maxExitedEvents = 1024
+	// time during which the PID of an entry is not checked again.
+	procKeyCheckInterval = 5 * time.Second
+)
+
+// procKeyReused checks if the PID of an entry has been reused by other
+// process. Reading the start time of the process on every lookup is
+// expensive, so once checked, it's trusted for procKeyCheckInterval.
+// It must be called with the lock of the store held.
+func procKeyReused(key procmon.ProcKey, checked *int64) bool {
+	now := time.Now().UnixNano()
+	if now-*checked < int64(procKeyCheckInterval) {
+		return false
+	}
+	if key.Reused() {
+		return true
+	}
+	*checked = now
+	return false
+}
+
 // NewEventsStore creates a new store of events.
 func NewEventsStore() *eventsStore {
 	return &eventsStore{
@@ -45,19 +79,44 @@ func NewEventsStore() *eventsStore {
 func (e *eventsStore) add(key uint64, event execEvent, proc procmon.Process) {
 	e.Lock()
 	defer e.Unlock()
+	procKey, _ := procmon.GetProcKey(int(key))
 	e.execEvents[key] = &execEventItem{
-		Proc:  proc,
-		Event: event,
+		Proc:           proc,
+		Event:          event,
+		ProcKey:        procKey,
+		ProcKeyChecked: time.Now().UnixNano(),
 	}
 }
 
 func (e *eventsStore) isInStore(key uint64) (item *execEventItem, found bool) {
-	e.RLock()
-	defer e.RUnlock()
+	e.Lock()
+	defer e.Unlock()
 	item, found = e.execEvents[key]
+	if found && procKeyReused(item.ProcKey, &item.ProcKeyChecked) {
+		delete(e.execEvents, key)
+		return nil, false
+	}
 	return
 }
 
+// setExited marks the process as exited, to forget it after a while.
+func (e *eventsStore) setExited(key uint64) {
+	e.Lock()
+	defer e.Unlock()
+	item, found := e.execEvents[key]
+	if !found {
+		return
+	}
+	item.Exited = time.Now().UnixNano()
+	e.exited = append(e.exited, key)
+	if len(e.exited) > maxExitedEvents {
+		if old, found := e.execEvents[e.exited[0]]; found && old.Exited != 0 {
+			delete(e.execEvents, e.exited[0])
+		}
+		e.exited = e.exited[1:]
+	}
+}
+
 func (e *eventsStore) delete(key uint64) {
 	e.Lock()
 	defer e.Unlock()
@@ -69,10 +128,23 @@ func (e *eventsStore) DeleteOldItems() {
 	defer e.Unlock()
 
 	for k, item := range e.execEvents {
+		if item.Exited != 0 {
+			if time.Since(time.Unix(0, item.Exited)) > exitedTTL {
+				delete(e.execEvents, k)
+			}
+			continue
+		}
 		if item.Proc.IsAlive() == false {
 			delete(e.execEvents, k)
 		}
 	}
+	exited := e.exited[:0]
+	for _, k := range e.exited {
+		if item, found := e.execEvents[k]; found && item.Exited != 0 {
+			exited = append(exited, k)
+		}
+	}
+	e.exited = exited
 }
 
 //-----------------------------------------------------------------------------
@@ -81,6 +153,10 @@ type ebpfCacheItem struct {
 	Proc     procmon.Process
 	Key      []byte
 	LastSeen int64
+	// PID and start time of the process of the connection.
+	ProcKey procmon.ProcKey
+	// last time the PID was checked (see procKeyReused()).
+	ProcKeyChecked int64
 }
 
 type ebpfCacheType struct {
@@ -97,10 +173,14 @@ var (
 
 // NewEbpfCacheItem creates a new cache item.
 func NewEbpfCacheItem(key []byte, proc procmon.Process) *ebpfCacheItem {
+	procKey, _ := procmon.GetProcKey(proc.ID)
+	now := time.Now().UnixNano()
 	return &ebpfCacheItem{
-		Key:      key,
-		Proc:     proc,
-		LastSeen: time.Now().UnixNano(),
+		Key:            key,
+		Proc:           proc,
+		LastSeen:       now,
+		ProcKey:        procKey,
+		ProcKeyChecked: now,
 	}
 }
 
@@ -108,7 +188,7 @@ func (i *ebpfCacheItem) isValid() bool {
 	lastSeen := time.Now().Sub(
 		time.Unix(0, i.LastSeen),
 	)
-	return int(lastSeen.Seconds()) < maxTTL
+	return int(lastSeen.Seconds()) < maxTTL && !procKeyReused(i.ProcKey, &i.ProcKeyChecked)
 }
 
 // NewEbpfCache creates a new cache store.
@@ -0,0 +1,61 @@
+package ebpf
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+)
+
+func TestProcKeyReused(t *testing.T) {
+	key, err := procmon.GetProcKey(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused := procmon.ProcKey{PID: key.PID, StartTime: key.StartTime + 1}
+
+	checked := int64(0)
+	if procKeyReused(key, &checked) {
+		t.Error("PID of a running process reused")
+	}
+	if checked == 0 {
+		t.Error("check time not updated")
+	}
+
+	// checked recently, the start time is not read again.
+	checked = time.Now().UnixNano()
+	if procKeyReused(reused, &checked) {
+		t.Error("PID checked again before procKeyCheckInterval")
+	}
+	checked = time.Now().Add(-procKeyCheckInterval).UnixNano()
+	if !procKeyReused(reused, &checked) {
+		t.Error("reused PID not detected after procKeyCheckInterval")
+	}
+}
+
+func TestEventsStoreReused(t *testing.T) {
+	key, err := procmon.GetProcKey(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewEventsStore()
+	pid := uint64(key.PID)
+	store.add(pid, execEvent{}, procmon.Process{ID: key.PID})
+	if _, found := store.isInStore(pid); !found {
+		t.Fatal("event not found")
+	}
+
+	// the PID is reused by other process.
+	store.execEvents[pid].ProcKey.StartTime++
+	if _, found := store.isInStore(pid); !found {
+		t.Error("event checked again before procKeyCheckInterval")
+	}
+	store.execEvents[pid].ProcKeyChecked = 0
+	if _, found := store.isInStore(pid); found {
+		t.Error("event of a reused PID found")
+	}
+	if _, found := store.execEvents[pid]; found {
+		t.Error("event of a reused PID not deleted")
+	}
+}
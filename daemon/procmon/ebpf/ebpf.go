@@ -76,6 +76,14 @@ func Start() error {
 	}
 	m.EnableOptionCompatProbe()
 
+	if !checkPinnedMaps(m) {
+		m.Close()
+		if err := m.Load(nil); err != nil {
+			log.Error("eBPF failed to load opensnitch.o with new maps: %v", err)
+			return err
+		}
+	}
+
 	// if previous shutdown was unclean, then we must remove the dangling kprobe
 	// and install it again (close the module and load it again)
 
@@ -110,6 +118,7 @@ func Start() error {
 
 	ebpfCache = NewEbpfCache()
 	initEventsStreamer()
+	initTraffic()
 
 	saveEstablishedConnections(uint8(syscall.AF_INET))
 	if core.IPv6Enabled {
@@ -160,6 +169,7 @@ func Stop() {
 	}
 	cancelTasks()
 	ebpfCache.clear()
+	stopTraffic()
 
 	if m != nil {
 		m.Close()
@@ -167,12 +167,15 @@ func streamEventsWorker(id int, chn chan []byte, lost chan uint64, kernelEvents
 						continue
 					}
 					execEvents.add(event.PID, event, *proc)
+					procmon.PublishExec(proc)
 
 				case EV_TYPE_SCHED_EXIT:
 					log.Debug("[eBPF exit event] -> %d", event.PID)
+					// keep the process for a while, its connections may be
+					// still queued.
 					if _, found := execEvents.isInStore(event.PID); found {
 						log.Debug("[eBPF exit event inCache] -> %d", event.PID)
-						execEvents.delete(event.PID)
+						execEvents.setExited(event.PID)
 					}
 				}
 			}
@@ -198,6 +201,14 @@ func event2process(event *execEvent) (proc *procmon.Process) {
 	proc.ReadCwd()
 	proc.ReadEnv()
 	proc.UID = int(event.UID)
+	proc.PPID = int(event.PPID)
+	proc.ReadParents()
+	proc.ReadContainer()
+	proc.ReadNamespace()
+	proc.ReadSession()
+	proc.ReadStartTime()
+	proc.ReadAppID()
+	proc.ReadCgroup()
 
 	if event.ArgsPartial == 0 {
 		for i := 0; i < int(event.ArgsCount); i++ {
@@ -207,6 +218,9 @@ func event2process(event *execEvent) (proc *procmon.Process) {
 	} else {
 		proc.ReadCmdline()
 	}
+	// the command line the process was executed with, which may be
+	// overwritten by the process later.
+	proc.ExecArgs = append([]string{}, proc.Args...)
 	log.Debug("[eBPF exec event] ppid: %d, pid: %d, %s -> %s", event.PPID, event.PID, proc.Path, proc.Args)
 
 	return
@@ -168,7 +168,9 @@ func findConnProcess(value *networkEventT, connKey string) (proc *procmon.Proces
 	if ev, found := execEvents.isInStore(value.Pid); found {
 		// use socket's UID. See above why ^
 		ev.Proc.UID = proc.UID
-		ev.Proc.ReadCmdline()
+		// the process may have overwritten its cmdline since it was executed,
+		// the original one is kept in ExecArgs.
+		ev.Proc.RereadCmdline()
 		// if proc's ReadPath() has been successfull, and the path received via the execve tracepoint differs,
 		// use proc's path.
 		// Sometimes we received from the tracepoint a wrong/non-existent path.
@@ -181,7 +183,7 @@ func findConnProcess(value *networkEventT, connKey string) (proc *procmon.Proces
 		}
 		proc = &ev.Proc
 
-		log.Debug("[ebpf conn] not in cache, but in execEvents: %s, %d -> %s", connKey, proc.ID, proc.Path)
+		log.Debug("[ebpf conn] not in cache, but in execEvents: %s, %d -> %s, exited: %v", connKey, proc.ID, proc.Path, ev.Exited != 0)
 	} else {
 		log.Debug("[ebpf conn] not in cache, NOR in execEvents: %s, %d -> %s", connKey, proc.ID, proc.Path)
 		// We'll end here if the events module has not been loaded, or if the process is not in cache.
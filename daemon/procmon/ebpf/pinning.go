@@ -0,0 +1,90 @@
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	elf "github.com/iovisor/gobpf/elf"
+	"golang.org/x/sys/unix"
+)
+
+// The maps of the connections are pinned to bpffs by opensnitch.o
+// (PIN_GLOBAL_NS, namespace opensnitch), so they're kept when the daemon is
+// restarted (upgrades, ...), and the connections opened before the restart
+// are still attributed to their processes.
+
+var (
+	pinnedMapsPath = "/sys/fs/bpf/opensnitch/globals"
+
+	// size of the keys of the pinned maps, as defined in opensnitch.c
+	pinnedMapsKeySize = map[string]uint32{
+		"tcpMap":   12,
+		"tcpv6Map": 36,
+		"udpMap":   12,
+		"udpv6Map": 36,
+	}
+)
+
+// struct bpf_map_info, from <linux_headers>/include/uapi/linux/bpf.h
+type bpfMapInfo struct {
+	Type       uint32
+	ID         uint32
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	MapFlags   uint32
+	Name       [16]byte
+}
+
+// getMapInfo returns the definition of a map with the command
+// BPF_OBJ_GET_INFO_BY_FD of the bpf() syscall.
+func getMapInfo(fd int) (*bpfMapInfo, error) {
+	const bpfObjGetInfoByFD = 15
+	var info bpfMapInfo
+	attr := struct {
+		fd      uint32
+		infoLen uint32
+		info    uint64
+	}{
+		fd:      uint32(fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, bpfObjGetInfoByFD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return nil, fmt.Errorf("bpf(BPF_OBJ_GET_INFO_BY_FD): %s", errno)
+	}
+	return &info, nil
+}
+
+// checkPinnedMaps verifies that the maps pinned by a previous daemon have the
+// layout expected by this one, and unpins the ones that don't. It returns
+// false if the module must be loaded again to create them.
+func checkPinnedMaps(mod *elf.Module) bool {
+	valid := true
+	valueSize := uint32(unsafe.Sizeof(networkEventT{}))
+	for name, keySize := range pinnedMapsKeySize {
+		bpfmap := mod.Map(name)
+		if bpfmap == nil {
+			continue
+		}
+		info, err := getMapInfo(bpfmap.Fd())
+		if err != nil {
+			log.Debug("[eBPF] unable to check the map %s: %s", name, err)
+			continue
+		}
+		if info.KeySize == keySize && info.ValueSize == valueSize {
+			continue
+		}
+		log.Warning("[eBPF] the pinned map %s is not compatible (key: %d, value: %d), recreating it", name, info.KeySize, info.ValueSize)
+		if err := os.Remove(filepath.Join(pinnedMapsPath, name)); err != nil && !os.IsNotExist(err) {
+			log.Warning("[eBPF] unable to unpin the map %s: %s", name, err)
+			continue
+		}
+		valid = false
+	}
+	return valid
+}
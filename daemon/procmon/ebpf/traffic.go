@@ -0,0 +1,188 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	elf "github.com/iovisor/gobpf/elf"
+)
+
+// trafficKeyT is the flow of the packets accounted by opensnitch-traffic.o.
+// The addresses and ports are in network byte order.
+type trafficKeyT struct {
+	Family uint8
+	Proto  uint8
+	LPort  uint16
+	RPort  uint16
+	Pad    uint16
+	LAddr  [16]byte
+	RAddr  [16]byte
+}
+
+type trafficValueT struct {
+	BytesOut   uint64
+	BytesIn    uint64
+	PacketsOut uint64
+	PacketsIn  uint64
+	UID        uint32
+	Pad        uint32
+}
+
+// FlowTraffic holds the bytes and packets sent and received by a flow, from
+// the point of view of the local side (Src).
+type FlowTraffic struct {
+	// tcp, udp, udplite, with the suffix 6 for IPv6.
+	Proto       string
+	SrcIP       net.IP
+	SrcPort     uint
+	DstIP       net.IP
+	DstPort     uint
+	UID         uint32
+	BytesSent   uint64
+	BytesRecv   uint64
+	PacketsSent uint64
+	PacketsRecv uint64
+}
+
+var (
+	trafficLock sync.RWMutex
+	trafficMod  *elf.Module
+	trafficMap  *elf.Map
+	// cgroup where the programs are attached, the root of the cgroupv2 hierarchy.
+	trafficCgroup string
+
+	trafficPrograms = map[string]elf.AttachType{
+		"cgroup/skb/egress":  elf.EgressType,
+		"cgroup/skb/ingress": elf.IngressType,
+	}
+)
+
+// initTraffic loads the programs that account the traffic of the flows.
+// They're optional, so the errors are only logged.
+func initTraffic() {
+	cgroup, err := core.GetCgroupv2MountPoint()
+	if err != nil {
+		log.Warning("[eBPF traffic] unable to account the traffic of the processes: %s", err)
+		return
+	}
+	mod, err := core.LoadEbpfModule("opensnitch-traffic.o")
+	if err != nil {
+		log.Warning("[eBPF traffic] %s", err)
+		return
+	}
+	for name, attachType := range trafficPrograms {
+		prog := mod.CgroupProgram(name)
+		if prog == nil {
+			err = fmt.Errorf("program %s not found", name)
+		} else {
+			err = elf.AttachCgroupProgram(prog, cgroup, attachType)
+		}
+		if err != nil {
+			log.Warning("[eBPF traffic] error attaching %s to %s: %s", name, cgroup, err)
+			detachTraffic(mod, cgroup)
+			mod.Close()
+			return
+		}
+	}
+	bpfmap := mod.Map("trafficMap")
+	if bpfmap == nil {
+		log.Warning("[eBPF traffic] module opensnitch-traffic.o malformed, trafficMap nil")
+		detachTraffic(mod, cgroup)
+		mod.Close()
+		return
+	}
+
+	trafficLock.Lock()
+	trafficMod, trafficMap, trafficCgroup = mod, bpfmap, cgroup
+	trafficLock.Unlock()
+	log.Info("[eBPF traffic] accounting the traffic of the processes from %s", cgroup)
+}
+
+func stopTraffic() {
+	trafficLock.Lock()
+	defer trafficLock.Unlock()
+	if trafficMod == nil {
+		return
+	}
+	detachTraffic(trafficMod, trafficCgroup)
+	trafficMod.Close()
+	trafficMod, trafficMap, trafficCgroup = nil, nil, ""
+}
+
+func detachTraffic(mod *elf.Module, cgroup string) {
+	for name, attachType := range trafficPrograms {
+		if prog := mod.CgroupProgram(name); prog != nil {
+			elf.DetachCgroupProgram(prog, cgroup, attachType)
+		}
+	}
+}
+
+// GetTraffic returns the counters of the flows seen since the programs were
+// attached. The least recently used flows are evicted by the kernel when the
+// map is full. It returns nil if the traffic is not being accounted.
+func GetTraffic() []FlowTraffic {
+	trafficLock.RLock()
+	defer trafficLock.RUnlock()
+	if trafficMod == nil {
+		return nil
+	}
+
+	var flows []FlowTraffic
+	var key, nextKey trafficKeyT
+	var value trafficValueT
+	for {
+		ok, err := trafficMod.LookupNextElement(trafficMap, unsafe.Pointer(&key),
+			unsafe.Pointer(&nextKey), unsafe.Pointer(&value))
+		if !ok || err != nil {
+			break
+		}
+		key = nextKey
+		if flow, ok := nextKey.flow(); ok {
+			flow.UID = value.UID
+			flow.BytesSent = value.BytesOut
+			flow.BytesRecv = value.BytesIn
+			flow.PacketsSent = value.PacketsOut
+			flow.PacketsRecv = value.PacketsIn
+			flows = append(flows, flow)
+		}
+	}
+	return flows
+}
+
+func (k *trafficKeyT) flow() (flow FlowTraffic, ok bool) {
+	protoType := ""
+	ipLen := net.IPv4len
+	if k.Family == syscall.AF_INET6 {
+		protoType = "6"
+		ipLen = net.IPv6len
+	}
+	switch k.Proto {
+	case syscall.IPPROTO_TCP:
+		flow.Proto = "tcp" + protoType
+	case syscall.IPPROTO_UDP:
+		flow.Proto = "udp" + protoType
+	case syscall.IPPROTO_UDPLITE:
+		flow.Proto = "udplite" + protoType
+	default:
+		return flow, false
+	}
+	flow.SrcIP = net.IP(append([]byte{}, k.LAddr[:ipLen]...))
+	flow.DstIP = net.IP(append([]byte{}, k.RAddr[:ipLen]...))
+	flow.SrcPort = uint(ntohs(k.LPort))
+	flow.DstPort = uint(ntohs(k.RPort))
+	return flow, true
+}
+
+// ntohs converts a port in network byte order, read from the map as a native
+// integer.
+func ntohs(port uint16) uint16 {
+	b := make([]byte, 2)
+	hostByteOrder.PutUint16(b, port)
+	return binary.BigEndian.Uint16(b)
+}
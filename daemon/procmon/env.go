@@ -0,0 +1,46 @@
+package procmon
+
+import "sync"
+
+var (
+	envLock sync.RWMutex
+	// environment variables to read of the processes. If empty, all of them are read.
+	envVars = make(map[string]bool)
+	// environment variables used by the rules, which are always read.
+	rulesEnvVars = make(map[string]bool)
+)
+
+// SetEnvVars configures the environment variables to read of the processes,
+// to not keep (and send to the GUI) all of them. If the list is empty, all
+// the variables are read.
+func SetEnvVars(names []string) {
+	envLock.Lock()
+	defer envLock.Unlock()
+
+	envVars = make(map[string]bool, len(names))
+	for _, name := range names {
+		envVars[name] = true
+	}
+}
+
+// SetRulesEnvVars sets the environment variables used by the rules
+// (process.env.VAR), which are read even if they're not configured.
+func SetRulesEnvVars(names []string) {
+	envLock.Lock()
+	defer envLock.Unlock()
+
+	rulesEnvVars = make(map[string]bool, len(names))
+	for _, name := range names {
+		rulesEnvVars[name] = true
+	}
+}
+
+func keepEnvVar(name string) bool {
+	envLock.RLock()
+	defer envLock.RUnlock()
+
+	if len(envVars) == 0 {
+		return true
+	}
+	return envVars[name] || rulesEnvVars[name]
+}
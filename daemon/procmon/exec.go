@@ -0,0 +1,75 @@
+package procmon
+
+import (
+	"sync"
+	"time"
+)
+
+// ExecEvent is a process executed, as reported by the process monitor
+// methods that trace the executions (ebpf).
+type ExecEvent struct {
+	Time time.Time `json:"time"`
+	PID  int       `json:"pid"`
+	PPID int       `json:"ppid"`
+	UID  int       `json:"uid"`
+	Path string    `json:"path"`
+	Args []string  `json:"args"`
+	CWD  string    `json:"cwd"`
+	// SHA-256 of the binary, filled by the subscribers that need it, as it's
+	// expensive to compute.
+	Hash string `json:"hash,omitempty"`
+}
+
+// maximum number of events queued per subscriber. The events are dropped if
+// a subscriber doesn't keep up.
+const maxQueuedExecEvents = 256
+
+var (
+	execSubscribersLock sync.RWMutex
+	execSubscribers     = make(map[chan *ExecEvent]struct{})
+)
+
+// SubscribeExecEvents returns a channel where the processes executed are
+// sent, until UnsubscribeExecEvents is called with it.
+func SubscribeExecEvents() chan *ExecEvent {
+	ch := make(chan *ExecEvent, maxQueuedExecEvents)
+	execSubscribersLock.Lock()
+	execSubscribers[ch] = struct{}{}
+	execSubscribersLock.Unlock()
+	return ch
+}
+
+// UnsubscribeExecEvents stops sending the processes executed to the given
+// channel, and closes it.
+func UnsubscribeExecEvents(ch chan *ExecEvent) {
+	execSubscribersLock.Lock()
+	defer execSubscribersLock.Unlock()
+	if _, found := execSubscribers[ch]; found {
+		delete(execSubscribers, ch)
+		close(ch)
+	}
+}
+
+// PublishExec sends a process executed to the subscribers, if any.
+func PublishExec(p *Process) {
+	execSubscribersLock.RLock()
+	defer execSubscribersLock.RUnlock()
+	if len(execSubscribers) == 0 {
+		return
+	}
+	ev := &ExecEvent{
+		Time: time.Now(),
+		PID:  p.ID,
+		PPID: p.PPID,
+		UID:  p.UID,
+		Path: p.Path,
+		Args: p.Args,
+		CWD:  p.CWD,
+	}
+	for ch := range execSubscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -42,11 +42,29 @@ func inodeFound(pidsPath, expect, inodeKey string, inode, pid int) bool {
 
 // lookupPidInProc searches for an inode in /proc.
 // First it gets the running PIDs and obtains the opened sockets.
+// If the processes are tracked with the proc connector, the PIDs tracked are
+// searched first, and /proc is only walked to search in the rest of PIDs
+// (processes whose events have not been received yet).
 // TODO: If the inode is not found, search again in the task/threads
 // of every PID (costly).
 func lookupPidInProc(pidsPath, expect, inodeKey string, inode int) int {
+	var searched map[int]bool
+	if pidsPath == "/proc/" {
+		if pidList, tracked := getTrackedPids(); tracked {
+			searched = make(map[int]bool, len(pidList))
+			for _, pid := range pidList {
+				if inodeFound(pidsPath, expect, inodeKey, inode, pid) {
+					return pid
+				}
+				searched[pid] = true
+			}
+		}
+	}
 	pidList := getProcPids(pidsPath)
 	for _, pid := range pidList {
+		if searched[pid] {
+			continue
+		}
 		if inodeFound(pidsPath, expect, inodeKey, inode, pid) {
 			return pid
 		}
@@ -0,0 +1,73 @@
+package procmon
+
+import (
+	"sync"
+	"time"
+)
+
+// MethodHealth holds the counters of the PID lookups of the process monitor
+// method in use, since it was configured.
+type MethodHealth struct {
+	Method string    `json:"method"`
+	Since  time.Time `json:"since"`
+	// connections whose PID has been looked up with the method.
+	Lookups uint64 `json:"lookups"`
+	// PIDs not found by the method, even if they were found by a fallback.
+	Unknown uint64 `json:"unknown"`
+	// errors reported by the method while looking up the PIDs.
+	Errors uint64 `json:"errors"`
+}
+
+var (
+	healthLock sync.Mutex
+	health     = MethodHealth{Method: MethodProc, Since: time.Now()}
+	// counters since the last call to TakeHealthInterval.
+	intervalLookups, intervalUnknown uint64
+)
+
+// RecordLookup counts a PID lookup of the process monitor method in use.
+func RecordLookup(found bool, err error) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	health.Lookups++
+	intervalLookups++
+	if !found {
+		health.Unknown++
+		intervalUnknown++
+	}
+	if err != nil {
+		health.Errors++
+	}
+}
+
+// RecordMethodError counts an error of the process monitor method in use.
+func RecordMethodError() {
+	healthLock.Lock()
+	health.Errors++
+	healthLock.Unlock()
+}
+
+// GetHealth returns the counters of the process monitor method in use.
+func GetHealth() MethodHealth {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	return health
+}
+
+// TakeHealthInterval returns the lookups and the unknown PIDs since the last
+// call, and resets them.
+func TakeHealthInterval() (lookups, unknown uint64) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	lookups, unknown = intervalLookups, intervalUnknown
+	intervalLookups, intervalUnknown = 0, 0
+	return lookups, unknown
+}
+
+// resetHealth starts counting the lookups of a new method.
+func resetHealth(method string) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	health = MethodHealth{Method: method, Since: time.Now()}
+	intervalLookups, intervalUnknown = 0, 0
+}
@@ -0,0 +1,32 @@
+package procmon
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMethodHealth(t *testing.T) {
+	oldMethod := GetMonitorMethod()
+	defer SetMonitorMethod(oldMethod)
+	SetMonitorMethod(MethodAudit)
+
+	RecordLookup(true, nil)
+	RecordLookup(false, nil)
+	RecordLookup(false, fmt.Errorf("lookup error"))
+	h := GetHealth()
+	if h.Method != MethodAudit || h.Lookups != 3 || h.Unknown != 2 || h.Errors != 1 {
+		t.Error("Invalid health of the method:", h)
+	}
+	if lookups, unknown := TakeHealthInterval(); lookups != 3 || unknown != 2 {
+		t.Error("Invalid lookups of the interval:", lookups, unknown)
+	}
+	if lookups, unknown := TakeHealthInterval(); lookups != 0 || unknown != 0 {
+		t.Error("Lookups of the interval not reset:", lookups, unknown)
+	}
+
+	// the counters start again with a new method.
+	SetMonitorMethod(MethodProc)
+	if h := GetHealth(); h.Method != MethodProc || h.Lookups != 0 || h.Unknown != 0 {
+		t.Error("Health not reset when changing the method:", h)
+	}
+}
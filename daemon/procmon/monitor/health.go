@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+	"github.com/evilsocket/opensnitch/daemon/procmon/audit"
+)
+
+// Failover is a change of the process monitor method made by the daemon,
+// because the method in use was not finding the PIDs of the connections.
+type Failover struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// Status is the state of the process monitor method, and the features of the
+// kernel the methods need.
+type Status struct {
+	procmon.MethodHealth
+	// the processes are tracked with the proc connector (proc method).
+	ProcEvents bool            `json:"proc_events"`
+	Features   map[string]bool `json:"features"`
+	Failovers  []Failover      `json:"failovers"`
+}
+
+var (
+	// interval to check the unknown PIDs of the method in use.
+	healthCheckInterval = 10 * time.Second
+	// minimum number of lookups in an interval to consider the method
+	// failing, not to fail over with a few connections of the kernel.
+	failoverMinLookups uint64 = 20
+	// ratio of unknown PIDs in an interval to fail over to the next method.
+	failoverMaxUnknown = 0.5
+	// the next method to use when one is failing.
+	failoverMethods = map[string]string{
+		procmon.MethodEbpf:  procmon.MethodAudit,
+		procmon.MethodAudit: procmon.MethodProc,
+	}
+	maxFailovers = 10
+
+	failoversLock sync.Mutex
+	failovers     []Failover
+	failoverChan  = make(chan Failover, maxFailovers)
+)
+
+// FailoverEvents returns the channel where the changes of the process monitor
+// method made by the daemon are notified.
+func FailoverEvents() <-chan Failover {
+	return failoverChan
+}
+
+// GetStatus returns the state of the process monitor method in use.
+func GetStatus() Status {
+	failoversLock.Lock()
+	list := make([]Failover, len(failovers))
+	copy(list, failovers)
+	failoversLock.Unlock()
+
+	return Status{
+		MethodHealth: procmon.GetHealth(),
+		ProcEvents:   procmon.IsTrackingProcEvents(),
+		Features:     kernelFeatures(),
+		Failovers:    list,
+	}
+}
+
+// kernelFeatures returns the features of the kernel needed by the methods.
+func kernelFeatures() map[string]bool {
+	tracing := "/sys/kernel/debug/tracing"
+	if !core.Exists(tracing + "/kprobe_events") {
+		tracing = "/sys/kernel/tracing"
+	}
+	return map[string]bool{
+		"kprobes":             core.Exists(tracing + "/kprobe_events"),
+		"syscall_tracepoints": core.Exists(tracing + "/events/syscalls"),
+		"btf":                 core.Exists("/sys/kernel/btf/vmlinux"),
+		"bpffs":               core.Exists("/sys/fs/bpf"),
+		"audit":               audit.Available(),
+	}
+}
+
+func monitorHealth() {
+	for {
+		time.Sleep(healthCheckInterval)
+		lookups, unknown := procmon.TakeHealthInterval()
+		if lookups < failoverMinLookups || float64(unknown)/float64(lookups) <= failoverMaxUnknown {
+			continue
+		}
+		from := procmon.GetMonitorMethod()
+		to, found := failoverMethods[from]
+		if !found {
+			continue
+		}
+		reason := fmt.Sprintf("%d of %d PIDs not found in %s", unknown, lookups, healthCheckInterval)
+		log.Warning("Process monitor method %s failing (%s), changing to %s", from, reason, to)
+		End()
+		procmon.SetMonitorMethod(to)
+		// Init falls back to proc if the method can't be started.
+		if err := Init(); err != nil {
+			log.Warning("Error changing the process monitor method to %s: %s", to, err)
+		}
+		addFailover(Failover{
+			Time:   time.Now(),
+			From:   from,
+			To:     procmon.GetMonitorMethod(),
+			Reason: reason,
+		})
+	}
+}
+
+func addFailover(f Failover) {
+	failoversLock.Lock()
+	if len(failovers) >= maxFailovers {
+		failovers = failovers[1:]
+	}
+	failovers = append(failovers, f)
+	failoversLock.Unlock()
+
+	select {
+	case failoverChan <- f:
+	default:
+	}
+}
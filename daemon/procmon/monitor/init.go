@@ -40,6 +40,8 @@ func End() {
 		audit.Stop()
 	} else if procmon.MethodIsEbpf() {
 		ebpf.Stop()
+	} else {
+		procmon.StopProcEvents()
 	}
 }
 
@@ -48,6 +50,7 @@ func Init() (err error) {
 	if cacheMonitorsRunning == false {
 		go procmon.MonitorActivePids()
 		go procmon.CacheCleanerTask()
+		go monitorHealth()
 		cacheMonitorsRunning = true
 	}
 
@@ -75,5 +78,8 @@ func Init() (err error) {
 	// if any of the above methods have failed, fallback to proc
 	log.Info("Process monitor method /proc")
 	procmon.SetMonitorMethod(procmon.MethodProc)
+	if errEvents := procmon.StartProcEvents(); errEvents != nil {
+		log.Warning("unable to track the processes with the proc connector, walking /proc: %s", errEvents)
+	}
 	return err
 }
@@ -0,0 +1,56 @@
+package procmon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	// directory of the network namespaces created with ip netns.
+	netnsPath = "/run/netns"
+
+	ownNetNS     uint64
+	ownNetNSOnce sync.Once
+)
+
+// GetNetNS returns the ID (inode) of the network namespace of a process, or 0
+// if it can't be read.
+func GetNetNS(pid int) uint64 {
+	return getNamespace(pid, "net")
+}
+
+// getNamespace returns the inode of a namespace (net, pid, mnt, ...) of a
+// process, or 0 if it can't be read.
+func getNamespace(pid int, name string) uint64 {
+	// net:[4026531840]
+	link, err := os.Readlink(fmt.Sprint("/proc/", pid, "/ns/", name))
+	if err != nil {
+		return 0
+	}
+	link = strings.TrimSuffix(strings.TrimPrefix(link, name+":["), "]")
+	ns, _ := strconv.ParseUint(link, 10, 64)
+	return ns
+}
+
+// OwnNetNS returns the ID of the network namespace of the daemon, usually the
+// namespace of the host.
+func OwnNetNS() uint64 {
+	ownNetNSOnce.Do(func() {
+		ownNetNS = GetNetNS(os.Getpid())
+	})
+	return ownNetNS
+}
+
+// NetNSByName returns the ID of a network namespace created with ip netns.
+func NetNSByName(name string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(netnsPath, name), &st); err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
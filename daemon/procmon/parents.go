@@ -0,0 +1,105 @@
+package procmon
+
+import (
+	"sync"
+
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+const (
+	// maximum number of parents to read of a process.
+	maxParents = 32
+	// maximum number of parents to cache, the cache is emptied when it's
+	// reached.
+	maxCachedParents = 1024
+)
+
+var (
+	parentsLock sync.Mutex
+	// parents by PID, with their own chain of parents. The parents are
+	// long-lived processes (shells, make, systemd, ...) shared by many
+	// processes, so they're read only once. A cached parent is discarded if
+	// its PID is reused by other process.
+	// The cached processes are not modified once they're added.
+	parentsCache = make(map[int]*Process)
+)
+
+// ReadParents reads the chain of parents of the process, up to init (PID 1).
+// Only the details to identify them are read (path, cmdline and comm).
+func (p *Process) ReadParents() {
+	// the parents read, not cached yet.
+	read := []*Process{}
+	child := p
+	for i := 0; i < maxParents && child.Parent == nil; i++ {
+		if child.ReadPPID() != nil || child.PPID <= 0 || child.PPID == child.ID {
+			break
+		}
+		if parent := getCachedParent(child.PPID); parent != nil {
+			child.Parent = parent
+			break
+		}
+		parent := NewProcess(child.PPID, "")
+		parent.ReadComm()
+		parent.ReadPath()
+		parent.ReadCmdline()
+		parent.ReadStartTime()
+		child.Parent = parent
+		child = parent
+		read = append(read, parent)
+	}
+
+	parentsLock.Lock()
+	defer parentsLock.Unlock()
+	if len(parentsCache)+len(read) > maxCachedParents {
+		parentsCache = make(map[int]*Process)
+	}
+	for _, parent := range read {
+		if !parent.StartTime.IsZero() {
+			parentsCache[parent.ID] = parent
+		}
+	}
+}
+
+// getCachedParent returns the cached process of the given PID, if it's still
+// the same process.
+func getCachedParent(pid int) *Process {
+	parentsLock.Lock()
+	parent, found := parentsCache[pid]
+	parentsLock.Unlock()
+	if !found {
+		return nil
+	}
+	if start, err := GetStartTime(pid); err != nil || !start.Equal(parent.StartTime) {
+		parentsLock.Lock()
+		delete(parentsCache, pid)
+		parentsLock.Unlock()
+		return nil
+	}
+	return parent
+}
+
+// Parents returns the chain of parents of the process, from the closest one.
+func (p *Process) Parents() []*Process {
+	parents := []*Process{}
+	for parent := p.Parent; parent != nil; parent = parent.Parent {
+		parents = append(parents, parent)
+	}
+	return parents
+}
+
+// SerializeParents transforms the chain of parents of the process to gRPC
+// protocol objects, from the closest one.
+func (p *Process) SerializeParents() []*protocol.Process {
+	parents := p.Parents()
+	list := make([]*protocol.Process, 0, len(parents))
+	for _, parent := range parents {
+		list = append(list, &protocol.Process{
+			Pid:  uint64(parent.ID),
+			Ppid: uint64(parent.PPID),
+			Comm: parent.Comm,
+			Path: parent.Path,
+			Args: parent.Args,
+		})
+	}
+	return list
+}
@@ -102,6 +102,17 @@ func FindProcess(pid int, interceptUnknown bool) *Process {
 	}
 
 	proc := NewProcess(pid, "")
+	// the process may have exited, but it may have been read when it was
+	// executed.
+	if exec := getExecDetails(pid); exec != nil {
+		if !proc.IsAlive() {
+			proc.Comm = exec.Comm
+			proc.Path = exec.Path
+			proc.Args = append(proc.Args, exec.Args...)
+			proc.CWD = exec.CWD
+		}
+		proc.ExecArgs = append([]string{}, exec.Args...)
+	}
 	if err := proc.GetInfo(); err != nil {
 		log.Debug("[%d] FindProcess() error: %s", pid, err)
 		return nil
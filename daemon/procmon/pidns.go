@@ -0,0 +1,160 @@
+package procmon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+)
+
+// The processes of the containers run in their own PID and mount namespaces:
+// the PIDs they see are not the PIDs of the host, and the path of their binary
+// is relative to the root filesystem of the container, so it may not exist on
+// the host, or be other binary.
+
+// ReadNamespace reads the PID of the process in its PID namespace, and the
+// path to its binary on the filesystem of the host, if it runs in other mount
+// namespace.
+func (p *Process) ReadNamespace() {
+	if pids := readNSpids(p.ID); len(pids) > 1 {
+		p.NSPID = pids[len(pids)-1]
+	}
+	if p.HostPath != "" || p.Path == "" || !core.IsAbsPath(p.Path) {
+		return
+	}
+	if mntNS := getNamespace(p.ID, "mnt"); mntNS == 0 || mntNS == getNamespace(os.Getpid(), "mnt") {
+		return
+	}
+	p.HostPath = hostPath(p.ID, p.Path)
+}
+
+// HostPID returns the PID on the host of the process with the PID nsPid in
+// the PID namespace pidNS, or -1 if it's not found.
+func HostPID(pidNS uint64, nsPid int) int {
+	for _, pid := range getProcPids("/proc/") {
+		if getNamespace(pid, "pid") != pidNS {
+			continue
+		}
+		if pids := readNSpids(pid); len(pids) > 0 && pids[len(pids)-1] == nsPid {
+			return pid
+		}
+	}
+	return -1
+}
+
+// readNSpids returns the PIDs of a process in each one of the PID namespaces
+// it belongs to, from the namespace of the daemon to the namespace of the
+// process, reading the NSpid field of /proc/<pid>/status (kernels >= 4.1).
+func readNSpids(pid int) (pids []int) {
+	f, err := os.Open(fmt.Sprint("/proc/", pid, "/status"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// NSpid:	12345	7
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+		for _, field := range strings.Fields(line[6:]) {
+			nsPid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil
+			}
+			pids = append(pids, nsPid)
+		}
+		break
+	}
+	return pids
+}
+
+// hostPath returns the path to a binary of the filesystem of a process on the
+// filesystem of the host.
+// If the root filesystem of the process is an overlay (docker, podman,
+// containerd), the binary is searched in the layers of the image, so the path
+// is the one of the image. Otherwise it's accessed via /proc/<pid>/root.
+func hostPath(pid int, path string) string {
+	if layers := readRootLayers(fmt.Sprint("/proc/", pid, "/mountinfo")); layers != nil {
+		for _, dir := range layers {
+			if st, err := os.Lstat(filepath.Join(dir, path)); err == nil && st.Mode().IsRegular() {
+				return filepath.Join(dir, path)
+			}
+		}
+	}
+	return filepath.Join(fmt.Sprint("/proc/", pid, "/root"), path)
+}
+
+// readRootLayers returns the directories of the overlay mounted on / of a
+// mountinfo file, the upper one first, or nil if / is not an overlay.
+func readRootLayers(mountinfo string) []string {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var layers []string
+	scanner := bufio.NewScanner(f)
+	// the lines may be long with the lowerdirs of images with many layers.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// 591 477 0:52 / / rw,relatime master:240 - overlay overlay rw,lowerdir=...,upperdir=...,workdir=...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[4] != "/" {
+			continue
+		}
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || len(fields) < sep+4 {
+			continue
+		}
+		// the last mount on / is the one in use.
+		layers = nil
+		if fields[sep+1] == "overlay" {
+			layers = parseOverlayLayers(fields[sep+3])
+		}
+	}
+	return layers
+}
+
+// parseOverlayLayers returns the directories of the options of an overlay
+// mount, the upper one first.
+// The lower directories may be relative to the directory of the storage
+// driver (lowerdir=l/ABCD:l/EFGH), which is the parent of the directory of
+// the upper one (/var/lib/docker/overlay2/<id>/diff).
+func parseOverlayLayers(options string) (layers []string) {
+	var upper string
+	var lower []string
+	for _, opt := range strings.Split(options, ",") {
+		if strings.HasPrefix(opt, "upperdir=") {
+			upper = opt[9:]
+		} else if strings.HasPrefix(opt, "lowerdir=") {
+			lower = strings.Split(opt[9:], ":")
+		}
+	}
+	if upper != "" {
+		layers = append(layers, upper)
+	}
+	for _, dir := range lower {
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) && upper != "" {
+			dir = filepath.Join(filepath.Dir(filepath.Dir(upper)), dir)
+		}
+		layers = append(layers, dir)
+	}
+	return layers
+}
@@ -68,7 +68,11 @@ type Process struct {
 	// $ /usr/bin/curl https://...
 	//   -> Path: /usr/bin/curl
 	//   -> Args: /usr/bin/curl https://....
-	Args        []string
+	Args []string
+	// ExecArgs is the command line of the process when it was executed, if
+	// it has been traced. The processes may overwrite it after being started,
+	// so it may differ from Args (nginx workers, malware hiding itself, ...).
+	ExecArgs    []string
 	Env         map[string]string
 	CWD         string
 	Descriptors []*procDescriptors
@@ -79,6 +83,29 @@ type Process struct {
 	Statm       *procStatm
 	Stack       string
 	Maps        string
+	// Parent is the process that launched this one, with its own parent,
+	// up to init. It's nil if the parent can't be read.
+	Parent *Process
+	// Container the process runs in, nil if it doesn't run in a container.
+	Container *Container
+	// NSPID is the PID of the process in its PID namespace, 0 if it runs in
+	// the namespace of the daemon.
+	NSPID int
+	// HostPath is the path to the binary on the filesystem of the host, if the
+	// process runs in other mount namespace. Path is relative to the root
+	// filesystem of the process.
+	HostPath string
+	// Session of logind of the process, nil if it doesn't belong to a session.
+	Session *Session
+	// when the process was started, zero if it can't be read.
+	StartTime time.Time
+	// AppID identifies the flatpak, snap or AppImage application of the
+	// process, of the type AppType. Empty for the other processes.
+	AppID   string
+	AppType string
+	// Cgroup of the process, and the systemd unit or slice it belongs to.
+	Cgroup      string
+	SystemdUnit string
 }
 
 // NewProcess returns a new Process structure.
@@ -117,6 +144,9 @@ func (p *Process) Serialize() *protocol.Process {
 		IoWrites:  uint64(ioStats.WChar),
 		NetReads:  netStats.ReadBytes,
 		NetWrites: netStats.WriteBytes,
+		NsPid:     uint64(p.NSPID),
+		HostPath:  p.HostPath,
+		ExecArgs:  p.ExecArgs,
 	}
 }
 
@@ -125,6 +155,9 @@ func SetMonitorMethod(newMonitorMethod string) {
 	lock.Lock()
 	defer lock.Unlock()
 
+	if monitorMethod != newMonitorMethod {
+		resetHealth(newMonitorMethod)
+	}
 	monitorMethod = newMonitorMethod
 }
 
@@ -1,8 +1,17 @@
 package procmon
 
 import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/netlink"
 )
 
 var (
@@ -42,6 +51,37 @@ func TestProcCmdline(t *testing.T) {
 	if len(proc.Args) == 0 {
 		t.Error("Proc Args should not be empty:", proc.Args)
 	}
+
+	p := NewProcess(myPid, "")
+	p.Args = []string{"original", "args"}
+	p.RereadCmdline()
+	if len(p.Args) != len(proc.Args) || p.Args[0] != proc.Args[0] {
+		t.Error("Cmdline not read again:", p.Args)
+	}
+}
+
+func TestProcParents(t *testing.T) {
+	proc.ReadParents()
+
+	if proc.PPID != os.Getppid() {
+		t.Error("Proc PPID not equal to", os.Getppid(), proc.PPID)
+	}
+	if proc.Parent == nil || proc.Parent.ID != os.Getppid() {
+		t.Error("Proc parent not read:", proc.Parent)
+	}
+	parents := proc.Parents()
+	if len(parents) == 0 || parents[len(parents)-1].PPID != 0 {
+		t.Error("Proc parents chain not read up to init:", len(parents))
+	}
+
+	other := NewProcess(os.Getpid(), "")
+	other.ReadParents()
+	if other.Parent != proc.Parent {
+		t.Error("Proc parent not cached:", other.Parent)
+	}
+	if serialized := other.SerializeParents(); len(serialized) != len(parents) || serialized[0].Pid != uint64(os.Getppid()) {
+		t.Error("Proc parents not serialized:", serialized)
+	}
 }
 
 func TestProcDescriptors(t *testing.T) {
@@ -60,6 +100,360 @@ func TestProcEnv(t *testing.T) {
 	}
 }
 
+func TestProcEnvFilter(t *testing.T) {
+	SetEnvVars([]string{"PATH"})
+	SetRulesEnvVars([]string{"HOME"})
+	defer SetEnvVars([]string{})
+	defer SetRulesEnvVars([]string{})
+
+	envProc := NewProcess(myPid, "fakeComm")
+	envProc.ReadEnv()
+	if len(envProc.Env) != 2 || envProc.Env["PATH"] == "" || envProc.Env["HOME"] == "" {
+		t.Error("Proc Env not filtered:", envProc.Env)
+	}
+}
+
+func TestProcContainer(t *testing.T) {
+	id := "4f1e5a4c5c42b91cb8f31c2b4bba2a1d4e0f8a6b6c0b5ad8a03e3e2f9d521c4e"
+	cgroups := map[string]string{
+		"0::/system.slice/docker-" + id + ".scope":  RuntimeDocker,
+		"12:pids:/docker/" + id:                     RuntimeDocker,
+		"0::/machine.slice/libpod-" + id + ".scope": RuntimePodman,
+		"0::/kubepods.slice/kubepods-besteffort.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope": RuntimeContainerd,
+		"0::/kubepods.slice/kubepods-pod1.slice/crio-" + id + ".scope":                                     RuntimeCrio,
+		"0::/user.slice/user-1000.slice/session-2.scope":                                                   "",
+		"0::/machine.slice/libpod-conmon-" + id + ".scope":                                                 "",
+	}
+	for cgroup, runtime := range cgroups {
+		r, cid := parseContainerCgroup(cgroup + "\n")
+		if r != runtime || (runtime != "" && cid != id) {
+			t.Error("Invalid container of the cgroup:", cgroup, r, cid)
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "procmon_test_containers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dockerContainersPath = tmpDir
+	os.Mkdir(tmpDir+"/"+id, 0700)
+	config := `{"ID": "` + id + `", "Name": "/web", "Config": {"Image": "nginx:latest", "Labels": {"com.docker.compose.project": "blog"}}}`
+	ioutil.WriteFile(tmpDir+"/"+id+"/config.v2.json", []byte(config), 0600)
+
+	c := getContainer("0::/system.slice/docker-" + id + ".scope")
+	if c == nil || c.Name != "web" || c.Image != "nginx:latest" || c.Labels["com.docker.compose.project"] != "blog" {
+		t.Error("Invalid docker container:", c)
+	}
+	if getContainer("0::/user.slice/user-1000.slice/session-2.scope") != nil {
+		t.Error("Process not in a container detected as a container")
+	}
+
+	// rootless podman containers, read from the API of podman.
+	apiID := strings.Repeat("ab", 32)
+	socket := tmpDir + "/podman.sock"
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/"+apiID+"/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"Id": "` + apiID + `", "Name": "db", "Config": {"Image": "docker.io/library/postgres:16", "Labels": {"app": "blog"}}}`))
+	}))
+	containerAPISockets[RuntimePodman] = socket
+	defer func() { containerAPISockets[RuntimePodman] = "/run/podman/podman.sock" }()
+
+	c = getContainer("0::/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-" + apiID + ".scope")
+	if c == nil || c.Name != "db" || c.Image != "docker.io/library/postgres:16" || c.Labels["app"] != "blog" {
+		t.Error("Invalid podman container read from the API:", c)
+	}
+	if s := c.Serialize(); s == nil || s.Id != apiID || s.Runtime != RuntimePodman {
+		t.Error("Invalid serialized container:", s)
+	}
+	var none *Container
+	if none.Serialize() != nil {
+		t.Error("Process without container serialized")
+	}
+}
+
+func TestProcNetNS(t *testing.T) {
+	ns := GetNetNS(myPid)
+	if ns == 0 || ns != OwnNetNS() {
+		t.Error("Invalid network namespace:", ns, OwnNetNS())
+	}
+	if GetNetNS(-1) != 0 {
+		t.Error("Network namespace of an invalid PID")
+	}
+}
+
+func TestProcNamespace(t *testing.T) {
+	pids := readNSpids(myPid)
+	if len(pids) == 0 || pids[0] != myPid {
+		t.Fatal("Invalid NSpid:", pids)
+	}
+	if pid := HostPID(getNamespace(myPid, "pid"), pids[len(pids)-1]); pid != myPid {
+		t.Error("Invalid host PID:", pid)
+	}
+	// the test runs in the namespaces of the daemon.
+	p := NewProcess(myPid, "")
+	p.ReadPath()
+	p.ReadNamespace()
+	if p.NSPID != 0 || p.HostPath != "" {
+		t.Error("Invalid namespace of the process:", p.NSPID, p.HostPath)
+	}
+
+	layers := parseOverlayLayers("rw,lowerdir=l/ABCD:l/EFGH,upperdir=/var/lib/docker/overlay2/1234/diff,workdir=/var/lib/docker/overlay2/1234/work")
+	expected := []string{"/var/lib/docker/overlay2/1234/diff", "/var/lib/docker/overlay2/l/ABCD", "/var/lib/docker/overlay2/l/EFGH"}
+	if strings.Join(layers, ":") != strings.Join(expected, ":") {
+		t.Error("Invalid overlay layers:", layers)
+	}
+
+	mountinfo, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mountinfo.Name())
+	mountinfo.WriteString("21 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n" +
+		"591 477 0:52 / / rw,relatime master:240 - overlay overlay rw,lowerdir=/lower1:/lower2,upperdir=/upper/diff,workdir=/upper/work\n" +
+		"592 591 0:54 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw\n")
+	mountinfo.Close()
+	if layers := readRootLayers(mountinfo.Name()); strings.Join(layers, ":") != "/upper/diff:/lower1:/lower2" {
+		t.Error("Invalid layers of the root filesystem:", layers)
+	}
+}
+
+func TestProcStartTime(t *testing.T) {
+	start, err := GetStartTime(myPid)
+	if err != nil {
+		t.Fatal("Error reading the start time:", err)
+	}
+	if start.After(time.Now()) || time.Since(start) > time.Hour {
+		t.Error("Invalid start time:", start)
+	}
+	ticks, err := parseStartTime("1234 (my (weird) comm) S 1 1234 1234 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 567 1000 10")
+	if err != nil || ticks != 567 {
+		t.Error("Invalid start time parsed:", ticks, err)
+	}
+}
+
+func TestProcKey(t *testing.T) {
+	key, err := GetProcKey(myPid)
+	if err != nil || key.PID != myPid || key.StartTime == 0 {
+		t.Fatal("Invalid key of the process:", key, err)
+	}
+	if key.Reused() {
+		t.Error("Own PID reused")
+	}
+	// other process started with the same PID
+	if other := (ProcKey{PID: myPid, StartTime: key.StartTime + 1}); !other.Reused() {
+		t.Error("PID reused not detected")
+	}
+
+	AddToActivePidsCache(uint64(myPid), proc)
+	if p := findProcessInActivePidsCache(uint64(myPid)); p != proc {
+		t.Error("Process not found in the active pids:", p)
+	}
+	// the process cached is not returned for other process with the same PID.
+	activePidsLock.Lock()
+	delete(activePids, key)
+	activePids[ProcKey{PID: myPid, StartTime: key.StartTime + 1}] = proc
+	activePidsLock.Unlock()
+	if p := findProcessInActivePidsCache(uint64(myPid)); p != nil {
+		t.Error("Process of a reused PID found in the active pids:", p)
+	}
+	activePidsLock.Lock()
+	delete(activePids, ProcKey{PID: myPid, StartTime: key.StartTime + 1})
+	activePidsLock.Unlock()
+}
+
+func TestProcAppID(t *testing.T) {
+	info := "[Application]\nname=org.mozilla.firefox\nruntime=runtime/org.freedesktop.Platform/x86_64/23.08\n\n[Instance]\nname=other\n"
+	if id := parseFlatpakInfo(info); id != "org.mozilla.firefox" {
+		t.Error("Invalid flatpak ID:", id)
+	}
+
+	cgroups := map[string][2]string{
+		"0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-flatpak-org.mozilla.firefox-4242.scope":  {AppFlatpak, "org.mozilla.firefox"},
+		"0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-flatpak-com.example.my\\x2dapp-12.scope": {AppFlatpak, "com.example.my-app"},
+		"0::/user.slice/user-1000.slice/user@1000.service/app.slice/snap.firefox.firefox-2f4f2c63.scope":         {AppSnap, "firefox"},
+		"0::/system.slice/snap.lxd.daemon.service":                                                               {AppSnap, "lxd"},
+		"0::/user.slice/user-1000.slice/session-2.scope":                                                         {"", ""},
+	}
+	for cgroup, want := range cgroups {
+		if appType, id := parseAppCgroup(cgroup); appType != want[0] || id != want[1] {
+			t.Error("Invalid application of the cgroup:", cgroup, appType, id)
+		}
+	}
+
+	environ := "HOME=/home/alice\x00APPIMAGE=/home/alice/Apps/Editor-1.2.AppImage\x00APPDIR=/tmp/.mount_EditorAbc"
+	if path := getEnviron(environ, "APPIMAGE"); path != "/home/alice/Apps/Editor-1.2.AppImage" {
+		t.Error("Invalid AppImage path:", path)
+	}
+
+	p := NewProcess(-1, "firefox")
+	p.Path = "/snap/firefox/3836/usr/lib/firefox/firefox"
+	p.ReadAppID()
+	if p.AppID != "firefox" || p.AppType != AppSnap {
+		t.Error("Invalid snap of the path:", p.AppID, p.AppType)
+	}
+}
+
+func TestProcCgroup(t *testing.T) {
+	cgroups := map[string][2]string{
+		"0::/system.slice/ssh.service": {"/system.slice/ssh.service", "ssh.service"},
+		"0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-org.gnome.Terminal.slice/vte-spawn.scope": {"/user.slice/user-1000.slice/user@1000.service/app.slice/app-org.gnome.Terminal.slice/vte-spawn.scope", "vte-spawn.scope"},
+		"0::/user.slice/user-1000.slice":                                                {"/user.slice/user-1000.slice", "user-1000.slice"},
+		"12:pids:/system.slice/cron.service\n1:name=systemd:/system.slice/cron.service": {"/system.slice/cron.service", "cron.service"},
+		"1:name=systemd:/init.scope\n0::/init.scope":                                    {"/init.scope", "init.scope"},
+		"0::/": {"/", ""},
+	}
+	for raw, want := range cgroups {
+		cgroup := parseCgroup(raw)
+		if cgroup != want[0] {
+			t.Error("Invalid cgroup:", raw, cgroup)
+		}
+		if unit := systemdUnit(cgroup); unit != want[1] {
+			t.Error("Invalid systemd unit of the cgroup:", cgroup, unit)
+		}
+	}
+
+	proc.ReadCgroup()
+	if proc.Cgroup == "" {
+		t.Error("Proc cgroup not read")
+	}
+}
+
+func TestProcTampered(t *testing.T) {
+	if ownPath, err := os.Executable(); err == nil && IsTampered(myPid, ownPath) {
+		t.Error("Proc binary tampered:", ownPath)
+	}
+
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+	tmpDir, err := ioutil.TempDir("", "procmon_test_tampered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	raw, err := ioutil.ReadFile(sleepPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binPath := tmpDir + "/sleep"
+	ioutil.WriteFile(binPath, raw, 0700)
+	cmd := exec.Command(binPath, "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("unable to execute the binary:", err)
+	}
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	if IsTampered(pid, binPath) {
+		t.Error("Binary tampered before being replaced")
+	}
+	// reinstalled with the same content
+	ioutil.WriteFile(binPath+".new", raw, 0700)
+	os.Rename(binPath+".new", binPath)
+	if IsTampered(pid, binPath) {
+		t.Error("Binary reinstalled with the same content tampered")
+	}
+	ioutil.WriteFile(binPath+".new", []byte("#!/bin/sh\n"), 0700)
+	os.Rename(binPath+".new", binPath)
+	if !IsTampered(pid, binPath) {
+		t.Error("Binary replaced not tampered")
+	}
+	os.Remove(binPath)
+	if !IsTampered(pid, binPath) {
+		t.Error("Binary deleted not tampered")
+	}
+}
+
+func TestProcEvents(t *testing.T) {
+	if err := StartProcEvents(); err != nil {
+		t.Skip("Unable to listen to the proc connector:", err)
+	}
+	defer StopProcEvents()
+	if pids, tracked := getTrackedPids(); !tracked || len(pids) == 0 {
+		t.Fatal("Processes running not tracked:", pids)
+	}
+
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+	cmd := exec.Command(sleepPath, "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("unable to execute sleep:", err)
+	}
+	pid := cmd.Process.Pid
+	var details *Process
+	for i := 0; i < 50 && details == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+		details = getExecDetails(pid)
+	}
+	if details == nil {
+		cmd.Process.Kill()
+		t.Skip("exec event not received, the proc connector may not be available")
+	}
+	if pids, _ := getTrackedPids(); len(pids) == 0 || pids[0] != pid {
+		t.Error("The last process executed is not the first one tracked:", pid, pids)
+	}
+	if len(details.Args) != 2 || details.Args[1] != "10" {
+		t.Error("Invalid args of the process executed:", details.Args)
+	}
+
+	// once exited, the process is identified with the details read when it
+	// was executed.
+	cmd.Process.Kill()
+	cmd.Wait()
+	proc := FindProcess(pid, false)
+	if proc == nil || proc.Path != details.Path || proc.Comm != "sleep" {
+		t.Fatal("Process exited not found:", proc)
+	}
+	if len(proc.ExecArgs) != 2 || proc.ExecArgs[1] != "10" {
+		t.Error("Invalid args of the process when it was executed:", proc.ExecArgs)
+	}
+}
+
+func TestProcSession(t *testing.T) {
+	cgroups := map[string]string{
+		"0::/user.slice/user-1000.slice/session-2.scope":                     "2",
+		"1:name=systemd:/user.slice/user-1000.slice/session-c1.scope":        "c1",
+		"0::/user.slice/user-1000.slice/user@1000.service/app.slice/a.scope": "",
+		"0::/system.slice/cron.service":                                      "",
+	}
+	for cgroup, id := range cgroups {
+		if sid := parseSessionCgroup(cgroup + "\n"); sid != id {
+			t.Error("Invalid session of the cgroup:", cgroup, sid, id)
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "procmon_test_sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	sessionsPath = tmpDir
+	ioutil.WriteFile(tmpDir+"/2", []byte("# This is private data. Do not parse.\nUID=1000\nUSER=alice\nACTIVE=1\nTYPE=wayland\nCLASS=user\nSEAT=seat0\n"), 0600)
+
+	s, err := GetSession("2")
+	if err != nil {
+		t.Fatal("GetSession() error:", err)
+	}
+	if s.UID != 1000 || s.User != "alice" || s.Type != "wayland" || s.Class != "user" || s.Seat != "seat0" {
+		t.Error("Invalid session:", s)
+	}
+	if _, err := GetSession("3"); err == nil {
+		t.Error("GetSession() of a session that doesn't exist")
+	}
+}
+
 func TestProcIOStats(t *testing.T) {
 	proc.readIOStats()
 
@@ -128,3 +522,100 @@ func TestProcCleanPath(t *testing.T) {
 		t.Error("Proc cleanPath() not cleaned:", proc.Path)
 	}
 }
+
+func TestProcChecksum(t *testing.T) {
+	expected, _ := fileChecksum("/proc/self/exe")
+	if sum := proc.Checksum(); sum == "" || sum != expected {
+		t.Error("Proc Checksum not equal to", expected, sum)
+	}
+
+	binFile, err := ioutil.TempFile("", "procmon_test_bin_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(binFile.Name())
+	binFile.WriteString("#!/bin/sh\n")
+	binFile.Close()
+
+	// not running processes are read from the path
+	fakeProc := NewProcess(-1, "fakeComm")
+	fakeProc.Path = binFile.Name()
+	sum := fakeProc.Checksum()
+	if sum != "a8076d3d28d21e02012b20eaf7dbf75409a6277134439025f282e368e3305abf" {
+		t.Error("Proc Checksum invalid:", sum)
+	}
+
+	next := time.Now().Add(time.Second)
+	ioutil.WriteFile(binFile.Name(), []byte("#!/bin/bash\n"), 0600)
+	os.Chtimes(binFile.Name(), next, next)
+	if newSum := fakeProc.Checksum(); newSum == sum || newSum == "" {
+		t.Error("Proc Checksum not updated:", newSum)
+	}
+	select {
+	case <-ChecksumEvents():
+	default:
+		t.Error("Proc Checksum change not notified")
+	}
+}
+
+func TestProcExecEvents(t *testing.T) {
+	// without subscribers the processes are not sent anywhere.
+	PublishExec(proc)
+
+	events := SubscribeExecEvents()
+	PublishExec(proc)
+	select {
+	case ev := <-events:
+		if ev.PID != proc.ID || ev.Path != proc.Path {
+			t.Error("Invalid exec event:", ev)
+		}
+	default:
+		t.Error("Exec event not received")
+	}
+
+	// the events are dropped when the subscriber doesn't read them.
+	for i := 0; i < maxQueuedExecEvents+1; i++ {
+		PublishExec(proc)
+	}
+	if len(events) != maxQueuedExecEvents {
+		t.Error("Exec events not dropped:", len(events))
+	}
+
+	UnsubscribeExecEvents(events)
+	for range events {
+	}
+	if _, open := <-events; open {
+		t.Error("Exec events channel not closed")
+	}
+
+	expected, _ := fileChecksum("/proc/self/exe")
+	if sum := PathChecksum("/proc/self/exe"); sum != expected {
+		t.Error("Path checksum not equal to", expected, sum)
+	}
+}
+
+func TestProcEventsWorkerSession(t *testing.T) {
+	old, oldCancel := context.WithCancel(context.Background())
+	defer oldCancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	procTrackLock.Lock()
+	procTrackCtx, procTrackCancel = ctx, cancel
+	procTracked = make(map[int]*trackedProc)
+	procTrackLock.Unlock()
+	defer StopProcEvents()
+
+	// the events of a previous session stop after the new one has started.
+	events := make(chan netlink.ProcEvent)
+	close(events)
+	procEventsWorker(old, events)
+	if _, tracked := getTrackedPids(); !tracked || ctx.Err() != nil {
+		t.Fatal("The worker of a previous session stopped the current one")
+	}
+
+	events = make(chan netlink.ProcEvent)
+	close(events)
+	procEventsWorker(ctx, events)
+	if _, tracked := getTrackedPids(); tracked || ctx.Err() == nil {
+		t.Error("The worker didn't stop its session")
+	}
+}
@@ -0,0 +1,227 @@
+package procmon
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/netlink"
+)
+
+// With the proc monitor method, the processes are tracked with the events of
+// the kernel proc connector (fork, exec, exit), instead of walking /proc on
+// every new connection: the sockets are searched first in the processes alive,
+// the most recently started first, and the processes are read as soon as
+// they're executed, to identify them even if they exit before their
+// connections are parsed.
+
+// time to keep the processes that have exited, to identify the connections
+// queued before they exited.
+var procExitedTTL = 5 * time.Second
+
+type trackedProc struct {
+	// order in which the processes were started, to search the most recent
+	// ones first.
+	seq    uint64
+	exited time.Time
+	// details read when the process was executed (comm, path, args and cwd),
+	// nil if it was started before the daemon.
+	exec *Process
+}
+
+var (
+	procTrackLock sync.RWMutex
+	// processes by PID, nil if the proc connector is not being used.
+	procTracked     map[int]*trackedProc
+	procTrackSeq    uint64
+	procTrackCancel context.CancelFunc
+	// context of the current session of the proc connector.
+	procTrackCtx context.Context
+)
+
+// StartProcEvents starts tracking the processes with the proc connector.
+func StartProcEvents() error {
+	StopProcEvents()
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := netlink.ListenProcEvents(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	procTrackLock.Lock()
+	procTrackCancel = cancel
+	procTrackCtx = ctx
+	procTracked = make(map[int]*trackedProc)
+	readTrackedPids()
+	procTrackLock.Unlock()
+
+	go procEventsWorker(ctx, events)
+	return nil
+}
+
+// StopProcEvents stops tracking the processes. The sockets are searched again
+// walking /proc.
+func StopProcEvents() {
+	procTrackLock.Lock()
+	defer procTrackLock.Unlock()
+	stopProcEvents()
+}
+
+// stopProcSession stops tracking the processes, if the given session is the
+// current one. A worker whose session has already been replaced by a new one
+// must not stop the new one.
+func stopProcSession(ctx context.Context) {
+	procTrackLock.Lock()
+	defer procTrackLock.Unlock()
+	if procTrackCtx == ctx {
+		stopProcEvents()
+	}
+}
+
+// stopProcEvents must be called with the lock held.
+func stopProcEvents() {
+	if procTrackCancel != nil {
+		procTrackCancel()
+		procTrackCancel = nil
+	}
+	procTrackCtx = nil
+	procTracked = nil
+}
+
+// readTrackedPids adds the processes running to the tracked ones, in the
+// order they were started. Must be called with the lock held.
+func readTrackedPids() {
+	pids := getProcPids("/proc/")
+	// the list is sorted by time, the most recent first.
+	for i := len(pids) - 1; i >= 0; i-- {
+		if _, found := procTracked[pids[i]]; !found {
+			procTrackSeq++
+			procTracked[pids[i]] = &trackedProc{seq: procTrackSeq}
+		}
+	}
+}
+
+func procEventsWorker(ctx context.Context, events <-chan netlink.ProcEvent) {
+	purgeTicker := time.NewTicker(procExitedTTL)
+	defer purgeTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() == nil {
+					log.Warning("proc connector stopped, walking /proc to find the processes")
+					stopProcSession(ctx)
+				}
+				return
+			}
+			handleProcEvent(&ev)
+		case <-purgeTicker.C:
+			purgeExitedProcs()
+		}
+	}
+}
+
+func handleProcEvent(ev *netlink.ProcEvent) {
+	if ev.What == netlink.ProcEventLost {
+		log.Debug("proc connector events lost, reading the processes running")
+		procTrackLock.Lock()
+		if procTracked != nil {
+			readTrackedPids()
+		}
+		procTrackLock.Unlock()
+		return
+	}
+	if ev.IsThread() {
+		return
+	}
+
+	var exec *Process
+	if ev.What == netlink.ProcEventExec {
+		exec = NewProcess(ev.PID, "")
+		exec.ReadComm()
+		exec.ReadPath()
+		exec.ReadCmdline()
+		exec.ReadCwd()
+	}
+
+	procTrackLock.Lock()
+	defer procTrackLock.Unlock()
+	if procTracked == nil {
+		return
+	}
+	switch ev.What {
+	case netlink.ProcEventFork:
+		procTrackSeq++
+		p := &trackedProc{seq: procTrackSeq}
+		// the child runs the binary of the parent until it executes other one.
+		if parent, found := procTracked[ev.ParentPID]; found {
+			p.exec = parent.exec
+		}
+		procTracked[ev.PID] = p
+	case netlink.ProcEventExec:
+		p, found := procTracked[ev.PID]
+		if !found {
+			procTrackSeq++
+			p = &trackedProc{seq: procTrackSeq}
+			procTracked[ev.PID] = p
+		}
+		p.exec = exec
+	case netlink.ProcEventExit:
+		if p, found := procTracked[ev.PID]; found {
+			p.exited = time.Now()
+		}
+	}
+}
+
+func purgeExitedProcs() {
+	procTrackLock.Lock()
+	defer procTrackLock.Unlock()
+	for pid, p := range procTracked {
+		if !p.exited.IsZero() && time.Since(p.exited) > procExitedTTL {
+			delete(procTracked, pid)
+		}
+	}
+}
+
+// IsTrackingProcEvents returns true if the processes are being tracked with the
+// proc connector.
+func IsTrackingProcEvents() bool {
+	procTrackLock.RLock()
+	defer procTrackLock.RUnlock()
+	return procTracked != nil
+}
+
+// getTrackedPids returns the PIDs of the processes alive, the most recently
+// started first. ok is false if the processes are not being tracked.
+func getTrackedPids() (pids []int, ok bool) {
+	procTrackLock.RLock()
+	defer procTrackLock.RUnlock()
+	if procTracked == nil {
+		return nil, false
+	}
+	pids = make([]int, 0, len(procTracked))
+	for pid, p := range procTracked {
+		if p.exited.IsZero() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return procTracked[pids[i]].seq > procTracked[pids[j]].seq
+	})
+	return pids, true
+}
+
+// getExecDetails returns the details of a process read when it was executed,
+// or of its parent if it didn't execute other binary. nil if they're not known.
+func getExecDetails(pid int) *Process {
+	procTrackLock.RLock()
+	defer procTrackLock.RUnlock()
+	if p, found := procTracked[pid]; found {
+		return p.exec
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Session is the logind session a process belongs to.
+type Session struct {
+	ID   string
+	UID  int
+	User string
+	// x11, wayland, mir, tty or unspecified
+	Type string
+	// user, greeter, lock-screen or background
+	Class string
+	Seat  string
+}
+
+var (
+	// logind's directory of the user sessions.
+	sessionsPath = "/run/systemd/sessions"
+
+	// the processes of a session are in the cgroup /user.slice/user-<uid>.slice/session-<id>.scope
+	reSessionCgroup = regexp.MustCompile(`/session-([^/]+)\.scope$`)
+)
+
+// ReadSession reads the logind session of the process, from its cgroups.
+// Session is nil if the process doesn't belong to any session (system
+// services, containers, ...).
+func (p *Process) ReadSession() {
+	cgroup, err := ioutil.ReadFile(fmt.Sprint("/proc/", p.ID, "/cgroup"))
+	if err != nil {
+		return
+	}
+	id := parseSessionCgroup(string(cgroup))
+	if id == "" {
+		return
+	}
+	p.Session, _ = GetSession(id)
+}
+
+// parseSessionCgroup returns the ID of the session of the cgroups of a process.
+func parseSessionCgroup(cgroup string) string {
+	for _, line := range strings.Split(cgroup, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if m := reSessionCgroup.FindStringSubmatch(fields[2]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// GetSession reads the state of a session: /run/systemd/sessions/<id>
+func GetSession(id string) (*Session, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(sessionsPath, id))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{ID: id, UID: -1}
+	for _, line := range strings.Split(string(raw), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "UID":
+			if uid, err := strconv.Atoi(kv[1]); err == nil {
+				s.UID = uid
+			}
+		case "USER":
+			s.User = kv[1]
+		case "TYPE":
+			s.Type = kv[1]
+		case "CLASS":
+			s.Class = kv[1]
+		case "SEAT":
+			s.Seat = kv[1]
+		}
+	}
+	return s, nil
+}
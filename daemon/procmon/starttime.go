@@ -0,0 +1,104 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// clock ticks per second of the times of /proc/<pid>/stat (USER_HZ),
+	// which is 100 on all the architectures supported by Linux.
+	clockTicks = uint64(100)
+
+	bootTime     time.Time
+	bootTimeOnce sync.Once
+)
+
+// ProcKey identifies a process. The PIDs are reused once the processes exit,
+// but not with the same start time.
+type ProcKey struct {
+	PID int
+	// clock ticks since boot when the process was started.
+	StartTime uint64
+}
+
+// GetProcKey returns the key of the process running with the given PID.
+func GetProcKey(pid int) (ProcKey, error) {
+	data, err := ioutil.ReadFile(fmt.Sprint("/proc/", pid, "/stat"))
+	if err != nil {
+		return ProcKey{PID: pid}, err
+	}
+	ticks, err := parseStartTime(string(data))
+	return ProcKey{PID: pid, StartTime: ticks}, err
+}
+
+// Reused returns true if the PID of the key is used by other process. It's
+// false if the process is still running, or if the PID is not in use.
+func (k ProcKey) Reused() bool {
+	if k.StartTime == 0 {
+		return false
+	}
+	cur, err := GetProcKey(k.PID)
+	return err == nil && cur.StartTime != k.StartTime
+}
+
+// ReadStartTime reads when the process was started.
+func (p *Process) ReadStartTime() {
+	p.StartTime, _ = GetStartTime(p.ID)
+}
+
+// GetStartTime returns when a process was started.
+func GetStartTime(pid int) (time.Time, error) {
+	data, err := ioutil.ReadFile(fmt.Sprint("/proc/", pid, "/stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	ticks, err := parseStartTime(string(data))
+	if err != nil {
+		return time.Time{}, err
+	}
+	boot := getBootTime()
+	if boot.IsZero() {
+		return time.Time{}, fmt.Errorf("unable to read the boot time")
+	}
+	return boot.Add(time.Duration(ticks * uint64(time.Second) / clockTicks)), nil
+}
+
+// parseStartTime returns the start time of a process of /proc/<pid>/stat, in
+// clock ticks since boot.
+func parseStartTime(stat string) (uint64, error) {
+	// the command of the process (2nd field) can contain spaces and parenthesis.
+	end := strings.LastIndex(stat, ")")
+	if end == -1 {
+		return 0, fmt.Errorf("invalid stat format")
+	}
+	// fields after the command, starting with the state (3rd field).
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid stat format")
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+func getBootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		data, err := ioutil.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(line, "btime ") {
+				continue
+			}
+			if btime, err := strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64); err == nil {
+				bootTime = time.Unix(btime, 0)
+			}
+			break
+		}
+	})
+	return bootTime
+}
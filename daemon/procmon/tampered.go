@@ -0,0 +1,78 @@
+package procmon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// maximum number of results to cache, the cache is emptied when it's reached.
+const maxCachedTampered = 1024
+
+var (
+	tamperedLock sync.Mutex
+	// results of the checks, by the files executed and on disk (device and
+	// inode), so the binaries are only compared once.
+	tamperedCache = make(map[string]bool)
+)
+
+// IsTampered returns true if the binary of a process has been deleted, or
+// replaced with a different one, since it was executed.
+// The binaries reinstalled with the same content (upgrades of other
+// packages, ...) are not considered tampered.
+func IsTampered(pid int, path string) bool {
+	if path == "" {
+		return false
+	}
+	exe := fmt.Sprint("/proc/", pid, "/exe")
+	link, err := os.Readlink(exe)
+	if err != nil {
+		return false
+	}
+	running, err := os.Stat(exe)
+	if err != nil {
+		return false
+	}
+	// the path is resolved in the mount namespace of the process (containers, ...)
+	diskPath := filepath.Join(fmt.Sprint("/proc/", pid, "/root"), path)
+	onDisk, err := os.Stat(diskPath)
+	if err != nil {
+		return strings.HasSuffix(link, " (deleted)")
+	}
+	if os.SameFile(running, onDisk) {
+		return false
+	}
+
+	key := fileKey(running) + "-" + fileKey(onDisk)
+	tamperedLock.Lock()
+	tampered, found := tamperedCache[key]
+	tamperedLock.Unlock()
+	if found {
+		return tampered
+	}
+
+	tampered = running.Size() != onDisk.Size()
+	if !tampered {
+		runningSum, err1 := fileChecksum(exe)
+		diskSum, err2 := fileChecksum(diskPath)
+		tampered = err1 != nil || err2 != nil || runningSum != diskSum
+	}
+
+	tamperedLock.Lock()
+	if len(tamperedCache) >= maxCachedTampered {
+		tamperedCache = make(map[string]bool)
+	}
+	tamperedCache[key] = tampered
+	tamperedLock.Unlock()
+	return tampered
+}
+
+func fileKey(info os.FileInfo) string {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprint(st.Dev, ":", st.Ino)
+	}
+	return fmt.Sprint(info.Name(), ":", info.ModTime().UnixNano())
+}
@@ -0,0 +1,430 @@
+package rule
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// A bundle is a signed archive (tar.gz) of rules and the lists they use, to
+// distribute vetted policies to the endpoints:
+//
+//   rules/<name>.json    the rules, with the lists relative to the bundle.
+//   lists/<dir>/<file>   the files of the directories of lists of the rules.
+//   MANIFEST             the SHA-256 of the files of the bundle.
+//   MANIFEST.sig         the ed25519 signature of the manifest.
+//
+// The keys are ed25519 keys encoded in base64. The signature and the hashes
+// of the files are verified before installing anything.
+
+const (
+	bundleManifest  = "MANIFEST"
+	bundleSignature = "MANIFEST.sig"
+	bundleRulesDir  = "rules"
+	bundleListsDir  = "lists"
+
+	// maximum size of a file of a bundle.
+	bundleMaxFileSize = 512 << 20
+)
+
+// GenerateBundleKeys creates a key pair to sign bundles: the private key is
+// written to privFile, and the public key to pubFile.
+func GenerateBundleKeys(privFile, pubFile string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := writeBundleKey(privFile, priv, 0600); err != nil {
+		return err
+	}
+	return writeBundleKey(pubFile, pub, 0644)
+}
+
+func writeBundleKey(fileName string, key []byte, perm os.FileMode) error {
+	raw := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := ioutil.WriteFile(fileName, []byte(raw), perm); err != nil {
+		return fmt.Errorf("Error writing key %s: %s", fileName, err)
+	}
+	return nil
+}
+
+func readBundleKey(fileName string, size int) ([]byte, error) {
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading key %s: %s", fileName, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("Invalid key %s", fileName)
+	}
+	return key, nil
+}
+
+// ExportBundle writes the given rules, or all the permanent rules if names is
+// empty, and their lists, to a bundle signed with the private key of keyFile.
+func (l *Loader) ExportBundle(fileName, keyFile string, names []string) error {
+	priv, err := readBundleKey(keyFile, ed25519.PrivateKeySize)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string][]byte)
+	l.RLock()
+	if len(names) == 0 {
+		for _, name := range l.rulesKeys {
+			if l.rules[name].Duration == Always {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		r, found := l.rules[name]
+		if !found {
+			l.RUnlock()
+			return fmt.Errorf("Rule %s not found", name)
+		}
+		if err = exportBundleRule(r, files); err != nil {
+			l.RUnlock()
+			return err
+		}
+	}
+	l.RUnlock()
+
+	manifest := bundleManifestOf(files)
+	files[bundleManifest] = manifest
+	files[bundleSignature] = ed25519.Sign(ed25519.PrivateKey(priv), manifest)
+
+	if err := writeBundle(fileName, files); err != nil {
+		return fmt.Errorf("Error writing bundle %s: %s", fileName, err)
+	}
+	log.Info("Exported %d rules to %s", len(names), fileName)
+	return nil
+}
+
+// exportBundleRule adds a rule and its lists to the files of a bundle.
+func exportBundleRule(r *Rule, files map[string][]byte) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// the lists of the copy are replaced by the directories of the bundle,
+	// without modifying the loaded rule.
+	var c Rule
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return err
+	}
+	err = c.Operator.rewriteLists(func(dir string) (string, error) {
+		name := bundleListName(dir)
+		fileList, err := filepath.Glob(filepath.Join(dir, "*.*"))
+		if err != nil {
+			return "", err
+		}
+		for _, listFile := range fileList {
+			if strings.HasPrefix(filepath.Base(listFile), ".") {
+				continue
+			}
+			data, err := ioutil.ReadFile(listFile)
+			if err != nil {
+				return "", fmt.Errorf("Error reading list %s of rule %s: %s", listFile, r.Name, err)
+			}
+			files[path.Join(bundleListsDir, name, filepath.Base(listFile))] = data
+		}
+		return path.Join(bundleListsDir, name), nil
+	})
+	if err != nil {
+		return err
+	}
+	if raw, err = json.MarshalIndent(&c, "", "  "); err != nil {
+		return err
+	}
+	files[path.Join(bundleRulesDir, r.Name+".json")] = raw
+	return nil
+}
+
+// bundleListName returns the name of the directory of a list in a bundle,
+// unique for every directory of lists.
+func bundleListName(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return fmt.Sprintf("%s-%s", filepath.Base(dir), hex.EncodeToString(sum[:4]))
+}
+
+// rewriteLists replaces the directories of the operators of type lists with
+// the ones returned by the given function.
+func (o *Operator) rewriteLists(rewrite func(dir string) (string, error)) error {
+	if o.Type == Lists && !isRemoteList(o.Data) {
+		dir, err := rewrite(o.Data)
+		if err != nil {
+			return err
+		}
+		o.Data = dir
+	}
+	if o.Type != List {
+		return nil
+	}
+	for i := 0; i < len(o.List); i++ {
+		if err := o.List[i].rewriteLists(rewrite); err != nil {
+			return err
+		}
+	}
+	// the rules created from the GUI also have the list in the data field.
+	if o.Data != "" {
+		raw, err := json.Marshal(o.List)
+		if err != nil {
+			return err
+		}
+		o.Data = string(raw)
+	}
+	return nil
+}
+
+// bundleManifestOf returns the manifest of the files of a bundle.
+func bundleManifestOf(files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifest bytes.Buffer
+	for _, name := range names {
+		sum := sha256.Sum256(files[name])
+		fmt.Fprintf(&manifest, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return manifest.Bytes()
+}
+
+func writeBundle(fileName string, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, buf.Bytes(), 0600)
+}
+
+// ReadBundle reads a bundle, and verifies it with the public key of keyFile.
+// It returns the files of the bundle, except the manifest and the signature.
+func ReadBundle(fileName, keyFile string) (map[string][]byte, error) {
+	pub, err := readBundleKey(keyFile, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid bundle %s: %s", fileName, err)
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bundle %s: %s", fileName, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("Invalid bundle %s: %s is not a regular file", fileName, hdr.Name)
+		}
+		if !validBundlePath(hdr.Name) {
+			return nil, fmt.Errorf("Invalid bundle %s: unexpected file %s", fileName, hdr.Name)
+		}
+		if hdr.Size > bundleMaxFileSize {
+			return nil, fmt.Errorf("Invalid bundle %s: %s too big", fileName, hdr.Name)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, bundleMaxFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bundle %s: %s", fileName, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	manifest, sig := files[bundleManifest], files[bundleSignature]
+	if manifest == nil || sig == nil {
+		return nil, fmt.Errorf("Invalid bundle %s: not signed", fileName)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), manifest, sig) {
+		return nil, fmt.Errorf("Invalid bundle %s: bad signature", fileName)
+	}
+	delete(files, bundleManifest)
+	delete(files, bundleSignature)
+	if !bytes.Equal(manifest, bundleManifestOf(files)) {
+		return nil, fmt.Errorf("Invalid bundle %s: the files don't match the manifest", fileName)
+	}
+	return files, nil
+}
+
+// validBundlePath checks that a file of a bundle is the manifest, the
+// signature, a rule or a list, so the bundle can't write anywhere else.
+func validBundlePath(name string) bool {
+	if name == bundleManifest || name == bundleSignature {
+		return true
+	}
+	if path.Clean(name) != name || strings.Contains(name, "..") {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	switch parts[0] {
+	case bundleRulesDir:
+		return len(parts) == 2 && strings.HasSuffix(parts[1], ".json")
+	case bundleListsDir:
+		return len(parts) == 3
+	}
+	return false
+}
+
+// ImportBundle verifies a bundle with the public key of keyFile, and installs
+// its rules, and its lists in the directory listsDir. It returns the names of
+// the rules imported.
+func (l *Loader) ImportBundle(fileName, keyFile, listsDir string) ([]string, error) {
+	files, err := ReadBundle(fileName, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if l.db == nil && l.path == "" {
+		return nil, fmt.Errorf("Rules path not loaded")
+	}
+
+	// the rules are parsed before installing anything.
+	rules := make([]*Rule, 0)
+	for name, data := range files {
+		if !strings.HasPrefix(name, bundleRulesDir+"/") {
+			continue
+		}
+		var r Rule
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("Error parsing %s of bundle %s: %s", name, fileName, err)
+		}
+		if r.Name == "" || strings.ContainsAny(r.Name, "/\\") || strings.HasPrefix(r.Name, ".") {
+			return nil, fmt.Errorf("Invalid rule name '%s' in bundle %s", r.Name, fileName)
+		}
+		err = r.Operator.rewriteLists(func(dir string) (string, error) {
+			if !strings.HasPrefix(dir, bundleListsDir+"/") {
+				return "", fmt.Errorf("rule %s: list %s not in the bundle", r.Name, dir)
+			}
+			return filepath.Join(listsDir, strings.TrimPrefix(dir, bundleListsDir+"/")), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bundle %s: %s", fileName, err)
+		}
+		rules = append(rules, &r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	if err := installBundleLists(files, listsDir); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rules))
+	for _, r := range rules {
+		raw, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return names, err
+		}
+		if err := l.installRule(r.Name, raw); err != nil {
+			return names, err
+		}
+		names = append(names, r.Name)
+	}
+	log.Info("Imported %d rules from %s", len(names), fileName)
+	return names, nil
+}
+
+// installBundleLists writes the lists of a bundle to listsDir, replacing the
+// previous versions of the lists.
+func installBundleLists(files map[string][]byte, listsDir string) error {
+	dirs := make(map[string]map[string]bool)
+	for name, data := range files {
+		parts := strings.Split(name, "/")
+		if parts[0] != bundleListsDir {
+			continue
+		}
+		dir := filepath.Join(listsDir, parts[1])
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Error creating lists directory %s: %s", dir, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, parts[2]), data, 0644); err != nil {
+			return fmt.Errorf("Error writing list %s: %s", name, err)
+		}
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]bool)
+		}
+		dirs[dir][parts[2]] = true
+	}
+	// the files removed from the lists of the bundle are removed too.
+	for dir, installed := range dirs {
+		fileList, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, listFile := range fileList {
+			if !installed[filepath.Base(listFile)] {
+				os.Remove(listFile)
+			}
+		}
+	}
+	return nil
+}
+
+// installRule saves a rule to the database or to the rules directory, and
+// loads it.
+func (l *Loader) installRule(name string, raw []byte) error {
+	if l.db != nil {
+		l.Lock()
+		defer l.Unlock()
+		if err := l.loadRuleData(raw, name); err != nil {
+			return err
+		}
+		return l.saveRuleToDB(name, raw)
+	}
+	fileName := filepath.Join(l.path, fmt.Sprintf("%s.json", name))
+	if err := ioutil.WriteFile(fileName, raw, 0600); err != nil {
+		return fmt.Errorf("Error while saving rule %s to %s: %s", name, fileName, err)
+	}
+	return l.loadRule(fileName)
+}
@@ -0,0 +1,89 @@
+package rule
+
+import "strings"
+
+// domainTrie holds the wildcard entries of the lists of domains (*.example.org),
+// as a tree of labels from the TLD to the subdomains, so a domain is matched
+// against all the wildcards by walking its labels once, regardless of the
+// number of entries of the lists.
+type domainTrie struct {
+	root trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	// the subdomains of this node are in the list.
+	wildcard bool
+	list     string
+}
+
+func newDomainTrie() *domainTrie {
+	return &domainTrie{}
+}
+
+// nextLabel returns the last label of a domain, and the rest of the domain.
+func nextLabel(domain string) (label, rest string) {
+	idx := strings.LastIndexByte(domain, '.')
+	if idx == -1 {
+		return domain, ""
+	}
+	return domain[idx+1:], domain[:idx]
+}
+
+// Add adds the subdomains of a domain to the trie. It returns false if the
+// domain was already added.
+func (t *domainTrie) Add(domain, list string) bool {
+	node := &t.root
+	for rest := strings.TrimSuffix(domain, "."); rest != ""; {
+		var label string
+		label, rest = nextLabel(rest)
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, found := node.children[label]
+		if !found {
+			child = &trieNode{}
+			node.children[label] = child
+		}
+		node = child
+	}
+	if node == &t.root || node.wildcard {
+		return false
+	}
+	node.wildcard = true
+	node.list = list
+	t.size++
+
+	return true
+}
+
+// Match checks if the domain is a subdomain of any of the domains of the trie,
+// and returns the list it belongs to.
+func (t *domainTrie) Match(domain string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	node := &t.root
+	for rest := strings.TrimSuffix(domain, "."); rest != ""; {
+		var label string
+		label, rest = nextLabel(rest)
+		child, found := node.children[label]
+		if !found {
+			return "", false
+		}
+		node = child
+		if node.wildcard && rest != "" {
+			return node.list, true
+		}
+	}
+	return "", false
+}
+
+// Len returns the number of wildcards of the trie.
+func (t *domainTrie) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
@@ -0,0 +1,239 @@
+package rule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/vishvananda/netlink"
+)
+
+// Besides once, until restart and always, the duration of the rules can be:
+// - a duration since the rule was created: 30s, 2h, 7d, 1w, 1d12h
+//   (the units of time.ParseDuration, plus d for days and w for weeks).
+// - until-logout: the rule expires when the session of the user that created
+//   it ends (see BindSession()).
+// - until-network-change: the rule expires when the addresses of the network
+//   interfaces change (a new network is joined, the VPN is disconnected, etc).
+//
+// When a rule expires, it's removed. If the rule is saved on disk, it's
+// disabled instead, so it can be enabled again from the GUI.
+
+// Event based durations of the rules.
+const (
+	UntilLogout        = Duration("until-logout")
+	UntilNetworkChange = Duration("until-network-change")
+)
+
+var (
+	daysRegex = regexp.MustCompile(`([0-9]+(\.[0-9]+)?)([dw])`)
+
+	// logind's directory of the user sessions.
+	sessionsPath = "/run/systemd/sessions"
+	// audit session id of the processes, which logind uses as the session id.
+	procSessionPath = "/proc/%d/sessionid"
+	// interval to check if a session has ended.
+	sessionsInterval = 5 * time.Second
+	// time to wait for more changes of the network, before expiring the rules.
+	networkChangeDelay = 3 * time.Second
+)
+
+// parseDuration parses the duration of a temporary rule.
+func parseDuration(d Duration) (time.Duration, error) {
+	expr := daysRegex.ReplaceAllStringFunc(string(d), func(s string) string {
+		parts := daysRegex.FindStringSubmatch(s)
+		n, _ := strconv.ParseFloat(parts[1], 64)
+		if parts[3] == "w" {
+			n *= 7
+		}
+		return fmt.Sprint(n*24, "h")
+	})
+	duration, err := time.ParseDuration(expr)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid rule duration: %s", d)
+	}
+	return duration, nil
+}
+
+// expireRule removes or disables a rule that has expired. It must be called
+// with the lock held.
+func (l *Loader) expireRule(r *Rule) {
+	log.Info("Temporary rule expired: %s - %s", r.Name, r.Duration)
+	fileName := filepath.Join(l.path, fmt.Sprintf("%s.json", r.Name))
+	if l.isSaved(r.Name) {
+		r.Enabled = false
+		if err := l.Save(r, fileName); err != nil {
+			log.Warning("Error disabling expired rule: %s", err)
+		}
+	} else {
+		delete(l.rules, r.Name)
+		l.sortRules()
+		l.updateEnvVars()
+	}
+
+	select {
+	case l.expiredRules <- r:
+	default:
+	}
+	l.sendChange(RuleExpired, r)
+}
+
+// expireRules expires the rules with the given event based duration.
+func (l *Loader) expireRules(d Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, r := range l.rules {
+		if r.Duration == d && r.Enabled {
+			l.expireRule(r)
+		}
+	}
+}
+
+// ExpiredRules returns the channel where the rules that expire are notified.
+func (l *Loader) ExpiredRules() <-chan *Rule {
+	return l.expiredRules
+}
+
+// userSession is the logind session an until-logout rule is bound to.
+// If the process that created the rule wasn't part of a session, the rule is
+// bound to the user, and it expires when the last session of the user ends.
+type userSession struct {
+	// empty when the rule is bound to the user.
+	id  string
+	uid int
+}
+
+// BindSession binds an until-logout rule to the session of the given process,
+// or to the given user if the process is not part of a session.
+// The rules not bound to any session expire when any session ends.
+func (r *Rule) BindSession(pid, uid int) {
+	if r.Duration != UntilLogout {
+		return
+	}
+	r.session = &userSession{uid: uid}
+	raw, err := ioutil.ReadFile(fmt.Sprintf(procSessionPath, pid))
+	if err != nil {
+		return
+	}
+	id := strings.TrimSpace(string(raw))
+	if _, err := readSessionUID(id); err == nil {
+		r.session.id = id
+	}
+}
+
+// readSessionUID returns the user of a logind session.
+func readSessionUID(id string) (int, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(sessionsPath, id))
+	if err != nil {
+		return -1, err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.HasPrefix(line, "UID=") {
+			return strconv.Atoi(strings.TrimPrefix(line, "UID="))
+		}
+	}
+	return -1, nil
+}
+
+// readSessions returns the current logind sessions, and their users.
+func readSessions() (map[string]int, error) {
+	files, err := ioutil.ReadDir(sessionsPath)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string]int, len(files))
+	for _, f := range files {
+		// logind also writes the .ref files of the sessions.
+		if f.IsDir() || strings.Contains(f.Name(), ".") {
+			continue
+		}
+		uid, _ := readSessionUID(f.Name())
+		sessions[f.Name()] = uid
+	}
+	return sessions, nil
+}
+
+// ended checks if the session of the rule is not one of the given sessions.
+func (s *userSession) ended(sessions map[string]int) bool {
+	if s.id != "" {
+		_, found := sessions[s.id]
+		return !found
+	}
+	for _, uid := range sessions {
+		if uid == s.uid {
+			return false
+		}
+	}
+	return true
+}
+
+// expireSessionRules expires the until-logout rules whose session is not
+// one of the current sessions. It's called when a session ends.
+func (l *Loader) expireSessionRules(current map[string]int) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, r := range l.rules {
+		if r.Duration != UntilLogout || !r.Enabled {
+			continue
+		}
+		if r.session == nil || r.session.ended(current) {
+			l.expireRule(r)
+		}
+	}
+}
+
+// sessionsMonitor expires the until-logout rules when their logind session ends.
+func (l *Loader) sessionsMonitor() {
+	sessions, err := readSessions()
+	if err != nil {
+		log.Warning("Unable to monitor the user sessions, %s rules will expire on restart: %s", UntilLogout, err)
+		return
+	}
+	for {
+		time.Sleep(sessionsInterval)
+		current, err := readSessions()
+		if err != nil {
+			continue
+		}
+		for s := range sessions {
+			if _, found := current[s]; !found {
+				log.Debug("User session ended: %s", s)
+				l.expireSessionRules(current)
+				break
+			}
+		}
+		sessions = current
+	}
+}
+
+// networkMonitor expires the until-network-change rules when the addresses of
+// the network interfaces change.
+func (l *Loader) networkMonitor() {
+	updates := make(chan netlink.AddrUpdate)
+	done := make(chan struct{})
+	if err := netlink.AddrSubscribe(updates, done); err != nil {
+		log.Warning("Unable to monitor the network, %s rules will expire on restart: %s", UntilNetworkChange, err)
+		return
+	}
+	for update := range updates {
+		log.Debug("Network address changed: %s", update.LinkAddress.String())
+		// a change of network usually involves several changes of addresses.
+		timeout := time.After(networkChangeDelay)
+	Wait:
+		for {
+			select {
+			case <-updates:
+			case <-timeout:
+				break Wait
+			}
+		}
+		l.expireRules(UntilNetworkChange)
+	}
+}
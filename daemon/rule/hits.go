@@ -0,0 +1,163 @@
+package rule
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// DefaultHitsFile is the file where the hits of the rules are saved, if no
+// other file has been configured.
+const DefaultHitsFile = "/var/lib/opensnitch/rules-hits.json"
+
+// interval to save the hits of the rules to disk.
+var hitsSaveInterval = time.Minute
+
+// Hits are the number of connections matched by a rule, and when it matched
+// the last one.
+type Hits struct {
+	Count   uint64    `json:"count"`
+	LastHit time.Time `json:"last_hit"`
+}
+
+// ruleHits holds the hits of the rules by name, so they're kept when the
+// rules are reloaded.
+type ruleHits struct {
+	sync.Mutex
+	file          string
+	hits          map[string]*Hits
+	changed       bool
+	workerRunning bool
+}
+
+// SetHitsFile loads the hits of the rules saved in the given file, and saves
+// them periodically. If path is empty, DefaultHitsFile is used.
+func (l *Loader) SetHitsFile(path string) {
+	if path == "" {
+		path = DefaultHitsFile
+	}
+	h := &l.hits
+	h.Lock()
+	defer h.Unlock()
+	if h.file == path {
+		return
+	}
+	h.file = path
+	if h.hits == nil {
+		h.hits = make(map[string]*Hits)
+	}
+
+	if core.Exists(path) {
+		saved := make(map[string]*Hits)
+		raw, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(raw, &saved)
+		}
+		if err != nil {
+			log.Warning("Error loading the hits of the rules from %s: %s", path, err)
+		}
+		for name, sh := range saved {
+			if cur, found := h.hits[name]; found {
+				cur.Count += sh.Count
+				if sh.LastHit.After(cur.LastHit) {
+					cur.LastHit = sh.LastHit
+				}
+				continue
+			}
+			h.hits[name] = sh
+		}
+		log.Debug("Loaded the hits of %d rules from %s", len(saved), path)
+	}
+
+	if !h.workerRunning {
+		h.workerRunning = true
+		go l.hitsWorker()
+	}
+}
+
+// addHits counts a connection matched by the given rules.
+func (l *Loader) addHits(rules ...*Rule) {
+	h := &l.hits
+	h.Lock()
+	defer h.Unlock()
+	if h.hits == nil {
+		h.hits = make(map[string]*Hits)
+	}
+	now := time.Now()
+	for _, r := range rules {
+		rh, found := h.hits[r.Name]
+		if !found {
+			rh = &Hits{}
+			h.hits[r.Name] = rh
+		}
+		rh.Count++
+		rh.LastHit = now
+	}
+	h.changed = true
+}
+
+// deleteHits forgets the hits of a deleted rule.
+func (l *Loader) deleteHits(name string) {
+	h := &l.hits
+	h.Lock()
+	defer h.Unlock()
+	if _, found := h.hits[name]; found {
+		delete(h.hits, name)
+		h.changed = true
+	}
+}
+
+// GetHits returns the hits of the rules, by rule name.
+func (l *Loader) GetHits() map[string]Hits {
+	h := &l.hits
+	h.Lock()
+	defer h.Unlock()
+	hits := make(map[string]Hits, len(h.hits))
+	for name, rh := range h.hits {
+		hits[name] = *rh
+	}
+	return hits
+}
+
+// SaveHits writes the hits of the rules to disk, if they've changed.
+func (l *Loader) SaveHits() error {
+	h := &l.hits
+	h.Lock()
+	defer h.Unlock()
+	if h.file == "" || !h.changed {
+		return nil
+	}
+	raw, err := json.Marshal(h.hits)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.file), 0700); err != nil {
+		return err
+	}
+	// the file is replaced atomically, not to lose the hits if the daemon is
+	// stopped while writing it.
+	tmpFile := h.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, raw, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, h.file); err != nil {
+		return err
+	}
+	h.changed = false
+	return nil
+}
+
+func (l *Loader) hitsWorker() {
+	for {
+		time.Sleep(hitsSaveInterval)
+		if err := l.SaveHits(); err != nil {
+			log.Warning("Error saving the hits of the rules: %s", err)
+		}
+	}
+}
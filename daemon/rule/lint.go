@@ -0,0 +1,214 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// Types of the problems found by the rules analyzer.
+const (
+	// the rule never matches, because an earlier rule matches the same connections.
+	LintShadowed = "shadowed"
+	// two rules have the same conditions, but one allows the connections and
+	// the other one denies them.
+	LintConflict = "conflict"
+	// the rule can't be loaded or enabled.
+	LintInvalid = "invalid"
+)
+
+// LintFinding is a problem found in a rule.
+type LintFinding struct {
+	Type string `json:"type"`
+	Rule string `json:"rule"`
+	// the rule that causes the problem, if any.
+	Related string `json:"related,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s", f.Rule, f.Message)
+}
+
+// Lint analyzes the rules, and returns the rules that are unreachable, the
+// rules that contradict each other, and the rules that are invalid.
+func (l *Loader) Lint() []LintFinding {
+	l.RLock()
+	defer l.RUnlock()
+
+	findings := []LintFinding{}
+	for name, err := range l.invalid {
+		findings = append(findings, LintFinding{
+			Type:    LintInvalid,
+			Rule:    name,
+			Message: fmt.Sprintf("rule not loaded: %s", err),
+		})
+	}
+
+	for i, nameB := range l.rulesKeys {
+		b := l.rules[nameB]
+		if !b.Enabled {
+			if err := checkRegexps(&b.Operator); err != nil {
+				findings = append(findings, LintFinding{
+					Type:    LintInvalid,
+					Rule:    nameB,
+					Message: fmt.Sprintf("the rule can't be enabled: %s", err),
+				})
+			}
+			continue
+		}
+		// rules are evaluated in order, so only the previous rules can
+		// make this rule unreachable, except for the fallback rules, which
+		// are only applied if no other rule matches the connection.
+		candidates := l.rulesKeys[:i]
+		if b.Fallback {
+			candidates = l.rulesKeys
+		}
+		for _, nameA := range candidates {
+			a := l.rules[nameA]
+			// the non-terminating rules don't stop the evaluation of the rules,
+			// and the rules with a startup window only match for a while.
+			if nameA == nameB || !a.Enabled || a.Continue || a.Fallback || a.StartupWindow != "" || !coversProfiles(a, b) || !a.Operator.covers(&b.Operator) {
+				continue
+			}
+			if !b.Continue && !b.Fallback && sameAction(a, b) == false && b.Operator.covers(&a.Operator) {
+				findings = append(findings, LintFinding{
+					Type:    LintConflict,
+					Rule:    nameB,
+					Related: nameA,
+					Message: fmt.Sprintf("has the same conditions as rule %s, but %s the connections instead of %s them", nameA, actionVerb(b.Action), actionVerb(a.Action)),
+				})
+				break
+			}
+			if b.Fallback || shadows(a, b) {
+				findings = append(findings, LintFinding{
+					Type:    LintShadowed,
+					Rule:    nameB,
+					Related: nameA,
+					Message: fmt.Sprintf("never matches, rule %s matches the same connections before", nameA),
+				})
+				break
+			}
+		}
+	}
+
+	return findings
+}
+
+// logLintFindings logs the problems found in the rules.
+func (l *Loader) logLintFindings() {
+	for _, f := range l.Lint() {
+		log.Warning("Rule %s: %s", f.Type, f)
+	}
+}
+
+// shadows checks if the rule a stops the evaluation of the rules when it
+// matches a connection, before reaching the rule b.
+func shadows(a, b *Rule) bool {
+	// the action of the rules with a rate limit changes with the connections.
+	if a.RateLimit != nil {
+		return false
+	}
+	return a.Action == Deny || a.Action == Reject || a.Precedence || a.Priority > b.Priority
+}
+
+func sameAction(a, b *Rule) bool {
+	return (a.Action == Allow) == (b.Action == Allow)
+}
+
+func actionVerb(a Action) string {
+	if a == Allow {
+		return "allows"
+	}
+	return "denies"
+}
+
+// coversProfiles checks if the rule a is evaluated in all the profiles the
+// rule b is evaluated.
+func coversProfiles(a, b *Rule) bool {
+	if len(a.Profiles) == 0 {
+		return true
+	}
+	if len(b.Profiles) == 0 {
+		return false
+	}
+	for _, p := range b.Profiles {
+		if !a.InProfile(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditions returns the conditions that a connection must meet to match the operator.
+func (o *Operator) conditions() []*Operator {
+	if o.Type != List {
+		return []*Operator{o}
+	}
+	conds := make([]*Operator, 0, len(o.List))
+	for i := 0; i < len(o.List); i++ {
+		conds = append(conds, &o.List[i])
+	}
+	return conds
+}
+
+// covers checks if all the connections matched by the operator b are also
+// matched by the operator o. The analysis is conservative: it only returns
+// true if all the conditions of o are met by a condition of b.
+func (o *Operator) covers(b *Operator) bool {
+	for _, ca := range o.conditions() {
+		found := false
+		for _, cb := range b.conditions() {
+			if ca.coversCondition(cb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Operator) coversCondition(b *Operator) bool {
+	if o.Operand == OpTrue && !o.Negate {
+		return true
+	}
+	if o.Operand != b.Operand || o.Negate != b.Negate {
+		return false
+	}
+	if o.Type == b.Type && o.Data == b.Data && (o.Sensitive == b.Sensitive || o.Sensitive == false) {
+		return true
+	}
+	if o.Sensitive && b.Sensitive == false {
+		return false
+	}
+	// the negated conditions only cover the same values.
+	if o.Negate {
+		return false
+	}
+	switch {
+	case o.Type == Simple && b.Type == Simple:
+		return o.Sensitive == false && strings.EqualFold(o.Data, b.Data)
+	case o.Type == Regexp && b.Type == Simple && o.re != nil:
+		return o.reCmp(b.Data)
+	}
+	return false
+}
+
+// checkRegexps returns the error of the first invalid regular expression of
+// the operator.
+func checkRegexps(o *Operator) error {
+	for _, c := range o.conditions() {
+		if c.Type != Regexp {
+			continue
+		}
+		if _, err := regexp.Compile(c.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
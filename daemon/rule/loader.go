@@ -15,6 +15,8 @@ import (
 	"github.com/evilsocket/opensnitch/daemon/conman"
 	"github.com/evilsocket/opensnitch/daemon/core"
 	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+	"github.com/evilsocket/opensnitch/daemon/sqlite"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -26,9 +28,35 @@ type Loader struct {
 	path              string
 	rules             map[string]*Rule
 	rulesKeys         []string
+	rulesList         []*Rule
 	watcher           *fsnotify.Watcher
 	liveReload        bool
 	liveReloadRunning bool
+	// only the rules of this profile, and the rules without profiles, are evaluated.
+	profile string
+
+	// connections allowed by rules with a quota.
+	flows     map[string]*trackedFlow
+	flowsLock sync.Mutex
+	// value of nf_conntrack_acct before enabling it, restored on exit.
+	conntrackAcct  []byte
+	stopAccounting chan struct{}
+
+	// database where the rules are stored, instead of the directory of rules.
+	db *sqlite.DB
+	// names of the rules of the files of the rules directory.
+	files map[string]string
+	// errors of the rules that couldn't be loaded.
+	invalid map[string]string
+	// connections matched by each rule.
+	hits ruleHits
+
+	// rules that have expired, to notify the GUI.
+	expiredRules chan *Rule
+	// rules added, changed or deleted.
+	ruleChanges  chan RuleChange
+	sessionsOnce sync.Once
+	networkOnce  sync.Once
 }
 
 // NewLoader loads rules from disk, and watches for changes made to the rules files
@@ -44,6 +72,10 @@ func NewLoader(liveReload bool) (*Loader, error) {
 		liveReload:        liveReload,
 		watcher:           watcher,
 		liveReloadRunning: false,
+		expiredRules:      make(chan *Rule, 32),
+		ruleChanges:       make(chan RuleChange, 64),
+		files:             make(map[string]string),
+		invalid:           make(map[string]string),
 	}, nil
 }
 
@@ -90,6 +122,7 @@ func (l *Loader) Load(path string) error {
 			continue
 		}
 	}
+	l.logLintFindings()
 
 	if l.liveReload && l.liveReloadRunning == false {
 		go l.liveReloadWorker()
@@ -106,12 +139,44 @@ func (l *Loader) loadRule(fileName string) error {
 	l.Lock()
 	defer l.Unlock()
 
+	var named struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(raw, &named)
+	if err := l.loadRuleData(raw, fileName); err != nil {
+		return err
+	}
+	// the name of the rule has been changed in the file.
+	if oldName, found := l.files[fileName]; found && oldName != named.Name {
+		if oldRule, found := l.rules[oldName]; found {
+			log.Info("Rule %s renamed to %s", oldName, named.Name)
+			l.cleanListsRule(oldRule)
+			delete(l.rules, oldName)
+			l.sortRules()
+			l.updateEnvVars()
+		}
+	}
+	l.files[fileName] = named.Name
+
+	return nil
+}
+
+// loadRuleData parses and compiles a rule in JSON format, read from a file or
+// from the database. It must be called with the lock held.
+func (l *Loader) loadRuleData(raw []byte, source string) (err error) {
 	var r Rule
 	err = json.Unmarshal(raw, &r)
 	if err != nil {
-		return fmt.Errorf("Error parsing rule from %s: %s", fileName, err)
+		return fmt.Errorf("Error parsing rule from %s: %s", source, err)
 	}
 	raw = nil
+	defer func() {
+		if err != nil {
+			l.invalid[r.Name] = err.Error()
+		} else {
+			delete(l.invalid, r.Name)
+		}
+	}()
 
 	if oldRule, found := l.rules[r.Name]; found {
 		l.cleanListsRule(oldRule)
@@ -130,18 +195,55 @@ func (l *Loader) loadRule(fileName string) error {
 				}
 			}
 		}
+		if r.Quota != nil {
+			if err := r.Quota.Compile(); err != nil {
+				log.Warning("Quota.Compile() error: %s", err)
+				return fmt.Errorf("(1) Error compiling rule quota: %s", err)
+			}
+			if oldRule, found := l.rules[r.Name]; found {
+				r.Quota.inherit(oldRule.Quota)
+			}
+		}
+		if r.Prompt != nil {
+			if err := r.Prompt.Compile(); err != nil {
+				log.Warning("Prompt.Compile() error: %s", err)
+				return fmt.Errorf("(1) Error compiling rule prompt: %s", err)
+			}
+		}
+		if err := r.compileStartupWindow(); err != nil {
+			return fmt.Errorf("(1) Error compiling rule startup window: %s", err)
+		}
+		if r.RateLimit != nil {
+			if err := r.RateLimit.Compile(); err != nil {
+				log.Warning("RateLimit.Compile() error: %s", err)
+				return fmt.Errorf("(1) Error compiling rule rate limit: %s", err)
+			}
+			if oldRule, found := l.rules[r.Name]; found {
+				r.RateLimit.inherit(oldRule.RateLimit)
+			}
+		}
+	}
+	if l.isTemporary(&r) {
+		// the rules saved on disk expire since they were created, not since
+		// they're loaded.
+		since := r.Created
+		if since.IsZero() {
+			since = time.Now()
+		}
+		if err := l.scheduleTemporaryRule(&r, since); err != nil {
+			return fmt.Errorf("(1) Error scheduling temporary rule: %s", err)
+		}
 	}
 	if oldRule, found := l.rules[r.Name]; found {
 		l.deleteOldRuleFromDisk(oldRule, &r)
 	}
 
-	log.Debug("Loaded rule from %s: %s", fileName, r.String())
+	log.Debug("Loaded rule from %s: %s", source, r.String())
+	_, exists := l.rules[r.Name]
 	l.rules[r.Name] = &r
 	l.sortRules()
-
-	if l.isTemporary(&r) {
-		err = l.scheduleTemporaryRule(r)
-	}
+	l.updateEnvVars()
+	l.notifyChange(exists, &r)
 
 	return nil
 }
@@ -153,6 +255,14 @@ func (l *Loader) deleteRule(filePath string) {
 	fileName := filepath.Base(filePath)
 	ruleName := fileName[:len(fileName)-5]
 
+	l.Lock()
+	if name, found := l.files[filePath]; found {
+		ruleName = name
+		delete(l.files, filePath)
+	}
+	delete(l.invalid, ruleName)
+	l.Unlock()
+
 	l.RLock()
 	rule, found := l.rules[ruleName]
 	delRule := found && rule.Duration == Always
@@ -163,6 +273,9 @@ func (l *Loader) deleteRule(filePath string) {
 }
 
 func (l *Loader) deleteRuleFromDisk(ruleName string) error {
+	if l.db != nil {
+		return l.deleteRuleFromDB(ruleName)
+	}
 	path := fmt.Sprint(l.path, "/", ruleName, ".json")
 	return os.Remove(path)
 }
@@ -191,6 +304,9 @@ func (l *Loader) cleanListsRule(oldRule *Rule) {
 	}
 }
 
+// time to wait after the last change of a rule file before reloading it.
+var liveReloadDelay = 500 * time.Millisecond
+
 func (l *Loader) liveReloadWorker() {
 	l.liveReloadRunning = true
 
@@ -201,31 +317,52 @@ func (l *Loader) liveReloadWorker() {
 		return
 	}
 
+	// the changes of a file are applied once it hasn't changed for a while,
+	// because editors and tools usually write the files in several steps
+	// (truncate + write, or write to a temporary file + rename).
+	// The timers are only accessed from this goroutine: when one expires, the
+	// file is sent back here to be reloaded, and its timer is deleted.
+	timers := make(map[string]*time.Timer)
+	expired := make(chan string)
 	for {
 		select {
 		case event := <-l.watcher.Events:
-			// a new rule json file has been created or updated
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				if strings.HasSuffix(event.Name, ".json") {
-					log.Important("Ruleset changed due to %s, reloading ...", path.Base(event.Name))
-					if err := l.loadRule(event.Name); err != nil {
-						log.Warning("%s", err)
-					}
-				}
-			} else if event.Op&fsnotify.Remove == fsnotify.Remove {
-				if strings.HasSuffix(event.Name, ".json") {
-					log.Important("Rule deleted %s", path.Base(event.Name))
-					// we only need to delete from memory rules of type Always,
-					// because the Remove event is of a file, i.e.: Duration == Always
-					l.deleteRule(event.Name)
-				}
+			if !strings.HasSuffix(event.Name, ".json") || event.Op&fsnotify.Chmod == event.Op {
+				continue
 			}
+			if t, found := timers[event.Name]; found {
+				t.Stop()
+			}
+			fileName := event.Name
+			timers[fileName] = time.AfterFunc(liveReloadDelay, func() {
+				expired <- fileName
+			})
+		case fileName := <-expired:
+			delete(timers, fileName)
+			l.reloadFile(fileName)
 		case err := <-l.watcher.Errors:
 			log.Error("File system watcher error: %s", err)
 		}
 	}
 }
 
+// reloadFile loads a rule file that has been created, modified or moved into
+// the rules directory, or deletes the rule if the file has been deleted or
+// moved out of the directory.
+func (l *Loader) reloadFile(fileName string) {
+	if core.Exists(fileName) {
+		log.Important("Ruleset changed due to %s, reloading ...", path.Base(fileName))
+		if err := l.loadRule(fileName); err != nil {
+			log.Warning("%s", err)
+		}
+		return
+	}
+	log.Important("Rule deleted %s", path.Base(fileName))
+	// we only need to delete from memory rules of type Always,
+	// because the Remove event is of a file, i.e.: Duration == Always
+	l.deleteRule(fileName)
+}
+
 func (l *Loader) isTemporary(r *Rule) bool {
 	return r.Duration != Restart && r.Duration != Always && r.Duration != Once
 }
@@ -247,12 +384,31 @@ func (l *Loader) setUniqueName(rule *Rule) {
 	}
 }
 
+// sortRules sorts the rules by priority, from the highest to the lowest, and
+// the rules with the same priority by name.
 func (l *Loader) sortRules() {
 	l.rulesKeys = make([]string, 0, len(l.rules))
 	for k := range l.rules {
 		l.rulesKeys = append(l.rulesKeys, k)
 	}
 	sort.Strings(l.rulesKeys)
+	sort.SliceStable(l.rulesKeys, func(i, j int) bool {
+		return l.rules[l.rulesKeys[i]].Priority > l.rules[l.rulesKeys[j]].Priority
+	})
+	l.rulesList = make([]*Rule, 0, len(l.rulesKeys))
+	for _, k := range l.rulesKeys {
+		l.rulesList = append(l.rulesList, l.rules[k])
+	}
+}
+
+// updateEnvVars configures the environment variables used by the rules, so
+// they're read from the processes.
+func (l *Loader) updateEnvVars() {
+	names := []string{}
+	for _, r := range l.rules {
+		names = append(names, r.Operator.envVars()...)
+	}
+	procmon.SetRulesEnvVars(names)
 }
 
 func (l *Loader) addUserRule(rule *Rule) {
@@ -297,37 +453,84 @@ func (l *Loader) replaceUserRule(rule *Rule) (err error) {
 				}
 			}
 		}
+		if rule.Quota != nil {
+			if err := rule.Quota.Compile(); err != nil {
+				log.Warning("Quota.Compile() error: %s", err)
+				return fmt.Errorf("(2) Error compiling rule quota: %s", err)
+			}
+			if found {
+				rule.Quota.inherit(oldRule.Quota)
+			}
+		}
+		if rule.Prompt != nil {
+			if err := rule.Prompt.Compile(); err != nil {
+				log.Warning("Prompt.Compile() error: %s", err)
+				return fmt.Errorf("(2) Error compiling rule prompt: %s", err)
+			}
+		}
+		if err := rule.compileStartupWindow(); err != nil {
+			return fmt.Errorf("(2) Error compiling rule startup window: %s", err)
+		}
+		if rule.RateLimit != nil {
+			if err := rule.RateLimit.Compile(); err != nil {
+				log.Warning("RateLimit.Compile() error: %s", err)
+				return fmt.Errorf("(2) Error compiling rule rate limit: %s", err)
+			}
+			if found {
+				rule.RateLimit.inherit(oldRule.RateLimit)
+			}
+		}
+	}
+	// the rules received from the GUI don't have the session they're bound to.
+	if found && rule.session == nil && rule.Duration == oldRule.Duration {
+		rule.session = oldRule.session
 	}
 	l.Lock()
 	l.rules[rule.Name] = rule
 	l.sortRules()
-	l.Unlock()
-
+	l.updateEnvVars()
 	if l.isTemporary(rule) {
-		err = l.scheduleTemporaryRule(*rule)
+		err = l.scheduleTemporaryRule(rule, time.Now())
 	}
+	l.notifyChange(found, rule)
+	l.Unlock()
 
 	return err
 }
 
-func (l *Loader) scheduleTemporaryRule(rule Rule) error {
-	tTime, err := time.ParseDuration(string(rule.Duration))
+// scheduleTemporaryRule schedules the expiration of a rule. It must be called
+// with the lock held.
+func (l *Loader) scheduleTemporaryRule(rule *Rule, since time.Time) error {
+	if !rule.Enabled {
+		return nil
+	}
+	switch rule.Duration {
+	case UntilLogout:
+		l.sessionsOnce.Do(func() { go l.sessionsMonitor() })
+		return nil
+	case UntilNetworkChange:
+		l.networkOnce.Do(func() { go l.networkMonitor() })
+		return nil
+	}
+
+	tTime, err := parseDuration(rule.Duration)
 	if err != nil {
 		return err
 	}
+	expires := since.Add(tTime)
+	rule.expires = expires
 
-	time.AfterFunc(tTime, func() {
+	name, duration := rule.Name, rule.Duration
+	time.AfterFunc(time.Until(expires), func() {
 		l.Lock()
 		defer l.Unlock()
 
-		log.Info("Temporary rule expired: %s - %s", rule.Name, rule.Duration)
-		if newRule, found := l.rules[rule.Name]; found {
-			if newRule.Duration != rule.Duration {
-				log.Debug("%s temporary rule expired, but has new Duration, old: %s, new: %s", rule.Name, rule.Duration, newRule.Duration)
+		if newRule, found := l.rules[name]; found {
+			if !newRule.expires.Equal(expires) || !newRule.Enabled {
+				log.Debug("%s temporary rule expired, but has been modified, old: %s, new: %s", name, duration, newRule.Duration)
 				return
 			}
-			delete(l.rules, rule.Name)
-			l.sortRules()
+			l.expireRule(newRule)
 		}
 	})
 	return nil
@@ -358,13 +561,16 @@ func (l *Loader) Replace(rule *Rule, saveToDisk bool) error {
 	return nil
 }
 
-// Save a rule to disk.
+// Save a rule to disk, or to the database if the rules are stored in a database.
 func (l *Loader) Save(rule *Rule, path string) error {
 	rule.Updated = time.Now()
 	raw, err := json.MarshalIndent(rule, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Error while saving rule %s to %s: %s", rule, path, err)
 	}
+	if l.db != nil {
+		return l.saveRuleToDB(rule.Name, raw)
+	}
 
 	if err = ioutil.WriteFile(path, raw, 0600); err != nil {
 		return fmt.Errorf("Error while saving rule %s to %s: %s", rule, path, err)
@@ -388,6 +594,9 @@ func (l *Loader) Delete(ruleName string) error {
 
 	delete(l.rules, ruleName)
 	l.sortRules()
+	l.updateEnvVars()
+	l.deleteHits(ruleName)
+	l.sendChange(RuleDeleted, rule)
 
 	if rule.Duration != Always {
 		return nil
@@ -397,26 +606,170 @@ func (l *Loader) Delete(ruleName string) error {
 	return l.deleteRuleFromDisk(ruleName)
 }
 
-// FindFirstMatch will try match the connection against the existing rule set.
-func (l *Loader) FindFirstMatch(con *conman.Connection) (match *Rule) {
+// Operations of the rules notified by RuleChanges.
+const (
+	RuleAdded   = "added"
+	RuleChanged = "changed"
+	RuleDeleted = "deleted"
+	RuleExpired = "expired"
+)
+
+// RuleChange is a rule added, changed, deleted or expired.
+type RuleChange struct {
+	Op   string
+	Rule *Rule
+}
+
+// RuleChanges returns the channel where the changes of the rules are notified.
+func (l *Loader) RuleChanges() <-chan RuleChange {
+	return l.ruleChanges
+}
+
+func (l *Loader) notifyChange(exists bool, r *Rule) {
+	if exists {
+		l.sendChange(RuleChanged, r)
+	} else {
+		l.sendChange(RuleAdded, r)
+	}
+}
+
+// sendChange notifies a change of a rule, discarding it if nobody is reading
+// them.
+func (l *Loader) sendChange(op string, r *Rule) {
+	select {
+	case l.ruleChanges <- RuleChange{Op: op, Rule: r}:
+	default:
+	}
+}
+
+// SetProfile changes the active profile of rules.
+// An empty profile evaluates only the rules that don't belong to any profile.
+func (l *Loader) SetProfile(profile string) {
+	l.Lock()
+	defer l.Unlock()
+	l.profile = strings.TrimSpace(profile)
+	log.Important("Rules profile changed to: %s", l.profile)
+}
+
+// GetProfile returns the active profile of rules.
+func (l *Loader) GetProfile() string {
 	l.RLock()
 	defer l.RUnlock()
+	return l.profile
+}
 
+// GetProfiles returns the profiles defined in the loaded rules.
+func (l *Loader) GetProfiles() []string {
+	l.RLock()
+	defer l.RUnlock()
+
+	profiles := []string{}
+	found := make(map[string]bool)
+	for _, r := range l.rules {
+		for _, p := range r.Profiles {
+			if !found[p] {
+				found[p] = true
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// Search returns the rules that contain the given text, and have all the
+// given tags, in the order they're evaluated.
+func (l *Loader) Search(text string, tags []string) []*Rule {
+	l.RLock()
+	defer l.RUnlock()
+
+	found := []*Rule{}
+Rules:
 	for _, idx := range l.rulesKeys {
-		rule, _ := l.rules[idx]
-		if rule.Enabled == false {
+		r := l.rules[idx]
+		for _, tag := range tags {
+			if !r.HasTag(tag) {
+				continue Rules
+			}
+		}
+		if text == "" || r.Contains(text) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// FindFirstMatch will try match the connection against the existing rule set.
+// The rules are evaluated by priority, and the rules of a priority override
+// the rules of the lower priorities.
+// Among the rules with the same priority, the Deny, Reject and Precedence
+// rules override the Allow rules.
+// The fallback rules are only applied if no other rule matches the connection.
+func (l *Loader) FindFirstMatch(con *conman.Connection) *Rule {
+	match, chain := l.findFirstMatch(con)
+	for _, r := range chain {
+		if !r.Nolog {
+			log.Info("Rule %s matched, continuing: %s", r.Name, con)
+		}
+	}
+	if match != nil {
+		chain = append(chain, match)
+	}
+	if len(chain) > 0 {
+		l.addHits(chain...)
+	}
+	return applyRateLimit(match)
+}
+
+// findFirstMatch returns the rule that matches the connection, without
+// applying its rate limit, and the non-terminating rules that matched the
+// connection before it.
+func (l *Loader) findFirstMatch(con *conman.Connection) (match *Rule, chain []*Rule) {
+	return l.findFirstMatchOf(con, nil)
+}
+
+// findFirstMatchOf returns the rule that matches the connection among the
+// rules accepted by only, or all of them if nil.
+func (l *Loader) findFirstMatchOf(con *conman.Connection, only func(*Rule) bool) (match *Rule, chain []*Rule) {
+	l.RLock()
+	defer l.RUnlock()
+
+	var fallback *Rule
+	defer func() {
+		if match == nil {
+			match = fallback
+		}
+	}()
+	for _, rule := range l.rulesList {
+		if rule.Enabled == false || !rule.InProfile(l.profile) || (only != nil && !only(rule)) {
+			continue
+		}
+		if match != nil && rule.Priority < match.Priority {
+			return match, chain
+		}
+		if rule.Fallback {
+			if fallback == nil && rule.Match(con) {
+				fallback = rule
+			}
 			continue
 		}
 		if rule.Match(con) {
+			if rule.Continue && !rule.Quota.Exceeded() {
+				chain = append(chain, rule)
+				con.Rules = append(con.Rules, rule.Name)
+				con.Tags = rule.addTags(con.Tags)
+				continue
+			}
 			// We have a match.
 			// Save the rule in order to don't ask the user to take action,
 			// and keep iterating until a Deny or a Priority rule appears.
 			match = rule
-			if rule.Action == Reject || rule.Action == Deny || rule.Precedence == true {
-				return rule
+			action := rule.GetAction()
+			if action == Reject || action == Deny || action == Prompt || rule.Precedence == true {
+				return rule, chain
 			}
 		}
 	}
 
-	return match
+	return match, chain
 }
@@ -1,9 +1,12 @@
 package rule
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -273,3 +276,819 @@ func testDurationChange(t *testing.T, l *Loader) {
 		t.Error("testDurationChange, error: rule has been deleted")
 	}
 }
+
+func TestRuleLoaderProfiles(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: profiles")
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	dummyOper.Compile()
+	globalRule := Create("000-global", "rule description global", true, false, false, Allow, Restart, dummyOper)
+	workRule := Create("001-work", "rule description work", true, false, false, Deny, Restart, dummyOper)
+	workRule.Profiles = []string{"work"}
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	l.Add(globalRule, false)
+	l.Add(workRule, false)
+
+	t.Run("No active profile", func(t *testing.T) {
+		if match := l.FindFirstMatch(conn); match == nil || match.Name != "000-global" {
+			t.Error("global rule didn't match: ", match)
+		}
+	})
+	t.Run("Active profile", func(t *testing.T) {
+		l.SetProfile("work")
+		if match := l.FindFirstMatch(conn); match == nil || match.Name != "001-work" {
+			t.Error("profile rule didn't match: ", match)
+		}
+	})
+	t.Run("Other profile", func(t *testing.T) {
+		l.SetProfile("home")
+		if match := l.FindFirstMatch(conn); match == nil || match.Name != "000-global" {
+			t.Error("rule of another profile matched: ", match)
+		}
+	})
+	t.Run("Profiles of the rules", func(t *testing.T) {
+		if profiles := l.GetProfiles(); len(profiles) != 1 || profiles[0] != "work" {
+			t.Error("invalid profiles: ", profiles)
+		}
+	})
+}
+
+func TestRuleLoaderPriority(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: priority")
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	dummyOper.Compile()
+	denyRule := Create("000-deny", "rule description deny", true, false, false, Deny, Restart, dummyOper)
+	allowRule := Create("001-allow", "rule description allow", true, false, false, Allow, Restart, dummyOper)
+	allowLowRule := Create("002-allow", "rule description allow low", true, false, false, Allow, Restart, dummyOper)
+	allowRule.Priority = 10
+	allowLowRule.Priority = 10
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	l.Add(denyRule, false)
+	l.Add(allowRule, false)
+	l.Add(allowLowRule, false)
+
+	t.Run("Rules sorted by priority", func(t *testing.T) {
+		if l.rulesKeys[0] != "001-allow" || l.rulesKeys[1] != "002-allow" || l.rulesKeys[2] != "000-deny" {
+			t.Error("Rules not sorted by priority: ", l.rulesKeys)
+		}
+	})
+	t.Run("Higher priority overrides deny", func(t *testing.T) {
+		if match := l.FindFirstMatch(conn); match == nil || match.Name != "002-allow" {
+			t.Error("priority rule didn't match: ", match)
+		}
+	})
+	t.Run("Precedence within the same priority", func(t *testing.T) {
+		l.rules["001-allow"].Precedence = true
+		if match := l.FindFirstMatch(conn); match == nil || match.Name != "001-allow" {
+			t.Error("precedence rule didn't match: ", match)
+		}
+	})
+}
+
+func TestRuleLoaderSearch(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: search")
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpProcessPath, "/usr/bin/firefox", list)
+	dummyOper.Compile()
+	firefoxRule := Create("000-firefox", "allow the browser", true, false, false, Allow, Restart, dummyOper)
+	firefoxRule.Tags = []string{"browsers", "work"}
+	dummyOper, _ = NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	dummyOper.Compile()
+	curlRule := Create("001-curl", "allow downloads", true, false, false, Allow, Restart, dummyOper)
+	curlRule.Tags = []string{"Work"}
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	l.Add(firefoxRule, false)
+	l.Add(curlRule, false)
+
+	searches := []struct {
+		text  string
+		tags  []string
+		rules []string
+	}{
+		{"", nil, []string{"000-firefox", "001-curl"}},
+		{"", []string{"work"}, []string{"000-firefox", "001-curl"}},
+		{"", []string{"work", "browsers"}, []string{"000-firefox"}},
+		{"FIREFOX", nil, []string{"000-firefox"}},
+		{"downloads", []string{"work"}, []string{"001-curl"}},
+		{"/usr/bin/", []string{"games"}, []string{}},
+	}
+	for _, s := range searches {
+		found := l.Search(s.text, s.tags)
+		if len(found) != len(s.rules) {
+			t.Error("Invalid rules found:", s.text, s.tags, len(found))
+			continue
+		}
+		for i, r := range found {
+			if r.Name != s.rules[i] {
+				t.Error("Invalid rule found:", s.text, s.tags, r.Name)
+			}
+		}
+	}
+}
+
+func TestRuleLoaderLint(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: lint")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	addRule := func(name string, action Action, op *Operator) {
+		if err := l.Add(Create(name, "", true, false, false, action, Restart, op), false); err != nil {
+			t.Error("Error adding rule:", name, err)
+		}
+	}
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	addRule("000-deny-curl", Deny, op)
+	op, _ = NewOperator(List, false, OpList, `[{"type": "simple", "operand": "process.path", "data": "/usr/bin/CURL"}, {"type": "simple", "operand": "dest.port", "data": "443"}]`, list)
+	addRule("001-allow-curl-https", Allow, op)
+	op, _ = NewOperator(Regexp, false, OpProcessPath, "^/usr/lib/firefox/.*", list)
+	addRule("002-allow-firefox", Allow, op)
+	// 002 doesn't stop the evaluation of the rules, so this rule is applied.
+	op, _ = NewOperator(Simple, false, OpProcessPath, "/usr/lib/firefox/firefox", list)
+	addRule("003-deny-firefox", Deny, op)
+	op, _ = NewOperator(Simple, false, OpDstPort, "53", list)
+	addRule("004-allow-dns", Allow, op)
+	op, _ = NewOperator(Simple, false, OpDstPort, "53", list)
+	addRule("005-deny-dns", Deny, op)
+	l.loadRule("testdata/invalid-regexp.json")
+
+	expected := map[string]LintFinding{
+		"001-allow-curl-https": {Type: LintShadowed, Related: "000-deny-curl"},
+		"005-deny-dns":         {Type: LintConflict, Related: "004-allow-dns"},
+		"invalid-regexp":       {Type: LintInvalid},
+	}
+	findings := l.Lint()
+	if len(findings) != len(expected) {
+		t.Error("Invalid number of findings:", findings)
+	}
+	for _, f := range findings {
+		exp, found := expected[f.Rule]
+		if !found || exp.Type != f.Type || exp.Related != f.Related {
+			t.Error("Invalid finding:", f.Type, f.Rule, f.Related, f.Message)
+		}
+	}
+}
+
+func TestRuleLoaderSimulate(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: simulate connections")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(List, false, OpList, `[{"type": "simple", "operand": "process.path", "data": "/usr/bin/curl"}, {"type": "simple", "operand": "dest.port", "data": "443"}]`, list)
+	l.Add(Create("000-allow-curl-https", "", true, false, false, Allow, Restart, op), false)
+	op, _ = NewOperator(Simple, false, OpUserID, "1001", list)
+	l.Add(Create("001-deny-guest", "", true, false, false, Deny, Restart, op), false)
+
+	sims := []struct {
+		sim    Simulation
+		rule   string
+		action Action
+	}{
+		{Simulation{ProcessPath: "/usr/bin/curl", UserID: 1000, DstIP: "1.1.1.1", DstPort: 443}, "000-allow-curl-https", Allow},
+		{Simulation{ProcessPath: "/usr/bin/curl", UserID: 1001, DstIP: "1.1.1.1", DstPort: 443}, "001-deny-guest", Deny},
+		{Simulation{ProcessPath: "/usr/bin/curl", UserID: 1000, DstIP: "1.1.1.1", DstPort: 80}, "", ""},
+	}
+	for _, s := range sims {
+		res, err := l.Simulate(&s.sim)
+		if err != nil {
+			t.Error("Simulate() error:", err)
+			continue
+		}
+		if res.Matched != (s.rule != "") || res.Action != s.action || (res.Matched && res.Rule.Name != s.rule) {
+			t.Error("Invalid simulation result:", s.sim, res.Matched, res.Action)
+		}
+	}
+	if _, err := l.Simulate(&Simulation{ProcessPath: "/usr/bin/curl", DstIP: "not an IP"}); err == nil {
+		t.Error("Simulate() accepted an invalid IP")
+	}
+}
+
+func TestRuleLoaderSimulateDestination(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: simulate connections of unknown processes")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	// the process is not known, so it must not deny the domain for every process.
+	op, _ := NewOperator(List, false, OpList, `[{"type": "simple", "operand": "process.path", "data": "/usr/bin/curl", "negate": true}, {"type": "simple", "operand": "dest.host", "data": "ads.example.com"}]`, list)
+	l.Add(Create("000-deny-ads-not-curl", "", true, false, false, Deny, Restart, op), false)
+	op, _ = NewOperator(Simple, false, OpDstHost, "tracker.example.com", list)
+	l.Add(Create("001-deny-tracker", "", true, false, false, Deny, Restart, op), false)
+	op, _ = NewOperator(List, false, OpList, `[{"type": "network", "operand": "dest.network", "data": "10.0.0.0/8"}, {"type": "simple", "operand": "dest.host", "data": "intranet.example.com"}]`, list)
+	l.Add(Create("002-allow-intranet", "", true, false, false, Allow, Restart, op), false)
+	op, _ = NewOperator(Simple, false, OpTrue, "", list)
+	l.Add(Create("003-deny-all", "", true, false, false, Deny, Restart, op), false)
+
+	sims := []struct {
+		sim    Simulation
+		rule   string
+		action Action
+	}{
+		{Simulation{DstHost: "ads.example.com", DstIP: "1.1.1.1", UserID: -1}, "", ""},
+		{Simulation{DstHost: "tracker.example.com", DstIP: "1.1.1.1", UserID: -1}, "001-deny-tracker", Deny},
+		{Simulation{DstHost: "intranet.example.com", DstIP: "10.1.1.1", UserID: -1}, "002-allow-intranet", Allow},
+		{Simulation{DstHost: "www.example.com", DstIP: "1.1.1.1", UserID: -1}, "", ""},
+	}
+	for _, s := range sims {
+		res, err := l.SimulateDestination(&s.sim)
+		if err != nil {
+			t.Error("SimulateDestination() error:", err)
+			continue
+		}
+		if res.Matched != (s.rule != "") || res.Action != s.action || (res.Matched && res.Rule.Name != s.rule) {
+			t.Error("Invalid simulation result:", s.sim, res.Matched, res.Action)
+		}
+	}
+}
+
+func TestRuleLoaderContinue(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: non-terminating rules")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	tagRule := Create("000-tag-curl", "", true, false, false, Deny, Restart, op)
+	tagRule.Continue = true
+	tagRule.Tags = []string{"downloads"}
+	l.Add(tagRule, false)
+	op, _ = NewOperator(Simple, false, OpDstPort, "443", list)
+	tagRule = Create("001-tag-https", "", true, false, false, Allow, Restart, op)
+	tagRule.Continue = true
+	tagRule.Tags = []string{"https", "Downloads"}
+	l.Add(tagRule, false)
+	op, _ = NewOperator(Simple, false, OpDstPort, "443", list)
+	l.Add(Create("002-allow-https", "", true, false, false, Allow, Restart, op), false)
+
+	res, err := l.Simulate(&Simulation{ProcessPath: "/usr/bin/curl", DstIP: "1.1.1.1", DstPort: 443})
+	if err != nil {
+		t.Fatal("Simulate() error:", err)
+	}
+	// the action of the non-terminating rules is not applied.
+	if !res.Matched || res.Rule.Name != "002-allow-https" || res.Action != Allow {
+		t.Error("Invalid rule matched:", res.Matched, res.Action)
+	}
+	if len(res.Chain) != 2 || res.Chain[0] != "000-tag-curl" || res.Chain[1] != "001-tag-https" {
+		t.Error("Invalid non-terminating rules matched:", res.Chain)
+	}
+	if len(res.Tags) != 2 || res.Tags[0] != "downloads" || res.Tags[1] != "https" {
+		t.Error("Invalid tags of the connection:", res.Tags)
+	}
+
+	res, _ = l.Simulate(&Simulation{ProcessPath: "/usr/bin/curl", DstIP: "1.1.1.1", DstPort: 80})
+	if res.Matched || len(res.Chain) != 1 {
+		t.Error("Non-terminating rule applied:", res.Matched, res.Action, res.Chain)
+	}
+}
+
+func TestRuleLoaderFallback(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: fallback rules")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/usr/lib/firefox/firefox", list)
+	fallbackRule := Create("000-firefox-default", "", true, false, true, Deny, Restart, op)
+	fallbackRule.Fallback = true
+	l.Add(fallbackRule, false)
+	op, _ = NewOperator(List, false, OpList, `[{"type": "simple", "operand": "process.path", "data": "/usr/lib/firefox/firefox"}, {"type": "simple", "operand": "dest.host", "data": "example.org"}]`, list)
+	l.Add(Create("001-allow-firefox-example", "", true, false, false, Allow, Restart, op), false)
+
+	sims := []struct {
+		sim  Simulation
+		rule string
+	}{
+		{Simulation{ProcessPath: "/usr/lib/firefox/firefox", DstHost: "example.org", DstIP: "1.1.1.1", DstPort: 443}, "001-allow-firefox-example"},
+		{Simulation{ProcessPath: "/usr/lib/firefox/firefox", DstHost: "example.com", DstIP: "1.1.1.1", DstPort: 443}, "000-firefox-default"},
+		{Simulation{ProcessPath: "/usr/bin/curl", DstHost: "example.com", DstIP: "1.1.1.1", DstPort: 443}, ""},
+	}
+	for _, s := range sims {
+		res, err := l.Simulate(&s.sim)
+		if err != nil {
+			t.Error("Simulate() error:", err)
+			continue
+		}
+		if res.Matched != (s.rule != "") || (res.Matched && res.Rule.Name != s.rule) {
+			t.Error("Invalid rule matched:", s.sim.ProcessPath, s.sim.DstHost, res.Matched)
+		}
+	}
+}
+
+func TestRuleLoaderStartupWindow(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: rules matching only while the processes start")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/opt/app/app", list)
+	startupRule := Create("000-allow-app-startup", "", true, true, false, Allow, Restart, op)
+	startupRule.StartupWindow = "30s"
+	l.Add(startupRule, false)
+	op, _ = NewOperator(Simple, false, OpProcessPath, "/opt/app/app", list)
+	l.Add(Create("001-deny-app", "", true, false, false, Deny, Restart, op), false)
+
+	sims := []struct {
+		age  string
+		rule string
+	}{
+		{"5s", "000-allow-app-startup"},
+		{"1m", "001-deny-app"},
+		{"", "001-deny-app"},
+	}
+	for _, s := range sims {
+		res, err := l.Simulate(&Simulation{ProcessPath: "/opt/app/app", ProcessAge: s.age, DstIP: "1.1.1.1", DstPort: 443})
+		if err != nil {
+			t.Error("Simulate() error:", err)
+			continue
+		}
+		if !res.Matched || res.Rule.Name != s.rule {
+			t.Error("Invalid rule matched:", s.age, res.Rule)
+		}
+	}
+	if findings := l.Lint(); len(findings) != 0 {
+		t.Error("Rule with a startup window reported:", findings)
+	}
+
+	invalid := Create("002-invalid-window", "", true, false, false, Allow, Restart, op)
+	invalid.StartupWindow = "soon"
+	if err := l.Replace(invalid, false); err == nil {
+		t.Error("Invalid startup window not detected")
+	}
+}
+
+func TestRuleLoaderPrompt(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: rules asking the user")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpDstPort, "22", list)
+	l.Add(Create("000-allow-ssh-port", "", true, false, false, Allow, Restart, op), false)
+	op, _ = NewOperator(Simple, false, OpProcessPath, "/usr/bin/ssh", list)
+	promptRule := Create("001-prompt-ssh", "", true, false, false, Prompt, Restart, op)
+	promptRule.Prompt = &PromptOptions{Timeout: 30}
+	l.Add(promptRule, false)
+	op, _ = NewOperator(Simple, false, OpProcessPath, "/usr/bin/ssh", list)
+	l.Add(Create("002-allow-ssh", "", true, false, false, Allow, Restart, op), false)
+
+	res, err := l.Simulate(&Simulation{ProcessPath: "/usr/bin/ssh", DstIP: "1.1.1.1", DstPort: 22})
+	if err != nil {
+		t.Error("Simulate() error:", err)
+	}
+	if !res.Matched || res.Rule.Name != "001-prompt-ssh" {
+		t.Error("Prompt rule not matched:", res.Rule)
+	}
+
+	r := l.GetAll()["001-prompt-ssh"]
+	if timeout := r.Prompt.GetTimeout(); timeout != 30*time.Second {
+		t.Error("Invalid prompt timeout:", timeout)
+	}
+	if fallback := r.PromptFallback(); fallback.Name != r.Name || fallback.GetAction() != Deny {
+		t.Error("Invalid prompt fallback:", fallback)
+	}
+	var noOptions *PromptOptions
+	if timeout := noOptions.GetTimeout(); timeout != DefaultPromptTimeout {
+		t.Error("Invalid default prompt timeout:", timeout)
+	}
+
+	invalid := Create("003-invalid-prompt", "", true, false, false, Prompt, Restart, op)
+	invalid.Prompt = &PromptOptions{Action: Prompt}
+	if err := l.Replace(invalid, false); err == nil {
+		t.Error("Invalid prompt action not detected")
+	}
+}
+
+func TestRuleLoaderHits(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: hits of the rules")
+
+	hitsFile := tmpDir + "/hits/rules-hits.json"
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	l.SetHitsFile(hitsFile)
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	l.Add(Create("000-allow-curl", "", true, false, false, Allow, Restart, op), false)
+
+	sim := &Simulation{ProcessPath: "/usr/bin/curl", DstIP: "1.1.1.1", DstPort: 443}
+	for i := 0; i < 3; i++ {
+		con, _ := sim.Connection()
+		l.FindFirstMatch(con)
+	}
+	// the simulations are not counted.
+	l.Simulate(sim)
+	hits := l.GetHits()["000-allow-curl"]
+	if hits.Count != 3 || hits.LastHit.IsZero() {
+		t.Error("Invalid hits of the rule:", hits)
+	}
+	if err := l.SaveHits(); err != nil {
+		t.Error("Error saving the hits:", err)
+	}
+
+	t.Run("Load hits", func(t *testing.T) {
+		l2, _ := NewLoader(false)
+		l2.SetHitsFile(hitsFile)
+		if saved := l2.GetHits()["000-allow-curl"]; saved.Count != 3 || !saved.LastHit.Equal(hits.LastHit) {
+			t.Error("Invalid hits loaded:", saved)
+		}
+	})
+	t.Run("Delete rule", func(t *testing.T) {
+		l.Delete("000-allow-curl")
+		if _, found := l.GetHits()["000-allow-curl"]; found {
+			t.Error("Hits of a deleted rule not deleted")
+		}
+	})
+}
+
+func TestRuleLoaderChanges(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: changes of the rules")
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	var list []Operator
+	op, _ := NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	r := Create("000-allow-curl", "", true, false, false, Allow, Restart, op)
+	l.Add(r, false)
+	l.Replace(Create("000-allow-curl", "", true, false, false, Deny, Restart, op), false)
+	l.Delete("000-allow-curl")
+
+	for _, op := range []string{RuleAdded, RuleChanged, RuleDeleted} {
+		select {
+		case change := <-l.RuleChanges():
+			if change.Op != op || change.Rule.Name != "000-allow-curl" {
+				t.Error("Invalid change of the rule, expected", op, "got:", change.Op, change.Rule.Name)
+			}
+		default:
+			t.Error("Change of the rule not notified:", op)
+		}
+	}
+}
+
+func TestRuleLoaderBundle(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: signed bundles of rules")
+
+	bundleDir := tmpDir + "/bundle"
+	srcDir, dstDir, listsDir := bundleDir+"/src", bundleDir+"/dst", bundleDir+"/lists"
+	os.MkdirAll(srcDir, 0700)
+	os.MkdirAll(dstDir, 0700)
+	keyFile := bundleDir + "/key"
+	if err := GenerateBundleKeys(keyFile, keyFile+".pub"); err != nil {
+		t.Fatal("Error generating the keys:", err)
+	}
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	l.Load(srcDir)
+	var list []Operator
+	op, _ := NewOperator(Lists, false, OpDomainsLists, "testdata/lists/domains/", list)
+	l.Add(Create("000-deny-ads", "", true, false, false, Deny, Always, op), true)
+	op, _ = NewOperator(Simple, false, OpProcessPath, "/usr/bin/curl", list)
+	l.Add(Create("001-allow-curl", "", true, false, false, Allow, Always, op), true)
+
+	bundle := bundleDir + "/rules.bundle"
+	if err := l.ExportBundle(bundle, keyFile, nil); err != nil {
+		t.Fatal("Error exporting the bundle:", err)
+	}
+
+	dst, _ := NewLoader(false)
+	dst.Load(dstDir)
+	t.Run("Invalid key", func(t *testing.T) {
+		GenerateBundleKeys(bundleDir+"/other", bundleDir+"/other.pub")
+		if _, err := dst.ImportBundle(bundle, bundleDir+"/other.pub", listsDir); err == nil {
+			t.Error("Bundle verified with another key")
+		}
+		if dst.NumRules() != 0 {
+			t.Error("Rules of an invalid bundle imported")
+		}
+	})
+	t.Run("Tampered bundle", func(t *testing.T) {
+		files, _ := ReadBundle(bundle, keyFile+".pub")
+		files[bundleManifest] = bundleManifestOf(files)
+		files[bundleSignature] = make([]byte, 64)
+		files["rules/002-allow-all.json"] = []byte(`{"name": "002-allow-all"}`)
+		writeBundle(bundleDir+"/tampered.bundle", files)
+		if _, err := dst.ImportBundle(bundleDir+"/tampered.bundle", keyFile+".pub", listsDir); err == nil {
+			t.Error("Tampered bundle verified")
+		}
+	})
+
+	names, err := dst.ImportBundle(bundle, keyFile+".pub", listsDir)
+	if err != nil {
+		t.Fatal("Error importing the bundle:", err)
+	}
+	if len(names) != 2 || dst.NumRules() != 2 {
+		t.Error("Invalid rules imported:", names)
+	}
+	r := dst.GetAll()["000-deny-ads"]
+	if r == nil || !strings.HasPrefix(r.Operator.Data, listsDir+"/domains-") {
+		t.Fatal("Invalid lists of the imported rule:", r)
+	}
+	if _, err := os.Stat(r.Operator.Data + "/domainlists.txt"); err != nil {
+		t.Error("List not installed:", err)
+	}
+	if _, err := os.Stat(dstDir + "/001-allow-curl.json"); err != nil {
+		t.Error("Rule not saved:", err)
+	}
+}
+
+func TestRuleLoaderDurations(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: durations")
+
+	durations := map[Duration]time.Duration{
+		"30s":   30 * time.Second,
+		"2h":    2 * time.Hour,
+		"7d":    7 * 24 * time.Hour,
+		"1w":    7 * 24 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"0.5d":  12 * time.Hour,
+	}
+	for d, expected := range durations {
+		if value, err := parseDuration(d); err != nil || value != expected {
+			t.Error("Invalid duration:", d, value, err)
+		}
+	}
+	for _, d := range []Duration{"7x", "d", "until-tomorrow"} {
+		if _, err := parseDuration(d); err == nil {
+			t.Error("Invalid duration parsed:", d)
+		}
+	}
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	dummyOper.Compile()
+
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	rulesDir := tmpDir + "/durations"
+	os.Mkdir(rulesDir, 0777)
+	if err = l.Load(rulesDir); err != nil {
+		t.Error("Error loading test rules: ", err)
+	}
+
+	t.Run("Temporary rule saved on disk", func(t *testing.T) {
+		r := Create("000-on-disk", "rule description", true, false, false, Allow, "1d", dummyOper)
+		r.Created = time.Now().Add(-25 * time.Hour)
+		if err := l.Save(r, rulesDir+"/000-on-disk.json"); err != nil {
+			t.Error("Error saving rule: ", err)
+		}
+		if err := l.loadRule(rulesDir + "/000-on-disk.json"); err != nil {
+			t.Error("Error loading rule: ", err)
+		}
+		select {
+		case expired := <-l.ExpiredRules():
+			if expired.Name != r.Name {
+				t.Error("Invalid expired rule:", expired.Name)
+			}
+		case <-time.After(time.Second):
+			t.Error("Rule on disk not expired")
+		}
+		l.RLock()
+		diskRule, found := l.rules[r.Name]
+		l.RUnlock()
+		if !found || diskRule.Enabled {
+			t.Error("Expired rule on disk not disabled:", found)
+		}
+	})
+	t.Run("Event based durations", func(t *testing.T) {
+		r := Create("001-network", "rule description", true, false, false, Allow, UntilNetworkChange, dummyOper)
+		if err := l.Add(r, false); err != nil {
+			t.Error("Error adding rule: ", err)
+		}
+		l.expireRules(UntilLogout)
+		testNumRules(t, l, 2)
+		l.expireRules(UntilNetworkChange)
+		testNumRules(t, l, 1)
+		if expired := <-l.ExpiredRules(); expired.Name != r.Name {
+			t.Error("Invalid expired rule:", expired.Name)
+		}
+	})
+	t.Run("Invalid duration saved on disk", func(t *testing.T) {
+		r := Create("002-invalid", "rule description", true, false, false, Allow, "7x", dummyOper)
+		if err := l.Save(r, rulesDir+"/002-invalid.json"); err != nil {
+			t.Error("Error saving rule: ", err)
+		}
+		if err := l.loadRule(rulesDir + "/002-invalid.json"); err == nil {
+			t.Error("Rule with invalid duration loaded")
+		}
+		l.RLock()
+		_, found := l.rules[r.Name]
+		_, invalid := l.invalid[r.Name]
+		l.RUnlock()
+		if found || !invalid {
+			t.Error("Rule with invalid duration not discarded:", found, invalid)
+		}
+	})
+}
+
+func TestRuleLoaderSessions(t *testing.T) {
+	t.Log("Test rules loader: until-logout rules")
+
+	dir := fmt.Sprintf("%s/sessions", tmpDir)
+	os.Mkdir(dir, 0700)
+	os.Mkdir(dir+"/proc", 0700)
+	oldSessionsPath, oldProcSessionPath := sessionsPath, procSessionPath
+	sessionsPath, procSessionPath = dir, dir+"/proc/%d"
+	defer func() {
+		sessionsPath, procSessionPath = oldSessionsPath, oldProcSessionPath
+	}()
+	writeFile := func(name, data string) {
+		if err := ioutil.WriteFile(dir+"/"+name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("2", "# This is private data. Do not parse.\nUID=1000\nUSER=user\n")
+	writeFile("2.ref", "")
+	writeFile("3", "UID=1000\n")
+	writeFile("4", "UID=1001\n")
+	writeFile("proc/100", "2")
+	// not part of a session.
+	writeFile("proc/200", "4294967295")
+
+	sessions, err := readSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 || sessions["2"] != 1000 || sessions["4"] != 1001 {
+		t.Errorf("Invalid sessions: %v", sessions)
+	}
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	dummyOper.Compile()
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// don't monitor the sessions, they're changed by the test.
+	l.sessionsOnce.Do(func() {})
+
+	bySession := Create("000-session", "", true, false, false, Allow, UntilLogout, dummyOper)
+	bySession.BindSession(100, 1000)
+	byUser := Create("001-user", "", true, false, false, Allow, UntilLogout, dummyOper)
+	byUser.BindSession(200, 1000)
+	otherUser := Create("002-other-user", "", true, false, false, Allow, UntilLogout, dummyOper)
+	otherUser.BindSession(300, 1001)
+	unbound := Create("003-unbound", "", true, false, false, Allow, UntilLogout, dummyOper)
+	for _, r := range []*Rule{bySession, byUser, otherUser, unbound} {
+		if err := l.Add(r, false); err != nil {
+			t.Fatal("Error adding rule: ", err)
+		}
+	}
+	if bySession.session.id != "2" || byUser.session.id != "" || byUser.session.uid != 1000 {
+		t.Errorf("Invalid sessions of the rules: %+v, %+v", bySession.session, byUser.session)
+	}
+
+	// the rules received from the GUI keep the session.
+	changed := Create("000-session", "changed", true, false, false, Deny, UntilLogout, dummyOper)
+	if err := l.Replace(changed, false); err != nil {
+		t.Fatal("Error replacing rule: ", err)
+	}
+	if changed.session == nil || changed.session.id != "2" {
+		t.Errorf("Session of the rule not inherited: %+v", changed.session)
+	}
+
+	// the session 2 ends, the user 1000 is still logged in.
+	l.expireSessionRules(map[string]int{"3": 1000, "4": 1001})
+	testNumRules(t, l, 2)
+	for _, name := range []string{"001-user", "002-other-user"} {
+		if _, found := l.rules[name]; !found {
+			t.Error("Rule expired before its session ended:", name)
+		}
+	}
+
+	// the last session of the user 1000 ends.
+	l.expireSessionRules(map[string]int{"4": 1001})
+	testNumRules(t, l, 1)
+	if _, found := l.rules["002-other-user"]; !found {
+		t.Error("Rule of another user expired")
+	}
+}
+
+func TestLiveReloadRename(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader with live reload: files renamed into and out of the directory")
+
+	watchDir := tmpDir + "/watch"
+	os.Mkdir(watchDir, 0777)
+	l, err := NewLoader(true)
+	if err != nil {
+		t.Fail()
+	}
+	if err = l.Load(watchDir); err != nil {
+		t.Error("Error loading test rules: ", err)
+	}
+	//wait for watcher to activate
+	time.Sleep(time.Second)
+
+	raw, err := ioutil.ReadFile("testdata/000-allow-chrome.json")
+	if err != nil {
+		t.Fatal("Error reading test rule: ", err)
+	}
+	// the file is written to a temporary file, and moved into the directory.
+	// The name of the file doesn't match the name of the rule.
+	if err = ioutil.WriteFile(tmpDir+"/rule.tmp", raw, 0600); err != nil {
+		t.Error("Error writing rule: ", err)
+	}
+	if err = os.Rename(tmpDir+"/rule.tmp", watchDir+"/chrome.json"); err != nil {
+		t.Error("Error moving rule into the directory: ", err)
+	}
+	time.Sleep(time.Second)
+	testNumRules(t, l, 1)
+	if l.GetAll()["000-allow-chrome"] == nil {
+		t.Error("Rule not loaded")
+	}
+
+	t.Run("Rule name changed", func(t *testing.T) {
+		renamed := strings.Replace(string(raw), "000-allow-chrome", "000-allow-chromium", 1)
+		if err := ioutil.WriteFile(watchDir+"/chrome.json", []byte(renamed), 0600); err != nil {
+			t.Error("Error writing rule: ", err)
+		}
+		time.Sleep(time.Second)
+		testNumRules(t, l, 1)
+		if l.GetAll()["000-allow-chromium"] == nil {
+			t.Error("Renamed rule not loaded")
+		}
+	})
+	t.Run("File moved out of the directory", func(t *testing.T) {
+		if err := os.Rename(watchDir+"/chrome.json", tmpDir+"/chrome.json.bak"); err != nil {
+			t.Error("Error moving rule out of the directory: ", err)
+		}
+		time.Sleep(time.Second)
+		testNumRules(t, l, 0)
+	})
+}
+
+func BenchmarkFindFirstMatch(b *testing.B) {
+	l, _ := NewLoader(false)
+	var list []Operator
+	for i := 0; i < 1000; i++ {
+		var op *Operator
+		switch i % 3 {
+		case 0:
+			op, _ = NewOperator(Simple, false, OpProcessPath, fmt.Sprint("/usr/bin/app", i), list)
+		case 1:
+			op, _ = NewOperator(Regexp, false, OpDstHost, fmt.Sprint(`^(.*\.)?example`, i, `\.org$`), list)
+		case 2:
+			op, _ = NewOperator(List, false, OpList, fmt.Sprint(`[{"type": "simple", "operand": "dest.port", "data": "`, i, `"}, {"type": "network", "operand": "dest.network", "data": "10.0.0.0/8"}]`), list)
+		}
+		l.Add(Create(fmt.Sprintf("%04d-rule", i), "", true, false, true, Allow, Restart, op), false)
+	}
+	sim := &Simulation{ProcessPath: "/usr/bin/curl", DstHost: "www.example.com", DstIP: "1.1.1.1", DstPort: 443}
+	con, _ := sim.Connection()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.findFirstMatch(con)
+	}
+}
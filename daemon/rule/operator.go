@@ -3,14 +3,19 @@ package rule
 import (
 	"fmt"
 	"net"
-	"reflect"
 	"regexp"
+	"regexp/syntax"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/conman"
 	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/geoip"
 	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/netlink"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
 )
 
 // Type is the type of rule.
@@ -25,12 +30,13 @@ type Operand string
 
 // Available types
 const (
-	Simple  = Type("simple")
-	Regexp  = Type("regexp")
-	Complex = Type("complex") // for future use
-	List    = Type("list")
-	Network = Type("network")
-	Lists   = Type("lists")
+	Simple   = Type("simple")
+	Regexp   = Type("regexp")
+	Complex  = Type("complex") // for future use
+	List     = Type("list")
+	Network  = Type("network")
+	Lists    = Type("lists")
+	Schedule = Type("schedule")
 )
 
 // Available operands
@@ -39,6 +45,15 @@ const (
 	OpProcessID           = Operand("process.id")
 	OpProcessPath         = Operand("process.path")
 	OpProcessCmd          = Operand("process.command")
+	OpProcessExecCmd      = Operand("process.exec_command")
+	OpProcessParentPath   = Operand("process.parent.path")
+	OpProcessParentCmd    = Operand("process.parent.command")
+	OpProcessAncestorPath = Operand("process.ancestor.path")
+	OpProcessHash         = Operand("process.hash")
+	OpProcessAppID        = Operand("process.appid")
+	OpProcessCgroup       = Operand("process.cgroup")
+	OpProcessSystemdUnit  = Operand("process.systemd_unit")
+	OpProcessTampered     = Operand("process.tampered")
 	OpProcessEnvPrefix    = Operand("process.env.")
 	OpProcessEnvPrefixLen = 12
 	OpUserID              = Operand("user.id")
@@ -46,8 +61,14 @@ const (
 	OpSrcPort             = Operand("source.port")
 	OpDstIP               = Operand("dest.ip")
 	OpDstHost             = Operand("dest.host")
+	OpDstSNI              = Operand("dest.sni")
+	OpDstHTTPHost         = Operand("dest.http.host")
+	OpDstMAC              = Operand("dest.mac")
+	OpSrcMAC              = Operand("source.mac")
 	OpDstPort             = Operand("dest.port")
 	OpDstNetwork          = Operand("dest.network")
+	OpDstCountry          = Operand("dest.country")
+	OpDstASN              = Operand("dest.asn")
 	OpSrcNetwork          = Operand("source.network")
 	OpProto               = Operand("protocol")
 	OpIfaceIn             = Operand("iface.in")
@@ -57,8 +78,32 @@ const (
 	OpDomainsRegexpLists  = Operand("lists.domains_regexp")
 	OpIPLists             = Operand("lists.ips")
 	OpNetLists            = Operand("lists.nets")
+	OpSchedule            = Operand("time.schedule")
+	OpNetNS               = Operand("netns")
+
+	// logind sessions of the processes.
+	OpSessionPrefix = Operand("session.")
+	OpSessionID     = Operand("session.id")
+	OpSessionUser   = Operand("session.user")
+	OpSessionType   = Operand("session.type")
+	OpSessionClass  = Operand("session.class")
+	OpSessionSeat   = Operand("session.seat")
+
+	// containers the processes run in.
+	OpProcessContainerPrefix         = Operand("process.container.")
+	OpProcessContainerID             = Operand("process.container.id")
+	OpProcessContainerName           = Operand("process.container.name")
+	OpProcessContainerImage          = Operand("process.container.image")
+	OpProcessContainerPod            = Operand("process.container.pod")
+	OpProcessContainerRuntime        = Operand("process.container.runtime")
+	OpProcessContainerLabelPrefix    = Operand("process.container.label.")
+	OpProcessContainerLabelPrefixLen = 24
 )
 
+// NetNSHost is the value of the netns operand of the network namespace of the
+// daemon, usually the namespace of the host.
+const NetNSHost = "host"
+
 type opCallback func(value interface{}) bool
 
 // Operator represents what we want to filter of a connection, and how.
@@ -68,13 +113,20 @@ type Operator struct {
 	Sensitive Sensitive  `json:"sensitive"`
 	Data      string     `json:"data"`
 	List      []Operator `json:"list"`
+	// match the connections that don't meet the condition.
+	Negate bool `json:"negate"`
 
 	sync.RWMutex
 	cb                  opCallback
+	matcher             func(*conman.Connection) bool
 	re                  *regexp.Regexp
+	reLiteral           string
 	netMask             *net.IPNet
+	schedule            *schedule
 	isCompiled          bool
 	lists               map[string]interface{}
+	wildcards           *domainTrie
+	remote              *remoteLists
 	listsMonitorRunning bool
 	exitMonitorChan     chan (bool)
 }
@@ -98,6 +150,15 @@ func (o *Operator) Compile() error {
 	}
 	if o.Type == Simple {
 		o.cb = o.simpleCmp
+		if o.Operand == OpDstASN {
+			// allow to write the ASNs as AS13335 or 13335
+			o.Data = strings.TrimPrefix(strings.ToUpper(o.Data), "AS")
+		} else if o.Operand == OpNetNS {
+			o.cb = o.cmpNetNS
+		} else if o.Operand == OpDstMAC || o.Operand == OpSrcMAC {
+			// allow to write the MACs as aa-bb-cc-dd-ee-ff
+			o.Data = strings.Replace(o.Data, "-", ":", -1)
+		}
 	} else if o.Type == Regexp {
 		o.cb = o.reCmp
 		if o.Sensitive == false {
@@ -108,30 +169,46 @@ func (o *Operator) Compile() error {
 			return err
 		}
 		o.re = re
+		o.reLiteral = requiredLiteral(o.Data)
 	} else if o.Operand == OpDomainsLists {
 		if o.Data == "" {
 			return fmt.Errorf("Operand lists is empty, nothing to load: %s", o)
 		}
-		o.loadLists()
+		if err := o.loadLists(); err != nil {
+			return err
+		}
 		o.cb = o.domainsListCmp
 	} else if o.Operand == OpDomainsRegexpLists {
 		if o.Data == "" {
 			return fmt.Errorf("Operand regexp lists is empty, nothing to load: %s", o)
 		}
-		o.loadLists()
+		if err := o.loadLists(); err != nil {
+			return err
+		}
 		o.cb = o.reListCmp
 	} else if o.Operand == OpIPLists {
 		if o.Data == "" {
 			return fmt.Errorf("Operand ip lists is empty, nothing to load: %s", o)
 		}
-		o.loadLists()
+		if err := o.loadLists(); err != nil {
+			return err
+		}
 		o.cb = o.ipListCmp
 	} else if o.Operand == OpNetLists {
 		if o.Data == "" {
 			return fmt.Errorf("Operand net lists is empty, nothing to load: %s", o)
 		}
-		o.loadLists()
+		if err := o.loadLists(); err != nil {
+			return err
+		}
 		o.cb = o.ipNetCmp
+	} else if o.Operand == OpSchedule {
+		sched, err := parseSchedule(o.Data)
+		if err != nil {
+			return err
+		}
+		o.schedule = sched
+		o.cb = o.scheduleCmp
 	} else if o.Type == List {
 		o.Operand = OpList
 	} else if o.Type == Network {
@@ -142,6 +219,7 @@ func (o *Operator) Compile() error {
 		}
 		o.cb = o.cmpNetwork
 	}
+	o.matcher = o.compileMatcher()
 	log.Debug("Operator compiled: %s", o)
 	o.isCompiled = true
 
@@ -153,25 +231,82 @@ func (o *Operator) String() string {
 	if o.Type == Regexp {
 		how = "matches"
 	}
+	if o.Negate {
+		how += " not"
+	}
 	return fmt.Sprintf("%s %s '%s'", log.Bold(string(o.Operand)), how, log.Yellow(string(o.Data)))
 }
 
+// cmpNetNS compares the network namespace of a connection with the ID of a
+// namespace, "host" (the namespace of the daemon), or the name of a namespace
+// created with ip netns.
+func (o *Operator) cmpNetNS(v interface{}) bool {
+	ns := o.Data
+	if strings.EqualFold(ns, NetNSHost) {
+		ns = fmt.Sprint(procmon.OwnNetNS())
+	} else if _, err := strconv.ParseUint(ns, 10, 64); err != nil {
+		// the namespaces can be deleted and created again, so the name is
+		// resolved on every connection.
+		id, err := procmon.NetNSByName(ns)
+		if err != nil {
+			return false
+		}
+		ns = fmt.Sprint(id)
+	}
+	return v == ns
+}
+
 func (o *Operator) simpleCmp(v interface{}) bool {
+	return o.simpleMatch(v.(string))
+}
+
+func (o *Operator) simpleMatch(v string) bool {
 	if o.Sensitive == false {
-		return strings.EqualFold(v.(string), o.Data)
+		return strings.EqualFold(v, o.Data)
 	}
 	return v == o.Data
 }
 
 func (o *Operator) reCmp(v interface{}) bool {
-	if vt := reflect.ValueOf(v).Kind(); vt != reflect.String {
+	str, ok := v.(string)
+	if !ok {
 		log.Warning("Operator.reCmp() bad interface type: %T", v)
 		return false
 	}
+	return o.reMatch(str)
+}
+
+func (o *Operator) reMatch(str string) bool {
 	if o.Sensitive == false {
-		v = strings.ToLower(v.(string))
+		str = strings.ToLower(str)
+	}
+	// the values without the text that all the matches contain are discarded
+	// without running the regular expression.
+	if o.reLiteral != "" && !strings.Contains(str, o.reLiteral) {
+		return false
+	}
+	return o.re.MatchString(str)
+}
+
+// requiredLiteral returns the longest text that all the matches of a regular
+// expression contain, if any.
+func requiredLiteral(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
 	}
-	return o.re.MatchString(v.(string))
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	literal := ""
+	for _, sub := range subs {
+		if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 && len(sub.Rune) > len(literal) {
+			literal = string(sub.Rune)
+		}
+	}
+	return literal
 }
 
 func (o *Operator) cmpNetwork(destIP interface{}) bool {
@@ -183,6 +318,14 @@ func (o *Operator) cmpNetwork(destIP interface{}) bool {
 	return o.netMask.Contains(destIP.(net.IP))
 }
 
+func (o *Operator) scheduleCmp(now interface{}) bool {
+	if o.schedule == nil {
+		log.Warning("scheduleCmp() NULL: %s", o.Data)
+		return false
+	}
+	return o.schedule.isActive(now.(time.Time))
+}
+
 func (o *Operator) domainsListCmp(v interface{}) bool {
 	dstHost := v.(string)
 	if dstHost == "" {
@@ -198,6 +341,10 @@ func (o *Operator) domainsListCmp(v interface{}) bool {
 		log.Debug("%s: %s, %s", log.Red("domain list match"), dstHost, o.lists[dstHost])
 		return true
 	}
+	if list, found := o.wildcards.Match(dstHost); found {
+		log.Debug("%s: %s, %s", log.Red("domain list wildcard match"), dstHost, list)
+		return true
+	}
 	return false
 }
 
@@ -251,66 +398,317 @@ func (o *Operator) reListCmp(v interface{}) bool {
 	return false
 }
 
-func (o *Operator) listMatch(con interface{}) bool {
-	res := true
+// DestinationOnly tells if the operator only checks the destination of the
+// connections: its IP, or the domains it has been resolved from.
+func (o *Operator) DestinationOnly() bool {
+	switch o.Operand {
+	case OpDstIP, OpDstHost, OpDstNetwork, OpDstCountry, OpDstASN,
+		OpDomainsLists, OpDomainsRegexpLists, OpIPLists, OpNetLists:
+		return true
+	case OpList:
+		if o.Type != List || len(o.List) == 0 {
+			return false
+		}
+		for i := range o.List {
+			if !o.List[i].DestinationOnly() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (o *Operator) listMatch(con *conman.Connection) bool {
 	for i := 0; i < len(o.List); i++ {
-		res = res && o.List[i].Match(con.(*conman.Connection))
+		if !o.List[i].Match(con) {
+			return false
+		}
 	}
-	return res
+	return true
 }
 
 // Match tries to match parts of a connection with the given operator.
+// If the operator is negated, the result is inverted.
 func (o *Operator) Match(con *conman.Connection) bool {
+	if o.matcher == nil {
+		log.Warning("Operator.Match() not compiled: %s", o)
+		return false
+	}
+	return o.matcher(con) != o.Negate
+}
 
-	if o.Operand == OpTrue {
-		return true
-	} else if o.Operand == OpList {
-		return o.listMatch(con)
-	} else if o.Operand == OpProcessPath {
-		return o.cb(con.Process.Path)
-	} else if o.Operand == OpProcessCmd {
-		return o.cb(strings.Join(con.Process.Args, " "))
-	} else if o.Operand == OpDstHost && con.DstHost != "" {
-		return o.cb(con.DstHost)
-	} else if o.Operand == OpDstIP {
-		return o.cb(con.DstIP.String())
-	} else if o.Operand == OpDstPort {
-		return o.cb(fmt.Sprintf("%d", con.DstPort))
-	} else if o.Operand == OpDomainsLists {
-		return o.cb(con.DstHost)
-	} else if o.Operand == OpIPLists {
-		return o.cb(con.DstIP.String())
-	} else if o.Operand == OpUserID {
-		return o.cb(fmt.Sprintf("%d", con.Entry.UserId))
-	} else if o.Operand == OpDstNetwork {
-		return o.cb(con.DstIP)
-	} else if o.Operand == OpSrcNetwork {
-		return o.cb(con.SrcIP)
-	} else if o.Operand == OpNetLists {
-		return o.cb(con.DstIP)
-	} else if o.Operand == OpDomainsRegexpLists {
-		return o.cb(con.DstHost)
-	} else if o.Operand == OpIfaceIn {
-		if ifname, err := net.InterfaceByIndex(con.Pkt.IfaceInIdx); err == nil {
-			return o.cb(ifname.Name)
-		}
-	} else if o.Operand == OpIfaceOut {
-		if ifname, err := net.InterfaceByIndex(con.Pkt.IfaceOutIdx); err == nil {
-			return o.cb(ifname.Name)
-		}
-	} else if o.Operand == OpProto {
-		return o.cb(con.Protocol)
-	} else if o.Operand == OpSrcIP {
-		return o.cb(con.SrcIP.String())
-	} else if o.Operand == OpSrcPort {
-		return o.cb(fmt.Sprintf("%d", con.SrcPort))
-	} else if o.Operand == OpProcessID {
-		return o.cb(fmt.Sprint(con.Process.ID))
-	} else if strings.HasPrefix(string(o.Operand), string(OpProcessEnvPrefix)) {
+// compileMatcher returns the function that checks the connections against
+// the operator. The value of the connection to compare is chosen here, once,
+// instead of on every connection.
+func (o *Operator) compileMatcher() func(*conman.Connection) bool {
+	switch o.Operand {
+	case OpTrue:
+		return func(*conman.Connection) bool { return true }
+	case OpList:
+		return o.listMatch
+	}
+	if o.cb == nil {
+		log.Warning("Operator.Compile() unknown type: %s", o.Type)
+		return func(*conman.Connection) bool { return false }
+	}
+	if o.Operand == OpProcessAncestorPath {
+		// any of the parents of the process, not only the closest one.
+		return func(con *conman.Connection) bool {
+			for _, parent := range con.Process.Parents() {
+				if o.cb(parent.Path) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if o.Operand == OpDstHost || o.Operand == OpDomainsLists || o.Operand == OpDomainsRegexpLists {
+		// any of the names of the CNAME chain, not only the domain requested.
+		cmp := o.stringCmp()
+		return func(con *conman.Connection) bool {
+			for _, host := range con.DstHosts() {
+				if cmp(host) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if value := o.stringFunc(); value != nil {
+		cmp := o.stringCmp()
+		return func(con *conman.Connection) bool {
+			v, ok := value(con)
+			return ok && cmp(v)
+		}
+	}
+	if value := o.valueFunc(); value != nil {
+		return func(con *conman.Connection) bool {
+			v, ok := value(con)
+			return ok && o.cb(v)
+		}
+	}
+	log.Warning("Operator.Compile() unknown operand: %s", o.Operand)
+	return func(*conman.Connection) bool { return false }
+}
+
+// stringCmp returns the function that compares the text values with the
+// operator. The simple and regexp operators are called directly, without
+// converting the values to interfaces.
+func (o *Operator) stringCmp() func(string) bool {
+	if o.Type == Simple && o.Operand != OpNetNS {
+		return o.simpleMatch
+	}
+	if o.Type == Regexp {
+		return o.reMatch
+	}
+	return func(v string) bool { return o.cb(v) }
+}
+
+// valueFunc returns the function that reads the value of the operands that
+// are not texts from a connection.
+func (o *Operator) valueFunc() func(*conman.Connection) (interface{}, bool) {
+	switch o.Operand {
+	case OpDstNetwork, OpNetLists:
+		return func(con *conman.Connection) (interface{}, bool) { return con.DstIP, true }
+	case OpSrcNetwork:
+		return func(con *conman.Connection) (interface{}, bool) { return con.SrcIP, true }
+	case OpSchedule:
+		return func(con *conman.Connection) (interface{}, bool) { return time.Now(), true }
+	}
+	return nil
+}
+
+// stringFunc returns the function that reads the value of the operand from a
+// connection, which returns false if the connection doesn't have it.
+func (o *Operator) stringFunc() func(*conman.Connection) (string, bool) {
+	switch o.Operand {
+	case OpProcessPath:
+		return func(con *conman.Connection) (string, bool) { return con.Process.Path, true }
+	case OpProcessCmd:
+		return func(con *conman.Connection) (string, bool) { return strings.Join(con.Process.Args, " "), true }
+	case OpProcessExecCmd:
+		// the command line when the process was executed, or the current one
+		// if the executions are not traced.
+		return func(con *conman.Connection) (string, bool) {
+			if con.Process.ExecArgs == nil {
+				return strings.Join(con.Process.Args, " "), true
+			}
+			return strings.Join(con.Process.ExecArgs, " "), true
+		}
+	case OpProcessHash:
+		return func(con *conman.Connection) (string, bool) { return con.Process.Checksum(), true }
+	case OpProcessAppID:
+		// the processes that are not of a flatpak, snap or AppImage application.
+		return func(con *conman.Connection) (string, bool) { return con.Process.AppID, con.Process.AppID != "" }
+	case OpProcessCgroup:
+		return func(con *conman.Connection) (string, bool) { return con.Process.Cgroup, con.Process.Cgroup != "" }
+	case OpProcessSystemdUnit:
+		return func(con *conman.Connection) (string, bool) {
+			return con.Process.SystemdUnit, con.Process.SystemdUnit != ""
+		}
+	case OpProcessTampered:
+		// "true" if the binary has been deleted or replaced.
+		return func(con *conman.Connection) (string, bool) { return strconv.FormatBool(con.Tampered), true }
+	case OpProcessID:
+		return func(con *conman.Connection) (string, bool) { return strconv.Itoa(con.Process.ID), true }
+	case OpProcessParentPath:
+		return func(con *conman.Connection) (string, bool) {
+			if con.Process.Parent == nil {
+				return "", false
+			}
+			return con.Process.Parent.Path, true
+		}
+	case OpProcessParentCmd:
+		return func(con *conman.Connection) (string, bool) {
+			if con.Process.Parent == nil {
+				return "", false
+			}
+			return strings.Join(con.Process.Parent.Args, " "), true
+		}
+	case OpDstHost:
+		return func(con *conman.Connection) (string, bool) { return con.DstHost, con.DstHost != "" }
+	case OpDstSNI:
+		return func(con *conman.Connection) (string, bool) { return con.SNI, con.SNI != "" }
+	case OpDstHTTPHost:
+		return func(con *conman.Connection) (string, bool) { return con.HTTPHost, con.HTTPHost != "" }
+	case OpDstMAC:
+		// the MAC of the gateway, or of the destination if it's on the local network.
+		return func(con *conman.Connection) (string, bool) {
+			mac := netlink.GetNextHopMAC(con.DstIP)
+			return mac, mac != ""
+		}
+	case OpSrcMAC:
+		return func(con *conman.Connection) (string, bool) {
+			mac := netlink.GetMAC(con.SrcIP)
+			return mac, mac != ""
+		}
+	case OpDomainsLists, OpDomainsRegexpLists:
+		return func(con *conman.Connection) (string, bool) { return con.DstHost, true }
+	case OpDstIP, OpIPLists:
+		return func(con *conman.Connection) (string, bool) { return con.DstIP.String(), true }
+	case OpDstPort:
+		return func(con *conman.Connection) (string, bool) {
+			return strconv.FormatUint(uint64(con.DstPort), 10), true
+		}
+	case OpDstCountry:
+		return func(con *conman.Connection) (string, bool) { return geoip.Country(con.DstIP), true }
+	case OpDstASN:
+		return func(con *conman.Connection) (string, bool) {
+			asn := geoip.ASN(con.DstIP)
+			if asn == 0 {
+				return "", true
+			}
+			return strconv.FormatUint(uint64(asn), 10), true
+		}
+	case OpSrcIP:
+		return func(con *conman.Connection) (string, bool) { return con.SrcIP.String(), true }
+	case OpSrcPort:
+		return func(con *conman.Connection) (string, bool) {
+			return strconv.FormatUint(uint64(con.SrcPort), 10), true
+		}
+	case OpUserID:
+		return func(con *conman.Connection) (string, bool) { return strconv.Itoa(con.Entry.UserId), true }
+	case OpProto:
+		return func(con *conman.Connection) (string, bool) { return con.Protocol, true }
+	case OpIfaceIn:
+		return func(con *conman.Connection) (string, bool) { return ifaceName(con.Pkt.IfaceInIdx) }
+	case OpIfaceOut:
+		return func(con *conman.Connection) (string, bool) { return ifaceName(con.Pkt.IfaceOutIdx) }
+	case OpNetNS:
+		return func(con *conman.Connection) (string, bool) { return strconv.FormatUint(con.NetNS, 10), true }
+	}
+
+	if strings.HasPrefix(string(o.Operand), string(OpProcessContainerPrefix)) {
+		field := o.containerField()
+		if field == nil {
+			return nil
+		}
+		return func(con *conman.Connection) (string, bool) {
+			// processes that don't run in a container.
+			if con.Process.Container == nil {
+				return "", false
+			}
+			return field(con.Process.Container), true
+		}
+	}
+	if strings.HasPrefix(string(o.Operand), string(OpSessionPrefix)) {
+		field := o.sessionField()
+		if field == nil {
+			return nil
+		}
+		return func(con *conman.Connection) (string, bool) {
+			// processes that don't belong to a user session.
+			if con.Process.Session == nil {
+				return "", false
+			}
+			return field(con.Process.Session), true
+		}
+	}
+	if strings.HasPrefix(string(o.Operand), string(OpProcessEnvPrefix)) {
 		envVarName := core.Trim(string(o.Operand[OpProcessEnvPrefixLen:]))
-		envVarValue, _ := con.Process.Env[envVarName]
-		return o.cb(envVarValue)
+		return func(con *conman.Connection) (string, bool) { return con.Process.Env[envVarName], true }
 	}
+	return nil
+}
 
-	return false
+func ifaceName(idx int) (string, bool) {
+	iface, err := net.InterfaceByIndex(idx)
+	if err != nil {
+		return "", false
+	}
+	return iface.Name, true
+}
+
+// containerField returns the function that reads the field of the container
+// of the operand.
+func (o *Operator) containerField() func(*procmon.Container) string {
+	switch o.Operand {
+	case OpProcessContainerID:
+		return func(c *procmon.Container) string { return c.ID }
+	case OpProcessContainerName:
+		return func(c *procmon.Container) string { return c.Name }
+	case OpProcessContainerImage:
+		return func(c *procmon.Container) string { return c.Image }
+	case OpProcessContainerPod:
+		return func(c *procmon.Container) string { return c.Pod }
+	case OpProcessContainerRuntime:
+		return func(c *procmon.Container) string { return c.Runtime }
+	}
+	if strings.HasPrefix(string(o.Operand), string(OpProcessContainerLabelPrefix)) {
+		label := core.Trim(string(o.Operand[OpProcessContainerLabelPrefixLen:]))
+		return func(c *procmon.Container) string { return c.Labels[label] }
+	}
+	return nil
+}
+
+// sessionField returns the function that reads the field of the session of
+// the operand.
+func (o *Operator) sessionField() func(*procmon.Session) string {
+	switch o.Operand {
+	case OpSessionID:
+		return func(s *procmon.Session) string { return s.ID }
+	case OpSessionUser:
+		return func(s *procmon.Session) string { return s.User }
+	case OpSessionType:
+		return func(s *procmon.Session) string { return s.Type }
+	case OpSessionClass:
+		return func(s *procmon.Session) string { return s.Class }
+	case OpSessionSeat:
+		return func(s *procmon.Session) string { return s.Seat }
+	}
+	return nil
+}
+
+// envVars returns the environment variables used by the operator, and the
+// operators of its list.
+func (o *Operator) envVars() []string {
+	names := []string{}
+	if strings.HasPrefix(string(o.Operand), string(OpProcessEnvPrefix)) {
+		names = append(names, core.Trim(string(o.Operand[OpProcessEnvPrefixLen:])))
+	}
+	for i := range o.List {
+		names = append(names, o.List[i].envVars()...)
+	}
+	return names
 }
@@ -92,6 +92,7 @@ func (o *Operator) ClearLists() {
 	for k := range o.lists {
 		delete(o.lists, k)
 	}
+	o.wildcards = nil
 	debug.FreeOSMemory()
 }
 
@@ -125,6 +126,16 @@ func (o *Operator) readDomainsList(raw, fileName string) (dups uint64) {
 		}
 
 		host = core.Trim(host)
+		// *.example.org matches the subdomains of example.org
+		if strings.HasPrefix(host, "*.") {
+			if o.wildcards == nil {
+				o.wildcards = newDomainTrie()
+			}
+			if !o.wildcards.Add(host[2:], fileName) {
+				dups++
+			}
+			continue
+		}
 		if _, found := o.lists[host]; found {
 			dups++
 			continue
@@ -132,7 +143,7 @@ func (o *Operator) readDomainsList(raw, fileName string) (dups uint64) {
 		o.lists[host] = fileName
 	}
 	lines = nil
-	log.Info("%d domains loaded, %s", len(o.lists), fileName)
+	log.Info("%d domains loaded, %d wildcards, %s", len(o.lists), o.wildcards.Len(), fileName)
 
 	return dups
 }
@@ -234,30 +245,48 @@ func (o *Operator) readLists() error {
 			log.Warning("Error reading list of IPs (%s): %s", fileName, err)
 			continue
 		}
-
-		if o.Operand == OpDomainsLists {
-			dups += o.readDomainsList(string(raw), fileName)
-		} else if o.Operand == OpDomainsRegexpLists {
-			dups += o.readRegexpList(string(raw), fileName)
-		} else if o.Operand == OpNetLists {
-			dups += o.readNetList(string(raw), fileName)
-		} else if o.Operand == OpIPLists {
-			dups += o.readIPList(string(raw), fileName)
-		} else {
-			log.Warning("Unknown lists operand type: %s", o.Operand)
-		}
+		dups += o.readList(string(raw), fileName)
 	}
 	log.Info("%d lists loaded, %d domains, %d duplicated", len(fileList), len(o.lists), dups)
 	return nil
 }
 
-func (o *Operator) loadLists() {
+// readList adds the entries of a list to the loaded ones, according to the operand.
+func (o *Operator) readList(raw, fileName string) (dups uint64) {
+	if o.Operand == OpDomainsLists {
+		dups = o.readDomainsList(raw, fileName)
+	} else if o.Operand == OpDomainsRegexpLists {
+		dups = o.readRegexpList(raw, fileName)
+	} else if o.Operand == OpNetLists {
+		dups = o.readNetList(raw, fileName)
+	} else if o.Operand == OpIPLists {
+		dups = o.readIPList(raw, fileName)
+	} else {
+		log.Warning("Unknown lists operand type: %s", o.Operand)
+	}
+	return dups
+}
+
+func (o *Operator) loadLists() error {
 	log.Info("loading domains lists: %s, %s, %s", o.Type, o.Operand, o.Data)
 
+	if isRemoteList(o.Data) {
+		remote, err := parseRemoteLists(o.Data)
+		if err != nil {
+			return err
+		}
+		o.remote = remote
+	}
+
 	// when loading from disk, we don't use the Operator's constructor, so we need to create this channel
 	if o.exitMonitorChan == nil {
 		o.exitMonitorChan = make(chan bool)
 		o.listsMonitorRunning = true
-		go o.monitorLists()
+		if o.remote != nil {
+			go o.monitorRemoteLists(o.exitMonitorChan)
+		} else {
+			go o.monitorLists()
+		}
 	}
+	return nil
 }
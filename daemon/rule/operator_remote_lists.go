@@ -0,0 +1,224 @@
+package rule
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// The operators of type lists can also load the lists from HTTP(S) URLs,
+// instead of from a local directory:
+//
+// "operator": {
+//   "type": "lists",
+//   "operand": "lists.domains",
+//   "data": "https://example.org/hosts.txt https://example.org/domains.txt refresh=12h"
+// }
+//
+// The URLs are separated by spaces or commas, and "refresh" is the interval to
+// download the lists again (24h by default). The lists of domains can be in
+// hosts format (0.0.0.0 example.org), or one domain per line.
+// The lists downloaded are cached on disk, and loaded from there until the
+// next refresh, so they're available when the daemon starts without network.
+// When the lists are refreshed, the new entries replace the old ones at once,
+// so there's no interval of time where the rule doesn't match.
+
+var (
+	remoteListsCacheDir = "/var/cache/opensnitchd/lists"
+
+	remoteListsRefresh    = 24 * time.Hour
+	remoteListsMinRefresh = time.Minute
+	// interval to check if the lists have expired, and to retry the downloads that have failed.
+	remoteListsRetry = 5 * time.Minute
+	// maximum size of a list.
+	remoteListsMaxSize int64 = 256 << 20
+
+	remoteListsClient = &http.Client{Timeout: 2 * time.Minute}
+)
+
+type remoteLists struct {
+	urls    []string
+	refresh time.Duration
+}
+
+// isRemoteList checks if the data of a lists operator are URLs, instead of a directory.
+func isRemoteList(data string) bool {
+	data = strings.ToLower(strings.TrimSpace(data))
+	return strings.HasPrefix(data, "http://") || strings.HasPrefix(data, "https://")
+}
+
+// parseRemoteLists parses the URLs and the options of a remote lists operator.
+func parseRemoteLists(data string) (*remoteLists, error) {
+	remote := &remoteLists{refresh: remoteListsRefresh}
+	fields := strings.FieldsFunc(data, func(c rune) bool {
+		return c == ' ' || c == ',' || c == '\t' || c == '\n'
+	})
+	for _, field := range fields {
+		if strings.HasPrefix(field, "refresh=") {
+			refresh, err := time.ParseDuration(field[len("refresh="):])
+			if err != nil || refresh < remoteListsMinRefresh {
+				return nil, fmt.Errorf("Invalid lists refresh interval (min. %s): %s", remoteListsMinRefresh, field)
+			}
+			remote.refresh = refresh
+			continue
+		}
+		u, err := url.Parse(field)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid list URL: %s", field)
+		}
+		remote.urls = append(remote.urls, field)
+	}
+	if len(remote.urls) == 0 {
+		return nil, fmt.Errorf("No URLs to load lists from: %s", data)
+	}
+
+	return remote, nil
+}
+
+func remoteListCacheFile(listURL string) string {
+	return filepath.Join(remoteListsCacheDir, fmt.Sprintf("%x.txt", sha256.Sum256([]byte(listURL))))
+}
+
+// fetchRemoteList downloads a list to the cache, if the cached one is older
+// than maxAge. It returns true if the list has changed.
+func fetchRemoteList(ctx context.Context, listURL string, maxAge time.Duration) (bool, error) {
+	cacheFile := remoteListCacheFile(listURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if stat, err := os.Stat(cacheFile); err == nil {
+		if time.Since(stat.ModTime()) < maxAge {
+			return false, nil
+		}
+		req.Header.Set("If-Modified-Since", stat.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := remoteListsClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		now := time.Now()
+		os.Chtimes(cacheFile, now, now)
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Error downloading list %s: %s", listURL, resp.Status)
+	}
+
+	if err := os.MkdirAll(remoteListsCacheDir, 0700); err != nil {
+		return false, err
+	}
+	tmpFile := cacheFile + ".tmp"
+	out, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return false, err
+	}
+	// read one byte more than the limit, to know if the list exceeds it.
+	n, err := io.Copy(out, io.LimitReader(resp.Body, remoteListsMaxSize+1))
+	if err == nil && n > remoteListsMaxSize {
+		err = fmt.Errorf("the list exceeds the max size (%d bytes)", remoteListsMaxSize)
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(tmpFile)
+		return false, fmt.Errorf("Error downloading list %s: %s", listURL, err)
+	}
+
+	return true, os.Rename(tmpFile, cacheFile)
+}
+
+// hostsFormat converts the lines with only a domain to the hosts format.
+func hostsFormat(raw string) string {
+	lines := strings.Split(raw, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 1 && fields[0][0] != '#' {
+			lines[i] = "0.0.0.0 " + fields[0]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readRemoteLists loads the cached lists, and replaces the loaded entries with them.
+func (o *Operator) readRemoteLists() {
+	var dups uint64
+	newLists := &Operator{Operand: o.Operand, lists: make(map[string]interface{})}
+	for _, listURL := range o.remote.urls {
+		raw, err := ioutil.ReadFile(remoteListCacheFile(listURL))
+		if err != nil {
+			// not downloaded yet
+			continue
+		}
+		data := string(raw)
+		if o.Operand == OpDomainsLists {
+			data = hostsFormat(data)
+		}
+		dups += newLists.readList(data, listURL)
+	}
+
+	o.Lock()
+	o.lists = newLists.lists
+	o.wildcards = newLists.wildcards
+	o.Unlock()
+	log.Info("%d remote lists loaded, %d entries, %d duplicated", len(o.remote.urls), len(newLists.lists), dups)
+}
+
+func (o *Operator) monitorRemoteLists(exitChan chan bool) {
+	log.Info("monitor remote lists started: %s", o.Data)
+
+	// the downloads are cancelled as soon as the monitor is stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-exitChan
+		cancel()
+	}()
+
+	// the lists are downloaded only when the cached ones are older than the
+	// refresh interval, so we can check them more often.
+	wait := o.remote.refresh
+	if wait > remoteListsRetry {
+		wait = remoteListsRetry
+	}
+
+	o.readRemoteLists()
+	for {
+		changed := false
+		for _, listURL := range o.remote.urls {
+			updated, err := fetchRemoteList(ctx, listURL, o.remote.refresh)
+			if err != nil {
+				if ctx.Err() != nil {
+					goto Exit
+				}
+				log.Warning("Error updating remote list %s: %s", listURL, err)
+			}
+			changed = changed || updated
+		}
+		if changed {
+			o.readRemoteLists()
+		}
+
+		select {
+		case <-ctx.Done():
+			goto Exit
+		case <-time.After(wait):
+		}
+	}
+
+Exit:
+	o.ClearLists()
+	log.Info("remote lists monitor stopped")
+}
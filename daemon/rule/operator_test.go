@@ -1,9 +1,13 @@
 package rule
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -258,6 +262,52 @@ func TestNewOperatorNetwork(t *testing.T) {
 	restoreConnection()
 }
 
+func TestNewOperatorSchedule(t *testing.T) {
+	t.Log("Test NewOperator() schedule")
+	var dummyList []Operator
+
+	opSched, err := NewOperator(Schedule, false, OpSchedule, "mon-fri 09:00-17:00; sat,sun 22:00-02:00", dummyList)
+	if err != nil {
+		t.Error("NewOperator schedule.err should be nil: ", err)
+		t.Fail()
+	}
+	if err = opSched.Compile(); err != nil {
+		t.Error("NewOperator schedule, Compile() error: ", err)
+		t.Fail()
+	}
+
+	// 2023-01-02 is monday
+	tests := []struct {
+		date   string
+		active bool
+	}{
+		{"2023-01-02 09:00", true},
+		{"2023-01-02 16:59", true},
+		{"2023-01-02 17:00", false},
+		{"2023-01-06 08:59", false},
+		{"2023-01-07 12:00", false},
+		{"2023-01-07 23:00", true},
+		{"2023-01-08 01:59", true},
+		{"2023-01-09 01:00", true},
+		{"2023-01-09 02:00", false},
+	}
+	for _, test := range tests {
+		now, _ := time.ParseInLocation("2006-01-02 15:04", test.date, time.Local)
+		if opSched.cb(now) != test.active {
+			t.Error("Test NewOperator() schedule, invalid result: ", test.date, test.active)
+		}
+	}
+
+	for _, data := range []string{"", "mon-xyz", "25:00-26:00", "09:00-09:00", "9-17"} {
+		opSched, _ = NewOperator(Schedule, false, OpSchedule, data, dummyList)
+		if err = opSched.Compile(); err == nil {
+			t.Error("NewOperator() invalid schedule. It should fail: ", data)
+		}
+	}
+
+	restoreConnection()
+}
+
 func TestNewOperatorRegexp(t *testing.T) {
 	t.Log("Test NewOperator() regexp")
 	var dummyList []Operator
@@ -295,6 +345,23 @@ func TestNewOperatorInvalidRegexp(t *testing.T) {
 	restoreConnection()
 }
 
+func TestRegexpRequiredLiteral(t *testing.T) {
+	t.Log("Test regexp required literal")
+
+	tests := map[string]string{
+		`^(.*\.)?example\.org$`:  "example.org",
+		`opensnitch`:             "opensnitch",
+		`^/usr/bin/(curl|wget)$`: "/usr/bin/",
+		`(?i)opensnitch`:         "",
+		`^(curl|wget)$`:          "",
+	}
+	for expr, want := range tests {
+		if got := requiredLiteral(expr); got != want {
+			t.Errorf("Invalid required literal of %s: %s, expected %s", expr, got, want)
+		}
+	}
+}
+
 func TestNewOperatorRegexpSensitive(t *testing.T) {
 	t.Log("Test NewOperator() regexp sensitive")
 	var dummyList []Operator
@@ -424,6 +491,369 @@ func TestNewOperatorList(t *testing.T) {
 	restoreConnection()
 }
 
+func TestNewOperatorNegate(t *testing.T) {
+	t.Log("Test NewOperator() negate")
+	var list []Operator
+
+	opSimple, _ := NewOperator(Simple, false, OpUserID, "1000", list)
+	opSimple.Negate = true
+	if err := opSimple.Compile(); err != nil {
+		t.Error("NewOperator negate, Compile() error:", err)
+	}
+	if opSimple.Match(conn) == false {
+		t.Error("Test NewOperator() negate, user.id != 1000 doesn't match")
+	}
+	opSimple.Data = fmt.Sprint(defaultUserID)
+	if opSimple.Match(conn) == true {
+		t.Error("Test NewOperator() negate, user.id != 666 matches")
+	}
+
+	listData := `[{"type": "simple", "operand": "user.id", "data": "666", "sensitive": false}, {"type": "network", "operand": "dest.network", "data": "192.168.0.0/16", "negate": true}]`
+	opList, _ := NewOperator(List, false, OpList, listData, list)
+	opList.List = *unmarshalListData(opList.Data, t)
+	compileListOperators(&opList.List, t)
+	if err := opList.Compile(); err != nil {
+		t.Error("NewOperator negate list, Compile() error:", err)
+	}
+	if opList.Match(conn) == false {
+		t.Error("Test NewOperator() negate list, dest.network not in 192.168.0.0/16 doesn't match")
+	}
+	opList.Negate = true
+	if opList.Match(conn) == true {
+		t.Error("Test NewOperator() negate list, negated list matches")
+	}
+}
+
+func TestNewOperatorEnv(t *testing.T) {
+	t.Log("Test NewOperator() process.env")
+	var list []Operator
+	conn.Process.Env = map[string]string{"FLATPAK_ID": "org.mozilla.firefox"}
+	defer func() { conn.Process.Env = nil }()
+
+	opEnv, _ := NewOperator(Simple, false, Operand("process.env.FLATPAK_ID"), "org.mozilla.firefox", list)
+	if err := opEnv.Compile(); err != nil {
+		t.Error("NewOperator env, Compile() error:", err)
+	}
+	if opEnv.Match(conn) == false {
+		t.Error("Test NewOperator() process.env.FLATPAK_ID doesn't match")
+	}
+	opEnv.Data = "org.chromium.Chromium"
+	if opEnv.Match(conn) == true {
+		t.Error("Test NewOperator() process.env.FLATPAK_ID matches another value")
+	}
+
+	listData := `[{"type": "simple", "operand": "process.env.FLATPAK_ID", "data": "org.mozilla.firefox"}, {"type": "simple", "operand": "process.env.CC", "data": "gcc"}]`
+	opList, _ := NewOperator(List, false, OpList, listData, list)
+	opList.List = *unmarshalListData(opList.Data, t)
+	if names := opList.envVars(); len(names) != 2 || names[0] != "FLATPAK_ID" || names[1] != "CC" {
+		t.Error("Test NewOperator() invalid env vars of the list:", names)
+	}
+}
+
+func TestNewOperatorContainer(t *testing.T) {
+	t.Log("Test NewOperator() process.container")
+	var list []Operator
+
+	opName, _ := NewOperator(Simple, false, OpProcessContainerName, "web", list)
+	opName.Compile()
+	if opName.Match(conn) == true {
+		t.Error("Test NewOperator() process.container.name matches a process without container")
+	}
+
+	conn.Process.Container = &procmon.Container{
+		ID:      "4f1e5a4c5c42",
+		Runtime: procmon.RuntimeDocker,
+		Name:    "web",
+		Image:   "nginx:latest",
+		Labels:  map[string]string{"com.docker.compose.project": "blog"},
+	}
+	defer func() { conn.Process.Container = nil }()
+
+	if opName.Match(conn) == false {
+		t.Error("Test NewOperator() process.container.name doesn't match")
+	}
+	opImage, _ := NewOperator(Regexp, false, OpProcessContainerImage, "^nginx:", list)
+	opImage.Compile()
+	if opImage.Match(conn) == false {
+		t.Error("Test NewOperator() process.container.image doesn't match")
+	}
+	opLabel, _ := NewOperator(Simple, false, Operand("process.container.label.com.docker.compose.project"), "blog", list)
+	opLabel.Compile()
+	if opLabel.Match(conn) == false {
+		t.Error("Test NewOperator() process.container.label doesn't match")
+	}
+	opLabel.Data = "shop"
+	if opLabel.Match(conn) == true {
+		t.Error("Test NewOperator() process.container.label matches another value")
+	}
+}
+
+func TestNewOperatorNetNS(t *testing.T) {
+	t.Log("Test NewOperator() netns")
+	var list []Operator
+	conn.NetNS = procmon.OwnNetNS()
+	defer func() { conn.NetNS = 0 }()
+
+	for data, match := range map[string]bool{
+		NetNSHost:                    true,
+		fmt.Sprint(conn.NetNS):       true,
+		"1":                          false,
+		"opensnitch-test-not-exists": false,
+	} {
+		opNetNS, _ := NewOperator(Simple, false, OpNetNS, data, list)
+		opNetNS.Compile()
+		if opNetNS.Match(conn) != match {
+			t.Error("Test NewOperator() netns invalid match:", data, conn.NetNS, match)
+		}
+	}
+}
+
+func TestNewOperatorSNI(t *testing.T) {
+	t.Log("Test NewOperator() dest.sni")
+	var list []Operator
+
+	opSNI, _ := NewOperator(Regexp, false, OpDstSNI, `^(.*\.)?example\.org$`, list)
+	opSNI.Compile()
+	if opSNI.Match(conn) == true {
+		t.Error("Test NewOperator() dest.sni matches without SNI")
+	}
+	conn.SNI = "www.example.org"
+	defer func() { conn.SNI = "" }()
+	if opSNI.Match(conn) == false {
+		t.Error("Test NewOperator() dest.sni doesn't match")
+	}
+	conn.SNI = "example.com"
+	if opSNI.Match(conn) == true {
+		t.Error("Test NewOperator() dest.sni matches another server")
+	}
+}
+
+func TestNewOperatorHTTPHost(t *testing.T) {
+	t.Log("Test NewOperator() dest.http.host")
+	var list []Operator
+
+	opHTTP, _ := NewOperator(Simple, false, OpDstHTTPHost, "example.org", list)
+	opHTTP.Compile()
+	if opHTTP.Match(conn) == true {
+		t.Error("Test NewOperator() dest.http.host matches without HTTP host")
+	}
+	conn.HTTPHost = "example.org"
+	defer func() { conn.HTTPHost = "" }()
+	if opHTTP.Match(conn) == false {
+		t.Error("Test NewOperator() dest.http.host doesn't match")
+	}
+}
+
+func TestNewOperatorMAC(t *testing.T) {
+	t.Log("Test NewOperator() source.mac")
+	var list []Operator
+
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		if len(iface.HardwareAddr) == 0 || len(addrs) == 0 {
+			continue
+		}
+		ip := addrs[0].(*net.IPNet).IP
+		srcIP := conn.SrcIP
+		conn.SrcIP = ip
+		defer func() { conn.SrcIP = srcIP }()
+
+		mac := strings.Replace(strings.ToUpper(iface.HardwareAddr.String()), ":", "-", -1)
+		opMAC, _ := NewOperator(Simple, false, OpSrcMAC, mac, list)
+		opMAC.Compile()
+		if opMAC.Match(conn) == false {
+			t.Error("Test NewOperator() source.mac doesn't match:", ip, mac)
+		}
+		opMAC, _ = NewOperator(Simple, false, OpSrcMAC, "00:11:22:33:44:55", list)
+		opMAC.Compile()
+		if opMAC.Match(conn) == true {
+			t.Error("Test NewOperator() source.mac matches another MAC:", ip)
+		}
+		return
+	}
+	t.Log("No interfaces with MAC addresses to test")
+}
+
+func TestNewOperatorAppID(t *testing.T) {
+	t.Log("Test NewOperator() process.appid")
+	var list []Operator
+
+	opApp, _ := NewOperator(Simple, false, OpProcessAppID, "org.mozilla.firefox", list)
+	opApp.Compile()
+	if opApp.Match(conn) == true {
+		t.Error("Test NewOperator() process.appid matches a process without application")
+	}
+	conn.Process.AppID = "org.mozilla.firefox"
+	defer func() { conn.Process.AppID = "" }()
+	if opApp.Match(conn) == false {
+		t.Error("Test NewOperator() process.appid doesn't match")
+	}
+}
+
+func TestNewOperatorSystemdUnit(t *testing.T) {
+	t.Log("Test NewOperator() process.cgroup, process.systemd_unit")
+	var list []Operator
+
+	opUnit, _ := NewOperator(Simple, false, OpProcessSystemdUnit, "ssh.service", list)
+	opUnit.Compile()
+	opCgroup, _ := NewOperator(Regexp, false, OpProcessCgroup, "^/system.slice/", list)
+	opCgroup.Compile()
+	if opUnit.Match(conn) == true || opCgroup.Match(conn) == true {
+		t.Error("Test NewOperator() process.systemd_unit matches a process without cgroup")
+	}
+	conn.Process.Cgroup = "/system.slice/ssh.service"
+	conn.Process.SystemdUnit = "ssh.service"
+	defer func() { conn.Process.Cgroup, conn.Process.SystemdUnit = "", "" }()
+	if opUnit.Match(conn) == false {
+		t.Error("Test NewOperator() process.systemd_unit doesn't match")
+	}
+	if opCgroup.Match(conn) == false {
+		t.Error("Test NewOperator() process.cgroup doesn't match")
+	}
+}
+
+func TestNewOperatorTampered(t *testing.T) {
+	t.Log("Test NewOperator() process.tampered")
+	var list []Operator
+
+	opTampered, _ := NewOperator(Simple, false, OpProcessTampered, "true", list)
+	opTampered.Compile()
+	if opTampered.Match(conn) == true {
+		t.Error("Test NewOperator() process.tampered matches a process not tampered")
+	}
+	conn.Tampered = true
+	defer func() { conn.Tampered = false }()
+	if opTampered.Match(conn) == false {
+		t.Error("Test NewOperator() process.tampered doesn't match")
+	}
+}
+
+func TestNewOperatorExecCmd(t *testing.T) {
+	t.Log("Test NewOperator() process.exec_command")
+	var list []Operator
+
+	opExecCmd, _ := NewOperator(Simple, false, OpProcessExecCmd, defaultProcArgs, list)
+	opExecCmd.Compile()
+	// the current command line is used if the original one is not known.
+	if opExecCmd.Match(conn) == false {
+		t.Error("Test NewOperator() process.exec_command doesn't match the current cmdline")
+	}
+	conn.Process.ExecArgs = []string{"/usr/bin/opensnitchd", "-debug"}
+	defer func() { conn.Process.ExecArgs = nil }()
+	if opExecCmd.Match(conn) == true {
+		t.Error("Test NewOperator() process.exec_command matches the current cmdline")
+	}
+	opExecCmd, _ = NewOperator(Simple, false, OpProcessExecCmd, "/usr/bin/opensnitchd -debug", list)
+	opExecCmd.Compile()
+	if opExecCmd.Match(conn) == false {
+		t.Error("Test NewOperator() process.exec_command doesn't match")
+	}
+}
+
+func TestNewOperatorDstHostChain(t *testing.T) {
+	t.Log("Test NewOperator() dest.host CNAME chain")
+	var list []Operator
+
+	opHost, _ := NewOperator(Simple, false, OpDstHost, "tracker.example.net", list)
+	opHost.Compile()
+	if opHost.Match(conn) == true {
+		t.Error("Test NewOperator() dest.host matches a CNAME not resolved")
+	}
+	conn.DstHostChain = []string{"tracker.example.net", "cdn.example.net", defaultDstHost}
+	defer func() { conn.DstHostChain = nil }()
+	if opHost.Match(conn) == false {
+		t.Error("Test NewOperator() dest.host doesn't match a CNAME of the chain")
+	}
+
+	opRegexp, _ := NewOperator(Regexp, false, OpDstHost, "^cdn\\.", list)
+	opRegexp.Compile()
+	if opRegexp.Match(conn) == false {
+		t.Error("Test NewOperator() dest.host regexp doesn't match a CNAME of the chain")
+	}
+
+	opDomain, _ := NewOperator(Simple, false, OpDstHost, defaultDstHost, list)
+	opDomain.Compile()
+	if opDomain.Match(conn) == false {
+		t.Error("Test NewOperator() dest.host doesn't match the domain requested")
+	}
+}
+
+func TestNewOperatorSession(t *testing.T) {
+	t.Log("Test NewOperator() session")
+	var list []Operator
+
+	opUser, _ := NewOperator(Simple, false, OpSessionUser, "alice", list)
+	opUser.Compile()
+	if opUser.Match(conn) == true {
+		t.Error("Test NewOperator() session.user matches a process without session")
+	}
+
+	conn.Process.Session = &procmon.Session{ID: "2", UID: 1000, User: "alice", Type: "wayland", Class: "user", Seat: "seat0"}
+	defer func() { conn.Process.Session = nil }()
+
+	if opUser.Match(conn) == false {
+		t.Error("Test NewOperator() session.user doesn't match")
+	}
+	opType, _ := NewOperator(Regexp, false, OpSessionType, "^(x11|wayland)$", list)
+	opType.Compile()
+	if opType.Match(conn) == false {
+		t.Error("Test NewOperator() session.type doesn't match")
+	}
+	conn.Process.Session.Type = "tty"
+	if opType.Match(conn) == true {
+		t.Error("Test NewOperator() session.type matches a tty session")
+	}
+}
+
+func TestNewOperatorParent(t *testing.T) {
+	t.Log("Test NewOperator() parent")
+	var list []Operator
+	conn.Process.Parent = &procmon.Process{
+		ID:   1234,
+		Path: "/usr/bin/make",
+		Args: []string{"make", "all"},
+		Parent: &procmon.Process{
+			ID:   1,
+			Path: "/usr/lib/systemd/systemd",
+		},
+	}
+	defer func() { conn.Process.Parent = nil }()
+
+	opParent, _ := NewOperator(Simple, false, OpProcessParentPath, "/usr/bin/make", list)
+	if err := opParent.Compile(); err != nil {
+		t.Error("NewOperator parent, Compile() error:", err)
+	}
+	if opParent.Match(conn) == false {
+		t.Error("Test NewOperator() parent path doesn't match")
+	}
+
+	opParentCmd, _ := NewOperator(Simple, false, OpProcessParentCmd, "make all", list)
+	if err := opParentCmd.Compile(); err != nil {
+		t.Error("NewOperator parent command, Compile() error:", err)
+	}
+	if opParentCmd.Match(conn) == false {
+		t.Error("Test NewOperator() parent command doesn't match")
+	}
+
+	opAncestor, _ := NewOperator(Simple, false, OpProcessAncestorPath, "/usr/lib/systemd/systemd", list)
+	if err := opAncestor.Compile(); err != nil {
+		t.Error("NewOperator ancestor, Compile() error:", err)
+	}
+	if opAncestor.Match(conn) == false {
+		t.Error("Test NewOperator() ancestor path doesn't match")
+	}
+	opAncestor.Data = "/usr/bin/bash"
+	if opAncestor.Match(conn) == true {
+		t.Error("Test NewOperator() ancestor path matches")
+	}
+
+	conn.Process.Parent = nil
+	if opParent.Match(conn) == true {
+		t.Error("Test NewOperator() parent path matches without parent")
+	}
+}
+
 func TestNewOperatorListsSimple(t *testing.T) {
 	t.Log("Test NewOperator() Lists simple")
 	var dummyList []Operator
@@ -458,6 +888,129 @@ func TestNewOperatorListsSimple(t *testing.T) {
 	restoreConnection()
 }
 
+func TestNewOperatorListsWildcards(t *testing.T) {
+	t.Log("Test NewOperator() Lists wildcards")
+	var dummyList []Operator
+
+	opLists, err := NewOperator(Lists, false, OpDomainsLists, "testdata/lists/wildcards/", dummyList)
+	if err != nil {
+		t.Error("NewOperator Lists wildcards, shouldn't be nil: ", err)
+		t.Fail()
+	}
+	if err = opLists.Compile(); err != nil {
+		t.Error("NewOperator Lists wildcards, Compile() error:", err)
+	}
+	time.Sleep(time.Second)
+	opLists.Lock()
+	if len(opLists.lists) != 1 || opLists.wildcards.Len() != 2 {
+		t.Error("NewOperator Lists wildcards, number of domains error:", len(opLists.lists), opLists.wildcards.Len())
+	}
+	opLists.Unlock()
+
+	domains := map[string]bool{
+		"opensnitch.io":          true,
+		"ads.doubleclick.net":    true,
+		"a.b.doubleclick.net":    true,
+		"ads.example.org":        true,
+		"doubleclick.net":        false,
+		"io":                     false,
+		"www.example.org":        false,
+		"www.doubleclick.net.es": false,
+	}
+	for domain, match := range domains {
+		conn.DstHost = domain
+		if opLists.Match(conn) != match {
+			t.Error("Test NewOperator() Lists wildcards, match error:", domain, match)
+		}
+	}
+
+	opLists.StopMonitoringLists()
+	time.Sleep(time.Second)
+	opLists.Lock()
+	if len(opLists.lists) != 0 || opLists.wildcards.Len() != 0 {
+		t.Error("NewOperator Lists wildcards, number should be 0 after stop:", len(opLists.lists), opLists.wildcards.Len())
+	}
+	opLists.Unlock()
+
+	restoreConnection()
+}
+
+func TestNewOperatorRemoteLists(t *testing.T) {
+	t.Log("Test NewOperator() remote Lists")
+	var dummyList []Operator
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# comment\nopensnitch.io\n0.0.0.0 www.test.org\nwww.test.org\n")
+	}))
+	defer server.Close()
+	remoteListsCacheDir = tmpDir + "/remote_lists"
+
+	for _, data := range []string{server.URL + " refresh=1s", "http://example.org/list.txt refresh=1x", "http:// refresh=1h"} {
+		opLists, _ := NewOperator(Lists, false, OpDomainsLists, data, dummyList)
+		if err := opLists.Compile(); err == nil {
+			t.Error("NewOperator remote Lists, invalid data compiled:", data)
+		}
+	}
+
+	opLists, err := NewOperator(Lists, false, OpDomainsLists, server.URL+"/list.txt, refresh=1h", dummyList)
+	if err != nil {
+		t.Error("NewOperator remote Lists, shouldn't be nil: ", err)
+		t.Fail()
+	}
+	if err = opLists.Compile(); err != nil {
+		t.Error("NewOperator remote Lists, Compile() error:", err)
+	}
+	time.Sleep(time.Second)
+	opLists.Lock()
+	if len(opLists.lists) != 2 {
+		t.Error("NewOperator remote Lists, number of domains error:", opLists.lists, len(opLists.lists))
+	}
+	opLists.Unlock()
+	if opLists.Match(conn) == false {
+		t.Error("Test NewOperator() remote lists doesn't match")
+	}
+	if !core.Exists(remoteListCacheFile(server.URL + "/list.txt")) {
+		t.Error("NewOperator remote Lists, list not cached")
+	}
+
+	opLists.StopMonitoringLists()
+	time.Sleep(time.Second)
+	opLists.Lock()
+	if len(opLists.lists) != 0 {
+		t.Error("NewOperator remote Lists, number should be 0 after stop:", opLists.lists, len(opLists.lists))
+	}
+	opLists.Unlock()
+
+	restoreConnection()
+}
+
+func TestFetchRemoteListMaxSize(t *testing.T) {
+	t.Log("Test fetchRemoteList() max size")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big.txt" {
+			fmt.Fprint(w, strings.Repeat("a", 33))
+			return
+		}
+		fmt.Fprint(w, strings.Repeat("a", 32))
+	}))
+	defer server.Close()
+	remoteListsCacheDir = tmpDir + "/remote_lists_size"
+	oldMaxSize := remoteListsMaxSize
+	remoteListsMaxSize = 32
+	defer func() { remoteListsMaxSize = oldMaxSize }()
+
+	if changed, err := fetchRemoteList(context.Background(), server.URL+"/list.txt", time.Hour); err != nil || !changed {
+		t.Error("fetchRemoteList() list of the max size not downloaded:", changed, err)
+	}
+	if changed, err := fetchRemoteList(context.Background(), server.URL+"/big.txt", time.Hour); err == nil || changed {
+		t.Error("fetchRemoteList() list bigger than the max size downloaded:", changed, err)
+	}
+	if core.Exists(remoteListCacheFile(server.URL+"/big.txt")) || core.Exists(remoteListCacheFile(server.URL+"/big.txt")+".tmp") {
+		t.Error("fetchRemoteList() list bigger than the max size cached")
+	}
+}
+
 func TestNewOperatorListsIPs(t *testing.T) {
 	t.Log("Test NewOperator() Lists domains_regexp")
 
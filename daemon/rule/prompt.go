@@ -0,0 +1,76 @@
+package rule
+
+import (
+	"fmt"
+	"time"
+)
+
+// The rules with the action prompt ask the user to allow or deny the
+// connections they match, even if other rules would allow them, to confirm
+// the connections of sensitive applications (ssh, gpg, ...):
+//
+// "action": "prompt",
+// "prompt": {
+//   "timeout": 30,
+//   "action": "deny"
+// }
+//
+// If the user doesn't answer within the timeout (seconds, 120 by default),
+// or the GUI is not connected, the action of the prompt is applied (deny, by
+// default). The answer of the user only applies to the connection asked, so
+// the next connections are asked again. The rules with the action prompt stop
+// the evaluation of the rules, like the rules that deny connections.
+
+// Prompt is the action to ask the user.
+const Prompt = Action("prompt")
+
+// DefaultPromptTimeout is the time to wait for the answer of the user, if the
+// rule doesn't configure it.
+const DefaultPromptTimeout = 120 * time.Second
+
+// PromptOptions configures how the user is asked by the rules with the action
+// prompt.
+type PromptOptions struct {
+	Timeout uint32 `json:"timeout,omitempty"`
+	Action  Action `json:"action,omitempty"`
+}
+
+// Compile validates the options of the prompt.
+func (p *PromptOptions) Compile() error {
+	switch p.Action {
+	case "", Allow, Deny, Reject:
+	default:
+		return fmt.Errorf("Invalid prompt action: %s", p.Action)
+	}
+	return nil
+}
+
+// GetTimeout returns the time to wait for the answer of the user.
+func (p *PromptOptions) GetTimeout() time.Duration {
+	if p == nil || p.Timeout == 0 {
+		return DefaultPromptTimeout
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+func (p *PromptOptions) getAction() Action {
+	if p == nil || p.Action == "" {
+		return Deny
+	}
+	return p.Action
+}
+
+// PromptFallback returns the rule to apply to a connection matched by a rule
+// with the action prompt, if the user hasn't answered.
+func (r *Rule) PromptFallback() *Rule {
+	return &Rule{
+		Created:     r.Created,
+		Name:        r.Name,
+		Description: r.Description,
+		Enabled:     true,
+		Priority:    r.Priority,
+		Nolog:       r.Nolog,
+		Action:      r.Prompt.getAction(),
+		Duration:    r.Duration,
+	}
+}
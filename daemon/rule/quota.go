@@ -0,0 +1,405 @@
+package rule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	daemonNetlink "github.com/evilsocket/opensnitch/daemon/netlink"
+	"github.com/vishvananda/netlink"
+)
+
+// Rules that allow connections can limit the amount of data transferred by
+// the connections they allow:
+//
+// "quota": {
+//   "limit": "2GB",
+//   "period": "day"
+// }
+//
+// The limit is the number of bytes sent and received, with an optional unit:
+// B, KB, MB, GB or TB (1KB = 1024 bytes).
+// The period is hour, day, week or month, which start at the beginning of the
+// hour, day, week or month, or a duration (12h, 30m), which starts when the
+// rule is loaded.
+// Once the quota is exceeded, the rule denies the connections until the end of
+// the period, and the connections already allowed are closed.
+//
+// The bytes transferred are read from the conntrack table, so the accounting of
+// conntrack must be enabled (net.netfilter.nf_conntrack_acct=1). We enable it
+// the first time a connection is allowed by a rule with a quota, and restore
+// the previous value on exit (see StopAccounting()).
+// The usage is not saved to disk, it starts from 0 when the daemon is restarted.
+
+const (
+	QuotaHour  = "hour"
+	QuotaDay   = "day"
+	QuotaWeek  = "week"
+	QuotaMonth = "month"
+)
+
+var (
+	quotaUnits = map[string]uint64{
+		"":   1,
+		"b":  1,
+		"kb": 1 << 10,
+		"mb": 1 << 20,
+		"gb": 1 << 30,
+		"tb": 1 << 40,
+	}
+
+	// protocols of the connections that can be accounted.
+	quotaProtos = map[string]uint8{
+		"tcp":     6,
+		"udp":     17,
+		"udplite": 136,
+		"sctp":    132,
+	}
+
+	// interval to read the bytes transferred by the connections.
+	accountingInterval = 5 * time.Second
+
+	conntrackAcctPath = "/proc/sys/net/netfilter/nf_conntrack_acct"
+)
+
+// Quota limits the data transferred by the connections allowed by a rule.
+type Quota struct {
+	Limit  string `json:"limit"`
+	Period string `json:"period"`
+
+	usage *quotaUsage
+}
+
+type quotaUsage struct {
+	sync.Mutex
+	limit    uint64
+	period   time.Duration
+	used     uint64
+	reset    time.Time
+	exceeded bool
+}
+
+// Compile parses the limit and the period of the quota.
+func (q *Quota) Compile() error {
+	limit, err := parseQuotaLimit(q.Limit)
+	if err != nil {
+		return err
+	}
+	period := time.Duration(0)
+	switch strings.ToLower(q.Period) {
+	case QuotaHour, QuotaDay, QuotaWeek, QuotaMonth:
+	default:
+		if period, err = time.ParseDuration(q.Period); err != nil || period <= 0 {
+			return fmt.Errorf("Invalid quota period: %s", q.Period)
+		}
+	}
+
+	q.usage = &quotaUsage{
+		limit:  limit,
+		period: period,
+	}
+	q.usage.reset = q.nextReset(time.Now())
+
+	return nil
+}
+
+// parseQuotaLimit returns the number of bytes of a limit: 2GB, 500mb, 1024
+func parseQuotaLimit(limit string) (uint64, error) {
+	limit = strings.ToLower(strings.TrimSpace(limit))
+	num := strings.TrimRight(limit, "bkmgt")
+	unit, found := quotaUnits[strings.TrimSpace(limit[len(num):])]
+	if !found {
+		return 0, fmt.Errorf("Invalid quota limit unit: %s", limit)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("Invalid quota limit: %s", limit)
+	}
+
+	return uint64(value * float64(unit)), nil
+}
+
+// nextReset returns when the next period of the quota starts.
+func (q *Quota) nextReset(now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch strings.ToLower(q.Period) {
+	case QuotaHour:
+		return time.Date(y, m, d, now.Hour()+1, 0, 0, 0, now.Location())
+	case QuotaDay:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	case QuotaWeek:
+		// weeks start on monday.
+		days := (8 - int(now.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return time.Date(y, m, d+days, 0, 0, 0, 0, now.Location())
+	case QuotaMonth:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+	}
+	return now.Add(q.usage.period)
+}
+
+// update starts a new period if the current one has ended.
+// It must be called with the usage locked.
+func (q *Quota) update(now time.Time) {
+	if now.Before(q.usage.reset) {
+		return
+	}
+	if q.usage.exceeded {
+		log.Important("Quota restored, new period started: %s / %s", q.Limit, q.Period)
+	}
+	q.usage.used = 0
+	q.usage.exceeded = false
+	q.usage.reset = q.nextReset(now)
+}
+
+// inherit keeps the usage of the old definition of the rule, if the quota
+// hasn't changed.
+func (q *Quota) inherit(old *Quota) {
+	if old == nil || old.usage == nil || q.usage == nil ||
+		old.Limit != q.Limit || old.Period != q.Period {
+		return
+	}
+	q.usage = old.usage
+}
+
+// Add adds the given bytes to the usage of the quota, and returns true if the
+// quota has been exceeded with them.
+func (q *Quota) Add(bytes uint64) bool {
+	if q.usage == nil {
+		return false
+	}
+	q.usage.Lock()
+	defer q.usage.Unlock()
+
+	q.update(time.Now())
+	q.usage.used += bytes
+	if q.usage.exceeded || q.usage.used < q.usage.limit {
+		return false
+	}
+	q.usage.exceeded = true
+	return true
+}
+
+// Exceeded checks if the quota has been exceeded in the current period.
+func (q *Quota) Exceeded() bool {
+	if q == nil || q.usage == nil {
+		return false
+	}
+	q.usage.Lock()
+	defer q.usage.Unlock()
+
+	q.update(time.Now())
+	return q.usage.exceeded
+}
+
+// Used returns the bytes transferred in the current period.
+func (q *Quota) Used() uint64 {
+	if q == nil || q.usage == nil {
+		return 0
+	}
+	q.usage.Lock()
+	defer q.usage.Unlock()
+
+	q.update(time.Now())
+	return q.usage.used
+}
+
+// trackedFlow is a connection allowed by a rule with a quota.
+type trackedFlow struct {
+	con *conman.Connection
+	// the rule that allowed the connection, and the non-terminating rules
+	// that matched it, with a quota.
+	rules []string
+	bytes uint64
+	seen  bool
+}
+
+func (tf *trackedFlow) hasRule(name string) bool {
+	for _, r := range tf.rules {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func flowFamily(con *conman.Connection) netlink.InetFamily {
+	if con.DstIP.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+func flowKey(proto uint8, srcIP string, srcPort uint, dstIP string, dstPort uint) string {
+	return fmt.Sprintf("%d %s:%d %s:%d", proto, srcIP, srcPort, dstIP, dstPort)
+}
+
+// TrackConnection accounts the bytes transferred by a connection allowed by a
+// rule with a quota, or matched by non-terminating rules with a quota.
+func (l *Loader) TrackConnection(con *conman.Connection, r *Rule) {
+	names := l.quotaRules(con, r)
+	if len(names) == 0 {
+		return
+	}
+	proto, found := quotaProtos[strings.TrimSuffix(con.Protocol, "6")]
+	if !found {
+		return
+	}
+
+	l.flowsLock.Lock()
+	defer l.flowsLock.Unlock()
+
+	if l.flows == nil {
+		l.flows = make(map[string]*trackedFlow)
+		l.enableConntrackAcct()
+		l.stopAccounting = make(chan struct{})
+		go l.accountingWorker(l.stopAccounting)
+	}
+	key := flowKey(proto, con.SrcIP.String(), con.SrcPort, con.DstIP.String(), con.DstPort)
+	l.flows[key] = &trackedFlow{con: con, rules: names}
+}
+
+// quotaRules returns the names of the rules with a quota of a connection.
+func (l *Loader) quotaRules(con *conman.Connection, r *Rule) []string {
+	names := []string{}
+	if r.Quota != nil && r.Quota.usage != nil {
+		names = append(names, r.Name)
+	}
+	if len(con.Rules) == 0 {
+		return names
+	}
+	l.RLock()
+	defer l.RUnlock()
+	for _, name := range con.Rules {
+		if cr, found := l.rules[name]; found && cr.Quota != nil && cr.Quota.usage != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// enableConntrackAcct enables the accounting of conntrack, saving the previous
+// value to restore it on exit. It must be called with the flows lock held.
+func (l *Loader) enableConntrackAcct() {
+	old, err := ioutil.ReadFile(conntrackAcctPath)
+	if err == nil && strings.TrimSpace(string(old)) == "1" {
+		return
+	}
+	if err := ioutil.WriteFile(conntrackAcctPath, []byte("1"), 0644); err != nil {
+		log.Warning("Unable to enable conntrack accounting, quotas won't work: %s", err)
+		return
+	}
+	l.conntrackAcct = old
+}
+
+// StopAccounting stops accounting the bytes of the connections allowed by
+// rules with a quota, and restores the accounting of conntrack to the value it
+// had before enabling it.
+func (l *Loader) StopAccounting() {
+	l.flowsLock.Lock()
+	defer l.flowsLock.Unlock()
+
+	if l.stopAccounting != nil {
+		close(l.stopAccounting)
+		l.stopAccounting = nil
+	}
+	l.flows = nil
+	if l.conntrackAcct == nil {
+		return
+	}
+	if err := ioutil.WriteFile(conntrackAcctPath, l.conntrackAcct, 0644); err != nil {
+		log.Warning("Unable to restore conntrack accounting: %s", err)
+	}
+	l.conntrackAcct = nil
+}
+
+func (l *Loader) accountingWorker(stop <-chan struct{}) {
+	t := time.NewTicker(accountingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			l.updateQuotas()
+		}
+	}
+}
+
+// updateQuotas adds to the quota of the rules the bytes transferred by their
+// connections since the last update.
+// The connections that are not in the conntrack table anymore are forgotten.
+func (l *Loader) updateQuotas() {
+	l.flowsLock.Lock()
+	defer l.flowsLock.Unlock()
+
+	if len(l.flows) == 0 {
+		return
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	exceeded := make(map[string]*Rule)
+	// the connections of the families that couldn't be dumped are kept.
+	failed := make(map[netlink.InetFamily]bool)
+	for _, family := range []netlink.InetFamily{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, family)
+		if err != nil {
+			log.Debug("Quotas, error dumping conntrack table (family %d): %s", family, err)
+			failed[family] = true
+			continue
+		}
+		for _, f := range flows {
+			key := flowKey(f.Forward.Protocol, f.Forward.SrcIP.String(), uint(f.Forward.SrcPort), f.Forward.DstIP.String(), uint(f.Forward.DstPort))
+			tf, found := l.flows[key]
+			if !found {
+				continue
+			}
+			tf.seen = true
+			total := f.Forward.Bytes + f.Reverse.Bytes
+			delta := total - tf.bytes
+			if total < tf.bytes {
+				// the conntrack entry has been recreated.
+				delta = total
+			}
+			tf.bytes = total
+
+			for _, name := range tf.rules {
+				r, found := l.rules[name]
+				if !found || r.Quota == nil {
+					continue
+				}
+				if r.Quota.Add(delta) {
+					exceeded[r.Name] = r
+				}
+			}
+		}
+	}
+
+	for key, tf := range l.flows {
+		if !tf.seen && !failed[flowFamily(tf.con)] {
+			delete(l.flows, key)
+			continue
+		}
+		tf.seen = false
+	}
+
+	for name, r := range exceeded {
+		log.Important("Quota exceeded, denying connections of rule %s (%s / %s)", name, r.Quota.Limit, r.Quota.Period)
+		for key, tf := range l.flows {
+			if !tf.hasRule(name) {
+				continue
+			}
+			daemonNetlink.KillSocket(tf.con.Protocol, tf.con.SrcIP, tf.con.SrcPort, tf.con.DstIP, tf.con.DstPort)
+			delete(l.flows, key)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+)
+
+// Rules that allow connections can limit the rate of new connections they
+// allow, to detect applications that connect periodically to the same
+// destinations (beaconing):
+//
+// "rate_limit": {
+//   "rate": "5/minute",
+//   "burst": 5,
+//   "action": "prompt"
+// }
+//
+// The rate is the number of connections allowed per second, minute, hour or
+// day. Burst is the number of connections that can be allowed at once, by
+// default the number of connections of the rate.
+// Once the limit is exceeded, the connections are denied (deny, by default),
+// rejected (reject), or the user is asked to allow or deny them (prompt, see
+// prompt.go), until the rate of connections falls below the limit.
+// The limit is implemented with a token bucket, which is refilled at the given
+// rate, so the connections are evaluated in userland, without the firewall.
+
+var rateLimitUnits = map[string]time.Duration{
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// RateLimit limits the new connections allowed by a rule.
+type RateLimit struct {
+	Rate   string `json:"rate"`
+	Burst  uint32 `json:"burst,omitempty"`
+	Action Action `json:"action,omitempty"`
+
+	bucket *tokenBucket
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	capacity float64
+	tokens   float64
+	// tokens added per second.
+	refill float64
+	last   time.Time
+}
+
+// Compile parses the rate and the action of the limit.
+func (rl *RateLimit) Compile() error {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(rl.Rate)), "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid rate limit: %s", rl.Rate)
+	}
+	count, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil || count == 0 {
+		return fmt.Errorf("Invalid rate limit: %s", rl.Rate)
+	}
+	unit, found := rateLimitUnits[strings.TrimSpace(parts[1])]
+	if !found {
+		return fmt.Errorf("Invalid rate limit unit: %s", rl.Rate)
+	}
+	switch rl.Action {
+	case "", Deny, Reject, Prompt:
+	default:
+		return fmt.Errorf("Invalid rate limit action: %s", rl.Action)
+	}
+
+	capacity := float64(count)
+	if rl.Burst > 0 {
+		capacity = float64(rl.Burst)
+	}
+	rl.bucket = &tokenBucket{
+		capacity: capacity,
+		tokens:   capacity,
+		refill:   float64(count) / unit.Seconds(),
+		last:     time.Now(),
+	}
+
+	return nil
+}
+
+// inherit keeps the tokens of the old definition of the rule, if the limit
+// hasn't changed.
+func (rl *RateLimit) inherit(old *RateLimit) {
+	if old == nil || old.bucket == nil || rl.bucket == nil ||
+		old.Rate != rl.Rate || old.Burst != rl.Burst {
+		return
+	}
+	rl.bucket = old.bucket
+}
+
+// Take takes a token from the bucket for a new connection, and returns false
+// if the limit has been exceeded.
+func (rl *RateLimit) Take() bool {
+	if rl == nil || rl.bucket == nil {
+		return true
+	}
+	b := rl.bucket
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.refill
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// applyRateLimit returns the rule to apply to a connection allowed by a rule
+// with a limit of connections: the rule itself if the limit hasn't been
+// exceeded, or a rule that denies, rejects or asks the user about the
+// connection. If the user doesn't answer, the connection is denied.
+func applyRateLimit(r *Rule) *Rule {
+	if r == nil || r.RateLimit == nil || r.GetAction() != Allow || r.RateLimit.Take() {
+		return r
+	}
+	log.Warning("Rate limit of the rule %s exceeded (%s), %s", r.Name, r.RateLimit.Rate, r.RateLimit.getAction())
+
+	return &Rule{
+		Created:     r.Created,
+		Name:        r.Name,
+		Description: r.Description,
+		Enabled:     true,
+		Priority:    r.Priority,
+		Nolog:       r.Nolog,
+		Action:      r.RateLimit.getAction(),
+		Duration:    r.Duration,
+	}
+}
+
+func (rl *RateLimit) getAction() Action {
+	if rl.Action == "" {
+		return Deny
+	}
+	return rl.Action
+}
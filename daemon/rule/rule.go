@@ -2,6 +2,7 @@ package rule
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/conman"
@@ -33,16 +34,43 @@ const (
 // The fields match the ones saved as json to disk.
 // If a .json rule file is modified on disk, it's reloaded automatically.
 type Rule struct {
-	Created     time.Time `json:"created"`
-	Updated     time.Time `json:"updated"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Enabled     bool      `json:"enabled"`
-	Precedence  bool      `json:"precedence"`
-	Nolog       bool      `json:"nolog"`
-	Action      Action    `json:"action"`
-	Duration    Duration  `json:"duration"`
-	Operator    Operator  `json:"operator"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Enabled     bool       `json:"enabled"`
+	Precedence  bool       `json:"precedence"`
+	Priority    int32      `json:"priority"`
+	Nolog       bool       `json:"nolog"`
+	Action      Action     `json:"action"`
+	Duration    Duration   `json:"duration"`
+	Operator    Operator   `json:"operator"`
+	Quota       *Quota     `json:"quota,omitempty"`
+	RateLimit   *RateLimit `json:"rate_limit,omitempty"`
+	// how the user is asked by the rules with the action prompt.
+	Prompt *PromptOptions `json:"prompt,omitempty"`
+	// Profiles the rule belongs to. Rules without profiles are always evaluated.
+	Profiles []string `json:"profiles,omitempty"`
+	// Tags to classify and search the rules.
+	Tags []string `json:"tags,omitempty"`
+	// the evaluation of the rules continues after this rule matches a
+	// connection, and its action is not applied (unless its quota is exceeded).
+	// The connection is logged, tagged with the tags of the rule, and
+	// accounted in the quota of the rule.
+	Continue bool `json:"continue,omitempty"`
+	// the rule is only applied to the connections that don't match any other
+	// rule, instead of asking the user or applying the global default action.
+	// It allows to define the default action of an application.
+	Fallback bool `json:"fallback,omitempty"`
+	// the rule only matches the connections of the processes started within
+	// this interval (30s, 2m, ...).
+	StartupWindow string `json:"startup_window,omitempty"`
+
+	// when the temporary rule expires.
+	expires       time.Time
+	startupWindow time.Duration
+	// session of the until-logout rules.
+	session *userSession
 }
 
 // Create creates a new rule object with the specified parameters.
@@ -67,7 +95,70 @@ func (r *Rule) String() string {
 // Match performs on a connection the checks a Rule has, to determine if it
 // must be allowed or denied.
 func (r *Rule) Match(con *conman.Connection) bool {
-	return r.Operator.Match(con)
+	return r.inStartupWindow(con) && r.Operator.Match(con)
+}
+
+// InProfile checks if the rule must be evaluated when the given profile is active.
+func (r *Rule) InProfile(profile string) bool {
+	if len(r.Profiles) == 0 {
+		return true
+	}
+	for _, p := range r.Profiles {
+		if strings.EqualFold(p, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAction returns the action to apply to the connections matched by the
+// rule, which is Deny if the rule allows connections but its quota has been
+// exceeded.
+func (r *Rule) GetAction() Action {
+	if r.Action == Allow && r.Quota.Exceeded() {
+		return Deny
+	}
+	return r.Action
+}
+
+// HasTag checks if the rule has the given tag.
+func (r *Rule) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTags adds the tags of the rule that are not already in the list.
+func (r *Rule) addTags(tags []string) []string {
+Tags:
+	for _, tag := range r.Tags {
+		for _, t := range tags {
+			if strings.EqualFold(t, tag) {
+				continue Tags
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Contains checks if the name, description, tags or the data of the operators
+// of the rule contain the given text, regardless of the case.
+func (r *Rule) Contains(text string) bool {
+	text = strings.ToLower(text)
+	fields := append([]string{r.Name, r.Description, r.Operator.Data}, r.Tags...)
+	for i := range r.Operator.List {
+		fields = append(fields, r.Operator.List[i].Data)
+	}
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), text) {
+			return true
+		}
+	}
+	return false
 }
 
 // Deserialize translates back the rule received to a Rule object
@@ -87,8 +178,9 @@ func Deserialize(reply *protocol.Rule) (*Rule, error) {
 		log.Warning("Deserialize rule, NewOperator() error: %s", err)
 		return nil, err
 	}
+	operator.Negate = reply.Operator.Negate
 
-	return Create(
+	r := Create(
 		reply.Name,
 		reply.Description,
 		reply.Enabled,
@@ -97,7 +189,34 @@ func Deserialize(reply *protocol.Rule) (*Rule, error) {
 		Action(reply.Action),
 		Duration(reply.Duration),
 		operator,
-	), nil
+	)
+	r.Priority = reply.Priority
+	r.Profiles = reply.Profiles
+	r.Tags = reply.Tags
+	r.Continue = reply.Continue
+	r.Fallback = reply.Fallback
+	r.StartupWindow = reply.StartupWindow
+	if reply.Quota != nil {
+		r.Quota = &Quota{
+			Limit:  reply.Quota.Limit,
+			Period: reply.Quota.Period,
+		}
+	}
+	if reply.RateLimit != nil {
+		r.RateLimit = &RateLimit{
+			Rate:   reply.RateLimit.Rate,
+			Burst:  reply.RateLimit.Burst,
+			Action: Action(reply.RateLimit.Action),
+		}
+	}
+	if reply.Prompt != nil {
+		r.Prompt = &PromptOptions{
+			Timeout: reply.Prompt.Timeout,
+			Action:  Action(reply.Prompt.Action),
+		}
+	}
+
+	return r, nil
 }
 
 // Serialize translates a Rule to the protocol object
@@ -105,11 +224,35 @@ func (r *Rule) Serialize() *protocol.Rule {
 	if r == nil {
 		return nil
 	}
+	var quota *protocol.RuleQuota
+	if r.Quota != nil {
+		quota = &protocol.RuleQuota{
+			Limit:  r.Quota.Limit,
+			Period: r.Quota.Period,
+			Used:   r.Quota.Used(),
+		}
+	}
+	var rateLimit *protocol.RuleRateLimit
+	if r.RateLimit != nil {
+		rateLimit = &protocol.RuleRateLimit{
+			Rate:   r.RateLimit.Rate,
+			Burst:  r.RateLimit.Burst,
+			Action: string(r.RateLimit.Action),
+		}
+	}
+	var prompt *protocol.RulePrompt
+	if r.Prompt != nil {
+		prompt = &protocol.RulePrompt{
+			Timeout: r.Prompt.Timeout,
+			Action:  string(r.Prompt.Action),
+		}
+	}
 	return &protocol.Rule{
 		Name:        string(r.Name),
 		Description: string(r.Description),
 		Enabled:     bool(r.Enabled),
 		Precedence:  bool(r.Precedence),
+		Priority:    r.Priority,
 		Nolog:       bool(r.Nolog),
 		Action:      string(r.Action),
 		Duration:    string(r.Duration),
@@ -118,6 +261,16 @@ func (r *Rule) Serialize() *protocol.Rule {
 			Sensitive: bool(r.Operator.Sensitive),
 			Operand:   string(r.Operator.Operand),
 			Data:      string(r.Operator.Data),
+			Negate:    r.Operator.Negate,
 		},
+		Quota:     quota,
+		RateLimit: rateLimit,
+		Profiles:  r.Profiles,
+		Tags:      r.Tags,
+		Continue:  r.Continue,
+		Fallback:  r.Fallback,
+
+		StartupWindow: r.StartupWindow,
+		Prompt:        prompt,
 	}
 }
@@ -1,6 +1,10 @@
 package rule
 
-import "testing"
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
 
 func TestCreate(t *testing.T) {
 	t.Log("Test: Create rule")
@@ -45,3 +49,179 @@ func TestCreate(t *testing.T) {
 		}
 	})
 }
+
+func TestRuleQuota(t *testing.T) {
+	t.Log("Test: Rule quota")
+
+	var list []Operator
+	oper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	r := Create("000-test-quota", "rule description 000", true, false, false, Allow, Always, oper)
+	r.Quota = &Quota{Limit: "2KB", Period: QuotaDay}
+	if err := r.Quota.Compile(); err != nil {
+		t.Fatal("Quota.Compile() error:", err)
+	}
+
+	t.Run("Quota not exceeded", func(t *testing.T) {
+		if r.Quota.Add(1024) {
+			t.Error("Quota exceeded with 1024 bytes")
+		}
+		if r.GetAction() != Allow {
+			t.Error("Rule action is not Allow:", r.GetAction())
+		}
+		if r.Quota.Used() != 1024 {
+			t.Error("Quota used bytes error:", r.Quota.Used())
+		}
+	})
+	t.Run("Quota exceeded", func(t *testing.T) {
+		if !r.Quota.Add(1024) {
+			t.Error("Quota not exceeded with 2048 bytes")
+		}
+		if r.Quota.Add(1024) {
+			t.Error("Quota exceeded twice")
+		}
+		if r.GetAction() != Deny {
+			t.Error("Rule action is not Deny:", r.GetAction())
+		}
+		if r.Action != Allow {
+			t.Error("Rule action modified:", r.Action)
+		}
+	})
+	t.Run("Quota inherited", func(t *testing.T) {
+		q := &Quota{Limit: "2KB", Period: QuotaDay}
+		q.Compile()
+		q.inherit(r.Quota)
+		if !q.Exceeded() {
+			t.Error("Quota usage not inherited")
+		}
+		q = &Quota{Limit: "4KB", Period: QuotaDay}
+		q.Compile()
+		q.inherit(r.Quota)
+		if q.Exceeded() {
+			t.Error("Quota usage inherited with a different limit")
+		}
+	})
+	t.Run("Quota restored", func(t *testing.T) {
+		r.Quota.usage.reset = time.Now().Add(-time.Second)
+		if r.Quota.Exceeded() {
+			t.Error("Quota exceeded after a new period")
+		}
+		if r.GetAction() != Allow {
+			t.Error("Rule action is not Allow:", r.GetAction())
+		}
+	})
+	t.Run("Quota limits and periods", func(t *testing.T) {
+		limits := map[string]uint64{"1024": 1024, "2 GB": 2 << 30, "1.5kb": 1536, "500MB": 500 << 20}
+		for limit, bytes := range limits {
+			if value, err := parseQuotaLimit(limit); err != nil || value != bytes {
+				t.Error("Invalid quota limit:", limit, value, err)
+			}
+		}
+		for _, q := range []Quota{
+			{Limit: "2XB", Period: QuotaDay},
+			{Limit: "GB", Period: QuotaDay},
+			{Limit: "-1", Period: QuotaDay},
+			{Limit: "1GB", Period: "year"},
+			{Limit: "1GB", Period: "-1h"},
+		} {
+			if err := q.Compile(); err == nil {
+				t.Error("Quota compiled:", q.Limit, q.Period)
+			}
+		}
+		q := &Quota{Limit: "1GB", Period: "12h"}
+		if err := q.Compile(); err != nil {
+			t.Error("Quota.Compile() error:", err)
+		}
+	})
+}
+
+func TestConntrackAcct(t *testing.T) {
+	t.Log("Test: conntrack accounting")
+
+	acctPath := conntrackAcctPath
+	defer func() { conntrackAcctPath = acctPath }()
+	conntrackAcctPath = tmpDir + "/nf_conntrack_acct"
+
+	for _, old := range []string{"0\n", "1\n"} {
+		if err := ioutil.WriteFile(conntrackAcctPath, []byte(old), 0644); err != nil {
+			t.Fatal(err)
+		}
+		l := &Loader{}
+		l.enableConntrackAcct()
+		if raw, _ := ioutil.ReadFile(conntrackAcctPath); string(raw) != "1" && string(raw) != "1\n" {
+			t.Errorf("conntrack accounting not enabled: %q", raw)
+		}
+		l.StopAccounting()
+		if raw, _ := ioutil.ReadFile(conntrackAcctPath); string(raw) != old {
+			t.Errorf("conntrack accounting not restored: %q, want %q", raw, old)
+		}
+	}
+}
+
+func TestRuleRateLimit(t *testing.T) {
+	t.Log("Test: Rule rate limit")
+
+	var list []Operator
+	oper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	r := Create("000-test-rate-limit", "rule description 000", true, false, false, Allow, Always, oper)
+	r.RateLimit = &RateLimit{Rate: "2/minute"}
+	if err := r.RateLimit.Compile(); err != nil {
+		t.Fatal("RateLimit.Compile() error:", err)
+	}
+
+	t.Run("Rate limit not exceeded", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if match := applyRateLimit(r); match != r {
+				t.Error("Rate limit exceeded:", i, match)
+			}
+		}
+	})
+	t.Run("Rate limit exceeded", func(t *testing.T) {
+		match := applyRateLimit(r)
+		if match == nil || match.GetAction() != Deny || match.Name != r.Name {
+			t.Error("Rate limit not exceeded:", match)
+		}
+		r.RateLimit.Action = Prompt
+		match = applyRateLimit(r)
+		if match == nil || match.GetAction() != Prompt || match.PromptFallback().GetAction() != Deny {
+			t.Error("Rate limit exceeded, but the user is not asked:", match)
+		}
+	})
+	t.Run("Rate limit refilled", func(t *testing.T) {
+		r.RateLimit.bucket.last = time.Now().Add(-30 * time.Second)
+		if match := applyRateLimit(r); match != r {
+			t.Error("Rate limit not refilled:", match)
+		}
+		if r.RateLimit.Take() {
+			t.Error("Rate limit refilled with more tokens than expected")
+		}
+	})
+	t.Run("Rate limit inherited", func(t *testing.T) {
+		rl := &RateLimit{Rate: "2/minute"}
+		rl.Compile()
+		rl.inherit(r.RateLimit)
+		if rl.Take() {
+			t.Error("Rate limit tokens not inherited")
+		}
+	})
+	t.Run("Rate limits", func(t *testing.T) {
+		for _, rl := range []RateLimit{
+			{Rate: "5"},
+			{Rate: "0/minute"},
+			{Rate: "5/week"},
+			{Rate: "x/s"},
+			{Rate: "5/s", Action: Allow},
+			{Rate: "5/s", Action: "ask"},
+		} {
+			if err := rl.Compile(); err == nil {
+				t.Error("RateLimit compiled:", rl.Rate, rl.Action)
+			}
+		}
+		rl := &RateLimit{Rate: "10 / hour", Burst: 1, Action: Reject}
+		if err := rl.Compile(); err != nil {
+			t.Error("RateLimit.Compile() error:", err)
+		}
+		if !rl.Take() || rl.Take() {
+			t.Error("RateLimit burst not applied")
+		}
+	})
+}
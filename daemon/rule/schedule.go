@@ -0,0 +1,167 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules can be restricted to specific time windows, by adding to the rule an
+// operator of type schedule:
+//
+// "operator": {
+//   "type": "schedule",
+//   "operand": "time.schedule",
+//   "data": "mon-fri 09:00-17:00; sat,sun 10:00-12:00"
+// }
+//
+// The windows are separated by ";". Every window is compounded of a list of
+// days and a range of time, both optional: "mon-fri", "22:00-06:00".
+// If the end of the range is before its start, the window ends the next day.
+// The schedule is evaluated against the local time every time a connection is
+// matched, so there's no need to reload the rules when a window starts or ends.
+
+var weekDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// timeWindow is a range of time, in minutes since midnight, on some days of the week.
+type timeWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+type schedule struct {
+	windows []timeWindow
+}
+
+// parseSchedule parses the time windows of a schedule.
+func parseSchedule(data string) (*schedule, error) {
+	s := &schedule{}
+	for _, w := range strings.Split(data, ";") {
+		if strings.TrimSpace(w) == "" {
+			continue
+		}
+		window, err := parseTimeWindow(strings.ToLower(w))
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, window)
+	}
+	if len(s.windows) == 0 {
+		return nil, fmt.Errorf("Empty schedule: %s", data)
+	}
+
+	return s, nil
+}
+
+// parseTimeWindow parses a window of a schedule: "mon,wed-fri 09:00-17:00"
+func parseTimeWindow(data string) (w timeWindow, err error) {
+	w.end = 24 * 60
+	hasDays := false
+	for _, field := range strings.Fields(data) {
+		if strings.Contains(field, ":") {
+			times := strings.Split(field, "-")
+			if len(times) != 2 {
+				return w, fmt.Errorf("Invalid time range: %s", field)
+			}
+			if w.start, err = parseDayMinutes(times[0]); err != nil {
+				return w, err
+			}
+			if w.end, err = parseDayMinutes(times[1]); err != nil {
+				return w, err
+			}
+			if w.start == w.end {
+				return w, fmt.Errorf("Invalid time range, empty: %s", field)
+			}
+			continue
+		}
+		if err = parseWeekDays(field, &w.days); err != nil {
+			return w, err
+		}
+		hasDays = true
+	}
+	if !hasDays {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+
+	return w, nil
+}
+
+// parseDayMinutes returns the minutes since midnight of the given time: 17:30
+func parseDayMinutes(hhmm string) (int, error) {
+	parts := strings.Split(hhmm, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid time: %s", hhmm)
+	}
+	hours, errH := strconv.Atoi(parts[0])
+	minutes, errM := strconv.Atoi(parts[1])
+	if errH != nil || errM != nil || hours < 0 || minutes < 0 || minutes > 59 ||
+		hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("Invalid time: %s", hhmm)
+	}
+
+	return hours*60 + minutes, nil
+}
+
+// parseWeekDays parses a list of days of the week: mon,wed-fri,sun
+func parseWeekDays(data string, days *[7]bool) error {
+	for _, d := range strings.Split(data, ",") {
+		if d == "" {
+			continue
+		}
+		bounds := strings.Split(d, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("Invalid range of days: %s", d)
+		}
+		first, found := weekDays[bounds[0]]
+		if !found {
+			return fmt.Errorf("Invalid day: %s", bounds[0])
+		}
+		last, found := weekDays[bounds[len(bounds)-1]]
+		if !found {
+			return fmt.Errorf("Invalid day: %s", bounds[len(bounds)-1])
+		}
+		// ranges may wrap around the end of the week: fri-mon
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// isActive checks if the given time is within any of the windows of the schedule.
+func (s *schedule) isActive(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && minutes >= w.start && minutes < w.end {
+				return true
+			}
+			continue
+		}
+		// the window ends the next day.
+		if (w.days[today] && minutes >= w.start) || (w.days[yesterday] && minutes < w.end) {
+			return true
+		}
+	}
+
+	return false
+}
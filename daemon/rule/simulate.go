@@ -0,0 +1,168 @@
+package rule
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/netfilter"
+	"github.com/evilsocket/opensnitch/daemon/netstat"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+)
+
+// Simulation is a synthetic connection, to check which rule would be applied
+// to it, without intercepting a real connection.
+type Simulation struct {
+	ProcessPath  string            `json:"process_path"`
+	ProcessArgs  []string          `json:"process_args,omitempty"`
+	ExecArgs     []string          `json:"process_exec_args,omitempty"`
+	ProcessEnv   map[string]string `json:"process_env,omitempty"`
+	AppID        string            `json:"process_appid,omitempty"`
+	Cgroup       string            `json:"process_cgroup,omitempty"`
+	SystemdUnit  string            `json:"process_systemd_unit,omitempty"`
+	Tampered     bool              `json:"process_tampered,omitempty"`
+	UserID       int               `json:"user_id"`
+	Protocol     string            `json:"protocol,omitempty"`
+	SrcIP        string            `json:"src_ip,omitempty"`
+	SrcPort      uint              `json:"src_port,omitempty"`
+	DstHost      string            `json:"dst_host,omitempty"`
+	DstHostChain []string          `json:"dst_host_chain,omitempty"`
+	SNI          string            `json:"sni,omitempty"`
+	HTTPHost     string            `json:"http_host,omitempty"`
+	DstIP        string            `json:"dst_ip"`
+	DstPort      uint              `json:"dst_port"`
+	NetNS        uint64            `json:"netns,omitempty"`
+	// time since the process was started (10s, 5m, ...), unknown by default.
+	ProcessAge string `json:"process_age,omitempty"`
+	// action expected for the connection, to validate a set of rules.
+	Expect Action `json:"expect,omitempty"`
+}
+
+// SimulationResult is the rule that would be applied to a connection.
+// If no rule matches the connection, the user is asked, or the default action
+// is applied.
+type SimulationResult struct {
+	Matched bool   `json:"matched"`
+	Rule    *Rule  `json:"rule,omitempty"`
+	Action  Action `json:"action,omitempty"`
+	// non-terminating rules that matched the connection, and their tags.
+	Chain []string `json:"chain,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// Connection builds the connection to evaluate against the rules.
+func (s *Simulation) Connection() (*conman.Connection, error) {
+	dstIP := net.ParseIP(s.DstIP)
+	if dstIP == nil {
+		return nil, fmt.Errorf("Invalid destination IP: %s", s.DstIP)
+	}
+	srcIP := net.ParseIP(s.SrcIP)
+	if s.SrcIP == "" {
+		srcIP = net.ParseIP("127.0.0.1")
+		if dstIP.To4() == nil {
+			srcIP = net.IPv6loopback
+		}
+	} else if srcIP == nil {
+		return nil, fmt.Errorf("Invalid source IP: %s", s.SrcIP)
+	}
+	proto := s.Protocol
+	if proto == "" {
+		proto = "tcp"
+	}
+	netns := s.NetNS
+	if netns == 0 {
+		netns = procmon.OwnNetNS()
+	}
+
+	proc := procmon.NewProcess(0, filepath.Base(s.ProcessPath))
+	proc.Path = s.ProcessPath
+	proc.UID = s.UserID
+	proc.AppID = s.AppID
+	proc.Cgroup = s.Cgroup
+	proc.SystemdUnit = s.SystemdUnit
+	if len(s.ProcessArgs) > 0 {
+		proc.Args = s.ProcessArgs
+	}
+	if len(s.ExecArgs) > 0 {
+		proc.ExecArgs = s.ExecArgs
+	}
+	for k, v := range s.ProcessEnv {
+		proc.Env[k] = v
+	}
+	if s.ProcessAge != "" {
+		age, err := time.ParseDuration(s.ProcessAge)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid process age: %s", s.ProcessAge)
+		}
+		proc.StartTime = time.Now().Add(-age)
+	}
+
+	return &conman.Connection{
+		Protocol:     proto,
+		SrcIP:        srcIP,
+		SrcPort:      s.SrcPort,
+		DstIP:        dstIP,
+		DstPort:      s.DstPort,
+		DstHost:      s.DstHost,
+		DstHostChain: s.DstHostChain,
+		SNI:          s.SNI,
+		HTTPHost:     s.HTTPHost,
+		Entry: &netstat.Entry{
+			Proto:   proto,
+			SrcIP:   srcIP,
+			SrcPort: s.SrcPort,
+			DstIP:   dstIP,
+			DstPort: s.DstPort,
+			UserId:  s.UserID,
+		},
+		Process:  proc,
+		NetNS:    netns,
+		Tampered: s.Tampered,
+		Pkt:      &netfilter.Packet{UID: uint32(s.UserID)},
+	}, nil
+}
+
+// Simulate returns the rule that would be applied to a connection, and its
+// verdict. The rate limits of the rules are not consumed.
+func (l *Loader) Simulate(s *Simulation) (*SimulationResult, error) {
+	con, err := s.Connection()
+	if err != nil {
+		return nil, err
+	}
+	match, _ := l.findFirstMatch(con)
+	res := &SimulationResult{
+		Chain: con.Rules,
+		Tags:  con.Tags,
+	}
+	if match != nil {
+		res.Matched = true
+		res.Rule = match
+		res.Action = match.GetAction()
+	}
+	return res, nil
+}
+
+// SimulateDestination returns the rule that would be applied to a connection
+// whose process is unknown, among the rules that only depend on its
+// destination (domains and IPs), excluding the fallback rules.
+func (l *Loader) SimulateDestination(s *Simulation) (*SimulationResult, error) {
+	con, err := s.Connection()
+	if err != nil {
+		return nil, err
+	}
+	match, _ := l.findFirstMatchOf(con, func(r *Rule) bool {
+		return !r.Fallback && r.Operator.DestinationOnly()
+	})
+	res := &SimulationResult{
+		Chain: con.Rules,
+		Tags:  con.Tags,
+	}
+	if match != nil {
+		res.Matched = true
+		res.Rule = match
+		res.Action = match.GetAction()
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package rule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+)
+
+// Rules can match the connections of the processes only during the first
+// seconds after the processes start, to allow the applications that check
+// the license or the updates when they start, but should be offline
+// otherwise:
+//
+// "startup_window": "30s"
+//
+// Once the window has elapsed, the rule doesn't match the connections of the
+// process, and the next rules are evaluated. If the start time of the process
+// is unknown, the rule doesn't match.
+
+// compileStartupWindow parses the startup window of the rule, if any.
+func (r *Rule) compileStartupWindow() error {
+	r.startupWindow = 0
+	if r.StartupWindow == "" {
+		return nil
+	}
+	window, err := time.ParseDuration(r.StartupWindow)
+	if err != nil {
+		return err
+	}
+	if window <= 0 {
+		return fmt.Errorf("the startup window must be positive: %s", r.StartupWindow)
+	}
+	r.startupWindow = window
+	return nil
+}
+
+// inStartupWindow checks if the process of the connection has been started
+// within the startup window of the rule.
+func (r *Rule) inStartupWindow(con *conman.Connection) bool {
+	if r.startupWindow == 0 {
+		return true
+	}
+	if con.Process == nil || con.Process.StartTime.IsZero() {
+		return false
+	}
+	return time.Since(con.Process.StartTime) <= r.startupWindow
+}
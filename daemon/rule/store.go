@@ -0,0 +1,139 @@
+package rule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/sqlite"
+)
+
+// The rules can be stored in a SQLite database instead of in a directory with
+// a JSON file per rule, which is faster to load with thousands of rules, and
+// the changes are saved in transactions.
+// The rules are saved in the same JSON format as the files, so they can be
+// imported from and exported to a directory of rules.
+// The database is not monitored for changes, the rules are modified from the
+// GUI.
+
+const rulesSchema = `CREATE TABLE IF NOT EXISTS rules (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL,
+	updated INTEGER NOT NULL
+)`
+
+// LoadDB loads the rules from a SQLite database, which is created if it
+// doesn't exist. If the database is empty, the rules of importPath are
+// imported, if any.
+func (l *Loader) LoadDB(path, importPath string) error {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := db.Exec(rulesSchema); err != nil {
+		db.Close()
+		return fmt.Errorf("Error creating the rules database %s: %s", path, err)
+	}
+	l.db = db
+
+	rows, err := db.Query("SELECT name, data FROM rules ORDER BY name")
+	if err != nil {
+		return fmt.Errorf("Error reading the rules database %s: %s", path, err)
+	}
+	if len(rows) == 0 && importPath != "" && core.Exists(importPath) {
+		log.Info("Rules database empty, importing the rules of %s", importPath)
+		return l.Import(importPath)
+	}
+
+	l.Lock()
+	for _, row := range rows {
+		if err := l.loadRuleData([]byte(row[1]), fmt.Sprint(path, ":", row[0])); err != nil {
+			log.Warning("%s", err)
+		}
+	}
+	log.Info("Loaded %d rules from %s", len(l.rules), path)
+	l.Unlock()
+	l.logLintFindings()
+
+	return nil
+}
+
+// Import loads the rules of a directory of JSON files, and saves them in the
+// database in a single transaction.
+func (l *Loader) Import(path string) error {
+	if l.db == nil {
+		return fmt.Errorf("Rules database not opened")
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return fmt.Errorf("Error globbing '%s': %s", path, err)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.db.Tx(func(exec func(string, ...string) error) error {
+		for _, fileName := range matches {
+			raw, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				return fmt.Errorf("Error while reading %s: %s", fileName, err)
+			}
+			var r struct{ Name string }
+			if err := json.Unmarshal(raw, &r); err != nil {
+				log.Warning("Error parsing rule from %s: %s", fileName, err)
+				continue
+			}
+			if err := l.loadRuleData(raw, fileName); err != nil {
+				log.Warning("%s", err)
+				continue
+			}
+			if err := exec("INSERT OR REPLACE INTO rules VALUES (?, ?, ?)", r.Name, string(raw), fmt.Sprint(time.Now().Unix())); err != nil {
+				return fmt.Errorf("Error importing rule %s: %s", fileName, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Export saves the rules of the database to a directory, in JSON format.
+func (l *Loader) Export(path string) error {
+	if l.db == nil {
+		return fmt.Errorf("Rules database not opened")
+	}
+	rows, err := l.db.Query("SELECT name, data FROM rules ORDER BY name")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		fileName := filepath.Join(path, fmt.Sprintf("%s.json", row[0]))
+		if err := ioutil.WriteFile(fileName, []byte(row[1]), 0600); err != nil {
+			return fmt.Errorf("Error exporting rule %s to %s: %s", row[0], fileName, err)
+		}
+	}
+	log.Info("Exported %d rules to %s", len(rows), path)
+	return nil
+}
+
+func (l *Loader) saveRuleToDB(name string, raw []byte) error {
+	if err := l.db.Exec("INSERT OR REPLACE INTO rules VALUES (?, ?, ?)", name, string(raw), fmt.Sprint(time.Now().Unix())); err != nil {
+		return fmt.Errorf("Error while saving rule %s to the database: %s", name, err)
+	}
+	return nil
+}
+
+func (l *Loader) deleteRuleFromDB(name string) error {
+	return l.db.Exec("DELETE FROM rules WHERE name = ?", name)
+}
+
+// isSaved checks if a rule is saved on disk or in the database.
+func (l *Loader) isSaved(name string) bool {
+	if l.db != nil {
+		rows, err := l.db.Query("SELECT name FROM rules WHERE name = ?", name)
+		return err == nil && len(rows) > 0
+	}
+	return l.path != "" && core.Exists(filepath.Join(l.path, fmt.Sprintf("%s.json", name)))
+}
@@ -0,0 +1,64 @@
+//go:build sqlite
+// +build sqlite
+
+package rule
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRuleLoaderDB(t *testing.T) {
+	t.Parallel()
+	t.Log("Test rules loader: database")
+
+	dbPath := tmpDir + "/rules.db"
+	l, err := NewLoader(false)
+	if err != nil {
+		t.Fail()
+	}
+	if err = l.LoadDB(dbPath, "testdata/"); err != nil {
+		t.Error("Error loading the rules database: ", err)
+	}
+	// the rules of testdata are imported into the empty database.
+	testNumRules(t, l, 2)
+
+	var list []Operator
+	dummyOper, _ := NewOperator(Simple, false, OpTrue, "", list)
+	dummyOper.Compile()
+	dbRule := Create("002-db-rule", "rule description", true, false, false, Allow, Always, dummyOper)
+	if err = l.Add(dbRule, true); err != nil {
+		t.Error("Error saving rule to the database: ", err)
+	}
+	if !l.isSaved(dbRule.Name) {
+		t.Error("Rule not saved to the database")
+	}
+
+	t.Run("Reload database", func(t *testing.T) {
+		l2, _ := NewLoader(false)
+		if err := l2.LoadDB(dbPath, ""); err != nil {
+			t.Error("Error reloading the rules database: ", err)
+		}
+		testNumRules(t, l2, 3)
+	})
+	t.Run("Delete rule", func(t *testing.T) {
+		if err := l.Delete(dbRule.Name); err != nil {
+			t.Error("Error deleting rule from the database: ", err)
+		}
+		if l.isSaved(dbRule.Name) {
+			t.Error("Rule not deleted from the database")
+		}
+	})
+	t.Run("Export", func(t *testing.T) {
+		exportDir := tmpDir + "/export"
+		os.Mkdir(exportDir, 0777)
+		if err := l.Export(exportDir); err != nil {
+			t.Error("Error exporting rules: ", err)
+		}
+		l3, _ := NewLoader(false)
+		if err := l3.Load(exportDir); err != nil {
+			t.Error("Error loading exported rules: ", err)
+		}
+		testNumRules(t, l3, 2)
+	})
+}
@@ -0,0 +1,40 @@
+//go:build !sqlite
+// +build !sqlite
+
+package sqlite
+
+import (
+	"errors"
+)
+
+// ErrNotSupported is returned when the daemon has been built without SQLite
+// support. Build it with -tags sqlite to use the databases.
+var ErrNotSupported = errors.New("sqlite: not supported, opensnitchd must be built with -tags sqlite")
+
+// DB is not available without SQLite support.
+type DB struct{}
+
+// Open always fails without SQLite support.
+func Open(path string) (*DB, error) {
+	return nil, ErrNotSupported
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return nil
+}
+
+// Exec executes a statement that doesn't return rows.
+func (d *DB) Exec(query string, args ...string) error {
+	return ErrNotSupported
+}
+
+// Query executes a statement, and returns the columns of the rows as text.
+func (d *DB) Query(query string, args ...string) ([][]string, error) {
+	return nil, ErrNotSupported
+}
+
+// Tx executes the statements added by fn in a transaction.
+func (d *DB) Tx(fn func(exec func(query string, args ...string) error) error) error {
+	return ErrNotSupported
+}
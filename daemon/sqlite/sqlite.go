@@ -0,0 +1,167 @@
+//go:build sqlite
+// +build sqlite
+
+package sqlite
+
+/*
+#cgo pkg-config: sqlite3
+
+#include <stdlib.h>
+#include <sqlite3.h>
+
+// SQLITE_TRANSIENT can't be used from Go, because it's a function pointer cast.
+static int bind_text(sqlite3_stmt *stmt, int idx, const char *text, int len) {
+	return sqlite3_bind_text(stmt, idx, text, len, SQLITE_TRANSIENT);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// DB is a minimal binding of libsqlite3, to store data of the daemon (rules,
+// etc) in an embedded database.
+// It's only built with -tags sqlite, otherwise the databases are not available
+// (see nosqlite.go).
+// All the values are bound and read as text.
+type DB struct {
+	sync.Mutex
+	db *C.sqlite3
+}
+
+// Open opens or creates a database.
+func Open(path string) (*DB, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	var db *C.sqlite3
+	flags := C.SQLITE_OPEN_READWRITE | C.SQLITE_OPEN_CREATE | C.SQLITE_OPEN_FULLMUTEX
+	if rc := C.sqlite3_open_v2(cpath, &db, C.int(flags), nil); rc != C.SQLITE_OK {
+		err := fmt.Errorf("Error opening database %s: %s", path, C.GoString(C.sqlite3_errstr(rc)))
+		C.sqlite3_close_v2(db)
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.db == nil {
+		return nil
+	}
+	if rc := C.sqlite3_close_v2(d.db); rc != C.SQLITE_OK {
+		return d.error(rc)
+	}
+	d.db = nil
+	return nil
+}
+
+func (d *DB) error(rc C.int) error {
+	return fmt.Errorf("sqlite error (%d): %s", int(rc), C.GoString(C.sqlite3_errmsg(d.db)))
+}
+
+// prepare compiles a statement, and binds the arguments to its parameters.
+func (d *DB) prepare(query string, args []string) (*C.sqlite3_stmt, error) {
+	if d.db == nil {
+		return nil, fmt.Errorf("sqlite: database closed")
+	}
+	cquery := C.CString(query)
+	defer C.free(unsafe.Pointer(cquery))
+
+	var stmt *C.sqlite3_stmt
+	if rc := C.sqlite3_prepare_v2(d.db, cquery, -1, &stmt, nil); rc != C.SQLITE_OK {
+		return nil, d.error(rc)
+	}
+	for i, arg := range args {
+		carg := C.CString(arg)
+		rc := C.bind_text(stmt, C.int(i+1), carg, C.int(len(arg)))
+		C.free(unsafe.Pointer(carg))
+		if rc != C.SQLITE_OK {
+			C.sqlite3_finalize(stmt)
+			return nil, d.error(rc)
+		}
+	}
+	return stmt, nil
+}
+
+// Exec executes a statement that doesn't return rows.
+func (d *DB) Exec(query string, args ...string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.exec(query, args)
+}
+
+func (d *DB) exec(query string, args []string) error {
+	stmt, err := d.prepare(query, args)
+	if err != nil {
+		return err
+	}
+	defer C.sqlite3_finalize(stmt)
+
+	for {
+		rc := C.sqlite3_step(stmt)
+		if rc == C.SQLITE_DONE {
+			return nil
+		}
+		if rc != C.SQLITE_ROW {
+			return d.error(rc)
+		}
+	}
+}
+
+// Query executes a statement, and returns the columns of the rows as text.
+func (d *DB) Query(query string, args ...string) ([][]string, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	stmt, err := d.prepare(query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer C.sqlite3_finalize(stmt)
+
+	rows := [][]string{}
+	for {
+		rc := C.sqlite3_step(stmt)
+		if rc == C.SQLITE_DONE {
+			return rows, nil
+		}
+		if rc != C.SQLITE_ROW {
+			return nil, d.error(rc)
+		}
+		cols := int(C.sqlite3_column_count(stmt))
+		row := make([]string, cols)
+		for i := 0; i < cols; i++ {
+			text := C.sqlite3_column_text(stmt, C.int(i))
+			size := C.sqlite3_column_bytes(stmt, C.int(i))
+			row[i] = C.GoStringN((*C.char)(unsafe.Pointer(text)), size)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Tx executes the statements added by fn in a transaction, which is rolled
+// back if any of them fails.
+func (d *DB) Tx(fn func(exec func(query string, args ...string) error) error) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := d.exec("BEGIN", nil); err != nil {
+		return err
+	}
+	err := fn(func(query string, args ...string) error {
+		return d.exec(query, args)
+	})
+	if err != nil {
+		d.exec("ROLLBACK", nil)
+		return err
+	}
+	return d.exec("COMMIT", nil)
+}
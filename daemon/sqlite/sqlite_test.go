@@ -0,0 +1,66 @@
+//go:build sqlite
+// +build sqlite
+
+package sqlite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir + "/test.db")
+	if err != nil {
+		t.Fatal("Open() error:", err)
+	}
+	defer db.Close()
+
+	if err := db.Exec("CREATE TABLE test (name TEXT PRIMARY KEY, data TEXT)"); err != nil {
+		t.Error("Exec() error:", err)
+	}
+	if err := db.Exec("INSERT INTO test VALUES (?, ?)", "rule-1", `{"name": "rule-1"}`); err != nil {
+		t.Error("Exec() insert error:", err)
+	}
+	rows, err := db.Query("SELECT name, data FROM test WHERE name = ?", "rule-1")
+	if err != nil || len(rows) != 1 || rows[0][0] != "rule-1" || rows[0][1] != `{"name": "rule-1"}` {
+		t.Error("Query() error:", err, rows)
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		err := db.Tx(func(exec func(string, ...string) error) error {
+			for i := 2; i < 5; i++ {
+				if err := exec("INSERT INTO test VALUES (?, ?)", fmt.Sprint("rule-", i), ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error("Tx() error:", err)
+		}
+		// the transaction fails because the name is not unique, and it's rolled back.
+		err = db.Tx(func(exec func(string, ...string) error) error {
+			if err := exec("INSERT INTO test VALUES (?, ?)", "rule-5", ""); err != nil {
+				return err
+			}
+			return exec("INSERT INTO test VALUES (?, ?)", "rule-1", "")
+		})
+		if err == nil {
+			t.Error("Tx() with duplicated rows didn't fail")
+		}
+		if rows, _ := db.Query("SELECT name FROM test"); len(rows) != 4 {
+			t.Error("Tx() not rolled back:", rows)
+		}
+	})
+	if err := db.Exec("INSERT INTO invalid VALUES (?)", "x"); err == nil {
+		t.Error("Exec() on an invalid table didn't fail")
+	}
+}
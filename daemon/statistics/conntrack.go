@@ -0,0 +1,151 @@
+package statistics
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/netlink"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// The connections allowed are tracked until conntrack notifies that they're
+// closed, with the bytes and packets transferred, to know the data volume and
+// the duration of each connection, besides its start.
+
+var conntrackProtos = map[uint8]string{
+	syscall.IPPROTO_ICMP:    "icmp",
+	syscall.IPPROTO_TCP:     "tcp",
+	syscall.IPPROTO_UDP:     "udp",
+	syscall.IPPROTO_ICMPV6:  "icmp",
+	syscall.IPPROTO_SCTP:    "sctp",
+	syscall.IPPROTO_UDPLITE: "udplite",
+}
+
+// closedStats holds the processes of the connections open, and the connections
+// closed since the last time the stats were sent.
+type closedStats struct {
+	enabled bool
+	owners  map[string]*flowOwner
+	closed  []*protocol.ConnectionEnd
+	// stops listening to the conntrack events.
+	cancel context.CancelFunc
+	// values of the accounting of conntrack before enabling it.
+	sysctls map[string][]byte
+}
+
+func newClosedStats() closedStats {
+	return closedStats{
+		owners: make(map[string]*flowOwner),
+	}
+}
+
+// add saves the process of a connection allowed, until it's closed.
+func (c *closedStats) add(con *conman.Connection) {
+	if !c.enabled || con.Process == nil {
+		return
+	}
+	if len(c.owners) >= maxFlows {
+		deleteOldestFlow(c.owners)
+	}
+	c.owners[flowKey(con.Protocol, con.SrcIP, con.SrcPort, con.DstIP, con.DstPort)] = &flowOwner{
+		pid:  con.Process.ID,
+		path: con.Process.Path,
+		uid:  uint32(con.Entry.UserId),
+		seen: time.Now(),
+	}
+}
+
+// listenConntrack enables the accounting of conntrack, and adds the
+// connections closed to the stats, until stopConntrack is called.
+func (s *Statistics) listenConntrack() {
+	s.stopConntrack()
+
+	sysctls := netlink.EnableConntrackAccounting()
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := netlink.ListenConntrackEvents(ctx)
+	if err != nil {
+		cancel()
+		netlink.RestoreConntrackAccounting(sysctls)
+		log.Warning("Stats, unable to listen to the conntrack events: %s", err)
+		return
+	}
+	s.Lock()
+	s.closed.enabled = true
+	s.closed.cancel = cancel
+	s.closed.sysctls = sysctls
+	s.Unlock()
+	log.Info("Stats, conntrack accounting enabled")
+
+	go func() {
+		for ev := range events {
+			s.onConnectionClosed(ev)
+		}
+	}()
+}
+
+// stopConntrack stops listening to the conntrack events, and restores the
+// accounting of conntrack.
+func (s *Statistics) stopConntrack() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed.cancel == nil {
+		return
+	}
+	s.closed.cancel()
+	netlink.RestoreConntrackAccounting(s.closed.sysctls)
+	s.closed.enabled = false
+	s.closed.cancel = nil
+	s.closed.sysctls = nil
+	s.closed.owners = make(map[string]*flowOwner)
+}
+
+func (s *Statistics) onConnectionClosed(ev netlink.ConntrackEvent) {
+	proto, found := conntrackProtos[ev.Orig.Protocol]
+	if !found {
+		return
+	}
+	if ev.Orig.SrcIP.To4() == nil {
+		proto += "6"
+	}
+	key := flowKey(proto, ev.Orig.SrcIP, uint(ev.Orig.SrcPort), ev.Orig.DstIP, uint(ev.Orig.DstPort))
+
+	s.Lock()
+	defer s.Unlock()
+
+	owner, found := s.closed.owners[key]
+	if !found {
+		return
+	}
+	delete(s.closed.owners, key)
+
+	start, end := ev.Start, ev.Stop
+	if start.IsZero() {
+		start = owner.seen
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if len(s.closed.closed) >= s.maxEvents {
+		s.closed.closed = s.closed.closed[1:]
+	}
+	s.closed.closed = append(s.closed.closed, &protocol.ConnectionEnd{
+		Protocol:    proto,
+		SrcIp:       ev.Orig.SrcIP.String(),
+		SrcPort:     uint32(ev.Orig.SrcPort),
+		DstIp:       ev.Orig.DstIP.String(),
+		DstPort:     uint32(ev.Orig.DstPort),
+		ProcessId:   uint64(owner.pid),
+		ProcessPath: owner.path,
+		UserId:      owner.uid,
+		BytesSent:   ev.Orig.Bytes,
+		BytesRecv:   ev.Reply.Bytes,
+		PacketsSent: ev.Orig.Packets,
+		PacketsRecv: ev.Reply.Packets,
+		Start:       start.UnixNano(),
+		End:         end.UnixNano(),
+	})
+}
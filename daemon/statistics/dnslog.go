@@ -0,0 +1,334 @@
+package statistics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/sqlite"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// The DNS responses observed can be saved in a SQLite database (a passive DNS
+// log), to know which domains have been resolved, by which process, and to
+// which IPs, even if no connection has been established to them.
+// The process that resolved a domain is known if its query has been
+// intercepted (outbound connections to port 53).
+
+const dnsLogSchema = `CREATE TABLE IF NOT EXISTS dns_log (
+	time INTEGER NOT NULL,
+	domain TEXT NOT NULL,
+	type TEXT NOT NULL,
+	resolver TEXT NOT NULL,
+	answers TEXT NOT NULL,
+	pid INTEGER NOT NULL,
+	process TEXT NOT NULL
+)`
+
+const dnsLogIndex = `CREATE INDEX IF NOT EXISTS dns_log_time ON dns_log (time)`
+
+var (
+	// the records are saved in batches, every dnsLogFlushInterval, or when
+	// the buffer is full.
+	dnsLogFlushInterval = time.Second
+	dnsLogBufferSize    = 512
+	// how often the records older than the retention are deleted.
+	dnsLogPurgeInterval = 10 * time.Minute
+	// time to wait for the response of a query, to know the process that
+	// resolved it.
+	dnsQueryTimeout = 10 * time.Second
+	// max number of records returned
+	dnsLogMaxRecords = 10000
+	// DefaultDNSLogRetention is the time the records are kept by default.
+	DefaultDNSLogRetention = 7 * 24 * time.Hour
+)
+
+// DNSLogRecord represents a DNS response observed.
+type DNSLogRecord struct {
+	Time     time.Time `json:"time"`
+	Domain   string    `json:"domain"`
+	Type     string    `json:"type"`
+	Resolver string    `json:"resolver"`
+	Answers  []string  `json:"answers,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	Process  string    `json:"process,omitempty"`
+}
+
+// DNSLogFilter selects the records of the DNS log.
+type DNSLogFilter struct {
+	// records newer than this time, all by default.
+	Since time.Time `json:"since,omitempty"`
+	// records whose domain contains this text.
+	Domain string `json:"domain,omitempty"`
+	// max number of records, the newest ones.
+	Limit int `json:"limit,omitempty"`
+}
+
+type dnsQueryKey struct {
+	id   uint16
+	port uint
+}
+
+type dnsQueryOwner struct {
+	pid     int
+	process string
+	time    time.Time
+}
+
+type dnsLog struct {
+	sync.Mutex
+	db        *sqlite.DB
+	retention time.Duration
+	records   chan *DNSLogRecord
+	// processes of the queries waiting for their responses.
+	queries map[dnsQueryKey]dnsQueryOwner
+}
+
+// openDNSLog opens or creates the database of the DNS log, and starts saving
+// the records.
+func (s *Statistics) openDNSLog(path string, retention time.Duration) error {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{dnsLogSchema, dnsLogIndex} {
+		if err := db.Exec(stmt); err != nil {
+			db.Close()
+			return fmt.Errorf("Error creating the DNS log database %s: %s", path, err)
+		}
+	}
+	dl := &dnsLog{
+		db:        db,
+		retention: retention,
+		records:   make(chan *DNSLogRecord, dnsLogBufferSize),
+		queries:   make(map[dnsQueryKey]dnsQueryOwner),
+	}
+	go dl.worker()
+
+	s.Lock()
+	s.dnsLog = dl
+	s.Unlock()
+	log.Info("DNS log enabled: %s, retention: %s", path, retention)
+	return nil
+}
+
+// OnDNSQuery saves the process of an outbound DNS query, to log it with
+// the response.
+func (s *Statistics) OnDNSQuery(con *conman.Connection) {
+	dl := s.getDNSLog()
+	if dl == nil || con.Pkt == nil || con.Pkt.Packet == nil || con.Process == nil {
+		return
+	}
+	msg, ok := con.Pkt.Packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || msg == nil || msg.QR {
+		return
+	}
+	dl.Lock()
+	defer dl.Unlock()
+	dl.queries[dnsQueryKey{id: msg.ID, port: con.SrcPort}] = dnsQueryOwner{
+		pid:     con.Process.ID,
+		process: con.Process.Path,
+		time:    time.Now(),
+	}
+}
+
+// LogDNSResponse adds the questions of a DNS response to the DNS log, and
+// sends them to the subscribers.
+func (s *Statistics) LogDNSResponse(packet gopacket.Packet) {
+	dl := s.getDNSLog()
+	subscribed := s.hasSubscribers()
+	if dl == nil && !subscribed {
+		return
+	}
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp == nil {
+		return
+	}
+	msg, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || msg == nil || !msg.QR {
+		return
+	}
+	resolver := ""
+	if nl := packet.NetworkLayer(); nl != nil {
+		resolver = nl.NetworkFlow().Src().String()
+	}
+
+	var owner dnsQueryOwner
+	if dl != nil {
+		dl.Lock()
+		key := dnsQueryKey{id: msg.ID, port: uint(udp.DstPort)}
+		owner = dl.queries[key]
+		delete(dl.queries, key)
+		dl.Unlock()
+	}
+
+	var answers []string
+	for _, ans := range msg.Answers {
+		if ans.IP != nil {
+			answers = append(answers, ans.IP.String())
+		} else if ans.CNAME != nil {
+			answers = append(answers, string(ans.CNAME))
+		}
+	}
+	now := time.Now()
+	for _, q := range msg.Questions {
+		record := &DNSLogRecord{
+			Time:     now,
+			Domain:   string(q.Name),
+			Type:     q.Type.String(),
+			Resolver: resolver,
+			Answers:  answers,
+			PID:      owner.pid,
+			Process:  owner.process,
+		}
+		if subscribed {
+			s.publishDNS(record)
+		}
+		if dl == nil {
+			continue
+		}
+		select {
+		case dl.records <- record:
+		default:
+			log.Debug("DNS log buffer full, record discarded: %s", record.Domain)
+		}
+	}
+}
+
+// GetDNSLog returns the records of the DNS log, the newest first.
+func (s *Statistics) GetDNSLog(filter DNSLogFilter) ([]*DNSLogRecord, error) {
+	dl := s.getDNSLog()
+	if dl == nil {
+		return nil, fmt.Errorf("The DNS log is not enabled")
+	}
+	if filter.Limit <= 0 || filter.Limit > dnsLogMaxRecords {
+		filter.Limit = dnsLogMaxRecords
+	}
+	since := "0"
+	if !filter.Since.IsZero() {
+		since = strconv.FormatInt(filter.Since.UnixNano(), 10)
+	}
+	rows, err := dl.db.Query(
+		"SELECT time, domain, type, resolver, answers, pid, process FROM dns_log WHERE time >= ? AND instr(domain, ?) > 0 ORDER BY time DESC LIMIT ?",
+		since, filter.Domain, strconv.Itoa(filter.Limit))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*DNSLogRecord, 0, len(rows))
+	for _, row := range rows {
+		nsec, _ := strconv.ParseInt(row[0], 10, 64)
+		pid, _ := strconv.Atoi(row[5])
+		record := &DNSLogRecord{
+			Time:     time.Unix(0, nsec),
+			Domain:   row[1],
+			Type:     row[2],
+			Resolver: row[3],
+			PID:      pid,
+			Process:  row[6],
+		}
+		if row[4] != "" {
+			record.Answers = strings.Split(row[4], " ")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DNSLogCSV returns the records of the DNS log in CSV format, with a header.
+func DNSLogCSV(records []*DNSLogRecord) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "domain", "type", "resolver", "answers", "pid", "process"})
+	for _, r := range records {
+		pid := ""
+		if r.PID != 0 {
+			pid = strconv.Itoa(r.PID)
+		}
+		w.Write([]string{
+			r.Time.Format(time.RFC3339Nano), r.Domain, r.Type, r.Resolver,
+			strings.Join(r.Answers, " "), pid, r.Process,
+		})
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+func (s *Statistics) getDNSLog() *dnsLog {
+	s.RLock()
+	defer s.RUnlock()
+	return s.dnsLog
+}
+
+func (dl *dnsLog) worker() {
+	flush := time.NewTicker(dnsLogFlushInterval)
+	purge := time.NewTicker(dnsLogPurgeInterval)
+	defer flush.Stop()
+	defer purge.Stop()
+
+	dl.purge()
+	batch := make([]*DNSLogRecord, 0, dnsLogBufferSize)
+	for {
+		select {
+		case record := <-dl.records:
+			batch = append(batch, record)
+			if len(batch) < dnsLogBufferSize {
+				continue
+			}
+		case <-flush.C:
+			dl.expireQueries()
+		case <-purge.C:
+			dl.purge()
+			continue
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := dl.save(batch); err != nil {
+			log.Warning("Error saving the DNS log: %s", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (dl *dnsLog) save(records []*DNSLogRecord) error {
+	return dl.db.Tx(func(exec func(string, ...string) error) error {
+		for _, r := range records {
+			err := exec("INSERT INTO dns_log (time, domain, type, resolver, answers, pid, process) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				strconv.FormatInt(r.Time.UnixNano(), 10), r.Domain, r.Type, r.Resolver,
+				strings.Join(r.Answers, " "), strconv.Itoa(r.PID), r.Process)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purge deletes the records older than the retention.
+func (dl *dnsLog) purge() {
+	if dl.retention <= 0 {
+		return
+	}
+	since := time.Now().Add(-dl.retention).UnixNano()
+	if err := dl.db.Exec("DELETE FROM dns_log WHERE time < ?", strconv.FormatInt(since, 10)); err != nil {
+		log.Warning("Error purging the DNS log: %s", err)
+	}
+}
+
+// expireQueries deletes the queries whose response has not been received.
+func (dl *dnsLog) expireQueries() {
+	dl.Lock()
+	defer dl.Unlock()
+	for key, owner := range dl.queries {
+		if time.Since(owner.time) > dnsQueryTimeout {
+			delete(dl.queries, key)
+		}
+	}
+}
@@ -12,6 +12,8 @@ type Event struct {
 	Time       time.Time
 	Connection *conman.Connection
 	Rule       *rule.Rule
+	// identical events coalesced into this one.
+	Coalesced uint64
 }
 
 func NewEvent(con *conman.Connection, match *rule.Rule) *Event {
@@ -28,5 +30,6 @@ func (e *Event) Serialize() *protocol.Event {
 		Connection: e.Connection.Serialize(),
 		Rule:       e.Rule.Serialize(),
 		Unixnano:   e.Time.UnixNano(),
+		Coalesced:  e.Coalesced,
 	}
 }
@@ -0,0 +1,208 @@
+package statistics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+	"github.com/evilsocket/opensnitch/daemon/sqlite"
+)
+
+// The connection events are kept in memory until they're sent to the GUI, so
+// the history is lost when the daemon or the GUI are restarted. Optionally,
+// they can be saved in a SQLite database, deleting the oldest ones when they
+// exceed the retention or the max number of events.
+// The events are saved with the format they're sent to the GUI.
+
+const eventsSchema = `CREATE TABLE IF NOT EXISTS events (
+	time INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	rule TEXT NOT NULL,
+	process TEXT NOT NULL,
+	dst_host TEXT NOT NULL,
+	data TEXT NOT NULL
+)`
+
+const eventsIndex = `CREATE INDEX IF NOT EXISTS events_time ON events (time)`
+
+var (
+	// the events are saved in batches, every eventsFlushInterval, or when
+	// the buffer is full.
+	eventsFlushInterval = time.Second
+	eventsBufferSize    = 1024
+	// how often the events exceeding the retention are deleted.
+	eventsPurgeInterval = 10 * time.Minute
+	// max number of events returned
+	eventsMaxResults = 10000
+	// DefaultEventsRetention is the time the events are kept by default.
+	DefaultEventsRetention = 30 * 24 * time.Hour
+	// DefaultEventsMaxRows is the number of events kept by default.
+	DefaultEventsMaxRows = 1000000
+)
+
+// EventsFilter selects the events of the database.
+type EventsFilter struct {
+	// events newer than this time, all by default.
+	Since time.Time `json:"since,omitempty"`
+	// events whose process path contains this text.
+	Process string `json:"process,omitempty"`
+	// events whose destination host contains this text.
+	DstHost string `json:"dst_host,omitempty"`
+	// max number of events, the newest ones.
+	Limit int `json:"limit,omitempty"`
+}
+
+type eventsDB struct {
+	db        *sqlite.DB
+	retention time.Duration
+	maxRows   int
+	events    chan *Event
+}
+
+// openEventsDB opens or creates the database of the events, and starts saving
+// them.
+func (s *Statistics) openEventsDB(path string, retention time.Duration, maxRows int) error {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{eventsSchema, eventsIndex} {
+		if err := db.Exec(stmt); err != nil {
+			db.Close()
+			return fmt.Errorf("Error creating the events database %s: %s", path, err)
+		}
+	}
+	edb := &eventsDB{
+		db:        db,
+		retention: retention,
+		maxRows:   maxRows,
+		events:    make(chan *Event, eventsBufferSize),
+	}
+	go edb.worker()
+
+	s.Lock()
+	s.eventsDB = edb
+	s.Unlock()
+	log.Info("Events database enabled: %s, retention: %s, max events: %d", path, retention, maxRows)
+	return nil
+}
+
+// saveEvent queues a connection event to save it in the database, if enabled.
+func (s *Statistics) saveEvent(con *conman.Connection, match *rule.Rule) {
+	edb := s.getEventsDB()
+	if edb == nil {
+		return
+	}
+	select {
+	case edb.events <- NewEvent(con, match):
+	default:
+		log.Debug("Events database buffer full, event discarded: %s", con.Process.Path)
+	}
+}
+
+// GetEvents returns the events of the database, the newest first, serialized
+// as they're sent to the GUI.
+func (s *Statistics) GetEvents(filter EventsFilter) ([]json.RawMessage, error) {
+	edb := s.getEventsDB()
+	if edb == nil {
+		return nil, fmt.Errorf("The events database is not enabled")
+	}
+	if filter.Limit <= 0 || filter.Limit > eventsMaxResults {
+		filter.Limit = eventsMaxResults
+	}
+	since := "0"
+	if !filter.Since.IsZero() {
+		since = strconv.FormatInt(filter.Since.UnixNano(), 10)
+	}
+	rows, err := edb.db.Query(
+		"SELECT data FROM events WHERE time >= ? AND instr(process, ?) > 0 AND instr(dst_host, ?) > 0 ORDER BY time DESC LIMIT ?",
+		since, filter.Process, filter.DstHost, strconv.Itoa(filter.Limit))
+	if err != nil {
+		return nil, err
+	}
+	events := make([]json.RawMessage, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, json.RawMessage(row[0]))
+	}
+	return events, nil
+}
+
+func (s *Statistics) getEventsDB() *eventsDB {
+	s.RLock()
+	defer s.RUnlock()
+	return s.eventsDB
+}
+
+func (edb *eventsDB) worker() {
+	flush := time.NewTicker(eventsFlushInterval)
+	purge := time.NewTicker(eventsPurgeInterval)
+	defer flush.Stop()
+	defer purge.Stop()
+
+	edb.purge()
+	batch := make([]*Event, 0, eventsBufferSize)
+	for {
+		select {
+		case ev := <-edb.events:
+			batch = append(batch, ev)
+			if len(batch) < eventsBufferSize {
+				continue
+			}
+		case <-flush.C:
+		case <-purge.C:
+			edb.purge()
+			continue
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := edb.save(batch); err != nil {
+			log.Warning("Error saving the events: %s", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (edb *eventsDB) save(events []*Event) error {
+	return edb.db.Tx(func(exec func(string, ...string) error) error {
+		for _, ev := range events {
+			data, err := json.Marshal(ev.Serialize())
+			if err != nil {
+				log.Debug("Error serializing event: %s", err)
+				continue
+			}
+			action, ruleName := "", ""
+			if ev.Rule != nil {
+				action, ruleName = string(ev.Rule.Action), ev.Rule.Name
+			}
+			err = exec("INSERT INTO events (time, action, rule, process, dst_host, data) VALUES (?, ?, ?, ?, ?, ?)",
+				strconv.FormatInt(ev.Time.UnixNano(), 10), action, ruleName,
+				ev.Connection.Process.Path, ev.Connection.DstHost, string(data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purge deletes the events older than the retention, and the oldest ones
+// exceeding the max number of events.
+func (edb *eventsDB) purge() {
+	if edb.retention > 0 {
+		since := time.Now().Add(-edb.retention).UnixNano()
+		if err := edb.db.Exec("DELETE FROM events WHERE time < ?", strconv.FormatInt(since, 10)); err != nil {
+			log.Warning("Error purging the events: %s", err)
+		}
+	}
+	if edb.maxRows > 0 {
+		// the rowids are incremental, because the oldest events are deleted.
+		if err := edb.db.Exec("DELETE FROM events WHERE rowid <= (SELECT MAX(rowid) FROM events) - ?", strconv.Itoa(edb.maxRows)); err != nil {
+			log.Warning("Error purging the events: %s", err)
+		}
+	}
+}
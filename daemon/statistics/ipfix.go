@@ -0,0 +1,423 @@
+package statistics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/procmon/ebpf"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+)
+
+// The connections intercepted can be exported as flows to a collector, in the
+// IPFIX format (NetFlow v10, RFC 7011), to integrate them with the network
+// monitoring tools. Besides the standard fields, the process, user and rule of
+// each flow are exported as enterprise fields.
+// The bytes and packets of the flows are only available if their traffic is
+// accounted with eBPF.
+
+const (
+	ipfixVersion   = 10
+	ipfixHeaderLen = 16
+	ipfixDomainID  = 0
+	ipfixSetTplID  = 2
+	ipfixTplIPv4   = 256
+	ipfixTplIPv6   = 257
+	ipfixVarLength = 65535
+	// max size of a message, to not fragment the UDP datagrams.
+	ipfixMaxMessage = 1400
+	// enterprise number of the standard reverse fields (RFC 5103).
+	ipfixReversePEN = 29305
+
+	// values of the firewallEvent field.
+	ipfixFlowCreated = 1
+	ipfixFlowDenied  = 3
+	ipfixFlowUpdated = 5
+)
+
+var (
+	// DefaultFlowExportInterval is how often the flows are exported by default.
+	DefaultFlowExportInterval = time.Minute
+	// DefaultFlowEnterpriseID is the enterprise number of the process fields by
+	// default, the one reserved for documentation (RFC 5612).
+	DefaultFlowEnterpriseID uint32 = 32473
+	// the flows without traffic for this time are forgotten.
+	flowIdleTimeout = 2 * time.Minute
+)
+
+var protoNumbers = map[string]uint8{
+	"icmp":    1,
+	"tcp":     6,
+	"udp":     17,
+	"icmp6":   58,
+	"sctp":    132,
+	"udplite": 136,
+}
+
+// protoNumber returns the IP protocol number of a protocol name (tcp, tcp6, ...).
+func protoNumber(proto string) uint8 {
+	if n, found := protoNumbers[proto]; found {
+		return n
+	}
+	return protoNumbers[strings.TrimSuffix(proto, "6")]
+}
+
+// enterprise fields of the flows.
+const (
+	flowFieldProcessID = iota + 1
+	flowFieldUserID
+	flowFieldProcessName
+	flowFieldProcessPath
+	flowFieldRule
+)
+
+type ipfixField struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+}
+
+// flowRecord is a flow of a connection, with the traffic since the last export.
+type flowRecord struct {
+	proto            uint8
+	srcIP, dstIP     net.IP
+	srcPort, dstPort uint16
+	pid              int
+	uid              uint32
+	path             string
+	rule             string
+	denied           bool
+	exported         bool
+	start, end       time.Time
+
+	bytesSent, bytesRecv     uint64
+	packetsSent, packetsRecv uint64
+	// counters of the flow in the last read, to export only the new traffic.
+	last ebpf.FlowTraffic
+}
+
+type flowExporter struct {
+	sync.Mutex
+	conn         net.Conn
+	interval     time.Duration
+	enterpriseID uint32
+	flows        map[string]*flowRecord
+	// number of data records sent, included in the header of the messages.
+	sequence uint32
+}
+
+// openFlowExporter starts exporting the flows of the connections to a
+// collector.
+func (s *Statistics) openFlowExporter(collector string, interval time.Duration, enterpriseID uint32) error {
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return err
+	}
+	fe := &flowExporter{
+		conn:         conn,
+		interval:     interval,
+		enterpriseID: enterpriseID,
+		flows:        make(map[string]*flowRecord),
+	}
+	go fe.worker()
+
+	s.Lock()
+	s.flowExporter = fe
+	s.Unlock()
+	log.Info("Flows export enabled, collector: %s, interval: %s", collector, interval)
+	return nil
+}
+
+// addFlowRecord adds the flow of a connection to the ones to export, if
+// enabled.
+func (s *Statistics) addFlowRecord(con *conman.Connection, match *rule.Rule) {
+	s.RLock()
+	fe := s.flowExporter
+	s.RUnlock()
+	if fe != nil {
+		fe.add(con, match)
+	}
+}
+
+func (fe *flowExporter) add(con *conman.Connection, match *rule.Rule) {
+	now := time.Now()
+	key := flowKey(con.Protocol, con.SrcIP, con.SrcPort, con.DstIP, con.DstPort)
+
+	fe.Lock()
+	defer fe.Unlock()
+
+	f, found := fe.flows[key]
+	if !found {
+		if len(fe.flows) >= maxFlows {
+			log.Debug("Flows export, max flows reached, flow discarded: %s", key)
+			return
+		}
+		f = &flowRecord{
+			proto:   protoNumber(con.Protocol),
+			srcIP:   con.SrcIP,
+			dstIP:   con.DstIP,
+			srcPort: uint16(con.SrcPort),
+			dstPort: uint16(con.DstPort),
+			start:   now,
+		}
+		fe.flows[key] = f
+	}
+	f.end = now
+	f.exported = false
+	if con.Entry != nil {
+		f.uid = uint32(con.Entry.UserId)
+	}
+	if con.Process != nil {
+		f.pid, f.path = con.Process.ID, con.Process.Path
+	}
+	if match != nil {
+		f.rule = match.Name
+		// the rules with the quota exceeded deny the connections.
+		action := match.GetAction()
+		f.denied = action == rule.Deny || action == rule.Reject
+	}
+}
+
+func (fe *flowExporter) worker() {
+	t := time.NewTicker(fe.interval)
+	defer t.Stop()
+
+	for range t.C {
+		fe.export()
+	}
+}
+
+// update adds the traffic since the last read to the flows, and returns the
+// ones to export.
+func (fe *flowExporter) update(traffic []ebpf.FlowTraffic) []*flowRecord {
+	now := time.Now()
+	for _, t := range traffic {
+		f, found := fe.flows[flowKey(t.Proto, t.SrcIP, t.SrcPort, t.DstIP, t.DstPort)]
+		if !found {
+			continue
+		}
+		sent, recv := t.BytesSent, t.BytesRecv
+		pktSent, pktRecv := t.PacketsSent, t.PacketsRecv
+		// the counters start from 0 if the flow was evicted and added again.
+		if f.last.BytesSent <= sent && f.last.BytesRecv <= recv {
+			sent -= f.last.BytesSent
+			recv -= f.last.BytesRecv
+			pktSent -= f.last.PacketsSent
+			pktRecv -= f.last.PacketsRecv
+		}
+		f.last = t
+		if sent == 0 && recv == 0 {
+			continue
+		}
+		f.bytesSent += sent
+		f.bytesRecv += recv
+		f.packetsSent += pktSent
+		f.packetsRecv += pktRecv
+		f.end = now
+	}
+
+	var export []*flowRecord
+	for k, f := range fe.flows {
+		if !f.exported || f.bytesSent > 0 || f.bytesRecv > 0 {
+			export = append(export, f)
+			continue
+		}
+		if now.Sub(f.end) > flowIdleTimeout {
+			delete(fe.flows, k)
+		}
+	}
+	return export
+}
+
+// export sends the new flows, and the ones with new traffic, to the collector.
+func (fe *flowExporter) export() {
+	traffic := ebpf.GetTraffic()
+
+	fe.Lock()
+	defer fe.Unlock()
+
+	flows := fe.update(traffic)
+	if len(flows) == 0 {
+		return
+	}
+	records := map[uint16][][]byte{}
+	for _, f := range flows {
+		event := uint8(ipfixFlowUpdated)
+		if !f.exported && f.denied {
+			event = ipfixFlowDenied
+		} else if !f.exported {
+			event = ipfixFlowCreated
+		}
+		tpl := uint16(ipfixTplIPv4)
+		if f.srcIP.To4() == nil {
+			tpl = ipfixTplIPv6
+		}
+		records[tpl] = append(records[tpl], fe.encodeRecord(f, event))
+
+		f.exported = true
+		f.bytesSent, f.bytesRecv, f.packetsSent, f.packetsRecv = 0, 0, 0, 0
+	}
+
+	// the templates are sent on every export, because the collector may have
+	// been restarted.
+	msg := fe.newMessage()
+	fe.writeTemplates(msg)
+	for _, tpl := range []uint16{ipfixTplIPv4, ipfixTplIPv6} {
+		recs := records[tpl]
+		for len(recs) > 0 {
+			n, size := 0, 4
+			for n < len(recs) && msg.Len()+size+len(recs[n]) <= ipfixMaxMessage {
+				size += len(recs[n])
+				n++
+			}
+			// the message is full, or the record doesn't fit in any message.
+			if n == 0 && msg.Len() > ipfixHeaderLen {
+				fe.send(msg)
+				msg = fe.newMessage()
+				continue
+			} else if n == 0 {
+				n = 1
+			}
+			writeSet(msg, tpl, recs[:n])
+			fe.sequence += uint32(n)
+			recs = recs[n:]
+		}
+	}
+	if msg.Len() > ipfixHeaderLen {
+		fe.send(msg)
+	}
+}
+
+// newMessage returns a message with the header, whose length is set when
+// it's sent.
+func (fe *flowExporter) newMessage() *bytes.Buffer {
+	msg := &bytes.Buffer{}
+	writeValues(msg,
+		uint16(ipfixVersion),
+		uint16(0),
+		uint32(time.Now().Unix()),
+		fe.sequence,
+		uint32(ipfixDomainID),
+	)
+	return msg
+}
+
+func (fe *flowExporter) send(msg *bytes.Buffer) {
+	b := msg.Bytes()
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	if _, err := fe.conn.Write(b); err != nil {
+		log.Debug("Flows export, error sending the flows: %s", err)
+	}
+}
+
+func (fe *flowExporter) writeTemplates(msg *bytes.Buffer) {
+	set := &bytes.Buffer{}
+	for _, tpl := range []uint16{ipfixTplIPv4, ipfixTplIPv6} {
+		fields := fe.fields(tpl == ipfixTplIPv6)
+		binary.Write(set, binary.BigEndian, tpl)
+		binary.Write(set, binary.BigEndian, uint16(len(fields)))
+		for _, f := range fields {
+			if f.enterprise == 0 {
+				binary.Write(set, binary.BigEndian, []uint16{f.id, f.length})
+				continue
+			}
+			binary.Write(set, binary.BigEndian, []uint16{f.id | 0x8000, f.length})
+			binary.Write(set, binary.BigEndian, f.enterprise)
+		}
+	}
+	writeSet(msg, ipfixSetTplID, [][]byte{set.Bytes()})
+}
+
+func writeSet(msg *bytes.Buffer, id uint16, records [][]byte) {
+	length := 4
+	for _, r := range records {
+		length += len(r)
+	}
+	binary.Write(msg, binary.BigEndian, []uint16{id, uint16(length)})
+	for _, r := range records {
+		msg.Write(r)
+	}
+}
+
+// encodeRecord encodes the fields of a flow, in the order of the template.
+func (fe *flowExporter) encodeRecord(f *flowRecord, event uint8) []byte {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, uint64(f.start.UnixNano()/int64(time.Millisecond)))
+	binary.Write(b, binary.BigEndian, uint64(f.end.UnixNano()/int64(time.Millisecond)))
+	if ip := f.srcIP.To4(); ip != nil {
+		b.Write(ip)
+		b.Write(f.dstIP.To4())
+	} else {
+		b.Write(f.srcIP.To16())
+		b.Write(f.dstIP.To16())
+	}
+	writeValues(b,
+		f.srcPort,
+		f.dstPort,
+		f.proto,
+		f.bytesSent,
+		f.packetsSent,
+		f.bytesRecv,
+		f.packetsRecv,
+		event,
+		uint32(f.pid),
+		f.uid,
+	)
+	writeVarString(b, filepath.Base(f.path))
+	writeVarString(b, f.path)
+	writeVarString(b, f.rule)
+	return b.Bytes()
+}
+
+// writeValues encodes fixed size values in network byte order.
+func writeValues(b *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		binary.Write(b, binary.BigEndian, v)
+	}
+}
+
+// writeVarString encodes a variable length field (RFC 7011, section 7).
+func writeVarString(b *bytes.Buffer, s string) {
+	if len(s) > ipfixVarLength-1 {
+		s = s[:ipfixVarLength-1]
+	}
+	if len(s) < 255 {
+		b.WriteByte(byte(len(s)))
+	} else {
+		b.WriteByte(255)
+		binary.Write(b, binary.BigEndian, uint16(len(s)))
+	}
+	b.WriteString(s)
+}
+
+func (fe *flowExporter) fields(ipv6 bool) []ipfixField {
+	srcIP, dstIP, ipLen := uint16(8), uint16(12), uint16(4)
+	if ipv6 {
+		srcIP, dstIP, ipLen = 27, 28, 16
+	}
+	return []ipfixField{
+		{id: 152, length: 8}, // flowStartMilliseconds
+		{id: 153, length: 8}, // flowEndMilliseconds
+		{id: srcIP, length: ipLen},
+		{id: dstIP, length: ipLen},
+		{id: 7, length: 2},                              // sourceTransportPort
+		{id: 11, length: 2},                             // destinationTransportPort
+		{id: 4, length: 1},                              // protocolIdentifier
+		{id: 1, length: 8},                              // octetDeltaCount
+		{id: 2, length: 8},                              // packetDeltaCount
+		{id: 1, length: 8, enterprise: ipfixReversePEN}, // reverseOctetDeltaCount
+		{id: 2, length: 8, enterprise: ipfixReversePEN}, // reversePacketDeltaCount
+		{id: 233, length: 1},                            // firewallEvent
+		{id: flowFieldProcessID, length: 4, enterprise: fe.enterpriseID},
+		{id: flowFieldUserID, length: 4, enterprise: fe.enterpriseID},
+		{id: flowFieldProcessName, length: ipfixVarLength, enterprise: fe.enterpriseID},
+		{id: flowFieldProcessPath, length: ipfixVarLength, enterprise: fe.enterpriseID},
+		{id: flowFieldRule, length: ipfixVarLength, enterprise: fe.enterpriseID},
+	}
+}
@@ -0,0 +1,224 @@
+package statistics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/netstat"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+	"github.com/evilsocket/opensnitch/daemon/procmon/ebpf"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+)
+
+func testConnection(proto, dstIP string, srcPort uint) *conman.Connection {
+	src := "192.168.1.10"
+	if net.ParseIP(dstIP).To4() == nil {
+		src = "fd00::10"
+	}
+	return &conman.Connection{
+		Protocol: proto,
+		SrcIP:    net.ParseIP(src),
+		SrcPort:  srcPort,
+		DstIP:    net.ParseIP(dstIP),
+		DstPort:  443,
+		Entry:    &netstat.Entry{UserId: 1000},
+		Process:  &procmon.Process{ID: 1234, Path: "/usr/bin/curl"},
+	}
+}
+
+func testFlowExporter(t *testing.T) (*flowExporter, net.PacketConn) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", collector.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &flowExporter{
+		conn:         conn,
+		interval:     time.Minute,
+		enterpriseID: DefaultFlowEnterpriseID,
+		flows:        make(map[string]*flowRecord),
+	}, collector
+}
+
+func TestFlowExporterAdd(t *testing.T) {
+	fe, collector := testFlowExporter(t)
+	defer collector.Close()
+
+	allow := &rule.Rule{Name: "allow-curl", Action: rule.Allow}
+	fe.add(testConnection("tcp", "1.1.1.1", 40000), allow)
+	fe.add(testConnection("tcp", "1.1.1.1", 40000), allow)
+	fe.add(testConnection("tcp", "1.1.1.1", 40001), allow)
+	fe.add(testConnection("udp", "1.1.1.1", 40000), nil)
+	if len(fe.flows) != 3 {
+		t.Fatalf("got %d flows, want 3: %v", len(fe.flows), fe.flows)
+	}
+	f := fe.flows[flowKey("tcp", net.ParseIP("192.168.1.10"), 40000, net.ParseIP("1.1.1.1"), 443)]
+	if f == nil || f.rule != "allow-curl" || f.denied || f.pid != 1234 || f.uid != 1000 || f.proto != 6 {
+		t.Errorf("unexpected flow: %+v", f)
+	}
+
+	// the rules with the quota exceeded deny the connections.
+	quota := &rule.Quota{Limit: "1b", Period: "1h"}
+	if err := quota.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	quota.Add(10)
+	exceeded := &rule.Rule{Name: "allow-quota", Action: rule.Allow, Quota: quota}
+	fe.add(testConnection("tcp", "8.8.8.8", 40000), exceeded)
+	if f := fe.flows[flowKey("tcp", net.ParseIP("192.168.1.10"), 40000, net.ParseIP("8.8.8.8"), 443)]; f == nil || !f.denied {
+		t.Errorf("flow of a rule with the quota exceeded not denied: %+v", f)
+	}
+}
+
+func TestFlowExporterUpdate(t *testing.T) {
+	fe, collector := testFlowExporter(t)
+	defer collector.Close()
+
+	con := testConnection("tcp", "1.1.1.1", 40000)
+	fe.add(con, nil)
+	traffic := ebpf.FlowTraffic{
+		Proto: "tcp", SrcIP: con.SrcIP, SrcPort: con.SrcPort, DstIP: con.DstIP, DstPort: con.DstPort,
+		BytesSent: 100, BytesRecv: 1000, PacketsSent: 1, PacketsRecv: 2,
+	}
+	if flows := fe.update([]ebpf.FlowTraffic{traffic}); len(flows) != 1 || flows[0].bytesSent != 100 || flows[0].bytesRecv != 1000 {
+		t.Fatalf("unexpected flows: %+v", flows)
+	}
+	flow := fe.update(nil)[0]
+	flow.exported = true
+	flow.bytesSent, flow.bytesRecv, flow.packetsSent, flow.packetsRecv = 0, 0, 0, 0
+
+	// only the traffic since the last read is exported.
+	traffic.BytesSent, traffic.PacketsSent = 150, 2
+	flows := fe.update([]ebpf.FlowTraffic{traffic})
+	if len(flows) != 1 || flows[0].bytesSent != 50 || flows[0].packetsSent != 1 || flows[0].bytesRecv != 0 {
+		t.Errorf("unexpected traffic delta: %+v", flows)
+	}
+	flows[0].bytesSent, flows[0].packetsSent = 0, 0
+	// the flows without new traffic are not exported again.
+	if flows := fe.update([]ebpf.FlowTraffic{traffic}); len(flows) != 0 {
+		t.Errorf("flow without traffic exported: %+v", flows)
+	}
+}
+
+func TestFlowExporterMessage(t *testing.T) {
+	fe, collector := testFlowExporter(t)
+	defer collector.Close()
+
+	fe.add(testConnection("tcp", "1.1.1.1", 40000), &rule.Rule{Name: "deny-curl", Action: rule.Deny})
+	fe.add(testConnection("udp", "2001:4860:4860::8888", 40000), nil)
+	fe.export()
+
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 65535)
+	n, _, err := collector.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := buf[:n]
+
+	// header
+	if version := binary.BigEndian.Uint16(msg[0:2]); version != ipfixVersion {
+		t.Errorf("invalid version: %d", version)
+	}
+	if length := binary.BigEndian.Uint16(msg[2:4]); int(length) != n {
+		t.Errorf("invalid message length: %d, want %d", length, n)
+	}
+	if seq := binary.BigEndian.Uint32(msg[8:12]); seq != 0 {
+		t.Errorf("invalid sequence of the first message: %d", seq)
+	}
+
+	// sets: templates, IPv4 data, IPv6 data.
+	sets := map[uint16][]byte{}
+	order := []uint16{}
+	for b := msg[ipfixHeaderLen:]; len(b) > 0; {
+		if len(b) < 4 {
+			t.Fatalf("truncated set: %v", b)
+		}
+		id, length := binary.BigEndian.Uint16(b[0:2]), int(binary.BigEndian.Uint16(b[2:4]))
+		if length < 4 || length > len(b) {
+			t.Fatalf("invalid length of the set %d: %d", id, length)
+		}
+		sets[id] = b[4:length]
+		order = append(order, id)
+		b = b[length:]
+	}
+	if len(order) != 3 || order[0] != ipfixSetTplID || order[1] != ipfixTplIPv4 || order[2] != ipfixTplIPv6 {
+		t.Fatalf("unexpected sets: %v", order)
+	}
+
+	// templates: id, number of fields, and the fields.
+	tpl := sets[ipfixSetTplID]
+	for _, id := range []uint16{ipfixTplIPv4, ipfixTplIPv6} {
+		fields := fe.fields(id == ipfixTplIPv6)
+		if got := binary.BigEndian.Uint16(tpl[0:2]); got != id {
+			t.Fatalf("template id: %d, want %d", got, id)
+		}
+		if got := binary.BigEndian.Uint16(tpl[2:4]); int(got) != len(fields) {
+			t.Errorf("template %d fields: %d, want %d", id, got, len(fields))
+		}
+		tpl = tpl[4:]
+		for _, f := range fields {
+			fieldID := binary.BigEndian.Uint16(tpl[0:2])
+			if f.enterprise != 0 {
+				if fieldID != f.id|0x8000 || binary.BigEndian.Uint32(tpl[4:8]) != f.enterprise {
+					t.Errorf("invalid enterprise field %d of the template %d", f.id, id)
+				}
+				tpl = tpl[8:]
+				continue
+			}
+			if fieldID != f.id || binary.BigEndian.Uint16(tpl[2:4]) != f.length {
+				t.Errorf("invalid field %d of the template %d", f.id, id)
+			}
+			tpl = tpl[4:]
+		}
+	}
+	if len(tpl) != 0 {
+		t.Errorf("unexpected data after the templates: %v", tpl)
+	}
+
+	// IPv4 record: start, end, addresses, ports, protocol, counters, event.
+	rec := sets[ipfixTplIPv4]
+	if !bytes.Equal(rec[16:20], net.ParseIP("192.168.1.10").To4()) || !bytes.Equal(rec[20:24], net.ParseIP("1.1.1.1").To4()) {
+		t.Errorf("invalid IPv4 addresses: %v", rec[16:24])
+	}
+	if port := binary.BigEndian.Uint16(rec[26:28]); port != 443 {
+		t.Errorf("invalid destination port: %d", port)
+	}
+	if rec[28] != 6 {
+		t.Errorf("invalid protocol: %d", rec[28])
+	}
+	if event := rec[61]; event != ipfixFlowDenied {
+		t.Errorf("invalid firewall event: %d", event)
+	}
+	if pid := binary.BigEndian.Uint32(rec[62:66]); pid != 1234 {
+		t.Errorf("invalid pid: %d", pid)
+	}
+	// variable length fields: process name, path and rule.
+	vars := rec[70:]
+	for _, want := range []string{"curl", "/usr/bin/curl", "deny-curl"} {
+		if len(vars) == 0 || int(vars[0]) > len(vars)-1 || string(vars[1:1+vars[0]]) != want {
+			t.Fatalf("invalid variable length field, want %s: %v", want, vars)
+		}
+		vars = vars[1+vars[0]:]
+	}
+
+	rec = sets[ipfixTplIPv6]
+	if !bytes.Equal(rec[32:48], net.ParseIP("2001:4860:4860::8888").To16()) || rec[52] != 17 {
+		t.Errorf("invalid IPv6 record: %v", rec)
+	}
+	if event := rec[85]; event != ipfixFlowCreated {
+		t.Errorf("invalid firewall event of the IPv6 flow: %d", event)
+	}
+
+	// the sequence counts the data records sent.
+	if fe.sequence != 2 {
+		t.Errorf("invalid sequence: %d", fe.sequence)
+	}
+}
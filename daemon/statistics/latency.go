@@ -0,0 +1,85 @@
+package statistics
+
+import (
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// Stages of the verdict of the connections whose latency is measured.
+const (
+	LatencyProcessLookup = "process_lookup"
+	LatencyRules         = "rule_evaluation"
+)
+
+// upper bounds of the buckets of the histograms. The connections slower than
+// the last one are counted in an additional bucket.
+var latencyBuckets = []time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyHistogram is the distribution of the time spent in a stage, since
+// the daemon was started.
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		counts: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for ; i < len(latencyBuckets) && d > latencyBuckets[i]; i++ {
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *latencyHistogram) serialize(stage string) *protocol.LatencyHistogram {
+	bounds := make([]uint64, len(latencyBuckets))
+	for i, b := range latencyBuckets {
+		bounds[i] = uint64(b.Microseconds())
+	}
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	return &protocol.LatencyHistogram{
+		Stage:    stage,
+		BoundsUs: bounds,
+		Counts:   counts,
+		Count:    h.count,
+		SumUs:    uint64(h.sum.Microseconds()),
+		MaxUs:    uint64(h.max.Microseconds()),
+	}
+}
+
+// serializeLatency returns the histograms of the stages, in the order they're
+// applied to the connections. Must be called with the lock held.
+func (s *Statistics) serializeLatency() []*protocol.LatencyHistogram {
+	return []*protocol.LatencyHistogram{
+		s.latency[LatencyProcessLookup].serialize(LatencyProcessLookup),
+		s.latency[LatencyRules].serialize(LatencyRules),
+	}
+}
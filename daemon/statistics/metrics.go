@@ -0,0 +1,159 @@
+package statistics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/dns"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/netfilter"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
+)
+
+// The counters of the daemon can be exposed on a local HTTP endpoint, in the
+// text format of Prometheus, to monitor the daemons of several machines:
+//	curl http://127.0.0.1:9101/metrics
+// The format is written directly, to not depend on the client library.
+
+const metricsPath = "/metrics"
+
+// metricsWriter writes the metrics in the text exposition format.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) value(name string, labels []string, value interface{}) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	fmt.Fprintf(w, " %v\n", value)
+}
+
+func (w *metricsWriter) metric(name, kind, help string, value interface{}) {
+	w.header(name, kind, help)
+	w.value(name, nil, value)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// Metrics returns the counters of the daemon in the text format of Prometheus.
+func (s *Statistics) Metrics() string {
+	w := &metricsWriter{}
+
+	s.RLock()
+	w.metric("opensnitch_connections_total", "counter", "Connections intercepted.", s.Connections)
+	w.metric("opensnitch_connections_accepted_total", "counter", "Connections allowed.", s.Accepted)
+	w.metric("opensnitch_connections_dropped_total", "counter", "Connections denied or rejected.", s.Dropped)
+	w.metric("opensnitch_connections_ignored_total", "counter", "Connections not intercepted.", s.Ignored)
+	w.metric("opensnitch_rules_hits_total", "counter", "Connections that matched a rule.", s.RuleHits)
+	w.metric("opensnitch_rules_misses_total", "counter", "Connections that didn't match any rule.", s.RuleMisses)
+	w.metric("opensnitch_dns_responses_total", "counter", "DNS responses intercepted.", s.DNSResponses)
+	w.metric("opensnitch_events_dropped_total", "counter", "Events discarded before being sent to the GUI.", s.droppedEvents)
+	w.metric("opensnitch_events_coalesced_total", "counter", "Identical events coalesced into the previous ones.", s.coalescedEvents)
+	w.metric("opensnitch_uptime_seconds", "gauge", "Time since the daemon was started.", seconds(time.Since(s.Started)))
+
+	name := "opensnitch_verdict_latency_seconds"
+	w.header(name, "histogram", "Time spent finding the processes and evaluating the rules of the connections.")
+	for _, stage := range []string{LatencyProcessLookup, LatencyRules} {
+		h := s.latency[stage]
+		cumulative := uint64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			w.value(name+"_bucket", []string{"stage", stage, "le", seconds(bound)}, cumulative)
+		}
+		w.value(name+"_bucket", []string{"stage", stage, "le", "+Inf"}, h.count)
+		w.value(name+"_sum", []string{"stage", stage}, seconds(h.sum))
+		w.value(name+"_count", []string{"stage", stage}, h.count)
+	}
+	s.RUnlock()
+
+	if s.rules != nil {
+		hits := s.rules.GetHits()
+		names := make([]string, 0, len(hits))
+		for name := range hits {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.header("opensnitch_rule_hits_total", "counter", "Connections matched by each rule.")
+		for _, name := range names {
+			w.value("opensnitch_rule_hits_total", []string{"rule", name}, hits[name].Count)
+		}
+	}
+
+	cache := dns.GetCacheStats()
+	w.metric("opensnitch_dns_cache_entries", "gauge", "Domains of the cache of resolved domains.", cache.Entries)
+	w.metric("opensnitch_dns_cache_hits_total", "counter", "Lookups of IPs found in the cache of resolved domains.", cache.Hits)
+	w.metric("opensnitch_dns_cache_misses_total", "counter", "Lookups of IPs not found in the cache of resolved domains.", cache.Misses)
+	w.metric("opensnitch_dns_cache_expired_total", "counter", "Domains deleted from the cache because their TTL expired.", cache.Expired)
+	w.metric("opensnitch_dns_cache_evicted_total", "counter", "Domains deleted from the cache to make room for new ones.", cache.Evicted)
+
+	health := procmon.GetHealth()
+	w.header("opensnitch_procmon_method", "gauge", "Process monitor method in use.")
+	w.value("opensnitch_procmon_method", []string{"method", health.Method}, 1)
+	w.metric("opensnitch_procmon_lookups_total", "counter", "Connections whose process has been looked up with the method in use.", health.Lookups)
+	w.metric("opensnitch_procmon_unknown_total", "counter", "Processes not found by the method in use.", health.Unknown)
+	w.metric("opensnitch_procmon_errors_total", "counter", "Errors of the method in use.", health.Errors)
+
+	w.metric("opensnitch_nfqueue_timeouts_total", "counter", "Packets not delivered to the daemon in time.", netfilter.GetQueueTimeouts())
+	if queues, err := netfilter.GetQueueStats(); err == nil {
+		for _, m := range []struct {
+			name, kind, help string
+			value            func(netfilter.QueueStats) uint64
+		}{
+			{"opensnitch_nfqueue_length", "gauge", "Packets waiting for a verdict.", func(q netfilter.QueueStats) uint64 { return q.Length }},
+			{"opensnitch_nfqueue_dropped_total", "counter", "Packets dropped because the queue was full.", func(q netfilter.QueueStats) uint64 { return q.Dropped }},
+			{"opensnitch_nfqueue_user_dropped_total", "counter", "Packets dropped because they couldn't be sent to the daemon.", func(q netfilter.QueueStats) uint64 { return q.UserDropped }},
+		} {
+			w.header(m.name, m.kind, m.help)
+			for _, q := range queues {
+				w.value(m.name, []string{"queue", strconv.Itoa(int(q.ID))}, m.value(q))
+			}
+		}
+	}
+
+	return w.String()
+}
+
+// serveMetrics starts the HTTP server of the metrics.
+func (s *Statistics) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(s.Metrics()))
+	})
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	log.Info("Stats, metrics available on http://%s%s", addr, metricsPath)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			log.Warning("Stats, metrics server error: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package statistics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+)
+
+// The connections of the same process to the same destination are coalesced
+// during an interval of time: only the first ones are added to the events sent
+// to the GUI, saved, logged and streamed, and the rest are counted in the last
+// event added. The counters of the stats are always updated.
+// This way very chatty apps don't saturate the connection with the GUI or the
+// events database.
+
+// DefaultEventsCoalesceBurst is the number of identical events recorded per
+// interval, before coalescing the rest.
+const DefaultEventsCoalesceBurst = 1
+
+// max number of tuples tracked. The events of new tuples once the limit is
+// reached are not coalesced.
+const maxSampledTuples = 4096
+
+type sampledTuple struct {
+	start time.Time
+	seen  int
+	// last event of this tuple added to the events buffer.
+	event *Event
+}
+
+type eventSampler struct {
+	sync.Mutex
+	interval  time.Duration
+	burst     int
+	tuples    map[string]*sampledTuple
+	lastPurge time.Time
+}
+
+func newEventSampler(interval time.Duration, burst int) *eventSampler {
+	return &eventSampler{
+		interval:  interval,
+		burst:     burst,
+		tuples:    make(map[string]*sampledTuple),
+		lastPurge: time.Now(),
+	}
+}
+
+func sampleKey(con *conman.Connection, match *rule.Rule) string {
+	path := ""
+	if con.Process != nil {
+		path = con.Process.Path
+	}
+	dst := con.DstHost
+	if dst == "" {
+		dst = con.DstIP.String()
+	}
+	verdict := ""
+	if match != nil {
+		verdict = match.Name
+	}
+	return fmt.Sprint(path, "|", con.Protocol, "|", dst, "|", con.DstPort, "|", verdict)
+}
+
+// sample returns the tuple of the event, and if it has to be coalesced.
+func (e *eventSampler) sample(now time.Time, con *conman.Connection, match *rule.Rule) (*sampledTuple, bool) {
+	e.Lock()
+	defer e.Unlock()
+
+	if now.Sub(e.lastPurge) > e.interval {
+		for k, t := range e.tuples {
+			if now.Sub(t.start) > e.interval {
+				delete(e.tuples, k)
+			}
+		}
+		e.lastPurge = now
+	}
+
+	key := sampleKey(con, match)
+	t, found := e.tuples[key]
+	if !found || now.Sub(t.start) > e.interval {
+		if !found && len(e.tuples) >= maxSampledTuples {
+			return nil, false
+		}
+		if t == nil {
+			t = &sampledTuple{}
+			e.tuples[key] = t
+		}
+		t.start, t.seen = now, 0
+	}
+	t.seen++
+	return t, t.seen > e.burst
+}
+
+// setCoalescing enables the coalescing of the identical events received
+// during an interval, after the first burst ones.
+func (s *Statistics) setCoalescing(interval time.Duration, burst int) {
+	if burst <= 0 {
+		burst = DefaultEventsCoalesceBurst
+	}
+	s.sampler = newEventSampler(interval, burst)
+	log.Info("Stats, coalescing the identical events every %s, burst: %d", interval, burst)
+}
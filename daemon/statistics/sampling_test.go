@@ -0,0 +1,119 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/rule"
+)
+
+func TestEventSamplerSample(t *testing.T) {
+	allow := &rule.Rule{Name: "allow-curl", Action: rule.Allow}
+	deny := &rule.Rule{Name: "deny-curl", Action: rule.Deny}
+	tests := []struct {
+		name      string
+		offset    time.Duration
+		dstIP     string
+		match     *rule.Rule
+		coalesced bool
+		seen      int
+	}{
+		{"first event", 0, "1.1.1.1", allow, false, 1},
+		{"burst", time.Second, "1.1.1.1", allow, false, 2},
+		{"merged", 2 * time.Second, "1.1.1.1", allow, true, 3},
+		{"merged again", 3 * time.Second, "1.1.1.1", allow, true, 4},
+		{"other destination", 4 * time.Second, "1.1.1.2", allow, false, 1},
+		{"other rule", 5 * time.Second, "1.1.1.1", deny, false, 1},
+		{"still merged", 10 * time.Second, "1.1.1.1", allow, true, 5},
+		// the interval of the tuple has expired, so the next ones start a new burst.
+		{"flushed", 11 * time.Second, "1.1.1.1", allow, false, 1},
+		{"burst after flushing", 12 * time.Second, "1.1.1.1", allow, false, 2},
+		{"merged after flushing", 13 * time.Second, "1.1.1.1", allow, true, 3},
+	}
+
+	base := time.Now()
+	sampler := newEventSampler(10*time.Second, 2)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tuple, coalesced := sampler.sample(base.Add(test.offset), testConnection("tcp", test.dstIP, 40000), test.match)
+			if tuple == nil {
+				t.Fatal("the event has not been sampled")
+			}
+			if coalesced != test.coalesced || tuple.seen != test.seen {
+				t.Errorf("coalesced: %v, seen: %d, want %v, %d", coalesced, tuple.seen, test.coalesced, test.seen)
+			}
+		})
+	}
+}
+
+func TestEventSamplerPurge(t *testing.T) {
+	base := time.Now()
+	sampler := newEventSampler(10*time.Second, 1)
+	sampler.lastPurge = base
+	sampler.sample(base, testConnection("tcp", "1.1.1.1", 40000), nil)
+	sampler.sample(base.Add(5*time.Second), testConnection("tcp", "1.1.1.2", 40000), nil)
+
+	// only the tuples whose interval has expired are deleted.
+	sampler.sample(base.Add(12*time.Second), testConnection("tcp", "1.1.1.3", 40000), nil)
+	if len(sampler.tuples) != 2 {
+		t.Errorf("%d tuples after purging, want 2", len(sampler.tuples))
+	}
+	if _, found := sampler.tuples[sampleKey(testConnection("tcp", "1.1.1.1", 40000), nil)]; found {
+		t.Error("the expired tuple has not been deleted")
+	}
+}
+
+func TestEventSamplerMaxTuples(t *testing.T) {
+	now := time.Now()
+	sampler := newEventSampler(time.Minute, 1)
+	for i := 0; i < maxSampledTuples; i++ {
+		con := testConnection("tcp", "1.1.1.1", 40000)
+		con.DstPort = uint(i)
+		sampler.sample(now, con, nil)
+	}
+
+	// the events of new tuples are not coalesced.
+	con := testConnection("tcp", "1.1.1.2", 40000)
+	for i := 0; i < 2; i++ {
+		if tuple, coalesced := sampler.sample(now, con, nil); tuple != nil || coalesced {
+			t.Errorf("new tuple sampled with the cache full: %v, %v", tuple, coalesced)
+		}
+	}
+	// while the known ones still are.
+	con.DstIP = testConnection("tcp", "1.1.1.1", 40000).DstIP
+	if _, coalesced := sampler.sample(now, con, nil); !coalesced {
+		t.Error("known tuple not coalesced with the cache full")
+	}
+}
+
+func TestOnConnectionCoalesced(t *testing.T) {
+	s := New(nil)
+	s.sampler = newEventSampler(time.Minute, 1)
+	allow := &rule.Rule{Name: "allow-curl", Action: rule.Allow}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		con := testConnection("tcp", "1.1.1.1", uint(40000+i))
+		tuple, coalesced := s.sampler.sample(now, con, allow)
+		s.onConnection(conEvent{con: con, match: allow, tuple: tuple, coalesced: coalesced})
+	}
+	con := testConnection("tcp", "1.1.1.2", 40000)
+	tuple, coalesced := s.sampler.sample(now, con, allow)
+	s.onConnection(conEvent{con: con, match: allow, tuple: tuple, coalesced: coalesced})
+
+	// the first event of each tuple is added, and the rest counted in it.
+	if len(s.Events) != 2 {
+		t.Fatalf("%d events, want 2", len(s.Events))
+	}
+	for i, want := range []uint64{2, 0} {
+		if s.Events[i].Coalesced != want {
+			t.Errorf("event %d: %d events coalesced, want %d", i, s.Events[i].Coalesced, want)
+		}
+	}
+	if s.Connections != 4 || s.coalescedEvents != 2 {
+		t.Errorf("%d connections, %d events coalesced, want 4, 2", s.Connections, s.coalescedEvents)
+	}
+	if got := s.Events[0].Connection.DstIP.String(); got != "1.1.1.1" {
+		t.Errorf("unexpected event: %s", got)
+	}
+}
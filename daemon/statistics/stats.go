@@ -7,8 +7,11 @@ import (
 
 	"github.com/evilsocket/opensnitch/daemon/conman"
 	"github.com/evilsocket/opensnitch/daemon/core"
+	"github.com/evilsocket/opensnitch/daemon/dns"
+	"github.com/evilsocket/opensnitch/daemon/firewall"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/evilsocket/opensnitch/daemon/log/loggers"
+	"github.com/evilsocket/opensnitch/daemon/procmon/ebpf"
 	"github.com/evilsocket/opensnitch/daemon/rule"
 	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
 )
@@ -18,12 +21,44 @@ type StatsConfig struct {
 	MaxEvents int `json:"MaxEvents"`
 	MaxStats  int `json:"MaxStats"`
 	Workers   int `json:"Workers"`
+	// database where the DNS responses are saved, disabled by default.
+	DNSLog string `json:"DNSLog"`
+	// time the DNS responses are kept (168h by default, 0 keeps them forever).
+	DNSLogRetention string `json:"DNSLogRetention"`
+	// database where the connection events are saved, disabled by default,
+	// the time they're kept (720h by default, 0 keeps them forever) and the
+	// max number of them (1000000 by default, 0 unlimited).
+	EventsDB        string `json:"EventsDB"`
+	EventsRetention string `json:"EventsRetention"`
+	EventsMaxRows   *int   `json:"EventsMaxRows"`
+	// local address where the metrics are exposed in the format of
+	// Prometheus (127.0.0.1:9101), disabled by default.
+	MetricsAddress string `json:"MetricsAddress"`
+	// UDP address of the collector (192.168.1.10:4739) where the flows of the
+	// connections are exported in the IPFIX format, disabled by default, how
+	// often they're exported (60s by default), and the enterprise number of
+	// the process fields (32473 by default).
+	FlowCollector      string `json:"FlowCollector"`
+	FlowExportInterval string `json:"FlowExportInterval"`
+	FlowEnterpriseID   uint32 `json:"FlowEnterpriseID"`
+	// enables the accounting of conntrack, to know the bytes transferred and
+	// the duration of the connections closed. Disabled by default.
+	ConntrackAccounting bool `json:"ConntrackAccounting"`
+	// interval during which the identical events (same process, protocol,
+	// destination, port and rule) are coalesced, disabled by default, and the
+	// number of them recorded per interval before coalescing the rest (1 by
+	// default).
+	EventsCoalesceInterval string `json:"EventsCoalesceInterval"`
+	EventsCoalesceBurst    int    `json:"EventsCoalesceBurst"`
 }
 
 type conEvent struct {
 	con       *conman.Connection
 	match     *rule.Rule
 	wasMissed bool
+	// tuple of the event and whether it's been coalesced, if enabled.
+	tuple     *sampledTuple
+	coalesced bool
 }
 
 // Statistics holds the connections and statistics the daemon intercepts.
@@ -53,6 +88,27 @@ type Statistics struct {
 	maxEvents int
 	// max number of entries for each By* map
 	maxStats int
+	// traffic of the processes, when it's accounted with eBPF
+	traffic trafficStats
+	// connections of the last minutes and hours.
+	windows windowStats
+	// connections closed, when they're accounted with conntrack
+	closed closedStats
+	// time spent finding the processes and evaluating the rules.
+	latency map[string]*latencyHistogram
+	// DNS responses saved, if enabled.
+	dnsLog *dnsLog
+	// connection events saved, if enabled.
+	eventsDB *eventsDB
+	// exporter of the flows, if enabled
+	flowExporter *flowExporter
+	// clients receiving the events as they happen.
+	subscribers subscribers
+	// coalescing of the identical events, if enabled.
+	sampler *eventSampler
+	// events discarded because the buffer was full, and coalesced.
+	droppedEvents   uint64
+	coalescedEvents uint64
 
 	logger *loggers.LoggerManager
 }
@@ -73,6 +129,13 @@ func New(rules *rule.Loader) (stats *Statistics) {
 		jobs:      make(chan conEvent),
 		maxEvents: 150,
 		maxStats:  25,
+		traffic:   newTrafficStats(),
+		windows:   newWindowStats(),
+		closed:    newClosedStats(),
+		latency: map[string]*latencyHistogram{
+			LatencyProcessLookup: newLatencyHistogram(),
+			LatencyRules:         newLatencyHistogram(),
+		},
 	}
 
 	return stats
@@ -101,16 +164,92 @@ func (s *Statistics) SetLimits(config StatsConfig) {
 	for i := 0; i < wrks; i++ {
 		go s.eventWorker(i)
 	}
+	if config.DNSLog != "" {
+		retention := DefaultDNSLogRetention
+		if config.DNSLogRetention != "" {
+			var err error
+			if retention, err = time.ParseDuration(config.DNSLogRetention); err != nil {
+				log.Warning("Invalid DNS log retention %s, using %s: %s", config.DNSLogRetention, DefaultDNSLogRetention, err)
+				retention = DefaultDNSLogRetention
+			}
+		}
+		if err := s.openDNSLog(config.DNSLog, retention); err != nil {
+			log.Warning("Unable to open the DNS log: %s", err)
+		}
+	}
+	if config.MetricsAddress != "" {
+		s.serveMetrics(config.MetricsAddress)
+	}
+	if config.EventsDB != "" {
+		retention := DefaultEventsRetention
+		if config.EventsRetention != "" {
+			var err error
+			if retention, err = time.ParseDuration(config.EventsRetention); err != nil {
+				log.Warning("Invalid events retention %s, using %s: %s", config.EventsRetention, DefaultEventsRetention, err)
+				retention = DefaultEventsRetention
+			}
+		}
+		maxRows := DefaultEventsMaxRows
+		if config.EventsMaxRows != nil {
+			maxRows = *config.EventsMaxRows
+		}
+		if err := s.openEventsDB(config.EventsDB, retention, maxRows); err != nil {
+			log.Warning("Unable to open the events database: %s", err)
+		}
+	}
+	if config.EventsCoalesceInterval != "" {
+		if interval, err := time.ParseDuration(config.EventsCoalesceInterval); err != nil || interval <= 0 {
+			log.Warning("Invalid events coalesce interval %s, coalescing disabled: %v", config.EventsCoalesceInterval, err)
+		} else {
+			s.setCoalescing(interval, config.EventsCoalesceBurst)
+		}
+	}
+	if config.ConntrackAccounting {
+		s.listenConntrack()
+	}
+	if config.FlowCollector != "" {
+		interval := DefaultFlowExportInterval
+		if config.FlowExportInterval != "" {
+			var err error
+			if interval, err = time.ParseDuration(config.FlowExportInterval); err != nil || interval <= 0 {
+				log.Warning("Invalid flows export interval %s, using %s: %v", config.FlowExportInterval, DefaultFlowExportInterval, err)
+				interval = DefaultFlowExportInterval
+			}
+		}
+		enterpriseID := DefaultFlowEnterpriseID
+		if config.FlowEnterpriseID > 0 {
+			enterpriseID = config.FlowEnterpriseID
+		}
+		if err := s.openFlowExporter(config.FlowCollector, interval, enterpriseID); err != nil {
+			log.Warning("Unable to export the flows: %s", err)
+		}
+	}
 
 }
 
+// Stop stops listening to the connections closed, restoring the accounting
+// of conntrack.
+func (s *Statistics) Stop() {
+	s.stopConntrack()
+}
+
 // OnConnectionEvent sends the details of a new connection throughout a channel,
 // in order to add the connection to the stats.
 func (s *Statistics) OnConnectionEvent(con *conman.Connection, match *rule.Rule, wasMissed bool) {
+	var tuple *sampledTuple
+	coalesced := false
+	if s.sampler != nil {
+		tuple, coalesced = s.sampler.sample(time.Now(), con, match)
+	}
 	s.jobs <- conEvent{
 		con:       con,
 		match:     match,
 		wasMissed: wasMissed,
+		tuple:     tuple,
+		coalesced: coalesced,
+	}
+	if coalesced {
+		return
 	}
 	action := "<nil>"
 	rname := "<nil>"
@@ -170,12 +309,20 @@ func (s *Statistics) eventWorker(id int) {
 	for true {
 		select {
 		case job := <-s.jobs:
-			s.onConnection(job.con, job.match, job.wasMissed)
+			s.onConnection(job)
+			s.addFlowRecord(job.con, job.match)
+			if job.coalesced {
+				continue
+			}
+			s.saveEvent(job.con, job.match)
+			s.publishConnection(job.con, job.match)
 		}
 	}
 }
 
-func (s *Statistics) onConnection(con *conman.Connection, match *rule.Rule, wasMissed bool) {
+func (s *Statistics) onConnection(job conEvent) {
+	con, match, wasMissed := job.con, job.match, job.wasMissed
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -187,31 +334,53 @@ func (s *Statistics) onConnection(con *conman.Connection, match *rule.Rule, wasM
 		s.RuleHits++
 	}
 
-	if wasMissed == false && match.Action == rule.Allow {
-		s.Accepted++
-	} else {
+	dropped := wasMissed || match.Action != rule.Allow
+	if dropped {
 		s.Dropped++
+	} else {
+		s.Accepted++
 	}
 
+	port := fmt.Sprintf("%d", con.DstPort)
 	s.incMap(&s.ByProto, con.Protocol)
 	s.incMap(&s.ByAddress, con.DstIP.String())
 	if con.DstHost != "" {
 		s.incMap(&s.ByHost, con.DstHost)
 	}
-	s.incMap(&s.ByPort, fmt.Sprintf("%d", con.DstPort))
+	s.incMap(&s.ByPort, port)
 	s.incMap(&s.ByUID, fmt.Sprintf("%d", con.Entry.UserId))
 	s.incMap(&s.ByExecutable, con.Process.Path)
+	s.windows.add(time.Now(), dropped, con.Process.Path, con.DstHost, port)
+	if !dropped {
+		s.closed.add(con)
+	}
+	s.traffic.addFlow(con)
+	s.latency[LatencyProcessLookup].observe(con.LookupTime)
+	s.latency[LatencyRules].observe(con.RulesTime)
+
+	if job.coalesced {
+		s.coalescedEvents++
+		if job.tuple.event != nil {
+			job.tuple.event.Coalesced++
+		}
+		return
+	}
 
 	// if we reached the limit, shift everything back
 	// by one position
 	nEvents := len(s.Events)
 	if nEvents == s.maxEvents {
 		s.Events = s.Events[1:]
+		s.droppedEvents++
 	}
 	if wasMissed {
 		return
 	}
-	s.Events = append(s.Events, NewEvent(con, match))
+	ev := NewEvent(con, match)
+	s.Events = append(s.Events, ev)
+	if job.tuple != nil {
+		job.tuple.event = ev
+	}
 }
 
 func (s *Statistics) serializeEvents() []*protocol.Event {
@@ -232,6 +401,7 @@ func (s *Statistics) emptyStats() {
 	if len(s.Events) > 0 {
 		s.Events = make([]*Event, 0)
 	}
+	s.closed.closed = nil
 	s.Unlock()
 }
 
@@ -239,6 +409,11 @@ func (s *Statistics) emptyStats() {
 // After return the stats, the Events are emptied, to keep collecting more stats
 // and not miss connections.
 func (s *Statistics) Serialize() *protocol.Statistics {
+	// query the firewall before locking the stats, to not block the workers.
+	fwCounters := firewall.GetCounters()
+	flows := ebpf.GetTraffic()
+	dnsCache := dns.GetCacheStats()
+
 	s.Lock()
 	defer s.emptyStats()
 	defer s.Unlock()
@@ -261,5 +436,17 @@ func (s *Statistics) Serialize() *protocol.Statistics {
 		ByPort:        s.ByPort,
 		ByUid:         s.ByUID,
 		ByExecutable:  s.ByExecutable,
+		FwCounters:    fwCounters,
+		Traffic:       s.traffic.update(flows),
+		Latency:       s.serializeLatency(),
+		Windows:       s.windows.serialize(time.Now(), s.maxStats),
+		Closed:        s.closed.closed,
+		DnsCache: &protocol.DNSCacheStats{
+			Entries: dnsCache.Entries,
+			Hits:    dnsCache.Hits,
+			Misses:  dnsCache.Misses,
+			Expired: dnsCache.Expired,
+			Evicted: dnsCache.Evicted,
+		},
 	}
 }
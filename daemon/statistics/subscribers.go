@@ -0,0 +1,176 @@
+package statistics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// Types of the events streamed to the subscribers.
+const (
+	StreamConnection = "connection"
+	StreamRule       = "rule"
+	StreamDNS        = "dns"
+)
+
+// events queued for each subscriber. If it doesn't read them fast enough, the
+// new ones are discarded.
+const subscriberBufferSize = 512
+
+type subscriber struct {
+	filter *protocol.EventsFilter
+	events chan *protocol.StreamEvent
+}
+
+type subscribers struct {
+	sync.RWMutex
+	list map[*subscriber]struct{}
+}
+
+// Subscribe returns a channel where the events matching the filter are sent,
+// and the function to stop receiving them.
+func (s *Statistics) Subscribe(filter *protocol.EventsFilter) (<-chan *protocol.StreamEvent, func()) {
+	if filter == nil {
+		filter = &protocol.EventsFilter{}
+	}
+	sub := &subscriber{
+		filter: filter,
+		events: make(chan *protocol.StreamEvent, subscriberBufferSize),
+	}
+	s.subscribers.Lock()
+	if s.subscribers.list == nil {
+		s.subscribers.list = make(map[*subscriber]struct{})
+	}
+	s.subscribers.list[sub] = struct{}{}
+	s.subscribers.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			s.subscribers.Lock()
+			delete(s.subscribers.list, sub)
+			close(sub.events)
+			s.subscribers.Unlock()
+		})
+	}
+}
+
+func (s *Statistics) hasSubscribers() bool {
+	s.subscribers.RLock()
+	defer s.subscribers.RUnlock()
+	return len(s.subscribers.list) > 0
+}
+
+// publish sends an event to the subscribers whose filter matches it.
+func (s *Statistics) publish(build func() *protocol.StreamEvent, matches func(*protocol.EventsFilter) bool) {
+	s.subscribers.RLock()
+	defer s.subscribers.RUnlock()
+
+	var ev *protocol.StreamEvent
+	for sub := range s.subscribers.list {
+		if !matches(sub.filter) {
+			continue
+		}
+		if ev == nil {
+			ev = build()
+		}
+		select {
+		case sub.events <- ev:
+		default:
+		}
+	}
+}
+
+func (s *Statistics) publishConnection(con *conman.Connection, match *rule.Rule) {
+	if !s.hasSubscribers() {
+		return
+	}
+	action := ""
+	if match != nil {
+		action = string(match.Action)
+	}
+	s.publish(
+		func() *protocol.StreamEvent {
+			ev := NewEvent(con, match)
+			return &protocol.StreamEvent{
+				Type:       StreamConnection,
+				Unixnano:   ev.Time.UnixNano(),
+				Connection: ev.Serialize(),
+			}
+		},
+		func(f *protocol.EventsFilter) bool {
+			process := ""
+			if con.Process != nil {
+				process = con.Process.Path
+			}
+			return matchAny(f.Types, StreamConnection) &&
+				matchAny(f.Actions, action) &&
+				strings.Contains(process, f.Process) &&
+				strings.Contains(con.DstHost, f.DstHost) &&
+				(f.DstIp == "" || f.DstIp == con.DstIP.String()) &&
+				(f.DstPort == 0 || uint(f.DstPort) == con.DstPort)
+		})
+}
+
+// PublishRuleChange sends a rule added, changed, deleted or expired to the
+// subscribers.
+func (s *Statistics) PublishRuleChange(change rule.RuleChange) {
+	if !s.hasSubscribers() || change.Rule == nil {
+		return
+	}
+	s.publish(
+		func() *protocol.StreamEvent {
+			return &protocol.StreamEvent{
+				Type:     StreamRule,
+				Unixnano: time.Now().UnixNano(),
+				Rule:     change.Rule.Serialize(),
+				RuleOp:   change.Op,
+			}
+		},
+		func(f *protocol.EventsFilter) bool {
+			return matchAny(f.Types, StreamRule) && matchAny(f.Actions, string(change.Rule.Action))
+		})
+}
+
+func (s *Statistics) publishDNS(record *DNSLogRecord) {
+	s.publish(
+		func() *protocol.StreamEvent {
+			return &protocol.StreamEvent{
+				Type:     StreamDNS,
+				Unixnano: record.Time.UnixNano(),
+				Dns: &protocol.DNSEvent{
+					Domain:   record.Domain,
+					Type:     record.Type,
+					Resolver: record.Resolver,
+					Answers:  record.Answers,
+					Pid:      uint64(record.PID),
+					Process:  record.Process,
+				},
+			}
+		},
+		func(f *protocol.EventsFilter) bool {
+			return matchAny(f.Types, StreamDNS) &&
+				len(f.Actions) == 0 &&
+				f.DstPort == 0 &&
+				strings.Contains(record.Process, f.Process) &&
+				strings.Contains(record.Domain, f.DstHost) &&
+				(f.DstIp == "" || (len(record.Answers) > 0 && matchAny(record.Answers, f.DstIp)))
+		})
+}
+
+// matchAny tells if a value is in a list of values, or if the list is empty.
+func matchAny(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
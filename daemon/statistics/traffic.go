@@ -0,0 +1,169 @@
+package statistics
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/procmon/ebpf"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// max number of flows of the connections to remember, to assign their
+// traffic to the processes.
+const maxFlows = 4096
+
+// the flows and processes without traffic for this time are forgotten.
+var trafficIdleTimeout = time.Minute
+
+// flowOwner is the process of the connection of a flow.
+type flowOwner struct {
+	pid  int
+	path string
+	uid  uint32
+	seen time.Time
+}
+
+// processTraffic is the traffic of a process, accumulated from its flows.
+type processTraffic struct {
+	pid          int
+	path         string
+	uid          uint32
+	bytesSent    uint64
+	bytesRecv    uint64
+	packetsSent  uint64
+	packetsRecv  uint64
+	sentRate     uint64
+	recvRate     uint64
+	lastActivity time.Time
+}
+
+// trafficStats assigns the traffic of the flows accounted with eBPF to the
+// processes of the connections intercepted.
+type trafficStats struct {
+	owners map[string]*flowOwner
+	// counters of the flows in the last read, to account only the new traffic.
+	last     map[string]ebpf.FlowTraffic
+	procs    map[string]*processTraffic
+	lastRead time.Time
+}
+
+func newTrafficStats() trafficStats {
+	return trafficStats{
+		owners: make(map[string]*flowOwner),
+		last:   make(map[string]ebpf.FlowTraffic),
+		procs:  make(map[string]*processTraffic),
+	}
+}
+
+func flowKey(proto string, srcIP net.IP, srcPort uint, dstIP net.IP, dstPort uint) string {
+	return fmt.Sprint(proto, ":", srcIP, ":", srcPort, ":", dstIP, ":", dstPort)
+}
+
+// deleteOldestFlow deletes the flow seen longest ago, to make room for a new one.
+func deleteOldestFlow(owners map[string]*flowOwner) {
+	oldestKey := ""
+	var oldest time.Time
+	for k, o := range owners {
+		if oldestKey == "" || o.seen.Before(oldest) {
+			oldestKey, oldest = k, o.seen
+		}
+	}
+	delete(owners, oldestKey)
+}
+
+// addFlow saves the process of the flow of a connection.
+func (t *trafficStats) addFlow(con *conman.Connection) {
+	if con.Process == nil || con.Process.ID <= 0 {
+		return
+	}
+	if len(t.owners) >= maxFlows {
+		deleteOldestFlow(t.owners)
+	}
+	t.owners[flowKey(con.Protocol, con.SrcIP, con.SrcPort, con.DstIP, con.DstPort)] = &flowOwner{
+		pid:  con.Process.ID,
+		path: con.Process.Path,
+		uid:  uint32(con.Entry.UserId),
+		seen: time.Now(),
+	}
+}
+
+// update adds the traffic of the flows since the last read to their processes,
+// and returns the traffic of the processes.
+func (t *trafficStats) update(flows []ebpf.FlowTraffic) []*protocol.ProcessTraffic {
+	if flows == nil {
+		return nil
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.lastRead).Seconds()
+	if t.lastRead.IsZero() {
+		elapsed = 0
+	}
+	t.lastRead = now
+
+	for _, p := range t.procs {
+		p.sentRate, p.recvRate = 0, 0
+	}
+	current := make(map[string]ebpf.FlowTraffic, len(flows))
+	for _, f := range flows {
+		key := flowKey(f.Proto, f.SrcIP, f.SrcPort, f.DstIP, f.DstPort)
+		current[key] = f
+		sent, recv := f.BytesSent, f.BytesRecv
+		pktSent, pktRecv := f.PacketsSent, f.PacketsRecv
+		// the counters start from 0 if the flow was evicted and added again.
+		if prev, found := t.last[key]; found && prev.BytesSent <= sent && prev.BytesRecv <= recv {
+			sent -= prev.BytesSent
+			recv -= prev.BytesRecv
+			pktSent -= prev.PacketsSent
+			pktRecv -= prev.PacketsRecv
+		}
+		owner, found := t.owners[key]
+		if !found || (sent == 0 && recv == 0) {
+			continue
+		}
+		owner.seen = now
+
+		procKey := fmt.Sprint(owner.pid, ":", owner.path)
+		p, found := t.procs[procKey]
+		if !found {
+			p = &processTraffic{pid: owner.pid, path: owner.path, uid: owner.uid}
+			t.procs[procKey] = p
+		}
+		p.bytesSent += sent
+		p.bytesRecv += recv
+		p.packetsSent += pktSent
+		p.packetsRecv += pktRecv
+		if elapsed > 0 {
+			p.sentRate += uint64(float64(sent) / elapsed)
+			p.recvRate += uint64(float64(recv) / elapsed)
+		}
+		p.lastActivity = now
+	}
+	t.last = current
+
+	for k, o := range t.owners {
+		if _, found := current[k]; !found && now.Sub(o.seen) > trafficIdleTimeout {
+			delete(t.owners, k)
+		}
+	}
+	traffic := make([]*protocol.ProcessTraffic, 0, len(t.procs))
+	for k, p := range t.procs {
+		if now.Sub(p.lastActivity) > trafficIdleTimeout {
+			delete(t.procs, k)
+			continue
+		}
+		traffic = append(traffic, &protocol.ProcessTraffic{
+			Pid:           uint64(p.pid),
+			Path:          p.path,
+			Uid:           p.uid,
+			BytesSent:     p.bytesSent,
+			BytesRecv:     p.bytesRecv,
+			PacketsSent:   p.packetsSent,
+			PacketsRecv:   p.packetsRecv,
+			BytesSentRate: p.sentRate,
+			BytesRecvRate: p.recvRate,
+		})
+	}
+	return traffic
+}
@@ -0,0 +1,184 @@
+package statistics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+)
+
+// Besides the counters since the daemon started, the connections are
+// aggregated in time buckets, to know the top processes, hosts and ports of
+// the last minutes or hours. The buckets are reused when they expire, so the
+// memory doesn't grow on long running systems.
+
+// dimensions of the connections aggregated.
+const (
+	windowByExecutable = iota
+	windowByHost
+	windowByPort
+	windowDimensions
+)
+
+// max number of keys of each dimension per bucket. The connections of new keys
+// once the limit is reached are counted in windowOtherKey.
+const (
+	windowMaxKeys  = 1024
+	windowOtherKey = "<other>"
+)
+
+// statsWindows are the windows of time serialized.
+var statsWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+// windowBucket holds the connections of a period of time.
+type windowBucket struct {
+	start       time.Time
+	connections uint64
+	dropped     uint64
+	counters    [windowDimensions]map[string]uint64
+}
+
+func (b *windowBucket) reset(start time.Time) {
+	b.start = start
+	b.connections, b.dropped = 0, 0
+	for i := range b.counters {
+		b.counters[i] = make(map[string]uint64)
+	}
+}
+
+func (b *windowBucket) inc(dimension int, key string) {
+	m := b.counters[dimension]
+	if _, found := m[key]; !found && len(m) >= windowMaxKeys {
+		key = windowOtherKey
+	}
+	m[key]++
+}
+
+// windowRing is a ring buffer of buckets of the same length.
+type windowRing struct {
+	length  time.Duration
+	buckets []windowBucket
+}
+
+func newWindowRing(length time.Duration, n int) *windowRing {
+	return &windowRing{
+		length:  length,
+		buckets: make([]windowBucket, n),
+	}
+}
+
+// bucket returns the bucket of a time, reusing it if it has expired.
+func (r *windowRing) bucket(now time.Time) *windowBucket {
+	start := now.Truncate(r.length)
+	b := &r.buckets[int(start.UnixNano()/int64(r.length))%len(r.buckets)]
+	if !b.start.Equal(start) {
+		b.reset(start)
+	}
+	return b
+}
+
+// span returns the duration of time covered by the ring.
+func (r *windowRing) span() time.Duration {
+	return r.length * time.Duration(len(r.buckets))
+}
+
+// windowStats aggregates the connections in a fine ring of buckets, for the
+// last hour, and in a coarse one for the last day.
+type windowStats struct {
+	rings []*windowRing
+}
+
+func newWindowStats() windowStats {
+	return windowStats{
+		rings: []*windowRing{
+			newWindowRing(10*time.Second, 360),
+			newWindowRing(10*time.Minute, 144),
+		},
+	}
+}
+
+func (w *windowStats) add(now time.Time, dropped bool, executable, host, port string) {
+	for _, r := range w.rings {
+		b := r.bucket(now)
+		b.connections++
+		if dropped {
+			b.dropped++
+		}
+		b.inc(windowByExecutable, executable)
+		if host != "" {
+			b.inc(windowByHost, host)
+		}
+		b.inc(windowByPort, port)
+	}
+}
+
+// serialize returns the connections of each window, with the top entries of
+// each dimension.
+func (w *windowStats) serialize(now time.Time, top int) []*protocol.StatsWindow {
+	windows := make([]*protocol.StatsWindow, 0, len(statsWindows))
+	for _, sw := range statsWindows {
+		ring := w.rings[len(w.rings)-1]
+		for _, r := range w.rings {
+			if sw.duration <= r.span() {
+				ring = r
+				break
+			}
+		}
+
+		var totals [windowDimensions]map[string]uint64
+		for i := range totals {
+			totals[i] = make(map[string]uint64)
+		}
+		win := &protocol.StatsWindow{Window: sw.name}
+		since := now.Add(-sw.duration)
+		for i := range ring.buckets {
+			b := &ring.buckets[i]
+			// the buckets not used yet, or expired.
+			if b.start.IsZero() || !b.start.Add(ring.length).After(since) {
+				continue
+			}
+			win.Connections += b.connections
+			win.Dropped += b.dropped
+			for d, counters := range b.counters {
+				for k, v := range counters {
+					totals[d][k] += v
+				}
+			}
+		}
+		win.ByExecutable = topEntries(totals[windowByExecutable], top)
+		win.ByHost = topEntries(totals[windowByHost], top)
+		win.ByPort = topEntries(totals[windowByPort], top)
+		windows = append(windows, win)
+	}
+	return windows
+}
+
+// topEntries returns the n entries with more connections.
+func topEntries(m map[string]uint64, n int) map[string]uint64 {
+	if len(m) <= n {
+		return m
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := make(map[string]uint64, n)
+	for _, k := range keys[:n] {
+		top[k] = m[k]
+	}
+	return top
+}
@@ -0,0 +1,146 @@
+package statistics
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindowRingBucket(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		offset time.Duration
+		// connections of the bucket after adding one.
+		want uint64
+	}{
+		{"first bucket", 0, 1},
+		{"same bucket", 900 * time.Millisecond, 2},
+		{"next bucket", time.Second, 1},
+		{"last bucket", 2 * time.Second, 1},
+		{"first bucket reused", 3 * time.Second, 1},
+		{"second bucket reused", 4500 * time.Millisecond, 1},
+		{"second bucket again", 4900 * time.Millisecond, 2},
+	}
+
+	ring := newWindowRing(time.Second, 3)
+	if ring.span() != 3*time.Second {
+		t.Errorf("span() = %s, want 3s", ring.span())
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := base.Add(test.offset)
+			b := ring.bucket(now)
+			b.connections++
+			if !b.start.Equal(now.Truncate(time.Second)) {
+				t.Errorf("bucket start %s, want %s", b.start, now.Truncate(time.Second))
+			}
+			if b.connections != test.want {
+				t.Errorf("bucket with %d connections, want %d", b.connections, test.want)
+			}
+		})
+	}
+}
+
+func TestWindowBucketMaxKeys(t *testing.T) {
+	var b windowBucket
+	b.reset(time.Now())
+	for i := 0; i < windowMaxKeys+10; i++ {
+		b.inc(windowByHost, fmt.Sprint("host", i))
+	}
+	b.inc(windowByHost, "host0")
+
+	hosts := b.counters[windowByHost]
+	if len(hosts) != windowMaxKeys+1 {
+		t.Errorf("%d keys, want %d", len(hosts), windowMaxKeys+1)
+	}
+	if hosts[windowOtherKey] != 10 || hosts["host0"] != 2 {
+		t.Errorf("unexpected counters: %s=%d, host0=%d", windowOtherKey, hosts[windowOtherKey], hosts["host0"])
+	}
+}
+
+func TestWindowStatsSerialize(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// in chronological order, as they're added by the daemon.
+	events := []struct {
+		offset     time.Duration
+		dropped    bool
+		executable string
+		host       string
+	}{
+		// expired from every window, and overwritten in the coarse ring by the
+		// event of 6h ago.
+		{-30 * time.Hour, false, "/usr/bin/old", "old.example.com"},
+		{-6 * time.Hour, true, "/usr/bin/curl", "www.example.com"},
+		// overwritten in the fine ring by the current events.
+		{-3 * time.Hour, false, "/usr/bin/curl", ""},
+		{-30 * time.Minute, false, "/usr/bin/firefox", "www.example.com"},
+		{-3 * time.Minute, true, "/usr/bin/firefox", "ads.example.com"},
+		// its bucket ends just when the last minute starts.
+		{-61 * time.Second, false, "/usr/bin/curl", "www.example.com"},
+		{-30 * time.Second, false, "/usr/bin/firefox", "www.example.com"},
+		{0, true, "/usr/bin/curl", "ads.example.com"},
+	}
+	tests := []struct {
+		window      string
+		connections uint64
+		dropped     uint64
+		executables map[string]uint64
+	}{
+		{"1m", 2, 1, map[string]uint64{"/usr/bin/firefox": 1, "/usr/bin/curl": 1}},
+		{"5m", 4, 2, map[string]uint64{"/usr/bin/firefox": 2, "/usr/bin/curl": 2}},
+		{"1h", 5, 2, map[string]uint64{"/usr/bin/firefox": 3, "/usr/bin/curl": 2}},
+		{"24h", 7, 3, map[string]uint64{"/usr/bin/firefox": 3, "/usr/bin/curl": 4}},
+	}
+
+	w := newWindowStats()
+	for _, e := range events {
+		w.add(now.Add(e.offset), e.dropped, e.executable, e.host, "443")
+	}
+	windows := w.serialize(now, 10)
+	if len(windows) != len(tests) {
+		t.Fatalf("%d windows serialized, want %d", len(windows), len(tests))
+	}
+	for i, test := range tests {
+		t.Run(test.window, func(t *testing.T) {
+			win := windows[i]
+			if win.Window != test.window {
+				t.Fatalf("window %q, want %q", win.Window, test.window)
+			}
+			if win.Connections != test.connections || win.Dropped != test.dropped {
+				t.Errorf("%d connections, %d dropped, want %d, %d", win.Connections, win.Dropped, test.connections, test.dropped)
+			}
+			if !reflect.DeepEqual(win.ByExecutable, test.executables) {
+				t.Errorf("by executable %v, want %v", win.ByExecutable, test.executables)
+			}
+			if win.ByPort["443"] != test.connections {
+				t.Errorf("by port %v, want %d connections", win.ByPort, test.connections)
+			}
+		})
+	}
+}
+
+func TestTopEntries(t *testing.T) {
+	m := map[string]uint64{"a": 3, "b": 1, "c": 3, "d": 5}
+	tests := []struct {
+		n    int
+		want map[string]uint64
+	}{
+		{0, map[string]uint64{}},
+		{1, map[string]uint64{"d": 5}},
+		// the ties are sorted by key.
+		{2, map[string]uint64{"d": 5, "a": 3}},
+		{3, map[string]uint64{"d": 5, "a": 3, "c": 3}},
+		{4, m},
+		{10, m},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+			if got := topEntries(m, test.n); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("topEntries(%d) = %v, want %v", test.n, got, test.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/evilsocket/opensnitch/daemon/conman"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/evilsocket/opensnitch/daemon/procmon"
+	"github.com/evilsocket/opensnitch/daemon/rule"
 	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -48,6 +49,10 @@ func NewAlert(atype protocol.Alert_Type, what protocol.Alert_What, action protoc
 		a.Data = &protocol.Alert_Conn{
 			data.(*conman.Connection).Serialize(),
 		}
+	case protocol.Alert_RULE:
+		a.Data = &protocol.Alert_Rule{
+			Rule: data.(*rule.Rule).Serialize(),
+		}
 	case protocol.Alert_GENERIC:
 		a.Data = &protocol.Alert_Text{data.(string)}
 	}
@@ -94,7 +99,7 @@ func (c *Client) alertsDispatcher() {
 	for {
 		select {
 		case pbAlert := <-c.alertsChan:
-			if !connected {
+			if !connected && !c.peersConnected() {
 				queueAlert(queuedAlerts, pbAlert)
 				continue
 			}
@@ -116,6 +121,9 @@ func (c *Client) alertsDispatcher() {
 }
 
 func (c *Client) dispatchAlert(pbAlert protocol.Alert) {
+	for _, p := range c.peers {
+		p.postAlert(&pbAlert)
+	}
 	if c.client == nil {
 		return
 	}
@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 
 	"github.com/evilsocket/opensnitch/daemon/log"
@@ -23,6 +24,19 @@ var (
 	}
 )
 
+// getClientAuthType returns the client auth type of the given name.
+// By default the clients must present a valid certificate.
+func getClientAuthType(name string) (tls.ClientAuthType, error) {
+	if name == "" {
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	authType, found := clientAuthType[name]
+	if !found {
+		return tls.NoClientCert, fmt.Errorf("unsupported ClientAuthType: %s", name)
+	}
+	return authType, nil
+}
+
 const (
 	// AuthSimple will use WithInsecure()
 	AuthSimple = "simple"
@@ -50,16 +64,28 @@ func New(config *config.Config) (grpc.DialOption, error) {
 		log.Debug("UI auth: simple")
 		return grpc.WithInsecure(), nil
 	}
+	if credsType != AuthTLSSimple && credsType != AuthTLSMutual {
+		return nil, fmt.Errorf("unknown auth type: %s", credsType)
+	}
+	clientAuth, err := getClientAuthType(tlsOpts.ClientAuthType)
+	if err != nil {
+		return nil, err
+	}
 	certPool := x509.NewCertPool()
 
-	// use CA certificate to authenticate clients if supplied
+	// use CA certificate to authenticate the server if supplied.
+	// With tls-mutual an invalid CA is an error, instead of connecting
+	// without verifying the GUI with it.
 	if tlsOpts.CACert != "" {
-		if caPem, err := ioutil.ReadFile(tlsOpts.CACert); err != nil {
+		caPem, err := ioutil.ReadFile(tlsOpts.CACert)
+		if err == nil && !certPool.AppendCertsFromPEM(caPem) {
+			err = fmt.Errorf("invalid CA certificate: %s", tlsOpts.CACert)
+		}
+		if err != nil && credsType == AuthTLSMutual {
+			return nil, fmt.Errorf("reading CA certificate: %s", err)
+		}
+		if err != nil {
 			log.Warning("reading UI auth CA certificate (%s): %s", credsType, err)
-		} else {
-			if !certPool.AppendCertsFromPEM(caPem) {
-				log.Warning("adding UI auth CA certificate (%s): %s", credsType, err)
-			}
 		}
 	}
 
@@ -79,11 +105,12 @@ func New(config *config.Config) (grpc.DialOption, error) {
 	tlsCfg := &tls.Config{
 		InsecureSkipVerify: tlsOpts.SkipVerify,
 		RootCAs:            certPool,
+		MinVersion:         tls.VersionTLS12,
 	}
 
 	// https://pkg.go.dev/google.golang.org/grpc/credentials#SecurityLevel
 	if credsType == AuthTLSMutual {
-		tlsCfg.ClientAuth = clientAuthType[tlsOpts.ClientAuthType]
+		tlsCfg.ClientAuth = clientAuth
 		clientCert, err := tls.LoadX509KeyPair(
 			tlsOpts.ClientCert,
 			tlsOpts.ClientKey,
@@ -100,3 +127,60 @@ func New(config *config.Config) (grpc.DialOption, error) {
 		credentials.NewTLS(tlsCfg),
 	), nil
 }
+
+// NewServer returns the configuration of the gRPC servers of the daemon
+// listening on TCP, to authenticate themselves and the clients.
+// It returns nil if the authentication is simple (no TLS).
+func NewServer(config *config.Config) (grpc.ServerOption, error) {
+	config.RLock()
+
+	credsType := config.Server.EventsAuthentication.Type
+	tlsOpts := config.Server.EventsAuthentication.TLSOptions
+
+	config.RUnlock()
+
+	if credsType == "" || credsType == AuthSimple {
+		log.Debug("server auth: simple")
+		return nil, nil
+	}
+	if credsType != AuthTLSSimple && credsType != AuthTLSMutual {
+		return nil, fmt.Errorf("unknown auth type: %s", credsType)
+	}
+	// the clients can be on other machines, so with tls-mutual they must
+	// always present a valid certificate.
+	if credsType == AuthTLSMutual {
+		clientAuth, err := getClientAuthType(tlsOpts.ClientAuthType)
+		if err != nil {
+			return nil, err
+		}
+		if clientAuth != tls.RequireAndVerifyClientCert {
+			return nil, fmt.Errorf("ClientAuthType %s not supported by %s, only req-and-verify-cert", tlsOpts.ClientAuthType, AuthTLSMutual)
+		}
+	}
+
+	serverCert, err := tls.LoadX509KeyPair(tlsOpts.ServerCert, tlsOpts.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("   using server cert: %s", tlsOpts.ServerCert)
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if credsType == AuthTLSMutual {
+		caPem, err := ioutil.ReadFile(tlsOpts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %s", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("invalid CA certificate: %s", tlsOpts.CACert)
+		}
+		tlsCfg.ClientCAs = certPool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		log.Debug("   verifying the client certs with: %s", tlsOpts.CACert)
+	}
+
+	return grpc.Creds(credentials.NewTLS(tlsCfg)), nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/evilsocket/opensnitch/daemon/ui/config"
+)
+
+func TestGetClientAuthType(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{"default", "", tls.RequireAndVerifyClientCert, false},
+		{"verify", "req-and-verify-cert", tls.RequireAndVerifyClientCert, false},
+		{"no cert", "no-client-cert", tls.NoClientCert, false},
+		{"unsupported", "require-cert", tls.NoClientCert, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := getClientAuthType(test.value)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidAuthOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		authType   string
+		clientAuth string
+	}{
+		{"unknown auth type", "tls-foo", ""},
+		{"unsupported client auth type", AuthTLSMutual, "require-cert"},
+		{"optional client cert", AuthTLSMutual, "verify-cert"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.Authentication.Type = test.authType
+			cfg.Server.Authentication.TLSOptions.ClientAuthType = test.clientAuth
+			cfg.Server.EventsAuthentication = cfg.Server.Authentication
+
+			if _, err := NewServer(cfg); err == nil {
+				t.Error("NewServer(): expected an error")
+			}
+			// the GUI connection doesn't verify the client auth type of the
+			// server, but it must be valid.
+			if test.clientAuth == "verify-cert" {
+				return
+			}
+			if _, err := New(cfg); err == nil {
+				t.Error("New(): expected an error")
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/conman"
+	"github.com/evilsocket/opensnitch/daemon/dns"
 	"github.com/evilsocket/opensnitch/daemon/firewall/iptables"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/evilsocket/opensnitch/daemon/log/loggers"
@@ -52,6 +53,8 @@ type Client struct {
 	isConnected         chan bool
 	alertsChan          chan protocol.Alert
 	streamNotifications protocol.UI_NotificationsClient
+	// other GUIs connected besides the primary one.
+	peers []*peer
 
 	//isAsking is set to true if the client is awaiting a decision from the GUI
 	isAsking bool
@@ -79,9 +82,15 @@ func NewClient(socketPath string, stats *statistics.Statistics, rules *rule.Load
 	if socketPath != "" {
 		c.setSocketPath(c.getSocketPath(socketPath))
 	}
+	for _, addr := range clientConfig.Server.Peers {
+		c.peers = append(c.peers, newPeer(addr, clientConfig.Server.PeersFullControl))
+	}
 	loggers.Load(clientConfig.Server.Loggers, clientConfig.Stats.Workers)
 	stats.SetLimits(clientConfig.Stats)
 	stats.SetLoggers(loggers)
+	if clientConfig.Server.EventsAddress != "" {
+		c.startEventsServer(clientConfig.Server.EventsAddress)
+	}
 
 	return c
 }
@@ -89,6 +98,9 @@ func NewClient(socketPath string, stats *statistics.Statistics, rules *rule.Load
 // Connect starts the connection poller
 func (c *Client) Connect() {
 	go c.poller()
+	for _, p := range c.peers {
+		go p.poller(c)
+	}
 }
 
 // Close cancels the running tasks: pinging the server and (re)connection poller.
@@ -121,6 +133,32 @@ func (c *Client) GetFirewallType() string {
 	return clientConfig.Firewall
 }
 
+// RejectWith returns the configured method to reject connections.
+func (c *Client) RejectWith() string {
+	clientConfig.RLock()
+	defer clientConfig.RUnlock()
+	return clientConfig.RejectWith
+}
+
+// DNSFirewall returns the configured method to block the DNS responses of
+// the domains denied, if any.
+func (c *Client) DNSFirewall() string {
+	clientConfig.RLock()
+	defer clientConfig.RUnlock()
+	return clientConfig.DNSFirewall
+}
+
+// UntrustedResolvers returns the action to apply to the connections resolved
+// by untrusted DNS servers.
+func (c *Client) UntrustedResolvers() string {
+	clientConfig.RLock()
+	defer clientConfig.RUnlock()
+	if clientConfig.UntrustedResolvers == "" {
+		return dns.UntrustedAlert
+	}
+	return clientConfig.UntrustedResolvers
+}
+
 // DefaultAction returns the default configured action for
 func (c *Client) DefaultAction() rule.Action {
 	isConnected := c.Connected()
@@ -128,7 +166,13 @@ func (c *Client) DefaultAction() rule.Action {
 	c.RLock()
 	defer c.RUnlock()
 
+	if c.con != nil && c.con.GetState() == connectivity.Ready {
+		return clientConnectedRule.Action
+	}
 	if isConnected {
+		if action, found := c.peersDefaultAction(); found {
+			return action
+		}
 		return clientConnectedRule.Action
 	}
 
@@ -143,8 +187,15 @@ func (c *Client) DefaultDuration() rule.Duration {
 	return clientDisconnectedRule.Duration
 }
 
-// Connected checks if the client has established a connection with the server.
+// Connected checks if the client has established a connection with the
+// primary server or any of the peers.
 func (c *Client) Connected() bool {
+	return c.primaryConnected() || c.peersConnected()
+}
+
+// primaryConnected checks if the client has established a connection with the
+// primary server.
+func (c *Client) primaryConnected() bool {
 	c.RLock()
 	defer c.RUnlock()
 	if c.con == nil || c.con.GetState() != connectivity.Ready {
@@ -176,22 +227,22 @@ func (c *Client) poller() {
 			log.Info("Client.poller() exit, Done()")
 			goto Exit
 		default:
-			isConnected := c.Connected()
+			isConnected := c.primaryConnected()
 			if wasConnected != isConnected {
 				c.onStatusChange(isConnected)
 				wasConnected = isConnected
 			}
 
-			if c.Connected() == false {
+			if c.primaryConnected() == false {
 				// connect and create the client if needed
 				if err := c.connect(); err != nil {
 					log.Warning("Error while connecting to UI service: %s", err)
 				}
 			}
 			if c.Connected() == true {
-				// if the client is connected and ready, send a ping
+				// if any of the GUIs is connected and ready, send a ping
 				if err := c.ping(time.Now()); err != nil {
-					log.Warning("Error while pinging UI service: %s, state: %v", err, c.con.GetState())
+					log.Warning("Error while pinging UI service: %s", err)
 				}
 			}
 
@@ -218,7 +269,7 @@ func (c *Client) onStatusChange(connected bool) {
 }
 
 func (c *Client) connect() (err error) {
-	if c.Connected() {
+	if c.primaryConnected() {
 		return
 	}
 
@@ -245,8 +296,14 @@ func (c *Client) openSocket() (err error) {
 	c.Lock()
 	defer c.Unlock()
 
-	if c.isUnixSocket {
-		c.con, err = grpc.Dial(c.socketPath, grpc.WithInsecure(),
+	c.con, err = dialUI(c.socketPath, c.isUnixSocket)
+	return err
+}
+
+// dialUI opens a connection with a GUI, on a unix socket or a TCP address.
+func dialUI(socketPath string, isUnixSocket bool) (con *grpc.ClientConn, err error) {
+	if isUnixSocket {
+		con, err = grpc.Dial(socketPath, grpc.WithInsecure(),
 			grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
 				return net.DialTimeout("unix", addr, timeout)
 			}))
@@ -263,12 +320,12 @@ func (c *Client) openSocket() (err error) {
 
 		dialOption, err := auth.New(&clientConfig)
 		if err != nil {
-			return fmt.Errorf("Invalid client auth options: %s", err)
+			return nil, fmt.Errorf("Invalid client auth options: %s", err)
 		}
-		c.con, err = grpc.Dial(c.socketPath, dialOption, grpc.WithKeepaliveParams(kacp))
+		return grpc.Dial(socketPath, dialOption, grpc.WithKeepaliveParams(kacp))
 	}
 
-	return err
+	return con, err
 }
 
 func (c *Client) disconnect() {
@@ -291,9 +348,15 @@ func (c *Client) ping(ts time.Time) (err error) {
 	if c.Connected() == false {
 		return fmt.Errorf("service is not connected")
 	}
+	primary := c.primaryConnected()
 
-	c.Lock()
-	defer c.Unlock()
+	// the GUIs are pinged without holding the lock, so a slow or unresponsive
+	// GUI doesn't block the rest of the client.
+	c.RLock()
+	client := c.client
+	peers := make([]*peer, len(c.peers))
+	copy(peers, c.peers)
+	c.RUnlock()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -304,7 +367,23 @@ func (c *Client) ping(ts time.Time) (err error) {
 		Stats: c.stats.Serialize(),
 	}
 	c.stats.RLock()
-	pong, err := c.client.Ping(ctx, pReq)
+	// the events are emptied once serialized, so the same stats are sent to
+	// all the GUIs.
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *peer) {
+			p.ping(pReq)
+			wg.Done()
+		}(p)
+	}
+	if !primary || client == nil {
+		wg.Wait()
+		c.stats.RUnlock()
+		return nil
+	}
+	pong, err := client.Ping(ctx, pReq)
+	wg.Wait()
 	c.stats.RUnlock()
 	if err != nil {
 		return err
@@ -318,26 +397,41 @@ func (c *Client) ping(ts time.Time) (err error) {
 }
 
 // Ask sends a request to the server, with the values of a connection to be
-// allowed or denied.
-func (c *Client) Ask(con *conman.Connection) *rule.Rule {
-	if c.client == nil {
+// allowed or denied, and waits for the answer up to the given timeout.
+// Depending on the PromptMode, it's sent to the primary GUI or to all of
+// them, in which case the first answer wins and the rest are cancelled.
+func (c *Client) Ask(con *conman.Connection, timeout time.Duration) *rule.Rule {
+	clients := c.promptClients()
+	if len(clients) == 0 {
 		return nil
 	}
 
 	// FIXME: if timeout is fired, the rule is not added to the list in the GUI
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*120)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	reply, err := c.client.AskRule(ctx, con.Serialize())
-	if err != nil {
-		log.Warning("Error while asking for rule: %s - %v", err, con)
-		return nil
+	pbCon := con.Serialize()
+	replies := make(chan *protocol.Rule, len(clients))
+	for _, client := range clients {
+		go func(client protocol.UIClient) {
+			reply, err := client.AskRule(ctx, pbCon)
+			if err != nil {
+				log.Warning("Error while asking for rule: %s - %v", err, con)
+				reply = nil
+			}
+			replies <- reply
+		}(client)
 	}
 
-	r, err := rule.Deserialize(reply)
-	if err != nil {
-		return nil
+	for range clients {
+		reply := <-replies
+		if reply == nil {
+			continue
+		}
+		if r, err := rule.Deserialize(reply); err == nil {
+			return r
+		}
 	}
-	return r
+	return nil
 }
 
 // PostAlert queues a new message to be delivered to the server
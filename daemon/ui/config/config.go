@@ -36,6 +36,38 @@ type serverConfig struct {
 	Authentication serverAuth             `json:"Authentication"`
 	LogFile        string                 `json:"LogFile"`
 	Loggers        []loggers.LoggerConfig `json:"Loggers"`
+	// address where the events are streamed to third party clients, with the
+	// Events service: unix:///run/opensnitchd/events.sock, 127.0.0.1:50052.
+	// Disabled by default.
+	EventsAddress string `json:"EventsAddress"`
+	// authentication of the clients of the Events service, when it listens on
+	// a TCP address: simple (default), tls-simple or tls-mutual. The server
+	// uses ServerCert and ServerKey, and with tls-mutual the clients must
+	// present a certificate signed by CACert. Only tls-mutual is allowed on
+	// non-loopback addresses.
+	EventsAuthentication serverAuth `json:"EventsAuthentication"`
+	// other GUIs the daemon is connected to besides the one of Address, to
+	// manage it from several seats or remotely. They use the same
+	// Authentication, and are read on startup.
+	Peers []string `json:"Peers"`
+	// by default the peers can only answer the prompts. With full control
+	// they can also manage the rules, the firewall and the configuration,
+	// like the GUI of Address.
+	PeersFullControl bool `json:"PeersFullControl"`
+	// GUIs where the connections are asked: primary (default), the GUI of
+	// Address or the first peer connected if it's not connected, or all,
+	// where the first answer wins.
+	PromptMode string `json:"PromptMode"`
+}
+
+type fwOptions struct {
+	// network interfaces where connections are intercepted. Empty means all.
+	Interfaces []string `json:"Interfaces"`
+	// network interfaces where connections are not intercepted.
+	SkipInterfaces []string `json:"SkipInterfaces"`
+	// allow (default) or strict: use the firewall the system is using, not to
+	// mix iptables-legacy and nftables rules.
+	Coexistence string `json:"Coexistence"`
 }
 
 // Config holds the values loaded from configFile
@@ -50,5 +82,35 @@ type Config struct {
 	LogUTC            bool                   `json:"LogUTC"`
 	LogMicro          bool                   `json:"LogMicro"`
 	Firewall          string                 `json:"Firewall"`
+	FwOptions         fwOptions              `json:"FwOptions"`
+	RulesProfile      string                 `json:"RulesProfile"`
 	Stats             statistics.StatsConfig `json:"Stats"`
+
+	// environment variables to read of the processes (process.env.VAR).
+	// By default all of them.
+	ProcEnvVars []string `json:"ProcEnvVars"`
+	// how to reject connections: kill-socket (default), icmp-port-unreachable, tcp-reset
+	RejectWith string `json:"RejectWith"`
+	// path to the MaxMind database of the dest.country operand. By default
+	// the GeoLite2 database installed on the system, if any.
+	GeoIPDatabase string `json:"GeoIPDatabase"`
+	// path to the MaxMind ASN database of the dest.asn operand.
+	ASNDatabase string `json:"ASNDatabase"`
+	// file where the hits of the rules are saved, to keep them across restarts.
+	// By default /var/lib/opensnitch/rules-hits.json
+	RulesHitsFile string `json:"RulesHitsFile"`
+	// how to block the domains denied by the rules when they're resolved:
+	// nxdomain, null (0.0.0.0, ::). Disabled by default.
+	DNSFirewall string `json:"DNSFirewall"`
+	// look up the IPs whose domain is unknown with reverse DNS, to show their
+	// name. Enabled by default.
+	ReverseDNS *bool `json:"ReverseDNS"`
+	// files of aliases of the IPs, in the format of /etc/hosts, which is
+	// always loaded.
+	HostsFiles []string `json:"HostsFiles"`
+	// IPs or networks of the DNS servers trusted, all of them by default, and
+	// what to do with the connections resolved by other ones: alert (default)
+	// or deny.
+	TrustedResolvers   []string `json:"TrustedResolvers"`
+	UntrustedResolvers string   `json:"UntrustedResolvers"`
 }
@@ -7,7 +7,11 @@ import (
 	"os"
 	"strings"
 
+	"github.com/evilsocket/opensnitch/daemon/dns"
+	"github.com/evilsocket/opensnitch/daemon/firewall"
+	"github.com/evilsocket/opensnitch/daemon/geoip"
 	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/procmon"
 	"github.com/evilsocket/opensnitch/daemon/procmon/monitor"
 	"github.com/evilsocket/opensnitch/daemon/rule"
 	"github.com/evilsocket/opensnitch/daemon/ui/config"
@@ -72,6 +76,9 @@ func (c *Client) loadConfiguration(rawConfig []byte) bool {
 	clientConfig.Lock()
 	defer clientConfig.Unlock()
 
+	prevProfile := clientConfig.RulesProfile
+	prevGeoIPDatabase := clientConfig.GeoIPDatabase
+	prevASNDatabase := clientConfig.ASNDatabase
 	if err := json.Unmarshal(rawConfig, &clientConfig); err != nil {
 		msg := fmt.Sprintf("Error parsing configuration %s: %s", configFile, err)
 		log.Error(msg)
@@ -105,6 +112,23 @@ func (c *Client) loadConfiguration(rawConfig []byte) bool {
 		clientDisconnectedRule.Duration = rule.Duration(clientConfig.DefaultDuration)
 		clientErrorRule.Duration = rule.Duration(clientConfig.DefaultDuration)
 	}
+	// the profile can be switched at runtime, so only apply it if it has changed.
+	if clientConfig.RulesProfile != prevProfile {
+		c.rules.SetProfile(clientConfig.RulesProfile)
+	}
+	if clientConfig.GeoIPDatabase != prevGeoIPDatabase {
+		geoip.SetDatabase(clientConfig.GeoIPDatabase)
+	}
+	if clientConfig.ASNDatabase != prevASNDatabase {
+		geoip.SetASNDatabase(clientConfig.ASNDatabase)
+	}
+	c.rules.SetHitsFile(clientConfig.RulesHitsFile)
+	dns.SetReverseLookups(clientConfig.ReverseDNS == nil || *clientConfig.ReverseDNS)
+	dns.LoadHostsFiles(clientConfig.HostsFiles)
+	dns.SetTrustedResolvers(clientConfig.TrustedResolvers)
+	procmon.SetEnvVars(clientConfig.ProcEnvVars)
+	firewall.SetInterfaces(clientConfig.FwOptions.Interfaces, clientConfig.FwOptions.SkipInterfaces)
+	firewall.SetCoexistence(clientConfig.FwOptions.Coexistence)
 	if clientConfig.ProcMonitorMethod != "" {
 		if err := monitor.ReconfigureMonitorMethod(clientConfig.ProcMonitorMethod); err != nil {
 			msg := fmt.Sprintf("Unable to set new process monitor (%s) method from disk: %v", clientConfig.ProcMonitorMethod, err)
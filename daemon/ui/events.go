@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/statistics"
+	"github.com/evilsocket/opensnitch/daemon/ui/auth"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+	"google.golang.org/grpc"
+)
+
+// eventsServer streams the connections, rules and DNS responses to third
+// party clients, besides the notifications sent to the GUI.
+type eventsServer struct {
+	protocol.UnimplementedEventsServer
+	stats *statistics.Statistics
+}
+
+// Subscribe sends the events matching the filter until the client closes the
+// stream.
+func (e *eventsServer) Subscribe(filter *protocol.EventsFilter, stream protocol.Events_SubscribeServer) error {
+	events, cancel := e.stats.Subscribe(filter)
+	defer cancel()
+	log.Debug("[events] new subscriber: %v", filter)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Debug("[events] subscriber disconnected")
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startEventsServer serves the Events service on a unix socket
+// (unix:///run/opensnitchd/events.sock) or a TCP address (127.0.0.1:50052).
+// On TCP the connections are encrypted and the clients authenticated if
+// configured. Other machines can only connect with the tls-mutual
+// authentication: the server refuses to listen on non-loopback addresses
+// otherwise.
+func (c *Client) startEventsServer(addr string) {
+	var lis net.Listener
+	var opts []grpc.ServerOption
+	var err error
+	if strings.HasPrefix(addr, "unix://") {
+		path := addr[7:]
+		os.Remove(path)
+		if lis, err = net.Listen("unix", path); err == nil {
+			// only root can read the events.
+			if err = os.Chmod(path, 0600); err != nil {
+				lis.Close()
+			}
+		}
+	} else {
+		clientConfig.RLock()
+		authType := clientConfig.Server.EventsAuthentication.Type
+		clientConfig.RUnlock()
+		if authType != auth.AuthTLSMutual && !isLoopbackAddr(addr) {
+			log.Error("[events] refusing to listen on %s without tls-mutual authentication, only loopback addresses are allowed", addr)
+			return
+		}
+
+		var creds grpc.ServerOption
+		if creds, err = auth.NewServer(&clientConfig); err != nil {
+			log.Error("[events] invalid auth options: %s", err)
+			return
+		}
+		if creds != nil {
+			opts = append(opts, creds)
+		} else {
+			log.Warning("[events] listening on %s without TLS, any local user can read the events", addr)
+		}
+		lis, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		log.Error("[events] unable to listen on %s: %s", addr, err)
+		return
+	}
+
+	srv := grpc.NewServer(opts...)
+	protocol.RegisterEventsServer(srv, &eventsServer{stats: c.stats})
+	log.Info("[events] streaming events on %s", addr)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Warning("[events] server error: %s", err)
+		}
+	}()
+}
+
+// isLoopbackAddr tells if a TCP address (host:port) is only reachable from
+// this machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -7,20 +7,30 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/evilsocket/opensnitch/daemon/core"
 	"github.com/evilsocket/opensnitch/daemon/firewall"
+	"github.com/evilsocket/opensnitch/daemon/firewall/iptables"
+	"github.com/evilsocket/opensnitch/daemon/firewall/nftables"
 	"github.com/evilsocket/opensnitch/daemon/log"
 	"github.com/evilsocket/opensnitch/daemon/procmon"
 	"github.com/evilsocket/opensnitch/daemon/procmon/monitor"
 	"github.com/evilsocket/opensnitch/daemon/rule"
+	"github.com/evilsocket/opensnitch/daemon/statistics"
 	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
 	"golang.org/x/net/context"
 )
 
 var stopMonitoringProcess = make(chan int)
 
+var (
+	execMonitorLock sync.Mutex
+	// stops the stream of the exec events to the GUI, nil if it's not running.
+	stopMonitoringExecs chan struct{}
+)
+
 // NewReply constructs a new protocol notification reply
 func NewReply(rID uint64, replyCode protocol.NotificationReplyCode, data string) *protocol.NotificationReply {
 	return &protocol.NotificationReply{
@@ -99,7 +109,11 @@ func (c *Client) handleActionChangeConfig(stream protocol.UI_NotificationsClient
 	}
 
 	if c.GetFirewallType() != newConf.Firewall {
-		firewall.ChangeFw(newConf.Firewall)
+		if err := firewall.ChangeFw(newConf.Firewall); err != nil {
+			log.Warning("[notification] error changing firewall: %s", err)
+			c.sendNotificationReply(stream, notification.Id, "", err)
+			return
+		}
 	}
 
 	if err := monitor.ReconfigureMonitorMethod(newConf.ProcMonitorMethod); err != nil {
@@ -193,6 +207,263 @@ func (c *Client) handleActionStopMonitorProcess(stream protocol.UI_Notifications
 	c.sendNotificationReply(stream, notification.Id, "", nil)
 }
 
+// handleActionCheckFwConfig validates the system firewall configuration sent
+// by the GUI, without applying it.
+// The configuration can be sent as JSON in the Data field, or as protobuf.
+func (c *Client) handleActionCheckFwConfig(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	rawConfig := []byte(notification.Data)
+	if notification.Data == "" {
+		sysfw, err := firewall.Deserialize(notification.SysFirewall)
+		if err != nil {
+			log.Warning("firewall.Deserialize() error: %s", err)
+			c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Error checking firewall, invalid rules"))
+			return
+		}
+		rawConfig = sysfw
+	}
+
+	var err error
+	if errs := firewall.CheckConfiguration(rawConfig); len(errs) > 0 {
+		msgs := []string{}
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		err = fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	c.sendNotificationReply(stream, notification.Id, "", err)
+}
+
+// handleActionGetFwBackend replies with the firewall in use, in JSON format.
+func (c *Client) handleActionGetFwBackend(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	backend, err := json.Marshal(firewall.Backend())
+	c.sendNotificationReply(stream, notification.Id, string(backend), err)
+}
+
+// handleActionGetFwRuleCounters replies with the packets and bytes matched
+// by every rule added by the daemon, in JSON format.
+func (c *Client) handleActionGetFwRuleCounters(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	counters, err := json.Marshal(firewall.GetRulesCounters())
+	c.sendNotificationReply(stream, notification.Id, string(counters), err)
+}
+
+// handleActionGetFwRules replies with the system firewall loaded in the
+// kernel, in the JSON format of the configuration.
+func (c *Client) handleActionGetFwRules(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	sysfw, err := firewall.GetFirewallRules()
+	if err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", err)
+		return
+	}
+	ruleset, err := firewall.Deserialize(sysfw)
+	c.sendNotificationReply(stream, notification.Id, string(ruleset), err)
+}
+
+// handleActionSetRulesProfile switches the active profile of rules, and
+// replies with the active profile and the profiles of the loaded rules.
+// The change is not saved to the configuration.
+func (c *Client) handleActionSetRulesProfile(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	log.Info("[notification] set rules profile: %s", notification.Data)
+	c.rules.SetProfile(notification.Data)
+
+	profiles, err := json.Marshal(struct {
+		Active   string
+		Profiles []string
+	}{c.rules.GetProfile(), c.rules.GetProfiles()})
+	c.sendNotificationReply(stream, notification.Id, string(profiles), err)
+}
+
+// handleActionSearchRules replies with the rules that contain a text and have
+// the given tags: {"Text": "firefox", "Tags": ["browsers"]}
+func (c *Client) handleActionSearchRules(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	var query struct {
+		Text string
+		Tags []string
+	}
+	if err := json.Unmarshal([]byte(notification.Data), &query); err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Invalid rules search: %s", err))
+		return
+	}
+	log.Debug("[notification] search rules: %s %v", query.Text, query.Tags)
+
+	rules, err := json.Marshal(c.rules.Search(query.Text, query.Tags))
+	c.sendNotificationReply(stream, notification.Id, string(rules), err)
+}
+
+// handleActionLintRules replies with the problems found in the rules: rules
+// that never match, rules that contradict each other and invalid rules.
+func (c *Client) handleActionLintRules(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	log.Debug("[notification] lint rules")
+
+	findings, err := json.Marshal(c.rules.Lint())
+	c.sendNotificationReply(stream, notification.Id, string(findings), err)
+}
+
+// handleActionSimulateConnection replies with the rule that would be applied
+// to a synthetic connection:
+// {"process_path": "/usr/bin/curl", "user_id": 1000, "dst_host": "example.org", "dst_ip": "93.184.216.34", "dst_port": 443}
+func (c *Client) handleActionSimulateConnection(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	var sim rule.Simulation
+	if err := json.Unmarshal([]byte(notification.Data), &sim); err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Invalid connection: %s", err))
+		return
+	}
+	log.Debug("[notification] simulate connection: %s -> %s:%d", sim.ProcessPath, sim.DstIP, sim.DstPort)
+
+	result, err := c.rules.Simulate(&sim)
+	if err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", err)
+		return
+	}
+	res, err := json.Marshal(result)
+	c.sendNotificationReply(stream, notification.Id, string(res), err)
+}
+
+// handleActionGetRulesHits replies with the number of connections matched by
+// each rule, and when they matched the last one:
+// {"000-allow-dns": {"count": 10, "last_hit": "2022-07-10T18:04:05Z"}}
+func (c *Client) handleActionGetRulesHits(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	log.Debug("[notification] get rules hits")
+
+	hits, err := json.Marshal(c.rules.GetHits())
+	c.sendNotificationReply(stream, notification.Id, string(hits), err)
+}
+
+// handleActionGetProcmonStatus replies with the state of the process monitor
+// method in use, its counters, and the features of the kernel:
+// {"method": "ebpf", "lookups": 120, "unknown": 2, "errors": 0, "features": {"kprobes": true, ...}, "failovers": [...], ...}
+func (c *Client) handleActionGetProcmonStatus(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	log.Debug("[notification] get procmon status")
+
+	status, err := json.Marshal(monitor.GetStatus())
+	c.sendNotificationReply(stream, notification.Id, string(status), err)
+}
+
+// handleActionGetDNSLog replies with the DNS responses of the DNS log, in JSON
+// or CSV format:
+// {"since": "2022-07-10T18:04:05Z", "domain": "example.com", "limit": 100, "format": "csv"}
+func (c *Client) handleActionGetDNSLog(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	var req struct {
+		statistics.DNSLogFilter
+		Format string `json:"format"`
+	}
+	if notification.Data != "" {
+		if err := json.Unmarshal([]byte(notification.Data), &req); err != nil {
+			c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Invalid DNS log filter: %s", err))
+			return
+		}
+	}
+	log.Debug("[notification] get DNS log: %v", req)
+
+	records, err := c.stats.GetDNSLog(req.DNSLogFilter)
+	if err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", err)
+		return
+	}
+	var data []byte
+	if req.Format == "csv" {
+		var out string
+		out, err = statistics.DNSLogCSV(records)
+		data = []byte(out)
+	} else {
+		data, err = json.Marshal(records)
+	}
+	c.sendNotificationReply(stream, notification.Id, string(data), err)
+}
+
+// handleActionGetEvents replies with the connection events saved in the
+// events database, in the format of the events of the statistics:
+// {"since": "2022-07-10T18:04:05Z", "process": "firefox", "dst_host": "example.com", "limit": 100}
+func (c *Client) handleActionGetEvents(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	var filter statistics.EventsFilter
+	if notification.Data != "" {
+		if err := json.Unmarshal([]byte(notification.Data), &filter); err != nil {
+			c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Invalid events filter: %s", err))
+			return
+		}
+	}
+	log.Debug("[notification] get events: %v", filter)
+
+	events, err := c.stats.GetEvents(filter)
+	if err != nil {
+		c.sendNotificationReply(stream, notification.Id, "", err)
+		return
+	}
+	data, err := json.Marshal(events)
+	c.sendNotificationReply(stream, notification.Id, string(data), err)
+}
+
+// handleActionMonitorExecEvents streams the processes executed to the GUI, as
+// replies to the notification, until STOP_MONITOR_EXEC_EVENTS is received:
+// {"time": "...", "pid": 1234, "ppid": 1000, "uid": 1000, "path": "/usr/bin/curl", "args": [...], "cwd": "/home/alice", "hash": "..."}
+// The executions are only traced by the ebpf process monitor method.
+func (c *Client) handleActionMonitorExecEvents(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	if !procmon.MethodIsEbpf() {
+		c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("The exec events are only available with the ebpf process monitor method"))
+		return
+	}
+	log.Info("[notification] monitoring exec events")
+
+	execMonitorLock.Lock()
+	if stopMonitoringExecs != nil {
+		close(stopMonitoringExecs)
+	}
+	stop := make(chan struct{})
+	stopMonitoringExecs = stop
+	execMonitorLock.Unlock()
+
+	go c.monitorExecEvents(stop, stream, notification.Id)
+}
+
+func (c *Client) monitorExecEvents(stop chan struct{}, stream protocol.UI_NotificationsClient, nID uint64) {
+	events := procmon.SubscribeExecEvents()
+	defer procmon.UnsubscribeExecEvents(events)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case ev := <-events:
+			ev.Hash = procmon.PathChecksum(ev.Path)
+			evJSON, err := json.Marshal(ev)
+			if errs := c.sendNotificationReply(stream, nID, string(evJSON), err); errs != nil {
+				execMonitorLock.Lock()
+				if stopMonitoringExecs == stop {
+					stopMonitoringExecs = nil
+				}
+				execMonitorLock.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) handleActionStopMonitorExecEvents(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	log.Info("[notification] stop monitoring exec events")
+	execMonitorLock.Lock()
+	if stopMonitoringExecs != nil {
+		close(stopMonitoringExecs)
+		stopMonitoringExecs = nil
+	}
+	execMonitorLock.Unlock()
+	c.sendNotificationReply(stream, notification.Id, "", nil)
+}
+
+// handleActionChangeFwBackend switches the firewall in use (iptables, nftables),
+// without restarting the daemon. The change is not saved to the configuration.
+func (c *Client) handleActionChangeFwBackend(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
+	if notification.Data != iptables.Name && notification.Data != nftables.Name {
+		c.sendNotificationReply(stream, notification.Id, "", fmt.Errorf("Invalid firewall: %s", notification.Data))
+		return
+	}
+	log.Info("[notification] changing firewall to %s", notification.Data)
+	if err := firewall.ChangeFw(notification.Data); err != nil {
+		log.Warning("[notification] error changing firewall: %s", err)
+		c.sendNotificationReply(stream, notification.Id, "", err)
+		return
+	}
+	c.handleActionGetFwBackend(stream, notification)
+}
+
 func (c *Client) handleNotification(stream protocol.UI_NotificationsClient, notification *protocol.Notification) {
 	switch {
 	case notification.Type == protocol.Action_MONITOR_PROCESS:
@@ -222,6 +493,52 @@ func (c *Client) handleNotification(stream protocol.UI_NotificationsClient, noti
 		}
 		c.sendNotificationReply(stream, notification.Id, "", nil)
 
+	case notification.Type == protocol.Action_CHECK_FW_CONFIG:
+		log.Info("[notification] checking firewall configuration")
+		c.handleActionCheckFwConfig(stream, notification)
+
+	case notification.Type == protocol.Action_GET_FW_BACKEND:
+		c.handleActionGetFwBackend(stream, notification)
+
+	case notification.Type == protocol.Action_GET_FW_RULE_COUNTERS:
+		c.handleActionGetFwRuleCounters(stream, notification)
+
+	case notification.Type == protocol.Action_GET_FW_RULES:
+		c.handleActionGetFwRules(stream, notification)
+
+	case notification.Type == protocol.Action_SET_RULES_PROFILE:
+		c.handleActionSetRulesProfile(stream, notification)
+
+	case notification.Type == protocol.Action_SEARCH_RULES:
+		c.handleActionSearchRules(stream, notification)
+
+	case notification.Type == protocol.Action_LINT_RULES:
+		c.handleActionLintRules(stream, notification)
+
+	case notification.Type == protocol.Action_SIMULATE_CONNECTION:
+		c.handleActionSimulateConnection(stream, notification)
+
+	case notification.Type == protocol.Action_GET_RULES_HITS:
+		c.handleActionGetRulesHits(stream, notification)
+
+	case notification.Type == protocol.Action_MONITOR_EXEC_EVENTS:
+		c.handleActionMonitorExecEvents(stream, notification)
+
+	case notification.Type == protocol.Action_STOP_MONITOR_EXEC_EVENTS:
+		c.handleActionStopMonitorExecEvents(stream, notification)
+
+	case notification.Type == protocol.Action_GET_PROCMON_STATUS:
+		c.handleActionGetProcmonStatus(stream, notification)
+
+	case notification.Type == protocol.Action_GET_DNS_LOG:
+		c.handleActionGetDNSLog(stream, notification)
+
+	case notification.Type == protocol.Action_GET_EVENTS:
+		c.handleActionGetEvents(stream, notification)
+
+	case notification.Type == protocol.Action_CHANGE_FW_BACKEND:
+		c.handleActionChangeFwBackend(stream, notification)
+
 	case notification.Type == protocol.Action_RELOAD_FW_RULES:
 		log.Info("[notification] reloading firewall")
 
@@ -289,20 +606,21 @@ func (c *Client) Subscribe() {
 		clientConnectedRule.Action = rule.Action(tempConf.DefaultAction)
 		c.Unlock()
 	}
-	c.listenForNotifications()
+	c.listenForNotifications(c.client)
+	c.disconnect()
 }
 
 // Notifications is the channel where the daemon receives messages from the server.
 // It consists of 2 grpc streams (send/receive) that are never closed,
 // this way we can share messages in realtime.
 // If the GUI is closed, we'll receive an error reading from the channel.
-func (c *Client) listenForNotifications() {
+func (c *Client) listenForNotifications(client protocol.UIClient) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// open the stream channel
 	streamReply := &protocol.NotificationReply{Id: 0, Code: protocol.NotificationReplyCode_OK}
-	notisStream, err := c.client.Notifications(ctx)
+	notisStream, err := client.Notifications(ctx)
 	if err != nil {
 		log.Error("establishing notifications channel %s", err)
 		return
@@ -333,5 +651,4 @@ func (c *Client) listenForNotifications() {
 Exit:
 	notisStream.CloseSend()
 	log.Info("Stop receiving notifications")
-	c.disconnect()
 }
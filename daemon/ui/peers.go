@@ -0,0 +1,236 @@
+package ui
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/opensnitch/daemon/log"
+	"github.com/evilsocket/opensnitch/daemon/rule"
+	"github.com/evilsocket/opensnitch/daemon/ui/protocol"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Besides the GUI of Server.Address (the primary one), the daemon can be
+// connected to other GUIs at the same time (Server.Peers), to manage it from
+// several seats or remotely. The stats and the alerts are sent to all of them,
+// and the connections are asked to the primary GUI, or to all of them
+// (Server.PromptMode).
+// The peers can only answer the prompts: their notifications (changes of the
+// rules, the firewall, the configuration, etc) are not listened to, unless
+// Server.PeersFullControl is enabled.
+
+// Where the connections are asked.
+const (
+	// PromptPrimary asks the primary GUI, or the first peer connected if
+	// it's not connected.
+	PromptPrimary = "primary"
+	// PromptAll asks all the GUIs connected. The first answer wins.
+	PromptAll = "all"
+)
+
+// peer is a GUI connected besides the primary one. Each one has its own
+// connection, notifications channel and configuration.
+type peer struct {
+	sync.RWMutex
+	socketPath   string
+	isUnixSocket bool
+	con          *grpc.ClientConn
+	client       protocol.UIClient
+	// default action of the GUI, received when subscribing.
+	defaultAction rule.Action
+	// the GUI can send notifications to manage the daemon.
+	fullControl bool
+}
+
+func newPeer(address string, fullControl bool) *peer {
+	p := &peer{socketPath: address, fullControl: fullControl}
+	if strings.HasPrefix(address, "unix://") {
+		p.isUnixSocket = true
+		p.socketPath = address[7:]
+	}
+	return p
+}
+
+func (p *peer) connected() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.con != nil && p.con.GetState() == connectivity.Ready
+}
+
+// uiClient returns the client of the GUI, if it's connected.
+func (p *peer) uiClient() protocol.UIClient {
+	if !p.connected() {
+		return nil
+	}
+	p.RLock()
+	defer p.RUnlock()
+	return p.client
+}
+
+func (p *peer) connect() (err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.con != nil {
+		state := p.con.GetState()
+		if state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			return nil
+		}
+		p.con.Close()
+	}
+	if p.con, err = dialUI(p.socketPath, p.isUnixSocket); err != nil {
+		p.con, p.client = nil, nil
+		return err
+	}
+	p.client = protocol.NewUIClient(p.con)
+	return nil
+}
+
+func (p *peer) disconnect() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.con != nil {
+		p.con.Close()
+		p.con = nil
+	}
+	p.client = nil
+	p.defaultAction = ""
+}
+
+// poller connects to the GUI, and subscribes to its notifications when
+// connected. The stats are sent by the primary poller, to all the GUIs.
+func (p *peer) poller(c *Client) {
+	log.Debug("UI peer poller started for socket %s", p.socketPath)
+	wasConnected := false
+	for {
+		select {
+		case <-c.clientCtx.Done():
+			log.Info("UI peer poller %s exit", p.socketPath)
+			return
+		default:
+		}
+		isConnected := p.connected()
+		if wasConnected != isConnected {
+			if isConnected {
+				log.Info("Connected to the UI peer on %s", p.socketPath)
+				go p.subscribe(c)
+			} else {
+				log.Error("Connection to the UI peer %s lost.", p.socketPath)
+			}
+			wasConnected = isConnected
+		}
+		if !isConnected {
+			if err := p.connect(); err != nil {
+				log.Warning("Error while connecting to UI peer %s: %s", p.socketPath, err)
+			}
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// subscribe sends the daemon status and configuration to the GUI, and
+// handles its notifications until the channel is closed, if it has full
+// control.
+func (p *peer) subscribe(c *Client) {
+	client := p.uiClient()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	clientCfg, err := client.Subscribe(ctx, c.getClientConfig())
+	cancel()
+	if err != nil {
+		log.Error("Subscribing to GUI peer %s: %s", p.socketPath, err)
+		p.disconnect()
+		return
+	}
+	if tempConf, err := c.parseConf(clientCfg.Config); err == nil {
+		p.Lock()
+		p.defaultAction = rule.Action(tempConf.DefaultAction)
+		p.Unlock()
+	}
+	if !p.fullControl {
+		log.Info("UI peer %s subscribed, it can only answer the prompts", p.socketPath)
+		return
+	}
+	c.listenForNotifications(client)
+	p.disconnect()
+}
+
+func (p *peer) ping(pReq *protocol.PingRequest) {
+	client := p.uiClient()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Ping(ctx, pReq); err != nil {
+		log.Warning("Error while pinging UI peer %s: %s", p.socketPath, err)
+	}
+}
+
+func (p *peer) postAlert(pbAlert *protocol.Alert) {
+	client := p.uiClient()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	client.PostAlert(ctx, pbAlert, grpc.UseCompressor(gzip.Name))
+	cancel()
+}
+
+// peersConnected tells if any of the peers is connected.
+func (c *Client) peersConnected() bool {
+	for _, p := range c.peers {
+		if p.connected() {
+			return true
+		}
+	}
+	return false
+}
+
+// peersDefaultAction returns the default action of the first peer connected.
+func (c *Client) peersDefaultAction() (rule.Action, bool) {
+	for _, p := range c.peers {
+		if !p.connected() {
+			continue
+		}
+		p.RLock()
+		action := p.defaultAction
+		p.RUnlock()
+		if action != "" {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// promptClients returns the GUIs where a connection has to be asked.
+func (c *Client) promptClients() []protocol.UIClient {
+	clientConfig.RLock()
+	mode := clientConfig.Server.PromptMode
+	clientConfig.RUnlock()
+
+	clients := []protocol.UIClient{}
+	if c.primaryConnected() {
+		c.RLock()
+		if c.client != nil {
+			clients = append(clients, c.client)
+		}
+		c.RUnlock()
+	}
+	for _, p := range c.peers {
+		if mode != PromptAll && len(clients) > 0 {
+			break
+		}
+		if client := p.uiClient(); client != nil {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
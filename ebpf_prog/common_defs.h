@@ -1,12 +1,20 @@
 #ifndef OPENSNITCH_COMMON_DEFS_H
 #define OPENSNITCH_COMMON_DEFS_H
 
+#ifdef OPENSNITCH_CORE
+// CO-RE build (make core): the kernel types are generated from the BTF of
+// the running kernel, and the accesses to them relocated when loading.
+#include "vmlinux.h"
+#else
 #include <linux/sched.h>
 #include <linux/ptrace.h>
 #include <uapi/linux/bpf.h>
+#endif
 #include "bpf_headers/bpf_helpers.h"
 #include "bpf_headers/bpf_tracing.h"
-//#include <bpf/bpf_core_read.h> 
+#ifdef OPENSNITCH_CORE
+#include "bpf_headers/bpf_core_read.h"
+#endif
 
 #define BUF_SIZE_MAP_NS 256
 #define MAPSIZE 12000
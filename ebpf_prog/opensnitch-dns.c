@@ -204,5 +204,186 @@ int ret_addrinfo(struct pt_regs *ctx) {
     return 0;
 }
 
+//-----------------------------------
+// DNS-over-TLS and DNS-over-HTTPS: the plaintext read from the TLS libraries
+// is sent to userspace, where the DNS responses are searched.
+// OpenSSL SSL_read(ssl, buf, num), GnuTLS gnutls_record_recv(session, buf, size)
+// and NSPR (Firefox) PR_Read(fd, buf, amount) receive the connection as the
+// first argument and the buffer as the second one, and return the number of
+// bytes read.
+// PR_Read() is used for every file of NSPR, so only the reads of the layered
+// descriptors (the SSL layer of NSS) are sent.
+// The server of the connection is obtained from the socket read by the TLS
+// library (tcp_recvmsg) while reading, and kept by connection, because the
+// records already received are read without reading the socket.
+// Statically linked libraries (Chrome's BoringSSL) are not supported.
+
+#define MAX_TLS_DATA 1024
+// NSPR PRDescType of the layered descriptors (PR_DESC_LAYERED).
+#define NSPR_DESC_LAYERED 4
+
+struct tlsPeer {
+    u16 family;
+    // network byte order.
+    u16 dport;
+    u8 daddr[16];
+} __attribute__((packed));
+
+struct tlsDataEvent {
+    u32 len;
+    u32 pid;
+    u64 conn;
+    struct tlsPeer peer;
+    u8 data[MAX_TLS_DATA];
+} __attribute__((packed));
+
+struct tlsReadArgs {
+    u64 conn;
+    u64 buf;
+};
+
+struct bpf_map_def SEC("maps/tls_read_args") tls_read_args = {
+    .type = BPF_MAP_TYPE_HASH,
+    .max_entries = MAPSIZE,
+    .key_size = sizeof(u64),
+    .value_size = sizeof(struct tlsReadArgs),
+};
+
+// server of the TLS connections, by connection.
+struct bpf_map_def SEC("maps/tls_peers") tls_peers = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .max_entries = MAPSIZE,
+    .key_size = sizeof(u64),
+    .value_size = sizeof(struct tlsPeer),
+};
+
+// the events don't fit in the stack.
+struct bpf_map_def SEC("maps/tls_heap") tls_heap = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(u32),
+    .value_size = sizeof(struct tlsDataEvent),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/tls_events") tls_events = {
+    .type = BPF_MAP_TYPE_PERF_EVENT_ARRAY,
+    .key_size = sizeof(u32),
+    .value_size = sizeof(u32),
+    .max_entries = MAPSIZE,
+};
+
+static __always_inline int tls_read_enter(struct pt_regs *ctx) {
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct tlsReadArgs args = {0};
+    args.conn = (u64)PT_REGS_PARM1(ctx);
+    args.buf = (u64)PT_REGS_PARM2(ctx);
+    if (args.conn == 0 || args.buf == 0)
+        return 0;
+    bpf_map_update_elem(&tls_read_args, &pid_tgid, &args, BPF_ANY);
+    return 0;
+}
+
+static __always_inline int tls_read_exit(struct pt_regs *ctx) {
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct tlsReadArgs *args = bpf_map_lookup_elem(&tls_read_args, &pid_tgid);
+    if (args == NULL)
+        return 0; // missed start
+    u64 conn = args->conn;
+    u64 bufp = args->buf;
+    bpf_map_delete_elem(&tls_read_args, &pid_tgid);
+
+    int ret = (int)PT_REGS_RC(ctx);
+    // shorter than the header of a HTTP/2 frame.
+    if (ret < 9)
+        return 0;
+
+    int zero = 0;
+    struct tlsDataEvent *ev = bpf_map_lookup_elem(&tls_heap, &zero);
+    if (!ev)
+        return 0;
+    u32 len = ret;
+    if (len > MAX_TLS_DATA - 1)
+        len = MAX_TLS_DATA - 1;
+    ev->len = len;
+    ev->pid = pid_tgid >> 32;
+    ev->conn = conn;
+    struct tlsPeer *peer = bpf_map_lookup_elem(&tls_peers, &conn);
+    if (peer != NULL) {
+        __builtin_memcpy(&ev->peer, peer, sizeof(ev->peer));
+    } else {
+        __builtin_memset(&ev->peer, 0, sizeof(ev->peer));
+    }
+    bpf_probe_read_user(&ev->data, len & (MAX_TLS_DATA - 1), (void *)bufp);
+    bpf_perf_event_output(ctx, &tls_events, BPF_F_CURRENT_CPU, ev, sizeof(*ev));
+
+    return 0;
+}
+
+// saves the server of the socket read by a TLS library.
+SEC("kprobe/tcp_recvmsg")
+int kprobe__tcp_recvmsg(struct pt_regs *ctx) {
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct tlsReadArgs *args = bpf_map_lookup_elem(&tls_read_args, &pid_tgid);
+    if (args == NULL)
+        return 0; // not reading from a TLS library
+    u64 conn = args->conn;
+
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    struct tlsPeer peer = {0};
+    bpf_probe_read(&peer.family, sizeof(peer.family), &sk->__sk_common.skc_family);
+    bpf_probe_read(&peer.dport, sizeof(peer.dport), &sk->__sk_common.skc_dport);
+    if (peer.family == AF_INET) {
+        bpf_probe_read(&peer.daddr, 4, &sk->__sk_common.skc_daddr);
+    } else if (peer.family == AF_INET6) {
+        bpf_probe_read(&peer.daddr, sizeof(peer.daddr), &sk->__sk_common.skc_v6_daddr.in6_u.u6_addr32);
+    } else {
+        return 0;
+    }
+    bpf_map_update_elem(&tls_peers, &conn, &peer, BPF_ANY);
+    return 0;
+}
+
+SEC("uprobe/SSL_read")
+int uprobe__SSL_read(struct pt_regs *ctx) {
+    return tls_read_enter(ctx);
+}
+
+SEC("uretprobe/SSL_read")
+int uretprobe__SSL_read(struct pt_regs *ctx) {
+    return tls_read_exit(ctx);
+}
+
+SEC("uprobe/gnutls_record_recv")
+int uprobe__gnutls_record_recv(struct pt_regs *ctx) {
+    return tls_read_enter(ctx);
+}
+
+SEC("uretprobe/gnutls_record_recv")
+int uretprobe__gnutls_record_recv(struct pt_regs *ctx) {
+    return tls_read_exit(ctx);
+}
+
+SEC("uprobe/PR_Read")
+int uprobe__PR_Read(struct pt_regs *ctx) {
+    // PRFileDesc->methods->file_type
+    void *fd = (void *)PT_REGS_PARM1(ctx);
+    void *methods = NULL;
+    int file_type = 0;
+    if (fd == NULL)
+        return 0;
+    bpf_probe_read_user(&methods, sizeof(methods), fd);
+    if (methods == NULL)
+        return 0;
+    bpf_probe_read_user(&file_type, sizeof(file_type), methods);
+    if (file_type != NSPR_DESC_LAYERED)
+        return 0;
+    return tls_read_enter(ctx);
+}
+
+SEC("uretprobe/PR_Read")
+int uretprobe__PR_Read(struct pt_regs *ctx) {
+    return tls_read_exit(ctx);
+}
+
 char _license[] SEC("license") = "GPL";
 u32 _version SEC("version") = 0xFFFFFFFE;
@@ -20,13 +20,20 @@ static __always_inline void new_event(struct data_t* data)
     __builtin_memset(&task, 0, sizeof(task));
     __builtin_memset(&parent, 0, sizeof(parent));
     task = (struct task_struct *)bpf_get_current_task();
-    bpf_probe_read(&parent, sizeof(parent), &task->real_parent);
     data->pid = bpf_get_current_pid_tgid() >> 32;
 
+#ifdef OPENSNITCH_CORE
+    // the offsets of real_parent and tgid are relocated to the ones of the
+    // running kernel.
+    data->ppid = BPF_CORE_READ(task, real_parent, tgid);
+#else
+    bpf_probe_read(&parent, sizeof(parent), &task->real_parent);
+
     // FIXME: always 0?
 #if !defined(__arm__) && !defined(__i386__)
     // on i686 -> invalid read from stack
     bpf_probe_read(&data->ppid, sizeof(data->ppid), &parent->tgid);
+#endif
 #endif
     data->uid = bpf_get_current_uid_gid() & 0xffffffff;
     bpf_get_current_comm(&data->comm, sizeof(data->comm));
@@ -39,6 +46,10 @@ static __always_inline void new_event(struct data_t* data)
 SEC("tracepoint/sched/sched_process_exit")
 int tracepoint__sched_sched_process_exit(struct pt_regs *ctx)
 {
+    // only the exit of the processes is reported, not of their threads.
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    if ((u32)pid_tgid != pid_tgid >> 32){ return 0; }
+
     int zero = 0;
     struct data_t *data = bpf_map_lookup_elem(&heapstore, &zero);
     if (!data){ return 0; }
@@ -0,0 +1,153 @@
+#define KBUILD_MODNAME "dummy"
+
+#include "common_defs.h"
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/ipv6.h>
+
+#ifndef AF_INET
+#define AF_INET 2
+#endif
+#ifndef AF_INET6
+#define AF_INET6 10
+#endif
+
+// Accounts the bytes and packets of the flows of the processes, from the root
+// cgroup (cgroup v2), so the daemon can report the bandwidth per application.
+
+// the addresses and ports are in network byte order.
+// l = local side of the flow, r = remote side.
+struct traffic_key_t {
+	u8 family;
+	u8 proto;
+	u16 lport;
+	u16 rport;
+	u16 pad;
+	u8 laddr[16];
+	u8 raddr[16];
+}__attribute__((packed));
+
+struct traffic_value_t {
+	u64 bytes_out;
+	u64 bytes_in;
+	u64 packets_out;
+	u64 packets_in;
+	u32 uid;
+	u32 pad;
+}__attribute__((packed));
+
+// the least recently used flows are evicted when the map is full.
+// The daemon reads the counters periodically.
+struct bpf_map_def SEC("maps/trafficMap") trafficMap = {
+	.type = BPF_MAP_TYPE_LRU_HASH,
+	.key_size = sizeof(struct traffic_key_t),
+	.value_size = sizeof(struct traffic_value_t),
+	.max_entries = MAPSIZE,
+};
+
+// parse_flow fills the key of the flow of a packet. The packets seen by the
+// cgroup programs start at the network header.
+static __always_inline int parse_flow(struct __sk_buff *skb, struct traffic_key_t *key, int egress)
+{
+	u16 ports[2] = {0};
+	u32 l4_off = 0;
+
+	if (skb->protocol == bpf_htons(ETH_P_IP)) {
+		struct iphdr iph;
+		if (bpf_skb_load_bytes(skb, 0, &iph, sizeof(iph)) < 0) {
+			return -1;
+		}
+		key->family = AF_INET;
+		key->proto = iph.protocol;
+		l4_off = iph.ihl * 4;
+		if (egress) {
+			__builtin_memcpy(key->laddr, &iph.saddr, 4);
+			__builtin_memcpy(key->raddr, &iph.daddr, 4);
+		} else {
+			__builtin_memcpy(key->laddr, &iph.daddr, 4);
+			__builtin_memcpy(key->raddr, &iph.saddr, 4);
+		}
+	} else if (skb->protocol == bpf_htons(ETH_P_IPV6)) {
+		struct ipv6hdr ip6h;
+		if (bpf_skb_load_bytes(skb, 0, &ip6h, sizeof(ip6h)) < 0) {
+			return -1;
+		}
+		key->family = AF_INET6;
+		// the extension headers are not parsed.
+		key->proto = ip6h.nexthdr;
+		l4_off = sizeof(ip6h);
+		if (egress) {
+			__builtin_memcpy(key->laddr, &ip6h.saddr, 16);
+			__builtin_memcpy(key->raddr, &ip6h.daddr, 16);
+		} else {
+			__builtin_memcpy(key->laddr, &ip6h.daddr, 16);
+			__builtin_memcpy(key->raddr, &ip6h.saddr, 16);
+		}
+	} else {
+		return -1;
+	}
+
+	if (key->proto != IPPROTO_TCP && key->proto != IPPROTO_UDP && key->proto != IPPROTO_UDPLITE) {
+		return 0;
+	}
+	// the source and destination ports are the first 4 bytes of the TCP and
+	// UDP headers.
+	if (bpf_skb_load_bytes(skb, l4_off, ports, sizeof(ports)) < 0) {
+		return 0;
+	}
+	if (egress) {
+		key->lport = ports[0];
+		key->rport = ports[1];
+	} else {
+		key->lport = ports[1];
+		key->rport = ports[0];
+	}
+	return 0;
+}
+
+static __always_inline int account(struct __sk_buff *skb, int egress)
+{
+	struct traffic_key_t key = {0};
+	if (parse_flow(skb, &key, egress) < 0) {
+		return 1;
+	}
+
+	struct traffic_value_t *val = bpf_map_lookup_elem(&trafficMap, &key);
+	if (val == NULL) {
+		struct traffic_value_t newVal = {0};
+		newVal.uid = bpf_get_socket_uid(skb);
+		bpf_map_update_elem(&trafficMap, &key, &newVal, BPF_NOEXIST);
+		val = bpf_map_lookup_elem(&trafficMap, &key);
+		if (val == NULL) {
+			return 1;
+		}
+	}
+	if (egress) {
+		__sync_fetch_and_add(&val->bytes_out, skb->len);
+		__sync_fetch_and_add(&val->packets_out, 1);
+	} else {
+		__sync_fetch_and_add(&val->bytes_in, skb->len);
+		__sync_fetch_and_add(&val->packets_in, 1);
+	}
+
+	// 1 = let the packet pass, we only count it.
+	return 1;
+}
+
+SEC("cgroup/skb/egress")
+int cgroup_skb_egress(struct __sk_buff *skb)
+{
+	return account(skb, 1);
+}
+
+SEC("cgroup/skb/ingress")
+int cgroup_skb_ingress(struct __sk_buff *skb)
+{
+	return account(skb, 0);
+}
+
+char _license[] SEC("license") = "GPL";
+// this number will be interpreted by the elf loader
+// to set the current running kernel version
+u32 _version SEC("version") = 0xFFFFFFFE;
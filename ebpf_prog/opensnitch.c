@@ -75,29 +75,39 @@ struct sock_on_x86_32_t {
 
 
 // Add +1,+2,+3 etc. to map size helps to easier distinguish maps in bpftool's output
+// the maps of the connections are pinned to /sys/fs/bpf/opensnitch/globals/,
+// to keep them when the daemon is restarted.
 struct bpf_map_def SEC("maps/tcpMap") tcpMap = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(struct tcp_key_t),
 	.value_size = sizeof(struct tcp_value_t),
 	.max_entries = MAPSIZE+1,
+	.pinning = PIN_GLOBAL_NS,
+	.namespace = "opensnitch",
 };
 struct bpf_map_def SEC("maps/tcpv6Map") tcpv6Map = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(struct tcpv6_key_t),
 	.value_size = sizeof(struct tcpv6_value_t),
 	.max_entries = MAPSIZE+2,
+	.pinning = PIN_GLOBAL_NS,
+	.namespace = "opensnitch",
 };
 struct bpf_map_def SEC("maps/udpMap") udpMap = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(struct udp_key_t),
 	.value_size = sizeof(struct udp_value_t),
 	.max_entries = MAPSIZE+3,
+	.pinning = PIN_GLOBAL_NS,
+	.namespace = "opensnitch",
 };
 struct bpf_map_def SEC("maps/udpv6Map") udpv6Map = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(struct udpv6_key_t),
 	.value_size = sizeof(struct udpv6_value_t),
 	.max_entries = MAPSIZE+4,
+	.pinning = PIN_GLOBAL_NS,
+	.namespace = "opensnitch",
 };
 
 // for TCP the IP-tuple can be copied from "struct sock" only upon return from tcp_connect().
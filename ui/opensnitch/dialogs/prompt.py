@@ -317,6 +317,24 @@ class PromptDialog(QtWidgets.QDialog, uic.loadUiType(DIALOG_UI_PATH)[0]):
             self.argsLabel.setVisible(False)
             self.argsLabel.setText("")
 
+    def _get_app_ancestry(self, app_name, con):
+        """returns the chain of parents of the process: bash → make → curl,
+        and the container it runs in, if any"""
+        tooltip = app_name
+        if len(con.process_parents) > 0:
+            chain = [os.path.basename(p.path) if p.path != "" else p.comm for p in reversed(con.process_parents)]
+            chain.append(os.path.basename(con.process_path))
+            tooltip = "%s\n%s" % (tooltip, " → ".join(chain))
+        if con.HasField("process_container"):
+            container = con.process_container
+            tooltip = "%s\n%s %s (%s, %s)" % (
+                tooltip,
+                QC.translate("popups", "Container:"),
+                container.name if container.name != "" else container.id[:12],
+                container.image,
+                container.runtime)
+        return tooltip
+
     def _render_connection(self, con):
         app_name, app_icon, description, _ = self._apps_parser.get_info_by_path(con.process_path, "terminal")
         app_args = " ".join(con.process_args)
@@ -331,7 +349,7 @@ class PromptDialog(QtWidgets.QDialog, uic.loadUiType(DIALOG_UI_PATH)[0]):
             self.appNameLabel.setText(QC.translate("popups", "Outgoing connection"))
         else:
             self._set_elide_text(self.appNameLabel, "%s" % app_name, max_size=42)
-            self.appNameLabel.setToolTip(app_name)
+            self.appNameLabel.setToolTip(self._get_app_ancestry(app_name, con))
 
         self.cwdLabel.setToolTip("%s %s" % (QC.translate("popups", "Process launched from:"), con.process_cwd))
         self._set_elide_text(self.cwdLabel, con.process_cwd, max_size=32)
@@ -61,6 +61,7 @@ install -m 644 -b $B daemon/system-fw.json %{buildroot}/etc/opensnitchd/system-f
 
 install -m 644 ebpf_prog/opensnitch.o %{buildroot}/usr/lib/opensnitchd/ebpf/opensnitch.o
 install -m 644 ebpf_prog/opensnitch-dns.o %{buildroot}/usr/lib/opensnitchd/ebpf/opensnitch-dns.o
+install -m 644 ebpf_prog/opensnitch-traffic.o %{buildroot}/usr/lib/opensnitchd/ebpf/opensnitch-traffic.o
 install -m 644 ebpf_prog/opensnitch-procs.o %{buildroot}/usr/lib/opensnitchd/ebpf/opensnitch-procs.o
 
 # upgrade, uninstall
@@ -97,5 +98,6 @@ rm -rf %{buildroot}
 %{_sysconfdir}/opensnitchd/system-fw.json
 %{_prefix}/lib/opensnitchd/ebpf/opensnitch.o
 %{_prefix}/lib/opensnitchd/ebpf/opensnitch-dns.o
+%{_prefix}/lib/opensnitchd/ebpf/opensnitch-traffic.o
 %{_prefix}/lib/opensnitchd/ebpf/opensnitch-procs.o
 %{_sysconfdir}/logrotate.d/opensnitch